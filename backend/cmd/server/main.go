@@ -1,12 +1,53 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
 
 	"selfecho/backend/internal/app"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if len(os.Args) != 3 || os.Args[2] != "check" {
+			fmt.Fprintln(os.Stderr, "usage: selfecho config check")
+			os.Exit(2)
+		}
+		if err := app.CheckConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ai" {
+		if err := app.RunAI(os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "crypto" {
+		if err := app.RunCrypto(os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		if err := app.RunBackup(os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		if err := app.RunSearch(os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	if err := app.Run(); err != nil {
 		log.Fatalf("server exited with error: %v", err)
 	}
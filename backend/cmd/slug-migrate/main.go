@@ -1,20 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/csv"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
+	"selfecho/backend/internal/app"
 	"selfecho/backend/internal/slugmigrate"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -22,17 +22,9 @@ import (
 )
 
 type config struct {
-	Database dbConfig       `yaml:"database"`
-	Deepseek deepseekConfig `yaml:"deepseek"`
-}
-
-type dbConfig struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Name     string `yaml:"name"`
-	SSLMode  string `yaml:"sslmode"`
+	Database app.DBConfig                          `yaml:"database"`
+	Deepseek deepseekConfig                        `yaml:"deepseek"`
+	LLM      map[string]slugmigrate.ProviderConfig `yaml:"llm"`
 }
 
 type deepseekConfig struct {
@@ -47,11 +39,10 @@ type postRow struct {
 	Slug  string
 }
 
-type mapping struct {
-	ID      string
-	Title   string
-	OldSlug string
-	NewSlug string
+// result is one worker's answer for posts[index].
+type result struct {
+	suggestion slugmigrate.Suggestion
+	err        error
 }
 
 func main() {
@@ -65,6 +56,15 @@ func main() {
 		sleepBetween    time.Duration
 		continueOnError bool
 		skipIfUnchanged bool
+		providerName    string
+		rps             float64
+		fallback        bool
+		concurrency     int
+		resumeFrom      string
+		checkpointEvery int
+		redirectsFormat string
+		redirectsOut    string
+		redirectsPrefix string
 	)
 
 	flag.StringVar(&configPath, "config", "", "config.yaml path (or use CONFIG_PATH)")
@@ -72,13 +72,31 @@ func main() {
 	flag.IntVar(&limit, "limit", 0, "max posts to process, 0 means all")
 	flag.BoolVar(&apply, "apply", false, "apply updates to DB (default: dry-run)")
 	flag.StringVar(&outPath, "out", "", "write mapping CSV to path (default: stdout)")
-	flag.DurationVar(&requestTimeout, "timeout", 20*time.Second, "per-request timeout to DeepSeek")
-	flag.DurationVar(&sleepBetween, "sleep", 0, "sleep duration between DeepSeek calls (e.g. 200ms)")
-	flag.BoolVar(&continueOnError, "continue-on-error", false, "continue when a DeepSeek call fails")
+	flag.StringVar(&providerName, "provider", "deepseek", "LLM provider: deepseek, openai (any OpenAI-compatible API), ollama, or none for offline transliteration")
+	flag.BoolVar(&fallback, "fallback", true, "fall back to offline pinyin transliteration when the LLM fails or is not configured")
+	flag.Float64Var(&rps, "rps", 0, "max LLM requests per second, 0 means the provider default, negative disables limiting")
+	flag.DurationVar(&requestTimeout, "timeout", 20*time.Second, "per-request timeout to the LLM provider")
+	flag.DurationVar(&sleepBetween, "sleep", 0, "sleep duration between LLM calls (e.g. 200ms)")
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "continue when an LLM call fails")
 	flag.BoolVar(&skipIfUnchanged, "skip-unchanged", true, "skip updates when new slug equals old slug")
+	flag.IntVar(&concurrency, "concurrency", 1, "number of concurrent LLM requests (still bounded by --rps)")
+	flag.StringVar(&resumeFrom, "resume-from", "", "mapping CSV from a previous run; its IDs are skipped and its rows kept in the output")
+	flag.IntVar(&checkpointEvery, "checkpoint-every", 20, "rewrite --out after this many processed posts, 0 disables checkpoints")
+	flag.StringVar(&redirectsFormat, "redirects-format", "", "also write static redirect rules: nginx (map block) or redirects (_redirects file)")
+	flag.StringVar(&redirectsOut, "redirects-out", "", "path for --redirects-format output (default slug-redirects.conf or _redirects)")
+	flag.StringVar(&redirectsPrefix, "redirects-prefix", slugmigrate.DefaultPostPrefix, "public post path prefix, include the site base path if any")
 	flag.Parse()
 
-	ctx := context.Background()
+	if concurrency < 1 {
+		fatal(fmt.Errorf("--concurrency must be at least 1"))
+	}
+	redirectsFormat = strings.TrimSpace(redirectsFormat)
+	if redirectsFormat != "" && redirectsFormat != slugmigrate.RedirectsNginx && redirectsFormat != slugmigrate.RedirectsNetlify {
+		fatal(fmt.Errorf("--redirects-format must be %s or %s", slugmigrate.RedirectsNginx, slugmigrate.RedirectsNetlify))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	statusFilter = strings.TrimSpace(statusFilter)
 	if statusFilter != "" && statusFilter != "draft" && statusFilter != "published" {
@@ -93,11 +111,26 @@ func main() {
 	if err != nil {
 		fatal(err)
 	}
-	if env := strings.TrimSpace(os.Getenv("DEEPSEEK_API_KEY")); env != "" {
-		cfg.Deepseek.APIKey = env
-	}
-	if cfg.Deepseek.APIKey == "" {
-		fatal(fmt.Errorf("missing DeepSeek API key: set deepseek.apiKey in config or DEEPSEEK_API_KEY"))
+	providerCfg := slugmigrate.ResolveProviderConfig(providerName, cfg.LLM, slugmigrate.ProviderConfig{
+		BaseURL: cfg.Deepseek.BaseURL,
+		Model:   cfg.Deepseek.Model,
+		APIKey:  cfg.Deepseek.APIKey,
+	})
+	if rps != 0 {
+		providerCfg.RPS = rps
+	}
+	var client slugmigrate.Provider
+	if providerName != "none" {
+		client, err = slugmigrate.NewProvider(providerName, providerCfg, &http.Client{Timeout: requestTimeout})
+		if err != nil {
+			err = fmt.Errorf("%w: configure llm.%s in config or set %s_API_KEY", err, providerName, strings.ToUpper(providerName))
+			if !fallback {
+				fatal(err)
+			}
+			fmt.Fprintf(os.Stderr, "warn: %v; using offline transliteration\n", err)
+		}
+	} else if !fallback {
+		fatal(fmt.Errorf("--provider none requires --fallback"))
 	}
 
 	db, err := openDB(ctx, cfg.Database)
@@ -106,6 +139,12 @@ func main() {
 	}
 	defer db.Close()
 
+	if apply {
+		if _, err := db.ExecContext(ctx, slugmigrate.RedirectsSchema); err != nil {
+			fatal(fmt.Errorf("create slug_redirects: %w", err))
+		}
+	}
+
 	hasType, err := hasColumn(ctx, db, "articles", "type")
 	if err != nil {
 		fatal(err)
@@ -116,37 +155,65 @@ func main() {
 		fatal(err)
 	}
 
+	var mappings []slugmigrate.Mapping
+	done := make(map[string]bool)
+	if strings.TrimSpace(resumeFrom) != "" {
+		prev, err := readMappingFile(resumeFrom)
+		if err != nil {
+			fatal(fmt.Errorf("--resume-from: %w", err))
+		}
+		for _, m := range prev {
+			if done[m.ID] {
+				continue
+			}
+			done[m.ID] = true
+			mappings = append(mappings, m)
+			slugmigrate.ApplySlugChange(m.ID, m.OldSlug, m.NewSlug, used)
+		}
+		fmt.Fprintf(os.Stderr, "resume: %d posts already mapped in %s\n", len(done), resumeFrom)
+	}
+
 	posts, err := fetchPosts(ctx, db, hasType, statusFilter, limit)
 	if err != nil {
 		fatal(err)
 	}
-	if len(posts) == 0 {
+	pending := posts[:0]
+	for _, p := range posts {
+		if !done[p.ID] {
+			pending = append(pending, p)
+		}
+	}
+	posts = pending
+	if len(posts) == 0 && len(mappings) == 0 {
 		fmt.Println("no posts matched")
 		return
 	}
 
-	client := &deepseekClient{
-		baseURL: strings.TrimSuffix(strings.TrimSpace(cfg.Deepseek.BaseURL), "/"),
-		model:   strings.TrimSpace(cfg.Deepseek.Model),
-		apiKey:  cfg.Deepseek.APIKey,
-		httpClient: &http.Client{
-			Timeout: requestTimeout,
-		},
-	}
-	if client.baseURL == "" {
-		client.baseURL = "https://api.deepseek.com"
-	}
-	if client.model == "" {
-		client.model = "deepseek-chat"
-	}
+	genCtx, cancelGen := context.WithCancel(ctx)
+	results := generateSlugs(genCtx, client, posts, fallback, concurrency, sleepBetween)
 
-	var mappings []mapping
 	var updated int
 	var skipped int
 	var failures int
+	var fallbacks int
 
 	for i, p := range posts {
-		newSlug, err := client.generateSlug(ctx, p.Title)
+		if checkpointEvery > 0 && i > 0 && i%checkpointEvery == 0 && strings.TrimSpace(outPath) != "" {
+			if err := writeMappingFile(outPath, mappings); err != nil {
+				fmt.Fprintf(os.Stderr, "warn: checkpoint failed: %v\n", err)
+			}
+		}
+		var r result
+		select {
+		case r = <-results[i]:
+		case <-ctx.Done():
+			r.err = ctx.Err()
+		}
+		if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "interrupted at %d/%d; rerun with --resume-from to continue\n", i+1, len(posts))
+			break
+		}
+		suggestion, err := r.suggestion, r.err
 		if err != nil {
 			failures++
 			fmt.Fprintf(os.Stderr, "fail %d/%d id=%s title=%q: %v\n", i+1, len(posts), p.ID, p.Title, err)
@@ -155,8 +222,12 @@ func main() {
 			}
 			continue
 		}
+		if suggestion.LLMErr != nil {
+			fallbacks++
+			fmt.Fprintf(os.Stderr, "fallback %d/%d id=%s title=%q: %v\n", i+1, len(posts), p.ID, p.Title, suggestion.LLMErr)
+		}
 
-		newSlug = slugmigrate.EnsureUniqueSlug(newSlug, p.ID, used)
+		newSlug := slugmigrate.EnsureUniqueSlug(suggestion.Slug, p.ID, used)
 		if newSlug == "" {
 			failures++
 			fmt.Fprintf(os.Stderr, "fail %d/%d id=%s title=%q: empty slug\n", i+1, len(posts), p.ID, p.Title)
@@ -171,17 +242,18 @@ func main() {
 			continue
 		}
 
-		mappings = append(mappings, mapping{
+		mappings = append(mappings, slugmigrate.Mapping{
 			ID:      p.ID,
 			Title:   p.Title,
 			OldSlug: p.Slug,
 			NewSlug: newSlug,
+			Method:  suggestion.Method,
 		})
 
 		slugmigrate.ApplySlugChange(p.ID, p.Slug, newSlug, used)
 
 		if apply {
-			if err := updateSlug(ctx, db, p.ID, newSlug); err != nil {
+			if err := updateSlug(ctx, db, p.ID, p.Slug, newSlug); err != nil {
 				failures++
 				fmt.Fprintf(os.Stderr, "fail update %d/%d id=%s: %v\n", i+1, len(posts), p.ID, err)
 				if !continueOnError {
@@ -191,24 +263,27 @@ func main() {
 			}
 			updated++
 		}
-
-		if sleepBetween > 0 {
-			time.Sleep(sleepBetween)
-		}
 	}
 
-	if err := writeMappingCSV(outPath, mappings); err != nil {
+	cancelGen()
+
+	if err := writeMappingFile(outPath, mappings); err != nil {
 		fatal(err)
 	}
+	if redirectsFormat != "" {
+		if err := writeRedirectsFile(redirectsOut, redirectsFormat, redirectsPrefix, mappings); err != nil {
+			fatal(err)
+		}
+	}
 
 	summaryOut := io.Writer(os.Stdout)
 	if strings.TrimSpace(outPath) == "" {
 		summaryOut = os.Stderr
 	}
 	if apply {
-		fmt.Fprintf(summaryOut, "done: updated=%d skipped=%d failed=%d\n", updated, skipped, failures)
+		fmt.Fprintf(summaryOut, "done: updated=%d skipped=%d failed=%d fallback=%d\n", updated, skipped, failures, fallbacks)
 	} else {
-		fmt.Fprintf(summaryOut, "dry-run: would-update=%d skipped=%d failed=%d (use --apply to write DB)\n", len(mappings), skipped, failures)
+		fmt.Fprintf(summaryOut, "dry-run: would-update=%d skipped=%d failed=%d fallback=%d (use --apply to write DB)\n", len(mappings), skipped, failures, fallbacks)
 	}
 }
 
@@ -245,14 +320,13 @@ func loadConfig(path string) (config, error) {
 	return cfg, nil
 }
 
-func openDB(ctx context.Context, cfg dbConfig) (*sql.DB, error) {
-	sslmode := cfg.SSLMode
-	if sslmode == "" {
-		sslmode = "disable"
+// openDB connects like the server does, so database.url and
+// database.options apply here too.
+func openDB(ctx context.Context, cfg app.DBConfig) (*sql.DB, error) {
+	dsn, err := app.BuildDSN(cfg)
+	if err != nil {
+		return nil, err
 	}
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, sslmode)
-
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
@@ -347,108 +421,110 @@ func fetchPosts(ctx context.Context, db *sql.DB, hasType bool, status string, li
 	return items, nil
 }
 
-func updateSlug(ctx context.Context, db *sql.DB, id, slug string) error {
-	_, err := db.ExecContext(ctx, `UPDATE articles SET slug=$1, updated_at=now() WHERE id=$2`, slug, id)
-	return err
-}
-
-func writeMappingCSV(outPath string, items []mapping) error {
-	var w io.Writer = os.Stdout
-	var file *os.File
-	if strings.TrimSpace(outPath) != "" {
-		f, err := os.Create(outPath)
-		if err != nil {
-			return err
-		}
-		file = f
-		defer file.Close()
-		w = f
+// updateSlug renames the article and records the old slug in slug_redirects
+// so the server keeps answering old links with a 301.
+func updateSlug(ctx context.Context, db *sql.DB, id, oldSlug, newSlug string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	cw := csv.NewWriter(w)
-	if err := cw.Write([]string{"id", "title", "old_slug", "new_slug"}); err != nil {
+	if _, err := tx.ExecContext(ctx, `UPDATE articles SET slug=$1, updated_at=now() WHERE id=$2`, newSlug, id); err != nil {
+		return err
+	}
+	// the new slug is live now, so it must not redirect anywhere else
+	if _, err := tx.ExecContext(ctx, `DELETE FROM slug_redirects WHERE old_slug=$1`, newSlug); err != nil {
 		return err
 	}
-	for _, it := range items {
-		if err := cw.Write([]string{it.ID, it.Title, it.OldSlug, it.NewSlug}); err != nil {
+	if oldSlug != "" && oldSlug != newSlug {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO slug_redirects (old_slug, article_id) VALUES ($1, $2)
+			ON CONFLICT (old_slug) DO UPDATE SET article_id=EXCLUDED.article_id, created_at=now()`, oldSlug, id); err != nil {
 			return err
 		}
 	}
-	cw.Flush()
-	return cw.Error()
+	return tx.Commit()
 }
 
-type deepseekClient struct {
-	baseURL    string
-	model      string
-	apiKey     string
-	httpClient *http.Client
+// generateSlugs asks the provider for every post using a pool of workers.
+// Each post gets its own buffered channel so the caller can consume results in
+// order (keeping deduplication deterministic) while later posts are in flight.
+func generateSlugs(ctx context.Context, client slugmigrate.Provider, posts []postRow, fallback bool, workers int, sleepBetween time.Duration) []chan result {
+	results := make([]chan result, len(posts))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range posts {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				suggestion, err := slugmigrate.Suggest(ctx, client, posts[i].Title, fallback)
+				results[i] <- result{suggestion: suggestion, err: err}
+				if sleepBetween > 0 && suggestion.Method != slugmigrate.MethodTransliteration {
+					time.Sleep(sleepBetween)
+				}
+			}
+		}()
+	}
+	return results
 }
 
-func (c *deepseekClient) generateSlug(ctx context.Context, title string) (string, error) {
-	title = strings.TrimSpace(title)
-	if title == "" {
-		return "", fmt.Errorf("empty title")
-	}
-
-	payload := map[string]any{
-		"model": c.model,
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": "将我下面给你的中文标题转换为SEO友好的英文slug格式。输出要求：全小写、用连字符连接、简洁明了。仅输出slug本身。",
-			},
-			{
-				"role":    "user",
-				"content": title,
-			},
-		},
-		"stream": false,
-	}
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
+func writeRedirectsFile(path, format, prefix string, items []slugmigrate.Mapping) error {
+	if strings.TrimSpace(path) == "" {
+		path = "slug-redirects.conf"
+		if format == slugmigrate.RedirectsNetlify {
+			path = "_redirects"
+		}
 	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(body))
+	f, err := os.Create(path)
 	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-
-	client := c.httpClient
-	if client == nil {
-		client = http.DefaultClient
+		return err
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+	if err := slugmigrate.WriteRedirects(f, format, prefix, items); err != nil {
+		f.Close()
+		return err
 	}
-	defer resp.Body.Close()
+	fmt.Fprintf(os.Stderr, "redirects: wrote %s\n", path)
+	return f.Close()
+}
 
-	if resp.StatusCode >= 300 {
-		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		return "", fmt.Errorf("deepseek http %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+func readMappingFile(path string) ([]slugmigrate.Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return slugmigrate.ReadMappingCSV(f)
+}
 
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+// writeMappingFile writes the CSV to stdout, or replaces outPath atomically so
+// an interrupted checkpoint never leaves a truncated file to resume from.
+func writeMappingFile(outPath string, items []slugmigrate.Mapping) error {
+	if strings.TrimSpace(outPath) == "" {
+		return slugmigrate.WriteMappingCSV(os.Stdout, items)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	tmp, err := os.CreateTemp(filepath.Dir(outPath), filepath.Base(outPath)+".*.tmp")
+	if err != nil {
+		return err
 	}
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("deepseek returned empty choices")
+	defer os.Remove(tmp.Name())
+	if err := slugmigrate.WriteMappingCSV(tmp, items); err != nil {
+		tmp.Close()
+		return err
 	}
-
-	out := slugmigrate.NormalizeLLMOutputToSlug(result.Choices[0].Message.Content)
-	if out == "" {
-		return "", fmt.Errorf("empty slug after normalization")
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	return out, nil
+	return os.Rename(tmp.Name(), outPath)
 }
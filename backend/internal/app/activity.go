@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activityRetention bounds how long bus events are kept for the admin feed.
+const activityRetention = `interval '90 days'`
+
+func (s *server) ensureActivitySchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS activity_log (
+			id BIGSERIAL PRIMARY KEY,
+			kind TEXT NOT NULL,
+			action TEXT NOT NULL,
+			entity_id TEXT NOT NULL DEFAULT '',
+			slug TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL DEFAULT '',
+			at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		ALTER TABLE activity_log ADD COLUMN IF NOT EXISTS actor TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_activity_log_at ON activity_log(at DESC, id DESC);
+		DELETE FROM activity_log WHERE at < now() - `+activityRetention+`;
+	`)
+	return err
+}
+
+// recordActivity persists a bus event for the admin feed. Progress ticks and
+// reindex starts are skipped: the feed is about outcomes, not heartbeats.
+func (s *server) recordActivity(ev changeEvent) {
+	if ev.Action == actionProgress || ev.Action == actionStarted {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := s.db.ExecContext(ctx, `
+			INSERT INTO activity_log (kind, action, entity_id, slug, message, actor, at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			string(ev.Kind), string(ev.Action), ev.ID, ev.Slug, ev.Message, ev.Actor, ev.At)
+		if err != nil {
+			warnf("记录活动 %s 失败: %v\n", ev.Kind, err)
+		}
+	}()
+}
+
+type activityEntry struct {
+	Kind    string    `json:"kind"`
+	Action  string    `json:"action"`
+	ID      string    `json:"id,omitempty"`
+	Slug    string    `json:"slug,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Actor   string    `json:"actor,omitempty"`
+	At      time.Time `json:"at"`
+	// key breaks ties between entries sharing a timestamp.
+	key string
+}
+
+// activityCursor is the opaque position of the last entry on a page.
+type activityCursor struct {
+	at  time.Time
+	key string
+}
+
+func (cur activityCursor) encode() string {
+	raw := strconv.FormatInt(cur.at.UnixMicro(), 10) + "|" + cur.key
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseActivityCursor(s string) (activityCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return activityCursor{}, false
+	}
+	micros, key, ok := strings.Cut(string(raw), "|")
+	if !ok || key == "" {
+		return activityCursor{}, false
+	}
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return activityCursor{}, false
+	}
+	return activityCursor{at: time.UnixMicro(n), key: key}, true
+}
+
+// adminActivity merges recorded bus events (article edits, settings changes,
+// imap sync results, reindexes) with article publishes into one
+// reverse-chronological feed, paged by ?cursor= and ?limit=.
+func (s *server) adminActivity(c *gin.Context) {
+	limit := 30
+	if l, err := strconv.Atoi(strings.TrimSpace(c.Query("limit"))); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	before := activityCursor{at: time.Now().Add(time.Hour), key: "~"}
+	if raw := c.Query("cursor"); raw != "" {
+		cur, ok := parseActivityCursor(raw)
+		if !ok {
+			respondError(c, http.StatusBadRequest, errInvalidCursor)
+			return
+		}
+		before = cur
+	}
+
+	rows, err := s.readQuery(c.Request.Context(), `
+		SELECT kind, action, entity_id, slug, title, message, actor, at, key FROM (
+			SELECT l.kind, l.action, l.entity_id, l.slug, COALESCE(a.title, '') AS title, l.message, l.actor, l.at,
+			       'l' || lpad(l.id::text, 20, '0') AS key
+			FROM activity_log l
+			LEFT JOIN articles a ON a.id = CASE WHEN l.kind = 'article.changed' THEN NULLIF(l.entity_id, '')::uuid END
+			UNION ALL
+			SELECT 'article.published', 'published', a.id::text, a.slug, a.title, '', '', a.published_at,
+			       'p' || a.id::text
+			FROM articles a
+			WHERE a.status = 'published' AND a.published_at IS NOT NULL
+		) feed
+		WHERE (at, key) < ($1, $2)
+		ORDER BY at DESC, key DESC
+		LIMIT $3`, before.at, before.key, limit+1)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryActivityFailed)
+		return
+	}
+	defer rows.Close()
+	loc := s.siteLocation()
+	items := []activityEntry{}
+	for rows.Next() {
+		var e activityEntry
+		if err := rows.Scan(&e.Kind, &e.Action, &e.ID, &e.Slug, &e.Title, &e.Message, &e.Actor, &e.At, &e.key); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryActivityFailed)
+			return
+		}
+		e.At = e.At.In(loc)
+		items = append(items, e)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryActivityFailed)
+		return
+	}
+
+	var next string
+	if len(items) > limit {
+		items = items[:limit]
+		last := items[len(items)-1]
+		next = activityCursor{at: last.At, key: last.key}.encode()
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "nextCursor": next})
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActivityCursorRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+	cur := activityCursor{at: at, key: "p3f1c2"}
+	got, ok := parseActivityCursor(cur.encode())
+	if !ok || !got.at.Equal(at) || got.key != cur.key {
+		t.Fatalf("round trip = %+v, %v", got, ok)
+	}
+	for _, bad := range []string{"", "%%%", "bm9waXBl", "MTIzfA"} {
+		if _, ok := parseActivityCursor(bad); ok {
+			t.Errorf("parseActivityCursor(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAccessConfig restricts the admin API, the login endpoint and the
+// admin pages to known networks and/or client certificates, on top of the
+// login itself.
+type adminAccessConfig struct {
+	// AllowCIDRs lists where admin requests may come from; empty allows any
+	// address. The client address honours trustedProxies.
+	AllowCIDRs []string `yaml:"allowCIDRs"`
+	// ClientCA is a PEM bundle; admin requests must then present a
+	// certificate signed by it. selfecho has to terminate TLS itself (see
+	// tlsConfig) unless a proxy does the check, see ClientCertHeader.
+	ClientCA string `yaml:"clientCA"`
+	// ClientCertHeader is the header a trusted TLS-terminating proxy sets to
+	// SUCCESS once it verified the client certificate, e.g.
+	// X-SSL-Client-Verify from nginx's $ssl_client_verify.
+	ClientCertHeader string `yaml:"clientCertHeader"`
+}
+
+// tlsConfig makes selfecho serve HTTPS itself, which client certificate
+// checks without a proxy need.
+type tlsConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+var errClientCANeedsTLS = errors.New("admin.clientCA 需要 tls.certFile/keyFile 或 admin.clientCertHeader")
+
+type adminGuard struct {
+	allow      []netip.Prefix
+	requireTLS bool
+	certHeader string
+}
+
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("admin.clientCA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("admin.clientCA: %s 中没有 PEM 证书", path)
+	}
+	return pool, nil
+}
+
+// newAdminGuard returns nil when no restriction is configured.
+func newAdminGuard(cfg config) (*adminGuard, error) {
+	ac := cfg.Admin
+	allow, err := parsePrefixes("admin.allowCIDRs", ac.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	g := &adminGuard{allow: allow, certHeader: strings.TrimSpace(ac.ClientCertHeader)}
+	if ac.ClientCA != "" {
+		if cfg.TLS.CertFile == "" && g.certHeader == "" {
+			return nil, errClientCANeedsTLS
+		}
+		if _, err := loadClientCAs(ac.ClientCA); err != nil {
+			return nil, err
+		}
+		g.requireTLS = cfg.TLS.CertFile != ""
+	}
+	if len(g.allow) == 0 && !g.requireTLS && g.certHeader == "" {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// serverTLSConfig is the listener's TLS setup, or nil to serve plain HTTP.
+// Client certificates are requested but optional at the handshake so the
+// public site stays reachable; adminGuard insists on them.
+func serverTLSConfig(cfg config) (*tls.Config, error) {
+	if cfg.TLS.CertFile == "" && cfg.TLS.KeyFile == "" {
+		return nil, nil
+	}
+	if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+		return nil, errors.New("tls.certFile 与 tls.keyFile 需同时设置")
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.Admin.ClientCA != "" {
+		pool, err := loadClientCAs(cfg.Admin.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
+
+func (g *adminGuard) allows(s *server, c *gin.Context) bool {
+	if g == nil {
+		return true
+	}
+	if len(g.allow) > 0 && !prefixesContain(g.allow, c.ClientIP()) {
+		return false
+	}
+	if !g.requireTLS && g.certHeader == "" {
+		return true
+	}
+	r := c.Request
+	if g.requireTLS && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	return g.certHeader != "" && s.trustForwarded(r) && strings.EqualFold(r.Header.Get(g.certHeader), "SUCCESS")
+}
+
+// adminAccessMiddleware guards the protected API group and the login.
+func (s *server) adminAccessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.admin.allows(s, c) {
+			s.security.blockIP(c.ClientIP(), time.Now())
+			respondError(c, http.StatusForbidden, errAdminAccessDenied)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// adminPagesMiddleware applies the same guard to the admin SPA routes
+// (/admin and below, /login), which are otherwise served to anyone.
+func (s *server) adminPagesMiddleware() gin.HandlerFunc {
+	admin, login := s.basePath+"/admin", s.basePath+"/login"
+	return func(c *gin.Context) {
+		p := c.Request.URL.Path
+		if p == admin || strings.HasPrefix(p, admin+"/") || p == login {
+			if !s.admin.allows(s, c) {
+				s.security.blockIP(c.ClientIP(), time.Now())
+				c.String(http.StatusForbidden, "403 Forbidden")
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminGuard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := defaultConfig()
+	cfg.Admin.AllowCIDRs = []string{"10.0.0.0/8", "2001:db8::/32"}
+	cfg.Admin.ClientCertHeader = "X-SSL-Client-Verify"
+	guard, err := newAdminGuard(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies, _ := parseTrustedProxies([]string{"10.0.0.1"})
+	s := &server{admin: guard, proxies: proxies}
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(proxies.strings()); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(s.adminPagesMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/", ok)
+	r.GET("/admin/posts", ok)
+	r.GET("/administrivia", ok)
+	r.GET("/api/settings", s.adminAccessMiddleware(), ok)
+
+	do := func(path, remote, verify string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		if verify != "" {
+			req.Header.Set("X-SSL-Client-Verify", verify)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	cases := []struct {
+		path, remote, verify string
+		want                 int
+	}{
+		{"/", "203.0.113.5:1234", "", http.StatusOK},
+		{"/administrivia", "203.0.113.5:1234", "", http.StatusOK},
+		{"/admin/posts", "203.0.113.5:1234", "", http.StatusForbidden},
+		{"/api/settings", "203.0.113.5:1234", "", http.StatusForbidden},
+		// inside the allowlist but no certificate verdict from the proxy
+		{"/api/settings", "10.0.0.1:1234", "", http.StatusForbidden},
+		{"/api/settings", "10.0.0.1:1234", "FAILED:unknown ca", http.StatusForbidden},
+		{"/api/settings", "10.0.0.1:1234", "SUCCESS", http.StatusOK},
+		{"/admin/posts", "10.0.0.1:1234", "SUCCESS", http.StatusOK},
+		// the header only counts when the trusted proxy sent it
+		{"/api/settings", "10.9.9.9:1234", "SUCCESS", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		if got := do(tc.path, tc.remote, tc.verify); got != tc.want {
+			t.Errorf("%s from %s (%q) = %d, want %d", tc.path, tc.remote, tc.verify, got, tc.want)
+		}
+	}
+}
+
+func TestAdminGuardConfig(t *testing.T) {
+	cfg := defaultConfig()
+	if g, err := newAdminGuard(cfg); g != nil || err != nil {
+		t.Fatalf("no restriction configured: got %v, %v", g, err)
+	}
+	cfg.Admin.AllowCIDRs = []string{"not-a-cidr"}
+	if _, err := newAdminGuard(cfg); err == nil {
+		t.Fatal("a bad CIDR should be rejected")
+	}
+	cfg.Admin.AllowCIDRs = nil
+	cfg.Admin.ClientCA = "/nonexistent/ca.pem"
+	if _, err := newAdminGuard(cfg); !errors.Is(err, errClientCANeedsTLS) {
+		t.Fatalf("clientCA without TLS: err = %v", err)
+	}
+}
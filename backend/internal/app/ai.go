@@ -0,0 +1,595 @@
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/slugmigrate"
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type summaryProposal struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Summary  string `json:"summary"`
+	Previous string `json:"previous"`
+	Provider string `json:"provider,omitempty"`
+	Applied  bool   `json:"applied"`
+}
+
+type articleText struct {
+	ID          string
+	Title       string
+	BodyMD      string
+	Description string
+	Tags        tagList
+	// Sealed is set when the body is stored encrypted (see openText).
+	Sealed bool
+}
+
+// openText decrypts a body loaded for the AI helpers and the search index.
+func (s *server) openText(a *articleText) error {
+	a.Sealed = isSealed(a.BodyMD)
+	var err error
+	a.BodyMD, err = s.content.open(a.BodyMD)
+	return err
+}
+
+func (s *server) loadArticleText(ctx context.Context, id string) (articleText, bool, error) {
+	var a articleText
+	if !store.ValidID(id) {
+		return a, false, nil
+	}
+	err := s.db.QueryRowContext(ctx, `SELECT id, title, body_md, meta_description, to_json(tags)::text FROM articles WHERE id=$1`, id).
+		Scan(&a.ID, &a.Title, &a.BodyMD, &a.Description, &a.Tags)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			return articleText{}, false, nil
+		}
+		return articleText{}, false, err
+	}
+	if err := s.openText(&a); err != nil {
+		return articleText{}, false, err
+	}
+	return a, true, nil
+}
+
+func (s *server) saveDescription(ctx context.Context, id, desc string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE articles SET meta_description=$1, updated_at=now() WHERE id=$2`, desc, id)
+	return err
+}
+
+// aiSummary backs POST /api/articles/:id/ai/summary. By default it only
+// proposes a meta description; {"apply": true} stores the proposal, and
+// {"apply": true, "summary": "..."} stores the editor's approved text without
+// calling the LLM again.
+func (s *server) aiSummary(c *gin.Context) {
+	var payload struct {
+		Apply   bool    `json:"apply"`
+		Summary *string `json:"summary"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&payload); err != nil {
+			respondError(c, http.StatusBadRequest, errInvalidBody)
+			return
+		}
+	}
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok || !s.checkArticleAuthor(c, id) {
+		return
+	}
+	a, ok, err := s.loadArticleText(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+
+	out := summaryProposal{ID: a.ID, Title: a.Title, Previous: a.Description}
+	if payload.Apply && payload.Summary != nil {
+		out.Summary = slugmigrate.CleanSummary(*payload.Summary)
+	} else {
+		if s.slugLLM == nil {
+			respondError(c, http.StatusServiceUnavailable, errAIUnavailable)
+			return
+		}
+		summary, err := slugmigrate.Summarize(ctx, s.slugLLM, a.Title, a.BodyMD)
+		if err != nil {
+			respondErrorDetail(c, http.StatusBadGateway, errAISummaryFailed, err)
+			return
+		}
+		out.Summary = summary
+		out.Provider = s.slugLLM.Name()
+	}
+
+	if payload.Apply {
+		if err := s.saveDescription(ctx, a.ID, out.Summary); err != nil {
+			respondError(c, http.StatusInternalServerError, errUpdateArticleFailed)
+			return
+		}
+		out.Applied = true
+		s.refreshSearchIndex(a.ID)
+		s.publishFrom(c, eventArticleChanged, actionUpdated, a.ID, "")
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// RunAI implements `selfecho ai <task>` for batch jobs against the configured
+// database and LLM provider.
+func RunAI(w io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: selfecho ai summary|tags [flags]")
+	}
+	switch args[0] {
+	case "summary":
+		return runSummaryBatch(w, args[1:])
+	case "tags":
+		return runTagBatch(w, args[1:])
+	default:
+		return fmt.Errorf("未知的 ai 子命令: %s", args[0])
+	}
+}
+
+// openCLIServer connects to the database for one-off commands and makes sure
+// the columns they touch exist.
+func openCLIServer(ctx context.Context) (*server, config, error) {
+	cfg, err := loadConfig(resolveConfigPath())
+	if err != nil {
+		return nil, cfg, err
+	}
+	db, err := ensureDB(ctx, cfg.Database)
+	if err != nil {
+		return nil, cfg, err
+	}
+	s := &server{db: db, encryption: cfg.Encryption}
+	if err := s.ensureArticleSchema(ctx); err != nil {
+		db.Close()
+		return nil, cfg, err
+	}
+	if err := s.ensureSettingsSchema(ctx); err != nil {
+		db.Close()
+		return nil, cfg, err
+	}
+	if err := s.ensureContentKey(ctx); err != nil {
+		db.Close()
+		return nil, cfg, err
+	}
+	return s, cfg, nil
+}
+
+func runSummaryBatch(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("ai summary", flag.ContinueOnError)
+	fs.SetOutput(w)
+	limit := fs.Int("limit", 0, "max articles to summarize, 0 means all")
+	overwrite := fs.Bool("overwrite", false, "also regenerate articles that already have a description")
+	apply := fs.Bool("apply", false, "store generated descriptions immediately (default: print proposals as CSV)")
+	approve := fs.String("approve", "", "store the summary column of a reviewed proposal CSV without calling the LLM")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	s, cfg, err := openCLIServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.db.Close()
+
+	if *approve != "" {
+		return applySummaryCSV(ctx, w, s, *approve)
+	}
+
+	s.slugLLM = newSlugProvider(cfg, &http.Client{Timeout: 60 * time.Second})
+	if s.slugLLM == nil {
+		return errors.New("未配置可用的 LLM 服务 (slug.provider / llm)")
+	}
+
+	query := `SELECT id, title, body_md, meta_description, to_json(tags)::text FROM articles`
+	if !*overwrite {
+		query += ` WHERE meta_description = ''`
+	}
+	query += ` ORDER BY created_at ASC`
+	var queryArgs []any
+	if *limit > 0 {
+		query += ` LIMIT $1`
+		queryArgs = append(queryArgs, *limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	var items []articleText
+	for rows.Next() {
+		var a articleText
+		if err := rows.Scan(&a.ID, &a.Title, &a.BodyMD, &a.Description, &a.Tags); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := s.openText(&a); err != nil {
+			rows.Close()
+			return fmt.Errorf("%s: %w", a.ID, err)
+		}
+		items = append(items, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if !*apply {
+		cw.Write([]string{"id", "title", "previous", "summary"})
+	}
+	var failed int
+	for i, a := range items {
+		summary, err := slugmigrate.Summarize(ctx, s.slugLLM, a.Title, a.BodyMD)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "fail %d/%d id=%s: %v\n", i+1, len(items), a.ID, err)
+			continue
+		}
+		if *apply {
+			if err := s.saveDescription(ctx, a.ID, summary); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.ID, a.Title, summary)
+			continue
+		}
+		cw.Write([]string{a.ID, a.Title, a.Description, summary})
+		cw.Flush()
+	}
+	cw.Flush()
+	fmt.Fprintf(os.Stderr, "summaries: %d ok, %d failed\n", len(items)-failed, failed)
+	return cw.Error()
+}
+
+// applySummaryCSV stores the (possibly hand-edited) summary column of a file
+// produced by a dry run. Rows with an empty summary are skipped.
+func applySummaryCSV(ctx context.Context, w io.Writer, s *server, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	col := map[string]int{}
+	for i, name := range records[0] {
+		col[strings.TrimSpace(name)] = i
+	}
+	idCol, ok1 := col["id"]
+	sumCol, ok2 := col["summary"]
+	if !ok1 || !ok2 {
+		return errors.New("CSV 需要 id 和 summary 列")
+	}
+	var applied int
+	for _, rec := range records[1:] {
+		if idCol >= len(rec) || sumCol >= len(rec) {
+			continue
+		}
+		summary := slugmigrate.CleanSummary(rec[sumCol])
+		if summary == "" {
+			continue
+		}
+		if err := s.saveDescription(ctx, rec[idCol], summary); err != nil {
+			return err
+		}
+		applied++
+	}
+	fmt.Fprintf(w, "applied %d summaries\n", applied)
+	return nil
+}
+
+type tagProposal struct {
+	ID             string         `json:"id"`
+	Title          string         `json:"title"`
+	Current        []string       `json:"current"`
+	Candidates     []tagCandidate `json:"candidates"`
+	Source         string         `json:"source"`
+	Provider       string         `json:"provider,omitempty"`
+	FallbackReason string         `json:"fallbackReason,omitempty"`
+	Applied        bool           `json:"applied"`
+}
+
+// buildKeywordIndex reads every article once for document frequencies and
+// the tags already in use.
+func (s *server) buildKeywordIndex(ctx context.Context) (*keywordIndex, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT title, body_md, to_json(tags)::text FROM articles`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ix := newKeywordIndex()
+	for rows.Next() {
+		var title, body string
+		var tags tagList
+		if err := rows.Scan(&title, &body, &tags); err != nil {
+			return nil, err
+		}
+		if body, err = s.content.open(body); err != nil {
+			body = ""
+		}
+		ix.add(title, body, tags)
+	}
+	return ix, rows.Err()
+}
+
+// proposeTags fills p.Candidates using mode "llm", "keywords", or "" (the
+// LLM when configured, keywords otherwise or when it fails).
+func (s *server) proposeTags(ctx context.Context, ix *keywordIndex, a articleText, mode string, limit int, p *tagProposal) error {
+	if mode != "keywords" && s.slugLLM != nil {
+		tags, err := slugmigrate.SuggestTags(ctx, s.slugLLM, a.Title, a.BodyMD, limit)
+		if err == nil {
+			have := make(map[string]bool)
+			for _, t := range a.Tags {
+				have[normalizeTag(t)] = true
+			}
+			for _, t := range normalizeTags(tags) {
+				if !have[t] {
+					p.Candidates = append(p.Candidates, tagCandidate{Tag: t, Existing: ix.tags[t] > 0})
+				}
+			}
+			p.Source = "llm"
+			p.Provider = s.slugLLM.Name()
+			return nil
+		}
+		if mode == "llm" {
+			return err
+		}
+		p.FallbackReason = err.Error()
+	} else if mode == "llm" {
+		return errors.New("no LLM provider configured")
+	}
+	p.Candidates = ix.suggest(a.Title, a.BodyMD, a.Tags, limit)
+	p.Source = "keywords"
+	return nil
+}
+
+func (s *server) saveTags(ctx context.Context, id string, tags []string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE articles SET tags=$1, updated_at=now() WHERE id=$2`, normalizeTags(tags), id)
+	return err
+}
+
+// aiTags backs POST /api/articles/:id/ai/tags. It proposes candidates the
+// editor can pick from; {"apply": true, "tags": [...]} adds the accepted ones
+// and {"apply": true} alone adds every candidate.
+func (s *server) aiTags(c *gin.Context) {
+	var payload struct {
+		Mode  string    `json:"mode"`
+		Limit int       `json:"limit"`
+		Apply bool      `json:"apply"`
+		Tags  *[]string `json:"tags"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&payload); err != nil {
+			respondError(c, http.StatusBadRequest, errInvalidBody)
+			return
+		}
+	}
+	if payload.Mode != "" && payload.Mode != "llm" && payload.Mode != "keywords" {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if payload.Limit <= 0 || payload.Limit > maxArticleTags {
+		payload.Limit = defaultTagSuggestions
+	}
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok || !s.checkArticleAuthor(c, id) {
+		return
+	}
+	a, ok, err := s.loadArticleText(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+
+	out := tagProposal{ID: a.ID, Title: a.Title, Current: normalizeTags(a.Tags), Candidates: []tagCandidate{}}
+	accepted := []string{}
+	if payload.Apply && payload.Tags != nil {
+		accepted = normalizeTags(*payload.Tags)
+		out.Source = "editor"
+	} else {
+		if payload.Mode == "llm" && s.slugLLM == nil {
+			respondError(c, http.StatusServiceUnavailable, errAIUnavailable)
+			return
+		}
+		ix, err := s.buildKeywordIndex(ctx)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+			return
+		}
+		if err := s.proposeTags(ctx, ix, a, payload.Mode, payload.Limit, &out); err != nil {
+			respondErrorDetail(c, http.StatusBadGateway, errAITagsFailed, err)
+			return
+		}
+		for _, cand := range out.Candidates {
+			accepted = append(accepted, cand.Tag)
+		}
+	}
+
+	if payload.Apply {
+		merged := normalizeTags(append(out.Current, accepted...))
+		if err := s.saveTags(ctx, a.ID, merged); err != nil {
+			respondError(c, http.StatusInternalServerError, errUpdateArticleFailed)
+			return
+		}
+		out.Current = merged
+		out.Applied = true
+		s.refreshSearchIndex(a.ID)
+		s.publishFrom(c, eventArticleChanged, actionUpdated, a.ID, "")
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// runTagBatch back-tags old posts. Without --apply it prints a CSV of
+// proposals whose tags column can be edited and fed back via --approve.
+func runTagBatch(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("ai tags", flag.ContinueOnError)
+	fs.SetOutput(w)
+	limit := fs.Int("limit", 0, "max articles to process, 0 means all")
+	perArticle := fs.Int("max", 5, "max tags suggested per article")
+	mode := fs.String("mode", "", "llm, keywords (offline TF-IDF), or empty for llm with keyword fallback")
+	all := fs.Bool("all", false, "also process articles that already have tags")
+	apply := fs.Bool("apply", false, "add suggested tags immediately (default: print proposals as CSV)")
+	approve := fs.String("approve", "", "add the tags column of a reviewed proposal CSV")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *mode != "" && *mode != "llm" && *mode != "keywords" {
+		return fmt.Errorf("--mode must be llm or keywords")
+	}
+
+	ctx := context.Background()
+	s, cfg, err := openCLIServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.db.Close()
+
+	if *approve != "" {
+		return applyTagCSV(ctx, w, s, *approve)
+	}
+	if *mode != "keywords" {
+		s.slugLLM = newSlugProvider(cfg, &http.Client{Timeout: 60 * time.Second})
+	}
+	ix, err := s.buildKeywordIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT id, title, body_md, meta_description, to_json(tags)::text FROM articles`
+	if !*all {
+		query += ` WHERE cardinality(tags) = 0`
+	}
+	query += ` ORDER BY created_at ASC`
+	var queryArgs []any
+	if *limit > 0 {
+		query += ` LIMIT $1`
+		queryArgs = append(queryArgs, *limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return err
+	}
+	var items []articleText
+	for rows.Next() {
+		var a articleText
+		if err := rows.Scan(&a.ID, &a.Title, &a.BodyMD, &a.Description, &a.Tags); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := s.openText(&a); err != nil {
+			rows.Close()
+			return fmt.Errorf("%s: %w", a.ID, err)
+		}
+		items = append(items, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	if !*apply {
+		cw.Write([]string{"id", "title", "current", "tags", "source"})
+	}
+	var failed int
+	for i, a := range items {
+		var p tagProposal
+		if err := s.proposeTags(ctx, ix, a, *mode, *perArticle, &p); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "fail %d/%d id=%s: %v\n", i+1, len(items), a.ID, err)
+			continue
+		}
+		tags := make([]string, 0, len(p.Candidates))
+		for _, cand := range p.Candidates {
+			tags = append(tags, cand.Tag)
+		}
+		if *apply {
+			if err := s.saveTags(ctx, a.ID, append(a.Tags, tags...)); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", a.ID, a.Title, strings.Join(tags, ", "))
+			continue
+		}
+		cw.Write([]string{a.ID, a.Title, strings.Join(a.Tags, ", "), strings.Join(tags, ", "), p.Source})
+		cw.Flush()
+	}
+	cw.Flush()
+	fmt.Fprintf(os.Stderr, "tags: %d ok, %d failed\n", len(items)-failed, failed)
+	return cw.Error()
+}
+
+// applyTagCSV merges the tags column of a reviewed dry-run file into each
+// article's existing tags.
+func applyTagCSV(ctx context.Context, w io.Writer, s *server, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	col := map[string]int{}
+	for i, name := range records[0] {
+		col[strings.TrimSpace(name)] = i
+	}
+	idCol, ok1 := col["id"]
+	tagCol, ok2 := col["tags"]
+	if !ok1 || !ok2 {
+		return errors.New("CSV 需要 id 和 tags 列")
+	}
+	var applied int
+	for _, rec := range records[1:] {
+		if idCol >= len(rec) || tagCol >= len(rec) {
+			continue
+		}
+		tags := slugmigrate.ParseTagList(rec[tagCol])
+		if len(tags) == 0 {
+			continue
+		}
+		a, ok, err := s.loadArticleText(ctx, rec[idCol])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "skip %s: 未找到文章\n", rec[idCol])
+			continue
+		}
+		if err := s.saveTags(ctx, a.ID, append(a.Tags, tags...)); err != nil {
+			return err
+		}
+		applied++
+	}
+	fmt.Fprintf(w, "tagged %d articles\n", applied)
+	return nil
+}
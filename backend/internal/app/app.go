@@ -1,11 +1,10 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/sha256"
+	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
@@ -13,8 +12,10 @@ import (
 	"fmt"
 	"html"
 	"io"
+	"io/fs"
 	"mime/quotedprintable"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -24,13 +25,15 @@ import (
 	"sync"
 	"time"
 
+	"selfecho/backend/internal/slugmigrate"
+	"selfecho/backend/internal/store"
+
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 	"github.com/emersion/go-message/mail"
 	"github.com/gin-gonic/gin"
 	"github.com/gosimple/slug"
 	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/russross/blackfriday/v2"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
@@ -61,6 +64,13 @@ type healthPayload struct {
 	CacheMisses     int64   `json:"cacheMisses"`
 	CacheHitRate    float64 `json:"cacheHitRate"`
 	CacheTTLSeconds int64   `json:"cacheTtlSeconds"`
+	SSRCacheEntries int     `json:"ssrCacheEntries"`
+	SSRCacheHits    int64   `json:"ssrCacheHits"`
+	SSRCacheMisses  int64   `json:"ssrCacheMisses"`
+	SSRCacheHitRate float64 `json:"ssrCacheHitRate"`
+	// Security and Routes are only filled in for adminHealth.
+	Security *securitySnapshot `json:"security,omitempty"`
+	Routes   []routeGroupStats `json:"routes,omitempty"`
 }
 
 type user struct {
@@ -89,52 +99,164 @@ type imapAccount struct {
 	LastUID         uint32    `json:"lastUid"`
 	LastUIDValidity uint32    `json:"lastUidValidity"`
 	CreatedAt       time.Time `json:"createdAt"`
+
+	// CACert is a PEM bundle trusted besides the system roots, for servers
+	// with a private or self-signed certificate.
+	CACert string `json:"caCert,omitempty"`
+	// InsecureSkipVerify turns certificate checks off entirely; it is logged
+	// when set and the first time the account connects.
+	InsecureSkipVerify    bool `json:"insecureSkipVerify"`
+	ConnectTimeoutSeconds int  `json:"connectTimeoutSeconds"`
+	ReadTimeoutSeconds    int  `json:"readTimeoutSeconds"`
+
+	// RetainMessages and RetainDays bound the message cache (see
+	// pruneImapAccount); zero means no limit.
+	RetainMessages int `json:"retainMessages"`
+	RetainDays     int `json:"retainDays"`
+	// CachedMessages and CacheBytes size the cache in the accounts list;
+	// only admins get them.
+	CachedMessages int   `json:"cachedMessages,omitempty"`
+	CacheBytes     int64 `json:"cacheBytes,omitempty"`
 }
 
 type imapMessage struct {
-	UID     uint32   `json:"uid"`
-	Subject string   `json:"subject"`
-	From    string   `json:"from"`
-	Date    string   `json:"date"`
-	Flags   []string `json:"flags"`
-	Snippet string   `json:"snippet"`
-	Body    string   `json:"body"`
+	UID      uint32   `json:"uid"`
+	Subject  string   `json:"subject"`
+	From     string   `json:"from"`
+	Date     string   `json:"date"`
+	Flags    []string `json:"flags"`
+	Snippet  string   `json:"snippet"`
+	ThreadID string   `json:"threadId,omitempty"`
+	Body     string   `json:"body"`
+	// plain is the text version of Body, kept for the cache.
+	plain string
+	// references lists the Message-IDs of the References header.
+	references []string
 }
 
 type article struct {
-	ID          string     `json:"id"`
-	Type        string     `json:"type"`
-	Title       string     `json:"title"`
-	Slug        string     `json:"slug"`
-	Archive     string     `json:"archive,omitempty"`
-	Status      string     `json:"status"`
-	BodyMD      string     `json:"bodyMd"`
-	BodyHTML    string     `json:"bodyHtml,omitempty"`
-	PublishedAt *time.Time `json:"publishedAt,omitempty"`
-	CreatedAt   time.Time  `json:"createdAt"`
-	UpdatedAt   time.Time  `json:"updatedAt"`
+	ID            string       `json:"id"`
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Slug          string       `json:"slug"`
+	Archive       string       `json:"archive,omitempty"`
+	Status        string       `json:"status"`
+	BodyMD        string       `json:"bodyMd"`
+	BodyHTML      string       `json:"bodyHtml,omitempty"`
+	Excerpt       string       `json:"excerpt,omitempty"`
+	Description   string       `json:"metaDescription,omitempty"`
+	Tags          tagList      `json:"tags,omitempty"`
+	Lang          string       `json:"lang,omitempty"`
+	TranslationOf *string      `json:"translationOf,omitempty"`
+	Social        *socialCard  `json:"social,omitempty"`
+	Meta          customFields `json:"meta,omitempty"`
+	Author        *byline      `json:"author,omitempty"`
+	Authors       []byline     `json:"authors,omitempty"`
+	Visibility    string       `json:"visibility,omitempty"`
+	Locked        bool         `json:"locked,omitempty"`
+	// Footnotes counts the notes at the end of the body.
+	Footnotes int `json:"footnotes,omitempty"`
+	// Gallery is the article's photos in order; published bodies already
+	// include them rendered.
+	Gallery     []galleryImage `json:"gallery,omitempty"`
+	PublishedAt *time.Time     `json:"publishedAt,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+
+	passHash string
+}
+
+func articleFromRow(r store.ArticleRow) article {
+	var by *byline
+	if r.Author != "" {
+		by = &byline{Username: r.Author, DisplayName: r.AuthorName}
+	}
+	var authors []byline
+	for _, b := range r.Authors {
+		authors = append(authors, byline{Username: b.Username, DisplayName: b.DisplayName})
+	}
+	return article{
+		ID: r.ID, Type: r.Type, Title: r.Title, Slug: r.Slug, Archive: r.Archive, Status: r.Status,
+		BodyMD: r.BodyMD, BodyHTML: r.BodyHTML, Excerpt: r.Excerpt, Description: r.Description, Tags: tagList(r.Tags),
+		Lang: r.Lang, TranslationOf: r.TranslationOf, Social: parseSocialCard(r.Social), Meta: parseCustomFields(r.Meta), Author: by, Authors: authors,
+		Visibility:  r.Visibility,
+		PublishedAt: r.PublishedAt, CreatedAt: r.CreatedAt, UpdatedAt: r.UpdatedAt,
+		passHash: r.PasswordHash,
+	}
 }
 
 type config struct {
-	Database   dbConfig       `yaml:"database"`
-	Site       siteConfig     `yaml:"site"`
-	Port       int            `yaml:"port"`
-	StaticDir  string         `yaml:"staticDir"`
-	ImapSecret string         `yaml:"imapSecret"`
-	Deepseek   deepseekConfig `yaml:"deepseek"`
-}
-
+	// Env is "production" or empty; production refuses insecure defaults
+	// such as the built-in imapSecret. SELFECHO_ENV overrides it.
+	Env            string            `yaml:"env"`
+	Database       dbConfig          `yaml:"database"`
+	Site           siteConfig        `yaml:"site"`
+	Port           int               `yaml:"port"`
+	StaticDir      string            `yaml:"staticDir"`
+	BasePath       string            `yaml:"basePath"`
+	TrustedProxies []string          `yaml:"trustedProxies"`
+	CORSOrigins    []string          `yaml:"corsOrigins"`
+	CacheTTL       int               `yaml:"cacheTTLSeconds"`
+	SSRCacheTTL    int               `yaml:"ssrCacheTTLSeconds"`
+	LogLevel       string            `yaml:"logLevel"`
+	RateLimit      rateLimitConfig   `yaml:"rateLimit"`
+	Static         staticConfig      `yaml:"static"`
+	ImapSecret     string            `yaml:"imapSecret"`
+	Deepseek       deepseekConfig    `yaml:"deepseek"`
+	Slug           slugConfig        `yaml:"slug"`
+	LLM            llmConfig         `yaml:"llm"`
+	Search         searchConfig      `yaml:"search"`
+	Analytics      analyticsConfig   `yaml:"analytics"`
+	Export         exportConfig      `yaml:"export"`
+	Encryption     encryptionConfig  `yaml:"encryption"`
+	Session        sessionConfig     `yaml:"session"`
+	Admin          adminAccessConfig `yaml:"admin"`
+	TLS            tlsConfig         `yaml:"tls"`
+	Challenge      challengeConfig   `yaml:"challenge"`
+	Metrics        metricsConfig     `yaml:"metrics"`
+	Webhooks       []webhookConfig   `yaml:"webhooks"`
+	SMTP           smtpConfig        `yaml:"smtp"`
+	GitSync        gitSyncConfig     `yaml:"gitSync"`
+	Backup         backupConfig      `yaml:"backup"`
+}
+
+func (cfg config) production() bool {
+	return strings.EqualFold(cfg.Env, "production")
+}
+
+// dbConfig accepts either a postgres:// URL or the discrete fields. Options
+// are passed through to pgx verbatim (connect_timeout,
+// statement_cache_capacity, default_query_exec_mode, ...). ReadURL points at
+// an optional read-only replica for public read traffic.
 type dbConfig struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Name     string `yaml:"name"`
-	SSLMode  string `yaml:"sslmode"`
+	URL             string            `yaml:"url"`
+	ReadURL         string            `yaml:"readUrl"`
+	Host            string            `yaml:"host"`
+	Port            int               `yaml:"port"`
+	User            string            `yaml:"user"`
+	Password        string            `yaml:"password"`
+	Name            string            `yaml:"name"`
+	SSLMode         string            `yaml:"sslmode"`
+	Options         map[string]string `yaml:"options"`
+	MaxOpenConns    int               `yaml:"maxOpenConns"`
+	MaxIdleConns    int               `yaml:"maxIdleConns"`
+	ConnMaxLifetime string            `yaml:"connMaxLifetime"`
+	StartupTimeout  string            `yaml:"startupTimeout"`
+	QueryTimeout    string            `yaml:"queryTimeout"`
+}
+
+// staticConfig controls the non-API file routes.
+type staticConfig struct {
+	MediaDir   string           `yaml:"mediaDir"`
+	ImageCache imageCacheConfig `yaml:"imageCache"`
+	// FilesDir holds uploaded attachments; empty disables uploads.
+	FilesDir    string `yaml:"filesDir"`
+	MaxUploadMB int    `yaml:"maxUploadMB"`
 }
 
 type siteConfig struct {
-	Title string `yaml:"title" json:"title"`
+	Title         string `yaml:"title" json:"title"`
+	CanonicalHost string `yaml:"canonicalHost" json:"-"`
 }
 
 type deepseekConfig struct {
@@ -145,7 +267,6 @@ type deepseekConfig struct {
 
 const (
 	sessionCookieName = "selfecho_session"
-	sessionTTL        = 7 * 24 * time.Hour
 )
 
 type ctxKey string
@@ -172,20 +293,65 @@ func defaultConfig() config {
 			BaseURL: "https://api.deepseek.com",
 			Model:   "deepseek-chat",
 		},
+		Static: staticConfig{
+			MediaDir: "./media",
+		},
 	}
 }
 
 type server struct {
-	db         *sql.DB
-	cache      *listCache
-	startedAt  time.Time
-	imapKey    []byte
-	deepseek   deepseekConfig
-	httpClient *http.Client
+	db           *sql.DB
+	replica      *readReplica
+	cache        *listCache
+	pages        *ssrCache
+	runtime      *runtimeConfig
+	settings     *settingsCache
+	basePath     string
+	canonical    *url.URL
+	proxies      *proxyTrust
+	limiter      *rateLimiter
+	events       *eventBus
+	notify       *notifyHub
+	startedAt    time.Time
+	imap         *imapSecrets
+	imapSync     *imapSyncLimiter
+	deepseek     deepseekConfig
+	slugLLM      slugmigrate.Provider
+	search       *searchIndexer
+	images       *imageCache
+	mediaDir     string
+	files        *attachmentStore
+	traffic      *trafficRecorder
+	articles     articleRepo
+	archives     archiveRepo
+	users        userRepo
+	authors      authorRepo
+	mail         imapRepo
+	httpClient   *http.Client
+	publicClient *http.Client
+	queryTimeout time.Duration
+	sessions     sessionPolicy
+	admin        *adminGuard
+	challenges   *challengeGate
+	security     *securityStats
+	routeStats   *routeStats
+	metricsToken string
+	webhooks     []webhookConfig
+	outboxWake   chan struct{}
+	smtp         smtpConfig
+	// git is the Git mirror, nil unless gitSync is enabled.
+	git *gitMirror
+	// backups is nil unless backup is enabled.
+	backups    *backupRunner
+	export     exportConfig
+	ogImages   *ogImageCache
+	activity   *activityCache
+	encryption encryptionConfig
+	content    *contentCipher
 }
 
 func (s *server) backfillBodyHTML(ctx context.Context) error {
-	rows, err := s.db.QueryContext(ctx, `SELECT id, body_md FROM articles WHERE (body_html IS NULL OR body_html = '')`)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, body_md FROM articles WHERE (body_html IS NULL OR body_html = '') AND body_md NOT LIKE 'enc1:%'`)
 	if err != nil {
 		return err
 	}
@@ -205,8 +371,8 @@ func (s *server) backfillBodyHTML(ctx context.Context) error {
 	}
 
 	for _, it := range items {
-		html := string(blackfriday.Run([]byte(it.body)))
-		_, err := s.db.ExecContext(ctx, `UPDATE articles SET body_html=$1, updated_at=now() WHERE id=$2`, html, it.id)
+		html := renderMarkdown(it.body)
+		_, err := s.db.ExecContext(ctx, `UPDATE articles SET body_html=$1, excerpt=$2, updated_at=now() WHERE id=$3`, html, plainExcerpt(html), it.id)
 		if err != nil {
 			return err
 		}
@@ -214,12 +380,49 @@ func (s *server) backfillBodyHTML(ctx context.Context) error {
 	return nil
 }
 
+// backfillExcerpts fills the excerpt column for rows written before it
+// existed. It only touches NULLs, so it is a no-op after the first run.
+func (s *server) backfillExcerpts(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, COALESCE(body_html, ''), body_md FROM articles WHERE excerpt IS NULL AND body_md NOT LIKE 'enc1:%'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type item struct {
+		id, html, md string
+	}
+	var items []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.html, &it.md); err != nil {
+			return err
+		}
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, it := range items {
+		html := it.html
+		if strings.TrimSpace(html) == "" {
+			html = renderMarkdown(it.md)
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE articles SET excerpt=$1 WHERE id=$2`, plainExcerpt(html), it.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func loadConfig(path string) (config, error) {
 	cfg := defaultConfig()
 	bytes, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Printf("warn: 未找到配置文件 %s，使用默认配置\n", path)
+			warnf("未找到配置文件 %s，使用默认配置\n", path)
+			applyEnvOverrides(&cfg)
 			return cfg, nil
 		}
 		return cfg, fmt.Errorf("读取配置失败: %w", err)
@@ -227,8 +430,21 @@ func loadConfig(path string) (config, error) {
 	if err := yaml.Unmarshal(bytes, &cfg); err != nil {
 		return cfg, fmt.Errorf("解析配置失败: %w", err)
 	}
-	if cfg.Database.Host == "" || cfg.Database.User == "" || cfg.Database.Name == "" || cfg.Database.Port == 0 {
-		return cfg, errors.New("配置不完整: database.host/user/name/port 必填")
+	for _, key := range unknownConfigKeys(bytes) {
+		warnf("配置文件 %s 中存在未知配置项: %s\n", path, key)
+	}
+	applyEnvOverrides(&cfg)
+	if cfg.Database.URL == "" && (cfg.Database.Host == "" || cfg.Database.User == "" || cfg.Database.Name == "" || cfg.Database.Port == 0) {
+		return cfg, errors.New("配置不完整: database.url 或 database.host/user/name/port 必填")
+	}
+	if _, err := cfg.Database.connMaxLifetime(); err != nil {
+		return cfg, err
+	}
+	if _, err := cfg.Database.startupTimeout(); err != nil {
+		return cfg, err
+	}
+	if _, err := BuildDSN(cfg.Database); err != nil {
+		return cfg, err
 	}
 	if cfg.Site.Title == "" {
 		cfg.Site.Title = defaultConfig().Site.Title
@@ -248,27 +464,93 @@ func loadConfig(path string) (config, error) {
 	return cfg, nil
 }
 
-func buildDSN(cfg dbConfig) string {
+// DBConfig is the database section of config.yaml, for the command line
+// tools that connect the same way the server does.
+type DBConfig = dbConfig
+
+// BuildDSN is the pgx connection string for cfg: database.url with the
+// options added to its query, or a keyword/value DSN from the discrete fields.
+func BuildDSN(cfg DBConfig) (string, error) {
+	keys := make([]string, 0, len(cfg.Options))
+	for k := range cfg.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			return "", errors.New("database.url 无效，需为 postgres:// 形式")
+		}
+		q := u.Query()
+		for _, k := range keys {
+			q.Set(k, cfg.Options[k])
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
 	sslmode := cfg.SSLMode
 	if sslmode == "" {
 		sslmode = "disable"
 	}
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name, sslmode)
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		dsnValue(cfg.Host), cfg.Port, dsnValue(cfg.User), dsnValue(cfg.Password), dsnValue(cfg.Name), dsnValue(sslmode))
+	for _, k := range keys {
+		dsn += " " + k + "=" + dsnValue(cfg.Options[k])
+	}
+	return dsn, nil
+}
+
+// dsnValue quotes a keyword/value DSN value when it contains spaces or quotes.
+func dsnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+func (cfg dbConfig) connMaxLifetime() (time.Duration, error) {
+	if cfg.ConnMaxLifetime == "" {
+		return 5 * time.Minute, nil
+	}
+	d, err := time.ParseDuration(cfg.ConnMaxLifetime)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("database.connMaxLifetime 无效: %s", cfg.ConnMaxLifetime)
+	}
+	return d, nil
 }
 
 func ensureDB(ctx context.Context, cfg dbConfig) (*sql.DB, error) {
-	dsn := buildDSN(cfg)
+	dsn, err := BuildDSN(cfg)
+	if err != nil {
+		return nil, err
+	}
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("创建数据库连接失败: %w", err)
 	}
-	db.SetConnMaxLifetime(5 * time.Minute)
-	db.SetMaxIdleConns(5)
-	db.SetMaxOpenConns(10)
+	lifetime, err := cfg.connMaxLifetime()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	maxOpen, maxIdle := cfg.MaxOpenConns, cfg.MaxIdleConns
+	if maxOpen <= 0 {
+		maxOpen = 10
+	}
+	if maxIdle <= 0 {
+		maxIdle = 5
+	}
+	db.SetConnMaxLifetime(lifetime)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetMaxOpenConns(maxOpen)
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("数据库连接失败: %w", err)
 	}
 	return db, nil
@@ -279,19 +561,19 @@ func makeSlug(title, provided string) (string, error) {
 		s := strings.TrimSpace(provided)
 		s = slug.Make(s)
 		if s == "" {
-			return "", errors.New("slug 不合法")
+			return "", newAPIError(errInvalidSlug)
 		}
 		return s, nil
 	}
 
 	base := strings.TrimSpace(title)
 	if base == "" {
-		return "", errors.New("标题为空，无法生成 slug")
+		return "", newAPIError(errSlugTitleEmpty)
 	}
 
 	s := slug.MakeLang(base, "zh")
 	if s == "" {
-		return "", errors.New("无法根据标题生成 slug")
+		return "", newAPIError(errSlugGenerationFailed)
 	}
 	return s, nil
 }
@@ -302,12 +584,12 @@ func (s *server) generateSlug(c *gin.Context) {
 		Mode  string `json:"mode"`
 	}
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
 	title := strings.TrimSpace(payload.Title)
 	if title == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "标题不能为空"})
+		respondError(c, http.StatusBadRequest, errTitleRequired)
 		return
 	}
 
@@ -320,261 +602,605 @@ func (s *server) generateSlug(c *gin.Context) {
 	case "llm":
 		slugVal, err := s.generateSlugWithLLM(c.Request.Context(), title)
 		if err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			respondErrorDetail(c, http.StatusBadGateway, errLLMFailed, err)
 			return
 		}
 		uniqueSlug, err := s.ensureUniqueSlug(c.Request.Context(), slugVal, "")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "slug 去重失败"})
+			respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"slug": uniqueSlug, "source": "llm", "deduped": uniqueSlug != slugVal})
 	case "pinyin":
 		slugVal, err := makeSlug(title, "")
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidSlug, err)
 			return
 		}
 		uniqueSlug, err := s.ensureUniqueSlug(c.Request.Context(), slugVal, "")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "slug 去重失败"})
+			respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"slug": uniqueSlug, "source": "pinyin", "deduped": uniqueSlug != slugVal})
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "mode 仅支持 llm 或 pinyin"})
+		respondError(c, http.StatusBadRequest, errInvalidSlugMode)
 	}
 }
 
 func (s *server) generateSlugWithLLM(ctx context.Context, title string) (string, error) {
 	if s.deepseek.APIKey == "" {
-		return "", errors.New("未配置 DeepSeek API 密钥")
+		return "", newAPIError(errLLMNotConfigured)
 	}
-	baseURL := strings.TrimSuffix(strings.TrimSpace(s.deepseek.BaseURL), "/")
-	if baseURL == "" {
-		baseURL = defaultConfig().Deepseek.BaseURL
+	client := &slugmigrate.DeepSeekClient{
+		BaseURL:    s.deepseek.BaseURL,
+		Model:      s.deepseek.Model,
+		APIKey:     s.deepseek.APIKey,
+		HTTPClient: s.httpClient,
 	}
-	model := strings.TrimSpace(s.deepseek.Model)
-	if model == "" {
-		model = defaultConfig().Deepseek.Model
+	slugified, err := client.GenerateSlug(ctx, title)
+	if err != nil {
+		var httpErr *slugmigrate.HTTPError
+		switch {
+		case errors.As(err, &httpErr):
+			return "", newAPIError(errLLMFailed, httpErr.Error())
+		case errors.Is(err, slugmigrate.ErrEmptySlug):
+			return "", errors.New("DeepSeek 返回的内容无法转换为 slug")
+		}
+		return "", fmt.Errorf("DeepSeek 请求失败: %w", err)
 	}
+	return slugified, nil
+}
 
-	payload := map[string]any{
-		"model": model,
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": "将我下面给你的中文标题转换为SEO友好的英文slug格式。输出要求：全小写、用连字符连接、简洁明了。仅输出slug本身。",
-			},
-			{
-				"role":    "user",
-				"content": title,
-			},
-		},
-		"stream": false,
+func resolveConfigPath() string {
+	if cfgPath := os.Getenv("CONFIG_PATH"); cfgPath != "" {
+		return cfgPath
+	}
+	// Prefer local config.yaml next to the binary, then parent (for dev)
+	if _, err := os.Stat("config.yaml"); err == nil {
+		return "config.yaml"
 	}
+	if _, err := os.Stat(filepath.Join("..", "config.yaml")); err == nil {
+		return filepath.Join("..", "config.yaml")
+	}
+	return "config.yaml" // default; will fail with clear error if missing
+}
 
-	body, err := json.Marshal(payload)
+func Run() error {
+	cfgPath := resolveConfigPath()
+	cfg, err := loadConfig(cfgPath)
 	if err != nil {
-		return "", err
+		return err
 	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/chat/completions", bytes.NewReader(body))
+	// the on-disk build wins so `ng build --watch` keeps working; binaries built
+	// with -tags embedspa fall back to their bundled copy
+	spa := diskSPA(resolveStaticDir(cfgPath, cfg.StaticDir))
+	if spa == nil {
+		if spa = embeddedSPA(); spa != nil {
+			infof("使用内嵌的前端构建\n")
+		}
+	}
+	if err := validateServerConfig(cfg); err != nil {
+		return err
+	}
+	level, _ := parseLogLevel(cfg.LogLevel)
+	setLogLevel(level)
+	tlsCfg, err := serverTLSConfig(cfg)
 	if err != nil {
-		return "", err
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.deepseek.APIKey)
 
-	client := s.httpClient
-	if client == nil {
-		client = http.DefaultClient
+	// listen right away so orchestrators see a 503 /healthz instead of a
+	// crash loop while Postgres is still starting
+	handler := &swapHandler{}
+	handler.set(startingHandler())
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: handler, TLSConfig: tlsCfg}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsCfg != nil {
+			serveErr <- srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+		cancel()
+	}()
+
+	db, err := connectWithRetry(ctx, cfg.Database)
+	if err != nil {
+		if ctx.Err() != nil {
+			return <-serveErr
+		}
+		srv.Close()
+		return err
 	}
+	defer db.Close()
+	replica := openReadReplica(context.Background(), cfg.Database)
+	defer replica.close()
 
-	resp, err := client.Do(req)
+	s, err := newServer(cfgPath, cfg, db, replica)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if err := s.ensureSchema(context.Background()); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
+	// reloads load site settings, so the schema has to exist first
+	s.watchReloadSignal()
+	router, err := s.routes(spa, resolveMediaDir(cfgPath, cfg.Static.MediaDir))
+	if err != nil {
+		return err
+	}
+	s.startBackground()
 
-	if resp.StatusCode >= 300 {
-		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		return "", fmt.Errorf("调用 DeepSeek 失败(%d): %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	handler.set(router)
+	infof("服务已就绪，监听 :%d\n", cfg.Port)
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
+}
 
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+// validateServerConfig runs the parses newServer depends on, so Run can
+// refuse a bad config before it starts listening.
+func validateServerConfig(cfg config) error {
+	if _, err := parseCanonicalHost(cfg.Site.CanonicalHost); err != nil {
+		return err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("解析 DeepSeek 响应失败: %w", err)
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
 	}
-	if len(result.Choices) == 0 {
-		return "", errors.New("DeepSeek 返回为空")
+	if err := validateReloadable(cfg); err != nil {
+		return err
 	}
-	content := strings.TrimSpace(result.Choices[0].Message.Content)
-	content = strings.Trim(content, "\"`' ")
-	content = strings.ReplaceAll(content, "\n", " ")
-
-	slugified := slug.Make(content)
-	if slugified == "" {
-		slugified = slug.MakeLang(content, "zh")
+	if cfg.production() && (cfg.ImapSecret == "" || cfg.ImapSecret == defaultImapSecret) {
+		return errDefaultImapSecret
 	}
-	if slugified == "" {
-		return "", errors.New("DeepSeek 返回的内容无法转换为 slug")
+	if _, err := cfg.Session.policy(); err != nil {
+		return err
 	}
-	return slugified, nil
+	if _, err := newAdminGuard(cfg); err != nil {
+		return err
+	}
+	if _, err := serverTLSConfig(cfg); err != nil {
+		return err
+	}
+	if _, err := newChallengeGate(cfg.Challenge, nil); err != nil {
+		return err
+	}
+	if err := validateWebhooks(cfg.Webhooks); err != nil {
+		return err
+	}
+	if err := validateSMTP(cfg.SMTP); err != nil {
+		return err
+	}
+	if err := validateGitSync(cfg.GitSync); err != nil {
+		return err
+	}
+	if err := validateBackup(cfg.Backup); err != nil {
+		return err
+	}
+	_, err := cfg.Database.queryTimeout()
+	return err
 }
 
-func Run() error {
-	cfgPath := os.Getenv("CONFIG_PATH")
-	if cfgPath == "" {
-		// Prefer local config.yaml next to the binary, then parent (for dev)
-		if _, err := os.Stat("config.yaml"); err == nil {
-			cfgPath = "config.yaml"
-		} else if _, err := os.Stat(filepath.Join("..", "config.yaml")); err == nil {
-			cfgPath = filepath.Join("..", "config.yaml")
-		} else {
-			cfgPath = "config.yaml" // default; will fail with clear error if missing
-		}
+// newServer wires a server for cfg around an open database. It neither
+// touches the schema nor starts background work, so tests can build one
+// against a scratch database.
+func newServer(cfgPath string, cfg config, db *sql.DB, replica *readReplica) (*server, error) {
+	canonical, err := parseCanonicalHost(cfg.Site.CanonicalHost)
+	if err != nil {
+		return nil, err
 	}
-
-	cfg, err := loadConfig(cfgPath)
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	staticDir := resolveStaticDir(cfgPath, cfg.StaticDir)
-	db, err := ensureDB(context.Background(), cfg.Database)
+	queryTimeout, err := cfg.Database.queryTimeout()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer db.Close()
-
-	router := gin.Default()
-	router.SetTrustedProxies(nil)
-	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Page, X-Limit")
-		if c.Request.Method == http.MethodOptions {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-		c.Next()
-	})
-
-	secret := cfg.ImapSecret
-	if env := os.Getenv("IMAP_SECRET"); env != "" {
-		secret = env
+	sessions, err := cfg.Session.policy()
+	if err != nil {
+		return nil, err
 	}
-
-	deepseekCfg := cfg.Deepseek
-	if env := os.Getenv("DEEPSEEK_API_KEY"); env != "" {
-		deepseekCfg.APIKey = env
+	admin, err := newAdminGuard(cfg)
+	if err != nil {
+		return nil, err
 	}
-
 	s := &server{
-		db:         db,
-		cache:      newListCache(30 * time.Second),
-		startedAt:  time.Now(),
-		imapKey:    deriveKey(secret),
-		deepseek:   deepseekCfg,
-		httpClient: &http.Client{Timeout: 15 * time.Second},
+		db:           db,
+		replica:      replica,
+		cache:        newListCache(cacheTTL(cfg)),
+		pages:        newSSRCache(ssrCacheTTL(cfg)),
+		runtime:      &runtimeConfig{path: cfgPath, current: cfg, startup: cfg, origins: normalizeOrigins(cfg.CORSOrigins)},
+		settings:     newSettingsCache(defaultSiteSettings(cfg.Site)),
+		basePath:     normalizeURLPrefix(cfg.BasePath),
+		canonical:    canonical,
+		proxies:      proxies,
+		limiter:      newRateLimiter(cfg.RateLimit),
+		events:       newEventBus(),
+		notify:       newNotifyHub(),
+		startedAt:    time.Now(),
+		imap:         newImapSecrets(cfg.ImapSecret),
+		imapSync:     newImapSyncLimiter(maxConcurrentImapSyncs),
+		deepseek:     cfg.Deepseek,
+		slugLLM:      newSlugProvider(cfg, &http.Client{Timeout: 15 * time.Second}),
+		search:       newSearchIndexer(cfg.Search),
+		mediaDir:     resolveMediaDir(cfgPath, cfg.Static.MediaDir),
+		ogImages:     &ogImageCache{},
+		activity:     &activityCache{},
+		encryption:   cfg.Encryption,
+		export:       cfg.Export,
+		httpClient:   &http.Client{Timeout: 15 * time.Second},
+		publicClient: newPublicClient(15 * time.Second),
+		queryTimeout: queryTimeout,
+		sessions:     sessions,
+		admin:        admin,
+		security:     newSecurityStats(),
+		routeStats:   newRouteStats(),
+		metricsToken: cfg.Metrics.Token,
+		webhooks:     cfg.Webhooks,
+		outboxWake:   make(chan struct{}, 1),
+		smtp:         cfg.SMTP,
+		git:          newGitMirror(cfgPath, cfg.GitSync),
+		backups:      newBackupRunner(cfgPath, cfg.Backup, cfg.Database),
+	}
+	s.useStore(store.New(db, replicaReader{s}))
+	if s.challenges, err = newChallengeGate(cfg.Challenge, s.httpClient); err != nil {
+		return nil, err
 	}
+	s.images = newImageCache(s.mediaDir, cfg.Static.ImageCache, s.publicClient)
+	s.files = newAttachmentStore(resolveMediaDir(cfgPath, cfg.Static.FilesDir), cfg.Static.MaxUploadMB)
+	s.traffic = newTrafficRecorder(cfg.Analytics, cfgPath)
+	s.registerEventSubscribers()
+	return s, nil
+}
 
-	if err := s.ensureAuthSchema(context.Background()); err != nil {
+// ensureSchema applies the startup migrations and loads the site settings.
+func (s *server) ensureSchema(ctx context.Context) error {
+	if err := s.ensureAuthSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureInitialAdmin(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureImapSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureImapRulesSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureMailFeedSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureArticleSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureSettingsSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureContentKey(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureRedirectSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureSearchSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureBlogrollSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureReaderSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureBookmarkSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureLinkGraphSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureGallerySchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureAttachmentSchema(ctx); err != nil {
 		return err
 	}
-	if err := s.ensureInitialAdmin(context.Background()); err != nil {
+	if err := s.ensurePreviewSchema(ctx); err != nil {
 		return err
 	}
-	if err := s.ensureImapSchema(context.Background()); err != nil {
+	if err := s.ensureEditLockSchema(ctx); err != nil {
 		return err
 	}
-	if err := s.ensureArticleSchema(context.Background()); err != nil {
+	if err := s.ensureTokenSchema(ctx); err != nil {
 		return err
 	}
+	if err := s.ensureOutboxSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureActivitySchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureCrawlSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureTrafficSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureIdempotencySchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureTemplateSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureAuthorSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureCoauthorSchema(ctx); err != nil {
+		return err
+	}
+	if err := s.ensureBackupSchema(ctx); err != nil {
+		return err
+	}
+	return s.loadSettings(ctx)
+}
+
+// routes builds the router: middleware, the JSON API under /api, the SSR
+// pages and finally the SPA and media files.
+func (s *server) routes(spa fs.FS, mediaDir string) (*gin.Engine, error) {
+	router := gin.New()
+	router.Use(requestLogger(), gin.Recovery())
+	if err := router.SetTrustedProxies(s.proxies.strings()); err != nil {
+		return nil, err
+	}
+	router.Use(s.routeStatsMiddleware())
+	router.Use(s.corsMiddleware())
+	router.Use(s.canonicalHostMiddleware())
+	router.Use(s.accessLogMiddleware())
+	router.Use(s.adminPagesMiddleware())
+
+	site := newStaticSite(spa, mediaDir, s.basePath)
+	site.images = s.images
 
-	router.GET("/api/hello", func(c *gin.Context) {
+	// every route lives under basePath so selfecho can sit behind a sub-path proxy
+	root := router.Group(s.basePath)
+	root.GET("/api/hello", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "hello from backend"})
 	})
 
-	router.GET("/api/site", func(c *gin.Context) {
-		c.JSON(http.StatusOK, cfg.Site)
-	})
+	root.GET("/api/site", s.getSite)
+	root.GET("/api/theme", s.getTheme)
+	root.GET("/og-image.png", s.ogImageHandler)
+	root.GET("/favicon.ico", s.iconHandler("favicon.ico", "image/x-icon", site.buildFile))
+	for _, icon := range siteIcons {
+		root.GET("/"+icon.name, s.iconHandler(icon.name, "image/png", site.buildFile))
+	}
+	root.GET("/manifest.webmanifest", s.manifestHandler)
+	root.GET("/healthz", s.healthz)
+	root.GET("/metrics", s.metricsHandler)
 
-	router.GET("/health", func(c *gin.Context) {
+	root.GET("/health", func(c *gin.Context) {
 		payload, err := s.collectHealth()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondErrorDetail(c, http.StatusInternalServerError, errHealthUnavailable, err)
 			return
 		}
 		c.JSON(http.StatusOK, payload)
 	})
-	router.GET("/api/health", func(c *gin.Context) {
+	root.GET("/api/health", func(c *gin.Context) {
 		payload, err := s.collectHealth()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			respondErrorDetail(c, http.StatusInternalServerError, errHealthUnavailable, err)
 			return
 		}
 		c.JSON(http.StatusOK, payload)
 	})
 
-	api := router.Group("/api")
+	api := root.Group("/api", s.rateLimitMiddleware())
 	{
 		api.GET("/articles", s.listArticles)
-		api.POST("/auth/login", s.login)
+		api.POST("/auth/login", s.adminAccessMiddleware(), s.requireChallenge("login"), s.login)
 		api.POST("/auth/logout", s.logout)
 		api.GET("/auth/me", s.me)
 		api.GET("/archives", s.listArchives)
+		api.GET("/archive/timeline", s.archiveTimeline)
+		api.GET("/stats/activity", s.activityStatsHandler)
 		api.GET("/categories", s.listCategories)
-		api.GET("/imap/messages", s.listImapMessages)
-		api.GET("/imap/accounts", s.listImapAccounts)
-		api.GET("/imap/messages/:uid", s.getImapMessage)
+		api.GET("/authors", s.listAuthors)
+		api.GET("/authors/:username", s.getAuthor)
+		api.GET("/links", s.listLinks)
+		api.POST("/articles/:id/unlock", s.requireChallenge("unlock"), s.unlockArticle)
+		api.GET("/challenge/:endpoint", s.getChallenge)
+		api.POST("/git/webhook", s.gitWebhook)
+		api.POST("/bookmarks", formAccessToken, s.adminAccessMiddleware(), s.requireAuthMiddleware(), s.idempotencyMiddleware(),
+			s.requireScope(scopeBookmarks), s.createBookmark)
 
 		protected := api.Group("/")
+		protected.Use(s.adminAccessMiddleware())
 		protected.Use(s.requireAuthMiddleware())
-		protected.POST("/articles", s.createArticle)
-		protected.PUT("/articles/:id", s.updateArticle)
-		protected.DELETE("/articles/:id", s.deleteArticle)
-		protected.POST("/archives", s.createArchive)
-		protected.PUT("/archives/:id", s.updateArchive)
-		protected.DELETE("/archives/:id", s.deleteArchive)
-		protected.POST("/imap/accounts", s.createImapAccount)
-		protected.GET("/imap/diagnose", s.diagnoseImapFetch)
-		protected.POST("/imap/rebuild", s.rebuildImapCache)
-		protected.POST("/slug", s.generateSlug)
-	}
-
+		protected.Use(s.idempotencyMiddleware())
+
+		// API tokens only reach the groups their scopes name
+		readArticles := protected.Group("/", s.requireScope(scopeArticlesRead))
+		readArticles.GET("/articles/:id/export", s.exportArticle)
+		readArticles.GET("/articles/:id/backlinks", s.backlinks)
+		readArticles.GET("/articles/:id/previews", s.listPreviews)
+		readArticles.GET("/articles/:id/lock", s.getEditLock)
+		readArticles.GET("/templates", s.listTemplates)
+		readArticles.GET("/templates/:id", s.getTemplate)
+
+		writeArticles := protected.Group("/", s.requireScope(scopeArticlesWrite))
+		writeArticles.POST("/articles", s.createArticle)
+		writeArticles.POST("/articles/import", s.importArticles)
+		writeArticles.POST("/articles/import/ghost", s.importGhost)
+		writeArticles.POST("/articles/import/substack", s.importSubstack)
+		writeArticles.POST("/articles/import/journal", s.importJournal)
+		writeArticles.PUT("/articles/:id", s.updateArticle)
+		writeArticles.DELETE("/articles/:id", s.deleteArticle)
+		writeArticles.POST("/articles/:id/duplicate", s.duplicateArticle)
+		writeArticles.POST("/articles/from-template/:id", s.createFromTemplate)
+		writeArticles.POST("/templates", s.createTemplate)
+		writeArticles.PUT("/templates/:id", s.updateTemplate)
+		writeArticles.DELETE("/templates/:id", s.deleteTemplate)
+		writeArticles.POST("/archives", s.createArchive)
+		writeArticles.PUT("/archives/:id", s.updateArchive)
+		writeArticles.DELETE("/archives/:id", s.deleteArchive)
+		writeArticles.POST("/slug", s.generateSlug)
+		writeArticles.POST("/slug/suggest", s.suggestSlug)
+		writeArticles.POST("/articles/:id/ai/summary", s.aiSummary)
+		writeArticles.POST("/articles/:id/ai/tags", s.aiTags)
+		writeArticles.POST("/links", s.createLink)
+		writeArticles.PUT("/links/:id", s.updateLink)
+		writeArticles.DELETE("/links/:id", s.deleteLink)
+		writeArticles.POST("/articles/:id/previews", s.createPreview)
+		writeArticles.DELETE("/articles/:id/previews/:previewId", s.revokePreview)
+		writeArticles.POST("/articles/:id/lock", s.acquireEditLock)
+		writeArticles.POST("/articles/:id/lock/heartbeat", s.heartbeatEditLock)
+		writeArticles.DELETE("/articles/:id/lock", s.releaseEditLock)
+
+		bookmarks := protected.Group("/", s.requireScope(scopeBookmarks))
+		bookmarks.GET("/bookmarks", s.listBookmarks)
+		bookmarks.PUT("/bookmarks/:id", s.updateBookmark)
+		bookmarks.DELETE("/bookmarks/:id", s.deleteBookmark)
+
+		media := protected.Group("/", s.requireScope(scopeMediaWrite))
+		media.GET("/attachments", s.listAttachments)
+		media.POST("/attachments", s.uploadAttachment)
+		media.DELETE("/attachments/:id", s.deleteAttachment)
+		media.GET("/articles/:id/gallery", s.listGallery)
+		media.POST("/articles/:id/gallery", s.uploadGalleryImages)
+		media.PUT("/articles/:id/gallery", s.arrangeGallery)
+		media.DELETE("/articles/:id/gallery/:imageId", s.deleteGalleryImage)
+
+		mail := protected.Group("/", s.requireScope(scopeImapRead))
+		mail.GET("/imap/messages", s.listImapMessages)
+		mail.GET("/imap/messages/:uid", s.getImapMessage)
+		mail.GET("/imap/accounts", s.listImapAccounts)
+		mail.GET("/imap/diagnose", s.diagnoseImapFetch)
+		mail.GET("/imap/accounts/:id/summary", s.imapAccountSummary)
+		mail.GET("/imap/threads", s.listImapThreads)
+		mail.GET("/imap/threads/:id", s.getImapThread)
+
+		admin := protected.Group("/", s.requireScope(scopeAdmin))
+		admin.GET("/imap/providers", s.listImapProviders)
+		admin.POST("/imap/accounts", s.createImapAccount)
+		admin.PUT("/imap/accounts/:id/retention", s.updateImapRetention)
+		admin.GET("/imap/rules", s.listImapRules)
+		admin.POST("/imap/rules", s.createImapRule)
+		admin.POST("/imap/rules/test", s.testImapRules)
+		admin.PUT("/imap/rules/:id", s.updateImapRule)
+		admin.DELETE("/imap/rules/:id", s.deleteImapRule)
+		admin.GET("/imap/feeds", s.listMailFeeds)
+		admin.POST("/imap/feeds", s.createMailFeed)
+		admin.POST("/imap/feeds/:id/token", s.rotateMailFeed)
+		admin.DELETE("/imap/feeds/:id", s.deleteMailFeed)
+		admin.GET("/reader/feeds", s.listReaderFeeds)
+		admin.POST("/reader/feeds", s.createReaderFeed)
+		admin.POST("/reader/feeds/:id/refresh", s.refreshReaderFeed)
+		admin.DELETE("/reader/feeds/:id", s.deleteReaderFeed)
+		admin.GET("/reader/items", s.listReaderItems)
+		admin.PUT("/reader/items/:id", s.markReaderItem)
+		admin.POST("/reader/items/read", s.markReaderRead)
+		admin.POST("/imap/rebuild", s.rebuildImapCache)
+		admin.PUT("/authors/me", s.updateProfile)
+		admin.GET("/settings", s.getSettings)
+		admin.PUT("/settings", s.updateSettings)
+		admin.PUT("/theme", s.updateTheme)
+		admin.POST("/theme/:asset", s.uploadThemeAsset)
+		admin.DELETE("/theme/:asset", s.deleteThemeAsset)
+		admin.POST("/admin/reload", s.reloadConfigHandler)
+		admin.GET("/admin/events", s.adminEvents)
+		admin.POST("/admin/search/reindex", s.reindexSearchHandler)
+		admin.GET("/admin/orphans", s.orphanPosts)
+		admin.GET("/admin/activity", s.adminActivity)
+		admin.GET("/admin/jobs", s.adminJobs)
+		admin.GET("/admin/jobs/:id", s.adminJob)
+		admin.POST("/admin/jobs/:id/retry", s.retryJob)
+		admin.POST("/admin/git/sync", s.syncGitNow)
+		admin.GET("/admin/backups", s.listBackups)
+		admin.GET("/admin/storage", s.adminStorage)
+		admin.GET("/admin/health", s.adminHealth)
+		admin.POST("/admin/backups", s.startBackup)
+		admin.GET("/admin/crawl-stats", s.crawlStats)
+		admin.GET("/admin/traffic", s.adminTraffic)
+		admin.GET("/tokens", s.listTokens)
+		admin.POST("/tokens", s.createToken)
+		admin.DELETE("/tokens/:id", s.revokeToken)
+		admin.GET("/users", s.listUsers)
+		admin.POST("/users/service-accounts", s.createServiceAccount)
+		admin.POST("/users/:id/tokens", s.issueServiceToken)
+		admin.DELETE("/users/:id/tokens", s.revokeServiceAccount)
+		admin.DELETE("/users/:id", s.deleteServiceAccount)
+	}
+
+	root.GET("/", s.cachedSSR(s.seoHomeHandler(spa)))
+	root.GET("/post/:slug", s.trackCrawl("post", s.cachedSSR(s.seoPostHandler(spa))))
+	root.GET("/post/:slug/plain", s.cachedSSR(s.seoPlainPostHandler()))
+	root.GET("/archive", s.cachedSSR(s.seoArchiveHandler(spa)))
+	root.GET("/archive/:year/:month", s.cachedSSR(s.seoArchiveMonthHandler(spa)))
+	root.GET("/categories", s.cachedSSR(s.seoCategoriesHandler(spa)))
+	root.GET("/category/:name", s.cachedSSR(s.seoCategoryHandler(spa)))
+	root.GET("/category/:name/feed.xml", s.cachedSSR(s.seoCategoryFeedHandler()))
+	root.GET("/author/:username", s.cachedSSR(s.seoAuthorHandler(spa)))
+	root.GET("/robots.txt", s.cachedSSR(s.seoRobotsHandler()))
+	root.GET("/sitemap.xml", s.trackCrawl("sitemap", s.cachedSSR(s.seoSitemapHandler())))
+	root.GET("/links", s.cachedSSR(s.seoLinksHandler(spa)))
+	root.GET("/opml.xml", s.cachedSSR(s.seoOPMLHandler()))
+	root.GET("/liked", s.cachedSSR(s.seoLikedHandler(spa)))
+	root.GET("/liked/feed.xml", s.cachedSSR(s.seoLikedFeedHandler()))
+	root.GET("/notes", s.cachedSSR(s.seoNotesHandler(spa)))
+	root.GET("/notes/feed.xml", s.cachedSSR(s.seoNotesFeedHandler()))
+	root.GET("/notes/:id", s.cachedSSR(s.seoNoteHandler(spa)))
+	root.GET("/preview/:token", s.seoPreviewHandler(spa))
+	root.GET("/feeds/mail/:token", s.serveMailFeed)
+	root.GET("/files/:id/:name", s.downloadAttachment)
+	root.HEAD("/files/:id/:name", s.downloadAttachment)
+
+	site.mount(router)
+
+	return router, nil
+}
+
+// startBackground kicks off the startup backfills and the long-running
+// workers.
+func (s *server) startBackground() {
 	if err := s.backfillBodyHTML(context.Background()); err != nil {
-		fmt.Printf("warn: backfill body_html failed: %v\n", err)
-	}
-
-	router.GET("/", s.seoHomeHandler(staticDir, cfg.Site.Title))
-	router.GET("/post/:slug", s.seoPostHandler(staticDir, cfg.Site.Title))
-	router.GET("/archive", s.seoArchiveHandler(staticDir, cfg.Site.Title))
-	router.GET("/categories", s.seoCategoriesHandler(staticDir, cfg.Site.Title))
-	router.GET("/category/:name", s.seoCategoryHandler(staticDir, cfg.Site.Title))
-	router.GET("/robots.txt", s.seoRobotsHandler())
-	router.GET("/sitemap.xml", s.seoSitemapHandler(cfg.Site.Title))
-
-	serveSPA(router, staticDir)
-
-	if err := router.Run(fmt.Sprintf(":%d", cfg.Port)); err != nil {
-		return err
-	}
-	return nil
+		warnf("backfill body_html failed: %v\n", err)
+	}
+	if err := s.backfillExcerpts(context.Background()); err != nil {
+		warnf("回填文章摘要失败: %v\n", err)
+	}
+	if _, err := s.backfillImapSnippets(context.Background()); err != nil {
+		warnf("回填邮件摘要失败: %v\n", err)
+	}
+	if n, err := s.sealPendingArticles(context.Background(), ""); err != nil {
+		warnf("加密草稿与密码文章失败: %v\n", err)
+	} else if n > 0 {
+		infof("已更新 %d 篇文章的正文加密状态\n", n)
+	}
+	s.startReindex(true)
+	go s.runTrafficFlusher()
+	go s.runSessionCleanup()
+	go s.runOutbox()
+	go s.runImapPruning()
+	go s.runReaderPolling()
+	go s.runBackupSchedule()
+	go func() {
+		if _, err := s.rebuildLinkGraph(context.Background()); err != nil {
+			warnf("重建内链图失败: %v\n", err)
+		}
+	}()
 }
 
 type archive struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ItemCount    int        `json:"itemCount"`
+	LatestPostAt *time.Time `json:"latestPostAt,omitempty"`
 }
 
 type archivePayload struct {
@@ -582,6 +1208,23 @@ type archivePayload struct {
 	Description string `json:"description"`
 }
 
+func (p archivePayload) validate() error {
+	var v validator
+	name := strings.TrimSpace(p.Name)
+	if v.check(name != "", "name", errNameRequired) {
+		v.check(maxRunes(name, maxArchiveNameRunes), "name", errNameTooLong)
+	}
+	v.check(maxRunes(p.Description, maxArchiveDescRunes), "description", errDescriptionTooLong)
+	return v.err()
+}
+
+func archiveFromRow(r store.Archive) archive {
+	return archive{
+		ID: r.ID, Name: r.Name, Description: r.Description, CreatedAt: r.CreatedAt,
+		ItemCount: r.ItemCount, LatestPostAt: r.LatestPostAt,
+	}
+}
+
 type categorySummary struct {
 	Name  string `json:"name"`
 	Count int    `json:"count"`
@@ -608,15 +1251,27 @@ func newListCache(ttl time.Duration) *listCache {
 	}
 }
 
-func (c *listCache) key(status, archive, typ, slug string, page, limit int, compact bool) string {
-	return fmt.Sprintf("s=%s|a=%s|t=%s|slug=%s|p=%d|l=%d|c=%t", status, archive, typ, slug, page, limit, compact)
+// listQuery is every filter that shapes an /api/articles response, and so
+// the list cache key. Compact and full responses are cut from the same rows
+// at serialization, so they share an entry.
+type listQuery struct {
+	status, archive, typ, slug, lang, author string
+	// meta is the ?meta.key= filter, encoded by metaFilterKey.
+	meta        string
+	page, limit int
+	// listedOnly hides unlisted posts from anonymous listings.
+	listedOnly bool
+}
+
+func (q listQuery) key() string {
+	return fmt.Sprintf("s=%s|a=%s|t=%s|slug=%s|lang=%s|by=%s|meta=%s|p=%d|l=%d|listed=%t",
+		q.status, q.archive, q.typ, q.slug, q.lang, q.author, q.meta, q.page, q.limit, q.listedOnly)
 }
 
-func (c *listCache) get(status, archive, typ, slug string, page, limit int, compact bool) (cachedList, bool) {
+func (c *listCache) get(q listQuery) (cachedList, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	ck := c.key(status, archive, typ, slug, page, limit, compact)
-	val, ok := c.data[ck]
+	val, ok := c.data[q.key()]
 	if !ok || time.Since(val.cachedAt) > c.ttl {
 		c.misses++
 		return cachedList{}, false
@@ -625,11 +1280,10 @@ func (c *listCache) get(status, archive, typ, slug string, page, limit int, comp
 	return val, true
 }
 
-func (c *listCache) set(status, archive, typ, slug string, page, limit int, compact bool, items []article, total int) {
+func (c *listCache) set(q listQuery, items []article, total int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	ck := c.key(status, archive, typ, slug, page, limit, compact)
-	c.data[ck] = cachedList{
+	c.data[q.key()] = cachedList{
 		items:    items,
 		total:    total,
 		cachedAt: time.Now(),
@@ -705,6 +1359,16 @@ func (s *server) collectHealth() (healthPayload, error) {
 		}
 	}
 
+	if s.pages != nil {
+		entries, hits, misses := s.pages.stats()
+		hp.SSRCacheEntries = entries
+		hp.SSRCacheHits = hits
+		hp.SSRCacheMisses = misses
+		if total := hits + misses; total > 0 {
+			hp.SSRCacheHitRate = float64(hits) / float64(total)
+		}
+	}
+
 	hp.GoVersion = runtime.Version()
 	if exePath, err := os.Executable(); err == nil {
 		if info, err := os.Stat(exePath); err == nil {
@@ -719,6 +1383,20 @@ func (s *server) collectHealth() (healthPayload, error) {
 	return hp, nil
 }
 
+// adminHealth is the health payload plus the security counters and route
+// stats, which the public /health leaves out.
+func (s *server) adminHealth(c *gin.Context) {
+	payload, err := s.collectHealth()
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errHealthUnavailable, err)
+		return
+	}
+	sec := s.security.snapshot(time.Now())
+	payload.Security = &sec
+	payload.Routes = s.routeStats.snapshot(time.Now())
+	c.JSON(http.StatusOK, payload)
+}
+
 func (s *server) ensureAuthSchema(ctx context.Context) error {
 	_, err := s.db.ExecContext(ctx, `
 		CREATE EXTENSION IF NOT EXISTS pgcrypto;
@@ -735,21 +1413,20 @@ func (s *server) ensureAuthSchema(ctx context.Context) error {
 			expires_at TIMESTAMPTZ NOT NULL,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_hash TEXT;
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMPTZ;
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE sessions ADD COLUMN IF NOT EXISTS remember BOOLEAN NOT NULL DEFAULT FALSE;
+		-- sessions from before tokens were hashed used their id as the cookie
+		DELETE FROM sessions WHERE token_hash IS NULL OR absolute_expires_at IS NULL;
 		CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions(token_hash);
+		CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
 	`)
 	return err
 }
 
-func deriveKey(secret string) []byte {
-	if secret == "" {
-		secret = "selfecho-imap-secret"
-		fmt.Println("warn: imapSecret/IMAP_SECRET 未设置，使用默认密钥，请在生产环境配置")
-	}
-	sum := sha256.Sum256([]byte(secret))
-	return sum[:]
-}
-
 func encryptSecret(key []byte, plaintext string) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -759,7 +1436,12 @@ func encryptSecret(key []byte, plaintext string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// values written before the nonce was randomized still open: the nonce
+	// travels with the ciphertext
 	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
 	ct := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
 	return base64.StdEncoding.EncodeToString(ct), nil
 }
@@ -805,8 +1487,7 @@ func (s *server) createUser(ctx context.Context, username, password, role string
 	if err != nil {
 		return err
 	}
-	_, err = s.db.ExecContext(ctx, `INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3) ON CONFLICT (username) DO NOTHING`, username, pwHash, role)
-	return err
+	return s.users.CreateUser(ctx, username, pwHash, role)
 }
 
 func (s *server) ensureInitialAdmin(ctx context.Context) error {
@@ -845,6 +1526,12 @@ func (s *server) ensureImapSchema(ctx context.Context) error {
 		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS use_starttls BOOLEAN NOT NULL DEFAULT FALSE;
 		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS last_uid BIGINT NOT NULL DEFAULT 0;
 		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS last_uidvalidity BIGINT NOT NULL DEFAULT 0;
+		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS ca_cert TEXT NOT NULL DEFAULT '';
+		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS insecure_skip_verify BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS connect_timeout_seconds INT NOT NULL DEFAULT 0;
+		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS read_timeout_seconds INT NOT NULL DEFAULT 0;
+		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS retain_messages INT NOT NULL DEFAULT 0;
+		ALTER TABLE imap_accounts ADD COLUMN IF NOT EXISTS retain_days INT NOT NULL DEFAULT 0;
 
 		CREATE TABLE IF NOT EXISTS imap_messages (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -861,6 +1548,14 @@ func (s *server) ensureImapSchema(ctx context.Context) error {
 			UNIQUE(account_id, uid, uidvalidity)
 		);
 		CREATE INDEX IF NOT EXISTS idx_imap_messages_acc_date ON imap_messages(account_id, msg_date DESC);
+		ALTER TABLE imap_messages ADD COLUMN IF NOT EXISTS snippet TEXT;
+		ALTER TABLE imap_messages ADD COLUMN IF NOT EXISTS message_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE imap_messages ADD COLUMN IF NOT EXISTS in_reply_to TEXT NOT NULL DEFAULT '';
+		ALTER TABLE imap_messages ADD COLUMN IF NOT EXISTS refs TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE imap_messages ADD COLUMN IF NOT EXISTS thread_id TEXT;
+		ALTER TABLE imap_messages ADD COLUMN IF NOT EXISTS to_addr TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_imap_messages_msgid ON imap_messages(account_id, message_id) WHERE message_id <> '';
+		CREATE INDEX IF NOT EXISTS idx_imap_messages_thread ON imap_messages(account_id, thread_id);
 	`)
 	return err
 }
@@ -868,6 +1563,17 @@ func (s *server) ensureImapSchema(ctx context.Context) error {
 func (s *server) ensureArticleSchema(ctx context.Context) error {
 	_, err := s.db.ExecContext(ctx, `
 		ALTER TABLE articles ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'post';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS meta_description TEXT NOT NULL DEFAULT '';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS lang TEXT NOT NULL DEFAULT '';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS excerpt TEXT;
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS social JSONB NOT NULL DEFAULT '{}';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS meta JSONB NOT NULL DEFAULT '{}';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS translation_of UUID REFERENCES articles(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_articles_translation_of ON articles(translation_of);
+		CREATE INDEX IF NOT EXISTS idx_articles_tags ON articles USING GIN (tags);
 		CREATE INDEX IF NOT EXISTS idx_articles_type_status ON articles(type, status);
 	`)
 	return err
@@ -875,67 +1581,80 @@ func (s *server) ensureArticleSchema(ctx context.Context) error {
 
 type sessionWithUser struct {
 	SessionID string
-	User      user
-	Expires   time.Time
+	// Token is the cookie value; only known right after createSession.
+	Token    string
+	User     user
+	Expires  time.Time
+	Remember bool
+}
+
+func sessionFromStore(ss store.Session) *sessionWithUser {
+	return &sessionWithUser{SessionID: ss.ID, User: userFromStore(ss.User), Expires: ss.Expires, Remember: ss.Remember}
+}
+
+func userFromStore(u store.User) user {
+	return user{ID: u.ID, Username: u.Username, PasswordHash: u.PasswordHash, Role: u.Role, CreatedAt: u.CreatedAt}
 }
 
-func (s *server) loadSession(ctx context.Context, sessionID string) (*sessionWithUser, error) {
-	var swu sessionWithUser
-	err := s.db.QueryRowContext(ctx, `
-		SELECT s.id, s.expires_at, u.id, u.username, u.password_hash, u.role, u.created_at
-		FROM sessions s
-		JOIN users u ON u.id = s.user_id
-		WHERE s.id = $1`, sessionID).
-		Scan(&swu.SessionID, &swu.Expires, &swu.User.ID, &swu.User.Username, &swu.User.PasswordHash, &swu.User.Role, &swu.User.CreatedAt)
+// loadSession looks up the session for a cookie token.
+func (s *server) loadSession(ctx context.Context, token string) (*sessionWithUser, error) {
+	ss, err := s.users.SessionByToken(ctx, hashSessionToken(token))
 	if err != nil {
 		return nil, err
 	}
-	return &swu, nil
+	return sessionFromStore(ss), nil
 }
 
-func (s *server) createSession(ctx context.Context, userID string) (*sessionWithUser, error) {
-	var swu sessionWithUser
-	err := s.db.QueryRowContext(ctx, `
-		INSERT INTO sessions (user_id, expires_at)
-		VALUES ($1, now() + ($2::int * interval '1 second'))
-		RETURNING id, expires_at`, userID, int(sessionTTL.Seconds())).
-		Scan(&swu.SessionID, &swu.Expires)
+func (s *server) createSession(ctx context.Context, userID string, remember bool) (*sessionWithUser, error) {
+	token, err := newSessionToken()
 	if err != nil {
 		return nil, err
 	}
-	// load user
-	err = s.db.QueryRowContext(ctx, `
-		SELECT id, username, password_hash, role, created_at FROM users WHERE id=$1`, userID).
-		Scan(&swu.User.ID, &swu.User.Username, &swu.User.PasswordHash, &swu.User.Role, &swu.User.CreatedAt)
+	ttl, maxAge := s.sessions.lifetime(remember)
+	ss, err := s.users.CreateSession(ctx, userID, hashSessionToken(token), ttl, maxAge, remember)
 	if err != nil {
 		return nil, err
 	}
-	return &swu, nil
+	swu := sessionFromStore(ss)
+	swu.Token = token
+	return swu, nil
 }
 
 func (s *server) deleteSession(ctx context.Context, sessionID string) {
-	s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id=$1`, sessionID)
+	s.users.DeleteSession(ctx, sessionID)
 }
 
-func (s *server) setSessionCookie(c *gin.Context, sessionID string, expires time.Time) {
-	secure := c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
-	http.SetCookie(c.Writer, &http.Cookie{
+// setSessionCookie issues the session cookie. Only "remember me" sessions
+// get a persistent cookie; others end when the browser closes, or earlier
+// when the server-side session expires.
+func (s *server) setSessionCookie(c *gin.Context, swu *sessionWithUser, token string) {
+	cookie := &http.Cookie{
 		Name:     sessionCookieName,
-		Value:    sessionID,
-		Path:     "/",
-		Expires:  expires,
-		MaxAge:   int(time.Until(expires).Seconds()),
+		Value:    token,
+		Path:     s.cookiePath(),
 		HttpOnly: true,
-		Secure:   secure,
+		Secure:   s.isSecureRequest(c.Request),
 		SameSite: http.SameSiteLaxMode,
-	})
+	}
+	if swu.Remember {
+		cookie.Expires = swu.Expires
+		cookie.MaxAge = int(time.Until(swu.Expires).Seconds())
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+func (s *server) cookiePath() string {
+	if s.basePath == "" {
+		return "/"
+	}
+	return s.basePath
 }
 
 func (s *server) clearSessionCookie(c *gin.Context) {
 	http.SetCookie(c.Writer, &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    "",
-		Path:     "/",
+		Path:     s.cookiePath(),
 		MaxAge:   -1,
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
@@ -948,21 +1667,25 @@ func (s *server) ensureUser(c *gin.Context) (*user, bool) {
 			return &u, true
 		}
 	}
+	if token, ok := bearerToken(c); ok {
+		return s.ensureTokenUser(c, token)
+	}
 	cookie, err := c.Cookie(sessionCookieName)
 	if err != nil || cookie == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return nil, false
 	}
 	swu, err := s.loadSession(c.Request.Context(), cookie)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return nil, false
 	}
 	if time.Now().After(swu.Expires) {
 		s.deleteSession(c.Request.Context(), swu.SessionID)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "会话已过期"})
+		respondError(c, http.StatusUnauthorized, errSessionExpired)
 		return nil, false
 	}
+	s.refreshSession(c, cookie, swu)
 	c.Set(string(userContextKey), swu.User)
 	return &swu.User, true
 }
@@ -977,50 +1700,12 @@ func (s *server) requireAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// serveSPA serves the built Angular app directly from disk, falling back to index.html
-// for client-side routes, while keeping API/health 404s intact.
-func serveSPA(router *gin.Engine, staticDir string) {
-	if staticDir == "" {
-		return
-	}
-	dir := filepath.Clean(staticDir)
-	info, err := os.Stat(dir)
-	if err != nil || !info.IsDir() {
-		fmt.Printf("warn: 静态目录不存在，跳过静态文件服务: %s\n", dir)
-		return
+// resolveMediaDir anchors a relative mediaDir next to the config file.
+func resolveMediaDir(cfgPath, mediaDir string) string {
+	if mediaDir == "" || filepath.IsAbs(mediaDir) {
+		return mediaDir
 	}
-
-	indexPath := filepath.Join(dir, "index.html")
-	if _, err := os.Stat(indexPath); err != nil {
-		fmt.Printf("warn: index.html 不存在于静态目录 %s，跳过静态文件服务\n", dir)
-		return
-	}
-
-	router.NoRoute(func(c *gin.Context) {
-		path := c.Request.URL.Path
-		if strings.HasPrefix(path, "/api") || path == "/health" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-			return
-		}
-
-		rel := strings.TrimPrefix(path, "/")
-		rel = filepath.Clean(rel)
-		if rel == "." || rel == "/" {
-			c.File(indexPath)
-			return
-		}
-		fullPath := filepath.Join(dir, rel)
-		// prevent path traversal
-		if !strings.HasPrefix(fullPath, dir) {
-			c.File(indexPath)
-			return
-		}
-		if _, err := os.Stat(fullPath); err == nil {
-			c.File(fullPath)
-			return
-		}
-		c.File(indexPath)
-	})
+	return filepath.Join(filepath.Dir(cfgPath), mediaDir)
 }
 
 func resolveStaticDir(cfgPath, staticDir string) string {
@@ -1084,7 +1769,7 @@ func resolveStaticDir(cfgPath, staticDir string) string {
 
 	if best.dir != "" {
 		if staticDir != "" && filepath.Clean(staticDir) != best.dir {
-			fmt.Printf("info: staticDir=%s resolved to %s\n", staticDir, best.dir)
+			infof("staticDir=%s resolved to %s\n", staticDir, best.dir)
 		}
 		return best.dir
 	}
@@ -1093,49 +1778,27 @@ func resolveStaticDir(cfgPath, staticDir string) string {
 }
 
 func (s *server) listArchives(c *gin.Context) {
-	ctx := c.Request.Context()
-	rows, err := s.db.QueryContext(ctx, `SELECT id, name, COALESCE(description, ''), created_at FROM archives ORDER BY name`)
+	rows, err := s.archives.ListArchives(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询归档失败"})
+		respondError(c, http.StatusInternalServerError, errQueryArchivesFailed)
 		return
 	}
-	defer rows.Close()
-
 	var result []archive
-	for rows.Next() {
-		var a archive
-		if err := rows.Scan(&a.ID, &a.Name, &a.Description, &a.CreatedAt); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "解析归档数据失败"})
-			return
-		}
-		result = append(result, a)
+	for _, r := range rows {
+		result = append(result, archiveFromRow(r))
 	}
 	c.JSON(http.StatusOK, result)
 }
 
 func (s *server) listCategories(c *gin.Context) {
-	ctx := c.Request.Context()
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT COALESCE(ar.name, '未分类') AS name, COUNT(*) AS count
-		FROM articles art
-		LEFT JOIN archives ar ON ar.id = art.archive_id
-		WHERE art.status = 'published' AND art.type = 'post'
-		GROUP BY COALESCE(ar.name, '未分类')
-		ORDER BY count DESC, name ASC`)
+	rows, err := s.archives.ListCategories(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询分类失败"})
+		respondError(c, http.StatusInternalServerError, errQueryCategoriesFailed)
 		return
 	}
-	defer rows.Close()
-
 	var items []categorySummary
-	for rows.Next() {
-		var cs categorySummary
-		if err := rows.Scan(&cs.Name, &cs.Count); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "解析分类数据失败"})
-			return
-		}
-		items = append(items, cs)
+	for _, r := range rows {
+		items = append(items, categorySummary{Name: r.Name, Count: r.Count})
 	}
 	c.JSON(http.StatusOK, items)
 }
@@ -1150,6 +1813,17 @@ func (s *server) listArticles(c *gin.Context) {
 	typeFilter := strings.TrimSpace(c.Query("type"))
 	compact := c.Query("compact") == "1" || strings.EqualFold(c.Query("fields"), "compact")
 	slugFilter := strings.TrimSpace(c.Query("slug"))
+	authorFilter := strings.TrimSpace(c.Query("author"))
+	langFilter, ok := normalizeLang(c.Query("lang"))
+	if !ok {
+		respondError(c, http.StatusBadRequest, errInvalidLanguage)
+		return
+	}
+	meta, err := metaFilter(c.Request.URL.Query())
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidMeta, err)
+		return
+	}
 
 	// 未指定 status 或请求非 published 的数据时，需要鉴权
 	if statusFilter == "" || statusFilter != "published" {
@@ -1161,13 +1835,13 @@ func (s *server) listArticles(c *gin.Context) {
 	if typeFilter == "" && statusFilter == "published" {
 		typeFilter = "post"
 	}
-	if typeFilter != "" && typeFilter != "post" && typeFilter != "memo" && typeFilter != "all" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "type 只能是 post 或 memo"})
+	if typeFilter != "" && typeFilter != "all" && !validArticleType(typeFilter) {
+		respondError(c, http.StatusBadRequest, errInvalidType)
 		return
 	}
 
 	page := 1
-	limit := 6
+	limit := s.siteSettings().PostsPerPage
 	if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
 		page = p
 	}
@@ -1179,144 +1853,249 @@ func (s *server) listArticles(c *gin.Context) {
 		limit = 0
 	}
 
-	var total int
-	whereParts := []string{}
-	args := []any{}
-	argPos := 1
-	if statusFilter != "" {
-		whereParts = append(whereParts, fmt.Sprintf("art.status = $%d", argPos))
-		args = append(args, statusFilter)
-		argPos++
+	// anonymous readers only see unlisted posts when asking for one by slug
+	authed := statusFilter != "published" || s.hasSession(c)
+	listedOnly := !authed && slugFilter == ""
+
+	q := listQuery{
+		status: statusFilter, archive: archiveFilter, typ: typeFilter, slug: slugFilter, lang: langFilter,
+		author: authorFilter, meta: metaFilterKey(meta), page: page, limit: limit, listedOnly: listedOnly,
 	}
-	if slugFilter != "" {
-		whereParts = append(whereParts, fmt.Sprintf("art.slug = $%d", argPos))
-		args = append(args, slugFilter)
-		argPos++
+	if cached, ok := s.cache.get(q); ok {
+		respondArticles(c, s.serializeArticles(c, cached.items, authed, compact), usePaging, page, limit, cached.total)
+		return
 	}
-	if archiveFilter != "" {
-		whereParts = append(whereParts, fmt.Sprintf("COALESCE(ar.name, '') = $%d", argPos))
-		args = append(args, archiveFilter)
-		argPos++
+
+	rows, total, err := s.articles.ListArticles(ctx, store.ArticleFilter{
+		Status:  statusFilter,
+		Slug:    slugFilter,
+		Archive: archiveFilter,
+		Author:  authorFilter,
+		Type:    typeFilter,
+		Lang:    langFilter,
+		// articles without a language are in the site default
+		LangIncludesUnset: langFilter != "" && strings.EqualFold(langFilter, s.contentLang("")),
+		ListedOnly:        listedOnly,
+		Meta:              meta,
+		Page:              page,
+		Limit:             limit,
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
 	}
-	if typeFilter != "" && typeFilter != "all" {
-		whereParts = append(whereParts, fmt.Sprintf("art.type = $%d", argPos))
-		args = append(args, typeFilter)
-		argPos++
+
+	var result []article
+	for _, r := range rows {
+		a := articleFromRow(r)
+		s.openArticle(&a)
+		a.Footnotes = countFootnotes(a.BodyHTML)
+		if statusFilter == "published" {
+			a.BodyHTML = s.proxyImages(s.expandFileShortcodes(ctx, a.BodyHTML))
+		}
+		a.inLocation(s.siteLocation())
+		result = append(result, a)
 	}
-	whereSQL := ""
-	if len(whereParts) > 0 {
-		whereSQL = "WHERE " + strings.Join(whereParts, " AND ")
+	s.attachGalleries(ctx, result, statusFilter == "published")
+	if !usePaging {
+		total = len(result)
 	}
+	s.cache.set(q, result, total)
+	respondArticles(c, s.serializeArticles(c, result, authed, compact), usePaging, page, limit, total)
+}
 
-	if cached, ok := s.cache.get(statusFilter, archiveFilter, typeFilter, slugFilter, page, limit, compact); ok {
-		if usePaging {
-			c.Header("X-Total-Count", strconv.Itoa(cached.total))
-			c.Header("X-Page", strconv.Itoa(page))
-			c.Header("X-Limit", strconv.Itoa(limit))
-		}
-		c.JSON(http.StatusOK, cached.items)
-		return
+// respondArticles writes an /api/articles response. Unpaged lists carry no
+// page headers, but still honour ?envelope=1 as a single page.
+func respondArticles(c *gin.Context, items []article, usePaging bool, page, limit, total int) {
+	switch {
+	case usePaging:
+		respondPage(c, items, page, limit, total)
+	case wantsEnvelope(c):
+		respondPage(c, items, 1, total, total)
+	default:
+		c.JSON(http.StatusOK, items)
 	}
+}
 
-	if usePaging {
-		countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM articles art LEFT JOIN archives ar ON ar.id = art.archive_id %s`, whereSQL)
-		if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "统计文章数失败"})
-			return
-		}
+// serializeArticles prepares cached rows for one response: password bodies
+// are hidden from readers who haven't unlocked them and compact drops bodies
+// altogether. items is shared with the cache and never modified.
+func (s *server) serializeArticles(c *gin.Context, items []article, authed, compact bool) []article {
+	out := s.protectBodies(c, items, authed)
+	if !compact {
+		return out
 	}
+	return compactArticles(out)
+}
 
-	var rows *sql.Rows
-	var err error
-	selectBody := "art.body_md, art.body_html"
-	if compact {
-		selectBody = "'' AS body_md, '' AS body_html"
+func compactArticles(items []article) []article {
+	out := make([]article, len(items))
+	for i, a := range items {
+		a.BodyMD = ""
+		a.BodyHTML = ""
+		out[i] = a
 	}
+	return out
+}
 
-	if usePaging {
-		offset := (page - 1) * limit
-		query := fmt.Sprintf(`
-			SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status, %s,
-			       art.published_at, art.created_at, art.updated_at
-			FROM articles art
-			LEFT JOIN archives ar ON ar.id = art.archive_id
-			%s
-			ORDER BY art.created_at DESC
-			LIMIT $%d OFFSET $%d`, selectBody, whereSQL, argPos, argPos+1)
-		argsWithPage := append(args, limit, offset)
-		rows, err = s.db.QueryContext(ctx, query, argsWithPage...)
-	} else {
-		query := fmt.Sprintf(`
-			SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status, %s,
-			       art.published_at, art.created_at, art.updated_at
-			FROM articles art
-			LEFT JOIN archives ar ON ar.id = art.archive_id
-			%s
-			ORDER BY art.created_at DESC`, selectBody, whereSQL)
-		rows, err = s.db.QueryContext(ctx, query, args...)
+type articlePayload struct {
+	Title       string `json:"title"`
+	Slug        string `json:"slug"`
+	Archive     string `json:"archive"`
+	Status      string `json:"status"`
+	Type        string `json:"type"`
+	BodyMD      string `json:"bodyMd"`
+	BodyHTML    string `json:"bodyHtml"`
+	PublishedAt string `json:"publishedAt"`
+	// Description and Tags are optional so editors that predate them don't
+	// clear them on save.
+	Description *string   `json:"metaDescription"`
+	Tags        *[]string `json:"tags"`
+	// Lang is a BCP 47 tag ("" = site default); TranslationOf links the
+	// article to its original ("" unlinks). Both keep the stored value when nil.
+	Lang          *string `json:"lang"`
+	TranslationOf *string `json:"translationOf"`
+	// Social overrides the link preview; nil keeps the stored card.
+	Social *socialCard `json:"social"`
+	// Meta replaces the custom fields as a whole; nil keeps the stored ones.
+	Meta *customFields `json:"meta"`
+	// Authors lists usernames in byline order, the first being the primary
+	// author. nil keeps the stored byline (the creator, for new articles).
+	Authors *[]string `json:"authors"`
+	// Visibility is public, unlisted or password; Password is only sent to
+	// set or change it. Both keep the stored value when nil.
+	Visibility *string `json:"visibility"`
+	Password   *string `json:"password"`
+}
+
+// passwordHash hashes a new post password, or returns nil to keep the stored
+// one.
+func (p articlePayload) passwordHash() (any, error) {
+	if p.Password == nil || *p.Password == "" {
+		return nil, nil
+	}
+	return hashPassword(*p.Password)
+}
+
+// social returns the card as JSON for the JSONB column, or nil to keep the
+// stored one.
+func (p articlePayload) social() any {
+	if p.Social == nil {
+		return nil
+	}
+	raw, _ := json.Marshal(p.Social)
+	return string(raw)
+}
+
+// meta returns the custom fields as JSON for the JSONB column, or nil to keep
+// the stored ones.
+func (p articlePayload) meta() any {
+	if p.Meta == nil {
+		return nil
+	}
+	if *p.Meta == nil {
+		return "{}"
+	}
+	raw, _ := json.Marshal(p.Meta)
+	return string(raw)
+}
+
+// lang returns the normalized language tag, or nil to keep the stored one.
+func (p articlePayload) lang() *string {
+	if p.Lang == nil {
+		return nil
+	}
+	l, _ := normalizeLang(*p.Lang)
+	return &l
+}
+
+// translationOf resolves TranslationOf to the group's original. set is false
+// when the payload leaves the link untouched; root is nil when it unlinks.
+func (s *server) translationOf(ctx context.Context, p articlePayload, self string) (set bool, root any, err error) {
+	if p.TranslationOf == nil {
+		return false, nil, nil
 	}
+	ref := strings.TrimSpace(*p.TranslationOf)
+	if ref == "" {
+		return true, nil, nil
+	}
+	id, ok, err := s.translationRoot(ctx, ref)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询文章失败"})
-		return
+		return false, nil, err
 	}
-	defer rows.Close()
+	if !ok || id == self {
+		return false, nil, newAPIError(errTranslationNotFound, ref)
+	}
+	return true, id, nil
+}
 
-	var result []article
-	for rows.Next() {
-		var a article
-		var archiveName sql.NullString
-		var publishedAt sql.NullTime
-		if err := rows.Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &archiveName, &a.Status, &a.BodyMD, &a.BodyHTML, &publishedAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "解析文章数据失败"})
-			return
-		}
-		if archiveName.Valid {
-			a.Archive = archiveName.String
-		}
-		if publishedAt.Valid {
-			a.PublishedAt = &publishedAt.Time
-		}
-		result = append(result, a)
+// tags returns the normalized tag list, or nil to keep the stored tags.
+func (p articlePayload) tags() any {
+	if p.Tags == nil {
+		return nil
 	}
-	if usePaging {
-		c.Header("X-Total-Count", strconv.Itoa(total))
-		c.Header("X-Page", strconv.Itoa(page))
-		c.Header("X-Limit", strconv.Itoa(limit))
-		s.cache.set(statusFilter, archiveFilter, typeFilter, slugFilter, page, limit, compact, result, total)
-	} else {
-		s.cache.set(statusFilter, archiveFilter, typeFilter, slugFilter, page, limit, compact, result, len(result))
+	return normalizeTags(*p.Tags)
+}
+
+// description returns the cleaned meta description, or nil to keep the stored one.
+func (p articlePayload) description() *string {
+	if p.Description == nil {
+		return nil
 	}
-	c.JSON(http.StatusOK, result)
+	d := slugmigrate.CleanSummary(*p.Description)
+	return &d
 }
 
-type articlePayload struct {
-	Title    string `json:"title"`
-	Slug     string `json:"slug"`
-	Archive  string `json:"archive"`
-	Status   string `json:"status"`
-	Type     string `json:"type"`
-	BodyMD   string `json:"bodyMd"`
-	BodyHTML string `json:"bodyHtml"`
+// inLocation converts the article timestamps so the API emits RFC3339 offsets
+// in the site timezone rather than whatever zone the driver returned.
+func (a *article) inLocation(loc *time.Location) {
+	a.CreatedAt = a.CreatedAt.In(loc)
+	a.UpdatedAt = a.UpdatedAt.In(loc)
+	if a.PublishedAt != nil {
+		t := a.PublishedAt.In(loc)
+		a.PublishedAt = &t
+	}
+}
+
+// resolvePublishedAt returns the publish time for a payload: drafts have none,
+// published articles use the supplied RFC3339 timestamp or now.
+func resolvePublishedAt(p articlePayload) (sql.NullTime, error) {
+	if p.Status != "published" {
+		return sql.NullTime{}, nil
+	}
+	raw := strings.TrimSpace(p.PublishedAt)
+	if raw == "" {
+		return sql.NullTime{Valid: true, Time: time.Now()}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return sql.NullTime{}, newAPIError(errInvalidPublishedAt, raw)
+	}
+	return sql.NullTime{Valid: true, Time: t}, nil
 }
 
 func (s *server) createArticle(c *gin.Context) {
 	ctx := c.Request.Context()
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
 	var payload articlePayload
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
 	if payload.Type == "" {
 		payload.Type = "post"
 	}
 	if err := validatePayload(payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
 		return
 	}
 
-	slug, err := makeSlug(payload.Title, payload.Slug)
+	slug, err := s.articleSlug(ctx, payload, "")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidSlug, err)
 		return
 	}
 	slugBase := slug
@@ -1325,37 +2104,71 @@ func (s *server) createArticle(c *gin.Context) {
 	if payload.Archive != "" {
 		id, err := s.ensureArchive(ctx, payload.Archive)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建归档失败"})
+			respondError(c, http.StatusInternalServerError, errCreateArchiveFailed)
 			return
 		}
 		archiveID = &id
 	}
-
-	var publishedAt sql.NullTime
-	if payload.Status == "published" {
-		publishedAt = sql.NullTime{Valid: true, Time: time.Now()}
+
+	publishedAt, err := resolvePublishedAt(payload)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidPublishedAt, err)
+		return
+	}
+
+	_, translationOf, err := s.translationOf(ctx, payload, "")
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errTranslationNotFound, err)
+		return
+	}
+	if err := s.checkVisibility(ctx, payload, ""); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	passHash, err := payload.passwordHash()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errCreateArticleFailed)
+		return
 	}
 
 	bodyHTML := strings.TrimSpace(payload.BodyHTML)
 	if bodyHTML == "" {
 		bodyHTML = renderMarkdown(payload.BodyMD)
 	}
+	authors, err := payloadAuthors(ctx, s.db, payload, u.ID)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidAuthors, err)
+		return
+	}
+	body, err := s.storeBody(payload.Status, payload.Visibility, payload.BodyMD, bodyHTML)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errCreateArticleFailed, err)
+		return
+	}
 
 	var createdID string
 	for attempt := 0; attempt < 3; attempt++ {
-		uniqueSlug, err := s.ensureUniqueSlug(ctx, slugBase, "")
+		var uniqueSlug string
+		uniqueSlug, err = s.ensureUniqueSlug(ctx, slugBase, "")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "slug 去重失败"})
+			respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
 			return
 		}
 		slug = uniqueSlug
 
-		err = s.db.QueryRowContext(
-			ctx,
-			`INSERT INTO articles (slug, title, body_md, body_html, status, archive_id, published_at, type) 
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
-			slug, payload.Title, payload.BodyMD, bodyHTML, payload.Status, archiveID, publishedAt, payload.Type,
-		).Scan(&createdID)
+		err = s.inTx(ctx, func(tx *sql.Tx) error {
+			err := tx.QueryRowContext(
+				ctx,
+				articleInsertSQL,
+				slug, payload.Title, body.md, body.html, payload.Status, archiveID, publishedAt, payload.Type, payload.description(), payload.tags(),
+				payload.lang(), translationOf, payload.social(), payload.Visibility, passHash, body.excerpt,
+				payload.meta(), authors[0],
+			).Scan(&createdID)
+			if err != nil {
+				return err
+			}
+			return s.enqueueWebhooks(ctx, tx, hookArticleCreated, articleHook{ID: createdID, Slug: slug, Title: payload.Title, Status: payload.Status})
+		})
 		if err == nil {
 			break
 		}
@@ -1364,33 +2177,47 @@ func (s *server) createArticle(c *gin.Context) {
 		}
 	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("创建文章失败: %v", err)})
+		respondErrorDetail(c, http.StatusBadRequest, errCreateArticleFailed, err)
 		return
 	}
+	// the primary author is already on the row; ensureCoauthorSchema
+	// backfills the byline if this fails
+	if err := setArticleAuthors(ctx, s.db, createdID, authors); err != nil {
+		warnf("设置文章作者失败: %v\n", err)
+	}
+	s.refreshSearchIndex(createdID)
+	s.refreshLinkGraph(createdID)
+	s.wakeOutbox()
+	s.publishFrom(c, eventArticleChanged, actionCreated, createdID, slug)
 	c.JSON(http.StatusCreated, gin.H{"id": createdID, "slug": slug})
-	s.cache.invalidateAll()
 }
 
 func (s *server) updateArticle(c *gin.Context) {
 	ctx := c.Request.Context()
-	id := c.Param("id")
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkEditLock(c, id) || !s.checkArticleAuthor(c, id) {
+		return
+	}
 
 	var payload articlePayload
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
 	if payload.Type == "" {
 		payload.Type = "post"
 	}
 	if err := validatePayload(payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
 		return
 	}
 
-	slug, err := makeSlug(payload.Title, payload.Slug)
+	slug, err := s.articleSlug(ctx, payload, id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidSlug, err)
 		return
 	}
 	slugBase := slug
@@ -1399,38 +2226,76 @@ func (s *server) updateArticle(c *gin.Context) {
 	if payload.Archive != "" {
 		aid, err := s.ensureArchive(ctx, payload.Archive)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建归档失败"})
+			respondError(c, http.StatusInternalServerError, errCreateArchiveFailed)
 			return
 		}
 		archiveID = &aid
 	}
 
-	var publishedAt sql.NullTime
-	if payload.Status == "published" {
-		publishedAt = sql.NullTime{Valid: true, Time: time.Now()}
+	publishedAt, err := resolvePublishedAt(payload)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidPublishedAt, err)
+		return
+	}
+
+	setTranslation, translationOf, err := s.translationOf(ctx, payload, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errTranslationNotFound, err)
+		return
+	}
+	if err := s.checkVisibility(ctx, payload, id); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	passHash, err := payload.passwordHash()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errUpdateArticleFailed)
+		return
 	}
 
 	bodyHTML := strings.TrimSpace(payload.BodyHTML)
 	if bodyHTML == "" {
 		bodyHTML = renderMarkdown(payload.BodyMD)
 	}
+	var authors []string
+	if payload.Authors != nil {
+		if authors, err = resolveAuthors(ctx, s.db, *payload.Authors); err != nil {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidAuthors, err)
+			return
+		}
+	}
+	body, err := s.updatedBody(ctx, s.db, id, payload.Status, payload.Visibility, payload.BodyMD, bodyHTML)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUpdateArticleFailed, err)
+		return
+	}
 
-	var res sql.Result
+	var affected int64
 	for attempt := 0; attempt < 3; attempt++ {
-		uniqueSlug, err := s.ensureUniqueSlug(ctx, slugBase, id)
+		var uniqueSlug string
+		uniqueSlug, err = s.ensureUniqueSlug(ctx, slugBase, id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "slug 去重失败"})
+			respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
 			return
 		}
 		slug = uniqueSlug
 
-		res, err = s.db.ExecContext(
-			ctx,
-			`UPDATE articles 
-			 SET title=$1, slug=$2, body_md=$3, body_html=$4, status=$5, archive_id=$6, published_at=$7, type=$8, updated_at=now()
-			 WHERE id=$9`,
-			payload.Title, slug, payload.BodyMD, bodyHTML, payload.Status, archiveID, publishedAt, payload.Type, id,
-		)
+		err = s.inTx(ctx, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(
+				ctx,
+				articleUpdateSQL,
+				payload.Title, slug, body.md, body.html, payload.Status, archiveID, publishedAt, payload.Type, id, payload.description(), payload.tags(),
+				payload.lang(), setTranslation, translationOf, payload.social(), payload.Visibility, passHash, body.excerpt,
+				payload.meta(),
+			)
+			if err != nil {
+				return err
+			}
+			if affected, _ = res.RowsAffected(); affected == 0 {
+				return nil
+			}
+			return s.enqueueWebhooks(ctx, tx, hookArticleUpdated, articleHook{ID: id, Slug: slug, Title: payload.Title, Status: payload.Status})
+		})
 		if err == nil {
 			break
 		}
@@ -1439,111 +2304,131 @@ func (s *server) updateArticle(c *gin.Context) {
 		}
 	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("更新文章失败: %v", err)})
+		respondErrorDetail(c, http.StatusBadRequest, errUpdateArticleFailed, err)
 		return
 	}
-	affected, _ := res.RowsAffected()
 	if affected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到文章"})
+		respondError(c, http.StatusNotFound, errArticleNotFound)
 		return
 	}
+	s.wakeOutbox()
+	if authors != nil {
+		if err := setArticleAuthors(ctx, s.db, id, authors); err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errUpdateArticleFailed, err)
+			return
+		}
+	}
+	s.refreshSearchIndex(id)
+	s.refreshLinkGraph(id)
+	s.publishFrom(c, eventArticleChanged, actionUpdated, id, slug)
 	c.Status(http.StatusNoContent)
-	s.cache.invalidateAll()
 }
 
 func (s *server) deleteArticle(c *gin.Context) {
 	ctx := c.Request.Context()
-	id := c.Param("id")
-	res, err := s.db.ExecContext(ctx, `DELETE FROM articles WHERE id=$1`, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除文章失败"})
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
 		return
 	}
-	affected, _ := res.RowsAffected()
-	if affected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到文章"})
+	if !s.checkArticleAuthor(c, id) {
+		return
+	}
+	var hook articleHook
+	var photos []string
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if photos, err = galleryFiles(ctx, tx, id); err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, `DELETE FROM articles WHERE id=$1 RETURNING id, slug, title, status`, id).
+			Scan(&hook.ID, &hook.Slug, &hook.Title, &hook.Status)
+		if err != nil {
+			return err
+		}
+		return s.enqueueWebhooks(ctx, tx, hookArticleDeleted, hook)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(c, http.StatusNotFound, errArticleNotFound)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, errDeleteArticleFailed)
 		return
 	}
+	s.removeGalleryFiles(photos)
+	s.wakeOutbox()
+	s.publishFrom(c, eventArticleChanged, actionDeleted, id, hook.Slug)
 	c.Status(http.StatusNoContent)
-	s.cache.invalidateAll()
 }
 
 func (s *server) createArchive(c *gin.Context) {
-	ctx := c.Request.Context()
 	var payload archivePayload
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
-	if strings.TrimSpace(payload.Name) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "名称不能为空"})
+	if err := payload.validate(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
 		return
 	}
-	var id string
-	err := s.db.QueryRowContext(ctx, `INSERT INTO archives (name, description) VALUES ($1, $2) RETURNING id`, payload.Name, payload.Description).
-		Scan(&id)
+	id, err := s.archives.CreateArchive(c.Request.Context(), payload.Name, payload.Description)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("创建归档失败: %v", err)})
+		respondErrorDetail(c, http.StatusBadRequest, errCreateArchiveFailed, err)
 		return
 	}
+	s.publishFrom(c, eventArchiveChanged, actionCreated, id, "")
 	c.JSON(http.StatusCreated, gin.H{"id": id})
-	s.cache.invalidateAll()
 }
 
 func (s *server) updateArchive(c *gin.Context) {
-	ctx := c.Request.Context()
 	id := c.Param("id")
 	var payload archivePayload
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
-	if strings.TrimSpace(payload.Name) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "名称不能为空"})
+	if err := payload.validate(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
 		return
 	}
-	res, err := s.db.ExecContext(ctx, `UPDATE archives SET name=$1, description=$2, created_at=created_at WHERE id=$3`, payload.Name, payload.Description, id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("更新归档失败: %v", err)})
+	err := s.archives.UpdateArchive(c.Request.Context(), id, payload.Name, payload.Description)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errArchiveNotFound)
 		return
 	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到归档"})
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errUpdateArchiveFailed, err)
 		return
 	}
+	s.publishFrom(c, eventArchiveChanged, actionUpdated, id, "")
 	c.Status(http.StatusNoContent)
-	s.cache.invalidateAll()
 }
 
+// deleteArchive removes an archive; ?reassignTo= moves its articles to another
+// archive, otherwise they become uncategorized.
 func (s *server) deleteArchive(c *gin.Context) {
-	ctx := c.Request.Context()
 	id := c.Param("id")
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "启动事务失败"})
+	res, err := s.archives.DeleteArchive(c.Request.Context(), id, strings.TrimSpace(c.Query("reassignTo")))
+	switch {
+	case errors.Is(err, store.ErrReassignToSelf):
+		respondError(c, http.StatusBadRequest, errReassignToSelf)
 		return
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, `UPDATE articles SET archive_id=NULL WHERE archive_id=$1`, id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "清理文章关联失败"})
+	case errors.Is(err, store.ErrReassignTargetNotFound):
+		respondError(c, http.StatusBadRequest, errReassignTargetNotFound)
 		return
-	}
-	res, err := tx.ExecContext(ctx, `DELETE FROM archives WHERE id=$1`, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除归档失败"})
+	case errors.Is(err, store.ErrNotFound):
+		respondError(c, http.StatusNotFound, errArchiveNotFound)
 		return
-	}
-	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "未找到归档"})
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errDeleteArchiveFailed)
 		return
 	}
-	if err := tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "提交事务失败"})
-		return
+	resp := gin.H{"affected": res.Moved}
+	if res.Target != nil {
+		resp["reassignedTo"] = gin.H{"id": res.Target.ID, "name": res.Target.Name}
 	}
-	c.Status(http.StatusNoContent)
-	s.cache.invalidateAll()
+	s.publishFrom(c, eventArchiveChanged, actionDeleted, id, "")
+	c.JSON(http.StatusOK, resp)
 }
 
 func (s *server) login(c *gin.Context) {
@@ -1551,35 +2436,45 @@ func (s *server) login(c *gin.Context) {
 	var payload struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Remember bool   `json:"remember"`
 	}
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
 	payload.Username = strings.TrimSpace(payload.Username)
-	if payload.Username == "" || payload.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名和密码不能为空"})
+	var v validator
+	v.check(payload.Username != "", "username", errCredentialsRequired)
+	v.check(payload.Password != "", "password", errCredentialsRequired)
+	v.check(maxRunes(payload.Username, maxUsernameRunes), "username", errUsernameTooLong)
+	v.check(len(payload.Password) <= maxLoginPassword, "password", errPasswordTooLong)
+	if err := v.err(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
 		return
 	}
 
-	var u user
-	err := s.db.QueryRowContext(ctx, `SELECT id, username, password_hash, role, created_at FROM users WHERE username=$1`, payload.Username).
-		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+	u, err := s.users.UserByUsername(ctx, payload.Username)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryUsersFailed, err)
+		return
+	}
+	if err != nil || u.Role == roleService {
+		s.security.loginFailed()
+		respondError(c, http.StatusUnauthorized, errInvalidCredentials)
 		return
 	}
 	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(payload.Password)) != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+		s.security.loginFailed()
+		respondError(c, http.StatusUnauthorized, errInvalidCredentials)
 		return
 	}
 
-	swu, err := s.createSession(ctx, u.ID)
+	swu, err := s.createSession(ctx, u.ID, payload.Remember)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建会话失败"})
+		respondError(c, http.StatusInternalServerError, errCreateSessionFailed)
 		return
 	}
-	s.setSessionCookie(c, swu.SessionID, swu.Expires)
+	s.setSessionCookie(c, swu, swu.Token)
 	c.JSON(http.StatusOK, gin.H{"username": swu.User.Username, "role": swu.User.Role})
 }
 
@@ -1587,7 +2482,9 @@ func (s *server) logout(c *gin.Context) {
 	ctx := c.Request.Context()
 	cookie, err := c.Cookie(sessionCookieName)
 	if err == nil && cookie != "" {
-		s.deleteSession(ctx, cookie)
+		if swu, err := s.loadSession(ctx, cookie); err == nil {
+			s.deleteSession(ctx, swu.SessionID)
+		}
 	}
 	s.clearSessionCookie(c)
 	c.Status(http.StatusNoContent)
@@ -1605,19 +2502,23 @@ func (s *server) me(c *gin.Context) {
 }
 
 func (s *server) listImapAccounts(c *gin.Context) {
-	rows, err := s.db.Query(`SELECT id, host, port, username, use_ssl, use_starttls, last_uid, last_uidvalidity, created_at FROM imap_accounts ORDER BY created_at DESC`)
+	rows, err := s.mail.ListImapAccounts(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询 IMAP 账号失败"})
+		respondError(c, http.StatusInternalServerError, errQueryImapAccountsFailed)
 		return
 	}
-	defer rows.Close()
-	var items []imapAccount
-	for rows.Next() {
-		var a imapAccount
-		if err := rows.Scan(&a.ID, &a.Host, &a.Port, &a.Username, &a.UseSSL, &a.UseStartTLS, &a.LastUID, &a.LastUIDValidity, &a.CreatedAt); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "解析 IMAP 账号失败"})
+	var stats map[string]store.ImapCacheStats
+	if callerAllows(c, scopeAdmin) {
+		if stats, err = s.mail.ImapCacheStats(c.Request.Context()); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryImapAccountsFailed)
 			return
 		}
+	}
+	var items []imapAccount
+	for _, r := range rows {
+		a := imapAccountFromStore(r)
+		a.Password = ""
+		a.CachedMessages, a.CacheBytes = stats[a.ID].Messages, stats[a.ID].Bytes
 		items = append(items, a)
 	}
 	c.JSON(http.StatusOK, items)
@@ -1631,9 +2532,16 @@ func (s *server) createImapAccount(c *gin.Context) {
 		Password    string `json:"password"`
 		UseSSL      bool   `json:"useSsl"`
 		UseStartTLS bool   `json:"useStartTls"`
+
+		CACert                string `json:"caCert"`
+		InsecureSkipVerify    bool   `json:"insecureSkipVerify"`
+		ConnectTimeoutSeconds int    `json:"connectTimeoutSeconds"`
+		ReadTimeoutSeconds    int    `json:"readTimeoutSeconds"`
+
+		imapRetention
 	}
 	if err := c.BindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体格式错误"})
+		respondError(c, http.StatusBadRequest, errInvalidBody)
 		return
 	}
 	payload.Host = strings.TrimSpace(payload.Host)
@@ -1641,29 +2549,50 @@ func (s *server) createImapAccount(c *gin.Context) {
 	if payload.Port == 0 {
 		payload.Port = 993
 	}
-	if payload.Host == "" || payload.Username == "" || payload.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "地址、用户名、密码不能为空"})
+	var v validator
+	v.check(payload.Host != "", "host", errImapFieldsRequired)
+	v.check(payload.Username != "", "username", errImapFieldsRequired)
+	v.check(payload.Password != "", "password", errImapFieldsRequired)
+	v.check(payload.Port > 0 && payload.Port <= 65535, "port", errInvalidPort)
+	v.check(validImapTimeout(payload.ConnectTimeoutSeconds), "connectTimeoutSeconds", errInvalidImapTimeout)
+	v.check(validImapTimeout(payload.ReadTimeoutSeconds), "readTimeoutSeconds", errInvalidImapTimeout)
+	payload.CACert = strings.TrimSpace(payload.CACert)
+	v.check(validImapCA(payload.CACert), "caCert", errInvalidImapCA)
+	payload.imapRetention.check(&v)
+	if err := v.err(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
 		return
 	}
 
-	secret := payload.Password
-	if s.imapKey != nil {
-		enc, err := encryptSecret(s.imapKey, payload.Password)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("加密密码失败: %v", err)})
-			return
-		}
-		secret = enc
+	secret, err := s.imap.seal(payload.Password)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errEncryptPasswordFailed, err)
+		return
 	}
 
-	_, err := s.db.Exec(
-		`INSERT INTO imap_accounts (host, port, username, password, use_ssl, use_starttls) VALUES ($1, $2, $3, $4, $5, $6)`,
-		payload.Host, payload.Port, payload.Username, secret, payload.UseSSL, payload.UseStartTLS,
-	)
+	err = s.mail.CreateImapAccount(c.Request.Context(), store.ImapAccount{
+		Host:        payload.Host,
+		Port:        payload.Port,
+		Username:    payload.Username,
+		Password:    secret,
+		UseSSL:      payload.UseSSL,
+		UseStartTLS: payload.UseStartTLS,
+
+		CACert:                payload.CACert,
+		InsecureSkipVerify:    payload.InsecureSkipVerify,
+		ConnectTimeoutSeconds: payload.ConnectTimeoutSeconds,
+		ReadTimeoutSeconds:    payload.ReadTimeoutSeconds,
+
+		RetainMessages: payload.RetainMessages,
+		RetainDays:     payload.RetainDays,
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存 IMAP 账号失败: %v", err)})
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveImapAccountFailed, err)
 		return
 	}
+	if payload.InsecureSkipVerify {
+		warnf("新增的 IMAP 账户 %s@%s 关闭了证书校验\n", payload.Username, payload.Host)
+	}
 	c.Status(http.StatusCreated)
 }
 
@@ -1677,17 +2606,17 @@ func (s *server) diagnoseImapFetch(c *gin.Context) {
 
 	acc, err := s.pickImapAccount(ctx, accountID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
 		return
 	}
 	if acc == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到 IMAP 账号，请先创建"})
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
 		return
 	}
 
 	msgs, err := fetchImapMessages(ctx, *acc, limit)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("即时拉取失败: %v", err)})
+		respondErrorDetail(c, http.StatusBadGateway, errImapFetchFailed, err)
 		return
 	}
 
@@ -1715,29 +2644,31 @@ func (s *server) rebuildImapCache(c *gin.Context) {
 
 	acc, err := s.pickImapAccount(ctx, accountID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
 		return
 	}
 	if acc == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到 IMAP 账号，请先创建"})
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
 		return
 	}
 
 	if _, err := s.db.ExecContext(ctx, `DELETE FROM imap_messages WHERE account_id=$1`, acc.ID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("清理缓存失败: %v", err)})
+		respondErrorDetail(c, http.StatusInternalServerError, errImapClearCacheFailed, err)
 		return
 	}
 	if _, err := s.db.ExecContext(ctx, `UPDATE imap_accounts SET last_uid=$1, last_uidvalidity=$2 WHERE id=$3`, 0, 0, acc.ID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("重置账号状态失败: %v", err)})
+		respondErrorDetail(c, http.StatusInternalServerError, errImapResetAccountFailed, err)
 		return
 	}
 	acc.LastUID = 0
 	acc.LastUIDValidity = 0
 
 	if err := s.syncImapAccount(ctx, acc, limit, true); err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("重建失败: %v", err)})
+		s.publishImapSync(acc.ID, err)
+		respondErrorDetail(c, http.StatusBadGateway, errImapRebuildFailed, err)
 		return
 	}
+	s.publishImapSync(acc.ID, nil)
 
 	total, _ := s.countCachedMessages(ctx, acc.ID)
 	c.JSON(http.StatusOK, gin.H{"count": total})
@@ -1756,104 +2687,94 @@ func (s *server) listImapMessages(c *gin.Context) {
 	}
 	offset := (page - 1) * limit
 	fresh := strings.EqualFold(strings.TrimSpace(c.Query("fresh")), "true") || strings.TrimSpace(c.Query("fresh")) == "1"
+	// the snippet quotes the message body, so only imap:read callers get it
+	respond := func(msgs []imapMessage, total int) {
+		if !callerAllows(c, scopeImapRead) {
+			for i := range msgs {
+				msgs[i].Snippet = ""
+			}
+		}
+		respondPage(c, msgs, page, limit, total)
+	}
 
 	acc, err := s.pickImapAccount(ctx, accountID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
 		return
 	}
 	if acc == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到 IMAP 账号，请先创建"})
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
 		return
 	}
 
 	if fresh {
 		if err := s.syncImapAccount(ctx, acc, limit, true); err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("同步 IMAP 失败: %v", err)})
+			respondErrorDetail(c, http.StatusBadGateway, errImapSyncFailed, err)
 			return
 		}
 	}
 
 	msgs, err := s.readCachedMessages(ctx, acc.ID, limit, offset)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取邮件失败: %v", err)})
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
 		return
 	}
 	msgs = dedupeByUID(msgs)
 	total, _ := s.countCachedMessages(ctx, acc.ID)
 	if len(msgs) > 0 {
-		c.Header("X-Total-Count", strconv.Itoa(total))
 		if !fresh {
 			s.syncImapAccountAsync(*acc, 50, false)
 		}
-		c.JSON(http.StatusOK, msgs)
+		respond(msgs, total)
 		return
 	}
 
 	if err := s.syncImapAccount(ctx, acc, 50, fresh); err != nil {
-		fmt.Printf("warn: 同步 IMAP 失败: %v\n", err)
+		warnf("同步 IMAP 失败: %v\n", err)
 	}
 
 	msgs, err = s.readCachedMessages(ctx, acc.ID, limit, offset)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取邮件失败: %v", err)})
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
 		return
 	}
 	total, _ = s.countCachedMessages(ctx, acc.ID)
 	if len(msgs) == 0 {
 		// fallback 直接拉取
 		if fresh, ferr := fetchImapMessages(ctx, *acc, limit); ferr == nil {
-			c.Header("X-Total-Count", strconv.Itoa(len(fresh)))
-			c.JSON(http.StatusOK, fresh)
+			respond(fresh, len(fresh))
 			return
 		}
 	}
-	c.Header("X-Total-Count", strconv.Itoa(total))
-	c.JSON(http.StatusOK, msgs)
+	respond(msgs, total)
 }
 
 func (s *server) pickImapAccount(ctx context.Context, id string) (*imapAccount, error) {
-	var row *sql.Row
-	if id != "" {
-		row = s.db.QueryRowContext(ctx, `SELECT id, host, port, username, password, use_ssl, use_starttls, last_uid, last_uidvalidity, created_at FROM imap_accounts WHERE id=$1`, id)
-	} else {
-		row = s.db.QueryRowContext(ctx, `SELECT id, host, port, username, password, use_ssl, use_starttls, last_uid, last_uidvalidity, created_at FROM imap_accounts ORDER BY created_at DESC LIMIT 1`)
-	}
-	var acc imapAccount
-	if err := row.Scan(&acc.ID, &acc.Host, &acc.Port, &acc.Username, &acc.Password, &acc.UseSSL, &acc.UseStartTLS, &acc.LastUID, &acc.LastUIDValidity, &acc.CreatedAt); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	row, err := s.mail.ImapAccount(ctx, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	if s.imapKey != nil && acc.Password != "" {
-		if dec, err := decryptSecret(s.imapKey, acc.Password); err == nil {
+	acc := imapAccountFromStore(row)
+	if acc.Password != "" {
+		if dec, err := s.imap.open(acc.Password); err == nil {
 			acc.Password = dec
+		} else {
+			warnf("解密 IMAP 密码失败，按明文使用: %v\n", err)
 		}
 	}
 	return &acc, nil
 }
 
 func fetchImapMessages(ctx context.Context, acc imapAccount, limit int) ([]imapMessage, error) {
-	address := fmt.Sprintf("%s:%d", acc.Host, acc.Port)
-	var c *client.Client
-	var err error
-	if acc.UseSSL {
-		c, err = client.DialTLS(address, nil)
-	} else {
-		c, err = client.Dial(address)
-	}
+	c, err := dialImap(acc)
 	if err != nil {
 		return nil, err
 	}
 	defer c.Logout()
 
-	if !acc.UseSSL && acc.UseStartTLS {
-		if err := c.StartTLS(nil); err != nil {
-			return nil, err
-		}
-	}
-
 	if err := c.Login(acc.Username, acc.Password); err != nil {
 		return nil, err
 	}
@@ -1910,17 +2831,17 @@ func (s *server) getImapMessage(c *gin.Context) {
 	uidStr := c.Param("uid")
 	uid64, err := strconv.ParseUint(uidStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "uid 非法"})
+		respondError(c, http.StatusBadRequest, errInvalidUID)
 		return
 	}
 
 	acc, err := s.pickImapAccount(ctx, accountID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
 		return
 	}
 	if acc == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "未找到 IMAP 账号，请先创建"})
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
 		return
 	}
 
@@ -1934,7 +2855,7 @@ func (s *server) getImapMessage(c *gin.Context) {
 	lastErr := err
 
 	if err := s.syncImapAccount(ctx, acc, 20, false); err != nil {
-		fmt.Printf("warn: 同步 IMAP 失败: %v\n", err)
+		warnf("同步 IMAP 失败: %v\n", err)
 		lastErr = err
 	}
 
@@ -1947,34 +2868,26 @@ func (s *server) getImapMessage(c *gin.Context) {
 		lastErr = err
 	}
 
-	if direct, derr := fetchImapMessageDetail(ctx, *acc, uint32(uid64)); derr == nil {
+	var direct imapMessage
+	derr := s.imapSync.run(ctx, acc.ID, func() (err error) {
+		direct, err = fetchImapMessageDetail(ctx, *acc, uint32(uid64))
+		return err
+	})
+	if derr == nil {
 		c.JSON(http.StatusOK, direct)
 		return
-	} else {
-		lastErr = derr
 	}
-	c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("加载邮件失败: %v", lastErr)})
+	lastErr = derr
+	respondErrorDetail(c, http.StatusInternalServerError, errLoadMessageFailed, lastErr)
 }
 
 func fetchImapMessageDetail(ctx context.Context, acc imapAccount, uid uint32) (imapMessage, error) {
-	address := fmt.Sprintf("%s:%d", acc.Host, acc.Port)
-	var c *client.Client
-	var err error
-	if acc.UseSSL {
-		c, err = client.DialTLS(address, nil)
-	} else {
-		c, err = client.Dial(address)
-	}
+	c, err := dialImap(acc)
 	if err != nil {
 		return imapMessage{}, err
 	}
 	defer c.Logout()
 
-	if !acc.UseSSL && acc.UseStartTLS {
-		if err := c.StartTLS(nil); err != nil {
-			return imapMessage{}, err
-		}
-	}
 	if err := c.Login(acc.Username, acc.Password); err != nil {
 		return imapMessage{}, err
 	}
@@ -1982,55 +2895,30 @@ func fetchImapMessageDetail(ctx context.Context, acc imapAccount, uid uint32) (i
 		return imapMessage{}, err
 	}
 
-	seqset := new(imap.SeqSet)
-	seqset.AddNum(uid)
-	section := &imap.BodySectionName{}
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, section.FetchItem()}
-	ch := make(chan *imap.Message, 1)
-	done := make(chan error, 1)
-	go func() {
-		done <- c.UidFetch(seqset, items, ch)
-	}()
-	var msg *imap.Message
-	for m := range ch {
-		msg = m
-	}
-	if err := <-done; err != nil {
+	msgs, err := uidFetchMessages(c, []uint32{uid})
+	if err != nil {
 		return imapMessage{}, err
 	}
-	ok := msg != nil
-	if !ok || msg == nil || msg.Envelope == nil {
+	msg, ok := msgs[uid]
+	if !ok {
 		return imapMessage{}, errors.New("邮件不存在")
 	}
-
-	body, _ := parseBody(msg.GetBody(section))
-
-	var fromAddr string
-	if len(msg.Envelope.From) > 0 {
-		fromAddr = safeUTF8(msg.Envelope.From[0].Address())
-	}
-	date := msg.Envelope.Date.Format(time.RFC3339)
-
-	return imapMessage{
-		UID:     msg.Uid,
-		Subject: safeUTF8(msg.Envelope.Subject),
-		From:    fromAddr,
-		Date:    date,
-		Flags:   msg.Flags,
-		Snippet: "",
-		Body:    safeUTF8(body),
-	}, nil
+	return msg, nil
 }
 
-func parseBody(body io.Reader) (string, error) {
+// parseBody extracts a message's body: the HTML part for display, falling
+// back to the escaped text part, and a plain text version of it.
+func parseBody(body io.Reader) (mailBody, error) {
 	if body == nil {
-		return "", nil
+		return mailBody{}, nil
 	}
 	mr, err := mail.CreateReader(body)
 	if err != nil {
 		b, _ := io.ReadAll(body)
-		return escapeText(string(b)), nil
+		text := safeUTF8(string(b))
+		return mailBody{HTML: escapeText(text), Plain: strings.TrimSpace(text)}, nil
 	}
+	refs, _ := mr.Header.MsgIDList("References")
 	var htmlBody string
 	var textBody string
 	for {
@@ -2039,7 +2927,7 @@ func parseBody(body io.Reader) (string, error) {
 			break
 		}
 		if err != nil {
-			return "", err
+			return mailBody{}, err
 		}
 		if ih, ok := p.Header.(*mail.InlineHeader); ok {
 			mt, _, _ := ih.ContentType()
@@ -2051,56 +2939,58 @@ func parseBody(body io.Reader) (string, error) {
 			}
 		}
 	}
-	if htmlBody != "" {
-		return htmlBody, nil
+	out := mailBody{References: refs}
+	switch {
+	case htmlBody != "" && textBody != "":
+		out.HTML, out.Plain = htmlBody, strings.TrimSpace(textBody)
+	case htmlBody != "":
+		out.HTML, out.Plain = htmlBody, htmlToPlain(htmlBody)
+	case textBody != "":
+		out.HTML, out.Plain = escapeText(textBody), strings.TrimSpace(textBody)
 	}
-	if textBody != "" {
-		return escapeText(textBody), nil
-	}
-	return "", nil
+	return out, nil
 }
 
-func uidFetchMessageDetail(c *client.Client, uid uint32) (imapMessage, error) {
-	if c == nil {
-		return imapMessage{}, errors.New("imap client is nil")
-	}
-	seqset := new(imap.SeqSet)
-	seqset.AddNum(uid)
+// uidFetchMessages fetches envelope, flags and full body of uids in one UID
+// FETCH. Messages the server did not return are missing from the map.
+func uidFetchMessages(c *client.Client, uids []uint32) (map[uint32]imapMessage, error) {
+	set := new(imap.SeqSet)
+	set.AddNum(uids...)
 	section := &imap.BodySectionName{}
 	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, section.FetchItem()}
-	ch := make(chan *imap.Message, 1)
+	ch := make(chan *imap.Message, len(uids))
 	done := make(chan error, 1)
 	go func() {
-		done <- c.UidFetch(seqset, items, ch)
+		done <- c.UidFetch(set, items, ch)
 	}()
-	var msg *imap.Message
+	out := make(map[uint32]imapMessage, len(uids))
 	for m := range ch {
-		msg = m
-	}
-	if err := <-done; err != nil {
-		return imapMessage{}, err
-	}
-	if msg == nil || msg.Envelope == nil {
-		return imapMessage{}, errors.New("邮件不存在")
+		if m == nil || m.Envelope == nil {
+			continue
+		}
+		out[m.Uid] = imapMessageFromFetch(m, section)
 	}
+	return out, <-done
+}
 
-	body, _ := parseBody(msg.GetBody(section))
-
+func imapMessageFromFetch(m *imap.Message, section *imap.BodySectionName) imapMessage {
+	body, _ := parseBody(m.GetBody(section))
 	var fromAddr string
-	if len(msg.Envelope.From) > 0 {
-		fromAddr = safeUTF8(msg.Envelope.From[0].Address())
+	if len(m.Envelope.From) > 0 {
+		fromAddr = safeUTF8(m.Envelope.From[0].Address())
 	}
-	date := msg.Envelope.Date.Format(time.RFC3339)
-
 	return imapMessage{
-		UID:     msg.Uid,
-		Subject: safeUTF8(msg.Envelope.Subject),
+		UID:     m.Uid,
+		Subject: safeUTF8(m.Envelope.Subject),
 		From:    fromAddr,
-		Date:    date,
-		Flags:   msg.Flags,
-		Snippet: "",
-		Body:    safeUTF8(body),
-	}, nil
+		Date:    m.Envelope.Date.Format(time.RFC3339),
+		Flags:   m.Flags,
+		Snippet: mailSnippet(body.Plain),
+		Body:    body.HTML,
+		plain:   body.Plain,
+
+		references: body.References,
+	}
 }
 
 func escapeText(s string) string {
@@ -2121,35 +3011,43 @@ func decodePart(ih *mail.InlineHeader, r io.Reader) ([]byte, error) {
 	return io.ReadAll(r)
 }
 
+// syncImapAccountAsync syncs in the background unless a background sync
+// for the account is already queued or running.
 func (s *server) syncImapAccountAsync(acc imapAccount, limit int, force bool) {
+	if !s.imapSync.claimBackground(acc.ID) {
+		return
+	}
 	go func(a imapAccount) {
+		defer s.imapSync.releaseBackground(a.ID)
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
-		if err := s.syncImapAccount(ctx, &a, limit, force); err != nil {
-			fmt.Printf("warn: 同步 IMAP 失败: %v\n", err)
+		err := s.syncImapAccount(ctx, &a, limit, force)
+		if errors.Is(err, errImapSyncBusy) {
+			return
+		}
+		if err != nil {
+			warnf("同步 IMAP 失败: %v\n", err)
+			s.recordFailedJob(jobImapSync, imapSyncJob{AccountID: a.ID, Limit: limit, Force: force}, err)
 		}
+		s.publishImapSync(a.ID, err)
 	}(acc)
 }
 
+// syncImapAccount caches the newest limit messages of acc's inbox, waiting
+// its turn in s.imapSync.
 func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit int, force bool) error {
-	address := fmt.Sprintf("%s:%d", acc.Host, acc.Port)
-	var c *client.Client
-	var err error
-	if acc.UseSSL {
-		c, err = client.DialTLS(address, nil)
-	} else {
-		c, err = client.Dial(address)
-	}
+	return s.imapSync.run(ctx, acc.ID, func() error {
+		return s.syncImapAccountLocked(ctx, acc, limit, force)
+	})
+}
+
+func (s *server) syncImapAccountLocked(ctx context.Context, acc *imapAccount, limit int, force bool) error {
+	c, err := dialImap(*acc)
 	if err != nil {
 		return err
 	}
 	defer c.Logout()
 
-	if !acc.UseSSL && acc.UseStartTLS {
-		if err := c.StartTLS(nil); err != nil {
-			return err
-		}
-	}
 	if err := c.Login(acc.Username, acc.Password); err != nil {
 		return err
 	}
@@ -2166,18 +3064,30 @@ func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit in
 		return nil
 	}
 
-	from := uint32(1)
-	if mbox.Messages > uint32(limit) {
-		from = mbox.Messages - uint32(limit) + 1
-	}
+	// an incremental sync lists only UIDs past the last one cached; a first,
+	// forced or post-UIDVALIDITY-reset sync lists the newest limit messages
 	set := new(imap.SeqSet)
-	set.AddRange(from, mbox.Messages)
+	incremental := !force && acc.LastUID > 0 && acc.LastUIDValidity == mbox.UidValidity
+	if incremental {
+		set.AddRange(acc.LastUID+1, 0)
+	} else {
+		from := uint32(1)
+		if mbox.Messages > uint32(limit) {
+			from = mbox.Messages - uint32(limit) + 1
+		}
+		set.AddRange(from, mbox.Messages)
+	}
 
 	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid}
 	messages := make(chan *imap.Message, limit)
-	if err := c.Fetch(set, items, messages); err != nil {
-		return err
-	}
+	listed := make(chan error, 1)
+	go func() {
+		if incremental {
+			listed <- c.UidFetch(set, items, messages)
+		} else {
+			listed <- c.Fetch(set, items, messages)
+		}
+	}()
 
 	type row struct {
 		uid uint32
@@ -2190,25 +3100,20 @@ func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit in
 		}
 		fetched = append(fetched, row{uid: msg.Uid, msg: msg})
 	}
+	if err := <-listed; err != nil {
+		return err
+	}
 	sort.Slice(fetched, func(i, j int) bool {
 		return fetched[i].uid < fetched[j].uid
 	})
-
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-	// reset on uidvalidity change (except initial 0)
-	if acc.LastUIDValidity != 0 && acc.LastUIDValidity != mbox.UidValidity {
-		if _, err := tx.ExecContext(ctx, `DELETE FROM imap_messages WHERE account_id=$1`, acc.ID); err != nil {
-			return err
-		}
-		acc.LastUID = 0
+	if len(fetched) > limit {
+		fetched = fetched[len(fetched)-limit:]
 	}
 
+	// reset on uidvalidity change (except initial 0)
+	reset := acc.LastUIDValidity != 0 && acc.LastUIDValidity != mbox.UidValidity
 	lastSeen := acc.LastUID
-	if force {
+	if force || reset {
 		lastSeen = 0
 	}
 	var maxUID uint32 = lastSeen
@@ -2223,57 +3128,45 @@ func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit in
 		}
 	}
 
-	section := &imap.BodySectionName{}
-	bodyItems := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, section.FetchItem()}
+	// bodies of all new messages come in one UID FETCH on this connection;
+	// whatever it missed is asked for once more, again as one batch
 	bodies := make(map[uint32]imapMessage)
-	bodyFailures := make(map[uint32]string)
 	var bodyFetchErr error
-	if len(toUpsert) > 0 {
-		uidset := new(imap.SeqSet)
+	for attempt := 0; attempt < 2; attempt++ {
+		var missing []uint32
 		for _, r := range toUpsert {
-			uidset.AddNum(r.uid)
-		}
-		ch := make(chan *imap.Message, len(toUpsert))
-		done := make(chan error, 1)
-		go func() {
-			done <- c.UidFetch(uidset, bodyItems, ch)
-		}()
-		for m := range ch {
-			if m == nil || m.Envelope == nil {
-				continue
-			}
-			body, _ := parseBody(m.GetBody(section))
-			var fromAddr string
-			if len(m.Envelope.From) > 0 {
-				fromAddr = safeUTF8(m.Envelope.From[0].Address())
-			}
-			date := m.Envelope.Date.Format(time.RFC3339)
-			bodies[m.Uid] = imapMessage{
-				UID:     m.Uid,
-				Subject: safeUTF8(m.Envelope.Subject),
-				From:    fromAddr,
-				Date:    date,
-				Flags:   m.Flags,
-				Snippet: "",
-				Body:    safeUTF8(body),
+			if _, ok := bodies[r.uid]; !ok {
+				missing = append(missing, r.uid)
 			}
 		}
-		if err := <-done; err != nil {
-			bodyFetchErr = err
+		if len(missing) == 0 {
+			break
+		}
+		got, err := uidFetchMessages(c, missing)
+		for uid, m := range got {
+			bodies[uid] = m
 		}
+		bodyFetchErr = err
+	}
 
-		if bodyFetchErr != nil || len(bodies) != len(toUpsert) {
-			for _, r := range toUpsert {
-				if _, ok := bodies[r.uid]; ok {
-					continue
-				}
-				detail, err := uidFetchMessageDetail(c, r.uid)
-				if err != nil {
-					bodyFailures[r.uid] = err.Error()
-					continue
-				}
-				bodies[r.uid] = detail
-			}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if reset {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM imap_messages WHERE account_id=$1`, acc.ID); err != nil {
+			return err
+		}
+	}
+
+	// rules act on mail that arrived since the last sync, never on what a
+	// first, forced or reset sync pulls into the cache
+	var rules []imapRule
+	var outcome ruleOutcome
+	if !force && !reset && acc.LastUIDValidity != 0 {
+		if rules, err = s.imapRules(ctx, acc.ID); err != nil {
+			return err
 		}
 	}
 
@@ -2292,9 +3185,7 @@ func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit in
 				date = msg.Envelope.Date.Format(time.RFC3339)
 			}
 			reason := "邮件正文不可用"
-			if perUID, ok := bodyFailures[uid]; ok && strings.TrimSpace(perUID) != "" {
-				reason = perUID
-			} else if bodyFetchErr != nil {
+			if bodyFetchErr != nil {
 				reason = fmt.Sprintf("%v", bodyFetchErr)
 			}
 			detail = imapMessage{
@@ -2322,16 +3213,36 @@ func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit in
 		subj := safeUTF8(detail.Subject)
 		from := safeUTF8(detail.From)
 		body := safeUTF8(detail.Body)
+		plain := safeUTF8(detail.plain)
+		to := envelopeRecipients(msg.Envelope)
+		refs := mailRefsOf(msg.Envelope, detail.references)
+		threadID, err := resolveImapThread(ctx, tx, acc.ID, refs)
+		if err != nil {
+			return err
+		}
 		_, err = tx.ExecContext(ctx, `
-			INSERT INTO imap_messages (account_id, uid, uidvalidity, subject, from_addr, msg_date, flags, body_html, body_plain)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+			INSERT INTO imap_messages (account_id, uid, uidvalidity, subject, from_addr, msg_date, flags, body_html, body_plain, snippet,
+			                           message_id, in_reply_to, refs, thread_id, to_addr)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13::text[],NULLIF($14, ''),$15)
 			ON CONFLICT (account_id, uid, uidvalidity) DO UPDATE
 			SET subject=EXCLUDED.subject, from_addr=EXCLUDED.from_addr, msg_date=EXCLUDED.msg_date,
-			    flags=EXCLUDED.flags, body_html=EXCLUDED.body_html, body_plain=EXCLUDED.body_plain
-		`, acc.ID, uid, mbox.UidValidity, subj, from, msgTime, flags, body, "")
+			    flags=EXCLUDED.flags, body_html=EXCLUDED.body_html, body_plain=EXCLUDED.body_plain, snippet=EXCLUDED.snippet,
+			    message_id=EXCLUDED.message_id, in_reply_to=EXCLUDED.in_reply_to, refs=EXCLUDED.refs, thread_id=EXCLUDED.thread_id,
+			    to_addr=EXCLUDED.to_addr
+		`, acc.ID, uid, mbox.UidValidity, subj, from, msgTime, flags, body, plain, mailSnippet(plain),
+			refs.MessageID, refs.InReplyTo, refs.References, threadID, to)
 		if err != nil {
 			return err
 		}
+		if len(rules) > 0 {
+			job := mailRuleJob{AccountID: acc.ID, UID: uid, Subject: subj, From: from, To: to, Text: plain, HTML: body}
+			if msgTime != nil {
+				job.Date = *msgTime
+			}
+			if err := s.applyRules(ctx, tx, rules, &outcome, job); err != nil {
+				return err
+			}
+		}
 	}
 	if _, err := tx.ExecContext(ctx, `UPDATE imap_accounts SET last_uid=$1, last_uidvalidity=$2 WHERE id=$3`, maxUID, mbox.UidValidity, acc.ID); err != nil {
 		return err
@@ -2341,85 +3252,77 @@ func (s *server) syncImapAccount(ctx context.Context, acc *imapAccount, limit in
 	}
 	acc.LastUID = maxUID
 	acc.LastUIDValidity = mbox.UidValidity
+	if outcome.queued > 0 {
+		s.wakeOutbox()
+	}
+	s.applyServerRules(ctx, c, acc.ID, outcome)
 	return nil
 }
 
 func (s *server) readCachedMessages(ctx context.Context, accountID string, limit, offset int) ([]imapMessage, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT uid, subject, from_addr, msg_date, flags, body_html, body_plain
-		FROM (
-			SELECT DISTINCT ON (uid) uid, subject, from_addr, msg_date, flags, body_html, body_plain, created_at
-			FROM imap_messages
-			WHERE account_id=$1
-			ORDER BY uid, uidvalidity DESC, created_at DESC
-		) t
-		ORDER BY msg_date DESC NULLS LAST, uid DESC
-		LIMIT $2 OFFSET $3`, accountID, limit, offset)
+	rows, err := s.mail.ImapMessages(ctx, accountID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 	var res []imapMessage
-	for rows.Next() {
-		var m imapMessage
-		var flags string
-		var msgDate sql.NullTime
-		var bodyHTML, bodyPlain sql.NullString
-		if err := rows.Scan(&m.UID, &m.Subject, &m.From, &msgDate, &flags, &bodyHTML, &bodyPlain); err != nil {
-			return nil, err
-		}
-		if msgDate.Valid {
-			m.Date = msgDate.Time.Format(time.RFC3339)
-		}
-		if flags != "" {
-			m.Flags = strings.Fields(flags)
-		}
-		if bodyHTML.Valid && bodyHTML.String != "" {
-			m.Body = bodyHTML.String
-		} else if bodyPlain.Valid && bodyPlain.String != "" {
-			m.Body = escapeText(bodyPlain.String)
-		}
-		res = append(res, m)
+	for _, r := range rows {
+		res = append(res, s.imapMessageFromStore(r))
 	}
 	return res, nil
 }
 
 func (s *server) countCachedMessages(ctx context.Context, accountID string) (int, error) {
-	var total int
-	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT uid) FROM imap_messages WHERE account_id=$1`, accountID).Scan(&total)
-	return total, err
+	return s.mail.CountImapMessages(ctx, accountID)
 }
 
 func (s *server) readCachedMessage(ctx context.Context, accountID string, uid uint32) (imapMessage, error) {
-	var m imapMessage
-	var flags string
-	var msgDate sql.NullTime
-	var bodyHTML, bodyPlain sql.NullString
-	err := s.db.QueryRowContext(ctx, `
-		SELECT uid, subject, from_addr, msg_date, flags, body_html, body_plain
-		FROM imap_messages
-		WHERE account_id=$1 AND uid=$2
-		ORDER BY uidvalidity DESC, created_at DESC
-		LIMIT 1
-	`, accountID, uid).Scan(&m.UID, &m.Subject, &m.From, &msgDate, &flags, &bodyHTML, &bodyPlain)
+	r, err := s.mail.ImapMessage(ctx, accountID, uid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return m, errors.New("未找到邮件")
+		if errors.Is(err, store.ErrNotFound) {
+			return imapMessage{}, errors.New("未找到邮件")
 		}
-		return m, err
+		return imapMessage{}, err
+	}
+	return s.imapMessageFromStore(r), nil
+}
+
+func imapAccountFromStore(r store.ImapAccount) imapAccount {
+	return imapAccount{
+		ID:              r.ID,
+		Host:            r.Host,
+		Port:            r.Port,
+		Username:        r.Username,
+		Password:        r.Password,
+		UseSSL:          r.UseSSL,
+		UseStartTLS:     r.UseStartTLS,
+		LastUID:         r.LastUID,
+		LastUIDValidity: r.LastUIDValidity,
+		CreatedAt:       r.CreatedAt,
+
+		CACert:                r.CACert,
+		InsecureSkipVerify:    r.InsecureSkipVerify,
+		ConnectTimeoutSeconds: r.ConnectTimeoutSeconds,
+		ReadTimeoutSeconds:    r.ReadTimeoutSeconds,
+
+		RetainMessages: r.RetainMessages,
+		RetainDays:     r.RetainDays,
 	}
-	if msgDate.Valid {
-		m.Date = msgDate.Time.Format(time.RFC3339)
+}
+
+func (s *server) imapMessageFromStore(r store.ImapMessage) imapMessage {
+	m := imapMessage{UID: r.UID, Subject: r.Subject, From: r.From, Snippet: r.Snippet, ThreadID: r.ThreadID, plain: r.BodyPlain}
+	if r.Date != nil {
+		m.Date = r.Date.In(s.siteLocation()).Format(time.RFC3339)
 	}
-	if flags != "" {
-		m.Flags = strings.Fields(flags)
+	if r.Flags != "" {
+		m.Flags = strings.Fields(r.Flags)
 	}
-	if bodyHTML.Valid && bodyHTML.String != "" {
-		m.Body = bodyHTML.String
-	} else if bodyPlain.Valid && bodyPlain.String != "" {
-		m.Body = escapeText(bodyPlain.String)
+	if r.BodyHTML != "" {
+		m.Body = r.BodyHTML
+	} else if r.BodyPlain != "" {
+		m.Body = escapeText(r.BodyPlain)
 	}
-	return m, nil
+	return m
 }
 
 func dedupeByUID(msgs []imapMessage) []imapMessage {
@@ -2435,9 +3338,59 @@ func dedupeByUID(msgs []imapMessage) []imapMessage {
 	return res
 }
 
+// articleInsertSQL creates an article from an articlePayload and returns
+// its id. Arguments: slug, title, body_md, body_html, status, archive_id,
+// published_at, type, description(), tags(), lang(), translation root,
+// social(), visibility, password hash, excerpt, meta(), author user id.
+const articleInsertSQL = `
+	INSERT INTO articles (slug, title, body_md, body_html, status, archive_id, published_at, type, meta_description, tags, lang, translation_of, social,
+	                      visibility, password_hash, excerpt, meta, author_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, ''), COALESCE($10::text[], '{}'), COALESCE($11, ''), $12::uuid,
+	        COALESCE($13::jsonb, '{}'), COALESCE($14, 'public'), COALESCE($15, ''), $16, COALESCE($17::jsonb, '{}'), $18::uuid) RETURNING id`
+
+// articleUpdateSQL overwrites article $9 from an articlePayload; nil optional
+// fields keep their stored values. Arguments: title, slug, body_md,
+// body_html, status, archive_id, published_at, type, id, description(),
+// tags(), lang(), whether to set the translation, translation root,
+// social(), visibility, password hash, excerpt, meta().
+const articleUpdateSQL = `
+	UPDATE articles
+	SET title=$1, slug=$2, body_md=$3, body_html=$4, status=$5, archive_id=$6, published_at=$7, type=$8,
+	    meta_description=COALESCE($10, meta_description), tags=COALESCE($11::text[], tags), lang=COALESCE($12, lang),
+	    translation_of=CASE WHEN $13 THEN $14::uuid ELSE translation_of END, social=COALESCE($15::jsonb, social),
+	    visibility=COALESCE($16, visibility), password_hash=COALESCE($17, password_hash), excerpt=$18,
+	    meta=COALESCE($19::jsonb, meta), updated_at=now()
+	WHERE id=$9`
+
+// sqlQuerier is what *sql.DB and *sql.Tx have in common, for helpers that
+// run either on their own or inside a caller's transaction.
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// inTx runs fn in a transaction, committing if it returns nil.
+func (s *server) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (s *server) ensureArchive(ctx context.Context, name string) (string, error) {
+	return upsertArchive(ctx, s.db, name)
+}
+
+// upsertArchive returns the id of the archive called name, creating it.
+func upsertArchive(ctx context.Context, q sqlQuerier, name string) (string, error) {
 	var id string
-	err := s.db.QueryRowContext(
+	err := q.QueryRowContext(
 		ctx,
 		`INSERT INTO archives (name) VALUES ($1)
 		 ON CONFLICT (name) DO UPDATE SET name=EXCLUDED.name
@@ -2448,21 +3401,43 @@ func (s *server) ensureArchive(ctx context.Context, name string) (string, error)
 }
 
 func validatePayload(p articlePayload) error {
-	if p.Title == "" {
-		return errors.New("标题不能为空")
-	}
-	if p.Status != "draft" && p.Status != "published" {
-		return errors.New("status 只能是 draft 或 published")
-	}
+	var v validator
 	if p.Type == "" {
 		p.Type = "post"
 	}
-	if p.Type != "post" && p.Type != "memo" {
-		return errors.New("type 只能是 post 或 memo")
+	title := strings.TrimSpace(p.Title)
+	if p.Type == "note" {
+		validateNote(&v, p)
+	} else if v.check(title != "", "title", errTitleRequired) {
+		v.check(maxRunes(title, maxTitleRunes), "title", errTitleTooLong)
 	}
-	return nil
-}
-
-func renderMarkdown(md string) string {
-	return string(blackfriday.Run([]byte(md)))
+	if p.Slug != "" {
+		v.check(validSlugInput(strings.TrimSpace(p.Slug)), "slug", errInvalidSlug, p.Slug)
+	}
+	v.check(p.Status == "draft" || p.Status == "published", "status", errInvalidStatus)
+	v.check(validArticleType(p.Type), "type", errInvalidType)
+	if p.Tags != nil {
+		v.check(countTags(*p.Tags) <= maxArticleTags, "tags", errTooManyTags)
+	}
+	v.check(maxRunes(p.Archive, maxArchiveNameRunes), "archive", errNameTooLong)
+	if p.Lang != nil {
+		_, ok := normalizeLang(*p.Lang)
+		v.check(ok, "lang", errInvalidLanguage, *p.Lang)
+	}
+	if p.Social != nil {
+		v.wrap("social", p.Social.normalize())
+	}
+	if p.Meta != nil {
+		p.Meta.validate(&v)
+	}
+	if p.Authors != nil {
+		v.check(len(*p.Authors) <= maxArticleAuthors, "authors", errInvalidAuthors, "too many")
+	}
+	if p.Visibility != nil {
+		v.check(validVisibility(*p.Visibility), "visibility", errInvalidVisibility, *p.Visibility)
+	}
+	if p.Password != nil {
+		v.check(len(*p.Password) <= maxPostPassword, "password", errPasswordTooLong)
+	}
+	return v.err()
 }
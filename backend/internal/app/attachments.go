@@ -0,0 +1,352 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMaxUploadMB  = 50
+	maxAttachmentName   = 200
+	attachmentCSP       = "default-src 'none'; sandbox"
+	attachmentShortcode = "file"
+)
+
+// attachment is a downloadable file uploaded by an editor. Files live outside
+// mediaDir so every download goes through /files and is counted.
+type attachment struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Downloads   int64     `json:"downloads"`
+	CreatedAt   time.Time `json:"createdAt"`
+	URL         string    `json:"url"`
+}
+
+type attachmentStore struct {
+	dir      string
+	maxBytes int64
+}
+
+// newAttachmentStore returns nil when filesDir is unset; the API then answers
+// every attachment call with errAttachmentsDisabled.
+func newAttachmentStore(filesDir string, maxUploadMB int) *attachmentStore {
+	if filesDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filesDir, 0o755); err != nil {
+		warnf("创建附件目录失败，附件功能未启用: %v\n", err)
+		return nil
+	}
+	if maxUploadMB <= 0 {
+		maxUploadMB = defaultMaxUploadMB
+	}
+	return &attachmentStore{dir: filesDir, maxBytes: int64(maxUploadMB) << 20}
+}
+
+func (st *attachmentStore) path(id string) string {
+	return filepath.Join(st.dir, id)
+}
+
+func (s *server) ensureAttachmentSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS attachments (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			filename TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			sha256 TEXT NOT NULL,
+			downloads BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
+
+// cleanFilename keeps the base name of an upload, minus control characters
+// and quotes that would break Content-Disposition.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "." || name == "" {
+		name = "file"
+	}
+	if utf8.RuneCountInString(name) > maxAttachmentName {
+		ext := filepath.Ext(name)
+		if utf8.RuneCountInString(ext) > 16 {
+			ext = ""
+		}
+		name = string([]rune(strings.TrimSuffix(name, ext))[:maxAttachmentName-utf8.RuneCountInString(ext)]) + ext
+	}
+	return name
+}
+
+// humanSize formats a byte count the way download blocks show it.
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+func (s *server) attachmentURL(a attachment) string {
+	return s.basePath + "/files/" + a.ID + "/" + urlPathEscape(a.Filename)
+}
+
+func (s *server) uploadAttachment(c *gin.Context) {
+	if s.files == nil {
+		respondError(c, http.StatusServiceUnavailable, errAttachmentsDisabled)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, s.files.maxBytes+1<<20)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, errFileTooLarge)
+			return
+		}
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	defer file.Close()
+	if header.Size > s.files.maxBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+
+	tmp, err := os.CreateTemp(s.files.dir, ".upload-*")
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(file, s.files.maxBytes+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	if size > s.files.maxBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+
+	a := attachment{
+		Filename: cleanFilename(header.Filename),
+		Size:     size,
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+	}
+	a.ContentType, _, _ = mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if a.ContentType == "" || a.ContentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(a.Filename)); byExt != "" {
+			a.ContentType = byExt
+		} else {
+			a.ContentType = "application/octet-stream"
+		}
+	}
+
+	ctx := c.Request.Context()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errBeginTxFailed)
+		return
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO attachments (filename, content_type, size, sha256) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, a.Filename, a.ContentType, a.Size, a.SHA256).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), s.files.path(a.ID)); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		os.Remove(s.files.path(a.ID))
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	a.CreatedAt = a.CreatedAt.In(s.siteLocation())
+	a.URL = s.attachmentURL(a)
+	c.JSON(http.StatusCreated, a)
+}
+
+func (s *server) listAttachments(c *gin.Context) {
+	rows, err := s.readQuery(c.Request.Context(), `
+		SELECT id, filename, content_type, size, sha256, downloads, created_at
+		FROM attachments
+		ORDER BY created_at DESC`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryAttachmentsFailed)
+		return
+	}
+	defer rows.Close()
+	items := []attachment{}
+	for rows.Next() {
+		var a attachment
+		if err := rows.Scan(&a.ID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.Downloads, &a.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryAttachmentsFailed)
+			return
+		}
+		a.CreatedAt = a.CreatedAt.In(s.siteLocation())
+		a.URL = s.attachmentURL(a)
+		items = append(items, a)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryAttachmentsFailed)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (s *server) deleteAttachment(c *gin.Context) {
+	id, ok := idParam(c, "id", errAttachmentNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM attachments WHERE id=$1`, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryAttachmentsFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errAttachmentNotFound)
+		return
+	}
+	if s.files != nil {
+		if err := os.Remove(s.files.path(id)); err != nil && !os.IsNotExist(err) {
+			warnf("删除附件文件失败 %s: %v\n", id, err)
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// downloadAttachment serves /files/:id/:name. The name segment is cosmetic;
+// only the id is looked up. Ranged continuations are not counted again.
+func (s *server) downloadAttachment(c *gin.Context) {
+	if s.files == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if !store.ValidID(id) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	var a attachment
+	err := s.readQueryRow(ctx, `SELECT id, filename, content_type FROM attachments WHERE id=$1`, id).
+		Scan(&a.ID, &a.Filename, &a.ContentType)
+	if err != nil {
+		if !errorsIsNotFound(err) {
+			warnf("查询附件失败: %v\n", err)
+		}
+		c.Status(http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(s.files.path(a.ID))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if rng := c.GetHeader("Range"); c.Request.Method == http.MethodGet && (rng == "" || strings.HasPrefix(rng, "bytes=0-")) {
+		if _, err := s.db.ExecContext(ctx, `UPDATE attachments SET downloads = downloads + 1 WHERE id=$1`, a.ID); err != nil {
+			warnf("更新附件下载次数失败: %v\n", err)
+		}
+	}
+	c.Header("Content-Type", a.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	c.Header("Content-Security-Policy", attachmentCSP)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=0")
+	http.ServeContent(c.Writer, c.Request, a.Filename, info.ModTime(), f)
+}
+
+// fileShortcodeRe matches [[file:<uuid>]], optionally alone in a paragraph so
+// the block replaces the whole <p>.
+var fileShortcodeRe = regexp.MustCompile(`(?:<p>\s*)?\[\[` + attachmentShortcode + `:([0-9a-fA-F-]{36})\]\](?:\s*</p>)?`)
+
+// expandFileShortcodes turns [[file:<id>]] in rendered HTML into download
+// blocks. Unknown ids are left as written so the editor notices.
+func (s *server) expandFileShortcodes(ctx context.Context, body string) string {
+	matches := fileShortcodeRe.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return body
+	}
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if store.ValidID(m[1]) {
+			ids = append(ids, strings.ToLower(m[1]))
+		}
+	}
+	rows, err := s.readQuery(ctx, `SELECT id, filename, size FROM attachments WHERE id = ANY($1::text[]::uuid[])`, ids)
+	if err != nil {
+		warnf("查询附件失败: %v\n", err)
+		return body
+	}
+	defer rows.Close()
+	found := make(map[string]attachment)
+	for rows.Next() {
+		var a attachment
+		if err := rows.Scan(&a.ID, &a.Filename, &a.Size); err != nil {
+			return body
+		}
+		found[a.ID] = a
+	}
+	return fileShortcodeRe.ReplaceAllStringFunc(body, func(tok string) string {
+		a, ok := found[strings.ToLower(fileShortcodeRe.FindStringSubmatch(tok)[1])]
+		if !ok {
+			return tok
+		}
+		return s.downloadBlock(a)
+	})
+}
+
+func (s *server) downloadBlock(a attachment) string {
+	return `<div class="download-block"><a class="download-link" href="` + html.EscapeString(s.attachmentURL(a)) + `" download>` +
+		html.EscapeString(a.Filename) + `</a> <span class="download-size">` + humanSize(a.Size) + `</span></div>`
+}
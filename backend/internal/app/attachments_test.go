@@ -0,0 +1,49 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanFilename(t *testing.T) {
+	cases := map[string]string{
+		"report.pdf":             "report.pdf",
+		`C:\Users\me\slides.zip`: "slides.zip",
+		"../../etc/passwd":       "passwd",
+		"a\"b\r\n.txt":           "ab.txt",
+		"":                       "file",
+	}
+	for in, want := range cases {
+		if got := cleanFilename(in); got != want {
+			t.Errorf("cleanFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+	long := cleanFilename(strings.Repeat("长", 300) + ".pdf")
+	if !strings.HasSuffix(long, ".pdf") || len([]rune(long)) != maxAttachmentName {
+		t.Errorf("long name not truncated with extension kept: %d runes", len([]rune(long)))
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	cases := map[int64]string{512: "512 B", 1536: "1.5 KB", 5 << 20: "5.0 MB", 3 << 30: "3.0 GB"}
+	for in, want := range cases {
+		if got := humanSize(in); got != want {
+			t.Errorf("humanSize(%d) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFileShortcodeMatch(t *testing.T) {
+	id := "0b8f3c4e-1d2a-4b5c-9d8e-7f6a5b4c3d2e"
+	body := "<p>[[file:" + id + "]]</p>\n<p>see [[file:" + id + "]] inline</p>"
+	s := &server{basePath: "/blog"}
+	out := fileShortcodeRe.ReplaceAllStringFunc(body, func(string) string {
+		return s.downloadBlock(attachment{ID: id, Filename: "a b.pdf", Size: 2048})
+	})
+	if strings.Contains(out, "<p><div") {
+		t.Fatalf("block left inside paragraph: %s", out)
+	}
+	if !strings.Contains(out, `href="/blog/files/`+id+`/a%20b.pdf"`) || !strings.Contains(out, "2.0 KB") {
+		t.Fatalf("unexpected block: %s", out)
+	}
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"selfecho/backend/internal/store"
+)
+
+const (
+	maxDisplayNameRunes = 100
+	maxBioRunes         = 2000
+)
+
+// author is a user's public profile for /api/authors and /author/:username.
+type author struct {
+	Username     string     `json:"username"`
+	DisplayName  string     `json:"displayName,omitempty"`
+	Bio          string     `json:"bio,omitempty"`
+	AvatarURL    string     `json:"avatarUrl,omitempty"`
+	PostCount    int        `json:"postCount"`
+	LatestPostAt *time.Time `json:"latestPostAt,omitempty"`
+}
+
+// name is what bylines show: the display name, else the username.
+func (a author) name() string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.Username
+}
+
+func authorFromStore(a store.Author) author {
+	return author{
+		Username: a.Username, DisplayName: a.DisplayName, Bio: a.Bio, AvatarURL: a.AvatarURL,
+		PostCount: a.PostCount, LatestPostAt: a.LatestPostAt,
+	}
+}
+
+// byline names an article's author in API payloads.
+type byline struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+func (b byline) name() string {
+	if b.DisplayName != "" {
+		return b.DisplayName
+	}
+	return b.Username
+}
+
+// bylineHTML links each author to their page, separated like a Chinese list.
+func (s *server) bylineHTML(authors []byline) string {
+	links := make([]string, len(authors))
+	for i, by := range authors {
+		links[i] = `<a href="` + s.basePath + `/author/` + urlPathEscape(by.Username) + `" class="author-link" rel="author">` + html.EscapeString(by.name()) + `</a>`
+	}
+	return strings.Join(links, "、")
+}
+
+type profilePayload struct {
+	DisplayName string `json:"displayName"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatarUrl"`
+}
+
+func (p *profilePayload) normalize() error {
+	var v validator
+	p.DisplayName = collapseWhitespace(p.DisplayName)
+	p.Bio = strings.TrimSpace(p.Bio)
+	p.AvatarURL = strings.TrimSpace(p.AvatarURL)
+	v.check(maxRunes(p.DisplayName, maxDisplayNameRunes), "displayName", errNameTooLong)
+	v.check(maxRunes(p.Bio, maxBioRunes), "bio", errDescriptionTooLong)
+	v.check(validAvatarURL(p.AvatarURL), "avatarUrl", errInvalidAvatarURL, p.AvatarURL)
+	return v.err()
+}
+
+// validAvatarURL accepts http(s) URLs and site-relative paths such as an
+// uploaded /files/... attachment.
+func validAvatarURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	if strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") {
+		return true
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ensureAuthorSchema adds profile fields to users and an author to articles.
+// Articles written before authors existed go to the only user, if there is
+// just one.
+func (s *server) ensureAuthorSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS author_id UUID REFERENCES users(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_articles_author ON articles(author_id);
+		UPDATE articles SET author_id = (SELECT id FROM users)
+		WHERE author_id IS NULL AND (SELECT COUNT(*) FROM users) = 1;
+	`)
+	return err
+}
+
+func (s *server) listAuthors(c *gin.Context) {
+	rows, err := s.authors.ListAuthors(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryAuthorsFailed)
+		return
+	}
+	items := []author{}
+	for _, r := range rows {
+		items = append(items, authorFromStore(r))
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (s *server) getAuthor(c *gin.Context) {
+	a, err := s.authors.AuthorByUsername(c.Request.Context(), c.Param("username"))
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errAuthorNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryAuthorsFailed)
+		return
+	}
+	c.JSON(http.StatusOK, authorFromStore(a))
+}
+
+// updateProfile backs PUT /api/authors/me: the signed-in user edits their
+// own profile.
+func (s *server) updateProfile(c *gin.Context) {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	var payload profilePayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	err := s.authors.UpdateProfile(c.Request.Context(), u.ID, store.Profile{
+		DisplayName: payload.DisplayName, Bio: payload.Bio, AvatarURL: payload.AvatarURL,
+	})
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveProfileFailed, err)
+		return
+	}
+	s.publishFrom(c, eventAuthorChanged, actionUpdated, u.ID, u.Username)
+	c.Status(http.StatusNoContent)
+}
+
+// personLD is the schema.org Person for an author page.
+func personLD(a author, pageURL string) map[string]any {
+	person := map[string]any{
+		"@type":         "Person",
+		"@id":           pageURL + "#person",
+		"name":          a.name(),
+		"alternateName": a.Username,
+		"url":           pageURL,
+	}
+	if a.Bio != "" {
+		person["description"] = truncateRunes(markdownPlainText(a.Bio), 300)
+	}
+	if a.AvatarURL != "" {
+		person["image"] = a.AvatarURL
+	}
+	return person
+}
+
+func (s *server) seoAuthorHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		ctx := c.Request.Context()
+		row, err := s.authors.AuthorByUsername(ctx, c.Param("username"))
+		if errors.Is(err, store.ErrNotFound) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		a := authorFromStore(row)
+
+		page, ok := pageParam(c)
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		base := s.baseURL(c)
+		listURL := base + "/author/" + urlPathEscape(a.Username)
+
+		posts, total, err := s.articles.ListArticles(ctx, store.ArticleFilter{
+			Status: "published", Type: "post", Author: a.Username, ListedOnly: true,
+			Page: page, Limit: archivePageSize,
+		})
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		pages := totalPages(total, archivePageSize)
+		if page > pages {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		canonical := withPage(listURL, page)
+		prev, next := pageLinks(listURL, page, pages)
+
+		var b strings.Builder
+		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 text-center sm:px-9 md:px-12 lg:px-[10rem]">`)
+		if a.AvatarURL != "" {
+			b.WriteString(`<img class="author-avatar mx-auto mb-3 h-20 w-20 rounded-full" src="` + html.EscapeString(a.AvatarURL) + `" alt="` + html.EscapeString(a.name()) + `">`)
+		}
+		b.WriteString(`<h1 class="mb-4 text-[1.6rem] font-bold tracking-[0.09375em]">` + html.EscapeString(a.name()) + `</h1>`)
+		if a.Bio != "" && page == 1 {
+			b.WriteString(`<div class="author-bio mb-6 text-left text-[15px] leading-7 text-[#3d3d3f]">` + renderMarkdown(a.Bio) + `</div>`)
+		}
+		for _, r := range posts {
+			it := articleFromRow(r)
+			b.WriteString(`<div class="pb-6 space-y-1">`)
+			b.WriteString(`<div class="text-[1.4rem] font-bold tracking-[0.09375em]">`)
+			b.WriteString(`<a href="` + s.basePath + `/post/` + urlPathEscape(it.Slug) + `" class="text-[#3273dc] no-underline">` + html.EscapeString(it.Title) + `</a>`)
+			b.WriteString(`</div>`)
+			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + html.EscapeString(s.formatSiteTime(it.CreatedAt)) + `</div>`)
+			b.WriteString(`</div>`)
+		}
+		b.WriteString(paginationNav(prev, next, page, pages))
+		b.WriteString(`</section>`)
+
+		title := "作者 - " + a.name()
+		description := a.name() + " 的文章"
+		if plain := markdownPlainText(a.Bio); plain != "" {
+			description = truncateRunes(plain, 180)
+		}
+		if page > 1 {
+			title += fmt.Sprintf(" (第 %d 页)", page)
+		}
+		profile := map[string]any{
+			"@type":      "ProfilePage",
+			"@id":        listURL,
+			"url":        canonical,
+			"name":       title,
+			"mainEntity": personLD(a, listURL),
+		}
+		crumbs := breadcrumbList([]crumb{{Name: siteTitle, URL: base + "/"}, {Name: a.name(), URL: listURL}})
+		headExtras := seoHead(siteTitle, title, description, canonical, "profile", jsonLDGraph(profile, crumbs))
+		headExtras += paginationHead(prev, next)
+
+		s.writeSSR(c, spa, title, headExtras, b.String())
+	}
+}
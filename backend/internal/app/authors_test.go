@@ -0,0 +1,44 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfilePayloadNormalize(t *testing.T) {
+	p := profilePayload{DisplayName: "  Ann   Lee ", Bio: " hi ", AvatarURL: "/files/1/me.png"}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.DisplayName != "Ann Lee" || p.Bio != "hi" {
+		t.Fatalf("normalize: %+v", p)
+	}
+	for _, bad := range []profilePayload{
+		{AvatarURL: "javascript:alert(1)"},
+		{AvatarURL: "//evil.example/a.png"},
+		{DisplayName: strings.Repeat("x", maxDisplayNameRunes+1)},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
+
+func TestPersonLD(t *testing.T) {
+	p := personLD(author{Username: "ann", Bio: "Writes **Go**.", AvatarURL: "https://a.example/ann.png"}, "https://b.example/author/ann")
+	if p["name"] != "ann" || p["description"] != "Writes Go." || p["image"] != "https://a.example/ann.png" {
+		t.Fatalf("person = %v", p)
+	}
+	if p := personLD(author{Username: "ann", DisplayName: "Ann"}, "u"); p["name"] != "Ann" || p["description"] != nil {
+		t.Fatalf("display name / no bio: %v", p)
+	}
+}
+
+func TestBylineHTML(t *testing.T) {
+	s := &server{basePath: "/blog"}
+	got := s.bylineHTML([]byline{{Username: "ann", DisplayName: "Ann <A>"}, {Username: "bob"}})
+	want := `<a href="/blog/author/ann" class="author-link" rel="author">Ann &lt;A&gt;</a>、<a href="/blog/author/bob" class="author-link" rel="author">bob</a>`
+	if got != want {
+		t.Fatalf("byline:\n got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,563 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Backups dump the database with pg_dump, encrypt the dump when a secret is
+// configured and copy it to every target, keeping the newest Keep dumps on
+// each. They run on a schedule and on demand, outside the outbox since a dump
+// can outlast a job lease; every run is recorded in the backups table.
+const (
+	backupFilePrefix     = "selfecho-"
+	backupFileSuffix     = ".dump"
+	backupEncryptedExt   = ".enc"
+	defaultBackupKeep    = 7
+	defaultBackupTimeout = time.Hour
+	backupCheckInterval  = 10 * time.Minute
+)
+
+var defaultBackupCommand = []string{"pg_dump", "--format=custom", "--no-owner", "--dbname={dsn}"}
+
+// backupConfig enables backups. DumpCommand writes the dump to stdout;
+// {dsn} in it is replaced with the database connection string, whose
+// password is passed in PGPASSWORD instead. Secret (or BACKUP_SECRET)
+// encrypts dump files; without it they are stored as pg_dump wrote them.
+// IntervalHours of 0 leaves backups to the admin endpoint and the CLI. Bulk
+// decides what happens to the large tables.
+type backupConfig struct {
+	Enabled        bool                 `yaml:"enabled"`
+	IntervalHours  int                  `yaml:"intervalHours"`
+	Keep           int                  `yaml:"keep"`
+	DumpCommand    []string             `yaml:"dumpCommand"`
+	TimeoutMinutes int                  `yaml:"timeoutMinutes"`
+	Secret         string               `yaml:"secret"`
+	Targets        []backupTargetConfig `yaml:"targets"`
+	Bulk           backupBulkConfig     `yaml:"bulk"`
+}
+
+func (c backupConfig) keep() int {
+	if c.Keep > 0 {
+		return c.Keep
+	}
+	return defaultBackupKeep
+}
+
+func (c backupConfig) command() []string {
+	if len(c.DumpCommand) > 0 {
+		return c.DumpCommand
+	}
+	return defaultBackupCommand
+}
+
+func (c backupConfig) timeout() time.Duration {
+	if c.TimeoutMinutes > 0 {
+		return time.Duration(c.TimeoutMinutes) * time.Minute
+	}
+	return defaultBackupTimeout
+}
+
+func validateBackup(c backupConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Targets) == 0 {
+		return errors.New("backup.targets 不能为空")
+	}
+	if c.IntervalHours < 0 || c.Keep < 0 || c.TimeoutMinutes < 0 {
+		return errors.New("backup.intervalHours、keep 与 timeoutMinutes 不能为负数")
+	}
+	if err := validateBackupBulk(c.Bulk); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i, t := range c.Targets {
+		if err := t.validate(i); err != nil {
+			return err
+		}
+		if seen[t.label(i)] {
+			return fmt.Errorf("backup.targets[%d].name 重复: %s", i, t.label(i))
+		}
+		seen[t.label(i)] = true
+	}
+	return nil
+}
+
+// backupRunner holds what a backup needs. mu keeps runs from overlapping.
+type backupRunner struct {
+	cfg     backupConfig
+	db      dbConfig
+	targets []backupTarget
+	mu      sync.Mutex
+}
+
+func newBackupRunner(cfgPath string, cfg backupConfig, db dbConfig) *backupRunner {
+	if !cfg.Enabled {
+		return nil
+	}
+	// uploads are bounded by the run's timeout rather than per request
+	client := &http.Client{}
+	b := &backupRunner{cfg: cfg, db: db}
+	for _, t := range cfg.Targets {
+		b.targets = append(b.targets, newBackupTarget(cfgPath, t, client))
+	}
+	return b
+}
+
+// backupRecord is a row of the backups table.
+type backupRecord struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Encrypted  bool      `json:"encrypted"`
+	Targets    tagList   `json:"targets"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+func (s *server) ensureBackupSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS backups (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			size BIGINT NOT NULL DEFAULT 0,
+			encrypted BOOLEAN NOT NULL DEFAULT false,
+			targets TEXT[] NOT NULL DEFAULT '{}',
+			error TEXT NOT NULL DEFAULT '',
+			started_at TIMESTAMPTZ NOT NULL,
+			finished_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		ALTER TABLE backups ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'content';
+		CREATE INDEX IF NOT EXISTS idx_backups_started ON backups(started_at DESC);
+	`)
+	return err
+}
+
+const backupTimeFormat = "20060102T150405Z"
+
+func backupFilePrefixFor(kind string) string {
+	if kind == backupBulk {
+		return backupFilePrefix + backupBulk + "-"
+	}
+	return backupFilePrefix
+}
+
+// backupName names a dump file after its kind and when it was taken, so
+// names of one kind sort by age.
+func backupName(kind string, at time.Time, encrypted bool) string {
+	name := backupFilePrefixFor(kind) + at.UTC().Format(backupTimeFormat) + backupFileSuffix
+	if encrypted {
+		name += backupEncryptedExt
+	}
+	return name
+}
+
+// isBackupName reports whether name is a dump file of kind.
+func isBackupName(kind, name string) bool {
+	rest, ok := strings.CutPrefix(name, backupFilePrefixFor(kind))
+	if !ok || len(rest) < len(backupTimeFormat) {
+		return false
+	}
+	if _, err := time.Parse(backupTimeFormat, rest[:len(backupTimeFormat)]); err != nil {
+		return false
+	}
+	ext := rest[len(backupTimeFormat):]
+	return ext == backupFileSuffix || ext == backupFileSuffix+backupEncryptedExt
+}
+
+// dumpDSN is the connection string for the dump command without its
+// password, which is returned separately for PGPASSWORD.
+func dumpDSN(cfg dbConfig) (string, string, error) {
+	password := cfg.Password
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return "", "", err
+		}
+		if u.User != nil {
+			if p, ok := u.User.Password(); ok {
+				password = p
+			}
+			u.User = url.User(u.User.Username())
+		}
+		cfg.URL = u.String()
+	}
+	cfg.Password = ""
+	dsn, err := BuildDSN(cfg)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.Replace(dsn, " password=''", "", 1), password, nil
+}
+
+// dump runs the dump command into w, with the table selection for kind
+// appended.
+func (b *backupRunner) dump(ctx context.Context, kind string, w io.Writer) error {
+	dsn, password, err := dumpDSN(b.db)
+	if err != nil {
+		return err
+	}
+	command := b.cfg.command()
+	args := make([]string, 0, len(command)-1)
+	for _, arg := range command[1:] {
+		args = append(args, strings.ReplaceAll(arg, "{dsn}", dsn))
+	}
+	args = append(args, b.cfg.Bulk.dumpArgs(kind)...)
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Env = os.Environ()
+	if password != "" {
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+password)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// run takes one backup of kind and copies it to every target. A target that
+// fails doesn't stop the others; the record lists the ones that got the dump
+// and the error names the rest.
+func (b *backupRunner) run(ctx context.Context, kind string) (backupRecord, error) {
+	if !b.mu.TryLock() {
+		return backupRecord{}, errBackupRunning
+	}
+	defer b.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.timeout())
+	defer cancel()
+
+	rec := backupRecord{Kind: kind, StartedAt: time.Now(), Encrypted: b.cfg.Secret != "", Targets: tagList{}}
+	rec.Name = backupName(kind, rec.StartedAt, rec.Encrypted)
+	tmp, err := os.CreateTemp("", "selfecho-backup-*")
+	if err != nil {
+		return rec, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	out := io.MultiWriter(tmp, hash)
+	if rec.Encrypted {
+		enc, err := newBackupWriter(out, b.cfg.Secret)
+		if err != nil {
+			return rec, err
+		}
+		if err := b.dump(ctx, kind, enc); err != nil {
+			return rec, err
+		}
+		if err := enc.Close(); err != nil {
+			return rec, err
+		}
+	} else if err := b.dump(ctx, kind, out); err != nil {
+		return rec, err
+	}
+	if rec.Size, err = tmp.Seek(0, io.SeekCurrent); err != nil {
+		return rec, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	var failed []string
+	for i, t := range b.targets {
+		label := b.cfg.Targets[i].label(i)
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return rec, err
+		}
+		if err := t.put(ctx, rec.Name, tmp, rec.Size, sum); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", label, err))
+			continue
+		}
+		rec.Targets = append(rec.Targets, label)
+		if err := pruneBackups(ctx, t, kind, b.cfg.keepFor(kind)); err != nil {
+			warnf("清理备份目标 %s 的旧备份失败: %v\n", label, err)
+		}
+	}
+	if len(failed) > 0 {
+		return rec, errors.New(strings.Join(failed, "; "))
+	}
+	return rec, nil
+}
+
+// pruneBackups removes all but the keep newest dump files of kind from t.
+// Other files are left alone.
+func pruneBackups(ctx context.Context, t backupTarget, kind string, keep int) error {
+	names, err := t.list(ctx)
+	if err != nil {
+		return err
+	}
+	var dumps []string
+	for _, name := range names {
+		if isBackupName(kind, name) {
+			dumps = append(dumps, name)
+		}
+	}
+	sort.Strings(dumps)
+	for len(dumps) > keep {
+		if err := t.remove(ctx, dumps[0]); err != nil {
+			return err
+		}
+		dumps = dumps[1:]
+	}
+	return nil
+}
+
+var errBackupRunning = errors.New("a backup is already running")
+
+// runBackup takes a backup of kind and records it, failed or not.
+func (s *server) runBackup(ctx context.Context, kind string) (backupRecord, error) {
+	rec, err := s.backups.run(ctx, kind)
+	if errors.Is(err, errBackupRunning) {
+		return rec, err
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	// recorded even when the run timed out
+	recCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if qerr := s.db.QueryRowContext(recCtx, `
+		INSERT INTO backups (kind, name, size, encrypted, targets, error, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, finished_at`, rec.Kind, rec.Name, rec.Size, rec.Encrypted, []string(rec.Targets), rec.Error, rec.StartedAt).
+		Scan(&rec.ID, &rec.FinishedAt); qerr != nil {
+		warnf("记录备份失败: %v\n", qerr)
+	}
+	return rec, err
+}
+
+// backupDue reports whether the last successful backup of kind is older
+// than its interval.
+func (s *server) backupDue(ctx context.Context, kind string) (bool, error) {
+	interval := s.backups.cfg.intervalFor(kind)
+	if interval == 0 {
+		return false, nil
+	}
+	var last sql.NullTime
+	if err := s.db.QueryRowContext(ctx, `SELECT max(started_at) FROM backups WHERE kind = $1 AND error = ''`, kind).Scan(&last); err != nil {
+		return false, err
+	}
+	return !last.Valid || time.Since(last.Time) >= interval, nil
+}
+
+// runBackupSchedule takes a backup whenever one is due. Due is judged from
+// the backups table, so restarts neither skip nor repeat one; a failed run
+// is retried at the next check.
+func (s *server) runBackupSchedule() {
+	if s.backups == nil {
+		return
+	}
+	var kinds []string
+	for _, kind := range s.backups.cfg.kinds() {
+		if s.backups.cfg.intervalFor(kind) > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return
+	}
+	ticker := time.NewTicker(backupCheckInterval)
+	defer ticker.Stop()
+	for {
+		for _, kind := range kinds {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			due, err := s.backupDue(ctx, kind)
+			cancel()
+			if err != nil {
+				warnf("读取备份记录失败: %v\n", err)
+				continue
+			}
+			if !due {
+				continue
+			}
+			if rec, err := s.runBackup(context.Background(), kind); err != nil && !errors.Is(err, errBackupRunning) {
+				warnf("备份失败: %v\n", err)
+			} else if err == nil {
+				infof("已备份 %s (%d 字节) 到 %s\n", rec.Name, rec.Size, strings.Join(rec.Targets, ", "))
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// backupKindParam reads ?kind=, which defaults to content and may only name
+// a kind that is taken.
+func (s *server) backupKindParam(c *gin.Context) (string, bool) {
+	kind := c.DefaultQuery("kind", backupContent)
+	if !slices.Contains(s.backups.cfg.kinds(), kind) {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBackupKind, errors.New(kind))
+		return "", false
+	}
+	return kind, true
+}
+
+// listBackups backs GET /api/admin/backups: the latest runs, newest first,
+// of every kind unless ?kind= picks one.
+func (s *server) listBackups(c *gin.Context) {
+	if s.backups == nil {
+		respondError(c, http.StatusServiceUnavailable, errBackupDisabled)
+		return
+	}
+	kind := ""
+	if c.Query("kind") != "" {
+		var ok bool
+		if kind, ok = s.backupKindParam(c); !ok {
+			return
+		}
+	}
+	rows, err := s.db.QueryContext(c.Request.Context(), `
+		SELECT id, kind, name, size, encrypted, to_json(targets)::text, error, started_at, finished_at
+		FROM backups WHERE $1 = '' OR kind = $1
+		ORDER BY started_at DESC LIMIT 50`, kind)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryBackupsFailed, err)
+		return
+	}
+	defer rows.Close()
+	items := []backupRecord{}
+	for rows.Next() {
+		var r backupRecord
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Name, &r.Size, &r.Encrypted, &r.Targets, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errQueryBackupsFailed, err)
+			return
+		}
+		items = append(items, r)
+	}
+	if err := rows.Err(); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryBackupsFailed, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// startBackup backs POST /api/admin/backups?kind=. The backup runs in the
+// background; its outcome shows up in the list.
+func (s *server) startBackup(c *gin.Context) {
+	if s.backups == nil {
+		respondError(c, http.StatusServiceUnavailable, errBackupDisabled)
+		return
+	}
+	kind, ok := s.backupKindParam(c)
+	if !ok {
+		return
+	}
+	if !s.backups.mu.TryLock() {
+		respondError(c, http.StatusConflict, errBackupInProgress)
+		return
+	}
+	s.backups.mu.Unlock()
+	go func() {
+		if _, err := s.runBackup(context.Background(), kind); err != nil {
+			warnf("备份失败: %v\n", err)
+		}
+	}()
+	c.Status(http.StatusAccepted)
+}
+
+// RunBackup implements `selfecho backup run [-kind bulk]`, which takes a
+// backup now, and
+// `selfecho backup decrypt -in FILE [-out FILE]`, which turns an encrypted
+// dump back into what pg_restore reads, using backup.secret.
+func RunBackup(w io.Writer, args []string) error {
+	usage := errors.New("usage: selfecho backup run [-kind content|bulk] | selfecho backup decrypt -in FILE [-out FILE]")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "run":
+		fs := flag.NewFlagSet("backup run", flag.ContinueOnError)
+		fs.SetOutput(w)
+		kind := fs.String("kind", backupContent, "content, or bulk when backup.bulk.mode is separate")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		ctx := context.Background()
+		s, cfg, err := openCLIServer(ctx)
+		if err != nil {
+			return err
+		}
+		defer s.db.Close()
+		if s.backups = newBackupRunner(resolveConfigPath(), cfg.Backup, cfg.Database); s.backups == nil {
+			return errors.New("backup.enabled 未开启")
+		}
+		if !slices.Contains(cfg.Backup.kinds(), *kind) {
+			return fmt.Errorf("unknown backup kind %q", *kind)
+		}
+		if err := s.ensureBackupSchema(ctx); err != nil {
+			return err
+		}
+		rec, err := s.runBackup(ctx, *kind)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "backed up %s (%d bytes) to %s\n", rec.Name, rec.Size, strings.Join(rec.Targets, ", "))
+		return nil
+	case "decrypt":
+		fs := flag.NewFlagSet("backup decrypt", flag.ContinueOnError)
+		fs.SetOutput(w)
+		in := fs.String("in", "", "encrypted dump file")
+		out := fs.String("out", "", "where to write the dump (default: stdout)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *in == "" {
+			return usage
+		}
+		cfg, err := loadConfig(resolveConfigPath())
+		if err != nil {
+			return err
+		}
+		if cfg.Backup.Secret == "" {
+			return errors.New("backup.secret / BACKUP_SECRET 未设置")
+		}
+		return decryptBackupFile(*in, *out, cfg.Backup.Secret, w)
+	}
+	return usage
+}
+
+func decryptBackupFile(in, out, secret string, stdout io.Writer) error {
+	src, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	r, err := newBackupReader(src, secret)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = io.Copy(stdout, r)
+		return err
+	}
+	dst, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		os.Remove(out)
+		return err
+	}
+	return dst.Close()
+}
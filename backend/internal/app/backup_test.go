@@ -0,0 +1,160 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupCryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 10, backupChunkBytes, 3*backupChunkBytes + 7} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		var sealed bytes.Buffer
+		w, err := newBackupWriter(&sealed, "s3cret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// odd write sizes cross chunk boundaries
+		for rest := plain; len(rest) > 0; {
+			n := min(len(rest), 5000)
+			w.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(sealed.Bytes(), plain[:min(size, 64)]) && size > 0 {
+			t.Fatalf("%d: plaintext leaked", size)
+		}
+
+		r, err := newBackupReader(bytes.NewReader(sealed.Bytes()), "s3cret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("%d: round trip got %d bytes, %v", size, len(got), err)
+		}
+
+		// cutting the file anywhere after the header must not pass for the whole
+		for _, cut := range []int{len(backupMagic) + kdfSaltBytes + backupNoncePrefix, sealed.Len() - 1, sealed.Len() - 16 - backupChunkBytes} {
+			if cut < len(backupMagic)+kdfSaltBytes+backupNoncePrefix {
+				continue
+			}
+			r, _ := newBackupReader(bytes.NewReader(sealed.Bytes()[:cut]), "s3cret")
+			if _, err := io.ReadAll(r); !errors.Is(err, errBackupCorrupt) {
+				t.Fatalf("%d: truncated at %d read without error", size, cut)
+			}
+		}
+	}
+
+	var sealed bytes.Buffer
+	w, _ := newBackupWriter(&sealed, "right")
+	w.Write([]byte("dump"))
+	w.Close()
+	r, _ := newBackupReader(&sealed, "wrong")
+	if _, err := io.ReadAll(r); !errors.Is(err, errBackupCorrupt) {
+		t.Fatalf("wrong secret: %v", err)
+	}
+	if _, err := newBackupReader(strings.NewReader("PGDMP plain"), "x"); err == nil {
+		t.Fatal("a plain dump is not an encrypted backup")
+	}
+}
+
+func TestValidateBackup(t *testing.T) {
+	ok := backupConfig{Enabled: true, Targets: []backupTargetConfig{
+		{Type: backupLocal, Dir: "backups"},
+		{Type: backupS3, Bucket: "b", AccessKey: "k", SecretKey: "s", Endpoint: "https://minio.local:9000"},
+		{Type: backupWebDAV, URL: "https://cloud.example/remote.php/dav/files/me/backups"},
+	}}
+	if err := validateBackup(ok); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []backupConfig{
+		{Enabled: true},
+		{Enabled: true, Targets: []backupTargetConfig{{Type: "ftp"}}},
+		{Enabled: true, Targets: []backupTargetConfig{{Type: backupLocal}}},
+		{Enabled: true, Targets: []backupTargetConfig{{Type: backupS3, Bucket: "b"}}},
+		{Enabled: true, Targets: []backupTargetConfig{{Type: backupWebDAV, URL: "ftp://x"}}},
+		{Enabled: true, Targets: []backupTargetConfig{{Name: "a", Type: backupLocal, Dir: "x"}, {Name: "a", Type: backupLocal, Dir: "y"}}},
+		{Enabled: true, Keep: -1, Targets: ok.Targets[:1]},
+	} {
+		if validateBackup(bad) == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+func TestDumpDSN(t *testing.T) {
+	dsn, pw, err := dumpDSN(dbConfig{URL: "postgres://blog:p%40ss@db:5432/blog?sslmode=disable"})
+	if err != nil || pw != "p@ss" || strings.Contains(dsn, "p%40ss") || !strings.HasPrefix(dsn, "postgres://blog@db:5432/blog") {
+		t.Fatalf("url: %q %q %v", dsn, pw, err)
+	}
+	dsn, pw, err = dumpDSN(dbConfig{Host: "db", Port: 5432, User: "blog", Password: "secret", Name: "blog"})
+	if err != nil || pw != "secret" || strings.Contains(dsn, "password") || !strings.Contains(dsn, "dbname=blog") {
+		t.Fatalf("fields: %q %q %v", dsn, pw, err)
+	}
+}
+
+func TestBackupRunnerLocal(t *testing.T) {
+	dir := t.TempDir()
+	cfg := backupConfig{
+		Enabled:     true,
+		Keep:        2,
+		Secret:      "s3cret",
+		DumpCommand: []string{"sh", "-c", `printf 'dump of %s pw=%s' "$1" "$PGPASSWORD"`, "sh", "{dsn}"},
+		Targets:     []backupTargetConfig{{Type: backupLocal, Dir: "a"}, {Name: "second", Type: backupLocal, Dir: "b"}},
+	}
+	b := newBackupRunner(filepath.Join(dir, "config.yaml"), cfg, dbConfig{URL: "postgres://u:pw@db/blog"})
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	for _, old := range []string{"selfecho-20200101T000000Z.dump", "selfecho-20200102T000000Z.dump.enc", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, "a", old), nil, 0o644)
+	}
+
+	rec, err := b.run(context.Background(), backupContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Encrypted || !strings.HasSuffix(rec.Name, ".dump.enc") || strings.Join(rec.Targets, ",") != "local#0,second" {
+		t.Fatalf("record = %+v", rec)
+	}
+	names, _ := (&localTarget{dir: filepath.Join(dir, "a")}).list(context.Background())
+	if strings.Join(names, ",") != "notes.txt,selfecho-20200102T000000Z.dump.enc,"+rec.Name {
+		t.Fatalf("after pruning: %v", names)
+	}
+
+	out := filepath.Join(dir, "plain.dump")
+	if err := decryptBackupFile(filepath.Join(dir, "b", rec.Name), out, "s3cret", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "dump of postgres://u@db/blog pw=pw" {
+		t.Fatalf("decrypted dump = %q", got)
+	}
+
+	b.cfg.DumpCommand = []string{"false"}
+	if _, err := b.run(context.Background(), backupContent); err == nil {
+		t.Fatal("a failing dump command should fail the backup")
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	at := time.Date(2026, 10, 18, 3, 4, 5, 0, time.FixedZone("x", 3600))
+	if got := backupName(backupContent, at, false); got != "selfecho-20261018T020405Z.dump" || !isBackupName(backupContent, got) {
+		t.Fatalf("name = %q", got)
+	}
+	bulk := backupName(backupBulk, at, true)
+	if bulk != "selfecho-bulk-20261018T020405Z.dump.enc" || !isBackupName(backupBulk, bulk) {
+		t.Fatalf("bulk name = %q", bulk)
+	}
+	if isBackupName(backupContent, bulk) || isBackupName(backupBulk, "selfecho-20261018T020405Z.dump") || isBackupName(backupContent, "selfecho-notes.dump") {
+		t.Fatal("kinds must not match each other's files")
+	}
+}
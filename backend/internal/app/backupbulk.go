@@ -0,0 +1,117 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Backup kinds. Content backups are the regular dumps; bulk backups hold the
+// bulk tables when they are dumped separately.
+const (
+	backupContent = "content"
+	backupBulk    = "bulk"
+)
+
+// Bulk table modes.
+const (
+	bulkInclude  = "include"
+	bulkExclude  = "exclude"
+	bulkSeparate = "separate"
+)
+
+const defaultBulkKeep = 2
+
+// defaultBulkTables are the IMAP message cache, which a sync can fetch again,
+// and the traffic rollup the access log feeds.
+var defaultBulkTables = []string{"imap_messages", "traffic_daily"}
+
+var tableNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+// backupBulkConfig keeps large, low-value tables from weighing down content
+// backups. Mode "include" (the default) dumps them with everything else;
+// "exclude" leaves their rows out, keeping the empty tables so a restore
+// still has the full schema; "separate" excludes them too and dumps them on
+// their own as selfecho-bulk-<time>.dump, every IntervalHours (default:
+// backup.intervalHours), keeping the newest Keep (default 2).
+type backupBulkConfig struct {
+	Tables        []string `yaml:"tables"`
+	Mode          string   `yaml:"mode"`
+	IntervalHours int      `yaml:"intervalHours"`
+	Keep          int      `yaml:"keep"`
+}
+
+func (c backupBulkConfig) tables() []string {
+	if len(c.Tables) > 0 {
+		return c.Tables
+	}
+	return defaultBulkTables
+}
+
+func (c backupBulkConfig) mode() string {
+	if c.Mode != "" {
+		return c.Mode
+	}
+	return bulkInclude
+}
+
+func validateBackupBulk(c backupBulkConfig) error {
+	switch c.mode() {
+	case bulkInclude, bulkExclude, bulkSeparate:
+	default:
+		return fmt.Errorf("backup.bulk.mode 需为 include、exclude 或 separate: %q", c.Mode)
+	}
+	for _, t := range c.Tables {
+		if !tableNameRe.MatchString(t) {
+			return fmt.Errorf("backup.bulk.tables: 无效的表名 %q", t)
+		}
+	}
+	if c.IntervalHours < 0 || c.Keep < 0 {
+		return fmt.Errorf("backup.bulk.intervalHours 与 keep 不能为负数")
+	}
+	return nil
+}
+
+// dumpArgs are the table selection flags added to the dump command for a
+// backup of kind.
+func (c backupBulkConfig) dumpArgs(kind string) []string {
+	var args []string
+	switch {
+	case kind == backupBulk:
+		for _, t := range c.tables() {
+			args = append(args, "--table="+t)
+		}
+	case c.mode() != bulkInclude:
+		for _, t := range c.tables() {
+			args = append(args, "--exclude-table-data="+t)
+		}
+	}
+	return args
+}
+
+// kinds lists the backup kinds taken.
+func (c backupConfig) kinds() []string {
+	if c.Bulk.mode() == bulkSeparate {
+		return []string{backupContent, backupBulk}
+	}
+	return []string{backupContent}
+}
+
+func (c backupConfig) keepFor(kind string) int {
+	if kind != backupBulk {
+		return c.keep()
+	}
+	if c.Bulk.Keep > 0 {
+		return c.Bulk.Keep
+	}
+	return defaultBulkKeep
+}
+
+// intervalFor is how often kind is taken; 0 means only on demand.
+func (c backupConfig) intervalFor(kind string) time.Duration {
+	hours := c.IntervalHours
+	if kind == backupBulk && c.Bulk.IntervalHours > 0 {
+		hours = c.Bulk.IntervalHours
+	}
+	return time.Duration(hours) * time.Hour
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupBulkConfig(t *testing.T) {
+	var c backupConfig
+	if args := c.Bulk.dumpArgs(backupContent); args != nil {
+		t.Fatalf("include mode should not change the dump: %v", args)
+	}
+	if strings.Join(c.kinds(), ",") != backupContent {
+		t.Fatalf("kinds = %v", c.kinds())
+	}
+
+	c = backupConfig{IntervalHours: 24, Keep: 7, Bulk: backupBulkConfig{Mode: bulkSeparate, Tables: []string{"imap_messages"}, IntervalHours: 168}}
+	if got := strings.Join(c.Bulk.dumpArgs(backupContent), " "); got != "--exclude-table-data=imap_messages" {
+		t.Fatalf("content args = %q", got)
+	}
+	if got := strings.Join(c.Bulk.dumpArgs(backupBulk), " "); got != "--table=imap_messages" {
+		t.Fatalf("bulk args = %q", got)
+	}
+	if strings.Join(c.kinds(), ",") != "content,bulk" || c.keepFor(backupBulk) != defaultBulkKeep || c.keepFor(backupContent) != 7 {
+		t.Fatalf("kinds %v, keep %d/%d", c.kinds(), c.keepFor(backupContent), c.keepFor(backupBulk))
+	}
+	if c.intervalFor(backupBulk) != 168*time.Hour || c.intervalFor(backupContent) != 24*time.Hour {
+		t.Fatal("intervalFor")
+	}
+
+	for _, bad := range []backupBulkConfig{{Mode: "skip"}, {Tables: []string{"x; DROP"}}, {Keep: -1}} {
+		if validateBackupBulk(bad) == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+	if err := validateBackupBulk(backupBulkConfig{Mode: bulkExclude, Tables: []string{"public.traffic_daily"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRunnerBulk(t *testing.T) {
+	dir := t.TempDir()
+	cfg := backupConfig{
+		Enabled:     true,
+		Keep:        1,
+		DumpCommand: []string{"sh", "-c", `shift; echo "$@"`, "sh", "--dbname={dsn}"},
+		Targets:     []backupTargetConfig{{Type: backupLocal, Dir: "out"}},
+		Bulk:        backupBulkConfig{Mode: bulkSeparate},
+	}
+	b := newBackupRunner(filepath.Join(dir, "config.yaml"), cfg, dbConfig{URL: "postgres://u@db/blog"})
+	content, err := b.run(context.Background(), backupContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulk, err := b.run(context.Background(), backupBulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, "out", name))
+		return string(data)
+	}
+	if got := read(content.Name); got != "--exclude-table-data=imap_messages --exclude-table-data=traffic_daily\n" {
+		t.Fatalf("content dump args = %q", got)
+	}
+	if got := read(bulk.Name); got != "--table=imap_messages --table=traffic_daily\n" {
+		t.Fatalf("bulk dump args = %q", got)
+	}
+	// each kind is pruned to its own keep without touching the other
+	if _, err := os.Stat(filepath.Join(dir, "out", content.Name)); err != nil {
+		t.Fatalf("bulk run pruned the content dump: %v", err)
+	}
+}
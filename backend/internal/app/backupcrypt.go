@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Encrypted dump files are "selfecho-backup-v1\n", a scrypt salt, a nonce
+// prefix and then the dump in AES-256-GCM sealed chunks. Each chunk's nonce
+// is the prefix, its index and a flag marking the last one, so chunks can't
+// be reordered or dropped and a truncated file fails to open.
+const (
+	backupMagic       = "selfecho-backup-v1\n"
+	backupChunkBytes  = 64 << 10
+	backupNoncePrefix = 7
+)
+
+var errBackupCorrupt = errors.New("encrypted backup is truncated or was not written with this secret")
+
+func backupAEAD(secret string, salt []byte) (cipher.AEAD, error) {
+	key, err := kdf(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func backupNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[backupNoncePrefix:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// backupWriter encrypts what is written to it onto w. Close writes the last
+// chunk and must be called; it does not close w.
+type backupWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+}
+
+func newBackupWriter(w io.Writer, secret string) (*backupWriter, error) {
+	head := make([]byte, kdfSaltBytes+backupNoncePrefix)
+	if _, err := rand.Read(head); err != nil {
+		return nil, err
+	}
+	aead, err := backupAEAD(secret, head[:kdfSaltBytes])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, backupMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(head); err != nil {
+		return nil, err
+	}
+	return &backupWriter{w: w, aead: aead, prefix: head[kdfSaltBytes:], buf: make([]byte, 0, backupChunkBytes)}, nil
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if len(bw.buf) == backupChunkBytes {
+			if err := bw.flush(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(bw.buf[len(bw.buf):backupChunkBytes], p)
+		bw.buf = bw.buf[:len(bw.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (bw *backupWriter) flush(last bool) error {
+	sealed := bw.aead.Seal(nil, backupNonce(bw.prefix, bw.index, last), bw.buf, nil)
+	bw.index++
+	bw.buf = bw.buf[:0]
+	_, err := bw.w.Write(sealed)
+	return err
+}
+
+func (bw *backupWriter) Close() error {
+	return bw.flush(true)
+}
+
+// backupReader decrypts a file written by backupWriter.
+type backupReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	chunk  []byte
+	plain  []byte
+	done   bool
+}
+
+func newBackupReader(r io.Reader, secret string) (*backupReader, error) {
+	head := make([]byte, len(backupMagic)+kdfSaltBytes+backupNoncePrefix)
+	if _, err := io.ReadFull(r, head); err != nil || string(head[:len(backupMagic)]) != backupMagic {
+		return nil, errors.New("not an encrypted selfecho backup")
+	}
+	head = head[len(backupMagic):]
+	aead, err := backupAEAD(secret, head[:kdfSaltBytes])
+	if err != nil {
+		return nil, err
+	}
+	return &backupReader{
+		r:      bufio.NewReaderSize(r, backupChunkBytes+aead.Overhead()+1),
+		aead:   aead,
+		prefix: head[kdfSaltBytes:],
+		chunk:  make([]byte, backupChunkBytes+aead.Overhead()),
+	}, nil
+}
+
+func (br *backupReader) Read(p []byte) (int, error) {
+	for len(br.plain) == 0 {
+		if br.done {
+			return 0, io.EOF
+		}
+		if err := br.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.plain)
+	br.plain = br.plain[n:]
+	return n, nil
+}
+
+func (br *backupReader) next() error {
+	n, err := io.ReadFull(br.r, br.chunk)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	// a short chunk, or a full one with nothing after it, is the last
+	last := n < len(br.chunk)
+	if !last {
+		if _, err := br.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+	plain, err := br.aead.Open(br.chunk[:0], backupNonce(br.prefix, br.index, last), br.chunk[:n], nil)
+	if err != nil {
+		return errBackupCorrupt
+	}
+	br.index++
+	br.plain, br.done = plain, last
+	return nil
+}
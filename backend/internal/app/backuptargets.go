@@ -0,0 +1,440 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup target types.
+const (
+	backupLocal  = "local"
+	backupS3     = "s3"
+	backupWebDAV = "webdav"
+)
+
+// backupTargetConfig is one place dump files are copied to. Local targets
+// use Dir (relative to the config file). S3 targets use Endpoint (default
+// AWS), Region, Bucket, Prefix and the access key pair; PathStyle addresses
+// the bucket in the path, as MinIO and most self-hosted stores want. WebDAV
+// targets use URL, a collection such as a Nextcloud folder, with Username
+// and Password.
+type backupTargetConfig struct {
+	Name      string `yaml:"name"`
+	Type      string `yaml:"type"`
+	Dir       string `yaml:"dir"`
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+	PathStyle bool   `yaml:"pathStyle"`
+	URL       string `yaml:"url"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// label names the target in logs and results.
+func (t backupTargetConfig) label(i int) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("%s#%d", t.Type, i)
+}
+
+func (t backupTargetConfig) validate(i int) error {
+	field := fmt.Sprintf("backup.targets[%d]", i)
+	switch t.Type {
+	case backupLocal:
+		if strings.TrimSpace(t.Dir) == "" {
+			return fmt.Errorf("%s.dir 不能为空", field)
+		}
+	case backupS3:
+		if t.Bucket == "" || t.AccessKey == "" || t.SecretKey == "" {
+			return fmt.Errorf("%s 需要 bucket、accessKey 与 secretKey", field)
+		}
+		if t.Endpoint != "" {
+			if u, err := url.Parse(t.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s.endpoint 需为 http(s) 地址: %q", field, t.Endpoint)
+			}
+		}
+	case backupWebDAV:
+		if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s.url 需为 http(s) 地址: %q", field, t.URL)
+		}
+	default:
+		return fmt.Errorf("%s.type 需为 local、s3 或 webdav: %q", field, t.Type)
+	}
+	return nil
+}
+
+// backupTarget stores dump files by name. list returns the names of the
+// files it holds, in no particular order.
+type backupTarget interface {
+	put(ctx context.Context, name string, body io.ReadSeeker, size int64, sum string) error
+	list(ctx context.Context) ([]string, error)
+	remove(ctx context.Context, name string) error
+}
+
+func newBackupTarget(cfgPath string, t backupTargetConfig, client *http.Client) backupTarget {
+	switch t.Type {
+	case backupS3:
+		return &s3Target{cfg: t, client: client}
+	case backupWebDAV:
+		return &webdavTarget{cfg: t, client: client}
+	}
+	return &localTarget{dir: resolveMediaDir(cfgPath, t.Dir)}
+}
+
+type localTarget struct {
+	dir string
+}
+
+func (t *localTarget) put(_ context.Context, name string, body io.ReadSeeker, _ int64, _ string) error {
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(t.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(t.dir, name))
+}
+
+func (t *localTarget) list(context.Context) ([]string, error) {
+	entries, err := os.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (t *localTarget) remove(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// s3Target talks to S3-compatible object storage with Signature Version 4.
+// Dumps go up in a single PUT, which S3 caps at 5 GB.
+type s3Target struct {
+	cfg    backupTargetConfig
+	client *http.Client
+	// now is stubbed by tests.
+	now func() time.Time
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (t *s3Target) region() string {
+	if t.cfg.Region != "" {
+		return t.cfg.Region
+	}
+	return "us-east-1"
+}
+
+func (t *s3Target) key(name string) string {
+	return path.Join(strings.Trim(t.cfg.Prefix, "/"), name)
+}
+
+// url addresses key in the bucket; an empty key is the bucket itself.
+func (t *s3Target) url(key string) *url.URL {
+	endpoint := t.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + t.region() + ".amazonaws.com"
+	}
+	u, _ := url.Parse(endpoint)
+	u.Path = strings.TrimRight(u.Path, "/")
+	if t.cfg.PathStyle {
+		u.Path += "/" + t.cfg.Bucket
+	} else {
+		u.Host = t.cfg.Bucket + "." + u.Host
+	}
+	u.Path += "/" + key
+	return u
+}
+
+func (t *s3Target) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, sum string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	now := time.Now
+	if t.now != nil {
+		now = t.now
+	}
+	signS3(req, sum, t.cfg.AccessKey, t.cfg.SecretKey, t.region(), now())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (t *s3Target) put(ctx context.Context, name string, body io.ReadSeeker, size int64, sum string) error {
+	resp, err := t.do(ctx, http.MethodPut, t.url(t.key(name)), io.NopCloser(body), size, sum)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (t *s3Target) list(ctx context.Context) ([]string, error) {
+	prefix := strings.Trim(t.cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	var names []string
+	token := ""
+	for {
+		u := t.url("")
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		resp, err := t.do(ctx, http.MethodGet, u, nil, 0, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			// objects in "subdirectories" of the prefix aren't ours
+			if rest := strings.TrimPrefix(c.Key, prefix); !strings.Contains(rest, "/") {
+				names = append(names, rest)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+func (t *s3Target) remove(ctx context.Context, name string) error {
+	resp, err := t.do(ctx, http.MethodDelete, t.url(t.key(name)), nil, 0, emptySHA256)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// signS3 adds the SigV4 Authorization header to req, signing the host, the
+// x-amz-* headers and range. sum is the hex SHA-256 of the body.
+func signS3(req *http.Request, sum, accessKey, secretKey, region string, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", sum)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "range" || lk == "content-type" || lk == "content-md5" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vals := append([]string(nil), query[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		strings.Join(pairs, "&"),
+		canonHeaders.String(),
+		signed,
+		sum,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and
+// "/" too unless it is a path.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !slash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// webdavTarget stores dumps in a WebDAV collection, created on first use.
+type webdavTarget struct {
+	cfg    backupTargetConfig
+	client *http.Client
+}
+
+func (t *webdavTarget) url(name string) string {
+	return strings.TrimRight(t.cfg.URL, "/") + "/" + url.PathEscape(name)
+}
+
+func (t *webdavTarget) do(ctx context.Context, method, target string, body io.Reader, size int64, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if t.cfg.Username != "" || t.cfg.Password != "" {
+		req.SetBasicAuth(t.cfg.Username, t.cfg.Password)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 && !slices.Contains(ok, resp.StatusCode) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("webdav %s %s: %s", method, req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (t *webdavTarget) put(ctx context.Context, name string, body io.ReadSeeker, size int64, _ string) error {
+	// 405 is the answer when the collection exists already
+	resp, err := t.do(ctx, "MKCOL", strings.TrimRight(t.cfg.URL, "/")+"/", nil, 0, nil, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp, err = t.do(ctx, http.MethodPut, t.url(name), io.NopCloser(body), size, http.Header{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (t *webdavTarget) list(ctx context.Context) ([]string, error) {
+	const propfind = `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+	resp, err := t.do(ctx, "PROPFIND", strings.TrimRight(t.cfg.URL, "/")+"/", strings.NewReader(propfind), int64(len(propfind)),
+		http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}, http.StatusNotFound)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	var ms struct {
+		Responses []struct {
+			Href       string    `xml:"DAV: href"`
+			Collection *struct{} `xml:"DAV: propstat>prop>resourcetype>collection"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, r := range ms.Responses {
+		if r.Collection != nil || strings.HasSuffix(r.Href, "/") {
+			continue
+		}
+		href := r.Href
+		if u, err := url.Parse(href); err == nil {
+			href = u.Path
+		}
+		names = append(names, path.Base(href))
+	}
+	return names, nil
+}
+
+func (t *webdavTarget) remove(ctx context.Context, name string) error {
+	resp, err := t.do(ctx, http.MethodDelete, t.url(name), nil, 0, nil, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
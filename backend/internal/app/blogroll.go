@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxLinkNameRunes = 100
+	maxLinkDescRunes = 300
+)
+
+// blogrollLink is an entry on the /links page: a site the author follows,
+// optionally with its feed so the blogroll can be exported as OPML.
+type blogrollLink struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	FeedURL     string    `json:"feedUrl,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Position    int       `json:"position"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type linkPayload struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	FeedURL     string `json:"feedUrl"`
+	Description string `json:"description"`
+	Position    int    `json:"position"`
+}
+
+func (p *linkPayload) normalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.URL = strings.TrimSpace(p.URL)
+	p.FeedURL = strings.TrimSpace(p.FeedURL)
+	p.Description = collapseWhitespace(p.Description)
+	if p.Name == "" || p.URL == "" {
+		return newAPIError(errNameRequired)
+	}
+	if utf8.RuneCountInString(p.Name) > maxLinkNameRunes || utf8.RuneCountInString(p.Description) > maxLinkDescRunes {
+		return newAPIError(errInvalidLink, "too long")
+	}
+	for _, raw := range []string{p.URL, p.FeedURL} {
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return newAPIError(errInvalidLink, raw)
+		}
+	}
+	return nil
+}
+
+func (s *server) ensureBlogrollSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS blogroll (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name TEXT NOT NULL,
+			url TEXT NOT NULL,
+			feed_url TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			position INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
+
+func (s *server) queryBlogroll(ctx context.Context) ([]blogrollLink, error) {
+	rows, err := s.readQuery(ctx, `
+		SELECT id, name, url, feed_url, description, position, created_at
+		FROM blogroll
+		ORDER BY position, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []blogrollLink{}
+	for rows.Next() {
+		var l blogrollLink
+		if err := rows.Scan(&l.ID, &l.Name, &l.URL, &l.FeedURL, &l.Description, &l.Position, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		l.CreatedAt = l.CreatedAt.In(s.siteLocation())
+		items = append(items, l)
+	}
+	return items, rows.Err()
+}
+
+func (s *server) listLinks(c *gin.Context) {
+	items, err := s.queryBlogroll(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryLinksFailed)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (s *server) createLink(c *gin.Context) {
+	var payload linkPayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidLink, err)
+		return
+	}
+	var id string
+	err := s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO blogroll (name, url, feed_url, description, position) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		payload.Name, payload.URL, payload.FeedURL, payload.Description, payload.Position).Scan(&id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveLinkFailed, err)
+		return
+	}
+	s.publishFrom(c, eventLinkChanged, actionCreated, id, "")
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+func (s *server) updateLink(c *gin.Context) {
+	id, ok := idParam(c, "id", errLinkNotFound)
+	if !ok {
+		return
+	}
+	var payload linkPayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidLink, err)
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE blogroll SET name=$1, url=$2, feed_url=$3, description=$4, position=$5 WHERE id=$6`,
+		payload.Name, payload.URL, payload.FeedURL, payload.Description, payload.Position, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveLinkFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errLinkNotFound)
+		return
+	}
+	s.publishFrom(c, eventLinkChanged, actionUpdated, id, "")
+	c.Status(http.StatusNoContent)
+}
+
+func (s *server) deleteLink(c *gin.Context) {
+	id, ok := idParam(c, "id", errLinkNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM blogroll WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveLinkFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errLinkNotFound)
+		return
+	}
+	s.publishFrom(c, eventLinkChanged, actionDeleted, id, "")
+	c.Status(http.StatusNoContent)
+}
+
+type opmlDocument struct {
+	XMLName xml.Name    `xml:"opml"`
+	Version string      `xml:"version,attr"`
+	Head    opmlHead    `xml:"head"`
+	Body    []opmlEntry `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+	OwnerName   string `xml:"ownerName,omitempty"`
+}
+
+type opmlEntry struct {
+	Text     string      `xml:"text,attr"`
+	Title    string      `xml:"title,attr,omitempty"`
+	Type     string      `xml:"type,attr,omitempty"`
+	XMLURL   string      `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string      `xml:"htmlUrl,attr,omitempty"`
+	Children []opmlEntry `xml:"outline,omitempty"`
+}
+
+func rssOutline(title, feed, page string) opmlEntry {
+	return opmlEntry{Text: title, Title: title, Type: "rss", XMLURL: feed, HTMLURL: page}
+}
+
+func buildOPML(head opmlHead, entries []opmlEntry) ([]byte, error) {
+	bytes, err := xml.MarshalIndent(opmlDocument{Version: "2.0", Head: head, Body: entries}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), bytes...), nil
+}
+
+// seoOPMLHandler exports the site's own feeds (one per category) and, when
+// present, the blogroll feeds as a nested outline.
+func (s *server) seoOPMLHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		site := s.siteSettings()
+		base := s.baseURL(c)
+		categories, err := s.queryCategorySummaries(ctx)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		links, err := s.queryBlogroll(ctx)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		var feeds []opmlEntry
+		for _, cat := range categories {
+			feeds = append(feeds, rssOutline(site.Title+" - "+cat.Name, categoryFeedLink(base, cat.Name), base+"/category/"+urlPathEscape(cat.Name)))
+		}
+		entries := []opmlEntry{{Text: site.Title, Title: site.Title, Children: feeds}}
+		var roll []opmlEntry
+		for _, l := range links {
+			if l.FeedURL != "" {
+				roll = append(roll, rssOutline(l.Name, l.FeedURL, l.URL))
+			}
+		}
+		if len(roll) > 0 {
+			entries = append(entries, opmlEntry{Text: "Blogroll", Title: "Blogroll", Children: roll})
+		}
+
+		bytes, err := buildOPML(opmlHead{
+			Title:       site.Title,
+			DateCreated: time.Now().In(s.siteLocation()).Format(time.RFC1123Z),
+			OwnerName:   site.Author,
+		}, entries)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Vary", "Host, X-Forwarded-Proto, X-Forwarded-Host")
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, "text/x-opml; charset=utf-8", bytes)
+	}
+}
+
+func (s *server) seoLinksHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		base := s.baseURL(c)
+		canonical := base + "/links"
+
+		links, err := s.queryBlogroll(c.Request.Context())
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		var b strings.Builder
+		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 sm:px-9 md:px-12 lg:px-[10rem]">`)
+		b.WriteString(`<ul class="space-y-4">`)
+		for _, l := range links {
+			b.WriteString(`<li class="blogroll-item">`)
+			b.WriteString(`<a class="text-[1.1rem] font-semibold text-[#3273dc] hover:underline" href="` + html.EscapeString(l.URL) + `" rel="noopener">` + html.EscapeString(l.Name) + `</a>`)
+			if l.FeedURL != "" {
+				b.WriteString(` <a class="text-xs text-[#aaa] hover:underline" href="` + html.EscapeString(l.FeedURL) + `" rel="noopener">RSS</a>`)
+			}
+			if l.Description != "" {
+				b.WriteString(`<p class="mt-1 text-sm text-[#666]">` + html.EscapeString(l.Description) + `</p>`)
+			}
+			b.WriteString(`</li>`)
+		}
+		b.WriteString(`</ul>`)
+		b.WriteString(`<p class="pt-6 text-xs text-[#aaa]"><a href="` + s.basePath + `/opml.xml" class="hover:underline">OPML</a></p>`)
+		b.WriteString(`</section>`)
+
+		headExtras := seoHead(siteTitle, "友情链接", "友情链接", canonical, "website", "")
+		headExtras += `<link rel="alternate" type="text/x-opml" title="OPML" href="` + html.EscapeString(base+"/opml.xml") + `">`
+		s.writeSSR(c, spa, "友情链接", headExtras, b.String())
+	}
+}
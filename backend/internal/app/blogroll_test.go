@@ -0,0 +1,44 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLinkPayloadNormalize(t *testing.T) {
+	p := linkPayload{Name: " Friend ", URL: "https://friend.example", FeedURL: "https://friend.example/feed.xml"}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Friend" {
+		t.Fatalf("name not trimmed: %q", p.Name)
+	}
+	for _, bad := range []linkPayload{
+		{Name: "x"},
+		{Name: "x", URL: "javascript:alert(1)"},
+		{Name: "x", URL: "https://ok.example", FeedURL: "ftp://feed"},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
+
+func TestBuildOPML(t *testing.T) {
+	out, err := buildOPML(opmlHead{Title: "Blog & Co"}, []opmlEntry{
+		{Text: "Blog", Children: []opmlEntry{rssOutline("Go", "https://b/category/Go/feed.xml", "https://b/category/Go")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(out)
+	for _, want := range []string{
+		`<opml version="2.0">`,
+		`<title>Blog &amp; Co</title>`,
+		`<outline text="Go" title="Go" type="rss" xmlUrl="https://b/category/Go/feed.xml" htmlUrl="https://b/category/Go"></outline>`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("missing %s in\n%s", want, doc)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobBookmarkTitle fills in the title of a bookmark saved without one.
+const jobBookmarkTitle = "bookmark.title"
+
+const (
+	maxBookmarkTitleRunes = 300
+	maxBookmarkNoteRunes  = 2000
+	// maxBookmarkPageBytes is how much of a page is read looking for its
+	// title.
+	maxBookmarkPageBytes = 512 << 10
+	publicBookmarksLimit = 100
+)
+
+var (
+	pageTitleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	ogTitleRe   = regexp.MustCompile(`(?is)<meta\s[^>]*property\s*=\s*["']og:title["'][^>]*>`)
+	metaContent = regexp.MustCompile(`(?is)\bcontent\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// bookmark is a saved link: read later, or, when Public, listed on the
+// /liked page and its feed.
+type bookmark struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Title     string     `json:"title"`
+	Note      string     `json:"note,omitempty"`
+	Tags      []string   `json:"tags"`
+	Read      bool       `json:"read"`
+	ReadAt    *time.Time `json:"readAt,omitempty"`
+	Public    bool       `json:"public"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+func (s *server) ensureBookmarkSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS bookmarks (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			url TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT '',
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			read_at TIMESTAMPTZ,
+			public BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_bookmarks_created ON bookmarks(created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_bookmarks_tags ON bookmarks USING GIN (tags);
+	`)
+	return err
+}
+
+// bookmarkPayload is a bookmark as saved, from JSON or, for bookmarklets, a
+// form post where tags may be one comma-separated value.
+type bookmarkPayload struct {
+	URL    string   `json:"url" form:"url"`
+	Title  string   `json:"title" form:"title"`
+	Note   string   `json:"note" form:"note"`
+	Tags   []string `json:"tags" form:"tags"`
+	Read   bool     `json:"read" form:"read"`
+	Public bool     `json:"public" form:"public"`
+}
+
+func (p *bookmarkPayload) normalize() error {
+	var v validator
+	p.URL = strings.TrimSpace(p.URL)
+	u, err := url.Parse(p.URL)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", errInvalidBookmarkURL)
+	p.Title = truncateRunes(collapseWhitespace(p.Title), maxBookmarkTitleRunes)
+	p.Note = truncateRunes(strings.TrimSpace(p.Note), maxBookmarkNoteRunes)
+	var tags []string
+	for _, t := range p.Tags {
+		tags = append(tags, strings.Split(t, ",")...)
+	}
+	p.Tags = normalizeTags(tags)
+	return v.err()
+}
+
+const bookmarkColumns = `id, url, title, note, to_json(tags)::text, read_at, public, created_at, updated_at`
+
+func (s *server) scanBookmark(row interface{ Scan(...any) error }) (bookmark, error) {
+	var b bookmark
+	var tags tagList
+	var readAt sql.NullTime
+	if err := row.Scan(&b.ID, &b.URL, &b.Title, &b.Note, &tags, &readAt, &b.Public, &b.CreatedAt, &b.UpdatedAt); err != nil {
+		return b, err
+	}
+	b.Tags = []string(tags)
+	if b.Tags == nil {
+		b.Tags = []string{}
+	}
+	if readAt.Valid {
+		t := readAt.Time.In(s.siteLocation())
+		b.Read, b.ReadAt = true, &t
+	}
+	b.CreatedAt = b.CreatedAt.In(s.siteLocation())
+	b.UpdatedAt = b.UpdatedAt.In(s.siteLocation())
+	return b, nil
+}
+
+// formAccessToken lets bookmarklets, which submit a plain form and so
+// cannot set headers, pass their API token as an access_token field
+// (RFC 6750 section 2.2). Form posts must carry a token one way or the
+// other: a session cookie alone would let any site post the form.
+func formAccessToken(c *gin.Context) {
+	if c.ContentType() == "application/x-www-form-urlencoded" {
+		if token := strings.TrimSpace(c.PostForm("access_token")); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		if _, ok := bearerToken(c); !ok {
+			respondError(c, http.StatusUnauthorized, errUnauthorized)
+			c.Abort()
+			return
+		}
+	}
+	c.Next()
+}
+
+// createBookmark saves a link, or refreshes it if already saved; the
+// title, when not given, is fetched from the page in the background. Form
+// posts get a small HTML confirmation instead of JSON.
+func (s *server) createBookmark(c *gin.Context) {
+	ctx := c.Request.Context()
+	var p bookmarkPayload
+	if err := c.ShouldBind(&p); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := p.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errSaveBookmarkFailed)
+		return
+	}
+	defer tx.Rollback()
+	var id string
+	var inserted bool
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO bookmarks (url, title, note, tags, read_at, public)
+		VALUES ($1, $2, $3, $4::text[], CASE WHEN $5 THEN now() END, $6)
+		ON CONFLICT (url) DO UPDATE SET
+			title = COALESCE(NULLIF(EXCLUDED.title, ''), bookmarks.title),
+			note = COALESCE(NULLIF(EXCLUDED.note, ''), bookmarks.note),
+			tags = ARRAY(SELECT DISTINCT unnest(bookmarks.tags || EXCLUDED.tags)),
+			updated_at = now()
+		RETURNING id, xmax = 0`,
+		p.URL, p.Title, p.Note, p.Tags, p.Read, p.Public).Scan(&id, &inserted)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveBookmarkFailed, err)
+		return
+	}
+	b, err := s.scanBookmark(tx.QueryRowContext(ctx, `SELECT `+bookmarkColumns+` FROM bookmarks WHERE id=$1`, id))
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveBookmarkFailed, err)
+		return
+	}
+	if b.Title == "" {
+		payload, _ := json.Marshal(gin.H{"id": b.ID})
+		if _, err := tx.ExecContext(ctx, `INSERT INTO outbox (type, payload) VALUES ($1, $2)`, jobBookmarkTitle, payload); err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errSaveBookmarkFailed, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveBookmarkFailed, err)
+		return
+	}
+	if b.Title == "" {
+		s.wakeOutbox()
+	}
+	status, action := http.StatusOK, actionUpdated
+	if inserted {
+		status, action = http.StatusCreated, actionCreated
+	}
+	s.publishFrom(c, eventBookmarkChanged, action, b.ID, "")
+	if c.ContentType() == "application/x-www-form-urlencoded" {
+		label := b.Title
+		if label == "" {
+			label = b.URL
+		}
+		c.Data(status, "text/html; charset=utf-8", []byte(`<!doctype html><meta charset="utf-8"><title>已保存</title>`+
+			`<p>已保存：<a href="`+html.EscapeString(b.URL)+`">`+html.EscapeString(label)+`</a></p>`))
+		return
+	}
+	c.JSON(status, b)
+}
+
+// listBookmarks pages through bookmarks, newest first, filtered by ?tag=,
+// ?unread=1 and a ?q= substring of the title, URL or note.
+func (s *server) listBookmarks(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := 30
+	if l, err := strconv.Atoi(strings.TrimSpace(c.Query("limit"))); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	page := 1
+	if p, err := strconv.Atoi(strings.TrimSpace(c.Query("page"))); err == nil && p > 0 {
+		page = p
+	}
+	where := `($1 = '' OR $1 = ANY(tags)) AND (NOT $2 OR read_at IS NULL)
+		AND ($3 = '' OR strpos(lower(title || ' ' || url || ' ' || note), lower($3)) > 0)`
+	tag, unread, q := normalizeTag(c.Query("tag")), c.Query("unread") == "1", strings.TrimSpace(c.Query("q"))
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM bookmarks WHERE `+where, tag, unread, q).Scan(&total); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryBookmarksFailed, err)
+		return
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+bookmarkColumns+` FROM bookmarks WHERE `+where+`
+		ORDER BY created_at DESC LIMIT $4 OFFSET $5`, tag, unread, q, limit, (page-1)*limit)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryBookmarksFailed, err)
+		return
+	}
+	defer rows.Close()
+	items := []bookmark{}
+	for rows.Next() {
+		b, err := s.scanBookmark(rows)
+		if err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errQueryBookmarksFailed, err)
+			return
+		}
+		items = append(items, b)
+	}
+	if err := rows.Err(); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryBookmarksFailed, err)
+		return
+	}
+	respondPage(c, items, page, limit, total)
+}
+
+// updateBookmark replaces a bookmark's fields. An empty title queues a new
+// fetch of it.
+func (s *server) updateBookmark(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errBookmarkNotFound)
+	if !ok {
+		return
+	}
+	var p bookmarkPayload
+	if err := c.BindJSON(&p); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := p.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	b, err := s.scanBookmark(s.db.QueryRowContext(ctx, `
+		UPDATE bookmarks SET url=$2, title=$3, note=$4, tags=$5::text[],
+		       read_at = CASE WHEN $6 THEN COALESCE(read_at, now()) END, public=$7, updated_at=now()
+		WHERE id=$1
+		RETURNING `+bookmarkColumns,
+		id, p.URL, p.Title, p.Note, p.Tags, p.Read, p.Public))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errBookmarkNotFound)
+		return
+	}
+	if isUniqueViolation(err) {
+		respondErrorDetail(c, http.StatusConflict, errSaveBookmarkFailed, err)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveBookmarkFailed, err)
+		return
+	}
+	if b.Title == "" {
+		payload, _ := json.Marshal(gin.H{"id": b.ID})
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO outbox (type, payload) VALUES ($1, $2)`, jobBookmarkTitle, payload); err == nil {
+			s.wakeOutbox()
+		}
+	}
+	s.publishFrom(c, eventBookmarkChanged, actionUpdated, b.ID, "")
+	c.JSON(http.StatusOK, b)
+}
+
+func (s *server) deleteBookmark(c *gin.Context) {
+	id, ok := idParam(c, "id", errBookmarkNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM bookmarks WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveBookmarkFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errBookmarkNotFound)
+		return
+	}
+	s.publishFrom(c, eventBookmarkChanged, actionDeleted, id, "")
+	c.Status(http.StatusNoContent)
+}
+
+// pageTitle extracts og:title, else <title>, from an HTML document.
+func pageTitle(doc string) string {
+	var raw string
+	if tag := ogTitleRe.FindString(doc); tag != "" {
+		if m := metaContent.FindStringSubmatch(tag); m != nil {
+			raw = m[1][1 : len(m[1])-1]
+		}
+	}
+	if strings.TrimSpace(raw) == "" {
+		if m := pageTitleRe.FindStringSubmatch(doc); m != nil {
+			raw = m[1]
+		}
+	}
+	return truncateRunes(collapseWhitespace(html.UnescapeString(stripHTMLTags(raw))), maxBookmarkTitleRunes)
+}
+
+// runBookmarkTitleJob fetches a bookmarked page and stores its title. Pages
+// that are gone or not HTML are given up on.
+func (s *server) runBookmarkTitleJob(ctx context.Context, payload []byte) error {
+	var j struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return fmt.Errorf("%w: %v", errJobPermanent, err)
+	}
+	var link, title string
+	err := s.db.QueryRowContext(ctx, `SELECT url, title FROM bookmarks WHERE id=$1`, j.ID).Scan(&link, &title)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && title != "") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errJobPermanent, err)
+	}
+	req.Header.Set("Accept", "text/html, application/xhtml+xml")
+	req.Header.Set("User-Agent", "selfecho-bookmarks")
+	resp, err := s.publicClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: %s", errJobPermanent, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("上游返回 %s", resp.Status)
+	}
+	if ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ctype != "text/html" && ctype != "application/xhtml+xml" {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBookmarkPageBytes))
+	if err != nil {
+		return err
+	}
+	if title = pageTitle(string(data)); title == "" {
+		return nil
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE bookmarks SET title=$2 WHERE id=$1 AND title=''`, j.ID, safeUTF8(title))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		s.publish(eventBookmarkChanged, actionUpdated, j.ID, "")
+	}
+	return nil
+}
+
+func (s *server) queryPublicBookmarks(ctx context.Context) ([]bookmark, error) {
+	rows, err := s.readQuery(ctx, `
+		SELECT `+bookmarkColumns+` FROM bookmarks WHERE public
+		ORDER BY created_at DESC LIMIT $1`, publicBookmarksLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []bookmark
+	for rows.Next() {
+		b, err := s.scanBookmark(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, b)
+	}
+	return items, rows.Err()
+}
+
+func bookmarkLabel(b bookmark) string {
+	if b.Title != "" {
+		return b.Title
+	}
+	return b.URL
+}
+
+// seoLikedHandler renders /liked, the public bookmarks. The page only
+// exists once something is made public.
+func (s *server) seoLikedHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		base := s.baseURL(c)
+		items, err := s.queryPublicBookmarks(c.Request.Context())
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if len(items) == 0 {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		var b strings.Builder
+		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 sm:px-9 md:px-12 lg:px-[10rem]">`)
+		b.WriteString(`<ul class="space-y-4">`)
+		for _, it := range items {
+			b.WriteString(`<li class="liked-item">`)
+			b.WriteString(`<a class="text-[1.1rem] font-semibold text-[#3273dc] hover:underline" href="` + html.EscapeString(it.URL) + `" rel="noopener">` + html.EscapeString(bookmarkLabel(it)) + `</a>`)
+			b.WriteString(` <time class="text-xs text-[#aaa]" datetime="` + it.CreatedAt.Format(time.RFC3339) + `">` + it.CreatedAt.Format("2006-01-02") + `</time>`)
+			if it.Note != "" {
+				b.WriteString(`<p class="mt-1 text-sm text-[#666]">` + escapeText(it.Note) + `</p>`)
+			}
+			if len(it.Tags) > 0 {
+				b.WriteString(`<p class="mt-1 text-xs text-[#aaa]">#` + html.EscapeString(strings.Join(it.Tags, " #")) + `</p>`)
+			}
+			b.WriteString(`</li>`)
+		}
+		b.WriteString(`</ul>`)
+		b.WriteString(`<p class="pt-6 text-xs text-[#aaa]"><a href="` + s.basePath + `/liked/feed.xml" class="hover:underline">RSS</a></p>`)
+		b.WriteString(`</section>`)
+
+		headExtras := seoHead(siteTitle, "喜欢的链接", "喜欢的链接", base+"/liked", "website", "")
+		headExtras += rssAlternateLink("喜欢的链接", base+"/liked/feed.xml")
+		s.writeSSR(c, spa, "喜欢的链接", headExtras, b.String())
+	}
+}
+
+// seoLikedFeedHandler is the RSS feed of the public bookmarks.
+func (s *server) seoLikedFeedHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		base := s.baseURL(c)
+		items, err := s.queryPublicBookmarks(c.Request.Context())
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		title := "喜欢的链接"
+		if siteTitle := s.siteSettings().Title; siteTitle != "" {
+			title = siteTitle + " - " + title
+		}
+		doc := rssDocument{
+			Version: "2.0",
+			Atom:    "http://www.w3.org/2005/Atom",
+			Channel: rssChannel{
+				Title:       title,
+				Link:        base + "/liked",
+				Description: "喜欢的链接",
+				AtomLink:    rssAtomLn{Href: base + "/liked/feed.xml", Rel: "self", Type: "application/rss+xml"},
+			},
+		}
+		for i, it := range items {
+			date := it.CreatedAt.In(s.siteLocation())
+			if i == 0 {
+				doc.Channel.LastBuildDate = date.Format(time.RFC1123Z)
+			}
+			doc.Channel.Items = append(doc.Channel.Items, rssItem{
+				Title:       bookmarkLabel(it),
+				Link:        it.URL,
+				GUID:        rssGUID{Value: "urn:selfecho:bookmark:" + it.ID},
+				PubDate:     date.Format(time.RFC1123Z),
+				Description: html.EscapeString(it.Note),
+			})
+		}
+		out, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Vary", "Host, X-Forwarded-Proto, X-Forwarded-Host")
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), out...))
+	}
+}
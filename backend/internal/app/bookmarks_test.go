@@ -0,0 +1,69 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBookmarkPayloadNormalize(t *testing.T) {
+	p := bookmarkPayload{URL: " https://example.org/a ", Title: "  A   page ", Tags: []string{"Go, #Web", "go"}}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.URL != "https://example.org/a" || p.Title != "A page" || strings.Join(p.Tags, "|") != "go|web" {
+		t.Fatalf("payload = %+v", p)
+	}
+	for _, raw := range []string{"", "example.org", "javascript:alert(1)", "ftp://example.org/"} {
+		if err := (&bookmarkPayload{URL: raw}).normalize(); err == nil {
+			t.Errorf("%q accepted", raw)
+		}
+	}
+}
+
+func TestPageTitle(t *testing.T) {
+	cases := map[string]string{
+		`<html><head><title> Plain &amp; simple </title></head></html>`:                  "Plain & simple",
+		`<head><meta content="Card title" property="og:title"><title>Tab</title></head>`: "Card title",
+		`<head><meta property='og:title' content=''><title>Fallback</title></head>`:      "Fallback",
+		`<svg><title>icon</title></svg>`:                                                 "icon",
+		`<html><body>no title</body></html>`:                                             "",
+	}
+	for doc, want := range cases {
+		if got := pageTitle(doc); got != want {
+			t.Errorf("pageTitle(%q) = %q, want %q", doc, got, want)
+		}
+	}
+}
+
+func TestFormAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	run := func(body string, header string) (int, string) {
+		var auth string
+		r := gin.New()
+		r.POST("/", formAccessToken, func(c *gin.Context) {
+			auth = c.GetHeader("Authorization")
+			c.Status(http.StatusNoContent)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code, auth
+	}
+	if code, auth := run("url=x&access_token=se_abc", ""); code != http.StatusNoContent || auth != "Bearer se_abc" {
+		t.Fatalf("form token: %d %q", code, auth)
+	}
+	if code, _ := run("url=x", "Bearer se_abc"); code != http.StatusNoContent {
+		t.Fatalf("header token: %d", code)
+	}
+	if code, _ := run("url=x", ""); code != http.StatusUnauthorized {
+		t.Fatalf("form without token: %d", code)
+	}
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseCanonicalHost accepts "example.com", "https://example.com" or
+// "http://example.com:8080"; a bare host defaults to https.
+func parseCanonicalHost(raw string) (*url.URL, error) {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || sanitizeScheme(u.Scheme) == "" || sanitizeHost(u.Host) == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("site.canonicalHost 无效: %s", raw)
+	}
+	return &url.URL{Scheme: strings.ToLower(u.Scheme), Host: strings.ToLower(u.Host)}, nil
+}
+
+// canonicalHostMiddleware 301s requests arriving on another host or scheme to
+// the canonical origin. Health checks and /metrics are exempt so load
+// balancers and scrapers probing by IP keep working.
+func (s *server) canonicalHostMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.canonical == nil {
+			c.Next()
+			return
+		}
+		p := strings.TrimPrefix(c.Request.URL.Path, s.basePath)
+		switch p {
+		case "/health", "/api/health", "/healthz", "/metrics":
+			c.Next()
+			return
+		}
+		current, err := url.Parse(s.requestBaseURL(c.Request))
+		if err == nil && strings.EqualFold(current.Host, s.canonical.Host) && current.Scheme == s.canonical.Scheme {
+			c.Next()
+			return
+		}
+		target := s.canonical.Scheme + "://" + s.canonical.Host + c.Request.URL.RequestURI()
+		status := http.StatusMovedPermanently
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			// keep the method and body for API writes
+			status = http.StatusPermanentRedirect
+		}
+		c.Redirect(status, target)
+		c.Abort()
+	}
+}
+
+// originURL is the scheme://host used in absolute links: the canonical host
+// when configured, otherwise whatever the request arrived on.
+func (s *server) originURL(r *http.Request) string {
+	if s.canonical != nil {
+		return s.canonical.Scheme + "://" + s.canonical.Host
+	}
+	return s.requestBaseURL(r)
+}
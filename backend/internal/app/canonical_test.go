@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseCanonicalHost(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"example.com":              "https://example.com",
+		"http://Example.com:8080/": "http://example.com:8080",
+		"https://www.example.com":  "https://www.example.com",
+	}
+	for in, want := range cases {
+		u, err := parseCanonicalHost(in)
+		if err != nil {
+			t.Fatalf("parseCanonicalHost(%q) error: %v", in, err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != want {
+			t.Fatalf("parseCanonicalHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+	for _, bad := range []string{"ftp://example.com", "https://example.com/blog", "exa mple.com"} {
+		if _, err := parseCanonicalHost(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCanonicalHostMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	canonical, _ := parseCanonicalHost("https://example.com")
+	s := &server{basePath: "/blog", canonical: canonical}
+	r := gin.New()
+	r.Use(s.canonicalHostMiddleware())
+	r.NoRoute(func(c *gin.Context) { c.Status(http.StatusOK) })
+	for path, want := range map[string]int{
+		"/blog/post/hello": http.StatusMovedPermanently,
+		"/blog/healthz":    http.StatusOK,
+		"/blog/metrics":    http.StatusOK,
+		"/blog/api/health": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://10.0.0.5"+path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://10.0.0.5/blog/api/articles", nil))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://example.com/blog/api/articles" {
+		t.Fatalf("POST = %d %q", w.Code, w.Header().Get("Location"))
+	}
+}
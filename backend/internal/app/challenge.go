@@ -0,0 +1,334 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// challengeEndpoints are the public write endpoints a challenge can guard.
+var challengeEndpoints = []string{"login", "unlock"}
+
+const (
+	defaultPowBits = 18
+	maxPowBits     = 28
+	powTTL         = 5 * time.Minute
+	// challengeHeader carries the captcha token or the proof-of-work
+	// solution; HTML forms may post it as a field instead, see
+	// challengeResponse.
+	challengeHeader = "X-Challenge-Response"
+)
+
+type captchaKeys struct {
+	SiteKey string `yaml:"siteKey"`
+	Secret  string `yaml:"secret"`
+}
+
+// challengeConfig puts a human check in front of public write endpoints.
+// Endpoints maps an endpoint (see challengeEndpoints) to turnstile, hcaptcha
+// or pow. An endpoint set to a captcha whose keys are missing falls back to
+// proof of work, which needs no third party.
+type challengeConfig struct {
+	Turnstile captchaKeys `yaml:"turnstile"`
+	HCaptcha  captchaKeys `yaml:"hcaptcha"`
+	// PowBits is the proof-of-work difficulty in leading zero bits.
+	PowBits   int               `yaml:"powBits"`
+	Endpoints map[string]string `yaml:"endpoints"`
+}
+
+// captchaProvider verifies widget tokens against a siteverify API; Turnstile
+// and hCaptcha share the protocol.
+type captchaProvider struct {
+	name      string
+	verifyURL string
+	keys      captchaKeys
+	client    *http.Client
+}
+
+func (p *captchaProvider) configured() bool {
+	return p.keys.SiteKey != "" && p.keys.Secret != ""
+}
+
+var errChallengeRejected = errors.New("challenge rejected")
+
+func (p *captchaProvider) verify(ctx context.Context, response, remoteIP string) error {
+	form := url.Values{"secret": {p.keys.Secret}, "response": {response}, "remoteip": {remoteIP}, "sitekey": {p.keys.SiteKey}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s siteverify: %s", p.name, resp.Status)
+	}
+	var out struct {
+		Success bool     `json:"success"`
+		Errors  []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return err
+	}
+	if !out.Success {
+		return fmt.Errorf("%w: %s", errChallengeRejected, strings.Join(out.Errors, ", "))
+	}
+	return nil
+}
+
+// powIssuer hands out stateless, signed proof-of-work puzzles. A solution is
+// a nonce such that sha256(challenge + "." + nonce) starts with bits zero
+// bits. Solved challenges are remembered until they expire so each one is
+// good for a single request.
+type powIssuer struct {
+	key  []byte
+	bits int
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func newPowIssuer(bits int) (*powIssuer, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &powIssuer{key: key, bits: bits, used: map[string]time.Time{}}, nil
+}
+
+func (p *powIssuer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+// issue returns a challenge: 16 random bytes and an expiry, signed.
+func (p *powIssuer) issue(now time.Time) (string, error) {
+	payload := make([]byte, 24)
+	if _, err := rand.Read(payload[:16]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(payload[16:], uint64(now.Add(powTTL).Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(payload, p.sign(payload)...)), nil
+}
+
+func leadingZeroBits(sum []byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// check validates a "challenge.nonce" solution.
+func (p *powIssuer) check(solution string, now time.Time) error {
+	challenge, nonce, ok := strings.Cut(solution, ".")
+	if !ok || nonce == "" || len(nonce) > 64 {
+		return errChallengeRejected
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(raw) != 40 || !hmac.Equal(raw[24:], p.sign(raw[:24])) {
+		return errChallengeRejected
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(raw[16:24])), 0)
+	if now.After(expires) {
+		return errChallengeRejected
+	}
+	sum := sha256.Sum256([]byte(solution))
+	if leadingZeroBits(sum[:]) < p.bits {
+		return errChallengeRejected
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, exp := range p.used {
+		if now.After(exp) {
+			delete(p.used, k)
+		}
+	}
+	if _, seen := p.used[challenge]; seen {
+		return errChallengeRejected
+	}
+	p.used[challenge] = expires
+	return nil
+}
+
+// challengeGate knows which provider guards each endpoint. A nil gate
+// guards nothing.
+type challengeGate struct {
+	endpoints map[string]string
+	captchas  map[string]*captchaProvider
+	pow       *powIssuer
+}
+
+func newChallengeGate(cfg challengeConfig, client *http.Client) (*challengeGate, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, nil
+	}
+	bits := cfg.PowBits
+	if bits == 0 {
+		bits = defaultPowBits
+	}
+	if bits < 1 || bits > maxPowBits {
+		return nil, fmt.Errorf("challenge.powBits 需在 1-%d 之间: %d", maxPowBits, cfg.PowBits)
+	}
+	pow, err := newPowIssuer(bits)
+	if err != nil {
+		return nil, err
+	}
+	g := &challengeGate{
+		endpoints: map[string]string{},
+		captchas: map[string]*captchaProvider{
+			"turnstile": {name: "turnstile", verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify", keys: cfg.Turnstile, client: client},
+			"hcaptcha":  {name: "hcaptcha", verifyURL: "https://api.hcaptcha.com/siteverify", keys: cfg.HCaptcha, client: client},
+		},
+		pow: pow,
+	}
+	for endpoint, provider := range cfg.Endpoints {
+		if !slices.Contains(challengeEndpoints, endpoint) {
+			return nil, fmt.Errorf("challenge.endpoints: 未知的接口 %s (可用: %s)", endpoint, strings.Join(challengeEndpoints, ", "))
+		}
+		switch provider {
+		case "", "none":
+			continue
+		case "pow":
+		case "turnstile", "hcaptcha":
+			if !g.captchas[provider].configured() {
+				provider = "pow"
+			}
+		default:
+			return nil, fmt.Errorf("challenge.endpoints.%s: 未知的验证方式 %s", endpoint, provider)
+		}
+		g.endpoints[endpoint] = provider
+	}
+	return g, nil
+}
+
+func (g *challengeGate) provider(endpoint string) string {
+	if g == nil {
+		return ""
+	}
+	return g.endpoints[endpoint]
+}
+
+// challengeResponse reads the client's answer from the header, or from the
+// form fields the Turnstile and hCaptcha widgets fill in on a plain form post.
+func challengeResponse(c *gin.Context) string {
+	if v := c.GetHeader(challengeHeader); v != "" {
+		return v
+	}
+	for _, field := range []string{"cf-turnstile-response", "h-captcha-response", "challenge"} {
+		if v := c.PostForm(field); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// requireChallenge guards a public write endpoint with whatever the config
+// assigns to it; unguarded endpoints pass straight through.
+func (s *server) requireChallenge(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider := s.challenges.provider(endpoint)
+		if provider == "" {
+			c.Next()
+			return
+		}
+		response := challengeResponse(c)
+		if response == "" {
+			s.security.challengeRejected(true)
+			respondError(c, http.StatusForbidden, errChallengeRequired)
+			c.Abort()
+			return
+		}
+		var err error
+		if provider == "pow" {
+			err = s.challenges.pow.check(response, time.Now())
+		} else {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+			err = s.challenges.captchas[provider].verify(ctx, response, c.ClientIP())
+			cancel()
+		}
+		switch {
+		case errors.Is(err, errChallengeRejected):
+			s.security.challengeRejected(false)
+			respondError(c, http.StatusForbidden, errChallengeFailed)
+			c.Abort()
+			return
+		case err != nil:
+			respondErrorDetail(c, http.StatusServiceUnavailable, errChallengeUnavailable, err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// getChallenge backs GET /api/challenge/:endpoint and tells the client what
+// to solve before calling the endpoint: nothing, a captcha widget with its
+// site key, or a fresh proof-of-work puzzle.
+func (s *server) getChallenge(c *gin.Context) {
+	endpoint := c.Param("endpoint")
+	provider := s.challenges.provider(endpoint)
+	switch provider {
+	case "":
+		c.JSON(http.StatusOK, gin.H{"provider": "none"})
+	case "pow":
+		challenge, err := s.challenges.pow.issue(time.Now())
+		if err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errChallengeUnavailable, err)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"provider": "pow", "challenge": challenge, "bits": s.challenges.pow.bits, "header": challengeHeader})
+	default:
+		c.JSON(http.StatusOK, gin.H{"provider": provider, "siteKey": s.challenges.captchas[provider].keys.SiteKey, "header": challengeHeader})
+	}
+}
+
+// powSolver solves the puzzle in the browser before a plain form submits;
+// the solution goes into the "challenge" field.
+const powSolver = `<input type="hidden" name="challenge"><script>(function(f,u){` +
+	`function z(d){for(var n=0,i=0;i<d.length;i++){if(d[i]){return n+Math.clz32(d[i])-24}n+=8}return n}` +
+	`f.addEventListener('submit',async function(e){var h=f.elements.challenge;if(h.value)return;e.preventDefault();` +
+	`var r=await(await fetch(u)).json(),t=new TextEncoder(),n=0;` +
+	`while(z(new Uint8Array(await crypto.subtle.digest('SHA-256',t.encode(r.challenge+'.'+n))))<r.bits)n++;` +
+	`h.value=r.challenge+'.'+n;f.submit()})})(document.currentScript.closest('form'),%q)</script>`
+
+// challengeWidget is the markup an SSR form needs to pass the challenge
+// guarding endpoint, or "" when it is not guarded.
+func (s *server) challengeWidget(endpoint string) string {
+	switch provider := s.challenges.provider(endpoint); provider {
+	case "":
+		return ""
+	case "pow":
+		return fmt.Sprintf(powSolver, s.basePath+"/api/challenge/"+endpoint)
+	case "turnstile":
+		return `<div class="cf-turnstile" data-sitekey="` + html.EscapeString(s.challenges.captchas[provider].keys.SiteKey) + `"></div>` +
+			`<script src="https://challenges.cloudflare.com/turnstile/v0/api.js" async defer></script>`
+	default:
+		return `<div class="h-captcha" data-sitekey="` + html.EscapeString(s.challenges.captchas[provider].keys.SiteKey) + `"></div>` +
+			`<script src="https://js.hcaptcha.com/1/api.js" async defer></script>`
+	}
+}
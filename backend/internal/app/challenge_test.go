@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func solvePow(t *testing.T, challenge string, bits int) string {
+	t.Helper()
+	for n := 0; n < 1<<24; n++ {
+		solution := challenge + "." + strconv.Itoa(n)
+		sum := sha256.Sum256([]byte(solution))
+		if leadingZeroBits(sum[:]) >= bits {
+			return solution
+		}
+	}
+	t.Fatal("no solution found")
+	return ""
+}
+
+func TestPowIssuer(t *testing.T) {
+	p, err := newPowIssuer(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	challenge, _ := p.issue(now)
+	solution := solvePow(t, challenge, 8)
+	if err := p.check(solution, now); err != nil {
+		t.Fatalf("valid solution rejected: %v", err)
+	}
+	if err := p.check(solution, now); !errors.Is(err, errChallengeRejected) {
+		t.Fatal("a solution must not be accepted twice")
+	}
+
+	other, _ := p.issue(now)
+	if err := p.check(solvePow(t, other, 8), now.Add(powTTL+time.Second)); err == nil {
+		t.Fatal("expired challenge accepted")
+	}
+	forged, _ := newPowIssuer(8)
+	foreign, _ := forged.issue(now)
+	if err := p.check(solvePow(t, foreign, 8), now); err == nil {
+		t.Fatal("challenge signed by another key accepted")
+	}
+}
+
+func TestCaptchaVerify(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("secret") != "sekrit" {
+			t.Errorf("secret = %q", r.PostForm.Get("secret"))
+		}
+		if r.PostForm.Get("response") == "good" {
+			w.Write([]byte(`{"success":true}`))
+			return
+		}
+		w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer api.Close()
+	p := &captchaProvider{name: "turnstile", verifyURL: api.URL, keys: captchaKeys{SiteKey: "site", Secret: "sekrit"}, client: api.Client()}
+	if err := p.verify(context.Background(), "good", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.verify(context.Background(), "bad", "192.0.2.1"); !errors.Is(err, errChallengeRejected) {
+		t.Fatalf("err = %v, want errChallengeRejected", err)
+	}
+}
+
+func TestRequireChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	gate, err := newChallengeGate(challengeConfig{PowBits: 4, Endpoints: map[string]string{"unlock": "turnstile"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gate.provider("unlock"); got != "pow" {
+		t.Fatalf("turnstile without keys should fall back to pow, got %q", got)
+	}
+	s := &server{challenges: gate}
+	r := gin.New()
+	r.GET("/challenge/:endpoint", s.getChallenge)
+	r.POST("/unlock", s.requireChallenge("unlock"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.POST("/login", s.requireChallenge("login"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	post := func(path, response string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if response != "" {
+			req.Header.Set(challengeHeader, response)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	if got := post("/login", ""); got != http.StatusNoContent {
+		t.Fatalf("unguarded endpoint: %d", got)
+	}
+	if got := post("/unlock", ""); got != http.StatusForbidden {
+		t.Fatalf("missing response: %d", got)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/challenge/unlock", nil))
+	body := w.Body.String()
+	i := strings.Index(body, `"challenge":"`)
+	if i < 0 {
+		t.Fatalf("no challenge in %s", body)
+	}
+	challenge := body[i+len(`"challenge":"`):]
+	challenge = challenge[:strings.Index(challenge, `"`)]
+	if got := post("/unlock", solvePow(t, challenge, 4)); got != http.StatusNoContent {
+		t.Fatalf("solved challenge: %d", got)
+	}
+}
+
+func TestChallengeConfigErrors(t *testing.T) {
+	for _, cfg := range []challengeConfig{
+		{Endpoints: map[string]string{"comments": "pow"}},
+		{Endpoints: map[string]string{"login": "recaptcha"}},
+		{PowBits: 64, Endpoints: map[string]string{"login": "pow"}},
+	} {
+		if _, err := newChallengeGate(cfg, nil); err == nil {
+			t.Errorf("%+v should be rejected", cfg)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxArticleAuthors = 10
+
+// ensureCoauthorSchema adds article_authors, which lists everyone credited on
+// an article in byline order. articles.author_id stays the first of them;
+// articles that predate the table are credited to that author.
+func (s *server) ensureCoauthorSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS article_authors (
+			article_id UUID NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			position INT NOT NULL DEFAULT 0,
+			PRIMARY KEY (article_id, user_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_authors_user ON article_authors(user_id);
+		INSERT INTO article_authors (article_id, user_id)
+		SELECT id, author_id FROM articles WHERE author_id IS NOT NULL
+		ON CONFLICT DO NOTHING;
+	`)
+	return err
+}
+
+// resolveAuthors maps usernames to user ids, in order and without
+// duplicates. Unknown usernames are an error naming the first of them.
+func resolveAuthors(ctx context.Context, q sqlQuerier, usernames []string) ([]string, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, name := range usernames {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		var id string
+		err := q.QueryRowContext(ctx, `SELECT id FROM users WHERE username=$1`, name).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, newAPIError(errAuthorNotFound, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, newAPIError(errInvalidAuthors)
+	}
+	return ids, nil
+}
+
+// setArticleAuthors replaces the byline of articleID with userIDs, the first
+// becoming the primary author.
+func setArticleAuthors(ctx context.Context, q sqlQuerier, articleID string, userIDs []string) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM article_authors WHERE article_id=$1`, articleID); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `
+		INSERT INTO article_authors (article_id, user_id, position)
+		SELECT $1::uuid, u::uuid, ord - 1 FROM unnest($2::text[]) WITH ORDINALITY AS t(u, ord)`, articleID, userIDs); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, `UPDATE articles SET author_id=$2 WHERE id=$1`, articleID, userIDs[0])
+	return err
+}
+
+// payloadAuthors resolves the payload's byline, defaulting to fallback (the
+// signed-in user) when the payload leaves it out.
+func payloadAuthors(ctx context.Context, q sqlQuerier, p articlePayload, fallback string) ([]string, error) {
+	if p.Authors == nil {
+		return []string{fallback}, nil
+	}
+	return resolveAuthors(ctx, q, *p.Authors)
+}
+
+// canEditArticle reports whether u may change article id: admins may edit
+// everything, other users only the articles they are credited on. Missing
+// articles are allowed through so the caller answers 404; malformed ids are
+// refused, and callers answer those 404 with idParam first.
+func canEditArticle(ctx context.Context, q sqlQuerier, u user, id string) (bool, error) {
+	if u.Role == "admin" {
+		return true, nil
+	}
+	if !store.ValidID(id) {
+		return false, nil
+	}
+	var ok bool
+	err := q.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM articles WHERE id=$1)
+		    OR EXISTS (SELECT 1 FROM article_authors WHERE article_id=$1 AND user_id=$2)`, id, u.ID).Scan(&ok)
+	return ok, err
+}
+
+// checkArticleAuthor writes 403 unless the signed-in user may edit article
+// id; see canEditArticle.
+func (s *server) checkArticleAuthor(c *gin.Context, id string) bool {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return false
+	}
+	allowed, err := canEditArticle(c.Request.Context(), s.db, *u, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return false
+	}
+	if !allowed {
+		respondError(c, http.StatusForbidden, errNotArticleAuthor)
+		return false
+	}
+	return true
+}
+
+// queryBylines lists the authors of article id in byline order.
+func (s *server) queryBylines(ctx context.Context, id string) ([]byline, error) {
+	rows, err := s.readQuery(ctx, `
+		SELECT u.username, u.display_name
+		FROM article_authors aa JOIN users u ON u.id = aa.user_id
+		WHERE aa.article_id = $1
+		ORDER BY aa.position, u.username`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []byline
+	for rows.Next() {
+		var b byline
+		if err := rows.Scan(&b.Username, &b.DisplayName); err != nil {
+			return nil, err
+		}
+		items = append(items, b)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,14 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCanEditArticleRefusesMalformedID(t *testing.T) {
+	// a malformed id never reaches the database, so no querier is needed
+	ok, err := canEditArticle(context.Background(), nil, user{ID: "u1", Role: "author"}, "not-a-uuid")
+	if err != nil || ok {
+		t.Fatalf("canEditArticle = %v, %v; want false", ok, err)
+	}
+}
@@ -0,0 +1,368 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "******"
+
+func applyEnvOverrides(cfg *config) {
+	if env := os.Getenv("SELFECHO_ENV"); env != "" {
+		cfg.Env = env
+	}
+	if env := os.Getenv("IMAP_SECRET"); env != "" {
+		cfg.ImapSecret = env
+	}
+	if env := os.Getenv("CONTENT_SECRET"); env != "" {
+		cfg.Encryption.Secret = env
+	}
+	if env := os.Getenv("METRICS_TOKEN"); env != "" {
+		cfg.Metrics.Token = env
+	}
+	if env := os.Getenv("DEEPSEEK_API_KEY"); env != "" {
+		cfg.Deepseek.APIKey = env
+	}
+	if env := os.Getenv("BACKUP_SECRET"); env != "" {
+		cfg.Backup.Secret = env
+	}
+}
+
+// unknownConfigKeys re-decodes raw strictly and returns one message per key
+// that does not map onto the config struct (typos are otherwise ignored).
+func unknownConfigKeys(raw []byte) []string {
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	var strict config
+	err := dec.Decode(&strict)
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return nil
+	}
+	var keys []string
+	for _, msg := range typeErr.Errors {
+		if strings.Contains(msg, "not found in type") {
+			keys = append(keys, msg)
+		}
+	}
+	return keys
+}
+
+func redactConfig(cfg config) config {
+	if cfg.Database.Password != "" {
+		cfg.Database.Password = redacted
+	}
+	cfg.Database.URL = redactURLPassword(cfg.Database.URL)
+	cfg.Database.ReadURL = redactURLPassword(cfg.Database.ReadURL)
+	if cfg.ImapSecret != "" {
+		cfg.ImapSecret = redacted
+	}
+	if cfg.Encryption.Secret != "" {
+		cfg.Encryption.Secret = redacted
+	}
+	if cfg.Deepseek.APIKey != "" {
+		cfg.Deepseek.APIKey = redacted
+	}
+	if cfg.Metrics.Token != "" {
+		cfg.Metrics.Token = redacted
+	}
+	if cfg.Challenge.Turnstile.Secret != "" {
+		cfg.Challenge.Turnstile.Secret = redacted
+	}
+	if cfg.Challenge.HCaptcha.Secret != "" {
+		cfg.Challenge.HCaptcha.Secret = redacted
+	}
+	hooks := make([]webhookConfig, len(cfg.Webhooks))
+	for i, h := range cfg.Webhooks {
+		if h.Secret != "" {
+			h.Secret = redacted
+		}
+		hooks[i] = h
+	}
+	cfg.Webhooks = hooks
+	if cfg.SMTP.Password != "" {
+		cfg.SMTP.Password = redacted
+	}
+	if cfg.GitSync.WebhookSecret != "" {
+		cfg.GitSync.WebhookSecret = redacted
+	}
+	cfg.GitSync.Remote = redactURLPassword(cfg.GitSync.Remote)
+	if cfg.Backup.Secret != "" {
+		cfg.Backup.Secret = redacted
+	}
+	targets := make([]backupTargetConfig, len(cfg.Backup.Targets))
+	for i, t := range cfg.Backup.Targets {
+		if t.SecretKey != "" {
+			t.SecretKey = redacted
+		}
+		if t.Password != "" {
+			t.Password = redacted
+		}
+		t.URL = redactURLPassword(t.URL)
+		targets[i] = t
+	}
+	cfg.Backup.Targets = targets
+	return cfg
+}
+
+func redactURLPassword(raw string) string {
+	u, err := url.Parse(raw)
+	if raw == "" || err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+		return u.String()
+	}
+	return raw
+}
+
+type configReport struct {
+	w      io.Writer
+	failed int
+}
+
+func (r *configReport) ok(item, format string, args ...any) {
+	fmt.Fprintf(r.w, "[ok]    %-16s %s\n", item, fmt.Sprintf(format, args...))
+}
+
+func (r *configReport) warn(item, format string, args ...any) {
+	fmt.Fprintf(r.w, "[warn]  %-16s %s\n", item, fmt.Sprintf(format, args...))
+}
+
+func (r *configReport) fail(item, format string, args ...any) {
+	r.failed++
+	fmt.Fprintf(r.w, "[error] %-16s %s\n", item, fmt.Sprintf(format, args...))
+}
+
+// CheckConfig backs `selfecho config check`: it loads the config exactly like
+// Run (file + env overrides), validates every section it can verify without
+// starting the server, and prints the effective configuration with secrets
+// redacted. It returns an error when any check failed.
+func CheckConfig(w io.Writer) error {
+	cfgPath := resolveConfigPath()
+	r := &configReport{w: w}
+	fmt.Fprintf(w, "config: %s\n\n", cfgPath)
+
+	if raw, err := os.ReadFile(cfgPath); err != nil {
+		r.warn("file", "无法读取 (%v)，使用默认配置", err)
+	} else {
+		keys := unknownConfigKeys(raw)
+		for _, key := range keys {
+			r.fail("file", "未知配置项: %s", key)
+		}
+		if len(keys) == 0 {
+			r.ok("file", "没有未知配置项")
+		}
+	}
+
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		r.fail("config", "%v", err)
+		return fmt.Errorf("配置检查失败: %d 项错误", r.failed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if db, err := ensureDB(ctx, cfg.Database); err != nil {
+		r.fail("database", "%v", err)
+	} else {
+		r.ok("database", "连接成功")
+		db.Close()
+	}
+	if replicaCfg, ok := replicaConfig(cfg.Database); ok {
+		if db, err := ensureDB(ctx, replicaCfg); err != nil {
+			r.warn("database.readUrl", "%v，读请求将使用主库", err)
+		} else {
+			r.ok("database.readUrl", "连接成功")
+			db.Close()
+		}
+	}
+
+	staticDir := resolveStaticDir(cfgPath, cfg.StaticDir)
+	switch {
+	case diskSPA(staticDir) != nil:
+		r.ok("staticDir", "%s", staticDir)
+	case embeddedSPA() != nil:
+		r.ok("staticDir", "%s 不可用，使用内嵌的前端构建", staticDir)
+	default:
+		r.warn("staticDir", "%s 中没有 index.html，前端页面将不可用", staticDir)
+	}
+
+	mediaDir := resolveMediaDir(cfgPath, cfg.Static.MediaDir)
+	if info, err := os.Stat(mediaDir); err != nil || !info.IsDir() {
+		r.warn("static.mediaDir", "%s 不存在，/media 不会挂载", mediaDir)
+	} else {
+		r.ok("static.mediaDir", "%s", mediaDir)
+	}
+	if cfg.Static.ImageCache.Enabled {
+		if info, err := os.Stat(mediaDir); err != nil || !info.IsDir() {
+			r.warn("static.imageCache", "需要可用的 mediaDir，外链图片缓存不会启用")
+		} else {
+			r.ok("static.imageCache", "%s", filepath.Join(mediaDir, imageCacheSubdir))
+		}
+	}
+
+	if filesDir := resolveMediaDir(cfgPath, cfg.Static.FilesDir); filesDir != "" {
+		r.ok("static.filesDir", "%s", filesDir)
+	}
+
+	if cfg.Analytics.Enabled {
+		if geo := resolveMediaDir(cfgPath, cfg.Analytics.GeoIPDB); geo == "" {
+			r.ok("analytics", "已启用，未配置 GeoIP 数据库")
+		} else if db, err := loadGeoIPDB(geo); err != nil {
+			r.warn("analytics.geoipDb", "%s 无法加载 (%v)，不记录国家", geo, err)
+		} else {
+			r.ok("analytics.geoipDb", "%s (%d 个范围)", geo, len(db.ranges))
+		}
+	}
+
+	if cmd := cfg.Export.PDFCommand; len(cmd) > 0 {
+		if bin, err := exec.LookPath(cmd[0]); err != nil {
+			r.warn("export.pdfCommand", "找不到 %s，PDF 导出会失败", cmd[0])
+		} else {
+			r.ok("export.pdfCommand", "%s", bin)
+		}
+	}
+
+	if d, err := cfg.Database.queryTimeout(); err != nil {
+		r.fail("database.queryTimeout", "%v", err)
+	} else if d == 0 {
+		r.warn("database.queryTimeout", "已关闭，慢查询可能堆积")
+	} else {
+		r.ok("database.queryTimeout", "%s", d)
+	}
+
+	if bp := normalizeURLPrefix(cfg.BasePath); bp != "" {
+		r.ok("basePath", "%s", bp)
+	}
+	if u, err := parseCanonicalHost(cfg.Site.CanonicalHost); err != nil {
+		r.fail("canonicalHost", "%v", err)
+	} else if u != nil {
+		r.ok("canonicalHost", "%s", u)
+	}
+	if pt, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		r.fail("trustedProxies", "%v", err)
+	} else if list := pt.strings(); len(list) > 0 {
+		r.ok("trustedProxies", "%s", strings.Join(list, ", "))
+	} else {
+		r.ok("trustedProxies", "未配置，忽略 X-Forwarded-* 头")
+	}
+
+	switch {
+	case cfg.production() && (cfg.ImapSecret == "" || cfg.ImapSecret == defaultImapSecret):
+		r.fail("imapSecret", "%v", errDefaultImapSecret)
+	case cfg.ImapSecret == "":
+		r.warn("imapSecret", "未设置，IMAP 密码将使用默认密钥加密")
+	default:
+		r.ok("imapSecret", "已设置")
+	}
+	switch {
+	case !cfg.Encryption.Enabled:
+		r.ok("encryption", "未启用，草稿与密码文章以明文存储")
+	case cfg.Encryption.Secret == "":
+		r.fail("encryption", "已启用但未设置 secret / CONTENT_SECRET")
+	default:
+		r.ok("encryption", "已启用")
+	}
+	if g, err := newAdminGuard(cfg); err != nil {
+		r.fail("admin", "%v", err)
+	} else if g == nil {
+		r.ok("admin", "未限制来源，仅依赖登录")
+	} else {
+		r.ok("admin", "允许网段 %d 个，客户端证书: %t", len(g.allow), g.requireTLS || g.certHeader != "")
+	}
+	if _, err := serverTLSConfig(cfg); err != nil {
+		r.fail("tls", "%v", err)
+	} else if cfg.TLS.CertFile != "" {
+		r.ok("tls", "%s", cfg.TLS.CertFile)
+	}
+	if g, err := newChallengeGate(cfg.Challenge, nil); err != nil {
+		r.fail("challenge", "%v", err)
+	} else if g == nil {
+		r.ok("challenge", "未启用")
+	} else {
+		for _, endpoint := range challengeEndpoints {
+			configured, used := cfg.Challenge.Endpoints[endpoint], g.provider(endpoint)
+			if used != "" && used != configured {
+				r.warn("challenge", "%s: %s 未配置 siteKey/secret，改用 %s", endpoint, configured, used)
+			} else if used != "" {
+				r.ok("challenge", "%s: %s", endpoint, used)
+			}
+		}
+	}
+	if err := validateWebhooks(cfg.Webhooks); err != nil {
+		r.fail("webhooks", "%v", err)
+	} else if len(cfg.Webhooks) > 0 {
+		r.ok("webhooks", "%d 个", len(cfg.Webhooks))
+	}
+	if err := validateSMTP(cfg.SMTP); err != nil {
+		r.fail("smtp", "%v", err)
+	} else if cfg.SMTP.enabled() {
+		r.ok("smtp", "%s:%d", cfg.SMTP.Host, cfg.SMTP.port())
+	}
+	if err := validateGitSync(cfg.GitSync); err != nil {
+		r.fail("gitSync", "%v", err)
+	} else if cfg.GitSync.Enabled {
+		if bin, err := exec.LookPath(cfg.GitSync.command()); err != nil {
+			r.fail("gitSync", "找不到 %s", cfg.GitSync.command())
+		} else if cfg.GitSync.Remote == "" {
+			r.ok("gitSync", "%s -> %s (仅本地)", bin, resolveMediaDir(cfgPath, cfg.GitSync.Dir))
+		} else {
+			r.ok("gitSync", "%s -> %s (%s)", bin, resolveMediaDir(cfgPath, cfg.GitSync.Dir), redactURLPassword(cfg.GitSync.Remote))
+		}
+		if cfg.Encryption.Enabled && !cfg.GitSync.ExcludeDrafts {
+			r.ok("gitSync.excludeDrafts", "encryption 已启用，草稿不写入仓库")
+		}
+	}
+	if err := validateBackup(cfg.Backup); err != nil {
+		r.fail("backup", "%v", err)
+	} else if cfg.Backup.Enabled {
+		if _, err := exec.LookPath(cfg.Backup.command()[0]); err != nil {
+			r.fail("backup", "找不到 %s", cfg.Backup.command()[0])
+		} else {
+			labels := make([]string, len(cfg.Backup.Targets))
+			for i, t := range cfg.Backup.Targets {
+				labels[i] = t.label(i)
+			}
+			r.ok("backup", "%s，保留 %d 份", strings.Join(labels, ", "), cfg.Backup.keep())
+		}
+		if mode := cfg.Backup.Bulk.mode(); mode != bulkInclude {
+			r.ok("backup.bulk", "%s: %s", mode, strings.Join(cfg.Backup.Bulk.tables(), ", "))
+		}
+		if cfg.Backup.Secret == "" {
+			r.warn("backup.secret", "未设置，备份以明文存储")
+		}
+	}
+	if p, err := cfg.Session.policy(); err != nil {
+		r.fail("session", "%v", err)
+	} else {
+		r.ok("session", "闲置 %s，最长 %s，记住我 %s", p.ttl, p.maxAge, p.rememberTTL)
+	}
+	if cfg.Deepseek.APIKey == "" {
+		r.warn("deepseek", "未配置 apiKey，AI slug 生成不可用")
+	} else {
+		r.ok("deepseek", "%s (%s)", cfg.Deepseek.BaseURL, cfg.Deepseek.Model)
+	}
+
+	out, err := yaml.Marshal(redactConfig(cfg))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n# effective config\n%s", out)
+
+	if r.failed > 0 {
+		return fmt.Errorf("配置检查失败: %d 项错误", r.failed)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnknownConfigKeys(t *testing.T) {
+	raw := []byte("site:\n  titel: Demo\nport: 8080\nstaticdir: ./static\n")
+	keys := unknownConfigKeys(raw)
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 unknown keys, got %v", keys)
+	}
+	if !strings.Contains(keys[0], "titel") || !strings.Contains(keys[1], "staticdir") {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if keys := unknownConfigKeys([]byte("site:\n  title: Demo\n")); len(keys) != 0 {
+		t.Fatalf("expected no unknown keys, got %v", keys)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Deepseek.APIKey = "sk-123"
+	got := redactConfig(cfg)
+	if got.Database.Password != redacted || got.Deepseek.APIKey != redacted || got.ImapSecret != "" {
+		t.Fatalf("unexpected redaction: %+v", got)
+	}
+}
+
+func TestBuildDSN(t *testing.T) {
+	dsn, err := BuildDSN(dbConfig{Host: "db", Port: 5432, User: "u", Password: "p w'x", Name: "blog", Options: map[string]string{"connect_timeout": "5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `host=db port=5432 user=u password='p w\'x' dbname=blog sslmode=disable connect_timeout=5`; dsn != want {
+		t.Fatalf("got %q, want %q", dsn, want)
+	}
+	dsn, err = BuildDSN(dbConfig{URL: "postgres://u:p@db/blog?sslmode=require", Options: map[string]string{"statement_cache_capacity": "0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "postgres://u:p@db/blog?sslmode=require&statement_cache_capacity=0"; dsn != want {
+		t.Fatalf("got %q, want %q", dsn, want)
+	}
+	if _, err := BuildDSN(dbConfig{URL: "mysql://db"}); err == nil {
+		t.Fatal("expected error for non-postgres url")
+	}
+	cfg := redactConfig(config{Database: dbConfig{URL: "postgres://u:secret@db/blog"}})
+	if strings.Contains(cfg.Database.URL, "secret") {
+		t.Fatalf("expected url password redacted, got %q", cfg.Database.URL)
+	}
+}
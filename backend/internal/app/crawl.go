@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchCrawler names the search engine behind a user agent, or "" for
+// everything else.
+func searchCrawler(ua string) string {
+	if class, agent := classifyUA(ua); class == uaSearch {
+		return agent
+	}
+	return ""
+}
+
+func (s *server) ensureCrawlSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS crawler_fetches (
+			crawler TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			fetches BIGINT NOT NULL DEFAULT 0,
+			first_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (crawler, kind, target)
+		);
+	`)
+	return err
+}
+
+// trackCrawl wraps an SSR handler and counts successful fetches by search
+// crawlers. It sits outside cachedSSR so cache hits are counted too. kind is
+// "sitemap" or "post"; posts are keyed by slug.
+func (s *server) trackCrawl(kind string, h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h(c)
+		crawler := searchCrawler(c.Request.UserAgent())
+		if crawler == "" {
+			return
+		}
+		if st := c.Writer.Status(); st != http.StatusOK && st != http.StatusNotModified {
+			return
+		}
+		target := ""
+		if kind == "post" {
+			target = c.Param("slug")
+		}
+		go s.recordCrawl(crawler, kind, target)
+	}
+}
+
+func (s *server) recordCrawl(crawler, kind, target string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO crawler_fetches (crawler, kind, target, fetches)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (crawler, kind, target) DO UPDATE
+		SET fetches = crawler_fetches.fetches + 1, last_at = now()`,
+		crawler, kind, target)
+	if err != nil {
+		warnf("记录爬虫抓取失败: %v\n", err)
+	}
+}
+
+type crawlerFetch struct {
+	Crawler string    `json:"crawler"`
+	Fetches int64     `json:"fetches"`
+	FirstAt time.Time `json:"firstAt"`
+	LastAt  time.Time `json:"lastAt"`
+}
+
+type postCrawlStats struct {
+	ID       string         `json:"id"`
+	Title    string         `json:"title"`
+	Slug     string         `json:"slug"`
+	Crawlers []crawlerFetch `json:"crawlers"`
+}
+
+// crawlStats reports when each search crawler last fetched the sitemap and
+// every published post; posts never crawled are listed with no crawlers.
+func (s *server) crawlStats(c *gin.Context) {
+	ctx := c.Request.Context()
+	loc := s.siteLocation()
+
+	rows, err := s.readQuery(ctx, `
+		SELECT crawler, fetches, first_at, last_at
+		FROM crawler_fetches WHERE kind='sitemap'
+		ORDER BY crawler`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryCrawlStatsFailed)
+		return
+	}
+	sitemap := []crawlerFetch{}
+	for rows.Next() {
+		var f crawlerFetch
+		if err := rows.Scan(&f.Crawler, &f.Fetches, &f.FirstAt, &f.LastAt); err != nil {
+			rows.Close()
+			respondError(c, http.StatusInternalServerError, errQueryCrawlStatsFailed)
+			return
+		}
+		f.FirstAt, f.LastAt = f.FirstAt.In(loc), f.LastAt.In(loc)
+		sitemap = append(sitemap, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryCrawlStatsFailed)
+		return
+	}
+
+	rows, err = s.readQuery(ctx, `
+		SELECT a.id, a.title, a.slug, f.crawler, f.fetches, f.first_at, f.last_at
+		FROM articles a
+		LEFT JOIN crawler_fetches f ON f.kind='post' AND f.target = a.slug
+		WHERE a.status='published'
+		ORDER BY COALESCE(a.published_at, a.created_at) DESC, a.id, f.crawler`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryCrawlStatsFailed)
+		return
+	}
+	defer rows.Close()
+	posts := []postCrawlStats{}
+	for rows.Next() {
+		var (
+			p       postCrawlStats
+			crawler *string
+			fetches *int64
+			first   *time.Time
+			last    *time.Time
+		)
+		if err := rows.Scan(&p.ID, &p.Title, &p.Slug, &crawler, &fetches, &first, &last); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryCrawlStatsFailed)
+			return
+		}
+		if n := len(posts); n == 0 || posts[n-1].ID != p.ID {
+			p.Crawlers = []crawlerFetch{}
+			posts = append(posts, p)
+		}
+		if crawler != nil {
+			cur := &posts[len(posts)-1]
+			cur.Crawlers = append(cur.Crawlers, crawlerFetch{
+				Crawler: *crawler, Fetches: *fetches, FirstAt: first.In(loc), LastAt: last.In(loc),
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryCrawlStatsFailed)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sitemap": sitemap, "posts": posts})
+}
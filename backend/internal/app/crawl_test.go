@@ -0,0 +1,17 @@
+package app
+
+import "testing"
+
+func TestSearchCrawler(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": "googlebot",
+		"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)":  "bingbot",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15":             "",
+		"": "",
+	}
+	for ua, want := range cases {
+		if got := searchCrawler(ua); got != want {
+			t.Errorf("searchCrawler(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
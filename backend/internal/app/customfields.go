@@ -0,0 +1,85 @@
+package app
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxMetaKeys  = 32
+	maxMetaBytes = 16 << 10
+)
+
+// metaKeyPattern keeps keys usable as ?meta.key= filters and template
+// identifiers: no dots, spaces or leading digits.
+var metaKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// customFields is free-form structured data (location, mood, original link…)
+// attached to an article for themes and integrations. Values are kept as raw
+// JSON so numbers and nested objects round-trip untouched.
+type customFields map[string]json.RawMessage
+
+// validate reports problems into the caller's validator so every bad key is
+// listed alongside the article's other field errors.
+func (m customFields) validate(v *validator) {
+	v.check(len(m) <= maxMetaKeys, "meta", errInvalidMeta, "too many keys")
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.check(metaKeyPattern.MatchString(k), "meta."+k, errInvalidMeta, "invalid key")
+	}
+	if raw, err := json.Marshal(m); err == nil {
+		v.check(len(raw) <= maxMetaBytes, "meta", errInvalidMeta, "too large")
+	}
+}
+
+// parseCustomFields decodes the stored JSONB column; an empty object yields
+// nil so the API omits the field.
+func parseCustomFields(raw []byte) customFields {
+	var m customFields
+	if len(raw) == 0 || json.Unmarshal(raw, &m) != nil || len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// metaFilter collects ?meta.key=value query parameters. Values match the
+// field's text form, so ?meta.stars=4 finds {"stars": 4}.
+func metaFilter(q url.Values) (map[string]string, error) {
+	var out map[string]string
+	var v validator
+	for name, vals := range q {
+		key, ok := strings.CutPrefix(name, "meta.")
+		if !ok {
+			continue
+		}
+		if !v.check(metaKeyPattern.MatchString(key), name, errInvalidMeta, "invalid key") {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[key] = vals[0]
+	}
+	return out, v.err()
+}
+
+// metaFilterKey is the list-cache key part for a meta filter.
+func metaFilterKey(f map[string]string) string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(url.QueryEscape(k) + "=" + url.QueryEscape(f[k]) + "&")
+	}
+	return b.String()
+}
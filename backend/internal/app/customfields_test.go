@@ -0,0 +1,36 @@
+package app
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestCustomFieldsValidate(t *testing.T) {
+	var v validator
+	customFields{"mood": json.RawMessage(`"calm"`), "stars": json.RawMessage(`4`)}.validate(&v)
+	if v.err() != nil {
+		t.Fatal(v.err())
+	}
+	customFields{"1st": json.RawMessage(`1`), "a.b": json.RawMessage(`1`), "ok": json.RawMessage(`1`)}.validate(&v)
+	if len(v.errs) != 2 || v.errs[0].Field != "meta.1st" || v.errs[1].Field != "meta.a.b" {
+		t.Fatalf("errs = %+v", v.errs)
+	}
+}
+
+func TestMetaFilter(t *testing.T) {
+	q, _ := url.ParseQuery("status=published&meta.mood=calm&meta.stars=4&meta.stars=5")
+	f, err := metaFilter(q)
+	if err != nil || len(f) != 2 || f["mood"] != "calm" || f["stars"] != "4" {
+		t.Fatalf("filter = %v %v", f, err)
+	}
+	if got := metaFilterKey(f); got != "mood=calm&stars=4&" {
+		t.Fatalf("key = %q", got)
+	}
+	if _, err := metaFilter(url.Values{"meta.bad key": {"x"}}); err == nil {
+		t.Fatal("accepted an invalid key")
+	}
+	if f, _ := metaFilter(url.Values{"status": {"x"}}); f != nil {
+		t.Fatalf("no meta params should yield nil, got %v", f)
+	}
+}
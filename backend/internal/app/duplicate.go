@@ -0,0 +1,77 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// duplicateSuffix marks a copy's slug; further copies get -copy-2 and so on.
+const duplicateSuffix = "-copy"
+
+// duplicateArticle backs POST /api/articles/:id/duplicate: it clones an
+// article as a new draft under "<slug>-copy", keeping its body, archive,
+// tags, description, language, social card, custom fields and visibility. It
+// is not linked as a translation, has no publish date until it is published
+// and belongs to whoever made the copy.
+func (s *server) duplicateArticle(c *gin.Context) {
+	ctx := c.Request.Context()
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	var srcSlug string
+	err := s.db.QueryRowContext(ctx, `SELECT slug FROM articles WHERE id=$1`, id).Scan(&srcSlug)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+
+	var newID, slug string
+	for attempt := 0; attempt < 3; attempt++ {
+		slug, err = s.ensureUniqueSlug(ctx, srcSlug+duplicateSuffix, "")
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
+			return
+		}
+		err = s.db.QueryRowContext(ctx, `
+			INSERT INTO articles (slug, title, body_md, body_html, excerpt, status, archive_id, published_at, type,
+			                      meta_description, tags, lang, social, meta, visibility, password_hash, author_id)
+			SELECT $2, title, body_md, body_html, excerpt, 'draft', archive_id, NULL, type,
+			       meta_description, tags, lang, social, meta, visibility, password_hash, $3::uuid
+			FROM articles WHERE id=$1
+			RETURNING id`, id, slug, u.ID).Scan(&newID)
+		if err == nil || !isUniqueViolation(err) {
+			break
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errDuplicateArticleFailed, err)
+		return
+	}
+	if err := setArticleAuthors(ctx, s.db, newID, []string{u.ID}); err != nil {
+		warnf("设置文章作者失败: %v\n", err)
+	}
+	// the copy is a draft even when the original was published
+	if _, err := s.sealPendingArticles(ctx, newID); err != nil {
+		warnf("加密文章副本失败: %v\n", err)
+	}
+	s.refreshSearchIndex(newID)
+	s.refreshLinkGraph(newID)
+	s.publishFrom(c, eventArticleChanged, actionCreated, newID, slug)
+	c.JSON(http.StatusCreated, gin.H{"id": newID, "slug": slug})
+}
@@ -0,0 +1,226 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Editors hold a short lease on an article while its editor page is open and
+// renew it with heartbeats; an abandoned tab simply lets it expire.
+const editLockInterval = `interval '2 minutes'`
+
+func (s *server) ensureEditLockSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS article_locks (
+			article_id UUID PRIMARY KEY REFERENCES articles(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			username TEXT NOT NULL,
+			acquired_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+	`)
+	return err
+}
+
+type editLock struct {
+	ArticleID  string    `json:"articleId"`
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Mine tells the caller whether they are the holder.
+	Mine bool `json:"mine"`
+}
+
+func (l *editLock) scan(row interface{ Scan(...any) error }, userID string) error {
+	if err := row.Scan(&l.ArticleID, &l.UserID, &l.Username, &l.AcquiredAt, &l.ExpiresAt); err != nil {
+		return err
+	}
+	l.Mine = l.UserID == userID
+	return nil
+}
+
+// activeLock returns the unexpired lock on article id, if any.
+func (s *server) activeLock(ctx context.Context, id, userID string) (*editLock, error) {
+	if !store.ValidID(id) {
+		return nil, nil
+	}
+	var l editLock
+	err := l.scan(s.db.QueryRowContext(ctx, `
+		SELECT article_id, user_id, username, acquired_at, expires_at
+		FROM article_locks
+		WHERE article_id=$1 AND expires_at > now()`, id), userID)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	l.inLocation(s.siteLocation())
+	return &l, nil
+}
+
+func (l *editLock) inLocation(loc *time.Location) {
+	l.AcquiredAt = l.AcquiredAt.In(loc)
+	l.ExpiresAt = l.ExpiresAt.In(loc)
+}
+
+func respondLocked(c *gin.Context, l *editLock) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error": localizeError(requestLanguage(c), errArticleLocked, l.Username),
+		"code":  errArticleLocked,
+		"lock":  l,
+	})
+}
+
+func (s *server) getEditLock(c *gin.Context) {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	l, err := s.activeLock(c.Request.Context(), c.Param("id"), u.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errEditLockFailed)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lock": l})
+}
+
+// acquireEditLock takes the lock when it is free, expired or already ours
+// (which doubles as the heartbeat), and answers 409 with the holder otherwise.
+func (s *server) acquireEditLock(c *gin.Context) {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkArticleAuthor(c, id) {
+		return
+	}
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM articles WHERE id=$1)`, id).Scan(&exists); err != nil {
+		respondError(c, http.StatusInternalServerError, errEditLockFailed)
+		return
+	}
+	if !exists {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+	var l editLock
+	err := l.scan(s.db.QueryRowContext(ctx, `
+		INSERT INTO article_locks (article_id, user_id, username, expires_at)
+		SELECT id, $2, $3, now() + `+editLockInterval+` FROM articles WHERE id=$1
+		ON CONFLICT (article_id) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			username = EXCLUDED.username,
+			acquired_at = CASE WHEN article_locks.user_id = EXCLUDED.user_id AND article_locks.expires_at > now()
+			                   THEN article_locks.acquired_at ELSE now() END,
+			expires_at = EXCLUDED.expires_at
+		WHERE article_locks.user_id = EXCLUDED.user_id OR article_locks.expires_at <= now()
+		RETURNING article_id, user_id, username, acquired_at, expires_at`, id, u.ID, u.Username), u.ID)
+	if err != nil {
+		if !errorsIsNotFound(err) {
+			respondError(c, http.StatusInternalServerError, errEditLockFailed)
+			return
+		}
+		// the conflict branch was skipped: someone else holds a live lock
+		holder, err := s.activeLock(ctx, id, u.ID)
+		if err != nil || holder == nil {
+			respondError(c, http.StatusConflict, errEditLockFailed)
+			return
+		}
+		respondLocked(c, holder)
+		return
+	}
+	l.inLocation(s.siteLocation())
+	c.JSON(http.StatusOK, gin.H{"lock": l})
+}
+
+// heartbeatEditLock extends a lock the caller already holds.
+func (s *server) heartbeatEditLock(c *gin.Context) {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkArticleAuthor(c, id) {
+		return
+	}
+	var l editLock
+	err := l.scan(s.db.QueryRowContext(ctx, `
+		UPDATE article_locks SET expires_at = now() + `+editLockInterval+`
+		WHERE article_id=$1 AND user_id=$2 AND expires_at > now()
+		RETURNING article_id, user_id, username, acquired_at, expires_at`, id, u.ID), u.ID)
+	if err != nil {
+		if !errorsIsNotFound(err) {
+			respondError(c, http.StatusInternalServerError, errEditLockFailed)
+			return
+		}
+		if holder, _ := s.activeLock(ctx, id, u.ID); holder != nil {
+			respondLocked(c, holder)
+			return
+		}
+		respondError(c, http.StatusConflict, errEditLockLost)
+		return
+	}
+	l.inLocation(s.siteLocation())
+	c.JSON(http.StatusOK, gin.H{"lock": l})
+}
+
+// releaseEditLock drops the caller's lock; ?force=1 breaks someone else's.
+func (s *server) releaseEditLock(c *gin.Context) {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkArticleAuthor(c, id) {
+		return
+	}
+	_, err := s.db.ExecContext(c.Request.Context(), `
+		DELETE FROM article_locks WHERE article_id=$1 AND ($2 OR user_id=$3 OR expires_at <= now())`,
+		id, c.Query("force") == "1", u.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errEditLockFailed)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// checkEditLock is the guard in front of article updates: it answers 409 and
+// returns false when another user holds the lock, unless ?force=1.
+func (s *server) checkEditLock(c *gin.Context, id string) bool {
+	if c.Query("force") == "1" {
+		return true
+	}
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return false
+	}
+	l, err := s.activeLock(c.Request.Context(), id, u.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errEditLockFailed)
+		return false
+	}
+	if l != nil && !l.Mine {
+		respondLocked(c, l)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondLockedIncludesHolder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/api/articles/1", nil)
+	respondLocked(c, &editLock{ArticleID: "1", UserID: "u2", Username: "alice", ExpiresAt: time.Now().Add(time.Minute)})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var body struct {
+		Code string   `json:"code"`
+		Lock editLock `json:"lock"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != string(errArticleLocked) || body.Lock.Username != "alice" || body.Lock.Mine {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,396 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// sealedPrefix marks an encrypted column value: enc1:<key id>:<base64>.
+	sealedPrefix = "enc1:"
+	kdfSaltKey   = "kdf"
+	kdfSaltBytes = 16
+)
+
+// encryptionConfig turns on encryption at rest for draft bodies and
+// password-protected posts. Secret (or CONTENT_SECRET) is stretched with
+// scrypt and a salt stored in the settings table.
+type encryptionConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Secret  string `yaml:"secret"`
+}
+
+var errContentKeyMissing = errors.New("content is encrypted but no encryption key is configured")
+
+// kdf stretches secret into an AES-256 key. The cost parameters make a
+// brute force of weak secrets expensive; the salt keeps keys from repeating
+// across installs.
+func kdf(secret string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(secret), salt, 1<<15, 8, 1, 32)
+}
+
+// contentCipher seals article bodies with AES-GCM. A nil cipher (encryption
+// disabled) stores plaintext and refuses to open sealed values.
+type contentCipher struct {
+	key []byte
+	// id names the key in every value it seals, so a wrong or rotated key
+	// is reported as such instead of as corrupt data.
+	id string
+}
+
+func newContentCipher(key []byte) *contentCipher {
+	sum := sha256.Sum256(key)
+	return &contentCipher{key: key, id: hex.EncodeToString(sum[:4])}
+}
+
+func isSealed(v string) bool {
+	return strings.HasPrefix(v, sealedPrefix)
+}
+
+func (cc *contentCipher) seal(v string) (string, error) {
+	if cc == nil || v == "" || isSealed(v) {
+		return v, nil
+	}
+	ct, err := encryptSecret(cc.key, v)
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + cc.id + ":" + ct, nil
+}
+
+// open returns plaintext values unchanged, so rows written before encryption
+// was enabled keep working.
+func (cc *contentCipher) open(v string) (string, error) {
+	if !isSealed(v) {
+		return v, nil
+	}
+	if cc == nil {
+		return "", errContentKeyMissing
+	}
+	id, ct, ok := strings.Cut(strings.TrimPrefix(v, sealedPrefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	if id != cc.id {
+		return "", fmt.Errorf("value was encrypted with key %s, configured key is %s", id, cc.id)
+	}
+	return decryptSecret(cc.key, ct)
+}
+
+// shouldSeal reports whether an article's body is encrypted at rest: drafts
+// and password-protected posts.
+func shouldSeal(status, visibility string) bool {
+	return status == "draft" || visibility == visibilityPassword
+}
+
+// storedBody is what the articles table keeps of a body: the markdown, the
+// rendered HTML and the plain-text excerpt.
+type storedBody struct {
+	md, html, excerpt string
+}
+
+// storeBody prepares a body for articleInsertSQL/articleUpdateSQL, sealing it
+// when the article qualifies and encryption is on. visibility is the
+// payload's; nil means the default, public.
+func (s *server) storeBody(status string, visibility *string, md, bodyHTML string) (storedBody, error) {
+	b := storedBody{md: md, html: bodyHTML, excerpt: plainExcerpt(bodyHTML)}
+	vis := visibilityPublic
+	if visibility != nil {
+		vis = *visibility
+	}
+	if s.content == nil || !shouldSeal(status, vis) {
+		return b, nil
+	}
+	var err error
+	for _, p := range []*string{&b.md, &b.html, &b.excerpt} {
+		if *p, err = s.content.seal(*p); err != nil {
+			return storedBody{}, err
+		}
+	}
+	return b, nil
+}
+
+// updatedBody is storeBody for an update, where a nil visibility keeps the
+// stored one.
+func (s *server) updatedBody(ctx context.Context, q sqlQuerier, id, status string, visibility *string, md, bodyHTML string) (storedBody, error) {
+	if s.content != nil && visibility == nil {
+		var stored string
+		if err := q.QueryRowContext(ctx, `SELECT visibility FROM articles WHERE id=$1`, id).Scan(&stored); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return storedBody{}, err
+		}
+		visibility = &stored
+	}
+	return s.storeBody(status, visibility, md, bodyHTML)
+}
+
+// openArticle decrypts a loaded article's body in place. A value that can't
+// be opened is blanked with a warning rather than shown as ciphertext.
+func (s *server) openArticle(a *article) {
+	for _, p := range []*string{&a.BodyMD, &a.BodyHTML, &a.Excerpt} {
+		plain, err := s.content.open(*p)
+		if err != nil {
+			warnf("解密文章 %s 失败: %v\n", a.ID, err)
+			plain = ""
+		}
+		*p = plain
+	}
+}
+
+// ensureContentKey derives the content key at startup, creating the install's
+// KDF salt on first use.
+func (s *server) ensureContentKey(ctx context.Context) error {
+	if !s.encryption.Enabled {
+		return nil
+	}
+	if s.encryption.Secret == "" {
+		return errors.New("encryption.enabled 需要设置 encryption.secret 或 CONTENT_SECRET")
+	}
+	salt, err := s.kdfSalt(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	key, err := kdf(s.encryption.Secret, salt)
+	if err != nil {
+		return err
+	}
+	s.content = newContentCipher(key)
+	return nil
+}
+
+// kdfSalt loads the stored salt, creating it when there is none yet.
+func (s *server) kdfSalt(ctx context.Context, q sqlQuerier) ([]byte, error) {
+	fresh := make([]byte, kdfSaltBytes)
+	if _, err := rand.Read(fresh); err != nil {
+		return nil, err
+	}
+	raw, _ := json.Marshal(map[string]string{"salt": base64.StdEncoding.EncodeToString(fresh)})
+	var stored []byte
+	err := q.QueryRowContext(ctx, `
+		WITH ins AS (
+			INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING RETURNING value
+		)
+		SELECT value FROM ins UNION ALL SELECT value FROM settings WHERE key=$1 LIMIT 1`, kdfSaltKey, raw).Scan(&stored)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSalt(stored)
+}
+
+func decodeSalt(raw []byte) ([]byte, error) {
+	var v struct {
+		Salt string `json:"salt"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(v.Salt)
+	if err != nil || len(salt) < kdfSaltBytes {
+		return nil, errors.New("stored KDF salt is invalid")
+	}
+	return salt, nil
+}
+
+// sealPendingArticles brings stored bodies in line with shouldSeal: it
+// encrypts drafts and password posts still in plaintext (e.g. right after
+// encryption was enabled) and decrypts rows that no longer qualify. id
+// limits it to one article; "" checks them all.
+func (s *server) sealPendingArticles(ctx context.Context, id string) (int, error) {
+	if s.content == nil {
+		if id != "" {
+			return 0, nil
+		}
+		var n int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM articles WHERE body_md LIKE 'enc1:%'`).Scan(&n); err == nil && n > 0 {
+			warnf("%d 篇文章已加密，但未启用 encryption，正文将无法读取\n", n)
+		}
+		return 0, nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, status, visibility, body_md, COALESCE(body_html, ''), COALESCE(excerpt, '') FROM articles
+		WHERE (status = 'draft' OR visibility = 'password') <> (body_md LIKE 'enc1:%') AND body_md <> ''
+		  AND ($1 = '' OR id = NULLIF($1, '')::uuid)`, id)
+	if err != nil {
+		return 0, err
+	}
+	type item struct {
+		id, status, visibility string
+		body                   storedBody
+	}
+	var items []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.status, &it.visibility, &it.body.md, &it.body.html, &it.body.excerpt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, it := range items {
+		md, err := s.content.open(it.body.md)
+		var bodyHTML string
+		if err == nil {
+			bodyHTML, err = s.content.open(it.body.html)
+		}
+		if err != nil {
+			// never rewrite what can't be read back
+			warnf("解密文章 %s 失败，跳过: %v\n", it.id, err)
+			continue
+		}
+		body, err := s.storeBody(it.status, &it.visibility, md, bodyHTML)
+		if err != nil {
+			return n, err
+		}
+		// the search vector may hold draft words; the startup reindex rebuilds it
+		if _, err := s.db.ExecContext(ctx, `UPDATE articles SET body_md=$1, body_html=$2, excerpt=$3, search_vector=NULL WHERE id=$4`, body.md, body.html, body.excerpt, it.id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// rotateContentKey re-encrypts every sealed article body from oldKey to
+// newKey in one transaction and stores newSalt alongside.
+func rotateContentKey(ctx context.Context, db *sql.DB, oldKey, newKey, newSalt []byte) (int, error) {
+	from, to := newContentCipher(oldKey), newContentCipher(newKey)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `SELECT id, body_md, COALESCE(body_html, ''), COALESCE(excerpt, '') FROM articles WHERE body_md LIKE 'enc1:%' FOR UPDATE`)
+	if err != nil {
+		return 0, err
+	}
+	type item struct {
+		id   string
+		cols [3]string
+	}
+	var items []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.cols[0], &it.cols[1], &it.cols[2]); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, it := range items {
+		for i, v := range it.cols {
+			plain, err := from.open(v)
+			if err != nil {
+				return 0, fmt.Errorf("article %s: %w", it.id, err)
+			}
+			if it.cols[i], err = to.seal(plain); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE articles SET body_md=$1, body_html=$2, excerpt=$3 WHERE id=$4`, it.cols[0], it.cols[1], it.cols[2], it.id); err != nil {
+			return 0, err
+		}
+	}
+	raw, _ := json.Marshal(map[string]string{"salt": base64.StdEncoding.EncodeToString(newSalt)})
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, updated_at=now()`, kdfSaltKey, raw); err != nil {
+		return 0, err
+	}
+	return len(items), tx.Commit()
+}
+
+// RunCrypto implements `selfecho crypto rotate`. With -old-secret (or
+// OLD_CONTENT_SECRET) it re-encrypts the sealed article bodies from that
+// secret to the one now configured, under a fresh salt. It always rewrites
+// the stored IMAP passwords from -old-imap-secret (or OLD_IMAP_SECRET,
+// default the current imapSecret) to the current imapSecret, moving values
+// from before per-value salts onto the new format. Stop the server first.
+func RunCrypto(w io.Writer, args []string) error {
+	if len(args) == 0 || args[0] != "rotate" {
+		return errors.New("usage: selfecho crypto rotate [-old-secret SECRET] [-old-imap-secret SECRET]")
+	}
+	fs := flag.NewFlagSet("crypto rotate", flag.ContinueOnError)
+	fs.SetOutput(w)
+	oldSecret := fs.String("old-secret", os.Getenv("OLD_CONTENT_SECRET"), "secret the content is currently encrypted with")
+	oldImapSecret := fs.String("old-imap-secret", os.Getenv("OLD_IMAP_SECRET"), "secret the IMAP passwords are currently encrypted with (default: imapSecret)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	s, cfg, err := openCLIServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.db.Close()
+	if *oldSecret != "" {
+		if err := rotateContent(ctx, w, s, cfg, *oldSecret); err != nil {
+			return err
+		}
+	}
+
+	if err := s.ensureImapSchema(ctx); err != nil {
+		return err
+	}
+	if *oldImapSecret == "" {
+		*oldImapSecret = cfg.ImapSecret
+	}
+	n, skipped, err := rotateImapSecrets(ctx, s.db, newImapSecrets(*oldImapSecret), newImapSecrets(cfg.ImapSecret))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "re-encrypted %d imap passwords\n", n)
+	if len(skipped) > 0 {
+		return fmt.Errorf("%d imap passwords do not open with the old secret: %s", len(skipped), strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+func rotateContent(ctx context.Context, w io.Writer, s *server, cfg config, oldSecret string) error {
+	if cfg.Encryption.Secret == "" {
+		return errors.New("encryption.secret / CONTENT_SECRET 未设置")
+	}
+	oldSalt, err := s.kdfSalt(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	oldKey, err := kdf(oldSecret, oldSalt)
+	if err != nil {
+		return err
+	}
+	newSalt := make([]byte, kdfSaltBytes)
+	if _, err := rand.Read(newSalt); err != nil {
+		return err
+	}
+	newKey, err := kdf(cfg.Encryption.Secret, newSalt)
+	if err != nil {
+		return err
+	}
+	n, err := rotateContentKey(ctx, s.db, oldKey, newKey, newSalt)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "re-encrypted %d articles with key %s\n", n, newContentCipher(newKey).id)
+	return nil
+}
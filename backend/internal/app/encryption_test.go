@@ -0,0 +1,84 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestContentCipher(t *testing.T) {
+	cc := newContentCipher(bytes.Repeat([]byte{1}, 32))
+	sealed, err := cc.seal("秘密 draft")
+	if err != nil || !strings.HasPrefix(sealed, sealedPrefix+cc.id+":") {
+		t.Fatalf("seal = %q, %v", sealed, err)
+	}
+	again, _ := cc.seal("秘密 draft")
+	if again == sealed {
+		t.Fatal("two seals of the same value should differ (random nonce)")
+	}
+	if plain, err := cc.open(sealed); err != nil || plain != "秘密 draft" {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+	if resealed, _ := cc.seal(sealed); resealed != sealed {
+		t.Fatal("sealing twice should be a no-op")
+	}
+	if plain, err := cc.open("legacy text"); err != nil || plain != "legacy text" {
+		t.Fatalf("plaintext passthrough = %q, %v", plain, err)
+	}
+
+	other := newContentCipher(bytes.Repeat([]byte{2}, 32))
+	if _, err := other.open(sealed); err == nil || !strings.Contains(err.Error(), cc.id) {
+		t.Fatalf("wrong key error = %v", err)
+	}
+	var disabled *contentCipher
+	if _, err := disabled.open(sealed); err != errContentKeyMissing {
+		t.Fatalf("disabled open = %v", err)
+	}
+	if v, _ := disabled.seal("x"); v != "x" {
+		t.Fatalf("disabled seal = %q", v)
+	}
+}
+
+func TestStoreBody(t *testing.T) {
+	s := &server{content: newContentCipher(bytes.Repeat([]byte{1}, 32))}
+	password, public := visibilityPassword, visibilityPublic
+	for _, tc := range []struct {
+		status     string
+		visibility *string
+		sealed     bool
+	}{
+		{"draft", nil, true},
+		{"published", nil, false},
+		{"published", &public, false},
+		{"published", &password, true},
+	} {
+		b, err := s.storeBody(tc.status, tc.visibility, "# hi", "<h1>hi</h1>")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isSealed(b.md) != tc.sealed || isSealed(b.html) != tc.sealed || isSealed(b.excerpt) != tc.sealed {
+			t.Errorf("%s/%v: %+v", tc.status, tc.visibility, b)
+		}
+	}
+	b, _ := s.storeBody("draft", nil, "# hi", "<h1>hi</h1>")
+	a := article{BodyMD: b.md, BodyHTML: b.html, Excerpt: b.excerpt}
+	s.openArticle(&a)
+	if a.BodyMD != "# hi" || a.BodyHTML != "<h1>hi</h1>" || a.Excerpt != "hi" {
+		t.Fatalf("opened = %+v", a)
+	}
+}
+
+func TestKDF(t *testing.T) {
+	salt := bytes.Repeat([]byte{7}, kdfSaltBytes)
+	k1, err := kdf("secret", salt)
+	if err != nil || len(k1) != 32 {
+		t.Fatalf("kdf = %x, %v", k1, err)
+	}
+	k2, _ := kdf("secret", bytes.Repeat([]byte{8}, kdfSaltBytes))
+	if bytes.Equal(k1, k2) {
+		t.Fatal("salt should change the key")
+	}
+	if _, err := decodeSalt([]byte(`{"salt":"c2hvcnQ="}`)); err == nil {
+		t.Fatal("short salt should be rejected")
+	}
+}
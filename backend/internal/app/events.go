@@ -0,0 +1,136 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type eventKind string
+
+const (
+	eventArticleChanged  eventKind = "article.changed"
+	eventArchiveChanged  eventKind = "archive.changed"
+	eventSettingsChanged eventKind = "settings.changed"
+	eventImapSynced      eventKind = "imap.synced"
+	eventSearchReindex   eventKind = "search.reindex"
+	eventLinkChanged     eventKind = "link.changed"
+	eventTemplateChanged eventKind = "template.changed"
+	eventAuthorChanged   eventKind = "author.changed"
+	eventBookmarkChanged eventKind = "bookmark.changed"
+)
+
+type eventAction string
+
+const (
+	actionCreated  eventAction = "created"
+	actionUpdated  eventAction = "updated"
+	actionDeleted  eventAction = "deleted"
+	actionStarted  eventAction = "started"
+	actionProgress eventAction = "progress"
+	actionFinished eventAction = "finished"
+	actionFailed   eventAction = "failed"
+)
+
+// changeEvent describes a single entity mutation or background job outcome.
+// Slug is only set for articles; Message carries a human-readable detail.
+// Actor is the username behind a request-driven change, empty for
+// background jobs.
+type changeEvent struct {
+	Kind    eventKind   `json:"kind"`
+	Action  eventAction `json:"action"`
+	ID      string      `json:"id,omitempty"`
+	Slug    string      `json:"slug,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Actor   string      `json:"actor,omitempty"`
+	At      time.Time   `json:"at"`
+}
+
+type eventSubscriber struct {
+	name string
+	fn   func(changeEvent)
+}
+
+// eventBus fans mutations out to in-process subscribers. Delivery is
+// synchronous so the list cache is already clean when the handler responds;
+// subscribers doing network work should hand off to their own goroutine.
+type eventBus struct {
+	mu   sync.RWMutex
+	subs []eventSubscriber
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{}
+}
+
+func (b *eventBus) subscribe(name string, fn func(changeEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, eventSubscriber{name: name, fn: fn})
+}
+
+func (b *eventBus) publish(ev changeEvent) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	b.mu.RLock()
+	subs := append([]eventSubscriber(nil), b.subs...)
+	b.mu.RUnlock()
+	for _, sub := range subs {
+		deliverEvent(sub, ev)
+	}
+}
+
+func deliverEvent(sub eventSubscriber, ev changeEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			warnf("事件订阅者 %s 处理 %s 失败: %v\n", sub.name, ev.Kind, r)
+		}
+	}()
+	sub.fn(ev)
+}
+
+func (s *server) publish(kind eventKind, action eventAction, id, slug string) {
+	s.events.publish(changeEvent{Kind: kind, Action: action, ID: id, Slug: slug})
+}
+
+// publishFrom is publish for handlers: the event is attributed to the
+// signed-in user or service account.
+func (s *server) publishFrom(c *gin.Context, kind eventKind, action eventAction, id, slug string) {
+	ev := changeEvent{Kind: kind, Action: action, ID: id, Slug: slug}
+	if v, ok := c.Get(string(userContextKey)); ok {
+		if u, ok := v.(user); ok {
+			ev.Actor = u.Username
+		}
+	}
+	s.events.publish(ev)
+}
+
+// publishImapSync reports the outcome of an IMAP sync to admin listeners.
+func (s *server) publishImapSync(accountID string, err error) {
+	ev := changeEvent{Kind: eventImapSynced, Action: actionFinished, ID: accountID}
+	if err != nil {
+		ev.Action = actionFailed
+		ev.Message = err.Error()
+	}
+	s.events.publish(ev)
+}
+
+// registerEventSubscribers wires the built-in reactions to content changes.
+func (s *server) registerEventSubscribers() {
+	s.events.subscribe("list-cache", func(ev changeEvent) {
+		switch ev.Kind {
+		case eventImapSynced, eventSearchReindex, eventTemplateChanged:
+		default:
+			s.cache.invalidateAll()
+			s.pages.invalidateAll()
+			s.activity.invalidate()
+		}
+	})
+	s.events.subscribe("admin-sse", s.notify.broadcast)
+	s.events.subscribe("activity-log", s.recordActivity)
+	if s.git != nil {
+		s.events.subscribe("git-sync", s.queueGitPush)
+	}
+}
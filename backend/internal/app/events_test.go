@@ -0,0 +1,32 @@
+package app
+
+import "testing"
+
+func TestEventBus_PublishIsolatesPanics(t *testing.T) {
+	bus := newEventBus()
+	var got []changeEvent
+	bus.subscribe("boom", func(changeEvent) { panic("boom") })
+	bus.subscribe("record", func(ev changeEvent) { got = append(got, ev) })
+
+	bus.publish(changeEvent{Kind: eventArticleChanged, Action: actionUpdated, ID: "1", Slug: "hello"})
+
+	if len(got) != 1 {
+		t.Fatalf("expected 1 delivered event, got %d", len(got))
+	}
+	if got[0].Slug != "hello" || got[0].At.IsZero() {
+		t.Fatalf("unexpected event: %+v", got[0])
+	}
+}
+
+func TestNotifyHub_BroadcastDoesNotBlock(t *testing.T) {
+	hub := newNotifyHub()
+	ch := hub.add()
+	for i := 0; i < cap(ch)+5; i++ {
+		hub.broadcast(changeEvent{Kind: eventImapSynced, Action: actionFinished})
+	}
+	if len(ch) != cap(ch) {
+		t.Fatalf("expected buffered events to fill the channel, got %d", len(ch))
+	}
+	hub.remove(ch)
+	hub.broadcast(changeEvent{Kind: eventImapSynced})
+}
@@ -0,0 +1,343 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// exportConfig controls GET /api/articles/:id/export. PDF output runs
+// PDFCommand (e.g. ["wkhtmltopdf", "--quiet", "-", "-"]); it reads the HTML
+// on stdin and writes the PDF to stdout unless its arguments name
+// "{input}" / "{output}", which are replaced by temporary file paths for
+// renderers such as headless Chromium. PDF export is off when it is empty.
+type exportConfig struct {
+	PDFCommand     []string `yaml:"pdfCommand"`
+	TimeoutSeconds int      `yaml:"timeoutSeconds"`
+}
+
+const (
+	defaultExportTimeout = 60 * time.Second
+	maxExportImageBytes  = 10 << 20
+)
+
+func (c exportConfig) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return defaultExportTimeout
+}
+
+// exportArticle backs GET /api/articles/:id/export?format=html|md|pdf and
+// answers with a self-contained file named after the slug.
+func (s *server) exportArticle(c *gin.Context) {
+	ctx := c.Request.Context()
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", "html")))
+	if format != "html" && format != "md" && format != "pdf" {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidExportFormat, errors.New(format))
+		return
+	}
+	if format == "pdf" && len(s.export.PDFCommand) == 0 {
+		respondError(c, http.StatusServiceUnavailable, errPDFExportDisabled)
+		return
+	}
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	a, ok, err := s.queryPost(ctx, `art.id=$1`, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+
+	var (
+		data  []byte
+		ctype string
+	)
+	switch format {
+	case "md":
+		data, err = exportMarkdown(a, s.siteLocation())
+		ctype = "text/markdown; charset=utf-8"
+	case "html":
+		data = []byte(s.standaloneHTML(ctx, a, s.baseURL(c)))
+		ctype = "text/html; charset=utf-8"
+	case "pdf":
+		data, err = renderPDF(ctx, s.export, []byte(s.standaloneHTML(ctx, a, s.baseURL(c))))
+		ctype = "application/pdf"
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errExportFailed, err)
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Slug + "." + format}))
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, ctype, data)
+}
+
+// exportFrontMatter is the YAML header of a Markdown export, in the shape
+// static site generators read. ID is only written by the Git mirror, which
+// needs it to follow renamed files.
+type exportFrontMatter struct {
+	ID          string   `yaml:"id,omitempty"`
+	Title       string   `yaml:"title"`
+	Slug        string   `yaml:"slug"`
+	Date        string   `yaml:"date"`
+	Updated     string   `yaml:"updated"`
+	Draft       bool     `yaml:"draft,omitempty"`
+	Type        string   `yaml:"type,omitempty"`
+	Category    string   `yaml:"category,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+	Authors     []string `yaml:"authors,omitempty"`
+	Lang        string   `yaml:"lang,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+}
+
+func exportMarkdown(a article, loc *time.Location) ([]byte, error) {
+	return markdownFile(articleFrontMatter(a, loc), a.BodyMD)
+}
+
+func articleFrontMatter(a article, loc *time.Location) exportFrontMatter {
+	date := a.CreatedAt
+	if a.PublishedAt != nil {
+		date = *a.PublishedAt
+	}
+	fm := exportFrontMatter{
+		Title: a.Title, Slug: a.Slug,
+		Date: date.In(loc).Format(time.RFC3339), Updated: a.UpdatedAt.In(loc).Format(time.RFC3339),
+		Draft: a.Status != "published", Category: a.Archive, Tags: a.Tags,
+		Lang: a.Lang, Description: a.Description,
+	}
+	if a.Type != "post" {
+		fm.Type = a.Type
+	}
+	for _, by := range a.Authors {
+		fm.Authors = append(fm.Authors, by.Username)
+	}
+	return fm
+}
+
+// markdownFile is body under a "---" fenced YAML header.
+func markdownFile(fm exportFrontMatter, body string) ([]byte, error) {
+	head, err := yaml.Marshal(fm)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	b.Write(head)
+	b.WriteString("---\n\n")
+	b.WriteString(strings.TrimRight(body, "\n"))
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// standaloneHTML renders a as one HTML document with its styles and images
+// inlined, so it opens the same offline. base is the site's absolute URL;
+// remaining relative links point back at it.
+func (s *server) standaloneHTML(ctx context.Context, a article, base string) string {
+	body := strings.TrimSpace(a.BodyHTML)
+	if body == "" {
+		body = renderMarkdown(a.BodyMD)
+	}
+	body = s.expandFileShortcodes(ctx, s.expandGallery(ctx, a.ID, body))
+	body = s.inlineImages(ctx, body)
+	body = absolutizeLinks(body, base)
+	return s.plainPage(a, base, body, "")
+}
+
+// inlineImages replaces <img src> URLs with data: URIs. Site media and
+// attachments are read from disk; other images are downloaded. Images that
+// can't be loaded keep their URL.
+func (s *server) inlineImages(ctx context.Context, body string) string {
+	return imgSrcRe.ReplaceAllStringFunc(body, func(tag string) string {
+		m := imgSrcRe.FindStringSubmatch(tag)
+		src := html.UnescapeString(m[2][1 : len(m[2])-1])
+		if strings.HasPrefix(src, "data:") {
+			return tag
+		}
+		data, ctype, err := s.loadImage(ctx, src)
+		if err != nil {
+			warnf("导出时内联图片失败 %s: %v\n", src, err)
+			return tag
+		}
+		uri := "data:" + ctype + ";base64," + base64.StdEncoding.EncodeToString(data)
+		return m[1] + `"` + uri + `"` + tag[len(m[0]):]
+	})
+}
+
+// loadImage fetches the bytes behind an <img src>.
+func (s *server) loadImage(ctx context.Context, src string) ([]byte, string, error) {
+	u, err := url.Parse(strings.TrimSpace(src))
+	if err != nil {
+		return nil, "", err
+	}
+	local := u.Scheme == "" && u.Host == ""
+	if host := s.canonicalHostname(); host != "" && strings.EqualFold(u.Host, host) {
+		local = true
+	}
+	if !local {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		return s.fetchImage(ctx, u.String())
+	}
+	p := strings.TrimPrefix(path.Clean("/"+u.Path), s.basePath)
+	var file, ctype string
+	switch {
+	case strings.HasPrefix(p, "/media/") && s.mediaDir != "":
+		file = filepath.Join(s.mediaDir, filepath.FromSlash(strings.TrimPrefix(p, "/media/")))
+	case strings.HasPrefix(p, "/files/") && s.files != nil:
+		id, _, _ := strings.Cut(strings.TrimPrefix(p, "/files/"), "/")
+		if !store.ValidID(id) {
+			return nil, "", fmt.Errorf("unknown attachment %q", id)
+		}
+		if err := s.readQueryRow(ctx, `SELECT id, content_type FROM attachments WHERE id=$1`, id).Scan(&id, &ctype); err != nil {
+			return nil, "", err
+		}
+		file = s.files.path(id)
+	default:
+		return nil, "", errors.New("not a media path")
+	}
+	data, err := readLimited(file, maxExportImageBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	return imageData(data, ctype)
+}
+
+// fetchImage downloads a remote image for exports and imports. src comes from
+// post bodies, so it goes through publicClient.
+func (s *server) fetchImage(ctx context.Context, src string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := s.publicClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("上游返回 %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxExportImageBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxExportImageBytes {
+		return nil, "", errImageTooLarge
+	}
+	ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return imageData(data, ctype)
+}
+
+func readLimited(file string, limit int64) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errImageTooLarge
+	}
+	return data, nil
+}
+
+// imageData settles the content type of an image, sniffing when the
+// declared one is missing or generic, and rejects anything that isn't one.
+func imageData(data []byte, ctype string) ([]byte, string, error) {
+	if !strings.HasPrefix(ctype, "image/") {
+		ctype, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !strings.HasPrefix(ctype, "image/") {
+		return nil, "", fmt.Errorf("不是图片: %q", ctype)
+	}
+	return data, ctype, nil
+}
+
+var relativeURLAttrRe = regexp.MustCompile(`(?i)(\s(?:href|src)\s*=\s*)(["'])(/[^/"'][^"']*|/)(["'])`)
+
+// absolutizeLinks points root-relative href/src attributes at base, which
+// already includes the base path.
+func absolutizeLinks(body, base string) string {
+	root := base
+	if u, err := url.Parse(base); err == nil {
+		u.Path = ""
+		root = strings.TrimRight(u.String(), "/")
+	}
+	return relativeURLAttrRe.ReplaceAllString(body, "${1}${2}"+strings.ReplaceAll(root, "$", "$$")+"${3}${4}")
+}
+
+// renderPDF runs the configured PDF command over page.
+func renderPDF(ctx context.Context, cfg exportConfig, page []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout())
+	defer cancel()
+	dir, err := os.MkdirTemp("", "selfecho-export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "article.html")
+	out := filepath.Join(dir, "article.pdf")
+
+	args := make([]string, 0, len(cfg.PDFCommand)-1)
+	var usesIn, usesOut bool
+	for _, arg := range cfg.PDFCommand[1:] {
+		usesIn = usesIn || strings.Contains(arg, "{input}")
+		usesOut = usesOut || strings.Contains(arg, "{output}")
+		args = append(args, strings.NewReplacer("{input}", in, "{output}", out).Replace(arg))
+	}
+	cmd := exec.CommandContext(ctx, cfg.PDFCommand[0], args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if usesIn {
+		if err := os.WriteFile(in, page, 0o600); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stdin = bytes.NewReader(page)
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", cfg.PDFCommand[0], err, strings.TrimSpace(stderr.String()))
+	}
+	pdf := stdout.Bytes()
+	if usesOut {
+		if pdf, err = os.ReadFile(out); err != nil {
+			return nil, err
+		}
+	}
+	if len(pdf) == 0 {
+		return nil, errors.New("PDF 渲染器没有输出")
+	}
+	return pdf, nil
+}
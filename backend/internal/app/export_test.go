@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportMarkdown(t *testing.T) {
+	pub := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	a := article{
+		Title: "Hello: world", Slug: "hello", Status: "published", Type: "post", Archive: "notes",
+		Tags: tagList{"go"}, Authors: []byline{{Username: "ann"}}, BodyMD: "# Hi\n\n",
+		PublishedAt: &pub, UpdatedAt: pub,
+	}
+	out, err := exportMarkdown(a, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(out)
+	for _, want := range []string{"---\ntitle: 'Hello: world'\n", "date: \"2026-03-01T08:00:00Z\"\n", "category: notes\n", "authors:\n    - ann\n", "---\n\n# Hi\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "draft:") || strings.Contains(got, "type:") {
+		t.Errorf("published post should not carry draft/type:\n%s", got)
+	}
+}
+
+func TestAbsolutizeLinks(t *testing.T) {
+	got := absolutizeLinks(`<a href="/blog/post/x">x</a> <a href='//cdn.example/y'>y</a> <img src="data:,">`, "https://b.example/blog")
+	want := `<a href="https://b.example/blog/post/x">x</a> <a href='//cdn.example/y'>y</a> <img src="data:,">`
+	if got != want {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestInlineImages(t *testing.T) {
+	dir := t.TempDir()
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{mediaDir: dir, basePath: "/blog"}
+	got := s.inlineImages(context.Background(), `<img alt="a" src="/blog/media/a.png" width="3"><img src="/blog/media/../../etc/passwd">`)
+	if !strings.HasPrefix(got, `<img alt="a" src="data:image/png;base64,iVBORw0KGgow`) || !strings.Contains(got, `" width="3">`) {
+		t.Fatalf("inline: %s", got)
+	}
+	if !strings.HasSuffix(got, `<img src="/blog/media/../../etc/passwd">`) {
+		t.Fatalf("escaped media path was touched: %s", got)
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	page := []byte("<html>%PDF</html>")
+	for _, cmd := range [][]string{{"cat"}, {"cp", "{input}", "{output}"}} {
+		out, err := renderPDF(context.Background(), exportConfig{PDFCommand: cmd}, page)
+		if err != nil || string(out) != string(page) {
+			t.Fatalf("%v: %q %v", cmd, out, err)
+		}
+	}
+	if _, err := renderPDF(context.Background(), exportConfig{PDFCommand: []string{"false"}}, page); err == nil {
+		t.Fatal("a failing renderer should be an error")
+	}
+}
@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      rssAtomLn `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssAtomLn struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Category    string  `xml:"category,omitempty"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// atomFeed is an Atom 1.0 document, written for mail feeds and read when
+// polling subscriptions.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published,omitempty"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Links     []atomLink  `xml:"link"`
+	Summary   *atomText   `xml:"summary,omitempty"`
+	Content   *atomText   `xml:"content,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+// alternate returns the entry's or feed's web link.
+func atomAlternate(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+func articleFeedDate(a article) time.Time {
+	if a.PublishedAt != nil {
+		return *a.PublishedAt
+	}
+	return a.CreatedAt
+}
+
+// buildRSS renders posts as an RSS 2.0 document; base is the request base URL
+// and selfURL the feed's own absolute location.
+func buildRSS(base, selfURL, title, link, description string, posts []article, loc *time.Location) ([]byte, error) {
+	doc := rssDocument{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        link,
+			Description: description,
+			AtomLink:    rssAtomLn{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	var latest time.Time
+	for _, p := range posts {
+		postURL := base + "/post/" + urlPathEscape(p.Slug)
+		date := articleFeedDate(p).In(loc)
+		if date.After(latest) {
+			latest = date
+		}
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       p.Title,
+			Link:        postURL,
+			GUID:        rssGUID{IsPermaLink: true, Value: postURL},
+			PubDate:     date.Format(time.RFC1123Z),
+			Category:    p.Archive,
+			Description: excerptFromArticle(p, 280),
+		})
+	}
+	if !latest.IsZero() {
+		doc.Channel.LastBuildDate = latest.Format(time.RFC1123Z)
+	}
+	bytes, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), bytes...), nil
+}
+
+func (s *server) queryArchiveByName(ctx context.Context, name string) (archive, bool, error) {
+	a, err := s.archives.ArchiveByName(ctx, name)
+	if errors.Is(err, store.ErrNotFound) {
+		return archive{}, false, nil
+	}
+	if err != nil {
+		return archive{}, false, err
+	}
+	return archiveFromRow(a), true, nil
+}
+
+func (s *server) queryFeedPostsByArchive(ctx context.Context, archive string, limit int) ([]article, error) {
+	if limit <= 0 || limit > 50 {
+		limit = 20
+	}
+	rows, err := s.readQuery(ctx, `
+		SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
+		       '' AS body_md, CASE WHEN art.excerpt IS NULL THEN art.body_html ELSE '' END,
+		       COALESCE(art.excerpt, ''), art.published_at, art.created_at, art.updated_at
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		WHERE art.status='published' AND art.type='post' AND art.visibility = 'public' AND COALESCE(ar.name, '') = $1
+		ORDER BY COALESCE(art.published_at, art.created_at) DESC, art.created_at DESC
+		LIMIT $2`, strings.TrimSpace(archive), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []article
+	for rows.Next() {
+		var a article
+		var archiveName sql.NullString
+		var bodyHTML sql.NullString
+		var publishedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &archiveName, &a.Status, &a.BodyMD, &bodyHTML, &a.Excerpt, &publishedAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		a.Archive = archiveName.String
+		a.BodyHTML = bodyHTML.String
+		if publishedAt.Valid {
+			a.PublishedAt = &publishedAt.Time
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+func markdownPlainText(md string) string {
+	if strings.TrimSpace(md) == "" {
+		return ""
+	}
+	return collapseWhitespace(html.UnescapeString(stripHTMLTags(renderMarkdown(md))))
+}
+
+func categoryFeedLink(base, name string) string {
+	return base + "/category/" + urlPathEscape(name) + "/feed.xml"
+}
+
+func rssAlternateLink(title, href string) string {
+	return `<link rel="alternate" type="application/rss+xml" title="` + html.EscapeString(title) + `" href="` + html.EscapeString(href) + `">`
+}
+
+func (s *server) seoCategoryFeedHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		ctx := c.Request.Context()
+		name := strings.TrimSpace(c.Param("name"))
+		if name == "" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		queryName := name
+		description := "分类文章列表"
+		if name == "未分类" {
+			queryName = ""
+		} else {
+			ar, ok, err := s.queryArchiveByName(ctx, name)
+			if err != nil {
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				c.Status(http.StatusNotFound)
+				return
+			}
+			if desc := markdownPlainText(ar.Description); desc != "" {
+				description = desc
+			}
+		}
+
+		posts, err := s.queryFeedPostsByArchive(ctx, queryName, 20)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		base := s.baseURL(c)
+		title := "分类 - " + name
+		if siteTitle != "" {
+			title = siteTitle + " - " + name
+		}
+		bytes, err := buildRSS(base, categoryFeedLink(base, name), title, base+"/category/"+urlPathEscape(name), description, posts, s.siteLocation())
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Vary", "Host, X-Forwarded-Proto, X-Forwarded-Host")
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", bytes)
+	}
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildRSS_ItemsAndSelfLink(t *testing.T) {
+	published := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	posts := []article{{
+		Title:       "Hello & <World>",
+		Slug:        "hello world",
+		Archive:     "tech",
+		BodyHTML:    "<p>Body text</p>",
+		PublishedAt: &published,
+	}}
+	out, err := buildRSS("https://example.com", "https://example.com/category/tech/feed.xml", "Site - tech", "https://example.com/category/tech", "desc", posts, time.UTC)
+	if err != nil {
+		t.Fatalf("buildRSS: %v", err)
+	}
+	got := string(out)
+	if !strings.Contains(got, `<title>Hello &amp; &lt;World&gt;</title>`) {
+		t.Fatalf("expected escaped item title, got: %s", got)
+	}
+	if !strings.Contains(got, `<link>https://example.com/post/hello%20world</link>`) {
+		t.Fatalf("expected escaped post link, got: %s", got)
+	}
+	if !strings.Contains(got, `href="https://example.com/category/tech/feed.xml" rel="self"`) {
+		t.Fatalf("expected atom self link, got: %s", got)
+	}
+	if !strings.Contains(got, `<lastBuildDate>Fri, 01 Mar 2024 08:00:00 +0000</lastBuildDate>`) {
+		t.Fatalf("expected lastBuildDate from newest post, got: %s", got)
+	}
+}
@@ -0,0 +1,559 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	gallerySubdir          = "gallery"
+	maxGalleryImages       = 100
+	maxGalleryImageBytes   = 20 << 20
+	maxGalleryUploadBytes  = 200 << 20
+	maxGalleryCaptionRunes = 300
+)
+
+// galleryTypes maps the sniffed content type of an accepted upload to the
+// extension it is stored under.
+var galleryTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// galleryImage is one photo of an article's gallery. Files live in
+// <mediaDir>/gallery and are served as plain media; Width and Height are 0
+// when the format can't be measured (WebP).
+type galleryImage struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Caption string `json:"caption,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+
+	file string
+}
+
+func (s *server) ensureGallerySchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS gallery_images (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			article_id UUID NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+			file TEXT NOT NULL,
+			caption TEXT NOT NULL DEFAULT '',
+			width INT NOT NULL DEFAULT 0,
+			height INT NOT NULL DEFAULT 0,
+			position INT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS gallery_images_article_idx ON gallery_images (article_id, position);
+	`)
+	return err
+}
+
+// galleryDir returns where gallery files go, creating it on first use. It is
+// only available when mediaDir exists, since that is what serves /media.
+func (s *server) galleryDir() (string, bool) {
+	if s.mediaDir == "" {
+		return "", false
+	}
+	if info, err := os.Stat(s.mediaDir); err != nil || !info.IsDir() {
+		return "", false
+	}
+	dir := filepath.Join(s.mediaDir, gallerySubdir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		warnf("创建图集目录失败: %v\n", err)
+		return "", false
+	}
+	return dir, true
+}
+
+func (s *server) galleryURL(file string) string {
+	return s.basePath + "/media/" + gallerySubdir + "/" + urlPathEscape(file)
+}
+
+// removeGalleryFiles deletes stored files whose rows are already gone.
+func (s *server) removeGalleryFiles(files []string) {
+	if len(files) == 0 || s.mediaDir == "" {
+		return
+	}
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(s.mediaDir, gallerySubdir, f)); err != nil && !os.IsNotExist(err) {
+			warnf("删除图集文件失败 %s: %v\n", f, err)
+		}
+	}
+}
+
+// galleryFiles lists the stored files of article id, so they can be removed
+// once the article is deleted.
+func galleryFiles(ctx context.Context, q sqlQuerier, id string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT file FROM gallery_images WHERE article_id=$1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []string
+	for rows.Next() {
+		var f string
+		if err := rows.Scan(&f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// queryGalleries loads the galleries of the given articles in one query,
+// keyed by article ID; articles without images are absent.
+func (s *server) queryGalleries(ctx context.Context, ids []string) (map[string][]galleryImage, error) {
+	out := map[string][]galleryImage{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := s.readQuery(ctx, `
+		SELECT article_id, id, file, caption, width, height
+		FROM gallery_images
+		WHERE article_id = ANY($1::text[]::uuid[])
+		ORDER BY article_id, position, created_at`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var articleID string
+		var g galleryImage
+		if err := rows.Scan(&articleID, &g.ID, &g.file, &g.Caption, &g.Width, &g.Height); err != nil {
+			return nil, err
+		}
+		g.URL = s.galleryURL(g.file)
+		out[articleID] = append(out[articleID], g)
+	}
+	return out, rows.Err()
+}
+
+func (s *server) queryGallery(ctx context.Context, id string) ([]galleryImage, error) {
+	galleries, err := s.queryGalleries(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	return galleries[id], nil
+}
+
+// attachGalleries fills in Gallery for items and, when render is set, places
+// it in their HTML body.
+func (s *server) attachGalleries(ctx context.Context, items []article, render bool) {
+	ids := make([]string, len(items))
+	for i, a := range items {
+		ids[i] = a.ID
+	}
+	galleries, err := s.queryGalleries(ctx, ids)
+	if err != nil {
+		warnf("查询图集失败: %v\n", err)
+		return
+	}
+	for i := range items {
+		items[i].Gallery = galleries[items[i].ID]
+		if render {
+			items[i].BodyHTML = s.placeGallery(items[i].BodyHTML, items[i].Gallery)
+		}
+	}
+}
+
+// galleryShortcodeRe matches the [[gallery]] marker, optionally alone in a
+// paragraph so the block doesn't end up inside a <p>.
+var galleryShortcodeRe = regexp.MustCompile(`(?:<p>\s*)?\[\[gallery\]\](?:\s*</p>)?`)
+
+// placeGallery renders images where the body has a [[gallery]] marker, or
+// after the body when it has none. Markers are dropped when there is nothing
+// to show.
+func (s *server) placeGallery(body string, images []galleryImage) string {
+	block := ""
+	if len(images) > 0 {
+		block = s.galleryHTML(images)
+	}
+	placed := false
+	body = galleryShortcodeRe.ReplaceAllStringFunc(body, func(string) string {
+		if placed {
+			return ""
+		}
+		placed = true
+		return block
+	})
+	if !placed {
+		body += block
+	}
+	return body
+}
+
+// expandGallery is placeGallery for a single article's rendered body.
+func (s *server) expandGallery(ctx context.Context, id, body string) string {
+	images, err := s.queryGallery(ctx, id)
+	if err != nil {
+		warnf("查询图集失败: %v\n", err)
+		return body
+	}
+	return s.placeGallery(body, images)
+}
+
+func (s *server) galleryHTML(images []galleryImage) string {
+	var b strings.Builder
+	b.WriteString(`<div class="gallery grid grid-cols-1 gap-2 sm:grid-cols-2 lg:grid-cols-3" data-count="` + strconv.Itoa(len(images)) + `">`)
+	for _, g := range images {
+		src := html.EscapeString(g.URL)
+		b.WriteString(`<figure class="gallery-item m-0">`)
+		b.WriteString(`<a href="` + src + `"><img src="` + src + `" alt="` + html.EscapeString(g.Caption) + `" loading="lazy"`)
+		if g.Width > 0 && g.Height > 0 {
+			b.WriteString(` width="` + strconv.Itoa(g.Width) + `" height="` + strconv.Itoa(g.Height) + `"`)
+		}
+		b.WriteString(` class="h-auto w-full rounded object-cover"></a>`)
+		if g.Caption != "" {
+			b.WriteString(`<figcaption class="mt-1 text-center text-xs text-[#888]">` + html.EscapeString(g.Caption) + `</figcaption>`)
+		}
+		b.WriteString(`</figure>`)
+	}
+	b.WriteString(`</div>`)
+	return b.String()
+}
+
+// galleryUpload is one checked upload waiting to be written.
+type galleryUpload struct {
+	data          []byte
+	ext           string
+	width, height int
+}
+
+// readGalleryUpload reads and checks one multipart file: it must be an image
+// of an accepted type no larger than maxGalleryImageBytes.
+func readGalleryUpload(fh *multipart.FileHeader) (galleryUpload, errCode, error) {
+	if fh.Size > maxGalleryImageBytes {
+		return galleryUpload{}, errFileTooLarge, nil
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return galleryUpload{}, "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxGalleryImageBytes+1))
+	if err != nil {
+		return galleryUpload{}, "", err
+	}
+	if len(data) > maxGalleryImageBytes {
+		return galleryUpload{}, errFileTooLarge, nil
+	}
+	ext, ok := galleryTypes[http.DetectContentType(data)]
+	if !ok {
+		return galleryUpload{}, errInvalidGalleryImage, nil
+	}
+	up := galleryUpload{data: data, ext: ext}
+	if ext != ".webp" {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return galleryUpload{}, errInvalidGalleryImage, nil
+		}
+		up.width, up.height = cfg.Width, cfg.Height
+	}
+	return up, "", nil
+}
+
+func newGalleryFileName(ext string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf) + ext, nil
+}
+
+// articleSlugByID returns the slug of article id, for change events.
+func (s *server) articleSlugByID(ctx context.Context, id string) (string, bool, error) {
+	if !store.ValidID(id) {
+		return "", false, nil
+	}
+	var slug string
+	err := s.db.QueryRowContext(ctx, `SELECT slug FROM articles WHERE id=$1`, id).Scan(&slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return slug, err == nil, err
+}
+
+func (s *server) listGallery(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if _, ok, err := s.articleSlugByID(ctx, id); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryGalleryFailed)
+		return
+	} else if !ok {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+	images, err := s.queryGallery(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryGalleryFailed)
+		return
+	}
+	if images == nil {
+		images = []galleryImage{}
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// uploadGalleryImages appends the uploaded "files" (or a single "file") to
+// the end of the article's gallery. Nothing is stored unless every file is
+// accepted.
+func (s *server) uploadGalleryImages(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok || !s.checkArticleAuthor(c, id) {
+		return
+	}
+	dir, ok := s.galleryDir()
+	if !ok {
+		respondError(c, http.StatusServiceUnavailable, errGalleryDisabled)
+		return
+	}
+	slug, ok, err := s.articleSlugByID(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryGalleryFailed)
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxGalleryUploadBytes)
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, errFileTooLarge)
+			return
+		}
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	headers := append(c.Request.MultipartForm.File["files"], c.Request.MultipartForm.File["file"]...)
+	if len(headers) == 0 {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	uploads := make([]galleryUpload, 0, len(headers))
+	for _, fh := range headers {
+		up, code, err := readGalleryUpload(fh)
+		if err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+			return
+		}
+		if code != "" {
+			status := http.StatusBadRequest
+			if code == errFileTooLarge {
+				status = http.StatusRequestEntityTooLarge
+			}
+			respondErrorDetail(c, status, code, newAPIError(code, cleanFilename(fh.Filename)))
+			return
+		}
+		uploads = append(uploads, up)
+	}
+
+	var written []string
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		var count, next int
+		// lock the article so concurrent uploads don't share positions
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM articles WHERE id=$1 FOR UPDATE`, id); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MAX(position) + 1, 0) FROM gallery_images WHERE article_id=$1`, id).Scan(&count, &next)
+		if err != nil {
+			return err
+		}
+		if count+len(uploads) > maxGalleryImages {
+			return newAPIError(errGalleryFull)
+		}
+		for i, up := range uploads {
+			name, err := newGalleryFileName(up.ext)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filepath.Join(dir, name), up.data, 0o644); err != nil {
+				return err
+			}
+			written = append(written, name)
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO gallery_images (article_id, file, width, height, position) VALUES ($1, $2, $3, $4, $5)`,
+				id, name, up.width, up.height, next+i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.removeGalleryFiles(written)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			respondErrorDetail(c, http.StatusBadRequest, errSaveGalleryFailed, err)
+			return
+		}
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveGalleryFailed, err)
+		return
+	}
+	s.publishFrom(c, eventArticleChanged, actionUpdated, id, slug)
+	images, err := s.queryGallery(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryGalleryFailed)
+		return
+	}
+	c.JSON(http.StatusCreated, images)
+}
+
+type galleryArrangement struct {
+	ID      string `json:"id"`
+	Caption string `json:"caption"`
+}
+
+// arrangeGallery replaces the gallery's order and captions with the posted
+// list. Images left out of the list are removed.
+func (s *server) arrangeGallery(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok || !s.checkArticleAuthor(c, id) {
+		return
+	}
+	var payload []galleryArrangement
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	var v validator
+	seen := map[string]bool{}
+	for i := range payload {
+		payload[i].Caption = strings.TrimSpace(payload[i].Caption)
+		field := fmt.Sprintf("[%d]", i)
+		v.check(maxRunes(payload[i].Caption, maxGalleryCaptionRunes), field+".caption", errCaptionTooLong)
+		v.check(!seen[payload[i].ID], field+".id", errInvalidBody, "duplicate")
+		seen[payload[i].ID] = true
+	}
+	if err := v.err(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	slug, ok, err := s.articleSlugByID(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryGalleryFailed)
+		return
+	}
+	if !ok {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+
+	var removed []string
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		for i, it := range payload {
+			if !store.ValidID(it.ID) {
+				return newAPIError(errGalleryImageNotFound, it.ID)
+			}
+			res, err := tx.ExecContext(ctx, `
+				UPDATE gallery_images SET caption=$1, position=$2
+				WHERE id=$3 AND article_id=$4`, it.Caption, i, it.ID, id)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return newAPIError(errGalleryImageNotFound, it.ID)
+			}
+		}
+		ids := make([]string, len(payload))
+		for i, it := range payload {
+			ids[i] = it.ID
+		}
+		rows, err := tx.QueryContext(ctx, `
+			DELETE FROM gallery_images
+			WHERE article_id=$1 AND NOT (id = ANY($2::text[]::uuid[]))
+			RETURNING file`, id, ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var f string
+			if err := rows.Scan(&f); err != nil {
+				return err
+			}
+			removed = append(removed, f)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			respondErrorDetail(c, http.StatusBadRequest, errSaveGalleryFailed, err)
+			return
+		}
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveGalleryFailed, err)
+		return
+	}
+	s.removeGalleryFiles(removed)
+	s.publishFrom(c, eventArticleChanged, actionUpdated, id, slug)
+	images, err := s.queryGallery(ctx, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryGalleryFailed)
+		return
+	}
+	if images == nil {
+		images = []galleryImage{}
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+func (s *server) deleteGalleryImage(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkArticleAuthor(c, id) {
+		return
+	}
+	imageID, ok := idParam(c, "imageId", errGalleryImageNotFound)
+	if !ok {
+		return
+	}
+	var file, slug string
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM gallery_images g USING articles art
+		WHERE g.id=$1 AND g.article_id=$2 AND art.id = g.article_id
+		RETURNING g.file, art.slug`, imageID, id).Scan(&file, &slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(c, http.StatusNotFound, errGalleryImageNotFound)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, errSaveGalleryFailed)
+		return
+	}
+	s.removeGalleryFiles([]string{file})
+	s.publishFrom(c, eventArticleChanged, actionUpdated, id, slug)
+	c.Status(http.StatusNoContent)
+}
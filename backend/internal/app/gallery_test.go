@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlaceGallery(t *testing.T) {
+	s := &server{basePath: "/blog"}
+	images := []galleryImage{
+		{URL: s.galleryURL("a.png"), Caption: `Sunset <3`, Width: 40, Height: 30},
+		{URL: s.galleryURL("b.webp")},
+	}
+
+	got := s.placeGallery("<p>before</p>\n<p>[[gallery]]</p>\n<p>after</p><p>[[gallery]]</p>", images)
+	if strings.Count(got, `class="gallery `) != 1 || strings.Contains(got, "[[gallery]]") {
+		t.Fatalf("marker not replaced once: %s", got)
+	}
+	if strings.Index(got, "gallery-item") > strings.Index(got, "after") {
+		t.Fatalf("gallery not placed at the marker: %s", got)
+	}
+	for _, want := range []string{`src="/blog/media/gallery/a.png"`, `width="40" height="30"`, `alt="Sunset &lt;3"`, `<figcaption`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+	if strings.Count(got, "<figcaption") != 1 {
+		t.Errorf("uncaptioned image got a caption: %s", got)
+	}
+
+	if got := s.placeGallery("<p>text</p>", images); !strings.HasPrefix(got, "<p>text</p><div class=\"gallery ") {
+		t.Fatalf("gallery not appended: %s", got)
+	}
+	if got := s.placeGallery("<p>a</p>\n<p>[[gallery]]</p>", nil); got != "<p>a</p>\n" {
+		t.Fatalf("empty gallery = %q", got)
+	}
+}
+
+func TestReadGalleryUpload(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 12, 7))); err != nil {
+		t.Fatal(err)
+	}
+	files := multipartFiles(t, map[string][]byte{"photo.png": img.Bytes(), "notes.txt": []byte("hello")})
+
+	up, code, err := readGalleryUpload(files["photo.png"])
+	if err != nil || code != "" || up.ext != ".png" || up.width != 12 || up.height != 7 {
+		t.Fatalf("png = %+v, %q, %v", up, code, err)
+	}
+	if _, code, err := readGalleryUpload(files["notes.txt"]); err != nil || code != errInvalidGalleryImage {
+		t.Fatalf("text = %q, %v", code, err)
+	}
+}
+
+func multipartFiles(t *testing.T, contents map[string][]byte) map[string]*multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, data := range contents {
+		part, err := w.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	w.Close()
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]*multipart.FileHeader{}
+	for _, fh := range req.MultipartForm.File["files"] {
+		out[fh.Filename] = fh
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// geoIPRange is one row of an IP-to-country CSV: the inclusive range
+// [start, end] belongs to country.
+type geoIPRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// geoIPDB answers country lookups from a CSV of "start,end,country" rows,
+// the layout of the free DB-IP and IP2Location LITE country files. Rows must
+// not overlap.
+type geoIPDB struct {
+	ranges []geoIPRange
+}
+
+func loadGeoIPDB(path string) (*geoIPDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseGeoIPDB(f)
+}
+
+func parseGeoIPDB(r io.Reader) (*geoIPDB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	db := &geoIPDB{}
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("第 %d 行字段不足", line)
+		}
+		start, err1 := netip.ParseAddr(strings.TrimSpace(rec[0]))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(rec[1]))
+		if err1 != nil || err2 != nil || start.Is4() != end.Is4() || end.Less(start) {
+			return nil, fmt.Errorf("第 %d 行 IP 范围无效", line)
+		}
+		country := strings.ToUpper(strings.TrimSpace(rec[2]))
+		if country == "" || country == "-" || country == "ZZ" {
+			continue
+		}
+		db.ranges = append(db.ranges, geoIPRange{start: start, end: end, country: country})
+	}
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start.Less(db.ranges[j].start) })
+	return db, nil
+}
+
+// country returns the ISO code for ip, or "" when it is unknown.
+func (db *geoIPDB) country(ip string) string {
+	if db == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	// first range starting after addr; the candidate is the one before it
+	i := sort.Search(len(db.ranges), func(i int) bool { return addr.Less(db.ranges[i].start) })
+	if i == 0 {
+		return ""
+	}
+	r := db.ranges[i-1]
+	if r.start.Is4() != addr.Is4() || r.end.Less(addr) {
+		return ""
+	}
+	return r.country
+}
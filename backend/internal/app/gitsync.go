@@ -0,0 +1,618 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Git sync mirrors articles into a Git repository as Markdown files with
+// front matter, one <slug>.md per article. Content changes queue a sync job,
+// and so does the webhook the repository host calls on push. A sync pulls,
+// imports the files changed in the repository since the last sync, rewrites
+// the mirror from the database, commits and pushes. An article edited on both
+// sides in between keeps the repository's version; files removed from the
+// repository do not delete articles.
+const (
+	jobGitSync = "git.sync"
+	// gitSyncedRef marks the last commit the mirror was written at; files
+	// changed after it were changed in the repository.
+	gitSyncedRef = "refs/selfecho/synced"
+	// gitPushDelay lets a burst of edits, such as autosaves, share a commit.
+	gitPushDelay      = 15 * time.Second
+	defaultGitTimeout = 2 * time.Minute
+	maxGitWebhookBody = 1 << 20
+)
+
+// gitSyncConfig enables the Git mirror. Dir is the working copy, relative to
+// the config file; it is created on first use. Remote, when set, is fetched
+// before and pushed to after every sync. Password-protected articles are
+// never written; drafts are unless ExcludeDrafts, or encryption is enabled,
+// which keeps them from leaving the database in plaintext.
+type gitSyncConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Dir           string `yaml:"dir"`
+	Remote        string `yaml:"remote"`
+	Branch        string `yaml:"branch"`
+	Path          string `yaml:"path"`
+	ExcludeDrafts bool   `yaml:"excludeDrafts"`
+	AuthorName    string `yaml:"authorName"`
+	AuthorEmail   string `yaml:"authorEmail"`
+	// WebhookSecret authenticates POST /api/git/webhook (GitHub, Gitea or
+	// GitLab style); without it the webhook is off.
+	WebhookSecret  string `yaml:"webhookSecret"`
+	Command        string `yaml:"command"`
+	TimeoutSeconds int    `yaml:"timeoutSeconds"`
+}
+
+func (c gitSyncConfig) branch() string {
+	if c.Branch != "" {
+		return c.Branch
+	}
+	return "main"
+}
+
+// path is the repository directory holding the articles, "content" unless
+// configured; "." is the repository root.
+func (c gitSyncConfig) path() string {
+	if c.Path != "" {
+		return path.Clean(c.Path)
+	}
+	return "content"
+}
+
+func (c gitSyncConfig) command() string {
+	if c.Command != "" {
+		return c.Command
+	}
+	return "git"
+}
+
+func (c gitSyncConfig) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return defaultGitTimeout
+}
+
+func validateGitSync(c gitSyncConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if strings.TrimSpace(c.Dir) == "" {
+		return errors.New("gitSync.dir 不能为空")
+	}
+	if p := c.path(); path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("gitSync.path 需为仓库内的相对路径: %q", c.Path)
+	}
+	if strings.ContainsAny(c.branch(), " ~^:?*[\\") || strings.HasPrefix(c.branch(), "-") {
+		return fmt.Errorf("gitSync.branch 无效: %q", c.Branch)
+	}
+	if strings.HasPrefix(c.Remote, "-") {
+		return fmt.Errorf("gitSync.remote 无效: %q", c.Remote)
+	}
+	return nil
+}
+
+// gitMirror runs the git commands of the sync. mu serializes jobs, since
+// they share one working copy.
+type gitMirror struct {
+	cfg gitSyncConfig
+	dir string
+	mu  sync.Mutex
+}
+
+func newGitMirror(cfgPath string, cfg gitSyncConfig) *gitMirror {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &gitMirror{cfg: cfg, dir: resolveMediaDir(cfgPath, cfg.Dir)}
+}
+
+// root is the directory the article files live in.
+func (g *gitMirror) root() string {
+	return filepath.Join(g.dir, filepath.FromSlash(g.cfg.path()))
+}
+
+func (g *gitMirror) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.timeout())
+	defer cancel()
+	name, email := g.cfg.AuthorName, g.cfg.AuthorEmail
+	if name == "" {
+		name = "selfecho"
+	}
+	if email == "" {
+		email = "selfecho@localhost"
+	}
+	full := append([]string{"-C", g.dir, "-c", "user.name=" + name, "-c", "user.email=" + email}, args...)
+	cmd := exec.CommandContext(ctx, g.cfg.command(), full...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+func (g *gitMirror) hasRef(ctx context.Context, ref string) bool {
+	_, err := g.run(ctx, "rev-parse", "--verify", "--quiet", ref)
+	return err == nil
+}
+
+// prepare makes Dir a clean checkout of the branch, with the remote's
+// commits rebased under any local ones that were not pushed yet.
+func (g *gitMirror) prepare(ctx context.Context) error {
+	branch := g.cfg.branch()
+	if _, err := os.Stat(filepath.Join(g.dir, ".git")); err != nil {
+		if err := os.MkdirAll(g.dir, 0o755); err != nil {
+			return err
+		}
+		if _, err := g.run(ctx, "init", "--quiet"); err != nil {
+			return err
+		}
+		if _, err := g.run(ctx, "symbolic-ref", "HEAD", "refs/heads/"+branch); err != nil {
+			return err
+		}
+	} else if g.hasRef(ctx, "HEAD") {
+		// an interrupted sync may have left changes behind; the mirror is
+		// rebuilt from the database anyway
+		if _, err := g.run(ctx, "reset", "--hard", "--quiet"); err != nil {
+			return err
+		}
+	}
+	if g.cfg.Remote == "" {
+		return nil
+	}
+	if _, err := g.run(ctx, "remote", "get-url", "origin"); err != nil {
+		if _, err := g.run(ctx, "remote", "add", "origin", g.cfg.Remote); err != nil {
+			return err
+		}
+	} else if _, err := g.run(ctx, "remote", "set-url", "origin", g.cfg.Remote); err != nil {
+		return err
+	}
+	if _, err := g.run(ctx, "fetch", "--quiet", "origin"); err != nil {
+		return err
+	}
+	upstream := "origin/" + branch
+	if !g.hasRef(ctx, "refs/remotes/"+upstream) {
+		return nil
+	}
+	if !g.hasRef(ctx, "HEAD") {
+		_, err := g.run(ctx, "checkout", "--quiet", "-f", "-B", branch, upstream)
+		return err
+	}
+	if _, err := g.run(ctx, "rebase", "--quiet", upstream); err != nil {
+		g.run(ctx, "rebase", "--abort")
+		return err
+	}
+	return nil
+}
+
+// incoming lists the article files changed in the repository since the
+// last sync, or all of them when the mirror has never been written here.
+func (g *gitMirror) incoming(ctx context.Context) ([]string, error) {
+	if !g.hasRef(ctx, "HEAD") {
+		return nil, nil
+	}
+	var out string
+	var err error
+	if g.hasRef(ctx, gitSyncedRef) {
+		out, err = g.run(ctx, "diff", "--name-only", "-z", gitSyncedRef, "HEAD", "--", g.cfg.path())
+	} else {
+		out, err = g.run(ctx, "ls-files", "-z", "--", g.cfg.path())
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range strings.Split(out, "\x00") {
+		if path.Dir(f) == g.cfg.path() && path.Ext(f) == ".md" {
+			names = append(names, path.Base(f))
+		}
+	}
+	return names, nil
+}
+
+// commit records every change below the article directory.
+func (g *gitMirror) commit(ctx context.Context, message string) error {
+	if _, err := g.run(ctx, "add", "--all", "--", g.cfg.path()); err != nil {
+		return err
+	}
+	if _, err := g.run(ctx, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	_, err := g.run(ctx, "commit", "--quiet", "-m", message)
+	return err
+}
+
+// markSynced moves gitSyncedRef to HEAD.
+func (g *gitMirror) markSynced(ctx context.Context) error {
+	if !g.hasRef(ctx, "HEAD") {
+		return nil
+	}
+	_, err := g.run(ctx, "update-ref", gitSyncedRef, "HEAD")
+	return err
+}
+
+// push sends local commits, if there is a remote and anything to send.
+func (g *gitMirror) push(ctx context.Context) error {
+	if g.cfg.Remote == "" || !g.hasRef(ctx, "HEAD") {
+		return nil
+	}
+	_, err := g.run(ctx, "push", "--quiet", "origin", "HEAD:refs/heads/"+g.cfg.branch())
+	return err
+}
+
+// gitMarkdown is the mirror file of a.
+func gitMarkdown(a article, loc *time.Location) ([]byte, error) {
+	fm := articleFrontMatter(a, loc)
+	fm.ID = a.ID
+	return markdownFile(fm, a.BodyMD)
+}
+
+// queueGitSync adds a sync job unless one is already waiting, so a burst of
+// changes is handled by one run.
+func (s *server) queueGitSync(ctx context.Context, delay time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO outbox (type, payload, run_at)
+		SELECT $1, '{}'::jsonb, now() + $2::interval
+		WHERE NOT EXISTS (SELECT 1 FROM outbox WHERE type = $1 AND status = 'pending')`,
+		jobGitSync, fmt.Sprintf("%d seconds", int(delay.Seconds())))
+	if err == nil {
+		s.wakeOutbox()
+	}
+	return err
+}
+
+// queueGitPush is the event subscriber behind push on change.
+func (s *server) queueGitPush(ev changeEvent) {
+	switch ev.Kind {
+	case eventArticleChanged, eventArchiveChanged, eventAuthorChanged:
+	default:
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.queueGitSync(ctx, gitPushDelay); err != nil {
+			warnf("排队 Git 同步失败: %v\n", err)
+		}
+	}()
+}
+
+// gitArticleIDs lists the articles the mirror holds.
+func (s *server) gitArticleIDs(ctx context.Context) ([]string, error) {
+	statuses := []string{"published", "draft"}
+	if s.git.cfg.ExcludeDrafts || s.content != nil {
+		statuses = statuses[:1]
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM articles
+		WHERE status = ANY($1) AND visibility <> 'password'
+		ORDER BY id`, statuses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// writeGitMirror brings the article directory in line with the database and
+// returns the names of the files it wrote or removed. Files that aren't
+// Markdown are left alone.
+func (s *server) writeGitMirror(ctx context.Context) ([]string, error) {
+	root := s.git.root()
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	ids, err := s.gitArticleIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	want := map[string][]byte{}
+	for _, id := range ids {
+		a, ok, err := s.queryPost(ctx, `art.id=$1`, id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		data, err := gitMarkdown(a, s.siteLocation())
+		if err != nil {
+			return nil, err
+		}
+		want[a.Slug+".md"] = data
+	}
+
+	var changed []string
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".md" {
+			continue
+		}
+		if _, ok := want[e.Name()]; !ok {
+			if err := os.Remove(filepath.Join(root, e.Name())); err != nil {
+				return nil, err
+			}
+			changed = append(changed, e.Name())
+		}
+	}
+	for name, data := range want {
+		file := filepath.Join(root, name)
+		if current, err := os.ReadFile(file); err == nil && bytes.Equal(current, data) {
+			continue
+		}
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			return nil, err
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func gitCommitMessage(changed []string) string {
+	if len(changed) == 1 {
+		return "selfecho: update " + changed[0]
+	}
+	return fmt.Sprintf("selfecho: update %d articles\n\n%s", len(changed), strings.Join(changed, "\n"))
+}
+
+// gitFilePayload turns a mirror file back into an article payload. The file
+// is the article's whole state, so missing tags or description clear them.
+func gitFilePayload(name string, data []byte) (articlePayload, string, error) {
+	head, body := splitFrontMatter(string(data))
+	var fm exportFrontMatter
+	if err := yaml.Unmarshal([]byte(head), &fm); err != nil {
+		return articlePayload{}, "", err
+	}
+	p := articlePayload{
+		Title:       fm.Title,
+		Slug:        fm.Slug,
+		Archive:     fm.Category,
+		Status:      "published",
+		Type:        fm.Type,
+		BodyMD:      strings.TrimRight(body, "\n"),
+		Lang:        &fm.Lang,
+		Description: &fm.Description,
+	}
+	if p.Slug == "" {
+		p.Slug = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if p.Type == "" {
+		p.Type = "post"
+	}
+	if fm.Draft {
+		p.Status = "draft"
+	} else {
+		p.PublishedAt = fm.Date
+	}
+	tags := fm.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	p.Tags = &tags
+	if len(fm.Authors) > 0 {
+		p.Authors = &fm.Authors
+	}
+	return p, fm.ID, nil
+}
+
+// gitActor is the user changes from the repository are made as: the first
+// admin.
+func (s *server) gitActor(ctx context.Context) (user, error) {
+	var u user
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, username, role, created_at FROM users
+		WHERE role = 'admin' ORDER BY created_at LIMIT 1`).Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt)
+	return u, err
+}
+
+// readGitChanges returns a payload for each of the named mirror files whose
+// content differs from what the database would write for it, with the ID of
+// the article it updates ("" for new ones). A file carrying an article's ID
+// under a new slug renames that article when it is imported.
+func (s *server) readGitChanges(ctx context.Context, names []string) (items []json.RawMessage, ids, read []string, err error) {
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(s.git.root(), name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		p, id, err := gitFilePayload(name, data)
+		if err != nil {
+			warnf("Git 文件 %s 的 front matter 无效: %v\n", name, err)
+			continue
+		}
+		var current article
+		var ok bool
+		if store.ValidID(id) {
+			current, ok, err = s.queryPost(ctx, `art.id=$1`, id)
+		} else {
+			current, ok, err = s.queryPost(ctx, `art.slug=$1`, p.Slug)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if ok {
+			if mirrored, err := gitMarkdown(current, s.siteLocation()); err == nil && bytes.Equal(mirrored, data) {
+				continue
+			}
+		}
+		raw, err := json.Marshal(p)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		items = append(items, raw)
+		ids = append(ids, current.ID)
+		read = append(read, name)
+	}
+	return items, ids, read, nil
+}
+
+// importGitChanges upserts the files changed in the repository and returns
+// the problems with those that could not be imported.
+func (s *server) importGitChanges(ctx context.Context) ([]string, error) {
+	names, err := s.git.incoming(ctx)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	items, ids, names, err := s.readGitChanges(ctx, names)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	actor, err := s.gitActor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]importResult, len(items))
+	for start := 0; start < len(items); start += importBatchSize {
+		end := min(start+importBatchSize, len(items))
+		s.importBatch(ctx, items[start:end], ids[start:end], results[start:end], start, true, actor, "")
+	}
+	var failed []string
+	for i, r := range results {
+		switch r.Status {
+		case importCreated:
+			s.refreshSearchIndex(r.ID)
+			s.refreshLinkGraph(r.ID)
+			s.publish(eventArticleChanged, actionCreated, r.ID, r.Slug)
+		case importUpdated:
+			s.refreshSearchIndex(r.ID)
+			s.refreshLinkGraph(r.ID)
+			s.publish(eventArticleChanged, actionUpdated, r.ID, r.Slug)
+		default:
+			failed = append(failed, names[i]+": "+r.Error)
+		}
+	}
+	return failed, nil
+}
+
+func (s *server) runGitSyncJob(ctx context.Context, _ []byte) error {
+	if s.git == nil {
+		return fmt.Errorf("%w: 未启用 gitSync", errJobPermanent)
+	}
+	s.git.mu.Lock()
+	defer s.git.mu.Unlock()
+	if err := s.git.prepare(ctx); err != nil {
+		return err
+	}
+	failed, err := s.importGitChanges(ctx)
+	if err != nil {
+		return err
+	}
+	changed, err := s.writeGitMirror(ctx)
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		if err := s.git.commit(ctx, gitCommitMessage(changed)); err != nil {
+			return err
+		}
+	}
+	if err := s.git.markSynced(ctx); err != nil {
+		return err
+	}
+	// also retries commits an earlier failed push left behind
+	if err := s.git.push(ctx); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		// the mirror has already put the database's version back
+		return fmt.Errorf("%w: %s", errJobPermanent, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// verifyGitWebhook accepts GitHub's and Gitea's HMAC signatures of the body
+// and GitLab's plain token.
+func verifyGitWebhook(secret string, h http.Header, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if sig := h.Get("X-Hub-Signature-256"); sig != "" {
+		return hmac.Equal([]byte(sig), []byte("sha256="+want))
+	}
+	if sig := h.Get("X-Gitea-Signature"); sig != "" {
+		return hmac.Equal([]byte(sig), []byte(want))
+	}
+	if token := h.Get("X-Gitlab-Token"); token != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+// gitWebhook backs POST /api/git/webhook, which the repository host calls
+// on push; it queues a sync to pull the changes in.
+func (s *server) gitWebhook(c *gin.Context) {
+	if s.git == nil || s.git.cfg.WebhookSecret == "" {
+		respondError(c, http.StatusNotFound, errGitSyncDisabled)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxGitWebhookBody))
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	if !verifyGitWebhook(s.git.cfg.WebhookSecret, c.Request.Header, body) {
+		respondError(c, http.StatusUnauthorized, errInvalidWebhookSignature)
+		return
+	}
+	if c.GetHeader("X-GitHub-Event") == "ping" {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if err := s.queueGitSync(c.Request.Context(), 0); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveJobFailed, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// syncGitNow backs POST /api/admin/git/sync.
+func (s *server) syncGitNow(c *gin.Context) {
+	if s.git == nil {
+		respondError(c, http.StatusServiceUnavailable, errGitSyncDisabled)
+		return
+	}
+	if err := s.queueGitSync(c.Request.Context(), 0); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveJobFailed, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateGitSync(t *testing.T) {
+	ok := gitSyncConfig{Enabled: true, Dir: "mirror"}
+	if err := validateGitSync(ok); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateGitSync(gitSyncConfig{}); err != nil {
+		t.Fatalf("disabled config should pass: %v", err)
+	}
+	for _, bad := range []gitSyncConfig{
+		{Enabled: true},
+		{Enabled: true, Dir: "m", Path: "../up"},
+		{Enabled: true, Dir: "m", Path: "/abs"},
+		{Enabled: true, Dir: "m", Branch: "a b"},
+		{Enabled: true, Dir: "m", Remote: "--upload-pack=x"},
+	} {
+		if validateGitSync(bad) == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+func TestGitFilePayloadRoundTrip(t *testing.T) {
+	pub := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	a := article{
+		ID: "42", Title: "Hello", Slug: "hello", Status: "published", Type: "post", Archive: "notes",
+		Tags: tagList{"go"}, Authors: []byline{{Username: "ann"}}, BodyMD: "# Hi\n\nthere",
+		PublishedAt: &pub, UpdatedAt: pub,
+	}
+	data, err := gitMarkdown(a, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "---\nid: \"42\"\n") {
+		t.Fatalf("mirror file should lead with the id:\n%s", data)
+	}
+	p, id, err := gitFilePayload("hello.md", data)
+	if err != nil || id != "42" {
+		t.Fatalf("id %q, %v", id, err)
+	}
+	if p.Title != "Hello" || p.Slug != "hello" || p.Status != "published" || p.Archive != "notes" || p.BodyMD != "# Hi\n\nthere" || p.PublishedAt != "2026-03-01T08:00:00Z" {
+		t.Fatalf("payload = %+v", p)
+	}
+	if p.Tags == nil || strings.Join(*p.Tags, ",") != "go" || p.Authors == nil || (*p.Authors)[0] != "ann" {
+		t.Fatalf("tags %v, authors %v", p.Tags, p.Authors)
+	}
+
+	p, _, err = gitFilePayload("new-post.md", []byte("---\ntitle: New\ndraft: true\n---\n\nbody\n"))
+	if err != nil || p.Slug != "new-post" || p.Status != "draft" || p.Type != "post" || p.Tags == nil || len(*p.Tags) != 0 {
+		t.Fatalf("new file = %+v, %v", p, err)
+	}
+}
+
+func TestVerifyGitWebhook(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	for _, h := range []http.Header{
+		{"X-Hub-Signature-256": {"sha256=" + sig}},
+		{"X-Gitea-Signature": {sig}},
+		{"X-Gitlab-Token": {"s3cret"}},
+	} {
+		if !verifyGitWebhook("s3cret", h, body) {
+			t.Errorf("%v should verify", h)
+		}
+	}
+	for _, h := range []http.Header{
+		{},
+		{"X-Hub-Signature-256": {"sha256=" + sig}},
+		{"X-Gitlab-Token": {"nope"}},
+	} {
+		if verifyGitWebhook("other", h, body) {
+			t.Errorf("%v should not verify", h)
+		}
+	}
+}
+
+func TestGitMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+	base := t.TempDir()
+	remote := filepath.Join(base, "remote.git")
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	g := newGitMirror(filepath.Join(base, "config.yaml"), gitSyncConfig{Enabled: true, Dir: "mirror", Remote: remote})
+
+	if err := g.prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := g.incoming(ctx); err != nil || len(names) != 0 {
+		t.Fatalf("empty mirror incoming = %v, %v", names, err)
+	}
+	os.MkdirAll(g.root(), 0o755)
+	os.WriteFile(filepath.Join(g.root(), "a.md"), []byte("a\n"), 0o644)
+	os.WriteFile(filepath.Join(g.root(), "b.md"), []byte("b\n"), 0o644)
+	if err := g.commit(ctx, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.markSynced(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.push(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// someone else edits b.md and adds c.md in another clone
+	other := filepath.Join(base, "other")
+	gitIn := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=o", "-c", "user.email=o@x"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	gitIn(base, "clone", "--quiet", "-b", "main", remote, other)
+	os.WriteFile(filepath.Join(other, "content", "b.md"), []byte("b2\n"), 0o644)
+	os.WriteFile(filepath.Join(other, "content", "c.md"), []byte("c\n"), 0o644)
+	os.WriteFile(filepath.Join(other, "README.md"), []byte("readme\n"), 0o644)
+	gitIn(other, "add", "-A")
+	gitIn(other, "commit", "--quiet", "-m", "edit")
+	gitIn(other, "push", "--quiet", "origin", "HEAD:main")
+
+	// meanwhile a sync committed a.md locally but its push did not happen
+	os.WriteFile(filepath.Join(g.root(), "a.md"), []byte("a2\n"), 0o644)
+	if err := g.commit(ctx, "local"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.markSynced(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	names, err := g.incoming(ctx)
+	if err != nil || strings.Join(names, ",") != "b.md,c.md" {
+		t.Fatalf("incoming = %v, %v", names, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(g.root(), "a.md")); string(data) != "a2\n" {
+		t.Fatalf("local commit lost in rebase: %q", data)
+	}
+	if err := g.markSynced(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := g.incoming(ctx); len(names) != 0 {
+		t.Fatalf("nothing should be incoming after marking: %v", names)
+	}
+	if err := g.push(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
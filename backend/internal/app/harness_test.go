@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The integration tests run the real router against Postgres. Point
+// SELFECHO_TEST_DATABASE_URL at any database the tests may create schemas
+// in, e.g. a throwaway container:
+//
+//	docker run --rm -e POSTGRES_PASSWORD=pw -p 5433:5432 postgres:16
+//	SELFECHO_TEST_DATABASE_URL=postgres://postgres:pw@localhost:5433/postgres?sslmode=disable go test ./...
+//
+// Each test gets its own schema, dropped afterwards. Without the variable
+// the tests are skipped.
+const (
+	testAdminUser     = "admin"
+	testAdminPassword = "integration-pw"
+)
+
+type testApp struct {
+	t      *testing.T
+	s      *server
+	db     *sql.DB
+	srv    *httptest.Server
+	client *http.Client
+}
+
+// newTestApp migrates a fresh schema exactly like Run (sql/01_init.sql, then
+// the startup migrations), creates the admin user and serves the router.
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	raw := os.Getenv("SELFECHO_TEST_DATABASE_URL")
+	if raw == "" {
+		t.Skip("SELFECHO_TEST_DATABASE_URL not set")
+	}
+	gin.SetMode(gin.TestMode)
+	db := openTestSchema(t, raw)
+	initSQL, err := os.ReadFile(filepath.Join("..", "..", "..", "sql", "01_init.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(initSQL)); err != nil {
+		t.Fatalf("01_init.sql: %v", err)
+	}
+
+	cfg := defaultConfig()
+	cfg.StaticDir = ""
+	cfg.Static.MediaDir = ""
+	s, err := newServer(filepath.Join(t.TempDir(), "config.yaml"), cfg, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the upstreams tests stand up listen on loopback, which newPublicClient refuses
+	s.publicClient = &http.Client{Timeout: 15 * time.Second}
+	ctx := context.Background()
+	if err := s.ensureSchema(ctx); err != nil {
+		t.Fatalf("ensureSchema: %v", err)
+	}
+	if err := s.createUser(ctx, testAdminUser, testAdminPassword, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	router, err := s.routes(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	jar, _ := cookiejar.New(nil)
+	return &testApp{t: t, s: s, db: db, srv: srv, client: &http.Client{Jar: jar}}
+}
+
+func openTestSchema(t *testing.T, raw string) *sql.DB {
+	t.Helper()
+	admin, err := sql.Open("pgx", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("app_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE EXTENSION IF NOT EXISTS pgcrypto; CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema+",public")
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("pgx", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if cleanup, err := sql.Open("pgx", raw); err == nil {
+			cleanup.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			cleanup.Close()
+		}
+	})
+	return db
+}
+
+// do sends a request with the app's cookie jar; body is JSON-encoded unless
+// it is already a string.
+func (a *testApp) do(method, path string, body any) *http.Response {
+	a.t.Helper()
+	switch b := body.(type) {
+	case nil:
+		return a.doRaw(method, path, "", "")
+	case string:
+		return a.doRaw(method, path, "application/json", b)
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		return a.doRaw(method, path, "application/json", string(buf))
+	}
+}
+
+// doRaw sends body as is; headers are added as name, value pairs.
+func (a *testApp) doRaw(method, path, contentType, body string, headers ...string) *http.Response {
+	a.t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, a.srv.URL+path, r)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// expect fails unless resp has status and returns its body.
+func (a *testApp) expect(resp *http.Response, status int) string {
+	a.t.Helper()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		a.t.Fatalf("%s %s: got %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, b)
+	}
+	return string(b)
+}
+
+func (a *testApp) decode(resp *http.Response, status int, v any) {
+	a.t.Helper()
+	if err := json.Unmarshal([]byte(a.expect(resp, status)), v); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func (a *testApp) login() {
+	a.t.Helper()
+	a.expect(a.do(http.MethodPost, "/api/auth/login", map[string]string{
+		"username": testAdminUser, "password": testAdminPassword,
+	}), http.StatusOK)
+}
+
+// seedPost creates a published post through the API and returns its id.
+func (a *testApp) seedPost(title, slug, body string) string {
+	a.t.Helper()
+	var out struct{ ID, Slug string }
+	a.decode(a.do(http.MethodPost, "/api/articles", map[string]string{
+		"title": title, "slug": slug, "bodyMd": body, "status": "published", "archive": "notes",
+	}), http.StatusCreated, &out)
+	return out.ID
+}
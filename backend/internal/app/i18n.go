@@ -0,0 +1,704 @@
+package app
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errCode is the stable, machine-readable identifier returned alongside every
+// API error; clients should switch on it rather than on the localized text.
+type errCode string
+
+const (
+	errInvalidBody             errCode = "invalid_body"
+	errNotFound                errCode = "not_found"
+	errUnauthorized            errCode = "unauthorized"
+	errSessionExpired          errCode = "session_expired"
+	errInvalidCredentials      errCode = "invalid_credentials"
+	errCredentialsRequired     errCode = "credentials_required"
+	errCreateSessionFailed     errCode = "create_session_failed"
+	errBeginTxFailed           errCode = "begin_tx_failed"
+	errCommitFailed            errCode = "commit_failed"
+	errTitleRequired           errCode = "title_required"
+	errInvalidStatus           errCode = "invalid_status"
+	errInvalidType             errCode = "invalid_type"
+	errInvalidSlug             errCode = "invalid_slug"
+	errSlugTitleEmpty          errCode = "slug_title_empty"
+	errSlugGenerationFailed    errCode = "slug_generation_failed"
+	errSlugDedupeFailed        errCode = "slug_dedupe_failed"
+	errInvalidSlugMode         errCode = "invalid_slug_mode"
+	errLLMNotConfigured        errCode = "llm_not_configured"
+	errLLMFailed               errCode = "llm_failed"
+	errQueryArticlesFailed     errCode = "query_articles_failed"
+	errParseArticlesFailed     errCode = "parse_articles_failed"
+	errCountArticlesFailed     errCode = "count_articles_failed"
+	errCreateArticleFailed     errCode = "create_article_failed"
+	errUpdateArticleFailed     errCode = "update_article_failed"
+	errDeleteArticleFailed     errCode = "delete_article_failed"
+	errArticleNotFound         errCode = "article_not_found"
+	errNameRequired            errCode = "name_required"
+	errQueryArchivesFailed     errCode = "query_archives_failed"
+	errParseArchivesFailed     errCode = "parse_archives_failed"
+	errCreateArchiveFailed     errCode = "create_archive_failed"
+	errUpdateArchiveFailed     errCode = "update_archive_failed"
+	errDeleteArchiveFailed     errCode = "delete_archive_failed"
+	errArchiveNotFound         errCode = "archive_not_found"
+	errDetachArticlesFailed    errCode = "detach_articles_failed"
+	errQueryCategoriesFailed   errCode = "query_categories_failed"
+	errParseCategoriesFailed   errCode = "parse_categories_failed"
+	errQueryTimelineFailed     errCode = "query_timeline_failed"
+	errSiteTitleRequired       errCode = "site_title_required"
+	errInvalidPostsPerPage     errCode = "invalid_posts_per_page"
+	errInvalidTimezone         errCode = "invalid_timezone"
+	errSocialLinkRequired      errCode = "social_link_required"
+	errInvalidSocialLink       errCode = "invalid_social_link"
+	errSnippetTooLong          errCode = "snippet_too_long"
+	errSaveSettingsFailed      errCode = "save_settings_failed"
+	errReloadFailed            errCode = "reload_failed"
+	errQueryImapAccountsFailed errCode = "query_imap_accounts_failed"
+	errParseImapAccountsFailed errCode = "parse_imap_accounts_failed"
+	errImapFieldsRequired      errCode = "imap_fields_required"
+	errEncryptPasswordFailed   errCode = "encrypt_password_failed"
+	errSaveImapAccountFailed   errCode = "save_imap_account_failed"
+	errImapAccountLookupFailed errCode = "imap_account_lookup_failed"
+	errImapAccountNotFound     errCode = "imap_account_not_found"
+	errImapThreadNotFound      errCode = "imap_thread_not_found"
+	errQueryImapRulesFailed    errCode = "query_imap_rules_failed"
+	errSaveImapRuleFailed      errCode = "save_imap_rule_failed"
+	errImapRuleNotFound        errCode = "imap_rule_not_found"
+	errImapRuleMatchRequired   errCode = "imap_rule_match_required"
+	errInvalidImapRuleAction   errCode = "invalid_imap_rule_action"
+	errInvalidImapRuleTarget   errCode = "invalid_imap_rule_target"
+	errQueryMailFeedsFailed    errCode = "query_mail_feeds_failed"
+	errSaveMailFeedFailed      errCode = "save_mail_feed_failed"
+	errMailFeedNotFound        errCode = "mail_feed_not_found"
+	errInvalidImapFolder       errCode = "invalid_imap_folder"
+	errQueryReaderFailed       errCode = "query_reader_failed"
+	errSaveReaderFeedFailed    errCode = "save_reader_feed_failed"
+	errReaderFeedNotFound      errCode = "reader_feed_not_found"
+	errReaderFeedExists        errCode = "reader_feed_exists"
+	errReaderItemNotFound      errCode = "reader_item_not_found"
+	errInvalidFeedURL          errCode = "invalid_feed_url"
+	errFetchFeedFailed         errCode = "fetch_feed_failed"
+	errQueryBookmarksFailed    errCode = "query_bookmarks_failed"
+	errSaveBookmarkFailed      errCode = "save_bookmark_failed"
+	errBookmarkNotFound        errCode = "bookmark_not_found"
+	errInvalidBookmarkURL      errCode = "invalid_bookmark_url"
+	errNoteEmpty               errCode = "note_empty"
+	errNoteTooLong             errCode = "note_too_long"
+	errGalleryDisabled         errCode = "gallery_disabled"
+	errInvalidGalleryImage     errCode = "invalid_gallery_image"
+	errGalleryFull             errCode = "gallery_full"
+	errGalleryImageNotFound    errCode = "gallery_image_not_found"
+	errCaptionTooLong          errCode = "caption_too_long"
+	errQueryGalleryFailed      errCode = "query_gallery_failed"
+	errSaveGalleryFailed       errCode = "save_gallery_failed"
+	errQueryStatsFailed        errCode = "query_stats_failed"
+	errInvalidImportArchive    errCode = "invalid_import_archive"
+	errInvalidImportSite       errCode = "invalid_import_site"
+	errGitSyncDisabled         errCode = "git_sync_disabled"
+	errInvalidWebhookSignature errCode = "invalid_webhook_signature"
+	errBackupDisabled          errCode = "backup_disabled"
+	errBackupInProgress        errCode = "backup_in_progress"
+	errQueryBackupsFailed      errCode = "query_backups_failed"
+	errInvalidBackupKind       errCode = "invalid_backup_kind"
+	errImapFetchFailed         errCode = "imap_fetch_failed"
+	errImapSyncFailed          errCode = "imap_sync_failed"
+	errImapClearCacheFailed    errCode = "imap_clear_cache_failed"
+	errImapResetAccountFailed  errCode = "imap_reset_account_failed"
+	errImapRebuildFailed       errCode = "imap_rebuild_failed"
+	errReadMessagesFailed      errCode = "read_messages_failed"
+	errLoadMessageFailed       errCode = "load_message_failed"
+	errInvalidUID              errCode = "invalid_uid"
+	errHealthUnavailable       errCode = "health_unavailable"
+	errServerStarting          errCode = "server_starting"
+	errDatabaseUnavailable     errCode = "database_unavailable"
+	errInvalidPublishedAt      errCode = "invalid_published_at"
+	errReassignToSelf          errCode = "reassign_to_self"
+	errReassignTargetNotFound  errCode = "reassign_target_not_found"
+	errAIUnavailable           errCode = "ai_unavailable"
+	errAISummaryFailed         errCode = "ai_summary_failed"
+	errAITagsFailed            errCode = "ai_tags_failed"
+	errReindexInProgress       errCode = "reindex_in_progress"
+	errInvalidRobotsRule       errCode = "invalid_robots_rule"
+	errInvalidPublisher        errCode = "invalid_publisher"
+	errInvalidLanguage         errCode = "invalid_language"
+	errTranslationNotFound     errCode = "translation_not_found"
+	errInvalidSocialCard       errCode = "invalid_social_card"
+	errInvalidLink             errCode = "invalid_link"
+	errLinkNotFound            errCode = "link_not_found"
+	errQueryLinksFailed        errCode = "query_links_failed"
+	errSaveLinkFailed          errCode = "save_link_failed"
+	errAttachmentsDisabled     errCode = "attachments_disabled"
+	errAttachmentNotFound      errCode = "attachment_not_found"
+	errFileTooLarge            errCode = "file_too_large"
+	errUploadFailed            errCode = "upload_failed"
+	errQueryAttachmentsFailed  errCode = "query_attachments_failed"
+	errInvalidPreviewTTL       errCode = "invalid_preview_ttl"
+	errPreviewNotFound         errCode = "preview_not_found"
+	errCreatePreviewFailed     errCode = "create_preview_failed"
+	errQueryPreviewsFailed     errCode = "query_previews_failed"
+	errInvalidVisibility       errCode = "invalid_visibility"
+	errPasswordRequired        errCode = "password_required"
+	errPasswordTooLong         errCode = "password_too_long"
+	errWrongPassword           errCode = "wrong_password"
+	errArticleLocked           errCode = "article_locked"
+	errEditLockLost            errCode = "edit_lock_lost"
+	errEditLockFailed          errCode = "edit_lock_failed"
+	errInvalidCursor           errCode = "invalid_cursor"
+	errQueryActivityFailed     errCode = "query_activity_failed"
+	errQueryCrawlStatsFailed   errCode = "query_crawl_stats_failed"
+	errQueryTrafficFailed      errCode = "query_traffic_failed"
+	errTitleTooLong            errCode = "title_too_long"
+	errTooManyTags             errCode = "too_many_tags"
+	errNameTooLong             errCode = "name_too_long"
+	errDescriptionTooLong      errCode = "description_too_long"
+	errUsernameTooLong         errCode = "username_too_long"
+	errInvalidPort             errCode = "invalid_port"
+	errInvalidImapCA           errCode = "invalid_imap_ca"
+	errInvalidImapTimeout      errCode = "invalid_imap_timeout"
+	errInvalidImapRetention    errCode = "invalid_imap_retention"
+	errInvalidIdempotencyKey   errCode = "invalid_idempotency_key"
+	errIdempotencyKeyReused    errCode = "idempotency_key_reused"
+	errIdempotencyInProgress   errCode = "idempotency_in_progress"
+	errIdempotencyFailed       errCode = "idempotency_failed"
+	errImportTooLarge          errCode = "import_too_large"
+	errDuplicateArticleFailed  errCode = "duplicate_article_failed"
+	errTemplateNotFound        errCode = "template_not_found"
+	errQueryTemplatesFailed    errCode = "query_templates_failed"
+	errSaveTemplateFailed      errCode = "save_template_failed"
+	errInvalidMeta             errCode = "invalid_meta"
+	errAuthorNotFound          errCode = "author_not_found"
+	errQueryAuthorsFailed      errCode = "query_authors_failed"
+	errSaveProfileFailed       errCode = "save_profile_failed"
+	errInvalidAvatarURL        errCode = "invalid_avatar_url"
+	errInvalidAuthors          errCode = "invalid_authors"
+	errNotArticleAuthor        errCode = "not_article_author"
+	errInvalidExportFormat     errCode = "invalid_export_format"
+	errPDFExportDisabled       errCode = "pdf_export_disabled"
+	errExportFailed            errCode = "export_failed"
+	errInvalidColor            errCode = "invalid_color"
+	errInvalidThemeAsset       errCode = "invalid_theme_asset"
+	errUnsupportedImage        errCode = "unsupported_image"
+	errThemeAssetsDisabled     errCode = "theme_assets_disabled"
+	errAdminAccessDenied       errCode = "admin_access_denied"
+	errChallengeRequired       errCode = "challenge_required"
+	errChallengeFailed         errCode = "challenge_failed"
+	errChallengeUnavailable    errCode = "challenge_unavailable"
+	errRateLimited             errCode = "rate_limited"
+	errInsufficientScope       errCode = "insufficient_scope"
+	errInvalidScope            errCode = "invalid_scope"
+	errTokenNotFound           errCode = "token_not_found"
+	errQueryTokensFailed       errCode = "query_tokens_failed"
+	errSaveTokenFailed         errCode = "save_token_failed"
+	errQueryUsersFailed        errCode = "query_users_failed"
+	errSaveUserFailed          errCode = "save_user_failed"
+	errUserNotFound            errCode = "user_not_found"
+	errUsernameTaken           errCode = "username_taken"
+	errNotServiceAccount       errCode = "not_service_account"
+	errQueryJobsFailed         errCode = "query_jobs_failed"
+	errJobNotFound             errCode = "job_not_found"
+	errJobNotRetryable         errCode = "job_not_retryable"
+	errSaveJobFailed           errCode = "save_job_failed"
+	errInvalidJobFilter        errCode = "invalid_job_filter"
+)
+
+const defaultLanguage = "zh"
+
+// errorCatalog maps language -> code -> message. Adding a language only needs
+// a new entry here; missing codes fall back to defaultLanguage.
+var errorCatalog = map[string]map[errCode]string{
+	"zh": {
+		errInvalidBody:             "请求体格式错误",
+		errNotFound:                "资源不存在",
+		errUnauthorized:            "未登录",
+		errSessionExpired:          "会话已过期",
+		errInvalidCredentials:      "用户名或密码错误",
+		errCredentialsRequired:     "用户名和密码不能为空",
+		errCreateSessionFailed:     "创建会话失败",
+		errBeginTxFailed:           "启动事务失败",
+		errCommitFailed:            "提交事务失败",
+		errTitleRequired:           "标题不能为空",
+		errInvalidStatus:           "status 只能是 draft 或 published",
+		errInvalidType:             "type 只能是 post、memo 或 note",
+		errInvalidSlug:             "slug 不合法",
+		errSlugTitleEmpty:          "标题为空，无法生成 slug",
+		errSlugGenerationFailed:    "无法根据标题生成 slug",
+		errSlugDedupeFailed:        "slug 去重失败",
+		errInvalidSlugMode:         "mode 仅支持 llm 或 pinyin",
+		errLLMNotConfigured:        "未配置 DeepSeek API 密钥",
+		errLLMFailed:               "调用 DeepSeek 失败",
+		errQueryArticlesFailed:     "查询文章失败",
+		errParseArticlesFailed:     "解析文章数据失败",
+		errCountArticlesFailed:     "统计文章数失败",
+		errCreateArticleFailed:     "创建文章失败",
+		errUpdateArticleFailed:     "更新文章失败",
+		errDeleteArticleFailed:     "删除文章失败",
+		errArticleNotFound:         "未找到文章",
+		errNameRequired:            "名称不能为空",
+		errQueryArchivesFailed:     "查询归档失败",
+		errParseArchivesFailed:     "解析归档数据失败",
+		errCreateArchiveFailed:     "创建归档失败",
+		errUpdateArchiveFailed:     "更新归档失败",
+		errDeleteArchiveFailed:     "删除归档失败",
+		errArchiveNotFound:         "未找到归档",
+		errDetachArticlesFailed:    "清理文章关联失败",
+		errQueryCategoriesFailed:   "查询分类失败",
+		errParseCategoriesFailed:   "解析分类数据失败",
+		errQueryTimelineFailed:     "查询归档时间线失败",
+		errSiteTitleRequired:       "站点标题不能为空",
+		errInvalidPostsPerPage:     "postsPerPage 需在 1-100 之间",
+		errInvalidTimezone:         "时区不合法",
+		errSocialLinkRequired:      "社交链接名称和地址不能为空",
+		errInvalidSocialLink:       "社交链接地址不合法",
+		errSnippetTooLong:          "自定义代码片段过长（上限 64KB）",
+		errSaveSettingsFailed:      "保存站点设置失败",
+		errReloadFailed:            "重新加载配置失败",
+		errQueryImapAccountsFailed: "查询 IMAP 账号失败",
+		errParseImapAccountsFailed: "解析 IMAP 账号失败",
+		errImapFieldsRequired:      "地址、用户名、密码不能为空",
+		errEncryptPasswordFailed:   "加密密码失败",
+		errSaveImapAccountFailed:   "保存 IMAP 账号失败",
+		errImapAccountLookupFailed: "查询 IMAP 账号失败",
+		errImapAccountNotFound:     "未找到 IMAP 账号，请先创建",
+		errImapThreadNotFound:      "未找到该会话",
+		errQueryImapRulesFailed:    "查询邮件规则失败",
+		errSaveImapRuleFailed:      "保存邮件规则失败",
+		errImapRuleNotFound:        "邮件规则不存在",
+		errImapRuleMatchRequired:   "至少需要一个匹配条件（发件人、主题或收件人）",
+		errInvalidImapRuleAction:   "未知的规则动作",
+		errInvalidImapRuleTarget:   "规则目标无效：移动需文件夹，转发需邮箱地址，webhook 需 http(s) 地址",
+		errQueryMailFeedsFailed:    "查询邮件订阅源失败",
+		errSaveMailFeedFailed:      "保存邮件订阅源失败",
+		errMailFeedNotFound:        "邮件订阅源不存在",
+		errInvalidImapFolder:       "邮件文件夹名无效",
+		errQueryReaderFailed:       "查询阅读列表失败",
+		errSaveReaderFeedFailed:    "保存订阅失败",
+		errReaderFeedNotFound:      "订阅不存在",
+		errReaderFeedExists:        "已订阅该地址",
+		errReaderItemNotFound:      "条目不存在",
+		errInvalidFeedURL:          "订阅地址需为 http(s) 地址",
+		errFetchFeedFailed:         "抓取订阅源失败",
+		errQueryBookmarksFailed:    "查询书签失败",
+		errSaveBookmarkFailed:      "保存书签失败",
+		errBookmarkNotFound:        "书签不存在",
+		errInvalidBookmarkURL:      "书签地址需为 http(s) 地址",
+		errNoteEmpty:               "短文内容不能为空",
+		errNoteTooLong:             "短文内容过长",
+		errGalleryDisabled:         "未配置媒体目录，无法上传图集",
+		errInvalidGalleryImage:     "图集只支持 JPEG、PNG、GIF、WebP 图片",
+		errGalleryFull:             "图集图片数量已达上限",
+		errGalleryImageNotFound:    "图集图片不存在",
+		errCaptionTooLong:          "图片说明过长",
+		errQueryGalleryFailed:      "查询图集失败",
+		errSaveGalleryFailed:       "保存图集失败",
+		errQueryStatsFailed:        "查询统计失败",
+		errInvalidImportArchive:    "无法识别的导出文件",
+		errInvalidImportSite:       "原站点地址必须是 http(s) URL",
+		errGitSyncDisabled:         "未启用 Git 同步",
+		errInvalidWebhookSignature: "Webhook 签名无效",
+		errBackupDisabled:          "未启用备份",
+		errBackupInProgress:        "已有备份正在进行",
+		errQueryBackupsFailed:      "查询备份记录失败",
+		errInvalidBackupKind:       "无效的备份类型",
+		errImapFetchFailed:         "即时拉取失败",
+		errImapSyncFailed:          "同步 IMAP 失败",
+		errImapClearCacheFailed:    "清理缓存失败",
+		errImapResetAccountFailed:  "重置账号状态失败",
+		errImapRebuildFailed:       "重建失败",
+		errReadMessagesFailed:      "读取邮件失败",
+		errLoadMessageFailed:       "加载邮件失败",
+		errInvalidUID:              "uid 非法",
+		errHealthUnavailable:       "无法读取系统指标",
+		errServerStarting:          "服务正在启动，数据库尚未就绪",
+		errDatabaseUnavailable:     "数据库不可用",
+		errInvalidPublishedAt:      "publishedAt 需为带时区偏移的 RFC3339 时间",
+		errReassignToSelf:          "不能把文章转移到正在删除的归档",
+		errReassignTargetNotFound:  "目标归档不存在",
+		errAIUnavailable:           "未配置可用的 LLM 服务",
+		errAISummaryFailed:         "生成摘要失败",
+		errAITagsFailed:            "生成标签建议失败",
+		errReindexInProgress:       "检索索引正在重建",
+		errInvalidRobotsRule:       "robots 规则无效",
+		errInvalidPublisher:        "发布者信息无效",
+		errInvalidLanguage:         "语言标签无效",
+		errTranslationNotFound:     "原文文章不存在",
+		errInvalidSocialCard:       "社交卡片设置无效",
+		errInvalidLink:             "链接无效",
+		errLinkNotFound:            "链接不存在",
+		errQueryLinksFailed:        "查询链接失败",
+		errSaveLinkFailed:          "保存链接失败",
+		errAttachmentsDisabled:     "未配置附件目录",
+		errAttachmentNotFound:      "附件不存在",
+		errFileTooLarge:            "文件超过上传大小限制",
+		errUploadFailed:            "上传失败",
+		errQueryAttachmentsFailed:  "查询附件失败",
+		errInvalidPreviewTTL:       "预览链接有效期无效（1 小时到 90 天）",
+		errPreviewNotFound:         "预览链接不存在",
+		errCreatePreviewFailed:     "创建预览链接失败",
+		errQueryPreviewsFailed:     "查询预览链接失败",
+		errInvalidVisibility:       "可见性无效（public、unlisted 或 password）",
+		errPasswordRequired:        "密码保护的文章需要设置密码",
+		errPasswordTooLong:         "密码不能超过 72 字节",
+		errWrongPassword:           "密码错误",
+		errArticleLocked:           "文章正在被其他用户编辑",
+		errEditLockLost:            "编辑锁已过期，请重新获取",
+		errEditLockFailed:          "处理编辑锁失败",
+		errInvalidCursor:           "分页游标无效",
+		errQueryActivityFailed:     "查询动态失败",
+		errQueryCrawlStatsFailed:   "查询爬虫抓取统计失败",
+		errQueryTrafficFailed:      "查询访问统计失败",
+		errTitleTooLong:            "标题不能超过 200 个字符",
+		errTooManyTags:             "标签不能超过 20 个",
+		errNameTooLong:             "名称不能超过 64 个字符",
+		errDescriptionTooLong:      "描述过长",
+		errUsernameTooLong:         "用户名不能超过 64 个字符",
+		errInvalidPort:             "端口必须在 1 到 65535 之间",
+		errInvalidImapCA:           "CA 证书不是有效的 PEM",
+		errInvalidImapTimeout:      "超时需在 0 到 600 秒之间",
+		errInvalidImapRetention:    "保留数量需在 0 到 1000000 之间，保留天数需在 0 到 3650 之间",
+		errInvalidIdempotencyKey:   "Idempotency-Key 不能超过 255 个字符",
+		errIdempotencyKeyReused:    "Idempotency-Key 已用于另一个请求",
+		errIdempotencyInProgress:   "相同 Idempotency-Key 的请求正在处理，请稍后重试",
+		errIdempotencyFailed:       "处理 Idempotency-Key 失败",
+		errImportTooLarge:          "一次最多导入 1000 篇文章",
+		errDuplicateArticleFailed:  "复制文章失败",
+		errTemplateNotFound:        "模板不存在",
+		errQueryTemplatesFailed:    "查询模板失败",
+		errSaveTemplateFailed:      "保存模板失败",
+		errInvalidMeta:             "自定义字段无效",
+		errAuthorNotFound:          "作者不存在",
+		errQueryAuthorsFailed:      "查询作者失败",
+		errSaveProfileFailed:       "保存个人资料失败",
+		errInvalidAvatarURL:        "头像地址无效",
+		errInvalidAuthors:          "作者列表无效",
+		errNotArticleAuthor:        "只有文章作者可以修改",
+		errInvalidExportFormat:     "导出格式无效，可选 html、md、pdf",
+		errPDFExportDisabled:       "未配置 PDF 导出",
+		errExportFailed:            "导出文章失败",
+		errInvalidColor:            "颜色格式无效，应为 #rrggbb",
+		errInvalidThemeAsset:       "未知的主题资源",
+		errUnsupportedImage:        "不支持的图片格式",
+		errThemeAssetsDisabled:     "未配置媒体目录，无法上传主题资源",
+		errAdminAccessDenied:       "当前网络或客户端证书不允许访问管理功能",
+		errChallengeRequired:       "请先完成人机验证",
+		errChallengeFailed:         "人机验证未通过",
+		errChallengeUnavailable:    "人机验证服务暂不可用",
+		errRateLimited:             "请求过于频繁，请稍后再试",
+		errInsufficientScope:       "令牌权限不足",
+		errInvalidScope:            "未知的令牌权限",
+		errTokenNotFound:           "令牌不存在",
+		errQueryTokensFailed:       "查询令牌失败",
+		errSaveTokenFailed:         "保存令牌失败",
+		errQueryUsersFailed:        "查询用户失败",
+		errSaveUserFailed:          "保存用户失败",
+		errUserNotFound:            "用户不存在",
+		errUsernameTaken:           "用户名已被占用",
+		errNotServiceAccount:       "只能对服务账号执行此操作",
+		errQueryJobsFailed:         "查询任务失败",
+		errJobNotFound:             "任务不存在",
+		errJobNotRetryable:         "任务正在运行或已完成，不能重试",
+		errSaveJobFailed:           "保存任务失败",
+		errInvalidJobFilter:        "无效的任务筛选条件",
+	},
+	"en": {
+		errInvalidBody:             "invalid request body",
+		errNotFound:                "not found",
+		errUnauthorized:            "not logged in",
+		errSessionExpired:          "session expired",
+		errInvalidCredentials:      "invalid username or password",
+		errCredentialsRequired:     "username and password are required",
+		errCreateSessionFailed:     "failed to create session",
+		errBeginTxFailed:           "failed to start transaction",
+		errCommitFailed:            "failed to commit transaction",
+		errTitleRequired:           "title is required",
+		errInvalidStatus:           "status must be draft or published",
+		errInvalidType:             "type must be post, memo or note",
+		errInvalidSlug:             "invalid slug",
+		errSlugTitleEmpty:          "title is empty, cannot generate slug",
+		errSlugGenerationFailed:    "unable to generate slug from title",
+		errSlugDedupeFailed:        "failed to deduplicate slug",
+		errInvalidSlugMode:         "mode must be llm or pinyin",
+		errLLMNotConfigured:        "DeepSeek API key is not configured",
+		errLLMFailed:               "DeepSeek request failed",
+		errQueryArticlesFailed:     "failed to query articles",
+		errParseArticlesFailed:     "failed to read article data",
+		errCountArticlesFailed:     "failed to count articles",
+		errCreateArticleFailed:     "failed to create article",
+		errUpdateArticleFailed:     "failed to update article",
+		errDeleteArticleFailed:     "failed to delete article",
+		errArticleNotFound:         "article not found",
+		errNameRequired:            "name is required",
+		errQueryArchivesFailed:     "failed to query archives",
+		errParseArchivesFailed:     "failed to read archive data",
+		errCreateArchiveFailed:     "failed to create archive",
+		errUpdateArchiveFailed:     "failed to update archive",
+		errDeleteArchiveFailed:     "failed to delete archive",
+		errArchiveNotFound:         "archive not found",
+		errDetachArticlesFailed:    "failed to detach articles from archive",
+		errQueryCategoriesFailed:   "failed to query categories",
+		errParseCategoriesFailed:   "failed to read category data",
+		errQueryTimelineFailed:     "failed to query archive timeline",
+		errSiteTitleRequired:       "site title is required",
+		errInvalidPostsPerPage:     "postsPerPage must be between 1 and 100",
+		errInvalidTimezone:         "invalid timezone",
+		errSocialLinkRequired:      "social link name and url are required",
+		errInvalidSocialLink:       "invalid social link url",
+		errSnippetTooLong:          "custom snippet is too long (max 64KB)",
+		errSaveSettingsFailed:      "failed to save site settings",
+		errReloadFailed:            "failed to reload configuration",
+		errQueryImapAccountsFailed: "failed to query IMAP accounts",
+		errParseImapAccountsFailed: "failed to read IMAP account data",
+		errImapFieldsRequired:      "host, username and password are required",
+		errEncryptPasswordFailed:   "failed to encrypt password",
+		errSaveImapAccountFailed:   "failed to save IMAP account",
+		errImapAccountLookupFailed: "failed to look up IMAP account",
+		errImapAccountNotFound:     "no IMAP account found, create one first",
+		errImapThreadNotFound:      "conversation not found",
+		errQueryImapRulesFailed:    "failed to query mail rules",
+		errSaveImapRuleFailed:      "failed to save mail rule",
+		errImapRuleNotFound:        "mail rule not found",
+		errImapRuleMatchRequired:   "at least one condition (from, subject or to) is required",
+		errInvalidImapRuleAction:   "unknown rule action",
+		errInvalidImapRuleTarget:   "invalid rule target: move needs a folder, forward an email address, webhook an http(s) URL",
+		errQueryMailFeedsFailed:    "failed to query mail feeds",
+		errSaveMailFeedFailed:      "failed to save mail feed",
+		errMailFeedNotFound:        "mail feed not found",
+		errInvalidImapFolder:       "invalid mail folder name",
+		errQueryReaderFailed:       "failed to query the reading list",
+		errSaveReaderFeedFailed:    "failed to save subscription",
+		errReaderFeedNotFound:      "subscription not found",
+		errReaderFeedExists:        "already subscribed to this feed",
+		errReaderItemNotFound:      "reading list item not found",
+		errInvalidFeedURL:          "feed URL must be an http(s) URL",
+		errFetchFeedFailed:         "failed to fetch feed",
+		errQueryBookmarksFailed:    "failed to query bookmarks",
+		errSaveBookmarkFailed:      "failed to save bookmark",
+		errBookmarkNotFound:        "bookmark not found",
+		errInvalidBookmarkURL:      "bookmark URL must be an http(s) URL",
+		errNoteEmpty:               "note body is required",
+		errNoteTooLong:             "note is too long",
+		errGalleryDisabled:         "media directory is not configured; galleries are unavailable",
+		errInvalidGalleryImage:     "gallery images must be JPEG, PNG, GIF or WebP",
+		errGalleryFull:             "the gallery has too many images",
+		errGalleryImageNotFound:    "gallery image not found",
+		errCaptionTooLong:          "caption is too long",
+		errQueryGalleryFailed:      "failed to query gallery",
+		errSaveGalleryFailed:       "failed to save gallery",
+		errQueryStatsFailed:        "failed to query statistics",
+		errInvalidImportArchive:    "unrecognized export file",
+		errInvalidImportSite:       "site must be an http(s) URL",
+		errGitSyncDisabled:         "git sync is not enabled",
+		errInvalidWebhookSignature: "invalid webhook signature",
+		errBackupDisabled:          "backups are not enabled",
+		errBackupInProgress:        "a backup is already running",
+		errQueryBackupsFailed:      "failed to query backups",
+		errInvalidBackupKind:       "invalid backup kind",
+		errImapFetchFailed:         "live fetch failed",
+		errImapSyncFailed:          "IMAP sync failed",
+		errImapClearCacheFailed:    "failed to clear cache",
+		errImapResetAccountFailed:  "failed to reset account state",
+		errImapRebuildFailed:       "rebuild failed",
+		errReadMessagesFailed:      "failed to read messages",
+		errLoadMessageFailed:       "failed to load message",
+		errInvalidUID:              "invalid uid",
+		errHealthUnavailable:       "unable to read system metrics",
+		errServerStarting:          "the server is starting and the database is not ready yet",
+		errDatabaseUnavailable:     "the database is unavailable",
+		errInvalidPublishedAt:      "publishedAt must be an RFC3339 timestamp with offset",
+		errReassignToSelf:          "cannot reassign articles to the archive being deleted",
+		errReassignTargetNotFound:  "target archive not found",
+		errAIUnavailable:           "no LLM provider is configured",
+		errAISummaryFailed:         "failed to generate summary",
+		errAITagsFailed:            "failed to suggest tags",
+		errReindexInProgress:       "a search reindex is already running",
+		errInvalidRobotsRule:       "invalid robots rule",
+		errInvalidPublisher:        "invalid publisher",
+		errInvalidLanguage:         "invalid language tag",
+		errTranslationNotFound:     "original article not found",
+		errInvalidSocialCard:       "invalid social card",
+		errInvalidLink:             "invalid link",
+		errLinkNotFound:            "link not found",
+		errQueryLinksFailed:        "failed to query links",
+		errSaveLinkFailed:          "failed to save link",
+		errAttachmentsDisabled:     "attachments directory is not configured",
+		errAttachmentNotFound:      "attachment not found",
+		errFileTooLarge:            "file exceeds the upload size limit",
+		errUploadFailed:            "upload failed",
+		errQueryAttachmentsFailed:  "failed to query attachments",
+		errInvalidPreviewTTL:       "preview ttl must be between 1 hour and 90 days",
+		errPreviewNotFound:         "preview link not found",
+		errCreatePreviewFailed:     "failed to create preview link",
+		errQueryPreviewsFailed:     "failed to query preview links",
+		errInvalidVisibility:       "visibility must be public, unlisted or password",
+		errPasswordRequired:        "password-protected articles need a password",
+		errPasswordTooLong:         "password must be at most 72 bytes",
+		errWrongPassword:           "wrong password",
+		errArticleLocked:           "article is being edited by another user",
+		errEditLockLost:            "edit lock expired, acquire it again",
+		errEditLockFailed:          "failed to process edit lock",
+		errInvalidCursor:           "invalid pagination cursor",
+		errQueryActivityFailed:     "failed to query activity",
+		errQueryCrawlStatsFailed:   "failed to query crawler stats",
+		errQueryTrafficFailed:      "failed to query traffic",
+		errTitleTooLong:            "title must be at most 200 characters",
+		errTooManyTags:             "at most 20 tags are allowed",
+		errNameTooLong:             "name must be at most 64 characters",
+		errDescriptionTooLong:      "description is too long",
+		errUsernameTooLong:         "username must be at most 64 characters",
+		errInvalidPort:             "port must be between 1 and 65535",
+		errInvalidImapCA:           "CA certificate is not valid PEM",
+		errInvalidImapTimeout:      "timeout must be between 0 and 600 seconds",
+		errInvalidImapRetention:    "retention must be 0 to 1000000 messages and 0 to 3650 days",
+		errInvalidIdempotencyKey:   "Idempotency-Key must be at most 255 characters",
+		errIdempotencyKeyReused:    "Idempotency-Key was already used for a different request",
+		errIdempotencyInProgress:   "a request with this Idempotency-Key is still in progress, retry later",
+		errIdempotencyFailed:       "failed to process Idempotency-Key",
+		errImportTooLarge:          "at most 1000 articles can be imported at once",
+		errDuplicateArticleFailed:  "failed to duplicate article",
+		errTemplateNotFound:        "template not found",
+		errQueryTemplatesFailed:    "failed to query templates",
+		errSaveTemplateFailed:      "failed to save template",
+		errInvalidMeta:             "invalid custom fields",
+		errAuthorNotFound:          "author not found",
+		errQueryAuthorsFailed:      "failed to query authors",
+		errSaveProfileFailed:       "failed to save profile",
+		errInvalidAvatarURL:        "invalid avatar url",
+		errInvalidAuthors:          "invalid author list",
+		errNotArticleAuthor:        "only the article's authors can change it",
+		errInvalidExportFormat:     "invalid export format, use html, md or pdf",
+		errPDFExportDisabled:       "PDF export is not configured",
+		errExportFailed:            "failed to export article",
+		errInvalidColor:            "invalid color, expected #rrggbb",
+		errInvalidThemeAsset:       "unknown theme asset",
+		errUnsupportedImage:        "unsupported image format",
+		errThemeAssetsDisabled:     "theme uploads need a media directory",
+		errAdminAccessDenied:       "admin access is not allowed from this network or without a client certificate",
+		errChallengeRequired:       "complete the challenge first",
+		errChallengeFailed:         "the challenge was not passed",
+		errChallengeUnavailable:    "the challenge service is unavailable",
+		errRateLimited:             "too many requests, please try again later",
+		errInsufficientScope:       "the API token lacks the required scope",
+		errInvalidScope:            "unknown token scope",
+		errTokenNotFound:           "token not found",
+		errQueryTokensFailed:       "failed to query tokens",
+		errSaveTokenFailed:         "failed to save token",
+		errQueryUsersFailed:        "failed to query users",
+		errSaveUserFailed:          "failed to save user",
+		errUserNotFound:            "user not found",
+		errUsernameTaken:           "username is already taken",
+		errNotServiceAccount:       "only service accounts can be managed here",
+		errQueryJobsFailed:         "failed to query jobs",
+		errJobNotFound:             "job not found",
+		errJobNotRetryable:         "job is running or done and cannot be retried",
+		errSaveJobFailed:           "failed to save job",
+		errInvalidJobFilter:        "invalid job filter",
+	},
+}
+
+// apiError carries an errCode through helper functions so handlers can
+// localize it at the edge; detail is appended verbatim (e.g. a driver error).
+type apiError struct {
+	code   errCode
+	detail string
+}
+
+func newAPIError(code errCode, detail ...string) error {
+	return &apiError{code: code, detail: strings.Join(detail, " ")}
+}
+
+func (e *apiError) Error() string {
+	return localizeError(defaultLanguage, e.code, e.detail)
+}
+
+func localizeError(lang string, code errCode, detail string) string {
+	msg, ok := errorCatalog[lang][code]
+	if !ok {
+		msg, ok = errorCatalog[defaultLanguage][code]
+	}
+	if !ok {
+		msg = string(code)
+	}
+	if detail != "" {
+		msg += ": " + detail
+	}
+	return msg
+}
+
+// pickLanguage returns the best catalog language for an Accept-Language header.
+func pickLanguage(header string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := errorCatalog[primary]; ok && q > 0 {
+			candidates = append(candidates, candidate{lang: primary, q: q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	if len(candidates) > 0 {
+		return candidates[0].lang
+	}
+	return defaultLanguage
+}
+
+func requestLanguage(c *gin.Context) string {
+	return pickLanguage(c.GetHeader("Accept-Language"))
+}
+
+func respondError(c *gin.Context, status int, code errCode) {
+	c.JSON(status, gin.H{"error": localizeError(requestLanguage(c), code, ""), "code": code})
+}
+
+// respondErrorDetail reports err under code, unless err already carries its own
+// errCode (validation helpers), in which case that code wins; validationErrors
+// are written with their field list.
+func respondErrorDetail(c *gin.Context, status int, code errCode, err error) {
+	var ve validationErrors
+	if errors.As(err, &ve) {
+		respondValidation(c, status, ve)
+		return
+	}
+	code, msg := describeError(requestLanguage(c), code, err)
+	c.JSON(status, gin.H{"error": msg, "code": code})
+}
+
+// describeError is the code and localized message respondErrorDetail would
+// send for err, for results embedded in a larger response.
+func describeError(lang string, code errCode, err error) (errCode, string) {
+	var ve validationErrors
+	if errors.As(err, &ve) {
+		parts := make([]string, len(ve))
+		for i, fe := range ve {
+			parts[i] = fe.Field + ": " + localizeError(lang, fe.Code, fe.Detail)
+		}
+		return ve[0].Code, strings.Join(parts, "; ")
+	}
+	detail := ""
+	var ae *apiError
+	if errors.As(err, &ae) {
+		code = ae.code
+		detail = ae.detail
+	} else if err != nil {
+		detail = err.Error()
+	}
+	return code, localizeError(lang, code, detail)
+}
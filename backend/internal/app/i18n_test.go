@@ -0,0 +1,29 @@
+package app
+
+import "testing"
+
+func TestPickLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                                "zh",
+		"en-US,en;q=0.9":                  "en",
+		"fr-FR,fr;q=0.9":                  "zh",
+		"fr;q=0.9, en;q=0.5, zh-CN;q=0.8": "zh",
+		"zh;q=0, en;q=0.1":                "en",
+	}
+	for header, want := range cases {
+		if got := pickLanguage(header); got != want {
+			t.Errorf("pickLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestErrorCatalogComplete(t *testing.T) {
+	base := errorCatalog[defaultLanguage]
+	for lang, messages := range errorCatalog {
+		for code := range base {
+			if messages[code] == "" {
+				t.Errorf("language %s is missing message for %s", lang, code)
+			}
+		}
+	}
+}
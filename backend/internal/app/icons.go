@@ -0,0 +1,232 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const iconsSubdir = themeSubdir + "/icons"
+
+// siteIcons are the PNG icons generated from the logo next to favicon.ico,
+// served at the site root where browsers and home screens look for them.
+var siteIcons = []struct {
+	name   string
+	size   int
+	opaque bool
+}{
+	// iOS fills transparent areas with black
+	{"apple-touch-icon.png", 180, true},
+	{"icon-192.png", 192, false},
+	{"icon-512.png", 512, false},
+}
+
+// faviconSizes are the PNG images packed into favicon.ico.
+var faviconSizes = []int{16, 32, 48}
+
+// refreshIcons regenerates the icon set after the logo or favicon changed
+// and records its version on t. A failure only leaves the site without
+// generated icons.
+func (s *server) refreshIcons(t *themeSettings) {
+	version, err := s.generateIcons(*t)
+	if err != nil {
+		warnf("生成站点图标失败: %v\n", err)
+		version = ""
+	}
+	t.Icons = version
+}
+
+// generateIcons draws favicon.ico and the siteIcons from the uploaded favicon,
+// or the logo when there is none, into the media directory. It returns ""
+// without an error when neither can be decoded (e.g. an .ico upload); the
+// uploaded favicon is then linked as is.
+func (s *server) generateIcons(t themeSettings) (string, error) {
+	src, from := s.decodeThemeAsset(t.Favicon), t.Favicon
+	if src == nil {
+		src, from = s.decodeThemeAsset(t.Logo), t.Logo
+	}
+	if src == nil {
+		return "", nil
+	}
+	dir := filepath.Join(s.mediaDir, filepath.FromSlash(iconsSubdir))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	var pngs [][]byte
+	for _, size := range faviconSizes {
+		raw, err := encodePNG(iconImage(src, size, nil))
+		if err != nil {
+			return "", err
+		}
+		pngs = append(pngs, raw)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, "favicon.ico"), encodeICO(faviconSizes, pngs)); err != nil {
+		return "", err
+	}
+	for _, icon := range siteIcons {
+		var bg *color.RGBA
+		if icon.opaque {
+			white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+			bg = &white
+		}
+		raw, err := encodePNG(iconImage(src, icon.size, bg))
+		if err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, icon.name), raw); err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256([]byte(from))
+	return hex.EncodeToString(sum[:4]), nil
+}
+
+// iconImage fits src into a size×size square, centred, over bg when set.
+func iconImage(src image.Image, size int, bg *color.RGBA) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, size, size))
+	if bg != nil {
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(*bg), image.Point{}, draw.Src)
+	}
+	b := src.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*size/b.Dx())
+	} else {
+		w = max(1, b.Dx()*size/b.Dy())
+	}
+	at := image.Pt((size-w)/2, (size-h)/2)
+	draw.Draw(canvas, image.Rect(at.X, at.Y, at.X+w, at.Y+h), resize(src, w, h), image.Point{}, draw.Over)
+	return canvas
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeICO packs PNG images into an .ico container, which every current
+// browser reads.
+func encodeICO(sizes []int, pngs [][]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(pngs))})
+	offset := 6 + 16*len(pngs)
+	for i, raw := range pngs {
+		// a 0 dimension means 256 pixels
+		dim := uint8(sizes[i] % 256)
+		binary.Write(&buf, binary.LittleEndian, struct {
+			W, H, Colors, Reserved uint8
+			Planes, BitCount       uint16
+			Size, Offset           uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(raw)), uint32(offset)})
+		offset += len(raw)
+	}
+	for _, raw := range pngs {
+		buf.Write(raw)
+	}
+	return buf.Bytes()
+}
+
+// iconsHead links the generated icons; v busts browser caches when the logo
+// changes.
+func (s *server) iconsHead(t themeSettings) string {
+	if t.Icons == "" {
+		if t.Favicon == "" {
+			return ""
+		}
+		return `<link rel="icon" href="` + html.EscapeString(t.Favicon) + `">`
+	}
+	v := "?v=" + t.Icons
+	return `<link rel="icon" href="` + s.basePath + `/favicon.ico` + v + `" sizes="48x48">` +
+		`<link rel="icon" type="image/png" href="` + s.basePath + `/icon-192.png` + v + `" sizes="192x192">` +
+		`<link rel="apple-touch-icon" href="` + s.basePath + `/apple-touch-icon.png` + v + `">`
+}
+
+// iconHandler serves one generated icon at the site root. Until a logo has
+// been uploaded the request goes to next, which serves the frontend build's
+// own icon.
+func (s *server) iconHandler(name, contentType string, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		full := filepath.Join(s.mediaDir, filepath.FromSlash(iconsSubdir), name)
+		if s.mediaDir == "" || s.siteSettings().Theme.Icons == "" {
+			next(c)
+			return
+		}
+		if _, err := os.Stat(full); err != nil {
+			next(c)
+			return
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Cache-Control", "public, max-age=86400")
+		http.ServeFile(c.Writer, c.Request, full)
+	}
+}
+
+// manifestIcons lists the generated icons for the web manifest.
+func (s *server) manifestIcons(t themeSettings) []gin.H {
+	if t.Icons == "" {
+		return []gin.H{}
+	}
+	var icons []gin.H
+	for _, icon := range siteIcons[1:] {
+		icons = append(icons, gin.H{
+			"src":   fmt.Sprintf("%s/%s?v=%s", s.basePath, icon.name, t.Icons),
+			"sizes": fmt.Sprintf("%dx%d", icon.size, icon.size),
+			"type":  "image/png",
+		})
+	}
+	return icons
+}
+
+// manifestShortNameRunes is roughly what a home screen label shows before
+// it is cut off.
+const manifestShortNameRunes = 12
+
+// webManifest is the web app manifest built from the site settings.
+func (s *server) webManifest(site siteSettings) gin.H {
+	shortName := site.Title
+	if r := []rune(shortName); len(r) > manifestShortNameRunes {
+		shortName = strings.TrimSpace(string(r[:manifestShortNameRunes]))
+	}
+	background := "#ffffff"
+	if site.Theme.OGScheme == "dark" {
+		background = darkBackground
+	}
+	m := gin.H{
+		"name":             site.Title,
+		"short_name":       shortName,
+		"lang":             site.Language,
+		"start_url":        s.basePath + "/",
+		"scope":            s.basePath + "/",
+		"display":          "standalone",
+		"theme_color":      site.Theme.accent("light"),
+		"background_color": background,
+		"icons":            s.manifestIcons(site.Theme),
+	}
+	if site.Description != "" {
+		m["description"] = site.Description
+	}
+	return m
+}
+
+// manifestHandler serves /manifest.webmanifest.
+func (s *server) manifestHandler(c *gin.Context) {
+	c.Header("Content-Type", "application/manifest+json")
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.webManifest(s.siteSettings()))
+}
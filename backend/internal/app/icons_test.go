@@ -0,0 +1,83 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateIcons(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, themeSubdir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	logo := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for i := range logo.Pix {
+		logo.Pix[i] = 0x80
+	}
+	raw, _ := encodePNG(logo)
+	if err := os.WriteFile(filepath.Join(dir, themeSubdir, "logo-1.png"), raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{mediaDir: dir}
+	version, err := s.generateIcons(themeSettings{Logo: "/media/theme/logo-1.png"})
+	if err != nil || version == "" {
+		t.Fatalf("generate = %q, %v", version, err)
+	}
+	for _, icon := range siteIcons {
+		f, err := os.Open(filepath.Join(dir, iconsSubdir, icon.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := png.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != icon.size || cfg.Height != icon.size {
+			t.Errorf("%s: %+v %v", icon.name, cfg, err)
+		}
+	}
+	ico, err := os.ReadFile(filepath.Join(dir, iconsSubdir, "favicon.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header [3]uint16
+	binary.Read(bytes.NewReader(ico), binary.LittleEndian, &header)
+	if header != [3]uint16{0, 1, uint16(len(faviconSizes))} || ico[6] != 16 {
+		t.Fatalf("ico header = %v, first size %d", header, ico[6])
+	}
+
+	if v, err := s.generateIcons(themeSettings{Favicon: "/media/theme/favicon-1.ico"}); v != "" || err != nil {
+		t.Fatalf("undecodable favicon = %q, %v", v, err)
+	}
+}
+
+func TestIconImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	img := iconImage(src, 16, nil)
+	if img.Bounds().Dx() != 16 || img.RGBAAt(8, 0).A != 0 || img.RGBAAt(8, 8).A != 0xff {
+		t.Fatalf("wide logo should be letterboxed: top %v centre %v", img.RGBAAt(8, 0), img.RGBAAt(8, 8))
+	}
+}
+
+func TestWebManifest(t *testing.T) {
+	s := &server{basePath: "/blog"}
+	m := s.webManifest(siteSettings{Title: "A rather long site title", Language: "en", Theme: themeSettings{AccentColor: "#112233", Icons: "abcd"}})
+	if m["short_name"] != "A rather lon" || m["start_url"] != "/blog/" || m["scope"] != "/blog/" || m["theme_color"] != "#112233" || m["display"] != "standalone" {
+		t.Fatalf("manifest = %v", m)
+	}
+	if _, ok := m["description"]; ok {
+		t.Error("empty description should be omitted")
+	}
+	icons := m["icons"].([]gin.H)
+	if len(icons) != 2 || icons[1]["src"] != "/blog/icon-512.png?v=abcd" || icons[1]["sizes"] != "512x512" {
+		t.Fatalf("icons = %v", icons)
+	}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyRetention is how long a key replays its first response;
+	// after that it may be reused for a new request.
+	idempotencyRetention = `interval '24 hours'`
+	maxIdempotencyKey    = 255
+)
+
+func (s *server) ensureIdempotencySchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			request_hash TEXT NOT NULL,
+			status INT NOT NULL DEFAULT 0,
+			content_type TEXT NOT NULL DEFAULT '',
+			body BYTEA,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (user_id, key)
+		);
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
+		DELETE FROM idempotency_keys WHERE created_at < now() - `+idempotencyRetention+`;
+	`)
+	return err
+}
+
+// requestFingerprint identifies what a key was first used for, so reusing it
+// for a different request is refused instead of replaying the wrong result.
+// Uploads are fingerprinted by size rather than buffered.
+func requestFingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.Path)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		fmt.Fprintf(h, "multipart %d", r.ContentLength)
+	} else if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// idempotencyMiddleware lets clients retry POSTs safely: the first request
+// with a given Idempotency-Key runs and its response is stored, later ones
+// with the same key get that response back. Keys are scoped per user, so it
+// must run after requireAuthMiddleware. Server errors are not stored, so a
+// retry after a 5xx runs again.
+func (s *server) idempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(idempotencyHeader))
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		v, _ := c.Get(string(userContextKey))
+		u, ok := v.(user)
+		if !ok {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			respondError(c, http.StatusBadRequest, errInvalidIdempotencyKey)
+			c.Abort()
+			return
+		}
+		hash, err := requestFingerprint(c.Request)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errInvalidBody)
+			c.Abort()
+			return
+		}
+		ctx := c.Request.Context()
+
+		// claim the key, taking over an expired entry
+		res, err := s.db.ExecContext(ctx, `
+			INSERT INTO idempotency_keys (user_id, key, request_hash) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, key) DO UPDATE
+			SET request_hash=EXCLUDED.request_hash, status=0, content_type='', body=NULL, created_at=now()
+			WHERE idempotency_keys.created_at < now() - `+idempotencyRetention,
+			u.ID, key, hash)
+		if err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errIdempotencyFailed, err)
+			c.Abort()
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			s.replayIdempotent(c, u.ID, key, hash)
+			c.Abort()
+			return
+		}
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		// the request is over; record the outcome even if the client left
+		saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if status := w.Status(); status >= http.StatusInternalServerError {
+			_, err = s.db.ExecContext(saveCtx, `DELETE FROM idempotency_keys WHERE user_id=$1 AND key=$2`, u.ID, key)
+		} else {
+			_, err = s.db.ExecContext(saveCtx, `
+				UPDATE idempotency_keys SET status=$3, content_type=$4, body=$5
+				WHERE user_id=$1 AND key=$2`,
+				u.ID, key, status, w.Header().Get("Content-Type"), w.buf.Bytes())
+		}
+		if err != nil {
+			warnf("保存幂等键 %s 失败: %v\n", key, err)
+		}
+	}
+}
+
+// replayIdempotent answers a request whose key is already taken.
+func (s *server) replayIdempotent(c *gin.Context, userID, key, hash string) {
+	var storedHash, contentType string
+	var status int
+	var body []byte
+	err := s.db.QueryRowContext(c.Request.Context(), `
+		SELECT request_hash, status, content_type, body FROM idempotency_keys WHERE user_id=$1 AND key=$2`,
+		userID, key).Scan(&storedHash, &status, &contentType, &body)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the first attempt failed and released the key in between
+		respondError(c, http.StatusConflict, errIdempotencyInProgress)
+	case err != nil:
+		respondErrorDetail(c, http.StatusInternalServerError, errIdempotencyFailed, err)
+	case storedHash != hash:
+		respondError(c, http.StatusUnprocessableEntity, errIdempotencyKeyReused)
+	case status == 0:
+		respondError(c, http.StatusConflict, errIdempotencyInProgress)
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		if len(body) == 0 {
+			c.Status(status)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		c.Data(status, contentType, body)
+	}
+}
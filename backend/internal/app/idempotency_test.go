@@ -0,0 +1,34 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	req := func(path, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+	r := req("/api/articles", `{"title":"a"}`)
+	a, err := requestFingerprint(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(r.Body); string(rest) != `{"title":"a"}` {
+		t.Fatalf("body not restored for the handler: %q", rest)
+	}
+	if b, _ := requestFingerprint(req("/api/articles", `{"title":"a"}`)); b != a {
+		t.Fatal("identical requests should match")
+	}
+	if b, _ := requestFingerprint(req("/api/articles", `{"title":"b"}`)); b == a {
+		t.Fatal("different bodies should not match")
+	}
+	if b, _ := requestFingerprint(req("/api/archives", `{"title":"a"}`)); b == a {
+		t.Fatal("different paths should not match")
+	}
+}
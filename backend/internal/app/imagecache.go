@@ -0,0 +1,269 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageCacheConfig turns on proxying of third-party images in posts through
+// /media/cache/<hash>, so posts keep working when the source disappears.
+type imageCacheConfig struct {
+	Enabled      bool  `yaml:"enabled"`
+	MaxBytes     int64 `yaml:"maxBytes"`
+	RefreshHours int   `yaml:"refreshHours"`
+}
+
+const (
+	defaultImageMaxBytes = 5 << 20
+	defaultImageRefresh  = 7 * 24 * time.Hour
+	imageCacheSubdir     = "cache"
+)
+
+// cachedImageCSP keeps a cached SVG from running script on our origin.
+const cachedImageCSP = "default-src 'none'; img-src data:; style-src 'unsafe-inline'; sandbox"
+
+var imageHashRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// imageCache stores each proxied image as <dir>/<hash> next to a <hash>.json
+// sidecar holding the source URL. The sidecar is written when a post is
+// rendered, so only URLs that actually appear in posts can be fetched.
+type imageCache struct {
+	dir      string
+	maxBytes int64
+	refresh  time.Duration
+	client   *http.Client
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+type cachedImage struct {
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	FetchedAt   time.Time `json:"fetchedAt,omitempty"`
+}
+
+// newImageCache returns nil when the cache is disabled or mediaDir is
+// unusable; callers treat a nil cache as "leave images alone".
+func newImageCache(mediaDir string, cfg imageCacheConfig, client *http.Client) *imageCache {
+	if !cfg.Enabled || mediaDir == "" {
+		return nil
+	}
+	if info, err := os.Stat(mediaDir); err != nil || !info.IsDir() {
+		warnf("媒体目录不可用，外链图片缓存未启用: %s\n", mediaDir)
+		return nil
+	}
+	dir := filepath.Join(mediaDir, imageCacheSubdir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		warnf("创建外链图片缓存目录失败: %v\n", err)
+		return nil
+	}
+	ic := &imageCache{
+		dir:      dir,
+		maxBytes: cfg.MaxBytes,
+		refresh:  time.Duration(cfg.RefreshHours) * time.Hour,
+		client:   client,
+		locks:    make(map[string]*sync.Mutex),
+	}
+	if ic.maxBytes <= 0 {
+		ic.maxBytes = defaultImageMaxBytes
+	}
+	if ic.refresh <= 0 {
+		ic.refresh = defaultImageRefresh
+	}
+	return ic
+}
+
+func imageHash(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (ic *imageCache) lock(hash string) *sync.Mutex {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	l, ok := ic.locks[hash]
+	if !ok {
+		l = &sync.Mutex{}
+		ic.locks[hash] = l
+	}
+	return l
+}
+
+func (ic *imageCache) metaPath(hash string) string {
+	return filepath.Join(ic.dir, hash+".json")
+}
+
+func (ic *imageCache) readMeta(hash string) (cachedImage, error) {
+	var m cachedImage
+	raw, err := os.ReadFile(ic.metaPath(hash))
+	if err != nil {
+		return m, err
+	}
+	return m, json.Unmarshal(raw, &m)
+}
+
+func (ic *imageCache) writeMeta(hash string, m cachedImage) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ic.metaPath(hash), raw)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// register records src and returns its cache hash; fetching is deferred to
+// the first request.
+func (ic *imageCache) register(src string) (string, error) {
+	hash := imageHash(src)
+	if _, err := os.Stat(ic.metaPath(hash)); err == nil {
+		return hash, nil
+	}
+	l := ic.lock(hash)
+	l.Lock()
+	defer l.Unlock()
+	if _, err := os.Stat(ic.metaPath(hash)); err == nil {
+		return hash, nil
+	}
+	return hash, ic.writeMeta(hash, cachedImage{URL: src})
+}
+
+var errImageTooLarge = errors.New("图片超过大小限制")
+
+// fetch downloads m.URL into the cache and updates m on success.
+func (ic *imageCache) fetch(ctx context.Context, hash string, m *cachedImage) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := ic.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("上游返回 %s", resp.Status)
+	}
+	ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(ctype, "image/") {
+		return fmt.Errorf("不是图片: %q", ctype)
+	}
+	if resp.ContentLength > ic.maxBytes {
+		return errImageTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, ic.maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > ic.maxBytes {
+		return errImageTooLarge
+	}
+	if err := writeFileAtomic(filepath.Join(ic.dir, hash), data); err != nil {
+		return err
+	}
+	m.ContentType = ctype
+	m.Size = int64(len(data))
+	m.FetchedAt = time.Now()
+	return ic.writeMeta(hash, *m)
+}
+
+// serve answers /media/cache/<hash>: fresh copies come from disk, stale ones
+// are refetched, and a failed refetch falls back to the stale copy (or a
+// redirect to the source when nothing was ever cached).
+func (ic *imageCache) serve(c *gin.Context, hash string) {
+	if !imageHashRe.MatchString(hash) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	l := ic.lock(hash)
+	l.Lock()
+	m, err := ic.readMeta(hash)
+	if err != nil {
+		l.Unlock()
+		c.Status(http.StatusNotFound)
+		return
+	}
+	body := filepath.Join(ic.dir, hash)
+	_, statErr := os.Stat(body)
+	cached := statErr == nil && !m.FetchedAt.IsZero()
+	if !cached || time.Since(m.FetchedAt) > ic.refresh {
+		if err := ic.fetch(c.Request.Context(), hash, &m); err != nil {
+			warnf("缓存外链图片失败 %s: %v\n", m.URL, err)
+			if !cached {
+				l.Unlock()
+				c.Redirect(http.StatusFound, m.URL)
+				return
+			}
+		}
+	}
+	l.Unlock()
+
+	c.Header("Content-Type", m.ContentType)
+	c.Header("Content-Security-Policy", cachedImageCSP)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", cacheMedia)
+	http.ServeFile(c.Writer, c.Request, body)
+}
+
+var imgSrcRe = regexp.MustCompile(`(?i)(<img\s[^>]*?\bsrc\s*=\s*)("[^"]*"|'[^']*')`)
+
+// proxyImages rewrites external <img src> URLs in rendered HTML to their
+// /media/cache path. It is a no-op when the cache is disabled.
+func (s *server) proxyImages(body string) string {
+	if s.images == nil || body == "" {
+		return body
+	}
+	host := s.canonicalHostname()
+	return imgSrcRe.ReplaceAllStringFunc(body, func(tag string) string {
+		m := imgSrcRe.FindStringSubmatch(tag)
+		src := html.UnescapeString(m[2][1 : len(m[2])-1])
+		u, err := url.Parse(strings.TrimSpace(src))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return tag
+		}
+		if host != "" && strings.EqualFold(u.Host, host) {
+			return tag
+		}
+		hash, err := s.images.register(u.String())
+		if err != nil {
+			warnf("登记外链图片失败: %v\n", err)
+			return tag
+		}
+		return m[1] + `"` + s.basePath + "/media/" + imageCacheSubdir + "/" + hash + `"`
+	})
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestProxyImagesAndServe(t *testing.T) {
+	hits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/big.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, 64))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png-bytes"))
+	}))
+	defer upstream.Close()
+
+	s := &server{basePath: "/blog"}
+	s.images = newImageCache(t.TempDir(), imageCacheConfig{Enabled: true, MaxBytes: 32}, upstream.Client())
+	if s.images == nil {
+		t.Fatal("cache not enabled")
+	}
+
+	body := `<p><img alt="x" src="` + upstream.URL + `/a.png"><img src="/blog/media/local.png"></p>`
+	out := s.proxyImages(body)
+	hash := imageHash(upstream.URL + "/a.png")
+	if !strings.Contains(out, `src="/blog/media/cache/`+hash+`"`) || !strings.Contains(out, `src="/blog/media/local.png"`) {
+		t.Fatalf("unexpected rewrite: %s", out)
+	}
+
+	gin.SetMode(gin.TestMode)
+	serve := func(h string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/media/cache/"+h, nil)
+		s.images.serve(c, h)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		w := serve(hash)
+		if w.Code != http.StatusOK || w.Body.String() != "png-bytes" {
+			t.Fatalf("serve #%d: %d %q", i, w.Code, w.Body.String())
+		}
+	}
+	if hits != 1 {
+		t.Fatalf("expected one upstream fetch, got %d", hits)
+	}
+
+	s.proxyImages(`<img src="` + upstream.URL + `/big.png">`)
+	if w := serve(imageHash(upstream.URL + "/big.png")); w.Code != http.StatusFound {
+		t.Fatalf("oversized image should redirect to the source, got %d", w.Code)
+	}
+	if w := serve(imageHash("https://never-registered.example/x.png")); w.Code != http.StatusNotFound {
+		t.Fatalf("unregistered hash should 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// imapSnippetRunes is the length of the preview the message list shows.
+const imapSnippetRunes = 200
+
+var (
+	// mailInvisible matches elements whose text is not part of the message.
+	mailInvisible = regexp.MustCompile(`(?is)<(?:head|style|script|title)\b.*?</(?:head|style|script|title)\s*>|<!--.*?-->`)
+	// mailBreaks matches tags that end a line of text.
+	mailBreaks  = regexp.MustCompile(`(?i)<br\s*/?>|</(?:p|div|tr|li|h[1-6]|blockquote)\s*>`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+	lineSpacing = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+// mailBody is a message body as parsed during sync: HTML for display, a
+// plain text version for previews and the References header for threading.
+type mailBody struct {
+	HTML       string
+	Plain      string
+	References []string
+}
+
+// htmlToPlain renders a mail's HTML part as text, keeping line breaks.
+func htmlToPlain(s string) string {
+	s = mailInvisible.ReplaceAllString(s, "")
+	s = mailBreaks.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(stripHTMLTags(s))
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(lineSpacing.ReplaceAllString(line, " "))
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// mailSnippet is the one-line preview of a plain text body.
+func mailSnippet(plain string) string {
+	return truncateRunes(collapseWhitespace(plain), imapSnippetRunes)
+}
+
+// backfillImapSnippets fills plain text and snippets for messages cached
+// before they were extracted during sync.
+func (s *server) backfillImapSnippets(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, COALESCE(body_html, ''), COALESCE(body_plain, '') FROM imap_messages WHERE snippet IS NULL`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	type item struct{ id, html, plain string }
+	var items []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.html, &it.plain); err != nil {
+			return 0, err
+		}
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, it := range items {
+		if it.plain == "" {
+			it.plain = htmlToPlain(it.html)
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE imap_messages SET body_plain=$1, snippet=$2 WHERE id=$3`,
+			it.plain, mailSnippet(it.plain), it.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), nil
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHTMLToPlain(t *testing.T) {
+	in := `<html><head><title>Hi</title><style>p{color:red}</style></head><body>
+<!-- tracking --><p>Hello&nbsp;<b>there</b> &amp; welcome</p><div>Line two<br>Line   three</div>
+<script>alert(1)</script></body></html>`
+	got := htmlToPlain(in)
+	want := "Hello there & welcome\nLine two\nLine three"
+	if got != want {
+		t.Fatalf("htmlToPlain = %q, want %q", got, want)
+	}
+}
+
+func TestParseBodyPlain(t *testing.T) {
+	cases := []struct {
+		name, msg, html, plain string
+	}{
+		{
+			name:  "html only",
+			msg:   "Content-Type: text/html; charset=utf-8\r\n\r\n<p>Hi <i>you</i></p><p>Bye</p>",
+			html:  "<p>Hi <i>you</i></p><p>Bye</p>",
+			plain: "Hi you\nBye",
+		},
+		{
+			name:  "text only",
+			msg:   "Content-Type: text/plain; charset=utf-8\r\n\r\n  a < b\nnext\n",
+			html:  "  a &lt; b<br>next<br>",
+			plain: "a < b\nnext",
+		},
+		{
+			name: "alternative",
+			msg: "Content-Type: multipart/alternative; boundary=x\r\n\r\n" +
+				"--x\r\nContent-Type: text/plain\r\n\r\nplain version\r\n" +
+				"--x\r\nContent-Type: text/html\r\n\r\n<p>html version</p>\r\n--x--\r\n",
+			html:  "<p>html version</p>",
+			plain: "plain version",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := parseBody(strings.NewReader(tc.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b.HTML != tc.html || b.Plain != tc.plain {
+				t.Fatalf("parseBody = %+v, want HTML %q, Plain %q", b, tc.html, tc.plain)
+			}
+		})
+	}
+}
+
+func TestMailSnippet(t *testing.T) {
+	if got := mailSnippet("Hello\n\n  world"); got != "Hello world" {
+		t.Fatalf("mailSnippet = %q", got)
+	}
+	long := mailSnippet(strings.Repeat("字", 500))
+	if n := utf8.RuneCountInString(long); n > imapSnippetRunes+1 {
+		t.Fatalf("snippet has %d runes", n)
+	}
+}
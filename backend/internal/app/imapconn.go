@@ -0,0 +1,98 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// Defaults for accounts that leave their timeouts at zero. The read timeout
+// bounds each IMAP command, so it must cover fetching a batch of bodies.
+const (
+	defaultImapConnectTimeout = 15 * time.Second
+	defaultImapReadTimeout    = 2 * time.Minute
+	maxImapTimeoutSeconds     = 600
+	maxImapCABytes            = 256 << 10
+)
+
+var errImapCABundle = errors.New("CA 证书不是有效的 PEM")
+
+// imapInsecureWarned remembers accounts whose skipped certificate check was
+// already logged, so each is reported once per process, not per sync.
+var imapInsecureWarned sync.Map
+
+// imapTLSConfig builds the TLS settings for acc: its CA bundle, if any, is
+// trusted in addition to the system roots.
+func imapTLSConfig(acc imapAccount) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: acc.Host}
+	if acc.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(acc.CACert)) {
+			return nil, errImapCABundle
+		}
+		cfg.RootCAs = pool
+	}
+	if acc.InsecureSkipVerify {
+		if _, seen := imapInsecureWarned.LoadOrStore(acc.ID, true); !seen {
+			warnf("IMAP 账户 %s (%s@%s) 已关闭证书校验\n", acc.ID, acc.Username, acc.Host)
+		}
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// validImapCA reports whether pem is empty or a usable CA bundle.
+func validImapCA(pem string) bool {
+	if pem == "" {
+		return true
+	}
+	return len(pem) <= maxImapCABytes && x509.NewCertPool().AppendCertsFromPEM([]byte(pem))
+}
+
+func validImapTimeout(seconds int) bool {
+	return seconds >= 0 && seconds <= maxImapTimeoutSeconds
+}
+
+func imapTimeout(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// dialImap connects to acc's server, upgrading with STARTTLS when asked,
+// with acc's timeouts applied to the dial and to every later command.
+func dialImap(acc imapAccount) (*client.Client, error) {
+	tlsCfg, err := imapTLSConfig(acc)
+	if err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(acc.Host, fmt.Sprint(acc.Port))
+	dialer := &net.Dialer{Timeout: imapTimeout(acc.ConnectTimeoutSeconds, defaultImapConnectTimeout)}
+	var c *client.Client
+	if acc.UseSSL {
+		c, err = client.DialWithDialerTLS(dialer, address, tlsCfg)
+	} else {
+		c, err = client.DialWithDialer(dialer, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Timeout = imapTimeout(acc.ReadTimeoutSeconds, defaultImapReadTimeout)
+	if !acc.UseSSL && acc.UseStartTLS {
+		if err := c.StartTLS(tlsCfg); err != nil {
+			c.Logout()
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/backend/memory"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+// newTestImapTLSServer serves the in-memory backend over TLS with a
+// self-signed certificate for 127.0.0.1, returning the account pointing at
+// it and the certificate as PEM.
+func newTestImapTLSServer(t *testing.T) (imapAccount, string) {
+	t.Helper()
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ts.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := imapserver.New(memory.New())
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	return imapAccount{ID: "acc", Host: host, Port: p, Username: "username", Password: "password", UseSSL: true}, string(ca)
+}
+
+func TestDialImapCustomCA(t *testing.T) {
+	acc, ca := newTestImapTLSServer(t)
+	acc.ConnectTimeoutSeconds = 5
+
+	if c, err := dialImap(acc); err == nil {
+		c.Logout()
+		t.Fatal("dial trusted a self-signed certificate")
+	}
+
+	withCA := acc
+	withCA.CACert = ca
+	c, err := dialImap(withCA)
+	if err != nil {
+		t.Fatalf("dial with CA: %v", err)
+	}
+	defer c.Logout()
+	if c.Timeout != defaultImapReadTimeout {
+		t.Fatalf("timeout = %v", c.Timeout)
+	}
+	if err := c.Login(acc.Username, acc.Password); err != nil {
+		t.Fatal(err)
+	}
+
+	insecure := acc
+	insecure.InsecureSkipVerify = true
+	insecure.ReadTimeoutSeconds = 30
+	c2, err := dialImap(insecure)
+	if err != nil {
+		t.Fatalf("dial insecure: %v", err)
+	}
+	defer c2.Logout()
+	if c2.Timeout != 30*time.Second {
+		t.Fatalf("timeout = %v", c2.Timeout)
+	}
+}
+
+func TestValidImapSettings(t *testing.T) {
+	_, ca := newTestImapTLSServer(t)
+	if !validImapCA("") || !validImapCA(ca) || validImapCA("not a certificate") {
+		t.Fatal("validImapCA")
+	}
+	if !validImapTimeout(0) || !validImapTimeout(maxImapTimeoutSeconds) || validImapTimeout(-1) || validImapTimeout(maxImapTimeoutSeconds+1) {
+		t.Fatal("validImapTimeout")
+	}
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+// newTestImapClient serves go-imap's in-memory backend (one message in
+// INBOX) and returns a client logged in with INBOX selected.
+func newTestImapClient(t *testing.T) *client.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := imapserver.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c, err := client.Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Logout() })
+	if err := c.Login("username", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Select("INBOX", true); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUidFetchMessages(t *testing.T) {
+	c := newTestImapClient(t)
+	uids, err := c.UidSearch(imap.NewSearchCriteria())
+	if err != nil || len(uids) != 1 {
+		t.Fatalf("uids = %v, %v", uids, err)
+	}
+	msgs, err := uidFetchMessages(c, uids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages", len(msgs))
+	}
+	for uid, m := range msgs {
+		if m.UID != uid || m.Subject != "A little message, just for you" || !strings.Contains(m.Body, "Hi there") {
+			t.Fatalf("message = %+v", m)
+		}
+	}
+
+	msgs, err = uidFetchMessages(c, []uint32{9999})
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("unknown uid: %v, %v", msgs, err)
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imapProvider prefills the account form for a well-known mail service.
+type imapProvider struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Domains     []string `json:"domains"`
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	UseSSL      bool     `json:"useSsl"`
+	UseStartTLS bool     `json:"useStartTls"`
+	// Note tells the user what to sign in with where it is not the account
+	// password.
+	Note string `json:"note,omitempty"`
+}
+
+var imapProviders = []imapProvider{
+	{ID: "gmail", Name: "Gmail", Domains: []string{"gmail.com", "googlemail.com"}, Host: "imap.gmail.com", Port: 993, UseSSL: true,
+		Note: "需开启两步验证并使用应用专用密码"},
+	{ID: "outlook", Name: "Outlook", Domains: []string{"outlook.com", "hotmail.com", "live.com", "msn.com"}, Host: "outlook.office365.com", Port: 993, UseSSL: true},
+	{ID: "icloud", Name: "iCloud", Domains: []string{"icloud.com", "me.com", "mac.com"}, Host: "imap.mail.me.com", Port: 993, UseSSL: true,
+		Note: "需使用 Apple ID 的 App 专用密码"},
+	{ID: "qq", Name: "QQ 邮箱", Domains: []string{"qq.com", "foxmail.com"}, Host: "imap.qq.com", Port: 993, UseSSL: true,
+		Note: "需在邮箱设置中开启 IMAP 并使用授权码"},
+	{ID: "163", Name: "网易 163", Domains: []string{"163.com"}, Host: "imap.163.com", Port: 993, UseSSL: true,
+		Note: "需在邮箱设置中开启 IMAP 并使用授权码"},
+}
+
+// imapDiscoverTimeout bounds the DNS lookups of one autodiscovery.
+const imapDiscoverTimeout = 3 * time.Second
+
+// imapResolver is the part of net.Resolver autodiscovery needs.
+type imapResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+var imapDNS imapResolver = net.DefaultResolver
+
+// imapDiscovery is a suggested server for an address. Source says how it was
+// found: "preset", "srv" (RFC 6186 records) or "guess" (imap.<domain>
+// resolves).
+type imapDiscovery struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	UseSSL      bool   `json:"useSsl"`
+	UseStartTLS bool   `json:"useStartTls"`
+	Source      string `json:"source"`
+	Provider    string `json:"provider,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+// mailDomain is the domain of an address, or the input itself when it is
+// already a bare domain.
+func mailDomain(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		email = email[i+1:]
+	}
+	email = strings.TrimSuffix(email, ".")
+	if !strings.Contains(email, ".") || strings.ContainsAny(email, " /:") {
+		return ""
+	}
+	return email
+}
+
+// discoverImap suggests a server for email, trying the presets, then SRV
+// records, then imap.<domain>. It returns nil when nothing was found.
+func discoverImap(ctx context.Context, r imapResolver, email string) *imapDiscovery {
+	domain := mailDomain(email)
+	if domain == "" {
+		return nil
+	}
+	for _, p := range imapProviders {
+		if slices.Contains(p.Domains, domain) {
+			return &imapDiscovery{Host: p.Host, Port: p.Port, UseSSL: p.UseSSL, UseStartTLS: p.UseStartTLS, Source: "preset", Provider: p.ID, Note: p.Note}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, imapDiscoverTimeout)
+	defer cancel()
+	for _, service := range []string{"imaps", "imap"} {
+		_, srvs, err := r.LookupSRV(ctx, service, "tcp", domain)
+		if err != nil {
+			continue
+		}
+		// Records come sorted by priority; "." marks the service as absent.
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			if target == "" || srv.Port == 0 {
+				continue
+			}
+			return &imapDiscovery{Host: target, Port: int(srv.Port), UseSSL: service == "imaps", UseStartTLS: service == "imap", Source: "srv"}
+		}
+	}
+	if addrs, err := r.LookupHost(ctx, "imap."+domain); err == nil && len(addrs) > 0 {
+		return &imapDiscovery{Host: "imap." + domain, Port: 993, UseSSL: true, Source: "guess"}
+	}
+	return nil
+}
+
+// listImapProviders returns the presets and, given ?email=, a suggested
+// server for that address.
+func (s *server) listImapProviders(c *gin.Context) {
+	var suggestion *imapDiscovery
+	if email := c.Query("email"); email != "" {
+		suggestion = discoverImap(c.Request.Context(), imapDNS, email)
+	}
+	c.JSON(http.StatusOK, gin.H{"providers": imapProviders, "suggestion": suggestion})
+}
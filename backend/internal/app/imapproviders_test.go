@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+type fakeImapResolver struct {
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func (r fakeImapResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if srvs, ok := r.srv["_"+service+"._"+proto+"."+name]; ok {
+		return "", srvs, nil
+	}
+	return "", nil, errors.New("no such host")
+}
+
+func (r fakeImapResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestDiscoverImap(t *testing.T) {
+	r := fakeImapResolver{
+		srv: map[string][]*net.SRV{
+			"_imaps._tcp.example.org": {{Target: "mail.example.org.", Port: 993}},
+			"_imaps._tcp.plain.net":   {{Target: ".", Port: 0}},
+			"_imap._tcp.plain.net":    {{Target: "mx.plain.net.", Port: 143}},
+		},
+		hosts: map[string][]string{"imap.guess.io": {"192.0.2.1"}},
+	}
+	cases := []struct {
+		email  string
+		want   *imapDiscovery
+		source string
+	}{
+		{"Someone@Gmail.com", &imapDiscovery{Host: "imap.gmail.com", Port: 993, UseSSL: true}, "preset"},
+		{"me@foxmail.com", &imapDiscovery{Host: "imap.qq.com", Port: 993, UseSSL: true}, "preset"},
+		{"me@example.org", &imapDiscovery{Host: "mail.example.org", Port: 993, UseSSL: true}, "srv"},
+		{"me@plain.net", &imapDiscovery{Host: "mx.plain.net", Port: 143, UseStartTLS: true}, "srv"},
+		{"guess.io", &imapDiscovery{Host: "imap.guess.io", Port: 993, UseSSL: true}, "guess"},
+		{"me@nowhere.test", nil, ""},
+		{"not an address", nil, ""},
+	}
+	for _, tc := range cases {
+		got := discoverImap(context.Background(), r, tc.email)
+		if tc.want == nil {
+			if got != nil {
+				t.Errorf("%s: got %+v, want nothing", tc.email, got)
+			}
+			continue
+		}
+		if got == nil || got.Host != tc.want.Host || got.Port != tc.want.Port || got.UseSSL != tc.want.UseSSL ||
+			got.UseStartTLS != tc.want.UseStartTLS || got.Source != tc.source {
+			t.Errorf("%s: got %+v, want %+v from %s", tc.email, got, tc.want, tc.source)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"selfecho/backend/internal/store"
+)
+
+const (
+	imapPruneInterval = time.Hour
+	// imapPruneBatch is how many rows one DELETE removes. Small batches keep
+	// each transaction short, so autovacuum can reclaim the space as pruning
+	// goes instead of after one huge delete.
+	imapPruneBatch = 500
+
+	maxImapRetainMessages = 1_000_000
+	maxImapRetainDays     = 3650
+)
+
+// imapRetention bounds an account's message cache: at most RetainMessages
+// messages, none older than RetainDays. Zero means no limit.
+type imapRetention struct {
+	RetainMessages int `json:"retainMessages"`
+	RetainDays     int `json:"retainDays"`
+}
+
+func (r imapRetention) check(v *validator) {
+	v.check(r.RetainMessages >= 0 && r.RetainMessages <= maxImapRetainMessages, "retainMessages", errInvalidImapRetention)
+	v.check(r.RetainDays >= 0 && r.RetainDays <= maxImapRetainDays, "retainDays", errInvalidImapRetention)
+}
+
+// pruneImapAccount deletes the cached messages of acc beyond its limits,
+// oldest first, in batches of imapPruneBatch. Messages keep their age by
+// date, or by when they were cached if they have none.
+func (s *server) pruneImapAccount(ctx context.Context, acc imapAccount) (int, error) {
+	var total int
+	step := func(query string, args ...any) error {
+		for {
+			res, err := s.db.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			total += int(n)
+			if n < imapPruneBatch {
+				return nil
+			}
+		}
+	}
+	if acc.RetainDays > 0 {
+		if err := step(`
+			DELETE FROM imap_messages WHERE id IN (
+				SELECT id FROM imap_messages
+				WHERE account_id=$1 AND COALESCE(msg_date, created_at) < now() - make_interval(days => $2)
+				LIMIT $3)`, acc.ID, acc.RetainDays, imapPruneBatch); err != nil {
+			return total, err
+		}
+	}
+	if acc.RetainMessages > 0 {
+		if err := step(`
+			DELETE FROM imap_messages WHERE id IN (
+				SELECT id FROM imap_messages
+				WHERE account_id=$1
+				ORDER BY COALESCE(msg_date, created_at) DESC, uid DESC
+				OFFSET $2
+				LIMIT $3)`, acc.ID, acc.RetainMessages, imapPruneBatch); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// pruneImapCaches applies every account's retention.
+func (s *server) pruneImapCaches(ctx context.Context) (int, error) {
+	rows, err := s.mail.ListImapAccounts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, r := range rows {
+		acc := imapAccountFromStore(r)
+		if acc.RetainMessages == 0 && acc.RetainDays == 0 {
+			continue
+		}
+		n, err := s.pruneImapAccount(ctx, acc)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", acc.ID, err)
+		}
+	}
+	return total, nil
+}
+
+func (s *server) runImapPruning() {
+	ticker := time.NewTicker(imapPruneInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		n, err := s.pruneImapCaches(ctx)
+		cancel()
+		if err != nil {
+			warnf("清理邮件缓存失败: %v\n", err)
+		} else if n > 0 {
+			infof("已清理 %d 封缓存邮件\n", n)
+		}
+		<-ticker.C
+	}
+}
+
+// updateImapRetention sets an account's cache limits and prunes to them
+// right away, returning how many messages went.
+func (s *server) updateImapRetention(c *gin.Context) {
+	var payload imapRetention
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	var v validator
+	payload.check(&v)
+	if err := v.err(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	ctx := c.Request.Context()
+	err := s.mail.SetImapRetention(ctx, c.Param("id"), payload.RetainMessages, payload.RetainDays)
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errImapAccountNotFound)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveImapAccountFailed, err)
+		return
+	}
+	acc, err := s.pickImapAccount(ctx, c.Param("id"))
+	if err != nil || acc == nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errImapAccountLookupFailed, err)
+		return
+	}
+	pruned, err := s.pruneImapAccount(ctx, *acc)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errImapClearCacheFailed, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"retainMessages": acc.RetainMessages, "retainDays": acc.RetainDays, "pruned": pruned})
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestImapRetentionCheck(t *testing.T) {
+	cases := []struct {
+		r  imapRetention
+		ok bool
+	}{
+		{imapRetention{}, true},
+		{imapRetention{RetainMessages: 500, RetainDays: 90}, true},
+		{imapRetention{RetainMessages: -1}, false},
+		{imapRetention{RetainDays: maxImapRetainDays + 1}, false},
+		{imapRetention{RetainMessages: maxImapRetainMessages + 1}, false},
+	}
+	for _, tc := range cases {
+		var v validator
+		tc.r.check(&v)
+		if err := v.err(); (err == nil) != tc.ok {
+			t.Errorf("%+v: err = %v", tc.r, err)
+		}
+		var verrs validationErrors
+		if err := v.err(); err != nil && !errors.As(err, &verrs) {
+			t.Errorf("%+v: err %T is no validation error", tc.r, err)
+		}
+	}
+}
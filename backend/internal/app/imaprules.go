@@ -0,0 +1,585 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/gin-gonic/gin"
+)
+
+// Rule actions. mark_read and move change the message on the server;
+// draft, forward and webhook run through the outbox.
+const (
+	ruleMarkRead = "mark_read"
+	ruleMove     = "move"
+	ruleDraft    = "draft"
+	ruleForward  = "forward"
+	ruleWebhook  = "webhook"
+)
+
+var ruleActions = []string{ruleMarkRead, ruleMove, ruleDraft, ruleForward, ruleWebhook}
+
+// Outbox jobs of the queued rule actions; their payload is a mailRuleJob.
+const (
+	jobMailDraft   = "mail.draft"
+	jobMailForward = "mail.forward"
+	jobMailWebhook = "mail.webhook"
+)
+
+// hookMailMatched is the event rule webhooks are sent as.
+const hookMailMatched = "mail.matched"
+
+// imapRule acts on incoming mail of one account, Sieve style: the enabled
+// rules are tried in position order on every new message, each whose
+// conditions all match applies its action, and a matching rule with Stop
+// set ends the evaluation. Conditions match case-insensitive substrings of
+// the sender, the subject and the To/Cc addresses; empty ones are ignored.
+// Target is the folder of move, the address of forward and the URL of
+// webhook.
+type imapRule struct {
+	ID           string    `json:"id"`
+	AccountID    string    `json:"accountId"`
+	Name         string    `json:"name"`
+	Position     int       `json:"position"`
+	Enabled      bool      `json:"enabled"`
+	MatchFrom    string    `json:"matchFrom"`
+	MatchSubject string    `json:"matchSubject"`
+	MatchTo      string    `json:"matchTo"`
+	Action       string    `json:"action"`
+	Target       string    `json:"target"`
+	Stop         bool      `json:"stop"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func (s *server) ensureImapRulesSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS imap_rules (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			account_id UUID NOT NULL REFERENCES imap_accounts(id) ON DELETE CASCADE,
+			name TEXT NOT NULL DEFAULT '',
+			position INT NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			match_from TEXT NOT NULL DEFAULT '',
+			match_subject TEXT NOT NULL DEFAULT '',
+			match_to TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			stop BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_imap_rules_account ON imap_rules(account_id, position);
+	`)
+	return err
+}
+
+// ruleMessage is what rules match against.
+type ruleMessage struct {
+	UID     uint32 `json:"uid"`
+	Subject string `json:"subject"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+func containsFold(s, sub string) bool {
+	return sub == "" || strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+}
+
+func (r imapRule) matches(m ruleMessage) bool {
+	return containsFold(m.From, r.MatchFrom) && containsFold(m.Subject, r.MatchSubject) && containsFold(m.To, r.MatchTo)
+}
+
+// matchRules returns the rules that apply to m, in order.
+func matchRules(rules []imapRule, m ruleMessage) []imapRule {
+	var out []imapRule
+	for _, r := range rules {
+		if !r.Enabled || !r.matches(m) {
+			continue
+		}
+		out = append(out, r)
+		if r.Stop {
+			break
+		}
+	}
+	return out
+}
+
+// envelopeRecipients joins the To and Cc addresses of env.
+func envelopeRecipients(env *imap.Envelope) string {
+	if env == nil {
+		return ""
+	}
+	var addrs []string
+	for _, a := range slices.Concat(env.To, env.Cc) {
+		if addr := a.Address(); addr != "@" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return safeUTF8(strings.Join(addrs, ", "))
+}
+
+const imapRuleColumns = `id, account_id, name, position, enabled, match_from, match_subject, match_to, action, target, stop, created_at`
+
+func scanImapRule(row interface{ Scan(...any) error }) (imapRule, error) {
+	var r imapRule
+	err := row.Scan(&r.ID, &r.AccountID, &r.Name, &r.Position, &r.Enabled, &r.MatchFrom, &r.MatchSubject, &r.MatchTo,
+		&r.Action, &r.Target, &r.Stop, &r.CreatedAt)
+	return r, err
+}
+
+// imapRules returns the rules of an account in evaluation order.
+func (s *server) imapRules(ctx context.Context, accountID string) ([]imapRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+imapRuleColumns+` FROM imap_rules WHERE account_id=$1 ORDER BY position, created_at`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []imapRule{}
+	for rows.Next() {
+		r, err := scanImapRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, r)
+	}
+	return items, rows.Err()
+}
+
+// mailRuleJob is the payload of the queued rule actions.
+type mailRuleJob struct {
+	RuleID    string    `json:"ruleId"`
+	RuleName  string    `json:"ruleName"`
+	AccountID string    `json:"accountId"`
+	Target    string    `json:"target,omitempty"`
+	UID       uint32    `json:"uid"`
+	Subject   string    `json:"subject"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Date      time.Time `json:"date"`
+	Text      string    `json:"text"`
+	HTML      string    `json:"html,omitempty"`
+}
+
+var ruleJobTypes = map[string]string{ruleDraft: jobMailDraft, ruleForward: jobMailForward, ruleWebhook: jobMailWebhook}
+
+// ruleOutcome collects what the rules of one sync asked of the server.
+type ruleOutcome struct {
+	markRead []uint32
+	moves    map[string][]uint32
+	queued   int
+}
+
+// applyRules runs the rules matching a newly cached message: queued actions
+// are written with q, the sync's transaction; server-side ones are added to
+// out for applyServerRules.
+func (s *server) applyRules(ctx context.Context, q sqlQuerier, rules []imapRule, out *ruleOutcome, job mailRuleJob) error {
+	for _, r := range matchRules(rules, ruleMessage{UID: job.UID, Subject: job.Subject, From: job.From, To: job.To}) {
+		switch r.Action {
+		case ruleMarkRead:
+			out.markRead = append(out.markRead, job.UID)
+		case ruleMove:
+			if out.moves == nil {
+				out.moves = map[string][]uint32{}
+			}
+			out.moves[r.Target] = append(out.moves[r.Target], job.UID)
+		default:
+			typ, ok := ruleJobTypes[r.Action]
+			if !ok {
+				continue
+			}
+			j := job
+			j.RuleID, j.RuleName, j.Target = r.ID, r.Name, r.Target
+			payload, err := json.Marshal(j)
+			if err != nil {
+				return err
+			}
+			if _, err := q.ExecContext(ctx, `INSERT INTO outbox (type, payload) VALUES ($1, $2)`, typ, payload); err != nil {
+				return err
+			}
+			out.queued++
+		}
+	}
+	return nil
+}
+
+// applyServerRules marks and moves messages on the server once the sync is
+// committed, keeping the INBOX cache in step. Failures are logged; the
+// messages stay as they are.
+func (s *server) applyServerRules(ctx context.Context, c *client.Client, accountID string, out ruleOutcome) {
+	if len(out.markRead) == 0 && len(out.moves) == 0 {
+		return
+	}
+	// the sync reads INBOX read-only so fetching bodies leaves \Seen alone
+	if _, err := c.Select("INBOX", false); err != nil {
+		warnf("执行邮件规则失败: %v\n", err)
+		return
+	}
+	if len(out.markRead) > 0 {
+		set := new(imap.SeqSet)
+		set.AddNum(out.markRead...)
+		err := c.UidStore(set, imap.FormatFlagsOp(imap.AddFlags, true), []any{imap.SeenFlag}, nil)
+		if err == nil {
+			_, err = s.db.ExecContext(ctx, `
+				UPDATE imap_messages SET flags = btrim(COALESCE(flags, '') || ' \Seen')
+				WHERE account_id=$1 AND uid = ANY($2::bigint[]) AND position('\Seen' IN COALESCE(flags, '')) = 0`,
+				accountID, uidStrings(out.markRead))
+		}
+		if err != nil {
+			warnf("邮件规则标记已读失败: %v\n", err)
+		}
+	}
+	for folder, uids := range out.moves {
+		set := new(imap.SeqSet)
+		set.AddNum(uids...)
+		err := c.UidMove(set, folder)
+		if err == nil {
+			_, err = s.db.ExecContext(ctx, `DELETE FROM imap_messages WHERE account_id=$1 AND uid = ANY($2::bigint[])`,
+				accountID, uidStrings(uids))
+		}
+		if err != nil {
+			warnf("邮件规则移动到 %s 失败: %v\n", folder, err)
+		}
+	}
+}
+
+func uidStrings(uids []uint32) []string {
+	out := make([]string, len(uids))
+	for i, u := range uids {
+		out[i] = strconv.FormatUint(uint64(u), 10)
+	}
+	return out
+}
+
+func decodeRuleJob(payload []byte) (mailRuleJob, error) {
+	var j mailRuleJob
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return j, fmt.Errorf("%w: %v", errJobPermanent, err)
+	}
+	return j, nil
+}
+
+// runMailDraftJob turns a message into a draft article.
+func (s *server) runMailDraftJob(ctx context.Context, payload []byte) error {
+	j, err := decodeRuleJob(payload)
+	if err != nil {
+		return err
+	}
+	title := strings.TrimSpace(j.Subject)
+	if title == "" {
+		title = "邮件 " + strconv.FormatUint(uint64(j.UID), 10)
+	}
+	slugBase, err := makeSlug(title, "")
+	if err != nil {
+		slugBase = "mail-" + strconv.FormatUint(uint64(j.UID), 10)
+	}
+	p := articlePayload{Title: title, BodyMD: j.Text, Status: "draft", Type: "post"}
+	body, err := s.storeBody(p.Status, p.Visibility, p.BodyMD, renderMarkdown(p.BodyMD))
+	if err != nil {
+		return err
+	}
+	var id, slug string
+	for attempt := 0; attempt < 3; attempt++ {
+		if slug, err = s.ensureUniqueSlug(ctx, slugBase, ""); err != nil {
+			return err
+		}
+		err = s.db.QueryRowContext(ctx, articleInsertSQL,
+			slug, title, body.md, body.html, p.Status, nil, nil, p.Type, p.description(), p.tags(),
+			p.lang(), nil, p.social(), p.Visibility, nil, body.excerpt, p.meta(), nil,
+		).Scan(&id)
+		if err == nil || !isUniqueViolation(err) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.sealPendingArticles(ctx, id); err != nil {
+		warnf("加密邮件草稿失败: %v\n", err)
+	}
+	s.refreshSearchIndex(id)
+	s.refreshLinkGraph(id)
+	s.publish(eventArticleChanged, actionCreated, id, slug)
+	return nil
+}
+
+// runMailForwardJob forwards a message to the rule's address over SMTP.
+func (s *server) runMailForwardJob(ctx context.Context, payload []byte) error {
+	j, err := decodeRuleJob(payload)
+	if err != nil {
+		return err
+	}
+	if !s.smtp.enabled() {
+		return fmt.Errorf("%w: %v", errJobPermanent, errSMTPDisabled)
+	}
+	intro := fmt.Sprintf("---------- 转发的邮件 ----------\nFrom: %s\nDate: %s\nSubject: %s\nTo: %s\n\n",
+		j.From, j.Date.Format(time.RFC1123Z), j.Subject, j.To)
+	return s.smtp.sendMail(ctx, outgoingMail{To: j.Target, Subject: "Fwd: " + j.Subject, Text: intro + j.Text, HTML: j.HTML})
+}
+
+// runMailWebhookJob posts a matched message, without its HTML, to the
+// rule's URL.
+func (s *server) runMailWebhookJob(ctx context.Context, payload []byte) error {
+	j, err := decodeRuleJob(payload)
+	if err != nil {
+		return err
+	}
+	j.HTML = ""
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return s.postWebhook(ctx, j.Target, "", hookMailMatched, data, time.Now())
+}
+
+// rulePayload is a rule as created, replaced or tested over the API.
+type rulePayload struct {
+	AccountID    string `json:"accountId"`
+	Name         string `json:"name"`
+	Position     *int   `json:"position"`
+	Enabled      *bool  `json:"enabled"`
+	MatchFrom    string `json:"matchFrom"`
+	MatchSubject string `json:"matchSubject"`
+	MatchTo      string `json:"matchTo"`
+	Action       string `json:"action"`
+	Target       string `json:"target"`
+	Stop         bool   `json:"stop"`
+}
+
+func (p *rulePayload) normalize() error {
+	var v validator
+	for _, f := range []*string{&p.AccountID, &p.Name, &p.MatchFrom, &p.MatchSubject, &p.MatchTo, &p.Action, &p.Target} {
+		*f = strings.TrimSpace(*f)
+	}
+	v.check(p.MatchFrom != "" || p.MatchSubject != "" || p.MatchTo != "", "matchFrom", errImapRuleMatchRequired)
+	if v.check(slices.Contains(ruleActions, p.Action), "action", errInvalidImapRuleAction) {
+		v.check(validRuleTarget(p.Action, p.Target), "target", errInvalidImapRuleTarget)
+	}
+	return v.err()
+}
+
+func validRuleTarget(action, target string) bool {
+	switch action {
+	case ruleMove:
+		return target != "" && !strings.ContainsAny(target, "\r\n")
+	case ruleForward:
+		_, err := mail.ParseAddress(target)
+		return err == nil
+	case ruleWebhook:
+		u, err := url.Parse(target)
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+	return true
+}
+
+func (p rulePayload) rule() imapRule {
+	r := imapRule{AccountID: p.AccountID, Name: p.Name, Enabled: true, MatchFrom: p.MatchFrom, MatchSubject: p.MatchSubject,
+		MatchTo: p.MatchTo, Action: p.Action, Target: p.Target, Stop: p.Stop}
+	if p.Enabled != nil {
+		r.Enabled = *p.Enabled
+	}
+	if p.Position != nil {
+		r.Position = *p.Position
+	}
+	return r
+}
+
+func bindRulePayload(c *gin.Context) (rulePayload, bool) {
+	var p rulePayload
+	if err := c.BindJSON(&p); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return p, false
+	}
+	if err := p.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return p, false
+	}
+	return p, true
+}
+
+// lookupImapAccount resolves the account a request is about, writing the
+// error response otherwise.
+func (s *server) lookupImapAccount(c *gin.Context, id string) (*imapAccount, bool) {
+	acc, err := s.pickImapAccount(c.Request.Context(), id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
+		return nil, false
+	}
+	if acc == nil {
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
+		return nil, false
+	}
+	return acc, true
+}
+
+// listImapRules returns the rules of ?accountId= in evaluation order.
+func (s *server) listImapRules(c *gin.Context) {
+	acc, ok := s.lookupImapAccount(c, strings.TrimSpace(c.Query("accountId")))
+	if !ok {
+		return
+	}
+	rules, err := s.imapRules(c.Request.Context(), acc.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryImapRulesFailed)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// createImapRule adds a rule, by default after the account's others.
+func (s *server) createImapRule(c *gin.Context) {
+	p, ok := bindRulePayload(c)
+	if !ok {
+		return
+	}
+	acc, ok := s.lookupImapAccount(c, p.AccountID)
+	if !ok {
+		return
+	}
+	r := p.rule()
+	r, err := scanImapRule(s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO imap_rules (account_id, name, position, enabled, match_from, match_subject, match_to, action, target, stop)
+		VALUES ($1, $2, COALESCE($3, (SELECT COALESCE(MAX(position), 0) + 1 FROM imap_rules WHERE account_id=$1)), $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+imapRuleColumns,
+		acc.ID, r.Name, p.Position, r.Enabled, r.MatchFrom, r.MatchSubject, r.MatchTo, r.Action, r.Target, r.Stop))
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveImapRuleFailed, err)
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+}
+
+// updateImapRule replaces a rule; its account cannot change.
+func (s *server) updateImapRule(c *gin.Context) {
+	id, ok := idParam(c, "id", errImapRuleNotFound)
+	if !ok {
+		return
+	}
+	p, ok := bindRulePayload(c)
+	if !ok {
+		return
+	}
+	r := p.rule()
+	r, err := scanImapRule(s.db.QueryRowContext(c.Request.Context(), `
+		UPDATE imap_rules SET name=$2, position=COALESCE($3, position), enabled=$4, match_from=$5, match_subject=$6,
+		       match_to=$7, action=$8, target=$9, stop=$10
+		WHERE id=$1
+		RETURNING `+imapRuleColumns,
+		id, r.Name, p.Position, r.Enabled, r.MatchFrom, r.MatchSubject, r.MatchTo, r.Action, r.Target, r.Stop))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errImapRuleNotFound)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveImapRuleFailed, err)
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+func (s *server) deleteImapRule(c *gin.Context) {
+	id, ok := idParam(c, "id", errImapRuleNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM imap_rules WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveImapRuleFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errImapRuleNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ruleMatch is a cached message a dry run found rules for.
+type ruleMatch struct {
+	ruleMessage
+	Rules []imapRule `json:"rules"`
+}
+
+// testImapRules dry-runs rules against the newest cached messages of an
+// account (?limit=, 50 by default) and reports what would apply, changing
+// nothing. The body may hold a draft rule to test alone; without one the
+// account's saved rules are used.
+func (s *server) testImapRules(c *gin.Context) {
+	var body struct {
+		AccountID string       `json:"accountId"`
+		Rule      *rulePayload `json:"rule"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	limit := 50
+	if l, err := strconv.Atoi(strings.TrimSpace(c.Query("limit"))); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	acc, ok := s.lookupImapAccount(c, strings.TrimSpace(body.AccountID))
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var rules []imapRule
+	if body.Rule != nil {
+		if err := body.Rule.normalize(); err != nil {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+			return
+		}
+		r := body.Rule.rule()
+		r.Enabled = true
+		rules = []imapRule{r}
+	} else {
+		var err error
+		if rules, err = s.imapRules(ctx, acc.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryImapRulesFailed)
+			return
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uid, subject, from_addr, to_addr FROM (
+			SELECT DISTINCT ON (uid) uid, COALESCE(subject, '') AS subject, COALESCE(from_addr, '') AS from_addr, to_addr, msg_date
+			FROM imap_messages
+			WHERE account_id=$1
+			ORDER BY uid, uidvalidity DESC, created_at DESC
+		) t
+		ORDER BY msg_date DESC NULLS LAST, uid DESC
+		LIMIT $2`, acc.ID, limit)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
+		return
+	}
+	defer rows.Close()
+	matches := []ruleMatch{}
+	checked := 0
+	for rows.Next() {
+		var m ruleMessage
+		if err := rows.Scan(&m.UID, &m.Subject, &m.From, &m.To); err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
+			return
+		}
+		checked++
+		if applied := matchRules(rules, m); len(applied) > 0 {
+			matches = append(matches, ruleMatch{ruleMessage: m, Rules: applied})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"checked": checked, "matches": matches})
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestMatchRules(t *testing.T) {
+	rules := []imapRule{
+		{ID: "off", Enabled: false, MatchFrom: "@", Action: ruleMarkRead},
+		{ID: "news", Enabled: true, MatchFrom: "NEWS@", MatchSubject: "weekly", Action: ruleMove, Target: "News"},
+		{ID: "read", Enabled: true, MatchTo: "list@example.org", Action: ruleMarkRead, Stop: true},
+		{ID: "late", Enabled: true, MatchFrom: "@", Action: ruleDraft},
+	}
+	m := ruleMessage{From: "news@example.org", Subject: "The Weekly digest", To: "me@example.org, list@example.org"}
+	got := matchRules(rules, m)
+	if len(got) != 2 || got[0].ID != "news" || got[1].ID != "read" {
+		t.Fatalf("matched = %+v", got)
+	}
+	m.Subject = "daily"
+	m.To = "me@example.org"
+	if got := matchRules(rules, m); len(got) != 1 || got[0].ID != "late" {
+		t.Fatalf("matched = %+v", got)
+	}
+}
+
+func TestRulePayloadNormalize(t *testing.T) {
+	ok := []rulePayload{
+		{MatchFrom: " a@example.org ", Action: ruleMarkRead},
+		{MatchSubject: "x", Action: ruleMove, Target: "Archive"},
+		{MatchTo: "x", Action: ruleForward, Target: "Me <me@example.org>"},
+		{MatchTo: "x", Action: ruleWebhook, Target: "https://hooks.example.org/mail"},
+		{MatchTo: "x", Action: ruleDraft},
+	}
+	for _, p := range ok {
+		if err := p.normalize(); err != nil {
+			t.Errorf("%+v: %v", p, err)
+		}
+	}
+	bad := []rulePayload{
+		{Action: ruleMarkRead},
+		{MatchFrom: "x", Action: "delete"},
+		{MatchFrom: "x", Action: ruleMove},
+		{MatchFrom: "x", Action: ruleForward, Target: "nobody"},
+		{MatchFrom: "x", Action: ruleWebhook, Target: "ftp://example.org"},
+	}
+	for _, p := range bad {
+		if err := p.normalize(); err == nil {
+			t.Errorf("%+v accepted", p)
+		}
+	}
+}
+
+func TestEnvelopeRecipients(t *testing.T) {
+	env := &imap.Envelope{
+		To: []*imap.Address{{MailboxName: "a", HostName: "example.org"}},
+		Cc: []*imap.Address{{MailboxName: "b", HostName: "example.org"}, {}},
+	}
+	if got := envelopeRecipients(env); got != "a@example.org, b@example.org" {
+		t.Fatalf("recipients = %q", got)
+	}
+	if envelopeRecipients(nil) != "" {
+		t.Fatal("recipients of nothing")
+	}
+}
+
+func TestMailForwardJobNeedsSMTP(t *testing.T) {
+	s := &server{}
+	payload, _ := json.Marshal(mailRuleJob{Target: "me@example.org", Subject: "hi"})
+	if err := s.runMailForwardJob(context.Background(), payload); !errors.Is(err, errJobPermanent) {
+		t.Fatalf("err = %v, want permanent", err)
+	}
+}
+
+func TestSMTPRender(t *testing.T) {
+	c := smtpConfig{From: "blog@example.org"}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	plain := string(c.render(outgoingMail{To: "me@example.org", Subject: "Fwd: 你好", Text: "a\nb"}, now))
+	if !strings.Contains(plain, "Subject: =?utf-8?q?") || !strings.Contains(plain, "text/plain") || !strings.HasSuffix(plain, "\r\n\r\na\r\nb\r\n") {
+		t.Fatalf("plain message:\n%s", plain)
+	}
+	alt := string(c.render(outgoingMail{To: "me@example.org", Subject: "x", Text: "a", HTML: "<p>a</p>"}, now))
+	if !strings.Contains(alt, "multipart/alternative") || !strings.Contains(alt, "<p>a</p>") {
+		t.Fatalf("alternative message:\n%s", alt)
+	}
+	if validateSMTP(smtpConfig{Host: "smtp.example.org", From: "nope"}) == nil ||
+		validateSMTP(smtpConfig{Host: "smtp.example.org", From: "blog@example.org", Security: "ssl"}) == nil ||
+		validateSMTP(smtpConfig{Host: "smtp.example.org", From: "blog@example.org"}) != nil {
+		t.Fatal("validateSMTP")
+	}
+}
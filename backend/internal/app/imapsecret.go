@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// imapSecretPrefix marks a password sealed under a per-value salt:
+	// kdf1:<base64 salt>:<base64 ciphertext>.
+	imapSecretPrefix  = "kdf1:"
+	defaultImapSecret = "selfecho-imap-secret"
+)
+
+var errDefaultImapSecret = errors.New("imapSecret / IMAP_SECRET 未设置，生产环境不能使用默认密钥")
+
+// imapSecrets encrypts stored IMAP passwords with a key stretched from
+// imapSecret by kdf under a fresh salt per value. Values written before
+// carry no prefix and were encrypted with sha256 of the secret; they still
+// open, and `selfecho crypto rotate` rewrites them.
+type imapSecrets struct {
+	secret string
+
+	mu sync.Mutex
+	// keys caches derived keys by salt: scrypt is slow on purpose and the
+	// sync loop opens the same password over and over
+	keys map[string][]byte
+}
+
+func newImapSecrets(secret string) *imapSecrets {
+	if secret == "" {
+		secret = defaultImapSecret
+		fmt.Println("warn: imapSecret/IMAP_SECRET 未设置，使用默认密钥，请在生产环境配置")
+	}
+	return &imapSecrets{secret: secret, keys: map[string][]byte{}}
+}
+
+func (b *imapSecrets) key(salt []byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key, ok := b.keys[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := kdf(b.secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	b.keys[string(salt)] = key
+	return key, nil
+}
+
+func (b *imapSecrets) seal(plain string) (string, error) {
+	salt := make([]byte, kdfSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := b.key(salt)
+	if err != nil {
+		return "", err
+	}
+	ct, err := encryptSecret(key, plain)
+	if err != nil {
+		return "", err
+	}
+	return imapSecretPrefix + base64.StdEncoding.EncodeToString(salt) + ":" + ct, nil
+}
+
+func (b *imapSecrets) open(v string) (string, error) {
+	rest, ok := strings.CutPrefix(v, imapSecretPrefix)
+	if !ok {
+		sum := sha256.Sum256([]byte(b.secret))
+		return decryptSecret(sum[:], v)
+	}
+	rawSalt, ct, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed imap secret")
+	}
+	salt, err := base64.StdEncoding.DecodeString(rawSalt)
+	if err != nil {
+		return "", err
+	}
+	key, err := b.key(salt)
+	if err != nil {
+		return "", err
+	}
+	return decryptSecret(key, ct)
+}
+
+// rotateImapSecrets re-encrypts every stored IMAP password from one secret to
+// another, which also moves legacy values onto per-value salts. Passwords
+// that do not open with from are left alone and reported in skipped.
+func rotateImapSecrets(ctx context.Context, db *sql.DB, from, to *imapSecrets) (n int, skipped []string, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `SELECT id::text, password FROM imap_accounts WHERE password <> '' FOR UPDATE`)
+	if err != nil {
+		return 0, nil, err
+	}
+	type item struct{ id, password string }
+	var items []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.password); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	for _, it := range items {
+		plain, err := from.open(it.password)
+		if err != nil {
+			skipped = append(skipped, it.id)
+			continue
+		}
+		sealed, err := to.seal(plain)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE imap_accounts SET password=$1 WHERE id=$2`, sealed, it.id); err != nil {
+			return 0, nil, err
+		}
+		n++
+	}
+	return n, skipped, tx.Commit()
+}
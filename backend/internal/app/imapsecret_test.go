@@ -0,0 +1,49 @@
+package app
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestImapSecrets(t *testing.T) {
+	b := newImapSecrets("correct horse")
+	sealed, err := b.seal("hunter2")
+	if err != nil || !strings.HasPrefix(sealed, imapSecretPrefix) {
+		t.Fatalf("seal = %q, %v", sealed, err)
+	}
+	again, _ := b.seal("hunter2")
+	salt := func(v string) string { return strings.Split(v, ":")[1] }
+	if salt(again) == salt(sealed) {
+		t.Fatal("each value should get its own salt")
+	}
+	if plain, err := b.open(sealed); err != nil || plain != "hunter2" {
+		t.Fatalf("open = %q, %v", plain, err)
+	}
+	if _, err := newImapSecrets("wrong").open(sealed); err == nil {
+		t.Fatal("a different secret should not open the value")
+	}
+
+	sum := sha256.Sum256([]byte("correct horse"))
+	legacy, _ := encryptSecret(sum[:], "hunter2")
+	if plain, err := b.open(legacy); err != nil || plain != "hunter2" {
+		t.Fatalf("legacy open = %q, %v", plain, err)
+	}
+}
+
+func TestProductionRefusesDefaultImapSecret(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Env = "production"
+	if err := validateServerConfig(cfg); !errors.Is(err, errDefaultImapSecret) {
+		t.Fatalf("err = %v, want errDefaultImapSecret", err)
+	}
+	cfg.ImapSecret = "s3cret"
+	if err := validateServerConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Env, cfg.ImapSecret = "", ""
+	if err := validateServerConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/emersion/go-imap"
+	"github.com/gin-gonic/gin"
+)
+
+// imapSyncedFolders are the mailboxes a sync caches.
+var imapSyncedFolders = []string{"INBOX"}
+
+// imapFolderSummary counts one mailbox. Source is "status" when the numbers
+// come from the server and "cache" when they come from synced messages.
+type imapFolderSummary struct {
+	Name   string `json:"name"`
+	Total  int    `json:"total"`
+	Unseen int    `json:"unseen"`
+	Source string `json:"source"`
+}
+
+// imapMailboxStatus asks the server for the message and unseen counts of
+// imapSyncedFolders.
+func imapMailboxStatus(acc imapAccount) ([]imapFolderSummary, error) {
+	c, err := dialImap(acc)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Logout()
+
+	if err := c.Login(acc.Username, acc.Password); err != nil {
+		return nil, err
+	}
+	var out []imapFolderSummary
+	for _, name := range imapSyncedFolders {
+		st, err := c.Status(name, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, imapFolderSummary{Name: name, Total: int(st.Messages), Unseen: int(st.Unseen), Source: "status"})
+	}
+	return out, nil
+}
+
+func (s *server) cachedMailboxSummary(ctx context.Context, accountID string) ([]imapFolderSummary, error) {
+	total, unseen, err := s.mail.ImapMailboxCounts(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return []imapFolderSummary{{Name: imapSyncedFolders[0], Total: total, Unseen: unseen, Source: "cache"}}, nil
+}
+
+// imapAccountSummary returns total and unseen counts per synced folder for
+// a mail badge. They come from the local cache unless ?live=1 asks the
+// server, which falls back to the cache, with the error, if it fails.
+func (s *server) imapAccountSummary(c *gin.Context) {
+	ctx := c.Request.Context()
+	acc, err := s.pickImapAccount(ctx, c.Param("id"))
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
+		return
+	}
+	if acc == nil {
+		respondError(c, http.StatusNotFound, errImapAccountNotFound)
+		return
+	}
+
+	var folders []imapFolderSummary
+	var liveErr error
+	if c.Query("live") == "1" {
+		liveErr = s.imapSync.run(ctx, acc.ID, func() error {
+			folders, err = imapMailboxStatus(*acc)
+			return err
+		})
+	}
+	if folders == nil {
+		if folders, err = s.cachedMailboxSummary(ctx, acc.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryImapAccountsFailed)
+			return
+		}
+	}
+
+	resp := gin.H{"accountId": acc.ID, "folders": folders}
+	var total, unseen int
+	for _, f := range folders {
+		total += f.Total
+		unseen += f.Unseen
+	}
+	resp["total"], resp["unseen"] = total, unseen
+	if liveErr != nil {
+		resp["error"] = liveErr.Error()
+	}
+	c.JSON(http.StatusOK, resp)
+}
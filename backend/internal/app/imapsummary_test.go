@@ -0,0 +1,32 @@
+package app
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/emersion/go-imap/backend/memory"
+	imapserver "github.com/emersion/go-imap/server"
+)
+
+func TestImapMailboxStatus(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := imapserver.New(memory.New())
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	folders, err := imapMailboxStatus(imapAccount{Host: host, Port: p, Username: "username", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := imapFolderSummary{Name: "INBOX", Total: 1, Unseen: 0, Source: "status"}
+	if len(folders) != 1 || folders[0] != want {
+		t.Fatalf("folders = %+v, want %+v", folders, want)
+	}
+}
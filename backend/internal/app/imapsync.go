@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// maxConcurrentImapSyncs caps IMAP connections opened for syncs and direct
+// message fetches across all accounts.
+const maxConcurrentImapSyncs = 4
+
+var errImapSyncBusy = errors.New("imap sync busy")
+
+// imapSyncLimiter keeps IMAP work from piling up: work on one account runs
+// one at a time, at most maxConcurrentImapSyncs run at once, and a
+// background sync requested while another is queued or running for the
+// same account is dropped, since that one will pick the new mail up. A nil
+// limiter runs everything directly.
+type imapSyncLimiter struct {
+	slots chan struct{}
+
+	mu      sync.Mutex
+	locks   map[string]chan struct{}
+	pending map[string]bool
+}
+
+func newImapSyncLimiter(n int) *imapSyncLimiter {
+	return &imapSyncLimiter{slots: make(chan struct{}, n), locks: map[string]chan struct{}{}, pending: map[string]bool{}}
+}
+
+func (l *imapSyncLimiter) lock(accountID string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lk, ok := l.locks[accountID]
+	if !ok {
+		lk = make(chan struct{}, 1)
+		l.locks[accountID] = lk
+	}
+	return lk
+}
+
+// run calls fn once it holds accountID and a global slot. Giving up on ctx
+// while waiting returns errImapSyncBusy.
+func (l *imapSyncLimiter) run(ctx context.Context, accountID string, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+	lk := l.lock(accountID)
+	select {
+	case lk <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", errImapSyncBusy, ctx.Err())
+	}
+	defer func() { <-lk }()
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", errImapSyncBusy, ctx.Err())
+	}
+	defer func() { <-l.slots }()
+	return fn()
+}
+
+// claimBackground reports whether a background sync for accountID should
+// start; pair a true result with releaseBackground.
+func (l *imapSyncLimiter) claimBackground(accountID string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending[accountID] {
+		return false
+	}
+	l.pending[accountID] = true
+	return true
+}
+
+func (l *imapSyncLimiter) releaseBackground(accountID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.pending, accountID)
+	l.mu.Unlock()
+}
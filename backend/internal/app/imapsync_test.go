@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestImapSyncLimiterSerializesAccount(t *testing.T) {
+	l := newImapSyncLimiter(4)
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.run(context.Background(), "acc", func() error {
+				n := running.Add(1)
+				if n > peak.Load() {
+					peak.Store(n)
+				}
+				time.Sleep(5 * time.Millisecond)
+				running.Add(-1)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if peak.Load() != 1 {
+		t.Fatalf("%d syncs of one account ran at once", peak.Load())
+	}
+}
+
+func TestImapSyncLimiterGlobalCap(t *testing.T) {
+	l := newImapSyncLimiter(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go l.run(context.Background(), "a", func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := l.run(ctx, "b", func() error { return nil })
+	close(release)
+	if !errors.Is(err, errImapSyncBusy) {
+		t.Fatalf("second account ran past the cap: %v", err)
+	}
+}
+
+func TestImapSyncLimiterCoalescesBackground(t *testing.T) {
+	l := newImapSyncLimiter(1)
+	if !l.claimBackground("acc") || l.claimBackground("acc") {
+		t.Fatal("second background sync for the account was not dropped")
+	}
+	if !l.claimBackground("other") {
+		t.Fatal("other accounts must not be affected")
+	}
+	l.releaseBackground("acc")
+	if !l.claimBackground("acc") {
+		t.Fatal("claim not released")
+	}
+	var nilLimiter *imapSyncLimiter
+	if !nilLimiter.claimBackground("acc") || nilLimiter.run(context.Background(), "acc", func() error { return nil }) != nil {
+		t.Fatal("nil limiter should run everything")
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/gin-gonic/gin"
+
+	"selfecho/backend/internal/store"
+)
+
+// mailRefs are the headers that link a message into a conversation, with
+// Message-IDs stored without angle brackets.
+type mailRefs struct {
+	MessageID  string
+	InReplyTo  string
+	References []string
+}
+
+// normalizeMsgID returns the first Message-ID in s, without brackets.
+func normalizeMsgID(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '<'); i >= 0 {
+		s = s[i+1:]
+		if j := strings.IndexByte(s, '>'); j >= 0 {
+			s = s[:j]
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func mailRefsOf(env *imap.Envelope, references []string) mailRefs {
+	r := mailRefs{References: []string{}}
+	if env != nil {
+		r.MessageID = normalizeMsgID(env.MessageId)
+		r.InReplyTo = normalizeMsgID(env.InReplyTo)
+	}
+	for _, id := range references {
+		if id = normalizeMsgID(id); id != "" {
+			r.References = append(r.References, id)
+		}
+	}
+	return r
+}
+
+// imapThreadKey names the conversation r starts or belongs to after its
+// root: the first reference, else the parent, else the message itself. It
+// is empty for a message without any Message-ID.
+func imapThreadKey(r mailRefs) string {
+	root := r.MessageID
+	if len(r.References) > 0 {
+		root = r.References[0]
+	} else if r.InReplyTo != "" {
+		root = r.InReplyTo
+	}
+	if root == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(root))
+	return hex.EncodeToString(sum[:8])
+}
+
+// resolveImapThread picks the thread for a message being cached: that of an
+// already cached parent or reference, so replies that cite only part of the
+// chain still join it, otherwise imapThreadKey.
+func resolveImapThread(ctx context.Context, q sqlQuerier, accountID string, r mailRefs) (string, error) {
+	parents := r.References
+	if r.InReplyTo != "" {
+		parents = append(parents[:len(parents):len(parents)], r.InReplyTo)
+	}
+	if len(parents) > 0 {
+		var thread string
+		err := q.QueryRowContext(ctx, `
+			SELECT thread_id FROM imap_messages
+			WHERE account_id=$1 AND message_id = ANY($2::text[]) AND thread_id IS NOT NULL
+			ORDER BY msg_date ASC NULLS LAST
+			LIMIT 1`, accountID, parents).Scan(&thread)
+		if err == nil {
+			return thread, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+	return imapThreadKey(r), nil
+}
+
+// imapThread is a conversation in the thread list.
+type imapThread struct {
+	ID         string `json:"id"`
+	Subject    string `json:"subject"`
+	From       string `json:"from"`
+	Snippet    string `json:"snippet"`
+	LatestUID  uint32 `json:"latestUid"`
+	LatestDate string `json:"latestDate"`
+	Count      int    `json:"count"`
+	Unseen     int    `json:"unseen"`
+}
+
+func (s *server) imapThreadFromStore(r store.ImapThread) imapThread {
+	t := imapThread{ID: r.ID, Subject: r.Subject, From: r.From, Snippet: r.Snippet, LatestUID: r.LatestUID, Count: r.Count, Unseen: r.Unseen}
+	if r.LatestDate != nil {
+		t.LatestDate = r.LatestDate.In(s.siteLocation()).Format(time.RFC3339)
+	}
+	return t
+}
+
+// listImapThreads pages through the cached conversations of ?accountId=,
+// newest activity first.
+func (s *server) listImapThreads(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := 12
+	if l, err := strconv.Atoi(strings.TrimSpace(c.Query("limit"))); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	page := 1
+	if p, err := strconv.Atoi(strings.TrimSpace(c.Query("page"))); err == nil && p > 0 {
+		page = p
+	}
+
+	acc, err := s.pickImapAccount(ctx, strings.TrimSpace(c.Query("accountId")))
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
+		return
+	}
+	if acc == nil {
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
+		return
+	}
+
+	rows, err := s.mail.ImapThreads(ctx, acc.ID, limit, (page-1)*limit)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
+		return
+	}
+	total, err := s.mail.CountImapThreads(ctx, acc.ID)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
+		return
+	}
+	items := make([]imapThread, 0, len(rows))
+	for _, r := range rows {
+		items = append(items, s.imapThreadFromStore(r))
+	}
+	respondPage(c, items, page, limit, total)
+}
+
+// getImapThread returns the messages of one conversation, oldest first.
+func (s *server) getImapThread(c *gin.Context) {
+	ctx := c.Request.Context()
+	acc, err := s.pickImapAccount(ctx, strings.TrimSpace(c.Query("accountId")))
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errImapAccountLookupFailed, err)
+		return
+	}
+	if acc == nil {
+		respondError(c, http.StatusBadRequest, errImapAccountNotFound)
+		return
+	}
+
+	rows, err := s.mail.ImapThread(ctx, acc.ID, c.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		respondError(c, http.StatusNotFound, errImapThreadNotFound)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errReadMessagesFailed, err)
+		return
+	}
+	msgs := make([]imapMessage, 0, len(rows))
+	for _, r := range rows {
+		msgs = append(msgs, s.imapMessageFromStore(r))
+	}
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "subject": msgs[0].Subject, "messages": msgs})
+}
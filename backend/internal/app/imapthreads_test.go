@@ -0,0 +1,49 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestMailRefsOf(t *testing.T) {
+	env := &imap.Envelope{MessageId: "<c@example.org>", InReplyTo: " <b@example.org> <x@example.org>"}
+	r := mailRefsOf(env, []string{"a@example.org", "<b@example.org>", ""})
+	if r.MessageID != "c@example.org" || r.InReplyTo != "b@example.org" ||
+		len(r.References) != 2 || r.References[0] != "a@example.org" || r.References[1] != "b@example.org" {
+		t.Fatalf("refs = %+v", r)
+	}
+	if r := mailRefsOf(nil, nil); r.MessageID != "" || r.References == nil {
+		t.Fatalf("refs of nothing = %+v", r)
+	}
+}
+
+func TestImapThreadKey(t *testing.T) {
+	root := imapThreadKey(mailRefs{MessageID: "a@example.org"})
+	if root == "" || len(root) != 16 {
+		t.Fatalf("root key = %q", root)
+	}
+	reply := imapThreadKey(mailRefs{MessageID: "b@example.org", InReplyTo: "a@example.org"})
+	deep := imapThreadKey(mailRefs{MessageID: "c@example.org", InReplyTo: "b@example.org", References: []string{"a@example.org", "b@example.org"}})
+	if reply != root || deep != root {
+		t.Fatalf("keys = %s %s %s, want one thread", root, reply, deep)
+	}
+	if other := imapThreadKey(mailRefs{MessageID: "z@example.org"}); other == root {
+		t.Fatal("unrelated message joined the thread")
+	}
+	if imapThreadKey(mailRefs{}) != "" {
+		t.Fatal("message without ids got a thread")
+	}
+}
+
+func TestParseBodyReferences(t *testing.T) {
+	msg := "References: <a@example.org>\r\n <b@example.org>\r\nContent-Type: text/plain\r\n\r\nhi\r\n"
+	b, err := parseBody(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.References) != 2 || b.References[0] != "a@example.org" || b.References[1] != "b@example.org" {
+		t.Fatalf("references = %v", b.References)
+	}
+}
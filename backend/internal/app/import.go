@@ -0,0 +1,316 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"selfecho/backend/internal/store"
+)
+
+const (
+	maxImportItems = 1000
+	maxImportBytes = 32 << 20
+	// importBatchSize articles share one transaction; a failed item only
+	// rolls back its own savepoint.
+	importBatchSize = 50
+)
+
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importFailed  = "failed"
+	// importSkipped marks posts of a foreign export that have no article
+	// counterpart, such as Ghost pages.
+	importSkipped = "skipped"
+)
+
+type importResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	// Source identifies the post in a foreign export (its slug or ID).
+	Source string  `json:"source,omitempty"`
+	ID     string  `json:"id,omitempty"`
+	Slug   string  `json:"slug,omitempty"`
+	Code   errCode `json:"code,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// readImportItems splits the request body into raw items: a JSON array, or
+// one object per line for application/x-ndjson. Items are decoded one by one
+// later so a malformed item fails alone.
+func readImportItems(r io.Reader, ndjson bool) ([]json.RawMessage, error) {
+	if !ndjson {
+		var items []json.RawMessage
+		if err := json.NewDecoder(r).Decode(&items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	var items []json.RawMessage
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxImportBytes)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+	}
+	return items, sc.Err()
+}
+
+// importArticles backs POST /api/articles/import. Each item is an article
+// payload as for POST /api/articles; with ?upsert=1 an item whose slug
+// already exists updates that article instead of creating a "-2" copy.
+// The response lists one result per item, in input order.
+func (s *server) importArticles(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	ct := c.ContentType()
+	items, err := readImportItems(c.Request.Body, strings.Contains(ct, "ndjson") || strings.Contains(ct, "jsonlines"))
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	if len(items) > maxImportItems {
+		respondErrorDetail(c, http.StatusRequestEntityTooLarge, errImportTooLarge, fmt.Errorf("%d > %d", len(items), maxImportItems))
+		return
+	}
+	upsert := c.Query("upsert") == "1" || strings.EqualFold(c.Query("upsert"), "true")
+	s.finishImport(c, s.runImport(c, items, upsert), nil)
+}
+
+// runImport imports items in batches on behalf of the request's user.
+func (s *server) runImport(c *gin.Context, items []json.RawMessage, upsert bool) []importResult {
+	u, _ := s.ensureUser(c)
+	lang := requestLanguage(c)
+	results := make([]importResult, len(items))
+	for start := 0; start < len(items); start += importBatchSize {
+		end := min(start+importBatchSize, len(items))
+		s.importBatch(c.Request.Context(), items[start:end], nil, results[start:end], start, upsert, *u, lang)
+	}
+	return results
+}
+
+// finishImport announces the imported articles and writes the response;
+// extra adds importer-specific fields to it.
+func (s *server) finishImport(c *gin.Context, results []importResult, extra gin.H) {
+	s.wakeOutbox()
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		switch r.Status {
+		case importCreated:
+			s.refreshSearchIndex(r.ID)
+			s.refreshLinkGraph(r.ID)
+			s.publishFrom(c, eventArticleChanged, actionCreated, r.ID, r.Slug)
+		case importUpdated:
+			s.refreshSearchIndex(r.ID)
+			s.refreshLinkGraph(r.ID)
+			s.publishFrom(c, eventArticleChanged, actionUpdated, r.ID, r.Slug)
+		}
+	}
+	out := gin.H{
+		"results": results,
+		"created": counts[importCreated],
+		"updated": counts[importUpdated],
+		"failed":  counts[importFailed],
+	}
+	if counts[importSkipped] > 0 {
+		out["skipped"] = counts[importSkipped]
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// importBatch imports items in one transaction and fills out. ids, when not
+// nil, holds the article each item was exported from ("" if unknown), which
+// upserts match before the slug. If the transaction itself fails, every item
+// of the batch is reported failed.
+func (s *server) importBatch(ctx context.Context, items []json.RawMessage, ids []string, out []importResult, offset int, upsert bool, u user, lang string) {
+	fail := func(i int, code errCode, err error) {
+		out[i] = importResult{Index: offset + i, Status: importFailed}
+		out[i].Code, out[i].Error = describeError(lang, code, err)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		for i := range items {
+			fail(i, errBeginTxFailed, err)
+		}
+		return
+	}
+	defer tx.Rollback()
+
+	for i, raw := range items {
+		var p articlePayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			fail(i, errInvalidBody, err)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT import_item`); err != nil {
+			fail(i, errCreateArticleFailed, err)
+			continue
+		}
+		var id string
+		if ids != nil {
+			id = ids[i]
+		}
+		r, err := s.importArticle(ctx, tx, p, id, upsert, u)
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT import_item`); rbErr != nil {
+				err = rbErr
+			}
+			fail(i, errCreateArticleFailed, err)
+			continue
+		}
+		r.Index = offset + i
+		out[i] = r
+	}
+	if err := tx.Commit(); err != nil {
+		for i := range items {
+			fail(i, errCommitFailed, err)
+		}
+	}
+}
+
+// importArticle writes one payload inside tx on behalf of u, mirroring
+// createArticle and, for upserts, updateArticle. An upsert updates article
+// fromID when it exists, renaming it to the payload's slug, and otherwise the
+// article holding that slug.
+func (s *server) importArticle(ctx context.Context, tx *sql.Tx, p articlePayload, fromID string, upsert bool, u user) (importResult, error) {
+	if p.Type == "" {
+		p.Type = "post"
+	}
+	if err := validatePayload(p); err != nil {
+		return importResult{}, err
+	}
+	slug, err := makeSlug(p.Title, p.Slug)
+	if err != nil {
+		return importResult{}, err
+	}
+
+	var existingID string
+	if upsert && store.ValidID(fromID) {
+		err := tx.QueryRowContext(ctx, `SELECT id FROM articles WHERE id=$1 FOR UPDATE`, fromID).Scan(&existingID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return importResult{}, err
+		}
+		if existingID != "" {
+			if slug, err = uniqueSlug(ctx, tx, slug, existingID); err != nil {
+				return importResult{}, err
+			}
+		}
+	}
+	if upsert && existingID == "" {
+		err := tx.QueryRowContext(ctx, `SELECT id FROM articles WHERE slug=$1 FOR UPDATE`, slug).Scan(&existingID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return importResult{}, err
+		}
+	}
+	if existingID != "" {
+		allowed, err := canEditArticle(ctx, tx, u, existingID)
+		if err != nil {
+			return importResult{}, err
+		}
+		if !allowed {
+			return importResult{}, newAPIError(errNotArticleAuthor)
+		}
+		l, err := s.activeLock(ctx, existingID, u.ID)
+		if err != nil {
+			return importResult{}, err
+		}
+		if l != nil && !l.Mine {
+			return importResult{}, newAPIError(errArticleLocked, l.Username)
+		}
+	}
+
+	var archiveID *string
+	if p.Archive != "" {
+		id, err := upsertArchive(ctx, tx, p.Archive)
+		if err != nil {
+			return importResult{}, err
+		}
+		archiveID = &id
+	}
+	publishedAt, err := resolvePublishedAt(p)
+	if err != nil {
+		return importResult{}, newAPIError(errInvalidPublishedAt, err.Error())
+	}
+	setTranslation, translationOf, err := s.translationOf(ctx, p, existingID)
+	if err != nil {
+		return importResult{}, err
+	}
+	if err := s.checkVisibility(ctx, p, existingID); err != nil {
+		return importResult{}, err
+	}
+	passHash, err := p.passwordHash()
+	if err != nil {
+		return importResult{}, err
+	}
+	bodyHTML := strings.TrimSpace(p.BodyHTML)
+	if bodyHTML == "" {
+		bodyHTML = renderMarkdown(p.BodyMD)
+	}
+	authors, err := payloadAuthors(ctx, tx, p, u.ID)
+	if err != nil {
+		return importResult{}, err
+	}
+
+	if existingID != "" {
+		body, err := s.updatedBody(ctx, tx, existingID, p.Status, p.Visibility, p.BodyMD, bodyHTML)
+		if err != nil {
+			return importResult{}, err
+		}
+		_, err = tx.ExecContext(ctx, articleUpdateSQL,
+			p.Title, slug, body.md, body.html, p.Status, archiveID, publishedAt, p.Type, existingID, p.description(), p.tags(),
+			p.lang(), setTranslation, translationOf, p.social(), p.Visibility, passHash, body.excerpt, p.meta(),
+		)
+		if err != nil {
+			return importResult{}, err
+		}
+		if p.Authors != nil {
+			if err := setArticleAuthors(ctx, tx, existingID, authors); err != nil {
+				return importResult{}, err
+			}
+		}
+		if err := s.enqueueWebhooks(ctx, tx, hookArticleUpdated, articleHook{ID: existingID, Slug: slug, Title: p.Title, Status: p.Status}); err != nil {
+			return importResult{}, err
+		}
+		return importResult{Status: importUpdated, ID: existingID, Slug: slug}, nil
+	}
+
+	slug, err = uniqueSlug(ctx, tx, slug, "")
+	if err != nil {
+		return importResult{}, err
+	}
+	body, err := s.storeBody(p.Status, p.Visibility, p.BodyMD, bodyHTML)
+	if err != nil {
+		return importResult{}, err
+	}
+	var id string
+	err = tx.QueryRowContext(ctx, articleInsertSQL,
+		slug, p.Title, body.md, body.html, p.Status, archiveID, publishedAt, p.Type, p.description(), p.tags(),
+		p.lang(), translationOf, p.social(), p.Visibility, passHash, body.excerpt, p.meta(), authors[0],
+	).Scan(&id)
+	if err != nil {
+		return importResult{}, err
+	}
+	if err := setArticleAuthors(ctx, tx, id, authors); err != nil {
+		return importResult{}, err
+	}
+	if err := s.enqueueWebhooks(ctx, tx, hookArticleCreated, articleHook{ID: id, Slug: slug, Title: p.Title, Status: p.Status}); err != nil {
+		return importResult{}, err
+	}
+	return importResult{Status: importCreated, ID: id, Slug: slug}, nil
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadImportItems(t *testing.T) {
+	items, err := readImportItems(strings.NewReader(`[{"title":"a"}, 3, {"title":"b"}]`), false)
+	if err != nil || len(items) != 3 || string(items[1]) != "3" {
+		t.Fatalf("array: %q %v", items, err)
+	}
+	items, err = readImportItems(strings.NewReader("{\"title\":\"a\"}\n\n  {\"title\":\"b\"}  \nnot json\n"), true)
+	if err != nil || len(items) != 3 || string(items[1]) != `{"title":"b"}` || string(items[2]) != "not json" {
+		t.Fatalf("ndjson: %q %v", items, err)
+	}
+	if _, err := readImportItems(strings.NewReader(`{"title":"a"}`), false); err == nil {
+		t.Fatal("a bare object is not an import array")
+	}
+}
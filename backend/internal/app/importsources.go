@@ -0,0 +1,679 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Importers for other blogging platforms' exports. Each turns its export into
+// article payloads and hands them to the regular import, after copying the
+// images they reference into <mediaDir>/imported.
+const (
+	maxImportArchiveBytes = 512 << 20
+	importedSubdir        = "imported"
+	// maxImportImages caps how many distinct images one import stores.
+	maxImportImages = 2000
+	// maxImportImageErrors caps how many image failures the response lists.
+	maxImportImageErrors = 50
+	ghostURLPlaceholder  = "__GHOST_URL__"
+)
+
+// sourcePost is one post read from a foreign export. Skip, when set, says
+// why it is not imported. Created, when set, replaces the article's creation
+// time, which is all a draft has to date it by.
+type sourcePost struct {
+	Source  string
+	Payload articlePayload
+	Skip    string
+	Created time.Time
+}
+
+// importSourcePosts imports posts through the regular import and reports
+// one result per post, in export order.
+func (s *server) importSourcePosts(c *gin.Context, posts []sourcePost, upsert bool, extra gin.H) {
+	var items []json.RawMessage
+	var index []int
+	results := make([]importResult, len(posts))
+	for i, p := range posts {
+		if p.Skip != "" {
+			results[i] = importResult{Index: i, Status: importSkipped, Source: p.Source, Error: p.Skip}
+			continue
+		}
+		raw, err := json.Marshal(p.Payload)
+		if err != nil {
+			results[i] = importResult{Index: i, Status: importFailed, Source: p.Source}
+			results[i].Code, results[i].Error = describeError(requestLanguage(c), errInvalidBody, err)
+			continue
+		}
+		items = append(items, raw)
+		index = append(index, i)
+	}
+	if len(items) > maxImportItems {
+		respondErrorDetail(c, http.StatusRequestEntityTooLarge, errImportTooLarge, fmt.Errorf("%d > %d", len(items), maxImportItems))
+		return
+	}
+	for j, r := range s.runImport(c, items, upsert) {
+		p := posts[index[j]]
+		r.Index = index[j]
+		r.Source = p.Source
+		results[index[j]] = r
+		if r.ID != "" && !p.Created.IsZero() {
+			if _, err := s.db.ExecContext(c.Request.Context(), `UPDATE articles SET created_at=$2 WHERE id=$1`, r.ID, p.Created); err != nil {
+				warnf("设置导入文章创建时间失败 %s: %v\n", r.ID, err)
+			}
+		}
+	}
+	s.finishImport(c, results, extra)
+}
+
+// spoolImportUpload copies the request body to a temporary file, since zip
+// archives need random access. done closes and removes it.
+func spoolImportUpload(c *gin.Context) (*os.File, int64, func(), error) {
+	f, err := os.CreateTemp("", "selfecho-import-")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	done := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	n, err := io.Copy(f, http.MaxBytesReader(c.Writer, c.Request.Body, maxImportArchiveBytes))
+	if err != nil {
+		done()
+		return nil, 0, nil, err
+	}
+	return f, n, done, nil
+}
+
+func isZip(r io.ReaderAt) bool {
+	magic := make([]byte, 4)
+	_, err := r.ReadAt(magic, 0)
+	return err == nil && string(magic) == "PK\x03\x04"
+}
+
+// readZipFile reads one archive member, refusing anything past limit so a
+// crafted archive can't expand without bound.
+func readZipFile(zf *zip.File, limit int64) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s 超过大小限制", zf.Name)
+	}
+	return data, nil
+}
+
+// parseImportDate accepts RFC 3339 and the "2006-01-02 15:04:05" (UTC) form
+// older exports use, and returns RFC 3339 for the payload.
+func parseImportDate(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC().Format(time.RFC3339)
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", raw); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	return raw
+}
+
+// importSiteParam reads ?site=, the old site's address, without a trailing
+// slash.
+func importSiteParam(c *gin.Context) (string, bool) {
+	site := strings.TrimRight(strings.TrimSpace(c.Query("site")), "/")
+	if site == "" {
+		return "", true
+	}
+	u, err := url.Parse(site)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	return site, true
+}
+
+var (
+	importImgRe     = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	importSrcRe     = regexp.MustCompile(`(?i)\ssrc\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	importSrcsetRe  = regexp.MustCompile(`(?i)\s(?:srcset|sizes)\s*=\s*(?:"[^"]*"|'[^']*')`)
+	pictureSourceRe = regexp.MustCompile(`(?i)<source\b[^>]*\ssrcset\s*=[^>]*>`)
+)
+
+// imageImporter copies the images of imported bodies into
+// <mediaDir>/imported, named by content hash so re-imports reuse them, and
+// points the <img> tags at the copies. Images it can't get keep their
+// original address.
+type imageImporter struct {
+	s   *server
+	ctx context.Context
+	dir string
+	// local returns the bytes of src when the export itself carries them.
+	local func(src string) ([]byte, bool)
+	// remote maps src to the URL to download it from, "" for none.
+	remote func(src string) string
+
+	done   map[string]string
+	saved  int
+	failed []string
+}
+
+// newImageImporter returns nil when there is no media directory to store
+// images in.
+func (s *server) newImageImporter(ctx context.Context) *imageImporter {
+	if s.mediaDir == "" {
+		return nil
+	}
+	if info, err := os.Stat(s.mediaDir); err != nil || !info.IsDir() {
+		return nil
+	}
+	dir := filepath.Join(s.mediaDir, importedSubdir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		warnf("创建导入图片目录失败: %v\n", err)
+		return nil
+	}
+	return &imageImporter{
+		s:      s,
+		ctx:    ctx,
+		dir:    dir,
+		local:  func(string) ([]byte, bool) { return nil, false },
+		remote: func(string) string { return "" },
+		done:   map[string]string{},
+	}
+}
+
+// rewrite localizes every <img> of body. Responsive variants (srcset and
+// <picture> sources) still point at the old site, so they are dropped.
+func (im *imageImporter) rewrite(body string) string {
+	body = importImgRe.ReplaceAllStringFunc(body, func(tag string) string {
+		m := importSrcRe.FindStringSubmatch(tag)
+		if m == nil {
+			return tag
+		}
+		local, ok := im.store(html.UnescapeString(m[1] + m[2]))
+		if !ok {
+			return tag
+		}
+		tag = strings.Replace(tag, m[0], ` src="`+html.EscapeString(local)+`"`, 1)
+		return importSrcsetRe.ReplaceAllString(tag, "")
+	})
+	return pictureSourceRe.ReplaceAllString(body, "")
+}
+
+// store returns the local URL for src, copying the image on first sight.
+func (im *imageImporter) store(src string) (string, bool) {
+	src = strings.TrimSpace(src)
+	if src == "" || strings.HasPrefix(src, "data:") {
+		return "", false
+	}
+	if u, ok := im.done[src]; ok {
+		return u, u != ""
+	}
+	im.done[src] = ""
+	data, ok := im.local(src)
+	if !ok {
+		remote := im.remote(src)
+		if remote == "" {
+			return "", false
+		}
+		if im.saved >= maxImportImages {
+			im.fail(src, errors.New("图片数量超过上限"))
+			return "", false
+		}
+		var err error
+		if data, _, err = im.s.fetchImage(im.ctx, remote); err != nil {
+			im.fail(src, err)
+			return "", false
+		}
+	}
+	ext, ok := galleryTypes[http.DetectContentType(data)]
+	if !ok {
+		im.fail(src, errors.New("不支持的图片格式"))
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:16]) + ext
+	file := filepath.Join(im.dir, name)
+	if _, err := os.Stat(file); err != nil {
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			im.fail(src, err)
+			return "", false
+		}
+	}
+	im.saved++
+	u := im.s.basePath + "/media/" + importedSubdir + "/" + name
+	im.done[src] = u
+	return u, true
+}
+
+func (im *imageImporter) fail(src string, err error) {
+	if len(im.failed) < maxImportImageErrors {
+		im.failed = append(im.failed, src+": "+err.Error())
+	}
+}
+
+// summary is the images part of the import response.
+func (im *imageImporter) summary() gin.H {
+	if im == nil {
+		return nil
+	}
+	out := gin.H{"images": im.saved}
+	if len(im.failed) > 0 {
+		out["imageErrors"] = im.failed
+	}
+	return out
+}
+
+// localizeBodies runs im over every post to be imported.
+func localizeBodies(im *imageImporter, posts []sourcePost) {
+	if im == nil {
+		return
+	}
+	for i := range posts {
+		if posts[i].Skip != "" {
+			continue
+		}
+		body := im.rewrite(posts[i].Payload.BodyHTML)
+		posts[i].Payload.BodyHTML = body
+		posts[i].Payload.BodyMD = body
+	}
+}
+
+func importOption(c *gin.Context, name string, def bool) bool {
+	switch strings.ToLower(c.Query(name)) {
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	return def
+}
+
+// --- Ghost ---
+
+type ghostPost struct {
+	ID              string  `json:"id"`
+	Title           string  `json:"title"`
+	Slug            string  `json:"slug"`
+	HTML            *string `json:"html"`
+	Plaintext       *string `json:"plaintext"`
+	FeatureImage    *string `json:"feature_image"`
+	Type            string  `json:"type"`
+	Status          string  `json:"status"`
+	Visibility      string  `json:"visibility"`
+	PublishedAt     *string `json:"published_at"`
+	CustomExcerpt   *string `json:"custom_excerpt"`
+	MetaDescription *string `json:"meta_description"`
+}
+
+type ghostData struct {
+	Data struct {
+		Posts []ghostPost `json:"posts"`
+		Tags  []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"tags"`
+		PostsTags []struct {
+			PostID    string `json:"post_id"`
+			TagID     string `json:"tag_id"`
+			SortOrder int    `json:"sort_order"`
+		} `json:"posts_tags"`
+	} `json:"data"`
+}
+
+// ghostExport is Ghost's "Export content" JSON. Current versions wrap the
+// data in db[0]; very old ones put it at the top level.
+type ghostExport struct {
+	DB []ghostData `json:"db"`
+	ghostData
+}
+
+// ghostPosts maps a Ghost JSON export to posts. Pages have no counterpart
+// here and are skipped; anything that isn't both published and public comes
+// in as a draft so members-only content doesn't go public by accident.
+func ghostPosts(data []byte) ([]sourcePost, error) {
+	var exp ghostExport
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return nil, err
+	}
+	d := exp.ghostData
+	if len(exp.DB) > 0 {
+		d = exp.DB[0]
+	}
+	if d.Data.Posts == nil {
+		return nil, errors.New("no posts in export")
+	}
+	tagNames := map[string]string{}
+	for _, t := range d.Data.Tags {
+		tagNames[t.ID] = t.Name
+	}
+	links := d.Data.PostsTags
+	sort.SliceStable(links, func(i, j int) bool { return links[i].SortOrder < links[j].SortOrder })
+	tags := map[string][]string{}
+	for _, l := range links {
+		if name := strings.TrimSpace(tagNames[l.TagID]); name != "" {
+			tags[l.PostID] = append(tags[l.PostID], name)
+		}
+	}
+
+	posts := make([]sourcePost, 0, len(d.Data.Posts))
+	for _, gp := range d.Data.Posts {
+		sp := sourcePost{Source: gp.Slug}
+		if sp.Source == "" {
+			sp.Source = gp.ID
+		}
+		if gp.Type == "page" {
+			sp.Skip = "Ghost page"
+			posts = append(posts, sp)
+			continue
+		}
+		body := ""
+		switch {
+		case gp.HTML != nil && strings.TrimSpace(*gp.HTML) != "":
+			body = *gp.HTML
+		case gp.Plaintext != nil:
+			body = renderMarkdown(*gp.Plaintext)
+		}
+		if gp.FeatureImage != nil && strings.TrimSpace(*gp.FeatureImage) != "" {
+			body = `<p><img src="` + html.EscapeString(*gp.FeatureImage) + `" alt=""></p>` + "\n" + body
+		}
+		p := articlePayload{
+			Title:    gp.Title,
+			Slug:     gp.Slug,
+			Type:     "post",
+			Status:   "draft",
+			BodyMD:   body,
+			BodyHTML: body,
+		}
+		if gp.Status == "published" && (gp.Visibility == "" || gp.Visibility == "public") {
+			p.Status = "published"
+		}
+		if gp.PublishedAt != nil {
+			p.PublishedAt = parseImportDate(*gp.PublishedAt)
+		}
+		for _, desc := range []*string{gp.CustomExcerpt, gp.MetaDescription} {
+			if desc != nil && strings.TrimSpace(*desc) != "" {
+				text := strings.TrimSpace(*desc)
+				p.Description = &text
+				break
+			}
+		}
+		if t, ok := tags[gp.ID]; ok {
+			p.Tags = &t
+		}
+		sp.Payload = p
+		posts = append(posts, sp)
+	}
+	return posts, nil
+}
+
+// ghostImagePath returns the path below content/images of a Ghost-hosted
+// image, with the resized "size/w600/" variants mapped to the original.
+func ghostImagePath(src string) (string, bool) {
+	_, rel, ok := strings.Cut(src, "/content/images/")
+	if !ok {
+		return "", false
+	}
+	rel, _, _ = strings.Cut(rel, "?")
+	if rest, ok := strings.CutPrefix(rel, "size/"); ok {
+		if _, after, ok := strings.Cut(rest, "/"); ok {
+			rel = after
+		}
+	}
+	rel, err := url.PathUnescape(rel)
+	if err != nil || rel == "" {
+		return "", false
+	}
+	return path.Clean(rel), true
+}
+
+// importGhost backs POST /api/articles/import/ghost. The body is the JSON
+// export, or a zip holding it together with the content/images folder.
+// ?site= is the old blog's address: it resolves __GHOST_URL__ links and
+// lets images missing from the upload be downloaded. ?images=0 leaves images
+// where they are; ?upsert=1 works as for the plain import.
+func (s *server) importGhost(c *gin.Context) {
+	site, ok := importSiteParam(c)
+	if !ok {
+		respondError(c, http.StatusBadRequest, errInvalidImportSite)
+		return
+	}
+	f, size, done, err := spoolImportUpload(c)
+	if err != nil {
+		respondErrorDetail(c, http.StatusRequestEntityTooLarge, errImportTooLarge, err)
+		return
+	}
+	defer done()
+
+	var data []byte
+	images := map[string]*zip.File{}
+	if isZip(f) {
+		zr, err := zip.NewReader(f, size)
+		if err != nil {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+			return
+		}
+		for _, zf := range zr.File {
+			// resized variants are found through their original
+			if zf.FileInfo().IsDir() || strings.Contains("/"+zf.Name, "/content/images/size/") {
+				continue
+			}
+			if rel, ok := ghostImagePath("/" + zf.Name); ok {
+				images[rel] = zf
+			} else if data == nil && strings.EqualFold(path.Ext(zf.Name), ".json") {
+				if data, err = readZipFile(zf, maxImportArchiveBytes); err != nil {
+					respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+					return
+				}
+			}
+		}
+	} else {
+		if data, err = io.ReadAll(io.NewSectionReader(f, 0, size)); err != nil {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+			return
+		}
+	}
+	if data == nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, errors.New("no JSON export in archive"))
+		return
+	}
+	posts, err := ghostPosts(data)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+		return
+	}
+
+	var im *imageImporter
+	if importOption(c, "images", true) {
+		im = s.newImageImporter(c.Request.Context())
+	}
+	if im != nil {
+		im.local = func(src string) ([]byte, bool) {
+			rel, ok := ghostImagePath(src)
+			if !ok {
+				return nil, false
+			}
+			zf, ok := images[rel]
+			if !ok {
+				return nil, false
+			}
+			data, err := readZipFile(zf, maxExportImageBytes)
+			if err != nil {
+				im.fail(src, err)
+				return nil, false
+			}
+			return data, true
+		}
+		im.remote = func(src string) string {
+			if rest, ok := strings.CutPrefix(src, ghostURLPlaceholder); ok {
+				if site == "" {
+					return ""
+				}
+				return site + rest
+			}
+			if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+				return src
+			}
+			return ""
+		}
+	}
+	localizeBodies(im, posts)
+	for i := range posts {
+		p := &posts[i].Payload
+		p.BodyHTML = strings.ReplaceAll(p.BodyHTML, ghostURLPlaceholder, site)
+		p.BodyMD = strings.ReplaceAll(p.BodyMD, ghostURLPlaceholder, site)
+	}
+	s.importSourcePosts(c, posts, importOption(c, "upsert", false), im.summary())
+}
+
+// --- Substack ---
+
+// substackPosts maps a Substack export (posts.csv plus posts/<id>.html) to
+// posts. Posts that aren't published to everyone come in as drafts; rows
+// without an HTML file, such as threads, are skipped.
+func substackPosts(zr *zip.Reader) ([]sourcePost, error) {
+	var index *zip.File
+	bodies := map[string]*zip.File{}
+	for _, zf := range zr.File {
+		base := path.Base(zf.Name)
+		switch {
+		case zf.FileInfo().IsDir():
+		case base == "posts.csv":
+			index = zf
+		case strings.HasSuffix(base, ".html"):
+			bodies[strings.TrimSuffix(base, ".html")] = zf
+		}
+	}
+	if index == nil {
+		return nil, errors.New("posts.csv not found")
+	}
+	data, err := readZipFile(index, maxImportBytes)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("posts.csv is empty")
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[strings.TrimSpace(name)] = i
+	}
+	if _, ok := col["post_id"]; !ok {
+		return nil, errors.New("posts.csv has no post_id column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	posts := make([]sourcePost, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		id := field(row, "post_id")
+		sp := sourcePost{Source: id}
+		zf, ok := bodies[id]
+		if !ok {
+			sp.Skip = "no HTML body in export"
+			posts = append(posts, sp)
+			continue
+		}
+		body, err := readZipFile(zf, maxImportBytes)
+		if err != nil {
+			return nil, err
+		}
+		// post_id is "<number>.<slug>"
+		_, slug, _ := strings.Cut(id, ".")
+		p := articlePayload{
+			Title:    field(row, "title"),
+			Slug:     slug,
+			Type:     "post",
+			Status:   "draft",
+			BodyMD:   string(body),
+			BodyHTML: string(body),
+		}
+		audience := field(row, "audience")
+		if strings.EqualFold(field(row, "is_published"), "true") && (audience == "" || audience == "everyone") {
+			p.Status = "published"
+		}
+		p.PublishedAt = parseImportDate(field(row, "post_date"))
+		if sub := field(row, "subtitle"); sub != "" {
+			p.Description = &sub
+		}
+		sp.Payload = p
+		posts = append(posts, sp)
+	}
+	return posts, nil
+}
+
+// importSubstack backs POST /api/articles/import/substack. The body is the
+// zip Substack exports; its images are hosted on Substack's CDN and get
+// downloaded unless ?images=0.
+func (s *server) importSubstack(c *gin.Context) {
+	f, size, done, err := spoolImportUpload(c)
+	if err != nil {
+		respondErrorDetail(c, http.StatusRequestEntityTooLarge, errImportTooLarge, err)
+		return
+	}
+	defer done()
+	if !isZip(f) {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, errors.New("not a zip archive"))
+		return
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+		return
+	}
+	posts, err := substackPosts(zr)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+		return
+	}
+	var im *imageImporter
+	if importOption(c, "images", true) {
+		im = s.newImageImporter(c.Request.Context())
+	}
+	if im != nil {
+		im.remote = func(src string) string {
+			if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+				return src
+			}
+			return ""
+		}
+	}
+	localizeBodies(im, posts)
+	s.importSourcePosts(c, posts, importOption(c, "upsert", false), im.summary())
+}
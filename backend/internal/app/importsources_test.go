@@ -0,0 +1,151 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func zipOf(t *testing.T, files map[string][]byte) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestGhostPosts(t *testing.T) {
+	export := `{"db":[{"data":{
+		"posts":[
+			{"id":"1","title":"Hello","slug":"hello","html":"<p>Hi</p>","feature_image":"__GHOST_URL__/content/images/a.png","type":"post","status":"published","visibility":"public","published_at":"2021-05-06T07:08:09.000Z","custom_excerpt":"Greeting"},
+			{"id":"2","title":"Paid","slug":"paid","html":"<p>$</p>","type":"post","status":"published","visibility":"paid","published_at":"2021-05-07 10:00:00"},
+			{"id":"3","title":"About","slug":"about","html":"<p>me</p>","type":"page","status":"published"}
+		],
+		"tags":[{"id":"t1","name":"go"},{"id":"t2","name":"life"}],
+		"posts_tags":[{"post_id":"1","tag_id":"t2","sort_order":1},{"post_id":"1","tag_id":"t1","sort_order":0}]
+	}}]}`
+	posts, err := ghostPosts([]byte(export))
+	if err != nil || len(posts) != 3 {
+		t.Fatalf("posts = %+v, %v", posts, err)
+	}
+	p := posts[0].Payload
+	if p.Status != "published" || p.PublishedAt != "2021-05-06T07:08:09Z" || *p.Description != "Greeting" {
+		t.Fatalf("hello = %+v", p)
+	}
+	if p.Tags == nil || strings.Join(*p.Tags, ",") != "go,life" {
+		t.Fatalf("tags = %v", p.Tags)
+	}
+	if !strings.HasPrefix(p.BodyHTML, `<p><img src="__GHOST_URL__/content/images/a.png"`) {
+		t.Fatalf("feature image missing: %q", p.BodyHTML)
+	}
+	if posts[1].Payload.Status != "draft" || posts[1].Payload.PublishedAt != "2021-05-07T10:00:00Z" {
+		t.Fatalf("paid post should come in as a draft: %+v", posts[1].Payload)
+	}
+	if posts[2].Skip == "" || posts[2].Source != "about" {
+		t.Fatalf("page should be skipped: %+v", posts[2])
+	}
+	if _, err := ghostPosts([]byte(`{"db":[]}`)); err == nil {
+		t.Fatal("an export without posts should be rejected")
+	}
+}
+
+func TestGhostImagePath(t *testing.T) {
+	for src, want := range map[string]string{
+		"__GHOST_URL__/content/images/2020/01/a.jpg":          "2020/01/a.jpg",
+		"https://blog.example/content/images/size/w600/b.png": "b.png",
+		"/content/images/c%20d.png?v=1":                       "c d.png",
+		"https://cdn.example/e.png":                           "",
+	} {
+		got, ok := ghostImagePath(src)
+		if got != want || ok != (want != "") {
+			t.Errorf("ghostImagePath(%q) = %q, %v", src, got, ok)
+		}
+	}
+}
+
+func TestSubstackPosts(t *testing.T) {
+	csv := "\ufeffpost_id,post_date,is_published,type,audience,title,subtitle\n" +
+		"101.first-post,2022-02-03T04:05:06.000Z,true,newsletter,everyone,First post,Sub\n" +
+		"102.paid,2022-03-01T00:00:00.000Z,true,newsletter,only_paid,Paid,\n" +
+		"103,2022-04-01T00:00:00.000Z,true,thread,everyone,,\n"
+	zr := zipOf(t, map[string][]byte{
+		"export/posts.csv":                 []byte(csv),
+		"export/posts/101.first-post.html": []byte("<p>one</p>"),
+		"export/posts/102.paid.html":       []byte("<p>two</p>"),
+	})
+	posts, err := substackPosts(zr)
+	if err != nil || len(posts) != 3 {
+		t.Fatalf("posts = %+v, %v", posts, err)
+	}
+	p := posts[0].Payload
+	if p.Slug != "first-post" || p.Title != "First post" || p.Status != "published" || p.PublishedAt != "2022-02-03T04:05:06Z" || *p.Description != "Sub" || p.BodyHTML != "<p>one</p>" {
+		t.Fatalf("first = %+v", p)
+	}
+	if posts[1].Payload.Status != "draft" {
+		t.Fatalf("paid post should come in as a draft: %+v", posts[1].Payload)
+	}
+	if posts[2].Skip == "" {
+		t.Fatalf("thread without body should be skipped: %+v", posts[2])
+	}
+	if _, err := substackPosts(zipOf(t, map[string][]byte{"x.txt": nil})); err == nil {
+		t.Fatal("an archive without posts.csv should be rejected")
+	}
+}
+
+func TestImageImporterRewrite(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{mediaDir: t.TempDir(), basePath: "/blog"}
+	im := s.newImageImporter(context.Background())
+	im.local = func(src string) ([]byte, bool) {
+		if src == "local.png" {
+			return img.Bytes(), true
+		}
+		if src == "text.png" {
+			return []byte("not an image"), true
+		}
+		return nil, false
+	}
+
+	body := `<picture><source type="image/webp" srcset="x.webp 1x"><img src="local.png" srcset="local.png 2x" alt="a"></picture>` +
+		`<img src='local.png'><img src="text.png"><img src="https://elsewhere/x.png">`
+	out := im.rewrite(body)
+	if strings.Contains(out, "<source") || strings.Contains(out, "srcset") {
+		t.Fatalf("responsive variants kept: %s", out)
+	}
+	if strings.Count(out, `src="/blog/media/imported/`) != 2 || !strings.Contains(out, `src="text.png"`) || !strings.Contains(out, `src="https://elsewhere/x.png"`) {
+		t.Fatalf("rewrite = %s", out)
+	}
+	if im.saved != 1 || len(im.failed) != 1 {
+		t.Fatalf("saved %d, failed %v", im.saved, im.failed)
+	}
+	files, _ := os.ReadDir(filepath.Join(s.mediaDir, importedSubdir))
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), ".png") {
+		t.Fatalf("stored files = %v", files)
+	}
+
+	if (&server{}).newImageImporter(context.Background()) != nil {
+		t.Fatal("no media dir, no importer")
+	}
+}
@@ -0,0 +1,1284 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntegrationAuthFlow(t *testing.T) {
+	a := newTestApp(t)
+	a.expect(a.do(http.MethodGet, "/api/auth/me", nil), http.StatusUnauthorized)
+	a.expect(a.do(http.MethodPost, "/api/auth/login", map[string]string{
+		"username": testAdminUser, "password": "wrong",
+	}), http.StatusUnauthorized)
+	a.expect(a.do(http.MethodPost, "/api/articles", map[string]string{"title": "x"}), http.StatusUnauthorized)
+
+	a.login()
+	if body := a.expect(a.do(http.MethodGet, "/api/auth/me", nil), http.StatusOK); !strings.Contains(body, testAdminUser) {
+		t.Fatalf("me: %s", body)
+	}
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodGet, "/api/auth/me", nil), http.StatusUnauthorized)
+}
+
+func TestIntegrationArticleCRUD(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	id := a.seedPost("Hello", "hello", "# Hello\n\nfirst post")
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=hello", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != id || !strings.Contains(list[0].BodyHTML, "first post") {
+		t.Fatalf("list after create: %+v", list)
+	}
+
+	a.expect(a.do(http.MethodPut, "/api/articles/"+id, map[string]string{
+		"title": "Hello again", "slug": "hello", "bodyMd": "edited", "status": "published", "archive": "notes",
+	}), http.StatusNoContent)
+	list = nil
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=hello", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Title != "Hello again" {
+		t.Fatalf("list after update: %+v", list)
+	}
+
+	var archives []archive
+	a.decode(a.do(http.MethodGet, "/api/archives", nil), http.StatusOK, &archives)
+	if len(archives) != 1 || archives[0].Name != "notes" || archives[0].ItemCount != 1 {
+		t.Fatalf("archives: %+v", archives)
+	}
+
+	a.expect(a.do(http.MethodDelete, "/api/articles/"+id, nil), http.StatusNoContent)
+	list = nil
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=hello", nil), http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("list after delete: %+v", list)
+	}
+}
+
+func TestIntegrationSSRCacheHeaders(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Cached", "cached", "body")
+
+	first := a.do(http.MethodGet, "/post/cached", nil)
+	a.expect(first, http.StatusOK)
+	if got := first.Header.Get("X-SSR-Cache"); got != "miss" {
+		t.Fatalf("first render: X-SSR-Cache=%q", got)
+	}
+	second := a.do(http.MethodGet, "/post/cached", nil)
+	body := a.expect(second, http.StatusOK)
+	if got := second.Header.Get("X-SSR-Cache"); got != "hit" {
+		t.Fatalf("second render: X-SSR-Cache=%q", got)
+	}
+	if !strings.Contains(body, "Cached") {
+		t.Fatalf("cached page lost its content: %s", body)
+	}
+
+	// any write drops the page cache
+	a.seedPost("Other", "other", "body")
+	if got := a.do(http.MethodGet, "/post/cached", nil).Header.Get("X-SSR-Cache"); got != "miss" {
+		t.Fatalf("after write: X-SSR-Cache=%q", got)
+	}
+}
+
+func TestIntegrationSEOHandlers(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Sitemapped", "sitemapped", "body")
+
+	if body := a.expect(a.do(http.MethodGet, "/sitemap.xml", nil), http.StatusOK); !strings.Contains(body, "/post/sitemapped") {
+		t.Fatalf("sitemap misses the post: %s", body)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/robots.txt", nil), http.StatusOK); !strings.Contains(body, "Sitemap:") {
+		t.Fatalf("robots.txt: %s", body)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/", nil), http.StatusOK); !strings.Contains(body, "Sitemapped") {
+		t.Fatalf("home misses the post: %s", body)
+	}
+	a.expect(a.do(http.MethodGet, "/post/missing", nil), http.StatusNotFound)
+	if body := a.expect(a.do(http.MethodGet, "/category/notes/feed.xml", nil), http.StatusOK); !strings.Contains(body, "Sitemapped") {
+		t.Fatalf("category feed: %s", body)
+	}
+}
+
+func TestIntegrationIdempotencyKey(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	post := func(key, title string) *http.Response {
+		return a.doRaw(http.MethodPost, "/api/articles", "application/json",
+			`{"title":"`+title+`","bodyMd":"x","status":"draft"}`, idempotencyHeader, key)
+	}
+	first := a.expect(post("k1", "Once"), http.StatusCreated)
+	replay := post("k1", "Once")
+	if body := a.expect(replay, http.StatusCreated); body != first || replay.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay = %s (%v), want %s", body, replay.Header, first)
+	}
+	a.expect(post("k1", "Different"), http.StatusUnprocessableEntity)
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=draft", nil), http.StatusOK, &list)
+	if len(list) != 1 {
+		t.Fatalf("retry created %d articles", len(list))
+	}
+}
+
+func TestIntegrationImportArticles(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Existing", "existing", "old body")
+
+	type result struct {
+		Results []importResult
+		Created int
+		Updated int
+		Failed  int
+	}
+	var got result
+	a.decode(a.do(http.MethodPost, "/api/articles/import", []map[string]string{
+		{"title": "One", "slug": "one", "bodyMd": "1", "status": "published"},
+		{"title": "", "bodyMd": "no title", "status": "published"},
+		{"title": "Two", "slug": "one", "bodyMd": "2", "status": "draft"},
+	}), http.StatusOK, &got)
+	if got.Created != 2 || got.Failed != 1 || got.Results[1].Code != errTitleRequired || got.Results[2].Slug != "one-2" {
+		t.Fatalf("import: %+v", got)
+	}
+
+	got = result{}
+	a.decode(a.doRaw(http.MethodPost, "/api/articles/import?upsert=1", "application/x-ndjson",
+		`{"title":"Existing","slug":"existing","bodyMd":"new body","status":"published"}`+"\n"),
+		http.StatusOK, &got)
+	if got.Updated != 1 || got.Results[0].Status != importUpdated {
+		t.Fatalf("upsert: %+v", got)
+	}
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=existing", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].BodyMD != "new body" {
+		t.Fatalf("upserted article: %+v", list)
+	}
+}
+
+func TestIntegrationImportGhost(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.s.mediaDir = t.TempDir()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	export := `{"db":[{"data":{"posts":[
+		{"id":"1","title":"From Ghost","slug":"from-ghost","html":"<p><img src=\"__GHOST_URL__/content/images/2020/01/a.png\"></p><p><a href=\"__GHOST_URL__/other/\">x</a></p>","type":"post","status":"published","visibility":"public","published_at":"2020-01-02T03:04:05.000Z"},
+		{"id":"2","title":"About","slug":"about","html":"<p>me</p>","type":"page","status":"published"}
+	]}}]}`
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	for name, data := range map[string][]byte{
+		"blog.ghost.json":              []byte(export),
+		"content/images/2020/01/a.png": img.Bytes(),
+	} {
+		f, _ := w.Create(name)
+		f.Write(data)
+	}
+	w.Close()
+
+	a.expect(a.doRaw(http.MethodPost, "/api/articles/import/ghost?site=ftp://old", "application/zip", archive.String()), http.StatusBadRequest)
+	var got struct {
+		Results []importResult
+		Created int
+		Skipped int
+		Images  int
+	}
+	a.decode(a.doRaw(http.MethodPost, "/api/articles/import/ghost?site=https://old.example/", "application/zip", archive.String()), http.StatusOK, &got)
+	if got.Created != 1 || got.Skipped != 1 || got.Images != 1 || got.Results[0].Source != "from-ghost" || got.Results[1].Status != importSkipped {
+		t.Fatalf("import: %+v", got)
+	}
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=from-ghost", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].PublishedAt == nil || !list[0].PublishedAt.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("imported article: %+v", list)
+	}
+	if !strings.Contains(list[0].BodyHTML, `src="/media/imported/`) || !strings.Contains(list[0].BodyHTML, `href="https://old.example/other/"`) {
+		t.Fatalf("imported body: %s", list[0].BodyHTML)
+	}
+
+	a.expect(a.doRaw(http.MethodPost, "/api/articles/import/substack", "application/json", export), http.StatusBadRequest)
+}
+
+func TestIntegrationImportJournal(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.s.mediaDir = t.TempDir()
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	journal := `{"entries":[{"uuid":"AAAABBBBCCCC","creationDate":"2019-07-08T06:00:00Z","text":"# Lake\n\n![](dayone-moment://P1)","location":{"placeName":"Lakeside"},"photos":[{"identifier":"P1","md5":"d41d8","type":"png"}]}]}`
+	var archive bytes.Buffer
+	w := zip.NewWriter(&archive)
+	for name, data := range map[string][]byte{
+		"Journal.json":     []byte(journal),
+		"photos/d41d8.png": img.Bytes(),
+	} {
+		f, _ := w.Create(name)
+		f.Write(data)
+	}
+	w.Close()
+
+	var got struct {
+		Results []importResult
+		Created int
+		Images  int
+	}
+	a.decode(a.doRaw(http.MethodPost, "/api/articles/import/journal", "application/zip", archive.String()), http.StatusOK, &got)
+	if got.Created != 1 || got.Images != 1 {
+		t.Fatalf("import: %+v", got)
+	}
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug="+got.Results[0].Slug, nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Status != "draft" || list[0].Title != "Lake" || !list[0].CreatedAt.Equal(time.Date(2019, 7, 8, 6, 0, 0, 0, time.UTC)) {
+		t.Fatalf("imported entry: %+v", list)
+	}
+	if !strings.Contains(list[0].BodyMD, "](/media/imported/") || !strings.Contains(string(list[0].Meta["location"]), "Lakeside") {
+		t.Fatalf("imported entry body/meta: %q %s", list[0].BodyMD, list[0].Meta["location"])
+	}
+
+	a.decode(a.doRaw(http.MethodPost, "/api/articles/import/journal?upsert=1", "application/json", journal), http.StatusOK, &got)
+	if len(got.Results) != 1 || got.Results[0].Status != importUpdated {
+		t.Fatalf("re-import: %+v", got)
+	}
+}
+
+func TestIntegrationMalformedIDs(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	id := a.seedPost("Real", "real", "body")
+	for _, req := range []struct{ method, path string }{
+		{http.MethodDelete, "/api/archives/not-a-uuid"},
+		{http.MethodPut, "/api/articles/42"},
+		{http.MethodGet, "/api/articles/42/export"},
+		{http.MethodGet, "/api/articles/42/previews"},
+		{http.MethodDelete, "/api/articles/" + id + "/previews/42"},
+		{http.MethodGet, "/api/admin/jobs/abc"},
+		{http.MethodDelete, "/api/templates/x"},
+		{http.MethodGet, "/files/x/y.pdf"},
+	} {
+		a.expect(a.do(req.method, req.path, map[string]any{"title": "t"}), http.StatusNotFound)
+	}
+}
+
+func TestIntegrationAdminStorage(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.s.mediaDir = t.TempDir()
+	os.WriteFile(filepath.Join(a.s.mediaDir, "a.png"), make([]byte, 100), 0o644)
+
+	var got storageReport
+	a.decode(a.do(http.MethodGet, "/api/admin/storage", nil), http.StatusOK, &got)
+	if got.Database.Bytes <= 0 || got.Disk.TotalBytes == 0 {
+		t.Fatalf("report: %+v", got)
+	}
+	var articles bool
+	for _, tb := range got.Tables {
+		articles = articles || tb.Name == "articles" && tb.TotalBytes > 0
+	}
+	if !articles {
+		t.Fatalf("articles table missing: %+v", got.Tables)
+	}
+	if len(got.Directories) == 0 || got.Directories[0].Name != "media" || got.Directories[0].Bytes != 100 {
+		t.Fatalf("directories: %+v", got.Directories)
+	}
+}
+
+func TestIntegrationGitSync(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	a.s.git = newGitMirror(filepath.Join(dir, "config.yaml"), gitSyncConfig{Enabled: true, Dir: "mirror"})
+	id := a.seedPost("Mirrored", "mirrored", "first")
+
+	ctx := context.Background()
+	if err := a.s.runGitSyncJob(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(a.s.git.root(), "mirrored.md")
+	data, err := os.ReadFile(file)
+	if err != nil || !strings.Contains(string(data), "id: \""+id+"\"") {
+		t.Fatalf("mirror file: %s %v", data, err)
+	}
+
+	// an edit made in the repository renames the article and replaces its body
+	edited := strings.Replace(strings.Replace(string(data), "slug: mirrored", "slug: renamed", 1), "first", "edited in git", 1)
+	os.Remove(file)
+	os.WriteFile(filepath.Join(a.s.git.root(), "renamed.md"), []byte(edited), 0o644)
+	if _, err := a.s.git.run(ctx, "add", "--all"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.s.git.run(ctx, "commit", "--quiet", "-m", "edit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.s.runGitSyncJob(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=renamed", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != id || list[0].BodyMD != "edited in git" {
+		t.Fatalf("after pull: %+v", list)
+	}
+
+	// a rename whose import fails leaves the article where it was
+	broken := strings.Replace(strings.Replace(edited, "slug: renamed", "slug: broken", 1), "title: Mirrored", "title: \"\"", 1)
+	os.Remove(filepath.Join(a.s.git.root(), "renamed.md"))
+	os.WriteFile(filepath.Join(a.s.git.root(), "broken.md"), []byte(broken), 0o644)
+	a.s.git.run(ctx, "add", "--all")
+	a.s.git.run(ctx, "commit", "--quiet", "-m", "break")
+	if err := a.s.runGitSyncJob(ctx, nil); !errors.Is(err, errJobPermanent) {
+		t.Fatalf("failed import: %v", err)
+	}
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=renamed", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != id {
+		t.Fatalf("after failed rename: %+v", list)
+	}
+
+	// with encryption on, drafts stay out of the repository
+	a.s.content = newContentCipher(make([]byte, 32))
+	a.expect(a.do(http.MethodPost, "/api/articles", map[string]string{
+		"title": "Secret", "slug": "secret", "bodyMd": "draft body", "status": "draft", "archive": "notes",
+	}), http.StatusCreated)
+	if err := a.s.runGitSyncJob(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(a.s.git.root(), "secret.md")); !os.IsNotExist(err) {
+		t.Fatalf("draft was mirrored: %v", err)
+	}
+}
+
+func TestIntegrationDuplicateArticle(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	id := a.seedPost("Weekly notes", "weekly", "## Links")
+
+	var dup struct{ ID, Slug string }
+	a.decode(a.do(http.MethodPost, "/api/articles/"+id+"/duplicate", nil), http.StatusCreated, &dup)
+	if dup.Slug != "weekly-copy" || dup.ID == id {
+		t.Fatalf("first copy: %+v", dup)
+	}
+	a.decode(a.do(http.MethodPost, "/api/articles/"+id+"/duplicate", nil), http.StatusCreated, &dup)
+	if dup.Slug != "weekly-copy-2" {
+		t.Fatalf("second copy: %+v", dup)
+	}
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug=weekly-copy", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Status != "draft" || list[0].PublishedAt != nil || list[0].Archive != "notes" || list[0].BodyMD != "## Links" {
+		t.Fatalf("copy: %+v", list)
+	}
+	a.expect(a.do(http.MethodPost, "/api/articles/00000000-0000-0000-0000-000000000000/duplicate", nil), http.StatusNotFound)
+}
+
+func TestIntegrationArticleFromTemplate(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	var tpl struct{ ID string }
+	a.decode(a.do(http.MethodPost, "/api/templates", map[string]any{
+		"name": "Weekly", "title": "Weekly {{year}}-W{{week}}", "bodyMd": "# {{title}}\n{{mood}}", "archive": "notes", "tags": []string{"weekly"},
+	}), http.StatusCreated, &tpl)
+
+	var created struct{ ID, Slug string }
+	a.decode(a.do(http.MethodPost, "/api/articles/from-template/"+tpl.ID, map[string]any{
+		"title": "Week one", "vars": map[string]string{"mood": "calm"},
+	}), http.StatusCreated, &created)
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?slug="+created.Slug, nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Status != "draft" || list[0].BodyMD != "# Week one\ncalm" || list[0].Archive != "notes" {
+		t.Fatalf("from template: %+v", list)
+	}
+
+	a.expect(a.do(http.MethodDelete, "/api/templates/"+tpl.ID, nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodPost, "/api/articles/from-template/"+tpl.ID, nil), http.StatusNotFound)
+}
+
+func TestIntegrationCustomFields(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	id := a.seedPost("Hike", "hike", "up")
+	a.seedPost("Nap", "nap", "zzz")
+
+	a.expect(a.do(http.MethodPut, "/api/articles/"+id, map[string]any{
+		"title": "Hike", "slug": "hike", "bodyMd": "up", "status": "published", "archive": "notes",
+		"meta": map[string]any{"location": "Tromsø", "stars": 4},
+	}), http.StatusNoContent)
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=published&meta.stars=4", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Slug != "hike" || string(list[0].Meta["location"]) != `"Tromsø"` {
+		t.Fatalf("meta filter: %+v", list)
+	}
+	a.expect(a.do(http.MethodGet, "/api/articles?status=published&meta.bad%20key=1", nil), http.StatusBadRequest)
+}
+
+func TestIntegrationAuthors(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Signed", "signed", "body")
+
+	a.expect(a.do(http.MethodPut, "/api/authors/me", map[string]string{
+		"displayName": "The Admin", "bio": "Writes things.",
+	}), http.StatusNoContent)
+
+	var authors []author
+	a.decode(a.do(http.MethodGet, "/api/authors", nil), http.StatusOK, &authors)
+	if len(authors) != 1 || authors[0].Username != testAdminUser || authors[0].DisplayName != "The Admin" || authors[0].PostCount != 1 {
+		t.Fatalf("authors: %+v", authors)
+	}
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=published&author="+testAdminUser, nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Author == nil || list[0].Author.DisplayName != "The Admin" {
+		t.Fatalf("author filter: %+v", list)
+	}
+	a.decode(a.do(http.MethodGet, "/api/articles?status=published&author=nobody", nil), http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("unknown author matched: %+v", list)
+	}
+
+	body := a.expect(a.do(http.MethodGet, "/author/"+testAdminUser, nil), http.StatusOK)
+	if !strings.Contains(body, "/post/signed") || !strings.Contains(body, `"@type":"Person"`) {
+		t.Fatalf("author page: %s", body)
+	}
+	a.expect(a.do(http.MethodGet, "/author/nobody", nil), http.StatusNotFound)
+}
+
+func TestIntegrationCoauthors(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	for _, name := range []string{"bob", "eve"} {
+		if err := a.s.createUser(ctx, name, "secret-"+name, "author"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.login()
+	var created struct{ ID, Slug string }
+	a.decode(a.do(http.MethodPost, "/api/articles", map[string]any{
+		"title": "Joint", "slug": "joint", "bodyMd": "body", "status": "published", "authors": []string{testAdminUser, "bob"},
+	}), http.StatusCreated, &created)
+	a.expect(a.do(http.MethodPost, "/api/articles", map[string]any{
+		"title": "Ghost", "bodyMd": "body", "status": "draft", "authors": []string{"nobody"},
+	}), http.StatusBadRequest)
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=published&author=bob", nil), http.StatusOK, &list)
+	if len(list) != 1 || len(list[0].Authors) != 2 || list[0].Authors[1].Username != "bob" || list[0].Author.Username != testAdminUser {
+		t.Fatalf("co-authored list: %+v", list)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/post/joint", nil), http.StatusOK); !strings.Contains(body, `/author/bob"`) {
+		t.Fatalf("post page misses the byline: %s", body)
+	}
+
+	update := map[string]any{"title": "Joint", "slug": "joint", "bodyMd": "edited", "status": "published"}
+	for _, tc := range []struct {
+		user   string
+		status int
+	}{{"eve", http.StatusForbidden}, {"bob", http.StatusNoContent}} {
+		a.expect(a.do(http.MethodPost, "/api/auth/login", map[string]string{"username": tc.user, "password": "secret-" + tc.user}), http.StatusOK)
+		a.expect(a.do(http.MethodPut, "/api/articles/"+created.ID, update), tc.status)
+	}
+
+	// eve can't reach the article through its side doors either
+	a.expect(a.do(http.MethodPost, "/api/auth/login", map[string]string{"username": "eve", "password": "secret-eve"}), http.StatusOK)
+	for _, path := range []string{"/ai/summary", "/ai/tags", "/previews", "/lock", "/lock/heartbeat"} {
+		a.expect(a.do(http.MethodPost, "/api/articles/"+created.ID+path, map[string]any{"apply": true, "summary": "x"}), http.StatusForbidden)
+	}
+	a.expect(a.do(http.MethodDelete, "/api/articles/"+created.ID+"/previews/00000000-0000-0000-0000-000000000000", nil), http.StatusForbidden)
+	a.expect(a.do(http.MethodPost, "/api/auth/login", map[string]string{"username": "bob", "password": "secret-bob"}), http.StatusOK)
+	a.expect(a.do(http.MethodDelete, "/api/articles/"+created.ID, nil), http.StatusNoContent)
+}
+
+func TestIntegrationExportArticle(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	id := a.seedPost("Exported", "exported", "Some *text*.")
+
+	resp := a.do(http.MethodGet, "/api/articles/"+id+"/export?format=md", nil)
+	if body := a.expect(resp, http.StatusOK); !strings.HasPrefix(body, "---\ntitle: Exported\n") || !strings.HasSuffix(body, "Some *text*.\n") {
+		t.Fatalf("markdown export: %s", body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=exported.md` {
+		t.Fatalf("Content-Disposition = %q", cd)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/api/articles/"+id+"/export", nil), http.StatusOK); !strings.Contains(body, "<em>text</em>") || !strings.Contains(body, "<style>") {
+		t.Fatalf("html export: %s", body)
+	}
+	a.expect(a.do(http.MethodGet, "/api/articles/"+id+"/export?format=pdf", nil), http.StatusServiceUnavailable)
+	a.expect(a.do(http.MethodGet, "/api/articles/"+id+"/export?format=docx", nil), http.StatusBadRequest)
+}
+
+func TestIntegrationPlainPost(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Printable", "printable", "Some *text*.")
+
+	body := a.expect(a.do(http.MethodGet, "/post/printable/plain", nil), http.StatusOK)
+	if !strings.Contains(body, "<em>text</em>") || !strings.Contains(body, `<link rel="canonical" href="`) || strings.Contains(body, "<app-root") {
+		t.Fatalf("plain page: %s", body)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/post/printable", nil), http.StatusOK); !strings.Contains(body, `/post/printable/plain"`) {
+		t.Fatalf("post page should link its print version: %s", body)
+	}
+	a.expect(a.do(http.MethodGet, "/post/missing/plain", nil), http.StatusNotFound)
+}
+
+func TestIntegrationTheme(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Themed", "themed", "text")
+
+	var th themeSettings
+	a.decode(a.do(http.MethodPut, "/api/theme", map[string]any{"accentColor": "#F60", "logo": "/media/theme/x.png"}), http.StatusOK, &th)
+	if th.AccentColor != "#ff6600" || th.Logo != "" {
+		t.Fatalf("theme = %+v", th)
+	}
+	a.expect(a.do(http.MethodPut, "/api/theme", map[string]any{"accentColor": "orange"}), http.StatusBadRequest)
+	if body := a.expect(a.do(http.MethodGet, "/api/theme", nil), http.StatusOK); !strings.Contains(body, `"#ff6600"`) {
+		t.Fatalf("public theme: %s", body)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/post/themed", nil), http.StatusOK); !strings.Contains(body, `<meta name="theme-color" content="#ff6600">`) || !strings.Contains(body, "/og-image.png?scheme=light") {
+		t.Fatalf("post head: %s", body)
+	}
+	resp := a.do(http.MethodGet, "/og-image.png?scheme=dark", nil)
+	if body := a.expect(resp, http.StatusOK); resp.Header.Get("Content-Type") != "image/png" || !strings.HasPrefix(body, "\x89PNG") {
+		t.Fatalf("og image: %q", resp.Header.Get("Content-Type"))
+	}
+	// the test app runs without a media directory
+	a.expect(a.doRaw(http.MethodPost, "/api/theme/logo", "multipart/form-data; boundary=x", "--x--\r\n"), http.StatusServiceUnavailable)
+	a.expect(a.do(http.MethodDelete, "/api/theme/banner", nil), http.StatusNotFound)
+}
+
+func TestIntegrationManifest(t *testing.T) {
+	a := newTestApp(t)
+	var m struct {
+		Name  string           `json:"name"`
+		Icons []map[string]any `json:"icons"`
+	}
+	resp := a.do(http.MethodGet, "/manifest.webmanifest", nil)
+	a.decode(resp, http.StatusOK, &m)
+	if resp.Header.Get("Content-Type") != "application/manifest+json" || m.Name == "" || m.Icons == nil || len(m.Icons) != 0 {
+		t.Fatalf("manifest = %+v (%s)", m, resp.Header.Get("Content-Type"))
+	}
+	// no logo and no frontend build: nothing to serve
+	a.expect(a.do(http.MethodGet, "/favicon.ico", nil), http.StatusNotFound)
+}
+
+func TestIntegrationContentEncryption(t *testing.T) {
+	a := newTestApp(t)
+	a.s.encryption = encryptionConfig{Enabled: true, Secret: "first secret"}
+	if err := a.s.ensureContentKey(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.login()
+	var out struct{ ID string }
+	a.decode(a.do(http.MethodPost, "/api/articles", map[string]string{
+		"title": "Hidden", "slug": "hidden", "bodyMd": "secret words", "status": "draft",
+	}), http.StatusCreated, &out)
+
+	var stored string
+	if err := a.db.QueryRow(`SELECT body_md FROM articles WHERE id=$1`, out.ID).Scan(&stored); err != nil || !isSealed(stored) {
+		t.Fatalf("draft body stored as %q, %v", stored, err)
+	}
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=draft", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].BodyMD != "secret words" {
+		t.Fatalf("draft list = %+v", list)
+	}
+	var vector string
+	if err := a.db.QueryRow(`SELECT COALESCE(search_vector::text, '') FROM articles WHERE id=$1`, out.ID).Scan(&vector); err != nil || strings.Contains(vector, "secret") {
+		t.Fatalf("encrypted body should stay out of the search index: %q, %v", vector, err)
+	}
+
+	a.expect(a.do(http.MethodPut, "/api/articles/"+out.ID, map[string]string{
+		"title": "Hidden", "slug": "hidden", "bodyMd": "secret words", "status": "published",
+	}), http.StatusNoContent)
+	if err := a.db.QueryRow(`SELECT body_md FROM articles WHERE id=$1`, out.ID).Scan(&stored); err != nil || stored != "secret words" {
+		t.Fatalf("published body stored as %q, %v", stored, err)
+	}
+}
+
+func TestIntegrationReloadKeepsRestartNeeded(t *testing.T) {
+	a := newTestApp(t)
+	yaml := "port: 9999\ncacheTTLSeconds: 5\ndatabase:\n  host: db\n  port: 5432\n  user: selfecho\n  name: selfecho\n"
+	if err := os.WriteFile(a.s.runtime.path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		res, err := a.s.reloadConfig(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Contains(res.RestartNeeded, "port") {
+			t.Fatalf("reload %d: restartNeeded = %v, want port until a restart", i+1, res.RestartNeeded)
+		}
+		if applied := slices.Contains(res.Applied, "cacheTTLSeconds"); applied != (i == 0) {
+			t.Fatalf("reload %d: applied = %v", i+1, res.Applied)
+		}
+	}
+}
+
+func TestIntegrationAPITokens(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	var created struct {
+		Token string   `json:"token"`
+		Info  apiToken `json:"info"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/tokens", map[string]any{
+		"name": "ci", "scopes": []string{scopeArticlesRead},
+	}), http.StatusCreated, &created)
+	if !strings.HasPrefix(created.Token, apiTokenPrefix) || created.Info.Name != "ci" {
+		t.Fatalf("created = %+v", created)
+	}
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+
+	auth := "Bearer " + created.Token
+	a.expect(a.doRaw(http.MethodGet, "/api/templates", "", "", "Authorization", auth), http.StatusOK)
+	a.expect(a.doRaw(http.MethodPost, "/api/articles", "application/json", `{"title":"x","slug":"x","status":"draft"}`,
+		"Authorization", auth), http.StatusForbidden)
+	a.expect(a.doRaw(http.MethodGet, "/api/tokens", "", "", "Authorization", auth), http.StatusForbidden)
+	a.expect(a.doRaw(http.MethodGet, "/api/templates", "", "", "Authorization", "Bearer se_bogus"), http.StatusUnauthorized)
+	if n := a.s.security.snapshot(time.Now()).LoginFailures; n != 1 {
+		t.Fatalf("login failures = %d, want 1 for the unknown token", n)
+	}
+
+	a.login()
+	a.expect(a.do(http.MethodDelete, "/api/tokens/"+created.Info.ID, nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+	a.expect(a.doRaw(http.MethodGet, "/api/templates", "", "", "Authorization", auth), http.StatusUnauthorized)
+}
+
+func TestIntegrationHealthHidesInternals(t *testing.T) {
+	a := newTestApp(t)
+	if body := a.expect(a.do(http.MethodGet, "/api/health", nil), http.StatusOK); strings.Contains(body, `"security"`) || strings.Contains(body, `"routes"`) {
+		t.Fatalf("public health carries security counters or route stats: %s", body)
+	}
+	a.expect(a.do(http.MethodGet, "/api/admin/health", nil), http.StatusUnauthorized)
+	a.login()
+	if body := a.expect(a.do(http.MethodGet, "/api/admin/health", nil), http.StatusOK); !strings.Contains(body, `"loginFailures"`) {
+		t.Fatalf("admin health = %s", body)
+	}
+}
+
+func TestIntegrationUnlockFailures(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.expect(a.do(http.MethodPost, "/api/articles", map[string]string{
+		"title": "Locked", "slug": "locked", "bodyMd": "x", "status": "published", "archive": "notes",
+		"visibility": visibilityPassword, "password": "open-sesame",
+	}), http.StatusCreated)
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+
+	a.expect(a.do(http.MethodPost, "/api/articles/locked/unlock", map[string]string{"password": "nope"}), http.StatusForbidden)
+	// the SSR lock page posts a form and is redirected back to the post
+	a.doRaw(http.MethodPost, "/api/articles/locked/unlock", "application/x-www-form-urlencoded", "password=nope")
+	if n := a.s.security.snapshot(time.Now()).UnlockFailures; n != 2 {
+		t.Fatalf("unlock failures = %d, want 2 (JSON and form)", n)
+	}
+	a.expect(a.do(http.MethodPost, "/api/articles/locked/unlock", map[string]string{"password": "open-sesame"}), http.StatusNoContent)
+}
+
+func TestIntegrationImapRoutesNeedScope(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	var created struct {
+		Token string `json:"token"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/tokens", map[string]any{
+		"name": "reader", "scopes": []string{scopeArticlesRead},
+	}), http.StatusCreated, &created)
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+
+	for _, path := range []string{"/api/imap/messages", "/api/imap/messages/1", "/api/imap/accounts"} {
+		a.expect(a.doRaw(http.MethodGet, path, "", ""), http.StatusUnauthorized)
+		a.expect(a.doRaw(http.MethodGet, path, "", "", "Authorization", "Bearer "+created.Token), http.StatusForbidden)
+	}
+}
+
+func TestIntegrationServiceAccount(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	var created struct {
+		Token   string  `json:"token"`
+		Account account `json:"account"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/users/service-accounts", map[string]any{
+		"username": "ci-export", "scopes": []string{scopeArticlesWrite},
+	}), http.StatusCreated, &created)
+	if created.Account.Role != roleService || len(created.Account.Tokens) != 1 {
+		t.Fatalf("created = %+v", created)
+	}
+	a.expect(a.do(http.MethodPost, "/api/users/service-accounts", map[string]any{
+		"username": "ci-export", "scopes": []string{scopeArticlesRead},
+	}), http.StatusConflict)
+
+	var users []account
+	a.decode(a.do(http.MethodGet, "/api/users", nil), http.StatusOK, &users)
+	if len(users) != 2 {
+		t.Fatalf("users = %+v", users)
+	}
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodPost, "/api/auth/login", map[string]string{"username": "ci-export", "password": "!"}), http.StatusUnauthorized)
+
+	auth := "Bearer " + created.Token
+	a.expect(a.doRaw(http.MethodPost, "/api/articles", "application/json",
+		`{"title":"From CI","slug":"from-ci","bodyMd":"x","status":"draft"}`, "Authorization", auth), http.StatusCreated)
+	var actor string
+	deadline := time.Now().Add(2 * time.Second)
+	for actor == "" && time.Now().Before(deadline) {
+		a.db.QueryRow(`SELECT actor FROM activity_log WHERE kind='article.changed' ORDER BY id DESC LIMIT 1`).Scan(&actor)
+		time.Sleep(20 * time.Millisecond)
+	}
+	if actor != "ci-export" {
+		t.Fatalf("activity actor = %q", actor)
+	}
+
+	a.login()
+	a.expect(a.do(http.MethodDelete, "/api/users/"+created.Account.ID+"/tokens", nil), http.StatusNoContent)
+	a.expect(a.doRaw(http.MethodGet, "/api/templates", "", "", "Authorization", auth), http.StatusUnauthorized)
+	for _, u := range users {
+		if u.Role != roleService {
+			a.expect(a.do(http.MethodDelete, "/api/users/"+u.ID, nil), http.StatusBadRequest)
+		}
+	}
+	a.expect(a.do(http.MethodDelete, "/api/users/"+created.Account.ID, nil), http.StatusNoContent)
+}
+
+func TestIntegrationOutboxWebhook(t *testing.T) {
+	a := newTestApp(t)
+	var delivered []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered = append(delivered, r.Header.Get("X-Selfecho-Event"))
+	}))
+	defer hook.Close()
+	a.s.webhooks = []webhookConfig{{URL: hook.URL, Events: []string{hookArticleCreated, hookArticleDeleted}}}
+	a.login()
+	id := a.seedPost("Hooked", "hooked", "body")
+	a.expect(a.do(http.MethodDelete, "/api/articles/"+id, nil), http.StatusNoContent)
+
+	var pending int
+	if err := a.db.QueryRow(`SELECT count(*) FROM outbox WHERE status='pending'`).Scan(&pending); err != nil || pending != 2 {
+		t.Fatalf("pending outbox rows = %d, %v", pending, err)
+	}
+	for a.s.processOutboxJob(context.Background()) {
+	}
+	if len(delivered) != 2 || delivered[0] != hookArticleCreated || delivered[1] != hookArticleDeleted {
+		t.Fatalf("delivered = %v", delivered)
+	}
+	var done int
+	if err := a.db.QueryRow(`SELECT count(*) FROM outbox WHERE status='done'`).Scan(&done); err != nil || done != 2 {
+		t.Fatalf("done outbox rows = %d, %v", done, err)
+	}
+}
+
+func TestIntegrationJobsAdmin(t *testing.T) {
+	a := newTestApp(t)
+	var hits int
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hits++ }))
+	defer hook.Close()
+	a.s.webhooks = []webhookConfig{{URL: hook.URL}}
+	a.s.recordFailedJob(jobWebhook, webhookDelivery{URL: hook.URL, Event: hookArticleUpdated, Data: []byte(`{}`)}, errors.New("connection refused"))
+	a.login()
+
+	var list struct {
+		Items  []job          `json:"items"`
+		Counts map[string]int `json:"counts"`
+	}
+	a.decode(a.do(http.MethodGet, "/api/admin/jobs?type=webhook&status=dead", nil), http.StatusOK, &list)
+	if len(list.Items) != 1 || list.Counts[jobDead] != 1 || list.Items[0].LastError != "connection refused" {
+		t.Fatalf("jobs = %+v", list)
+	}
+	id := strconv.FormatInt(list.Items[0].ID, 10)
+	var one job
+	a.decode(a.do(http.MethodGet, "/api/admin/jobs/"+id, nil), http.StatusOK, &one)
+	if !strings.Contains(string(one.Payload), hook.URL) {
+		t.Fatalf("payload = %s", one.Payload)
+	}
+
+	a.decode(a.do(http.MethodPost, "/api/admin/jobs/"+id+"/retry", nil), http.StatusOK, &one)
+	if one.Status != jobPending || one.Attempts != 0 {
+		t.Fatalf("retried job = %+v", one)
+	}
+	for a.s.processOutboxJob(context.Background()) {
+	}
+	if hits != 1 {
+		t.Fatalf("webhook hits = %d", hits)
+	}
+	a.expect(a.do(http.MethodPost, "/api/admin/jobs/"+id+"/retry", nil), http.StatusConflict)
+	a.expect(a.do(http.MethodGet, "/api/admin/jobs/999999", nil), http.StatusNotFound)
+}
+
+func TestIntegrationImapSummary(t *testing.T) {
+	a := newTestApp(t)
+	var accID string
+	if err := a.db.QueryRow(`
+		INSERT INTO imap_accounts (host, port, username, password) VALUES ('imap.invalid', 993, 'me', '') RETURNING id`).Scan(&accID); err != nil {
+		t.Fatal(err)
+	}
+	// uid 2 is cached under two UIDVALIDITY values; only the newer copy counts
+	if _, err := a.db.Exec(`
+		INSERT INTO imap_messages (account_id, uid, uidvalidity, flags) VALUES
+			($1, 1, 7, '\Seen'), ($1, 2, 6, '\Seen'), ($1, 2, 7, ''), ($1, 3, 7, '\Flagged')`, accID); err != nil {
+		t.Fatal(err)
+	}
+	a.login()
+
+	var sum struct {
+		Folders []imapFolderSummary `json:"folders"`
+		Total   int                 `json:"total"`
+		Unseen  int                 `json:"unseen"`
+	}
+	a.decode(a.do(http.MethodGet, "/api/imap/accounts/"+accID+"/summary", nil), http.StatusOK, &sum)
+	if sum.Total != 3 || sum.Unseen != 2 || len(sum.Folders) != 1 || sum.Folders[0].Source != "cache" {
+		t.Fatalf("summary = %+v", sum)
+	}
+}
+
+func TestIntegrationImapThreads(t *testing.T) {
+	a := newTestApp(t)
+	var accID string
+	if err := a.db.QueryRow(`
+		INSERT INTO imap_accounts (host, port, username, password) VALUES ('imap.invalid', 993, 'me', '') RETURNING id`).Scan(&accID); err != nil {
+		t.Fatal(err)
+	}
+	insert := func(uid int, date string, r mailRefs) {
+		t.Helper()
+		thread, err := resolveImapThread(context.Background(), a.db, accID, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.db.Exec(`
+			INSERT INTO imap_messages (account_id, uid, uidvalidity, subject, msg_date, flags, message_id, in_reply_to, refs, thread_id)
+			VALUES ($1, $2, 1, $3, $4, '', $5, $6, $7::text[], NULLIF($8, ''))`,
+			accID, uid, "subject "+strconv.Itoa(uid), date, r.MessageID, r.InReplyTo, r.References, thread); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(1, "2026-01-01T00:00:00Z", mailRefs{MessageID: "a@x", References: []string{}})
+	insert(2, "2026-01-02T00:00:00Z", mailRefs{MessageID: "z@x", References: []string{}})
+	// cites only its parent, which is in the cache already
+	insert(3, "2026-01-03T00:00:00Z", mailRefs{MessageID: "b@x", InReplyTo: "a@x", References: []string{}})
+	insert(4, "2026-01-04T00:00:00Z", mailRefs{MessageID: "c@x", InReplyTo: "b@x", References: []string{}})
+	a.login()
+
+	var list struct {
+		Items []imapThread `json:"items"`
+		Total int          `json:"total"`
+	}
+	a.decode(a.do(http.MethodGet, "/api/imap/threads?envelope=1&accountId="+accID, nil), http.StatusOK, &list)
+	if list.Total != 2 || len(list.Items) != 2 || list.Items[0].Count != 3 || list.Items[0].Subject != "subject 1" || list.Items[0].LatestUID != 4 {
+		t.Fatalf("threads = %+v", list)
+	}
+
+	var detail struct {
+		Messages []imapMessage `json:"messages"`
+	}
+	a.decode(a.do(http.MethodGet, "/api/imap/threads/"+list.Items[0].ID+"?accountId="+accID, nil), http.StatusOK, &detail)
+	if len(detail.Messages) != 3 || detail.Messages[0].UID != 1 || detail.Messages[2].UID != 4 {
+		t.Fatalf("thread = %+v", detail.Messages)
+	}
+	a.expect(a.do(http.MethodGet, "/api/imap/threads/nope?accountId="+accID, nil), http.StatusNotFound)
+}
+
+func TestIntegrationImapRetention(t *testing.T) {
+	a := newTestApp(t)
+	var accID string
+	if err := a.db.QueryRow(`
+		INSERT INTO imap_accounts (host, port, username, password) VALUES ('imap.invalid', 993, 'me', '') RETURNING id`).Scan(&accID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.Exec(`
+		INSERT INTO imap_messages (account_id, uid, uidvalidity, msg_date, body_html)
+		SELECT $1, n, 1, now() - make_interval(days => 10 - n, hours => -1), repeat('x', 100) FROM generate_series(1, 6) n`, accID); err != nil {
+		t.Fatal(err)
+	}
+	a.login()
+
+	var res struct {
+		Pruned int `json:"pruned"`
+	}
+	a.decode(a.do(http.MethodPut, "/api/imap/accounts/"+accID+"/retention", map[string]int{"retainMessages": 4}), http.StatusOK, &res)
+	if res.Pruned != 2 {
+		t.Fatalf("pruned = %d, want 2", res.Pruned)
+	}
+	// uids 3..6 are just under 7..4 days old
+	a.decode(a.do(http.MethodPut, "/api/imap/accounts/"+accID+"/retention", map[string]int{"retainDays": 5}), http.StatusOK, &res)
+	if res.Pruned != 2 {
+		t.Fatalf("pruned = %d, want 2", res.Pruned)
+	}
+
+	var accounts []imapAccount
+	a.decode(a.do(http.MethodGet, "/api/imap/accounts", nil), http.StatusOK, &accounts)
+	if len(accounts) != 1 || accounts[0].CachedMessages != 2 || accounts[0].CacheBytes <= 0 || accounts[0].RetainDays != 5 {
+		t.Fatalf("accounts = %+v", accounts)
+	}
+	a.expect(a.do(http.MethodPut, "/api/imap/accounts/"+accID+"/retention", map[string]int{"retainDays": -1}), http.StatusBadRequest)
+}
+
+func TestIntegrationImapRules(t *testing.T) {
+	a := newTestApp(t)
+	var accID string
+	if err := a.db.QueryRow(`
+		INSERT INTO imap_accounts (host, port, username, password) VALUES ('imap.invalid', 993, 'me', '') RETURNING id`).Scan(&accID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.db.Exec(`
+		INSERT INTO imap_messages (account_id, uid, uidvalidity, subject, from_addr, to_addr, msg_date)
+		VALUES ($1, 1, 1, 'Weekly digest', 'news@example.org', 'me@example.org', now()),
+		       ($1, 2, 1, 'Hello', 'friend@example.org', 'me@example.org', now())`, accID); err != nil {
+		t.Fatal(err)
+	}
+	a.login()
+
+	var rule imapRule
+	a.decode(a.do(http.MethodPost, "/api/imap/rules", map[string]any{
+		"accountId": accID, "name": "news", "matchFrom": "NEWS@", "action": ruleMove, "target": "News",
+	}), http.StatusCreated, &rule)
+	if rule.Position != 1 || !rule.Enabled {
+		t.Fatalf("rule = %+v", rule)
+	}
+	a.expect(a.do(http.MethodPost, "/api/imap/rules", map[string]any{"accountId": accID, "action": ruleMarkRead}), http.StatusBadRequest)
+
+	var tested struct {
+		Checked int         `json:"checked"`
+		Matches []ruleMatch `json:"matches"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/imap/rules/test", map[string]any{"accountId": accID}), http.StatusOK, &tested)
+	if tested.Checked != 2 || len(tested.Matches) != 1 || tested.Matches[0].UID != 1 || tested.Matches[0].Rules[0].ID != rule.ID {
+		t.Fatalf("dry run = %+v", tested)
+	}
+	a.decode(a.do(http.MethodPost, "/api/imap/rules/test", map[string]any{
+		"accountId": accID, "rule": map[string]any{"matchTo": "me@", "action": ruleDraft},
+	}), http.StatusOK, &tested)
+	if len(tested.Matches) != 2 {
+		t.Fatalf("draft rule dry run = %+v", tested)
+	}
+
+	a.decode(a.do(http.MethodPut, "/api/imap/rules/"+rule.ID, map[string]any{
+		"name": "news", "matchFrom": "news@", "action": ruleMove, "target": "News", "enabled": false,
+	}), http.StatusOK, &rule)
+	var rules []imapRule
+	a.decode(a.do(http.MethodGet, "/api/imap/rules?accountId="+accID, nil), http.StatusOK, &rules)
+	if len(rules) != 1 || rules[0].Enabled {
+		t.Fatalf("rules = %+v", rules)
+	}
+
+	payload, _ := json.Marshal(mailRuleJob{AccountID: accID, UID: 2, Subject: "Hello", Text: "Hi there"})
+	if err := a.s.runMailDraftJob(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	if err := a.db.QueryRow(`SELECT status FROM articles WHERE title='Hello'`).Scan(&status); err != nil || status != "draft" {
+		t.Fatalf("draft article: %q %v", status, err)
+	}
+
+	a.expect(a.do(http.MethodDelete, "/api/imap/rules/"+rule.ID, nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodDelete, "/api/imap/rules/"+rule.ID, nil), http.StatusNotFound)
+}
+
+func TestIntegrationReader(t *testing.T) {
+	a := newTestApp(t)
+	items := `<item><title>One</title><link>https://example.org/1</link><pubDate>Wed, 01 May 2024 12:00:00 +0000</pubDate></item>`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		fmt.Fprintf(w, `<rss version="2.0"><channel><title>Upstream</title><link>https://example.org/</link>%s</channel></rss>`, items)
+	}))
+	defer upstream.Close()
+	a.login()
+
+	var feed readerFeed
+	a.decode(a.do(http.MethodPost, "/api/reader/feeds", map[string]string{"feedUrl": upstream.URL}), http.StatusCreated, &feed)
+	if feed.Title != "Upstream" || feed.Unread != 1 || feed.LastError != "" || feed.LastFetchedAt == nil {
+		t.Fatalf("feed = %+v", feed)
+	}
+	a.expect(a.do(http.MethodPost, "/api/reader/feeds", map[string]string{"feedUrl": upstream.URL}), http.StatusConflict)
+	a.expect(a.do(http.MethodPost, "/api/reader/feeds", map[string]string{"feedUrl": "file:///etc/passwd"}), http.StatusBadRequest)
+
+	var refreshed struct {
+		Added int `json:"added"`
+	}
+	items += `<item><title>Two</title><guid>two</guid></item>`
+	a.decode(a.do(http.MethodPost, "/api/reader/feeds/"+feed.ID+"/refresh", nil), http.StatusOK, &refreshed)
+	if refreshed.Added != 0 {
+		t.Fatalf("304 added %d items", refreshed.Added)
+	}
+	if _, err := a.db.Exec(`UPDATE reader_feeds SET etag='' WHERE id=$1`, feed.ID); err != nil {
+		t.Fatal(err)
+	}
+	a.decode(a.do(http.MethodPost, "/api/reader/feeds/"+feed.ID+"/refresh", nil), http.StatusOK, &refreshed)
+	if refreshed.Added != 1 {
+		t.Fatalf("added = %d, want 1", refreshed.Added)
+	}
+
+	var list []readerItem
+	a.decode(a.do(http.MethodGet, "/api/reader/items?unread=1", nil), http.StatusOK, &list)
+	if len(list) != 2 || list[1].Title != "One" || list[1].FeedTitle != "Upstream" {
+		t.Fatalf("items = %+v", list)
+	}
+	a.expect(a.do(http.MethodPut, "/api/reader/items/"+list[1].ID, map[string]bool{"read": true}), http.StatusNoContent)
+	a.decode(a.do(http.MethodGet, "/api/reader/items?unread=1&feedId="+feed.ID, nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Title != "Two" {
+		t.Fatalf("unread = %+v", list)
+	}
+	var marked struct {
+		Marked int `json:"marked"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/reader/items/read", nil), http.StatusOK, &marked)
+	if marked.Marked != 1 {
+		t.Fatalf("marked = %d", marked.Marked)
+	}
+	a.expect(a.do(http.MethodDelete, "/api/reader/feeds/"+feed.ID, nil), http.StatusNoContent)
+	a.decode(a.do(http.MethodGet, "/api/reader/items", nil), http.StatusOK, &list)
+	if len(list) != 0 {
+		t.Fatalf("items outlived their feed: %+v", list)
+	}
+}
+
+func TestIntegrationMailFeeds(t *testing.T) {
+	a := newTestApp(t)
+	var accID string
+	if err := a.db.QueryRow(`
+		INSERT INTO imap_accounts (host, port, username, password) VALUES ('imap.invalid', 993, 'me', '') RETURNING id`).Scan(&accID); err != nil {
+		t.Fatal(err)
+	}
+	a.login()
+
+	var feed mailFeed
+	a.decode(a.do(http.MethodPost, "/api/imap/feeds", map[string]string{"accountId": accID, "folder": "Newsletters"}), http.StatusCreated, &feed)
+	if feed.Token == "" || !strings.HasSuffix(feed.URL, "/feeds/mail/"+feed.Token) || feed.Folder != "Newsletters" {
+		t.Fatalf("feed = %+v", feed)
+	}
+	var feeds []mailFeed
+	a.decode(a.do(http.MethodGet, "/api/imap/feeds", nil), http.StatusOK, &feeds)
+	if len(feeds) != 1 || feeds[0].Token != "" {
+		t.Fatalf("feeds = %+v", feeds)
+	}
+
+	a.expect(a.do(http.MethodGet, "/feeds/mail/nope", nil), http.StatusNotFound)
+	a.expect(a.do(http.MethodGet, "/feeds/mail/"+feed.Token, nil), http.StatusBadGateway)
+
+	var rotated mailFeed
+	a.decode(a.do(http.MethodPost, "/api/imap/feeds/"+feed.ID+"/token", nil), http.StatusOK, &rotated)
+	if rotated.Token == feed.Token {
+		t.Fatal("token did not change")
+	}
+	a.expect(a.do(http.MethodGet, "/feeds/mail/"+feed.Token, nil), http.StatusNotFound)
+	a.expect(a.do(http.MethodDelete, "/api/imap/feeds/"+feed.ID, nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodDelete, "/api/imap/feeds/"+feed.ID, nil), http.StatusNotFound)
+}
+
+func TestIntegrationBookmarks(t *testing.T) {
+	a := newTestApp(t)
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><title>Fetched title</title></head></html>`)
+	}))
+	defer page.Close()
+	a.login()
+
+	var created struct {
+		Token string `json:"token"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/tokens", map[string]any{
+		"name": "bookmarklet", "scopes": []string{scopeBookmarks},
+	}), http.StatusCreated, &created)
+
+	var b bookmark
+	a.decode(a.do(http.MethodPost, "/api/bookmarks", map[string]any{
+		"url": "https://example.org/read", "title": "Read me", "tags": []string{"Go"},
+	}), http.StatusCreated, &b)
+	if b.Title != "Read me" || len(b.Tags) != 1 || b.Read || b.Public {
+		t.Fatalf("bookmark = %+v", b)
+	}
+	a.expect(a.do(http.MethodPost, "/api/auth/logout", nil), http.StatusNoContent)
+
+	form := "url=" + page.URL + "&tags=web,notes&access_token=" + created.Token
+	body := a.expect(a.doRaw(http.MethodPost, "/api/bookmarks", "application/x-www-form-urlencoded", form), http.StatusCreated)
+	if !strings.Contains(body, "已保存") {
+		t.Fatalf("form response = %s", body)
+	}
+	a.expect(a.doRaw(http.MethodPost, "/api/bookmarks", "application/x-www-form-urlencoded", "url="+page.URL), http.StatusUnauthorized)
+	a.expect(a.doRaw(http.MethodGet, "/api/templates", "", "", "Authorization", "Bearer "+created.Token), http.StatusForbidden)
+
+	var saved bookmark
+	if err := a.db.QueryRow(`SELECT id FROM bookmarks WHERE url=$1`, page.URL).Scan(&saved.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.s.runBookmarkTitleJob(context.Background(), []byte(`{"id":"`+saved.ID+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	a.login()
+	var list []bookmark
+	a.decode(a.do(http.MethodGet, "/api/bookmarks?tag=web", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Title != "Fetched title" {
+		t.Fatalf("tagged = %+v", list)
+	}
+	a.decode(a.do(http.MethodGet, "/api/bookmarks?q=READ", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != b.ID {
+		t.Fatalf("search = %+v", list)
+	}
+
+	a.expect(a.do(http.MethodGet, "/liked", nil), http.StatusNotFound)
+	a.decode(a.do(http.MethodPut, "/api/bookmarks/"+b.ID, map[string]any{
+		"url": b.URL, "title": b.Title, "note": "worth it", "tags": b.Tags, "read": true, "public": true,
+	}), http.StatusOK, &b)
+	if !b.Read || !b.Public || b.Note != "worth it" {
+		t.Fatalf("updated = %+v", b)
+	}
+	a.decode(a.do(http.MethodGet, "/api/bookmarks?unread=1", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Read {
+		t.Fatalf("unread = %+v", list)
+	}
+	if html := a.expect(a.do(http.MethodGet, "/liked", nil), http.StatusOK); !strings.Contains(html, "Read me") || strings.Contains(html, "Fetched title") {
+		t.Fatalf("liked page = %s", html)
+	}
+	if feed := a.expect(a.do(http.MethodGet, "/liked/feed.xml", nil), http.StatusOK); !strings.Contains(feed, "https://example.org/read") {
+		t.Fatalf("liked feed = %s", feed)
+	}
+
+	a.expect(a.do(http.MethodDelete, "/api/bookmarks/"+b.ID, nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodDelete, "/api/bookmarks/"+b.ID, nil), http.StatusNotFound)
+}
+
+func TestIntegrationNotes(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	var created struct {
+		ID   string `json:"id"`
+		Slug string `json:"slug"`
+	}
+	a.decode(a.do(http.MethodPost, "/api/articles", map[string]any{
+		"type": "note", "status": "published", "bodyMd": "Trying out *notes*",
+	}), http.StatusCreated, &created)
+	if !strings.HasPrefix(created.Slug, "note-") {
+		t.Fatalf("created = %+v", created)
+	}
+	a.expect(a.do(http.MethodPut, "/api/articles/"+created.ID, map[string]any{
+		"type": "note", "status": "published", "bodyMd": "Trying out *notes* again",
+	}), http.StatusNoContent)
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=published&type=note", nil), http.StatusOK, &list)
+	if len(list) != 1 || list[0].Slug != created.Slug {
+		t.Fatalf("notes = %+v", list)
+	}
+	a.seedPost("Regular", "regular", "body")
+
+	if body := a.expect(a.do(http.MethodGet, "/notes", nil), http.StatusOK); !strings.Contains(body, "<em>notes</em> again") || strings.Contains(body, "Regular") {
+		t.Fatalf("notes page: %s", body)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/notes/"+created.ID, nil), http.StatusOK); !strings.Contains(body, "Trying out notes again") {
+		t.Fatalf("note page: %s", body)
+	}
+	a.expect(a.do(http.MethodGet, "/notes/not-an-id", nil), http.StatusNotFound)
+	a.expect(a.do(http.MethodGet, "/post/"+created.Slug, nil), http.StatusNotFound)
+	if body := a.expect(a.do(http.MethodGet, "/notes/feed.xml", nil), http.StatusOK); !strings.Contains(body, "/notes/"+created.ID) {
+		t.Fatalf("notes feed: %s", body)
+	}
+	if body := a.expect(a.do(http.MethodGet, "/sitemap.xml", nil), http.StatusOK); !strings.Contains(body, "/notes/"+created.ID) {
+		t.Fatalf("sitemap misses the note: %s", body)
+	}
+}
+
+func TestIntegrationGallery(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	id := a.seedPost("Trip", "trip", "Day one\n\n[[gallery]]\n\nThe end")
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 6))); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, name := range []string{"one.png", "two.png"} {
+		part, _ := w.CreateFormFile("files", name)
+		part.Write(img.Bytes())
+	}
+	w.Close()
+
+	a.expect(a.doRaw(http.MethodPost, "/api/articles/"+id+"/gallery", w.FormDataContentType(), body.String()), http.StatusServiceUnavailable)
+	a.s.mediaDir = t.TempDir()
+	var images []galleryImage
+	a.decode(a.doRaw(http.MethodPost, "/api/articles/"+id+"/gallery", w.FormDataContentType(), body.String()), http.StatusCreated, &images)
+	if len(images) != 2 || images[0].Width != 8 || images[0].Height != 6 {
+		t.Fatalf("uploaded = %+v", images)
+	}
+
+	a.decode(a.do(http.MethodPut, "/api/articles/"+id+"/gallery", []map[string]string{
+		{"id": images[1].ID, "caption": "Second first"},
+	}), http.StatusOK, &images)
+	if len(images) != 1 || images[0].Caption != "Second first" {
+		t.Fatalf("arranged = %+v", images)
+	}
+	a.expect(a.do(http.MethodPut, "/api/articles/"+id+"/gallery", []map[string]string{
+		{"id": "00000000-0000-0000-0000-000000000000"},
+	}), http.StatusBadRequest)
+
+	var list []article
+	a.decode(a.do(http.MethodGet, "/api/articles?status=published&slug=trip", nil), http.StatusOK, &list)
+	if len(list) != 1 || len(list[0].Gallery) != 1 || !strings.Contains(list[0].BodyHTML, "Second first") {
+		t.Fatalf("article = %+v", list)
+	}
+	page := a.expect(a.do(http.MethodGet, "/post/trip", nil), http.StatusOK)
+	if strings.Index(page, "gallery-item") < strings.Index(page, "Day one") || strings.Index(page, "gallery-item") > strings.Index(page, "The end") {
+		t.Fatalf("gallery not placed in the post: %s", page)
+	}
+
+	a.expect(a.do(http.MethodDelete, "/api/articles/"+id+"/gallery/"+images[0].ID, nil), http.StatusNoContent)
+	a.expect(a.do(http.MethodDelete, "/api/articles/"+id+"/gallery/"+images[0].ID, nil), http.StatusNotFound)
+	entries, err := os.ReadDir(filepath.Join(a.s.mediaDir, gallerySubdir))
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("gallery files left: %v, %v", entries, err)
+	}
+}
+
+func TestIntegrationActivityStats(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.seedPost("Counted", "counted", "两个字 and three words")
+
+	var st activityStats
+	a.decode(a.do(http.MethodGet, "/api/stats/activity", nil), http.StatusOK, &st)
+	if st.Total != 1 || len(st.Days) != 1 || st.Days[0].Words != 6 || st.CurrentStreak != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+	// a write drops the cached result
+	a.seedPost("Second", "second", "more")
+	a.decode(a.do(http.MethodGet, "/api/stats/activity", nil), http.StatusOK, &st)
+	if st.Total != 2 || st.Days[0].Count != 2 {
+		t.Fatalf("after write = %+v", st)
+	}
+}
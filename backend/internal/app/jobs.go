@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobImapSync re-runs a background IMAP sync. Background syncs are not
+// queued up front, as every inbox read triggers one; only failures are
+// recorded, dead, so they can be inspected and retried.
+const jobImapSync = "imap.sync"
+
+var (
+	jobTypes    = []string{jobWebhook, jobImapSync, jobMailDraft, jobMailForward, jobMailWebhook, jobBookmarkTitle, jobGitSync}
+	jobStatuses = []string{jobPending, jobRunning, jobDone, jobDead}
+)
+
+type imapSyncJob struct {
+	AccountID string `json:"accountId"`
+	Limit     int    `json:"limit"`
+	Force     bool   `json:"force"`
+}
+
+func (s *server) runImapSyncJob(ctx context.Context, payload []byte) error {
+	var j imapSyncJob
+	if err := json.Unmarshal(payload, &j); err != nil {
+		return fmt.Errorf("%w: %v", errJobPermanent, err)
+	}
+	acc, err := s.pickImapAccount(ctx, j.AccountID)
+	if err != nil {
+		return err
+	}
+	if acc == nil {
+		return fmt.Errorf("%w: IMAP 账户 %s 不存在", errJobPermanent, j.AccountID)
+	}
+	err = s.syncImapAccount(ctx, acc, j.Limit, j.Force)
+	s.publishImapSync(acc.ID, err)
+	return err
+}
+
+// recordFailedJob dead-letters work that failed outside the outbox.
+func (s *server) recordFailedJob(typ string, payload any, cause error) {
+	raw, err := json.Marshal(payload)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO outbox (type, payload, status, attempts, last_error) VALUES ($1, $2, $3, 1, $4)`,
+			typ, raw, jobDead, cause.Error())
+		cancel()
+	}
+	if err != nil {
+		warnf("记录失败任务 %s 失败: %v\n", typ, err)
+	}
+}
+
+// job is an outbox row for the admin API. Payload is only filled when a
+// single job is requested.
+type job struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Status    string          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError,omitempty"`
+	RunAt     time.Time       `json:"runAt"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+const jobColumns = `id, type, status, attempts, last_error, run_at, created_at, updated_at`
+
+func scanJob(row interface{ Scan(...any) error }, extra ...any) (job, error) {
+	var j job
+	err := row.Scan(append([]any{&j.ID, &j.Type, &j.Status, &j.Attempts, &j.LastError, &j.RunAt, &j.CreatedAt, &j.UpdatedAt}, extra...)...)
+	return j, err
+}
+
+// adminJobs lists outbox jobs newest first, filtered by ?type= and
+// ?status= and paged by ?cursor= (the last id seen) and ?limit=. Counts
+// holds the number of jobs per status for the type filter.
+func (s *server) adminJobs(c *gin.Context) {
+	typ, status := strings.TrimSpace(c.Query("type")), strings.TrimSpace(c.Query("status"))
+	if (typ != "" && !slices.Contains(jobTypes, typ)) || (status != "" && !slices.Contains(jobStatuses, status)) {
+		respondError(c, http.StatusBadRequest, errInvalidJobFilter)
+		return
+	}
+	limit := 50
+	if l, err := strconv.Atoi(strings.TrimSpace(c.Query("limit"))); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	var before int64
+	if raw := c.Query("cursor"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			respondError(c, http.StatusBadRequest, errInvalidCursor)
+			return
+		}
+		before = n
+	}
+
+	ctx := c.Request.Context()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+jobColumns+` FROM outbox
+		WHERE ($1 = '' OR type = $1) AND ($2 = '' OR status = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4`, typ, status, before, limit+1)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+		return
+	}
+	defer rows.Close()
+	items := []job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+			return
+		}
+		items = append(items, j)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+		return
+	}
+	var next string
+	if len(items) > limit {
+		items = items[:limit]
+		next = strconv.FormatInt(items[len(items)-1].ID, 10)
+	}
+
+	counts := map[string]int{}
+	countRows, err := s.db.QueryContext(ctx, `SELECT status, count(*) FROM outbox WHERE $1 = '' OR type = $1 GROUP BY status`, typ)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+		return
+	}
+	defer countRows.Close()
+	for countRows.Next() {
+		var st string
+		var n int
+		if err := countRows.Scan(&st, &n); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+			return
+		}
+		counts[st] = n
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "nextCursor": next, "counts": counts})
+}
+
+// jobIDParam reads the numeric :id of a job, answering 404 for anything else.
+func jobIDParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusNotFound, errJobNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// adminJob returns one job with its payload.
+func (s *server) adminJob(c *gin.Context) {
+	id, ok := jobIDParam(c)
+	if !ok {
+		return
+	}
+	var payload []byte
+	j, err := scanJob(s.db.QueryRowContext(c.Request.Context(), `
+		SELECT `+jobColumns+`, payload FROM outbox WHERE id = $1`, id), &payload)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondError(c, http.StatusNotFound, errJobNotFound)
+		return
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+		return
+	}
+	j.Payload = payload
+	c.JSON(http.StatusOK, j)
+}
+
+// retryJob puts a dead or waiting job back in line with a fresh set of
+// attempts. Running and done jobs are left alone.
+func (s *server) retryJob(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, ok := jobIDParam(c)
+	if !ok {
+		return
+	}
+	j, err := scanJob(s.db.QueryRowContext(ctx, `
+		UPDATE outbox SET status = 'pending', attempts = 0, run_at = now(), updated_at = now()
+		WHERE id = $1 AND status IN ('pending', 'dead')
+		RETURNING `+jobColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM outbox WHERE id = $1)`, id).Scan(&exists); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryJobsFailed)
+		} else if exists {
+			respondError(c, http.StatusConflict, errJobNotRetryable)
+		} else {
+			respondError(c, http.StatusNotFound, errJobNotFound)
+		}
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveJobFailed, err)
+		return
+	}
+	s.wakeOutbox()
+	c.JSON(http.StatusOK, j)
+}
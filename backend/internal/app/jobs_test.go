@@ -0,0 +1,23 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminJobsRejectsUnknownFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{}
+	r := gin.New()
+	r.GET("/jobs", s.adminJobs)
+	for _, q := range []string{"?type=newsletter", "?status=failed", "?cursor=abc"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/jobs"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package app
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Journal import: Day One exports and folders of dated Markdown files come
+// in as drafts, so a private diary stays private. Entries keep their original
+// time as the article's creation time; where and under what sky they were
+// written goes into custom fields.
+
+// journalSlugPrefix keeps Day One entries apart from posts; the entry's own
+// ID makes re-imports with ?upsert=1 land on the same article.
+const journalSlugPrefix = "journal-"
+
+type dayOneExport struct {
+	Entries []dayOneEntry `json:"entries"`
+}
+
+type dayOneEntry struct {
+	UUID         string   `json:"uuid"`
+	CreationDate string   `json:"creationDate"`
+	TimeZone     string   `json:"timeZone"`
+	Text         string   `json:"text"`
+	Tags         []string `json:"tags"`
+	Starred      bool     `json:"starred"`
+	Location     *struct {
+		PlaceName          string   `json:"placeName"`
+		LocalityName       string   `json:"localityName"`
+		AdministrativeArea string   `json:"administrativeArea"`
+		Country            string   `json:"country"`
+		Latitude           *float64 `json:"latitude"`
+		Longitude          *float64 `json:"longitude"`
+	} `json:"location"`
+	Weather *struct {
+		ConditionsDescription string   `json:"conditionsDescription"`
+		TemperatureCelsius    *float64 `json:"temperatureCelsius"`
+	} `json:"weather"`
+	Photos []struct {
+		Identifier string `json:"identifier"`
+		MD5        string `json:"md5"`
+		Type       string `json:"type"`
+	} `json:"photos"`
+}
+
+// journalFrontMatter is what a Markdown journal file may declare up front;
+// everything is optional.
+type journalFrontMatter struct {
+	Title    string   `yaml:"title"`
+	Slug     string   `yaml:"slug"`
+	Date     string   `yaml:"date"`
+	Tags     []string `yaml:"tags"`
+	Location string   `yaml:"location"`
+}
+
+var (
+	markdownImageRe = regexp.MustCompile(`(!\[[^\]]*\]\()([^)\s]+)`)
+	// journalFileDateRe reads "2021-03-04", optionally followed by a time
+	// ("2021-03-04 0930" or "2021-03-04T09-30"), at the start of a file name.
+	journalFileDateRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(?:[ T_](\d{2})[-:]?(\d{2}))?`)
+)
+
+// splitFrontMatter separates a leading "---" YAML block from the Markdown
+// after it. Text without one is all body.
+func splitFrontMatter(text string) (head, body string) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		rest, ok = strings.CutPrefix(text, "---\r\n")
+	}
+	if !ok {
+		return "", text
+	}
+	for _, sep := range []string{"\n---\n", "\n---\r\n"} {
+		if i := strings.Index(rest, sep); i >= 0 {
+			return rest[:i], strings.TrimLeft(rest[i+len(sep):], "\r\n")
+		}
+	}
+	if h, ok := strings.CutSuffix(strings.TrimRight(rest, "\r\n"), "\n---"); ok {
+		return h, ""
+	}
+	return "", text
+}
+
+// journalTitle takes a leading "# heading" as the entry's title and removes
+// it from the body; entries without one are titled by their date.
+func journalTitle(body string, date time.Time) (string, string) {
+	trimmed := strings.TrimLeft(body, " \t\r\n")
+	line, rest, _ := strings.Cut(trimmed, "\n")
+	if heading, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+		if t := strings.TrimSpace(strings.ReplaceAll(heading, `\`, "")); t != "" {
+			return t, strings.TrimLeft(rest, "\r\n")
+		}
+	}
+	return date.Format(dateLayout), body
+}
+
+// rewriteMarkdownImages points the Markdown images of body at their stored
+// copies; key maps a reference to what im.store should look up.
+func rewriteMarkdownImages(im *imageImporter, body string, key func(src string) string) string {
+	if im == nil {
+		return body
+	}
+	return markdownImageRe.ReplaceAllStringFunc(body, func(m string) string {
+		sm := markdownImageRe.FindStringSubmatch(m)
+		if local, ok := im.store(key(sm[2])); ok {
+			return sm[1] + local
+		}
+		return m
+	})
+}
+
+func journalMeta(fields map[string]any) *customFields {
+	meta := customFields{}
+	for k, v := range fields {
+		if raw, err := json.Marshal(v); err == nil {
+			meta[k] = raw
+		}
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return &meta
+}
+
+func decodeDayOne(data []byte) (dayOneExport, error) {
+	var exp dayOneExport
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return exp, err
+	}
+	if exp.Entries == nil {
+		return exp, errors.New("no entries in Day One export")
+	}
+	return exp, nil
+}
+
+// dayOnePosts maps the entries of one Day One journal to draft posts.
+// journal is the journal's name (its JSON file name in the export).
+func dayOnePosts(exp dayOneExport, journal string, loc *time.Location) []sourcePost {
+	posts := make([]sourcePost, 0, len(exp.Entries))
+	for _, e := range exp.Entries {
+		sp := sourcePost{Source: e.UUID}
+		created, err := time.Parse(time.RFC3339, strings.TrimSpace(e.CreationDate))
+		if err != nil {
+			sp.Skip = "invalid creationDate"
+			posts = append(posts, sp)
+			continue
+		}
+		if strings.TrimSpace(e.Text) == "" && len(e.Photos) == 0 {
+			sp.Skip = "empty entry"
+			posts = append(posts, sp)
+			continue
+		}
+		entryLoc := loc
+		if tz, err := time.LoadLocation(e.TimeZone); err == nil && e.TimeZone != "" {
+			entryLoc = tz
+		}
+		local := created.In(entryLoc)
+		title, body := journalTitle(e.Text, local)
+
+		fields := map[string]any{}
+		if journal != "" {
+			fields["journal"] = journal
+		}
+		if e.UUID != "" {
+			fields["dayOneId"] = e.UUID
+		}
+		if e.TimeZone != "" {
+			fields["timeZone"] = e.TimeZone
+		}
+		if e.Starred {
+			fields["starred"] = true
+		}
+		if l := e.Location; l != nil {
+			place := map[string]any{}
+			for k, v := range map[string]string{"placeName": l.PlaceName, "locality": l.LocalityName, "region": l.AdministrativeArea, "country": l.Country} {
+				if v != "" {
+					place[k] = v
+				}
+			}
+			if l.Latitude != nil && l.Longitude != nil {
+				place["latitude"], place["longitude"] = *l.Latitude, *l.Longitude
+			}
+			if len(place) > 0 {
+				fields["location"] = place
+			}
+		}
+		if w := e.Weather; w != nil {
+			weather := map[string]any{}
+			if w.ConditionsDescription != "" {
+				weather["conditions"] = w.ConditionsDescription
+			}
+			if w.TemperatureCelsius != nil {
+				weather["temperatureCelsius"] = *w.TemperatureCelsius
+			}
+			if len(weather) > 0 {
+				fields["weather"] = weather
+			}
+		}
+
+		p := articlePayload{
+			Title:  title,
+			Type:   "post",
+			Status: "draft",
+			BodyMD: body,
+			Meta:   journalMeta(fields),
+		}
+		if e.UUID != "" {
+			p.Slug = journalSlugPrefix + local.Format(dateLayout) + "-" + strings.ToLower(e.UUID[:min(8, len(e.UUID))])
+		}
+		if len(e.Tags) > 0 {
+			tags := append([]string(nil), e.Tags...)
+			p.Tags = &tags
+		}
+		sp.Payload = p
+		sp.Created = created
+		posts = append(posts, sp)
+	}
+	return posts
+}
+
+// markdownJournalPost maps one Markdown file to a draft. Its date comes from
+// the front matter or else the file name; files with neither are skipped.
+func markdownJournalPost(name string, data []byte, loc *time.Location) sourcePost {
+	sp := sourcePost{Source: name}
+	head, body := splitFrontMatter(string(data))
+	var fm journalFrontMatter
+	if head != "" {
+		if err := yaml.Unmarshal([]byte(head), &fm); err != nil {
+			sp.Skip = "invalid front matter: " + err.Error()
+			return sp
+		}
+	}
+	stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	created, ok := parseJournalDate(fm.Date, loc)
+	if !ok {
+		created, ok = journalFileDate(stem, loc)
+	}
+	if !ok {
+		sp.Skip = "no date in front matter or file name"
+		return sp
+	}
+	if strings.TrimSpace(body) == "" {
+		sp.Skip = "empty entry"
+		return sp
+	}
+	title := strings.TrimSpace(fm.Title)
+	if title == "" {
+		title, body = journalTitle(body, created)
+	}
+	p := articlePayload{
+		Title:  title,
+		Slug:   fm.Slug,
+		Type:   "post",
+		Status: "draft",
+		BodyMD: body,
+	}
+	if p.Slug == "" {
+		p.Slug = journalSlugPrefix + stem
+	}
+	if len(fm.Tags) > 0 {
+		p.Tags = &fm.Tags
+	}
+	if place := strings.TrimSpace(fm.Location); place != "" {
+		p.Meta = journalMeta(map[string]any{"location": map[string]string{"placeName": place}})
+	}
+	sp.Payload = p
+	sp.Created = created
+	return sp
+}
+
+func parseJournalDate(raw string, loc *time.Location) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, true
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", dateLayout} {
+		if t, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func journalFileDate(stem string, loc *time.Location) (time.Time, bool) {
+	m := journalFileDateRe.FindStringSubmatch(stem)
+	if m == nil {
+		return time.Time{}, false
+	}
+	raw := m[1]
+	if m[2] != "" {
+		raw += " " + m[2] + ":" + m[3]
+	}
+	return parseJournalDate(raw, loc)
+}
+
+func isJournalMarkdown(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".txt":
+		return !strings.HasPrefix(path.Base(name), ".")
+	}
+	return false
+}
+
+// importJournal backs POST /api/articles/import/journal. The body is a Day
+// One JSON export, the zip Day One produces (journals plus photos/), or a
+// zip of Markdown files named or front-mattered with their dates. Entries
+// become drafts; ?upsert=1 updates earlier imports of the same entries.
+func (s *server) importJournal(c *gin.Context) {
+	f, size, done, err := spoolImportUpload(c)
+	if err != nil {
+		respondErrorDetail(c, http.StatusRequestEntityTooLarge, errImportTooLarge, err)
+		return
+	}
+	defer done()
+	loc := s.siteLocation()
+	var im *imageImporter
+	if importOption(c, "images", true) {
+		im = s.newImageImporter(c.Request.Context())
+	}
+
+	if !isZip(f) {
+		data, err := io.ReadAll(io.NewSectionReader(f, 0, size))
+		if err != nil {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+			return
+		}
+		exp, err := decodeDayOne(data)
+		if err != nil {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+			return
+		}
+		s.importSourcePosts(c, dayOnePosts(exp, "", loc), importOption(c, "upsert", false), im.summary())
+		return
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+		return
+	}
+	files := map[string]*zip.File{}
+	photos := map[string]*zip.File{}
+	var journals, markdown []*zip.File
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || strings.HasPrefix(zf.Name, "__MACOSX/") {
+			continue
+		}
+		name := path.Clean(zf.Name)
+		files[name] = zf
+		switch {
+		case path.Base(path.Dir(name)) == "photos":
+			base := path.Base(name)
+			photos[strings.TrimSuffix(base, path.Ext(base))] = zf
+		case strings.EqualFold(path.Ext(name), ".json"):
+			journals = append(journals, zf)
+		case isJournalMarkdown(name):
+			markdown = append(markdown, zf)
+		}
+	}
+	sort.Slice(markdown, func(i, j int) bool { return markdown[i].Name < markdown[j].Name })
+
+	var posts []sourcePost
+	if len(journals) > 0 {
+		moments := map[string]string{}
+		for _, zf := range journals {
+			data, err := readZipFile(zf, maxImportArchiveBytes)
+			if err != nil {
+				respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+				return
+			}
+			name := strings.TrimSuffix(path.Base(zf.Name), path.Ext(zf.Name))
+			exp, err := decodeDayOne(data)
+			if err != nil {
+				respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, fmt.Errorf("%s: %w", zf.Name, err))
+				return
+			}
+			for _, e := range exp.Entries {
+				for _, ph := range e.Photos {
+					moments[ph.Identifier] = ph.MD5
+				}
+			}
+			posts = append(posts, dayOnePosts(exp, name, loc)...)
+		}
+		if im != nil {
+			im.local = func(src string) ([]byte, bool) {
+				id, ok := strings.CutPrefix(src, "dayone-moment://")
+				if !ok {
+					return nil, false
+				}
+				zf, ok := photos[moments[id]]
+				if !ok {
+					return nil, false
+				}
+				data, err := readZipFile(zf, maxExportImageBytes)
+				if err != nil {
+					im.fail(src, err)
+					return nil, false
+				}
+				return data, true
+			}
+		}
+		for i := range posts {
+			posts[i].Payload.BodyMD = rewriteMarkdownImages(im, posts[i].Payload.BodyMD, func(src string) string { return src })
+		}
+	} else {
+		if len(markdown) == 0 {
+			respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, errors.New("no Day One journal or Markdown files in archive"))
+			return
+		}
+		if im != nil {
+			im.local = func(src string) ([]byte, bool) {
+				zf, ok := files[src]
+				if !ok {
+					return nil, false
+				}
+				data, err := readZipFile(zf, maxExportImageBytes)
+				if err != nil {
+					im.fail(src, err)
+					return nil, false
+				}
+				return data, true
+			}
+		}
+		for _, zf := range markdown {
+			data, err := readZipFile(zf, maxImportBytes)
+			if err != nil {
+				respondErrorDetail(c, http.StatusBadRequest, errInvalidImportArchive, err)
+				return
+			}
+			name := path.Clean(zf.Name)
+			sp := markdownJournalPost(name, data, loc)
+			// images next to the file are looked up relative to it
+			sp.Payload.BodyMD = rewriteMarkdownImages(im, sp.Payload.BodyMD, func(src string) string {
+				if u, err := url.Parse(src); err == nil && u.Scheme == "" && !strings.HasPrefix(src, "/") {
+					if p, err := url.PathUnescape(u.Path); err == nil {
+						return path.Join(path.Dir(name), p)
+					}
+				}
+				return src
+			})
+			posts = append(posts, sp)
+		}
+	}
+	s.importSourcePosts(c, posts, importOption(c, "upsert", false), im.summary())
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	head, body := splitFrontMatter("---\ntitle: Hi\ndate: 2021-03-04\n---\n\nBody\n")
+	if head != "title: Hi\ndate: 2021-03-04" || body != "Body\n" {
+		t.Fatalf("got %q / %q", head, body)
+	}
+	if head, body := splitFrontMatter("No header\n---\nrule"); head != "" || body != "No header\n---\nrule" {
+		t.Fatalf("plain text: %q / %q", head, body)
+	}
+	if head, body := splitFrontMatter("---\nunterminated"); head != "" || body != "---\nunterminated" {
+		t.Fatalf("unterminated: %q / %q", head, body)
+	}
+}
+
+func TestJournalTitle(t *testing.T) {
+	date := time.Date(2021, 3, 4, 9, 0, 0, 0, time.UTC)
+	if title, body := journalTitle("# Kyoto\\!\n\nTemples.", date); title != "Kyoto!" || body != "Temples." {
+		t.Fatalf("heading: %q / %q", title, body)
+	}
+	if title, body := journalTitle("Rainy day.", date); title != "2021-03-04" || body != "Rainy day." {
+		t.Fatalf("no heading: %q / %q", title, body)
+	}
+}
+
+func TestDayOnePosts(t *testing.T) {
+	exp, err := decodeDayOne([]byte(`{"metadata":{"version":"1.0"},"entries":[
+		{"uuid":"ABCDEF0123456789","creationDate":"2021-03-04T23:30:00Z","timeZone":"Asia/Tokyo","text":"# Kyoto\n\n![](dayone-moment://P1)","tags":["travel"],"starred":true,
+		 "location":{"placeName":"Kiyomizu","localityName":"Kyoto","country":"Japan","latitude":34.99,"longitude":135.78},
+		 "weather":{"conditionsDescription":"Cloudy","temperatureCelsius":12.5},
+		 "photos":[{"identifier":"P1","md5":"abc","type":"jpeg"}]},
+		{"uuid":"EMPTY","creationDate":"2021-03-05T00:00:00Z","text":"  "},
+		{"uuid":"BAD","creationDate":"yesterday","text":"x"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := dayOnePosts(exp, "Journal", time.UTC)
+	if len(posts) != 3 || posts[1].Skip == "" || posts[2].Skip == "" {
+		t.Fatalf("posts = %+v", posts)
+	}
+	p := posts[0].Payload
+	if p.Title != "Kyoto" || p.Status != "draft" || p.Slug != "journal-2021-03-05-abcdef01" || p.BodyMD != "![](dayone-moment://P1)" {
+		t.Fatalf("entry = %+v", p)
+	}
+	if !posts[0].Created.Equal(time.Date(2021, 3, 4, 23, 30, 0, 0, time.UTC)) {
+		t.Fatalf("created = %v", posts[0].Created)
+	}
+	meta, _ := json.Marshal(p.Meta)
+	for _, want := range []string{`"journal":"Journal"`, `"timeZone":"Asia/Tokyo"`, `"starred":true`, `"placeName":"Kiyomizu"`, `"latitude":34.99`, `"temperatureCelsius":12.5`} {
+		if !strings.Contains(string(meta), want) {
+			t.Errorf("meta %s lacks %s", meta, want)
+		}
+	}
+	var v validator
+	p.Meta.validate(&v)
+	if err := v.err(); err != nil {
+		t.Fatalf("meta does not validate: %v", err)
+	}
+	if _, err := decodeDayOne([]byte(`{"posts":[]}`)); err == nil {
+		t.Fatal("not a Day One export")
+	}
+}
+
+func TestMarkdownJournalPost(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	sp := markdownJournalPost("2020/2020-05-06 0930 walk.md", []byte("Went for a walk."), loc)
+	if sp.Skip != "" || sp.Payload.Title != "2020-05-06" || sp.Payload.Slug != "journal-2020-05-06 0930 walk" || sp.Payload.Status != "draft" {
+		t.Fatalf("file-dated = %+v", sp)
+	}
+	if !sp.Created.Equal(time.Date(2020, 5, 6, 9, 30, 0, 0, loc)) {
+		t.Fatalf("created = %v", sp.Created)
+	}
+
+	sp = markdownJournalPost("notes.md", []byte("---\ntitle: Move\ndate: 2019-01-02\ntags: [home]\nlocation: Berlin\n---\nBoxes."), loc)
+	if sp.Skip != "" || sp.Payload.Title != "Move" || sp.Payload.BodyMD != "Boxes." || (*sp.Payload.Tags)[0] != "home" {
+		t.Fatalf("front matter = %+v", sp)
+	}
+	if meta, _ := json.Marshal(sp.Payload.Meta); string(meta) != `{"location":{"placeName":"Berlin"}}` {
+		t.Fatalf("meta = %s", meta)
+	}
+	if !sp.Created.Equal(time.Date(2019, 1, 2, 0, 0, 0, 0, loc)) {
+		t.Fatalf("created = %v", sp.Created)
+	}
+
+	if sp := markdownJournalPost("readme.md", []byte("undated"), loc); sp.Skip == "" {
+		t.Fatal("undated file should be skipped")
+	}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strings"
+
+	"selfecho/backend/internal/store"
+)
+
+const defaultSiteLanguage = "zh-CN"
+
+// langTagRe accepts the BCP 47 subset used for content: a 2–3 letter primary
+// language followed by optional script, region or variant subtags.
+var langTagRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// normalizeLang validates a language tag and returns it in conventional case
+// (zh-Hans-CN, en-US). An empty tag is valid and means "site default".
+func normalizeLang(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if tag == "" {
+		return "", true
+	}
+	if len(tag) > 35 || !langTagRe.MatchString(tag) {
+		return "", false
+	}
+	parts := strings.Split(tag, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch p := parts[i]; {
+		case len(p) == 4 && isAlpha(p):
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		case len(p) == 2 && isAlpha(p), len(p) == 3 && isDigits(p):
+			parts[i] = strings.ToUpper(p)
+		default:
+			parts[i] = strings.ToLower(p)
+		}
+	}
+	return strings.Join(parts, "-"), true
+}
+
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ogLocale converts a language tag to the language_TERRITORY form Open Graph
+// expects; script subtags are dropped.
+func ogLocale(tag string) string {
+	parts := strings.Split(tag, "-")
+	locale := parts[0]
+	for _, p := range parts[1:] {
+		if len(p) == 2 || (len(p) == 3 && isDigits(p)) {
+			return locale + "_" + p
+		}
+	}
+	return locale
+}
+
+// contentLang is the effective language of an article: its own tag, or the
+// site default when unset.
+func (s *server) contentLang(lang string) string {
+	if lang != "" {
+		return lang
+	}
+	if site := s.siteSettings().Language; site != "" {
+		return site
+	}
+	return defaultSiteLanguage
+}
+
+var htmlOpenTagRe = regexp.MustCompile(`(?i)<html(\s[^>]*)?>`)
+var htmlLangAttrRe = regexp.MustCompile(`(?i)\slang\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+
+// setHTMLLang sets (or replaces) the lang attribute on the document's <html>
+// element.
+func setHTMLLang(doc, lang string) string {
+	loc := htmlOpenTagRe.FindStringIndex(doc)
+	if loc == nil || lang == "" {
+		return doc
+	}
+	tag := doc[loc[0]:loc[1]]
+	attr := ` lang="` + html.EscapeString(lang) + `"`
+	if htmlLangAttrRe.MatchString(tag) {
+		tag = htmlLangAttrRe.ReplaceAllLiteralString(tag, attr)
+	} else {
+		tag = tag[:len("<html")] + attr + tag[len("<html"):]
+	}
+	return doc[:loc[0]] + tag + doc[loc[1]:]
+}
+
+// translation is one published language version of a post.
+type translation struct {
+	Lang     string
+	Slug     string
+	Original bool
+}
+
+// queryTranslations returns the published posts in the translation group of
+// article id: the original plus every article whose translation_of points at
+// it. Fewer than two entries means the post has no translations.
+func (s *server) queryTranslations(ctx context.Context, id string) ([]translation, error) {
+	rows, err := s.readQuery(ctx, `
+		WITH root AS (SELECT COALESCE(translation_of, id) AS id FROM articles WHERE id=$1)
+		SELECT art.lang, art.slug, art.translation_of IS NULL
+		FROM articles art, root
+		WHERE (art.id = root.id OR art.translation_of = root.id)
+		  AND art.status='published' AND art.type='post' AND art.visibility <> 'unlisted'
+		ORDER BY art.translation_of IS NOT NULL, art.lang, art.created_at`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []translation
+	for rows.Next() {
+		var t translation
+		if err := rows.Scan(&t.Lang, &t.Slug, &t.Original); err != nil {
+			return nil, err
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+// hreflangLinks renders rel=alternate links for a translation group, pointing
+// x-default at the original. Entries without a language use siteLang; only
+// the first post per language is linked.
+func hreflangLinks(base, siteLang string, items []translation) string {
+	if len(items) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	seen := make(map[string]bool)
+	for _, t := range items {
+		lang := t.Lang
+		if lang == "" {
+			lang = siteLang
+		}
+		href := base + "/post/" + urlPathEscape(t.Slug)
+		if !seen[strings.ToLower(lang)] {
+			seen[strings.ToLower(lang)] = true
+			b.WriteString(`<link rel="alternate" hreflang="` + html.EscapeString(lang) + `" href="` + html.EscapeString(href) + `">`)
+		}
+		if t.Original {
+			b.WriteString(`<link rel="alternate" hreflang="x-default" href="` + html.EscapeString(href) + `">`)
+		}
+	}
+	return b.String()
+}
+
+// translationRoot resolves the article a new translation should point at,
+// following one level so groups stay flat. ok is false when id is unknown.
+func (s *server) translationRoot(ctx context.Context, id string) (string, bool, error) {
+	if !store.ValidID(id) {
+		return "", false, nil
+	}
+	var root string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(translation_of, id)::text FROM articles WHERE id=$1`, id).Scan(&root)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return root, true, nil
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLang(t *testing.T) {
+	cases := map[string]string{
+		"":           "",
+		"EN":         "en",
+		"zh_cn":      "zh-CN",
+		"zh-hans-cn": "zh-Hans-CN",
+		"es-419":     "es-419",
+	}
+	for in, want := range cases {
+		got, ok := normalizeLang(in)
+		if !ok || got != want {
+			t.Errorf("normalizeLang(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	for _, bad := range []string{"e", "english!", "zh-", `en"><script>`} {
+		if _, ok := normalizeLang(bad); ok {
+			t.Errorf("normalizeLang(%q) accepted", bad)
+		}
+	}
+}
+
+func TestOGLocale(t *testing.T) {
+	cases := map[string]string{"zh-CN": "zh_CN", "zh-Hans-CN": "zh_CN", "en": "en", "es-419": "es_419"}
+	for in, want := range cases {
+		if got := ogLocale(in); got != want {
+			t.Errorf("ogLocale(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSetHTMLLang(t *testing.T) {
+	if got := setHTMLLang(`<!doctype html><html lang="en" class="x"><head>`, "zh-CN"); got != `<!doctype html><html lang="zh-CN" class="x"><head>` {
+		t.Errorf("replace: %s", got)
+	}
+	if got := setHTMLLang(`<html><head>`, "en"); got != `<html lang="en"><head>` {
+		t.Errorf("insert: %s", got)
+	}
+}
+
+func TestHreflangLinks(t *testing.T) {
+	if hreflangLinks("https://b", "zh-CN", []translation{{Slug: "a", Original: true}}) != "" {
+		t.Fatal("single post should have no alternates")
+	}
+	got := hreflangLinks("https://b", "zh-CN", []translation{
+		{Slug: "a", Original: true},
+		{Lang: "en", Slug: "a-en"},
+	})
+	for _, want := range []string{
+		`hreflang="zh-CN" href="https://b/post/a"`,
+		`hreflang="x-default" href="https://b/post/a"`,
+		`hreflang="en" href="https://b/post/a-en"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// article_links records which post slugs each article links to. Targets are
+// stored as written so links through a retired slug still count once the
+// redirect is resolved at query time.
+const linkGraphSchema = `
+	CREATE TABLE IF NOT EXISTS article_links (
+		source_id UUID NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+		target_slug TEXT NOT NULL,
+		PRIMARY KEY (source_id, target_slug)
+	);
+	CREATE INDEX IF NOT EXISTS idx_article_links_target ON article_links(target_slug);
+`
+
+func (s *server) ensureLinkGraphSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, linkGraphSchema)
+	return err
+}
+
+var hrefRe = regexp.MustCompile(`(?i)<a\s[^>]*?href\s*=\s*("[^"]*"|'[^']*')`)
+
+// internalPostLinks returns the distinct post slugs linked from body. Relative
+// links and absolute links to host count; basePath is stripped first.
+func internalPostLinks(body, basePath, host string) []string {
+	var slugs []string
+	seen := make(map[string]bool)
+	for _, m := range hrefRe.FindAllStringSubmatch(body, -1) {
+		u, err := url.Parse(strings.TrimSpace(htmlUnquote(m[1])))
+		if err != nil {
+			continue
+		}
+		if u.Host != "" && (host == "" || !strings.EqualFold(u.Host, host)) {
+			continue
+		}
+		if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		p := u.Path
+		if basePath != "" {
+			if !strings.HasPrefix(p, basePath+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(p, basePath)
+		}
+		slug, ok := strings.CutPrefix(p, "/post/")
+		slug = strings.TrimSuffix(slug, "/")
+		if !ok || slug == "" || strings.Contains(slug, "/") || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		slugs = append(slugs, slug)
+	}
+	return slugs
+}
+
+func htmlUnquote(attr string) string {
+	return html.UnescapeString(attr[1 : len(attr)-1])
+}
+
+func (s *server) canonicalHostname() string {
+	if s.canonical == nil {
+		return ""
+	}
+	return s.canonical.Host
+}
+
+// indexArticleLinks replaces the outgoing links recorded for one article.
+func (s *server) indexArticleLinks(ctx context.Context, id string) error {
+	var bodyHTML, bodyMD string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(body_html, ''), body_md FROM articles WHERE id=$1`, id).Scan(&bodyHTML, &bodyMD)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if bodyHTML, err = s.content.open(bodyHTML); err != nil {
+		return err
+	}
+	if strings.TrimSpace(bodyHTML) == "" {
+		if bodyMD, err = s.content.open(bodyMD); err != nil {
+			return err
+		}
+		bodyHTML = renderMarkdown(bodyMD)
+	}
+	slugs := internalPostLinks(bodyHTML, s.basePath, s.canonicalHostname())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM article_links WHERE source_id=$1`, id); err != nil {
+		return err
+	}
+	if len(slugs) > 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO article_links (source_id, target_slug)
+			SELECT $1::uuid, unnest($2::text[])
+			ON CONFLICT DO NOTHING`, id, slugs)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *server) refreshLinkGraph(id string) {
+	if err := s.indexArticleLinks(context.Background(), id); err != nil {
+		warnf("更新文章 %s 的内链失败: %v\n", id, err)
+	}
+}
+
+// rebuildLinkGraph reparses every article; run at startup so links written
+// before the table existed (or by slug-migrate) are picked up.
+func (s *server) rebuildLinkGraph(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM articles`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := s.indexArticleLinks(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// linkedArticle is one end of an internal link.
+type linkedArticle struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Slug   string `json:"slug"`
+	Status string `json:"status"`
+	Type   string `json:"type"`
+}
+
+// backlinks lists the articles whose bodies link to article id, directly or
+// through one of its retired slugs.
+func (s *server) backlinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	var exists bool
+	if err := s.readQueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM articles WHERE id=$1)`, id).Scan(&exists); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	if !exists {
+		respondError(c, http.StatusNotFound, errArticleNotFound)
+		return
+	}
+	rows, err := s.readQuery(ctx, `
+		SELECT DISTINCT src.id, src.title, src.slug, src.status, src.type
+		FROM articles dst
+		JOIN article_links l ON l.target_slug = dst.slug
+		    OR l.target_slug IN (SELECT old_slug FROM slug_redirects WHERE article_id = dst.id)
+		JOIN articles src ON src.id = l.source_id
+		WHERE dst.id=$1 AND src.id <> dst.id
+		ORDER BY src.title`, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	defer rows.Close()
+	items := []linkedArticle{}
+	for rows.Next() {
+		var a linkedArticle
+		if err := rows.Scan(&a.ID, &a.Title, &a.Slug, &a.Status, &a.Type); err != nil {
+			respondError(c, http.StatusInternalServerError, errParseArticlesFailed)
+			return
+		}
+		items = append(items, a)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+type orphanPost struct {
+	linkedArticle
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+}
+
+// orphanPosts reports published posts that no other published article links
+// to.
+func (s *server) orphanPosts(c *gin.Context) {
+	rows, err := s.readQuery(c.Request.Context(), `
+		SELECT dst.id, dst.title, dst.slug, dst.status, dst.type, dst.published_at
+		FROM articles dst
+		WHERE dst.status='published' AND dst.type='post'
+		  AND NOT EXISTS (
+			SELECT 1
+			FROM article_links l
+			JOIN articles src ON src.id = l.source_id
+			WHERE src.status='published' AND src.id <> dst.id
+			  AND (l.target_slug = dst.slug
+			       OR l.target_slug IN (SELECT old_slug FROM slug_redirects WHERE article_id = dst.id)))
+		ORDER BY COALESCE(dst.published_at, dst.created_at) DESC`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	defer rows.Close()
+	loc := s.siteLocation()
+	items := []orphanPost{}
+	for rows.Next() {
+		var o orphanPost
+		if err := rows.Scan(&o.ID, &o.Title, &o.Slug, &o.Status, &o.Type, &o.PublishedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, errParseArticlesFailed)
+			return
+		}
+		if o.PublishedAt != nil {
+			t := o.PublishedAt.In(loc)
+			o.PublishedAt = &t
+		}
+		items = append(items, o)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
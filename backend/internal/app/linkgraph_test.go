@@ -0,0 +1,21 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInternalPostLinks(t *testing.T) {
+	body := `<p><a href="/blog/post/hello">a</a> <a href='https://example.com/blog/post/world/?x=1&amp;y=2'>b</a>
+<a href="https://other.net/blog/post/nope">c</a> <a href="/post/no-base">d</a>
+<a href="/blog/post/hello#top">dup</a> <a href="/blog/category/go">e</a> <a href="mailto:x@y">f</a>
+<a href="/blog/post/%E4%BD%A0%E5%A5%BD">g</a></p>`
+	got := internalPostLinks(body, "/blog", "example.com")
+	want := []string{"hello", "world", "你好"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := internalPostLinks(`<a href="https://example.com/post/x">x</a>`, "", ""); len(got) != 0 {
+		t.Fatalf("absolute links without a canonical host should be ignored: %v", got)
+	}
+}
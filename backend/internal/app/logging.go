@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logLevel gates the "info:" and "warn:" lines and gin's request log. It is
+// package-level because those lines are printed from helpers that have no
+// server at hand; reloadConfig changes it at runtime.
+type logLevel int32
+
+const (
+	levelInfo logLevel = iota
+	levelWarn
+	levelError
+)
+
+var currentLogLevel atomic.Int32
+
+// parseLogLevel accepts "info" (the default), "warn" and "error". "warn"
+// drops informational lines and the request log; "error" drops warnings too.
+func parseLogLevel(s string) (logLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "info":
+		return levelInfo, nil
+	case "warn", "warning":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	}
+	return 0, fmt.Errorf("logLevel 无效: %s（可选 info、warn、error）", s)
+}
+
+func setLogLevel(l logLevel) { currentLogLevel.Store(int32(l)) }
+
+func logEnabled(l logLevel) bool { return l >= logLevel(currentLogLevel.Load()) }
+
+func infof(format string, args ...any) {
+	if logEnabled(levelInfo) {
+		fmt.Printf("info: "+format, args...)
+	}
+}
+
+func warnf(format string, args ...any) {
+	if logEnabled(levelWarn) {
+		fmt.Printf("warn: "+format, args...)
+	}
+}
+
+// requestLogger is gin's request log, silenced above the info level.
+func requestLogger() gin.HandlerFunc {
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Skip: func(*gin.Context) bool { return !logEnabled(levelInfo) },
+	})
+}
@@ -0,0 +1,20 @@
+package app
+
+import "testing"
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]logLevel{"": levelInfo, "INFO": levelInfo, "warn": levelWarn, "error": levelError} {
+		if got, err := parseLogLevel(in); err != nil || got != want {
+			t.Errorf("parseLogLevel(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Fatal("unknown level accepted")
+	}
+
+	defer setLogLevel(levelInfo)
+	setLogLevel(levelWarn)
+	if logEnabled(levelInfo) || !logEnabled(levelWarn) {
+		t.Fatal("warn level should drop info and keep warnings")
+	}
+}
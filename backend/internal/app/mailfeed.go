@@ -0,0 +1,353 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// mailFeedEntries is how many of a folder's newest messages a feed lists.
+	mailFeedEntries = 20
+	// mailFeedTTL is how long a rendered feed is reused, so frequent polling
+	// does not turn into frequent IMAP logins.
+	mailFeedTTL = 10 * time.Minute
+
+	maxMailFeedTitle = 200
+)
+
+// mailFeed publishes an IMAP folder as a private Atom feed, say the folder
+// newsletters get moved to, for reading in a feed reader. Like preview
+// links, only the SHA-256 of the token in its URL is stored.
+type mailFeed struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"accountId"`
+	Folder    string    `json:"folder"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Token and URL are only returned when the link is created or rotated.
+	Token string `json:"token,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+func (s *server) ensureMailFeedSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS mail_feeds (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			account_id UUID NOT NULL REFERENCES imap_accounts(id) ON DELETE CASCADE,
+			folder TEXT NOT NULL DEFAULT 'INBOX',
+			title TEXT NOT NULL DEFAULT '',
+			token_hash TEXT NOT NULL UNIQUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
+
+func mailFeedURL(base, token string) string {
+	return base + "/feeds/mail/" + token
+}
+
+// fetchImapFolder returns the newest limit messages of folder with their
+// bodies, newest first, and the folder's UIDVALIDITY.
+func fetchImapFolder(acc imapAccount, folder string, limit int) ([]imapMessage, uint32, error) {
+	c, err := dialImap(acc)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.Logout()
+
+	if err := c.Login(acc.Username, acc.Password); err != nil {
+		return nil, 0, err
+	}
+	mbox, err := c.Select(folder, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	if mbox.Messages == 0 {
+		return []imapMessage{}, mbox.UidValidity, nil
+	}
+	var from uint32 = 1
+	if mbox.Messages > uint32(limit) {
+		from = mbox.Messages - uint32(limit) + 1
+	}
+	set := new(imap.SeqSet)
+	set.AddRange(from, mbox.Messages)
+
+	section := &imap.BodySectionName{}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchUid, section.FetchItem()}
+	ch := make(chan *imap.Message, limit)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Fetch(set, items, ch)
+	}()
+	var out []imapMessage
+	for m := range ch {
+		if m == nil || m.Envelope == nil {
+			continue
+		}
+		out = append(out, imapMessageFromFetch(m, section))
+	}
+	if err := <-done; err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UID > out[j].UID })
+	return out, mbox.UidValidity, nil
+}
+
+// buildMailAtom renders messages as the Atom document of feed f.
+func buildMailAtom(f mailFeed, selfURL string, uidValidity uint32, msgs []imapMessage) ([]byte, error) {
+	title := f.Title
+	if title == "" {
+		title = f.Folder
+	}
+	doc := atomFeed{
+		ID:    "urn:selfecho:mail-feed:" + f.ID,
+		Title: title,
+		Links: []atomLink{{Href: selfURL, Rel: "self", Type: "application/atom+xml"}},
+	}
+	latest := f.CreatedAt
+	for _, m := range msgs {
+		date, err := time.Parse(time.RFC3339, m.Date)
+		if err != nil {
+			date = f.CreatedAt
+		}
+		if date.After(latest) {
+			latest = date
+		}
+		content := m.Body
+		if content == "" {
+			content = escapeText(m.plain)
+		}
+		subject := m.Subject
+		if subject == "" {
+			subject = "(无主题)"
+		}
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        "urn:selfecho:mail:" + f.ID + ":" + strconv.FormatUint(uint64(uidValidity), 10) + ":" + strconv.FormatUint(uint64(m.UID), 10),
+			Title:     subject,
+			Updated:   date.Format(time.RFC3339),
+			Published: date.Format(time.RFC3339),
+			Author:    &atomPerson{Name: m.From, Email: m.From},
+			Summary:   &atomText{Type: "text", Body: m.Snippet},
+			Content:   &atomText{Type: "html", Body: content},
+		})
+	}
+	doc.Updated = latest.Format(time.RFC3339)
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+type renderedMailFeed struct {
+	body []byte
+	at   time.Time
+}
+
+// mailFeedCache keeps rendered feeds by feed ID for mailFeedTTL.
+type mailFeedCache struct {
+	mu    sync.Mutex
+	feeds map[string]renderedMailFeed
+}
+
+var mailFeeds = &mailFeedCache{feeds: map[string]renderedMailFeed{}}
+
+func (mc *mailFeedCache) get(id string, now time.Time) ([]byte, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	f, ok := mc.feeds[id]
+	if !ok || now.Sub(f.at) > mailFeedTTL {
+		return nil, false
+	}
+	return f.body, true
+}
+
+func (mc *mailFeedCache) put(id string, body []byte, now time.Time) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for k, f := range mc.feeds {
+		if now.Sub(f.at) > mailFeedTTL {
+			delete(mc.feeds, k)
+		}
+	}
+	mc.feeds[id] = renderedMailFeed{body: body, at: now}
+}
+
+func (mc *mailFeedCache) forget(id string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.feeds, id)
+}
+
+// serveMailFeed answers /feeds/mail/:token. Unknown tokens 404; a folder
+// that cannot be read is a 502 so feed readers keep the entries they have.
+func (s *server) serveMailFeed(c *gin.Context) {
+	ctx := c.Request.Context()
+	token := strings.TrimSpace(c.Param("token"))
+	var f mailFeed
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, account_id, folder, title, created_at FROM mail_feeds WHERE token_hash=$1`, hashPreviewToken(token)).
+		Scan(&f.ID, &f.AccountID, &f.Folder, &f.Title, &f.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) || token == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("X-Robots-Tag", "noindex")
+	now := time.Now()
+	if body, ok := mailFeeds.get(f.ID, now); ok {
+		c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", body)
+		return
+	}
+
+	acc, err := s.pickImapAccount(ctx, f.AccountID)
+	if err != nil || acc == nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	msgs, uidValidity, err := fetchImapFolder(*acc, f.Folder, mailFeedEntries)
+	if err != nil {
+		warnf("读取邮件订阅源 %s 失败: %v\n", f.Folder, err)
+		c.Status(http.StatusBadGateway)
+		return
+	}
+	body, err := buildMailAtom(f, mailFeedURL(s.baseURL(c), token), uidValidity, msgs)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	mailFeeds.put(f.ID, body, now)
+	c.Data(http.StatusOK, "application/atom+xml; charset=utf-8", body)
+}
+
+func (s *server) listMailFeeds(c *gin.Context) {
+	rows, err := s.db.QueryContext(c.Request.Context(), `
+		SELECT id, account_id, folder, title, created_at FROM mail_feeds ORDER BY created_at`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryMailFeedsFailed)
+		return
+	}
+	defer rows.Close()
+	items := []mailFeed{}
+	for rows.Next() {
+		var f mailFeed
+		if err := rows.Scan(&f.ID, &f.AccountID, &f.Folder, &f.Title, &f.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryMailFeedsFailed)
+			return
+		}
+		f.CreatedAt = f.CreatedAt.In(s.siteLocation())
+		items = append(items, f)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryMailFeedsFailed)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// createMailFeed publishes a folder of an account, INBOX by default, and
+// returns its URL once.
+func (s *server) createMailFeed(c *gin.Context) {
+	var payload struct {
+		AccountID string `json:"accountId"`
+		Folder    string `json:"folder"`
+		Title     string `json:"title"`
+	}
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	folder := strings.TrimSpace(payload.Folder)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	if strings.ContainsAny(folder, "\r\n") {
+		respondError(c, http.StatusBadRequest, errInvalidImapFolder)
+		return
+	}
+	acc, ok := s.lookupImapAccount(c, strings.TrimSpace(payload.AccountID))
+	if !ok {
+		return
+	}
+	token, err := newPreviewToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errSaveMailFeedFailed)
+		return
+	}
+	f := mailFeed{AccountID: acc.ID, Folder: folder, Title: truncateRunes(collapseWhitespace(payload.Title), maxMailFeedTitle), Token: token}
+	err = s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO mail_feeds (account_id, folder, title, token_hash) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`, f.AccountID, f.Folder, f.Title, hashPreviewToken(token)).Scan(&f.ID, &f.CreatedAt)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveMailFeedFailed, err)
+		return
+	}
+	f.CreatedAt = f.CreatedAt.In(s.siteLocation())
+	f.URL = mailFeedURL(s.baseURL(c), token)
+	c.JSON(http.StatusCreated, f)
+}
+
+// rotateMailFeed replaces a feed's token; the old URL stops working.
+func (s *server) rotateMailFeed(c *gin.Context) {
+	id, ok := idParam(c, "id", errMailFeedNotFound)
+	if !ok {
+		return
+	}
+	token, err := newPreviewToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errSaveMailFeedFailed)
+		return
+	}
+	var f mailFeed
+	err = s.db.QueryRowContext(c.Request.Context(), `
+		UPDATE mail_feeds SET token_hash=$2 WHERE id=$1
+		RETURNING id, account_id, folder, title, created_at`, id, hashPreviewToken(token)).
+		Scan(&f.ID, &f.AccountID, &f.Folder, &f.Title, &f.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errMailFeedNotFound)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveMailFeedFailed, err)
+		return
+	}
+	mailFeeds.forget(f.ID)
+	f.CreatedAt = f.CreatedAt.In(s.siteLocation())
+	f.Token = token
+	f.URL = mailFeedURL(s.baseURL(c), token)
+	c.JSON(http.StatusOK, f)
+}
+
+func (s *server) deleteMailFeed(c *gin.Context) {
+	id, ok := idParam(c, "id", errMailFeedNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM mail_feeds WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveMailFeedFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errMailFeedNotFound)
+		return
+	}
+	mailFeeds.forget(id)
+	c.Status(http.StatusNoContent)
+}
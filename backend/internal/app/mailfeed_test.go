@@ -0,0 +1,52 @@
+package app
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestFetchImapFolderAtom(t *testing.T) {
+	acc, _ := newTestImapTLSServer(t)
+	acc.InsecureSkipVerify = true
+	msgs, uidValidity, err := fetchImapFolder(acc, "INBOX", mailFeedEntries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Subject == "" || msgs[0].Body == "" && msgs[0].plain == "" {
+		t.Fatalf("messages = %+v", msgs)
+	}
+	if _, _, err := fetchImapFolder(acc, "Missing", mailFeedEntries); err == nil {
+		t.Fatal("fetched a folder that does not exist")
+	}
+
+	f := mailFeed{ID: "f1", Folder: "INBOX", CreatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	out, err := buildMailAtom(f, "https://blog.example/feeds/mail/tok", uidValidity, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc atomFeed
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Title != "INBOX" || len(doc.Entries) != 1 || doc.Entries[0].Title != msgs[0].Subject ||
+		doc.Entries[0].Content == nil || doc.Entries[0].Content.Type != "html" || atomAlternate(doc.Links) != "" {
+		t.Fatalf("feed = %+v", doc)
+	}
+}
+
+func TestMailFeedCache(t *testing.T) {
+	mc := &mailFeedCache{feeds: map[string]renderedMailFeed{}}
+	now := time.Now()
+	mc.put("a", []byte("x"), now)
+	if b, ok := mc.get("a", now.Add(time.Minute)); !ok || string(b) != "x" {
+		t.Fatal("fresh feed missed")
+	}
+	if _, ok := mc.get("a", now.Add(mailFeedTTL+time.Second)); ok {
+		t.Fatal("stale feed served")
+	}
+	mc.forget("a")
+	if _, ok := mc.get("a", now); ok {
+		t.Fatal("forgotten feed served")
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// markdownExtensions is blackfriday's default set plus footnotes, which
+// CommonExtensions leaves out ("[^1]" would otherwise render literally).
+const markdownExtensions = blackfriday.CommonExtensions | blackfriday.Footnotes
+
+func renderMarkdown(md string) string {
+	md, repeats := prepareFootnotes(md)
+	// the renderer keeps per-document state, so each call gets its own
+	r := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: blackfriday.CommonHTMLFlags | blackfriday.FootnoteReturnLinks,
+	})
+	out := string(blackfriday.Run([]byte(md), blackfriday.WithExtensions(markdownExtensions), blackfriday.WithRenderer(r)))
+	return joinRepeatedFootnotes(out, repeats)
+}
+
+// footnoteRefRe matches a footnote reference or, at the start of a line and
+// followed by ':', a definition.
+var footnoteRefRe = regexp.MustCompile(`\[\^([^\]\s]+)\]`)
+
+// prepareFootnotes works around two blackfriday footnote bugs. Labels are
+// anchored by their ASCII letters and digits only, so labels without any (or
+// that collide) are renamed "note-<n>". A note referenced more than once is
+// numbered again with its text repeated, so every reference after the first
+// becomes a placeholder for joinRepeatedFootnotes to turn back into a link
+// once the HTML is rendered; repeats holds their (renamed) labels.
+func prepareFootnotes(md string) (out string, repeats []string) {
+	names := map[string]string{}
+	anchors := map[string]bool{}
+	seen := map[string]bool{}
+	var b strings.Builder
+	last := 0
+	for _, m := range footnoteRefRe.FindAllStringSubmatchIndex(md, -1) {
+		start, end := m[0], m[1]
+		label := md[m[2]:m[3]]
+		name, ok := names[label]
+		if !ok {
+			name = label
+			for n := len(names) + 1; footnoteSlug(name) == "" || anchors[footnoteSlug(name)]; n++ {
+				name = fmt.Sprintf("note-%d", n)
+			}
+			anchors[footnoteSlug(name)] = true
+			names[label] = name
+		}
+		b.WriteString(md[last:start])
+		last = end
+		definition := end < len(md) && md[end] == ':' && strings.TrimLeft(md[strings.LastIndexByte(md[:start], '\n')+1:start], " ") == ""
+		if definition || !seen[name] {
+			seen[name] = seen[name] || !definition
+			b.WriteString("[^" + name + "]")
+			continue
+		}
+		b.WriteString(footnotePlaceholder(len(repeats)))
+		repeats = append(repeats, name)
+	}
+	if last == 0 {
+		return md, nil
+	}
+	b.WriteString(md[last:])
+	return b.String(), repeats
+}
+
+func footnotePlaceholder(i int) string {
+	return fmt.Sprintf("sefnrepeat%dx", i)
+}
+
+// joinRepeatedFootnotes replaces the placeholders with references to the
+// note the first one points at. Each gets its own id ("fnref:<note>:2"); the
+// note's return link goes back to the first. A placeholder whose note was
+// never rendered (the label was in a code span) gets its text back.
+func joinRepeatedFootnotes(out string, repeats []string) string {
+	uses := map[string]int{}
+	for i, label := range repeats {
+		frag := footnoteSlug(label)
+		first := regexp.MustCompile(`<sup class="footnote-ref" id="fnref:` + regexp.QuoteMeta(frag) + `"><a href="#fn:` + regexp.QuoteMeta(frag) + `">(\d+)</a></sup>`).FindStringSubmatch(out)
+		ref := "[^" + label + "]"
+		if first != nil {
+			uses[frag]++
+			ref = fmt.Sprintf(`<sup class="footnote-ref" id="fnref:%s:%d"><a href="#fn:%s">%s</a></sup>`, frag, uses[frag]+1, frag, first[1])
+		}
+		out = strings.Replace(out, footnotePlaceholder(i), ref, 1)
+	}
+	return out
+}
+
+// footnoteSlug is how blackfriday turns a footnote label into an anchor:
+// runs of anything but ASCII letters and digits become one '-', trimmed at
+// both ends.
+func footnoteSlug(label string) string {
+	var b strings.Builder
+	dash := false
+	for i := 0; i < len(label); i++ {
+		ch := label[i]
+		if ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' {
+			b.WriteByte(ch)
+			dash = false
+		} else if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// countFootnotes is the number of notes in the footnote list of rendered
+// HTML; repeated references to one note count once.
+func countFootnotes(bodyHTML string) int {
+	i := strings.Index(bodyHTML, `<div class="footnotes">`)
+	if i < 0 {
+		return 0
+	}
+	return strings.Count(bodyHTML[i:], `<li id="fn:`)
+}
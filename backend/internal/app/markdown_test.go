@@ -0,0 +1,63 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdownFootnotes(t *testing.T) {
+	out := renderMarkdown("Claim[^src] and again[^src], plus more.[^2]\n\n[^src]: The source.\n[^2]: Another note.\n")
+	for _, want := range []string{
+		`<sup class="footnote-ref" id="fnref:src"><a href="#fn:src">1</a></sup>`,
+		`<sup class="footnote-ref" id="fnref:src:2"><a href="#fn:src">1</a></sup>`,
+		`<sup class="footnote-ref" id="fnref:2"><a href="#fn:2">2</a></sup>`,
+		`<li id="fn:src">The source. <a class="footnote-return" href="#fnref:src">`,
+		`<div class="footnotes">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "[^") || strings.Contains(out, "sefnrepeat") {
+		t.Errorf("footnote syntax left in output:\n%s", out)
+	}
+	if n := countFootnotes(out); n != 2 {
+		t.Errorf("countFootnotes = %d, want 2", n)
+	}
+	if n := countFootnotes(renderMarkdown("no notes")); n != 0 {
+		t.Errorf("countFootnotes without notes = %d", n)
+	}
+}
+
+func TestRenderMarkdownRepeatedFootnoteInCode(t *testing.T) {
+	out := renderMarkdown("Write `[^x]` and `[^x]` for a note.")
+	if strings.Count(out, "[^x]") != 2 || strings.Contains(out, "footnote-ref") {
+		t.Fatalf("code spans changed: %s", out)
+	}
+}
+
+func TestFootnoteSlug(t *testing.T) {
+	for in, want := range map[string]string{"1": "1", "my note": "my-note", "--a__b--": "a-b", "注释": ""} {
+		if got := footnoteSlug(in); got != want {
+			t.Errorf("footnoteSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRenderMarkdownFootnoteLabels(t *testing.T) {
+	out := renderMarkdown("甲[^注释]乙[^说明]丙[^注释]\n\n[^注释]: 第一条\n[^说明]: 第二条\n")
+	for _, want := range []string{
+		`id="fnref:note-1"><a href="#fn:note-1">1</a>`,
+		`id="fnref:note-2"><a href="#fn:note-2">2</a>`,
+		`id="fnref:note-1:2"><a href="#fn:note-1">1</a>`,
+		`<li id="fn:note-1">第一条`,
+		`<li id="fn:note-2">第二条`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+	if n := countFootnotes(out); n != 2 {
+		t.Errorf("countFootnotes = %d, want 2", n)
+	}
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// maxFetchRedirects matches net/http's own redirect limit.
+const maxFetchRedirects = 10
+
+var errPrivateAddress = errors.New("拒绝访问内网地址")
+
+// publicAddr reports whether ip is a public internet address; loopback,
+// private, link-local, unspecified and multicast addresses are not.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// dialPublicOnly is a net.Dialer Control hook. It sees the resolved address
+// of every connection, so a hostname pointing inside the network or a
+// redirect to one is refused as well.
+func dialPublicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !publicAddr(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// newPublicClient is the client for URLs that users, posts and feeds supply
+// (bookmarks, feeds, remote images, webhooks): it only dials public
+// addresses, so those URLs cannot reach the loopback interface, the LAN or
+// cloud metadata endpoints.
+func newPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would make the connection on our behalf, out of the guard's sight
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to %q refused", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
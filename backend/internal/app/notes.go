@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Notes are short microblog posts. Their title is optional and they are
+// addressed by ID (/notes/<id>); the slug column only keeps its uniqueness
+// constraint satisfied and never shows up in a URL.
+const (
+	maxNoteRunes  = 1000
+	notesPageSize = 20
+	// noteTitleRunes is how much of an untitled note stands in for its title
+	// in page titles and feeds.
+	noteTitleRunes = 60
+)
+
+func validArticleType(t string) bool {
+	return t == "post" || t == "memo" || t == "note"
+}
+
+func validateNote(v *validator, p articlePayload) {
+	v.check(maxRunes(strings.TrimSpace(p.Title), maxTitleRunes), "title", errTitleTooLong)
+	if v.check(strings.TrimSpace(p.BodyMD) != "" || strings.TrimSpace(p.BodyHTML) != "", "bodyMd", errNoteEmpty) {
+		v.check(maxRunes(strings.TrimSpace(p.BodyMD), maxNoteRunes), "bodyMd", errNoteTooLong)
+	}
+	// the unlock flow lives on /post/<slug>, which notes don't have
+	if p.Visibility != nil {
+		v.check(*p.Visibility != visibilityPassword, "visibility", errInvalidVisibility, *p.Visibility)
+	}
+}
+
+// articleSlug is makeSlug for the payload, except that notes without an
+// explicit slug keep the one they have (id is "" on create) or get a random
+// one.
+func (s *server) articleSlug(ctx context.Context, p articlePayload, id string) (string, error) {
+	if p.Type != "note" || strings.TrimSpace(p.Slug) != "" {
+		return makeSlug(p.Title, p.Slug)
+	}
+	if id != "" {
+		var current string
+		err := s.db.QueryRowContext(ctx, `SELECT slug FROM articles WHERE id=$1 AND type='note'`, id).Scan(&current)
+		if err == nil {
+			return current, nil
+		}
+		// a lookup failure only costs the note its old internal slug
+		if !errorsIsNotFound(err) {
+			warnf("查询短文 slug 失败: %v\n", err)
+		}
+	}
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "note-" + hex.EncodeToString(buf), nil
+}
+
+// noteTitle is the note's title, or the start of its text when it has none.
+func noteTitle(a article) string {
+	if t := strings.TrimSpace(a.Title); t != "" {
+		return t
+	}
+	return excerptFromArticle(a, noteTitleRunes)
+}
+
+func notePath(id string) string {
+	return "/notes/" + urlPathEscape(id)
+}
+
+func (s *server) countPublishedNotes(ctx context.Context) (int, error) {
+	var total int
+	err := s.readQueryRow(ctx, `
+		SELECT COUNT(*) FROM articles
+		WHERE status='published' AND type='note' AND visibility = 'public'`).Scan(&total)
+	return total, err
+}
+
+// queryPublishedNotes lists a page of public notes, newest first, with their
+// full body.
+func (s *server) queryPublishedNotes(ctx context.Context, page, limit int) ([]article, error) {
+	if page < 1 {
+		page = 1
+	}
+	rows, err := s.readQuery(ctx, `
+		SELECT id, title, slug, body_md, body_html, published_at, created_at, updated_at
+		FROM articles
+		WHERE status='published' AND type='note' AND visibility = 'public'
+		ORDER BY COALESCE(published_at, created_at) DESC, created_at DESC
+		LIMIT $1 OFFSET $2`, limit, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []article
+	for rows.Next() {
+		a := article{Type: "note", Status: "published"}
+		var publishedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Title, &a.Slug, &a.BodyMD, &a.BodyHTML, &publishedAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if publishedAt.Valid {
+			a.PublishedAt = &publishedAt.Time
+		}
+		s.openArticle(&a)
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+type noteDate struct {
+	ID      string
+	Updated time.Time
+}
+
+// queryPublishedNoteDates returns the ID and last change of every public note,
+// for the sitemap.
+func (s *server) queryPublishedNoteDates(ctx context.Context) ([]noteDate, error) {
+	rows, err := s.readQuery(ctx, `
+		SELECT id, updated_at FROM articles
+		WHERE status='published' AND type='note' AND visibility = 'public'
+		ORDER BY updated_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []noteDate
+	for rows.Next() {
+		var it noteDate
+		if err := rows.Scan(&it.ID, &it.Updated); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+func (s *server) noteBody(ctx context.Context, a article) string {
+	bodyHTML := strings.TrimSpace(a.BodyHTML)
+	if bodyHTML == "" {
+		bodyHTML = renderMarkdown(a.BodyMD)
+	}
+	return s.proxyImages(s.expandFileShortcodes(ctx, s.expandGallery(ctx, a.ID, bodyHTML)))
+}
+
+func (s *server) writeNote(b *strings.Builder, ctx context.Context, a article, link bool) {
+	date := articleFeedDate(a)
+	b.WriteString(`<article class="note space-y-2 border-b border-slate-100 pb-6">`)
+	if t := strings.TrimSpace(a.Title); t != "" {
+		b.WriteString(`<h2 class="text-[1.2rem] font-semibold text-[#3d3d3f]">` + html.EscapeString(t) + `</h2>`)
+	}
+	b.WriteString(`<div class="article-body space-y-3 text-[16px] leading-8 text-[#3d3d3f]">` + s.noteBody(ctx, a) + `</div>`)
+	stamp := `<time datetime="` + date.Format(time.RFC3339) + `">` + html.EscapeString(s.formatSiteTime(date)) + `</time>`
+	if link {
+		stamp = `<a href="` + s.basePath + notePath(a.ID) + `" class="hover:underline">` + stamp + `</a>`
+	}
+	b.WriteString(`<p class="text-xs text-[#aaa]">` + stamp + `</p>`)
+	b.WriteString(`</article>`)
+}
+
+// seoNotesHandler renders /notes, the paginated stream of public notes.
+func (s *server) seoNotesHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		ctx := c.Request.Context()
+		page, ok := pageParam(c)
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		base := s.baseURL(c)
+		listURL := base + "/notes"
+
+		total, err := s.countPublishedNotes(ctx)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		pages := totalPages(total, notesPageSize)
+		if page > pages {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		notes, err := s.queryPublishedNotes(ctx, page, notesPageSize)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		prev, next := pageLinks(listURL, page, pages)
+
+		var b strings.Builder
+		b.WriteString(`<section class="mx-auto max-w-3xl space-y-6 px-6 py-8 sm:px-9 md:px-12 lg:px-[10rem]">`)
+		for _, it := range notes {
+			s.writeNote(&b, ctx, it, true)
+		}
+		b.WriteString(paginationNav(prev, next, page, pages))
+		b.WriteString(`<p class="text-xs text-[#aaa]"><a href="` + s.basePath + `/notes/feed.xml" class="hover:underline">RSS</a></p>`)
+		b.WriteString(`</section>`)
+
+		title := "短文"
+		if page > 1 {
+			title += fmt.Sprintf(" (第 %d 页)", page)
+		}
+		headExtras := seoHead(siteTitle, title, "短文列表", withPage(listURL, page), "website", "")
+		headExtras += rssAlternateLink("短文", base+"/notes/feed.xml")
+		s.writeSSR(c, spa, title, headExtras, b.String())
+	}
+}
+
+// seoNoteHandler renders a single note at /notes/:id.
+func (s *server) seoNoteHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		ctx := c.Request.Context()
+		id := strings.TrimSpace(c.Param("id"))
+		if !store.ValidID(id) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		a, ok, err := s.queryPost(ctx, `art.status='published' AND art.type='note' AND art.visibility <> 'password' AND art.id=$1`, id)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		base := s.baseURL(c)
+		canonical := base + notePath(a.ID)
+		title := noteTitle(a)
+		desc := a.Description
+		if desc == "" {
+			desc = excerptFromArticle(a, 180)
+		}
+		date := articleFeedDate(a).In(s.siteLocation())
+		publisher := publisherEntity(s.siteSettings(), base+"/")
+		posting := map[string]any{
+			"@type":            "SocialMediaPosting",
+			"headline":         title,
+			"inLanguage":       s.contentLang(a.Lang),
+			"datePublished":    date.Format(time.RFC3339),
+			"dateModified":     a.UpdatedAt.In(s.siteLocation()).Format(time.RFC3339),
+			"mainEntityOfPage": canonical,
+			"url":              canonical,
+			"publisher":        map[string]any{"@id": publisher["@id"]},
+		}
+		var card socialCard
+		if a.Social != nil {
+			card = *a.Social
+		}
+		headExtras := seoHeadCard(siteTitle, title, desc, canonical, "article", jsonLDGraph(posting, publisher), card)
+		if a.Visibility == visibilityUnlisted {
+			headExtras += `<meta name="robots" content="noindex">`
+		}
+
+		var b strings.Builder
+		b.WriteString(`<section class="mx-auto max-w-3xl space-y-5 px-6 py-8 sm:px-9 md:px-12 lg:px-[10rem]">`)
+		s.writeNote(&b, ctx, a, false)
+		b.WriteString(`<div class="pt-2"><a href="` + s.basePath + `/notes" class="text-sm text-[#3c546c] hover:underline">← 全部短文</a></div>`)
+		b.WriteString(`</section>`)
+		s.writeSSRLang(c, spa, s.contentLang(a.Lang), title, headExtras, b.String())
+	}
+}
+
+// seoNotesFeedHandler is the RSS feed of public notes. Items carry the whole
+// note since there is no "read more" to click through to.
+func (s *server) seoNotesFeedHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		base := s.baseURL(c)
+		notes, err := s.queryPublishedNotes(ctx, 1, 20)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		title := "短文"
+		if siteTitle := s.siteSettings().Title; siteTitle != "" {
+			title = siteTitle + " - " + title
+		}
+		doc := rssDocument{
+			Version: "2.0",
+			Atom:    "http://www.w3.org/2005/Atom",
+			Channel: rssChannel{
+				Title:       title,
+				Link:        base + "/notes",
+				Description: "短文",
+				AtomLink:    rssAtomLn{Href: base + "/notes/feed.xml", Rel: "self", Type: "application/rss+xml"},
+			},
+		}
+		for i, it := range notes {
+			date := articleFeedDate(it).In(s.siteLocation())
+			if i == 0 {
+				doc.Channel.LastBuildDate = date.Format(time.RFC1123Z)
+			}
+			link := base + notePath(it.ID)
+			doc.Channel.Items = append(doc.Channel.Items, rssItem{
+				Title:       noteTitle(it),
+				Link:        link,
+				GUID:        rssGUID{IsPermaLink: true, Value: link},
+				PubDate:     date.Format(time.RFC1123Z),
+				Description: s.noteBody(ctx, it),
+			})
+		}
+		out, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Header("Vary", "Host, X-Forwarded-Proto, X-Forwarded-Host")
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), out...))
+	}
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateNotePayload(t *testing.T) {
+	if err := validatePayload(articlePayload{Type: "note", Status: "published", BodyMD: "just a thought"}); err != nil {
+		t.Fatalf("untitled note rejected: %v", err)
+	}
+	if err := validatePayload(articlePayload{Type: "post", Status: "published", BodyMD: "body"}); err == nil {
+		t.Fatal("untitled post accepted")
+	}
+	password := visibilityPassword
+	for name, p := range map[string]articlePayload{
+		"empty":    {Type: "note", Status: "draft", BodyMD: "  "},
+		"long":     {Type: "note", Status: "draft", BodyMD: strings.Repeat("字", maxNoteRunes+1)},
+		"password": {Type: "note", Status: "draft", BodyMD: "x", Visibility: &password},
+		"type":     {Type: "status", Status: "draft", Title: "x"},
+	} {
+		if err := validatePayload(p); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestNoteSlugAndTitle(t *testing.T) {
+	s := &server{}
+	slug, err := s.articleSlug(context.Background(), articlePayload{Type: "note"}, "")
+	if err != nil || !strings.HasPrefix(slug, "note-") || !validSlugInput(slug) {
+		t.Fatalf("slug = %q, %v", slug, err)
+	}
+	if slug, _ := s.articleSlug(context.Background(), articlePayload{Type: "note", Slug: "Hello There"}, ""); slug != "hello-there" {
+		t.Fatalf("explicit slug = %q", slug)
+	}
+
+	if got := noteTitle(article{Title: " Titled "}); got != "Titled" {
+		t.Fatalf("titled = %q", got)
+	}
+	long := strings.Repeat("a", noteTitleRunes+20)
+	if got := noteTitle(article{BodyMD: "**" + long + "**"}); !strings.HasPrefix(got, "aaa") || len([]rune(got)) > noteTitleRunes+1 {
+		t.Fatalf("untitled = %q", got)
+	}
+}
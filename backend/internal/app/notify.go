@@ -0,0 +1,77 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sseHeartbeat = 25 * time.Second
+
+// notifyHub fans bus events out to connected admin SSE clients. Slow clients
+// miss events rather than blocking publishers.
+type notifyHub struct {
+	mu      sync.Mutex
+	clients map[chan changeEvent]struct{}
+}
+
+func newNotifyHub() *notifyHub {
+	return &notifyHub{clients: make(map[chan changeEvent]struct{})}
+}
+
+func (h *notifyHub) add() chan changeEvent {
+	ch := make(chan changeEvent, 16)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *notifyHub) remove(ch chan changeEvent) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+func (h *notifyHub) broadcast(ev changeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// adminEvents streams bus events to the admin SPA as Server-Sent Events; the
+// SSE event name is the event kind.
+func (s *server) adminEvents(c *gin.Context) {
+	ch := s.notify.add()
+	defer s.notify.remove(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+	ctx := c.Request.Context()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev := <-ch:
+			c.SSEvent(string(ev.Kind), ev)
+			return true
+		case <-ticker.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
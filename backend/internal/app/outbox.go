@@ -0,0 +1,301 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jobWebhook delivers one webhook event; see outboxHandler for the others.
+const jobWebhook = "webhook"
+
+// Outbox job states. A job is pending until a worker claims it (running),
+// then done, or pending again with a later run_at after a failure, or dead
+// once it ran out of attempts.
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobDead    = "dead"
+)
+
+const (
+	outboxPoll        = 5 * time.Second
+	outboxMaxAttempts = 8
+	outboxBackoff     = 30 * time.Second
+	outboxMaxBackoff  = 6 * time.Hour
+	// outboxLease is how long a claimed job may run; a job still running
+	// after it belonged to a process that died and is picked up again.
+	outboxLease     = 5 * time.Minute
+	outboxRetention = `interval '7 days'`
+)
+
+// Webhook events, named like the bus events they mirror.
+const (
+	hookArticleCreated = "article.created"
+	hookArticleUpdated = "article.updated"
+	hookArticleDeleted = "article.deleted"
+)
+
+var webhookEvents = []string{hookArticleCreated, hookArticleUpdated, hookArticleDeleted}
+
+// errJobPermanent fails a job without further attempts.
+var errJobPermanent = errors.New("permanent failure")
+
+// webhookConfig subscribes URL to article changes. Deliveries are POSTed as
+// JSON and, when Secret is set, signed in X-Selfecho-Signature as
+// sha256=<hex HMAC-SHA256 of the body>.
+type webhookConfig struct {
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+	// Events limits the subscription (see webhookEvents); empty means all.
+	Events []string `yaml:"events"`
+}
+
+func (h webhookConfig) wants(event string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+func validateWebhooks(hooks []webhookConfig) error {
+	for i, h := range hooks {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d].url 需为 http(s) 地址: %q", i, h.URL)
+		}
+		for _, ev := range h.Events {
+			if !slices.Contains(webhookEvents, ev) {
+				return fmt.Errorf("webhooks[%d].events: 未知的事件 %s", i, ev)
+			}
+		}
+	}
+	return nil
+}
+
+// The outbox holds work that must follow a content change, written in the
+// same transaction as the change so a crash right after the commit cannot
+// lose it. runOutbox works through it with retries.
+func (s *server) ensureOutboxSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS outbox (
+			id BIGSERIAL PRIMARY KEY,
+			type TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox(run_at) WHERE status IN ('pending', 'running');
+		DELETE FROM outbox WHERE status = 'done' AND updated_at < now() - `+outboxRetention+`;
+	`)
+	return err
+}
+
+// articleHook is the data of the article webhook events.
+type articleHook struct {
+	ID     string `json:"id"`
+	Slug   string `json:"slug"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+}
+
+// webhookDelivery is the payload of a webhook job: one event for one URL.
+type webhookDelivery struct {
+	URL   string          `json:"url"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+	At    time.Time       `json:"at"`
+}
+
+// enqueueWebhooks writes a job per webhook subscribed to event. q should be
+// the transaction making the change; call wakeOutbox after it commits.
+func (s *server) enqueueWebhooks(ctx context.Context, q sqlQuerier, event string, data any) error {
+	var raw []byte
+	for _, h := range s.webhooks {
+		if !h.wants(event) {
+			continue
+		}
+		if raw == nil {
+			var err error
+			if raw, err = json.Marshal(data); err != nil {
+				return err
+			}
+		}
+		payload, err := json.Marshal(webhookDelivery{URL: h.URL, Event: event, Data: raw, At: time.Now()})
+		if err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, `INSERT INTO outbox (type, payload) VALUES ($1, $2)`, jobWebhook, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wakeOutbox tells the worker new jobs were committed.
+func (s *server) wakeOutbox() {
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// runOutbox processes due jobs until there are none, then waits for a wake
+// up or the next poll.
+func (s *server) runOutbox() {
+	ticker := time.NewTicker(outboxPoll)
+	defer ticker.Stop()
+	for {
+		for s.processOutboxJob(context.Background()) {
+		}
+		select {
+		case <-ticker.C:
+		case <-s.outboxWake:
+		}
+	}
+}
+
+func (s *server) outboxHandler(typ string) func(context.Context, []byte) error {
+	switch typ {
+	case jobWebhook:
+		return s.deliverWebhook
+	case jobImapSync:
+		return s.runImapSyncJob
+	case jobMailDraft:
+		return s.runMailDraftJob
+	case jobMailForward:
+		return s.runMailForwardJob
+	case jobMailWebhook:
+		return s.runMailWebhookJob
+	case jobBookmarkTitle:
+		return s.runBookmarkTitleJob
+	case jobGitSync:
+		return s.runGitSyncJob
+	}
+	return nil
+}
+
+// processOutboxJob claims and runs one due job, reporting whether there was
+// one.
+func (s *server) processOutboxJob(ctx context.Context) bool {
+	var (
+		id       int64
+		typ      string
+		payload  []byte
+		attempts int
+	)
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE outbox SET status = 'running', attempts = attempts + 1, run_at = now() + $1::interval, updated_at = now()
+		WHERE id = (
+			SELECT id FROM outbox
+			WHERE status IN ('pending', 'running') AND run_at <= now()
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, type, payload, attempts`, strconv.Itoa(int(outboxLease.Seconds()))+" seconds").
+		Scan(&id, &typ, &payload, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		warnf("读取 outbox 失败: %v\n", err)
+		return false
+	}
+
+	var runErr error
+	if handle := s.outboxHandler(typ); handle == nil {
+		runErr = fmt.Errorf("%w: 未知的任务类型 %s", errJobPermanent, typ)
+	} else {
+		runCtx, cancel := context.WithTimeout(ctx, outboxLease)
+		runErr = handle(runCtx, payload)
+		cancel()
+	}
+
+	status, runAt, lastError := jobDone, time.Now(), ""
+	if runErr != nil {
+		lastError = runErr.Error()
+		if errors.Is(runErr, errJobPermanent) || attempts >= outboxMaxAttempts {
+			status = jobDead
+			warnf("outbox 任务 %d (%s) 已放弃: %v\n", id, typ, runErr)
+		} else {
+			status, runAt = jobPending, runAt.Add(outboxRetryDelay(attempts))
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE outbox SET status = $2, run_at = $3, last_error = $4, updated_at = now() WHERE id = $1`,
+		id, status, runAt, lastError); err != nil {
+		warnf("更新 outbox 任务 %d 失败: %v\n", id, err)
+	}
+	return true
+}
+
+// outboxRetryDelay backs off exponentially from outboxBackoff.
+func outboxRetryDelay(attempts int) time.Duration {
+	d := outboxBackoff
+	for i := 1; i < attempts && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, outboxMaxBackoff)
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *server) deliverWebhook(ctx context.Context, payload []byte) error {
+	var d webhookDelivery
+	if err := json.Unmarshal(payload, &d); err != nil {
+		return fmt.Errorf("%w: %v", errJobPermanent, err)
+	}
+	i := slices.IndexFunc(s.webhooks, func(h webhookConfig) bool { return h.URL == d.URL })
+	if i < 0 {
+		return fmt.Errorf("%w: webhook %s 已不在配置中", errJobPermanent, d.URL)
+	}
+	return s.postWebhook(ctx, d.URL, s.webhooks[i].Secret, d.Event, d.Data, d.At)
+}
+
+// postWebhook POSTs one event to url, signed when secret is set. A request
+// that cannot even be built fails the job for good.
+func (s *server) postWebhook(ctx context.Context, url, secret, event string, data json.RawMessage, at time.Time) error {
+	body, err := json.Marshal(gin.H{"event": event, "data": data, "at": at})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errJobPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "selfecho-webhook")
+	req.Header.Set("X-Selfecho-Event", event)
+	if secret != "" {
+		req.Header.Set("X-Selfecho-Signature", signWebhook(secret, body))
+	}
+	resp, err := s.publicClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOutboxRetryDelay(t *testing.T) {
+	if d := outboxRetryDelay(1); d != outboxBackoff {
+		t.Fatalf("first retry after %s", d)
+	}
+	if d := outboxRetryDelay(3); d != 4*outboxBackoff {
+		t.Fatalf("third retry after %s", d)
+	}
+	if d := outboxRetryDelay(100); d != outboxMaxBackoff {
+		t.Fatalf("retry delay not capped: %s", d)
+	}
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	ok := []webhookConfig{{URL: "https://example.com/hook", Events: []string{hookArticleCreated}}}
+	if err := validateWebhooks(ok); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][]webhookConfig{
+		{{URL: "ftp://example.com"}},
+		{{URL: "/relative"}},
+		{{URL: "https://example.com", Events: []string{"comment.created"}}},
+	} {
+		if err := validateWebhooks(bad); err == nil {
+			t.Errorf("validateWebhooks(%+v) accepted", bad)
+		}
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	var gotSig, gotEvent string
+	var got map[string]any
+	status := http.StatusOK
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotSig, gotEvent = r.Header.Get("X-Selfecho-Signature"), r.Header.Get("X-Selfecho-Event")
+		json.Unmarshal(body, &got)
+		if gotSig != signWebhook("s3cret", body) {
+			t.Errorf("signature %q does not match body", gotSig)
+		}
+		w.WriteHeader(status)
+	}))
+	defer hook.Close()
+
+	s := &server{publicClient: hook.Client(), webhooks: []webhookConfig{{URL: hook.URL, Secret: "s3cret"}}}
+	payload, _ := json.Marshal(webhookDelivery{URL: hook.URL, Event: hookArticleCreated, Data: json.RawMessage(`{"id":"1"}`), At: time.Now()})
+	if err := s.deliverWebhook(context.Background(), payload); err != nil {
+		t.Fatal(err)
+	}
+	if gotEvent != hookArticleCreated || got["event"] != hookArticleCreated || got["data"].(map[string]any)["id"] != "1" {
+		t.Fatalf("delivered %q %v", gotEvent, got)
+	}
+
+	status = http.StatusBadGateway
+	if err := s.deliverWebhook(context.Background(), payload); err == nil || errors.Is(err, errJobPermanent) {
+		t.Fatalf("5xx should be retried: %v", err)
+	}
+	s.webhooks = nil
+	if err := s.deliverWebhook(context.Background(), payload); !errors.Is(err, errJobPermanent) {
+		t.Fatalf("removed webhook should fail permanently: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archivePageSize is how many posts an SSR archive or category page lists.
+const archivePageSize = 50
+
+// pageParam reads ?page=; a missing value is page 1 and anything that isn't a
+// positive integer is rejected so crawlers don't index duplicate pages.
+func pageParam(c *gin.Context) (int, bool) {
+	raw := strings.TrimSpace(c.Query("page"))
+	if raw == "" {
+		return 1, true
+	}
+	page, err := strconv.Atoi(raw)
+	if err != nil || page < 1 {
+		return 0, false
+	}
+	return page, true
+}
+
+func totalPages(total, size int) int {
+	if total <= 0 || size <= 0 {
+		return 1
+	}
+	return (total + size - 1) / size
+}
+
+// withPage sets ?page=n on raw, dropping it for page 1 so the first page keeps
+// its plain canonical URL.
+func withPage(raw string, page int) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	if page <= 1 {
+		q.Del("page")
+	} else {
+		q.Set("page", strconv.Itoa(page))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// pageLinks returns the prev/next URLs around page, empty at either end.
+func pageLinks(raw string, page, pages int) (prev, next string) {
+	if page > 1 {
+		prev = withPage(raw, page-1)
+	}
+	if page < pages {
+		next = withPage(raw, page+1)
+	}
+	return prev, next
+}
+
+func paginationHead(prev, next string) string {
+	var b strings.Builder
+	if prev != "" {
+		b.WriteString(`<link rel="prev" href="` + html.EscapeString(prev) + `">`)
+	}
+	if next != "" {
+		b.WriteString(`<link rel="next" href="` + html.EscapeString(next) + `">`)
+	}
+	return b.String()
+}
+
+func paginationNav(prev, next string, page, pages int) string {
+	if pages <= 1 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="flex justify-between py-6 text-sm" aria-label="pagination">`)
+	if prev != "" {
+		b.WriteString(`<a rel="prev" class="text-[#3273dc]" href="` + html.EscapeString(prev) + `">上一页</a>`)
+	} else {
+		b.WriteString(`<span></span>`)
+	}
+	b.WriteString(`<span class="text-[#aaa]">` + strconv.Itoa(page) + ` / ` + strconv.Itoa(pages) + `</span>`)
+	if next != "" {
+		b.WriteString(`<a rel="next" class="text-[#3273dc]" href="` + html.EscapeString(next) + `">下一页</a>`)
+	} else {
+		b.WriteString(`<span></span>`)
+	}
+	b.WriteString(`</nav>`)
+	return b.String()
+}
+
+// setPageHeaders describes a paged API response: totals plus an RFC 8288
+// Link header pointing at the neighbouring pages of the same query.
+func setPageHeaders(c *gin.Context, page, limit, total int) {
+	pages := totalPages(total, limit)
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.Header("X-Page", strconv.Itoa(page))
+	c.Header("X-Limit", strconv.Itoa(limit))
+	c.Header("X-Total-Pages", strconv.Itoa(pages))
+
+	self := c.Request.URL.RequestURI()
+	var links []string
+	if page > 1 {
+		links = append(links, `<`+withPageParam(self, page-1)+`>; rel="prev"`)
+	}
+	if page < pages {
+		links = append(links, `<`+withPageParam(self, page+1)+`>; rel="next"`)
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+}
+
+// withPageParam is withPage for API URLs, which always carry an explicit page.
+func withPageParam(raw string, page int) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// pageEnvelope is the ?envelope=1 body of a list endpoint: the page plus the
+// numbers setPageHeaders sends, for clients behind proxies that drop custom
+// headers. NextCursor is the URL of the next page, empty on the last one.
+type pageEnvelope struct {
+	Items      any    `json:"items"`
+	Total      int    `json:"total"`
+	Page       int    `json:"page"`
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func wantsEnvelope(c *gin.Context) bool {
+	v := strings.TrimSpace(c.Query("envelope"))
+	return v == "1" || strings.EqualFold(v, "true")
+}
+
+// respondPage writes one page of a list: the page headers always, and the
+// items either bare or, when asked for, wrapped in a pageEnvelope.
+func respondPage(c *gin.Context, items any, page, limit, total int) {
+	setPageHeaders(c, page, limit, total)
+	if !wantsEnvelope(c) {
+		c.JSON(http.StatusOK, items)
+		return
+	}
+	if v := reflect.ValueOf(items); v.Kind() == reflect.Slice && v.IsNil() {
+		items = []any{}
+	}
+	env := pageEnvelope{Items: items, Total: total, Page: page, Limit: limit}
+	if page < totalPages(total, limit) {
+		env.NextCursor = withPageParam(c.Request.URL.RequestURI(), page+1)
+	}
+	c.JSON(http.StatusOK, env)
+}
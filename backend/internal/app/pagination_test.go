@@ -0,0 +1,83 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWithPageAndLinks(t *testing.T) {
+	base := "https://blog.example/archive?archive=Go+%E7%AC%94%E8%AE%B0"
+	if got := withPage(base, 1); got != base {
+		t.Fatalf("page 1 should keep the plain URL, got %q", got)
+	}
+	if got, want := withPage(base, 3), "https://blog.example/archive?archive=Go+%E7%AC%94%E8%AE%B0&page=3"; got != want {
+		t.Fatalf("withPage = %q, want %q", got, want)
+	}
+	prev, next := pageLinks("https://blog.example/category/go", 2, 3)
+	if prev != "https://blog.example/category/go" || next != "https://blog.example/category/go?page=3" {
+		t.Fatalf("unexpected links: %q %q", prev, next)
+	}
+	if prev, next := pageLinks("/x", 1, 1); prev != "" || next != "" {
+		t.Fatalf("single page should have no links: %q %q", prev, next)
+	}
+	if got := totalPages(0, 50); got != 1 {
+		t.Fatalf("empty list should still have one page, got %d", got)
+	}
+	if got := totalPages(101, 50); got != 3 {
+		t.Fatalf("totalPages(101, 50) = %d, want 3", got)
+	}
+}
+
+func TestSetPageHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/articles?status=published&page=2&limit=10", nil)
+	setPageHeaders(c, 2, 10, 35)
+
+	if got := w.Header().Get("X-Total-Pages"); got != "4" {
+		t.Fatalf("X-Total-Pages = %q", got)
+	}
+	want := `</api/articles?limit=10&page=1&status=published>; rel="prev", </api/articles?limit=10&page=3&status=published>; rel="next"`
+	if got := w.Header().Get("Link"); got != want {
+		t.Fatalf("Link = %q, want %q", got, want)
+	}
+}
+
+func TestPageParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for raw, want := range map[string]int{"": 1, "?page=4": 4, "?page=0": 0, "?page=x": 0} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/archive"+raw, nil)
+		got, ok := pageParam(c)
+		if got != want || ok != (want > 0) {
+			t.Fatalf("pageParam(%q) = %d, %v", raw, got, ok)
+		}
+	}
+}
+
+func TestRespondPageEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	page := func(uri string, items []string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", uri, nil)
+		respondPage(c, items, 2, 10, 35)
+		if got := w.Header().Get("X-Total-Count"); got != "35" {
+			t.Fatalf("%s: X-Total-Count = %q", uri, got)
+		}
+		return w.Body.String()
+	}
+	if got := page("/api/imap/messages?page=2&limit=10", []string{"a"}); got != `["a"]` {
+		t.Fatalf("bare body = %s", got)
+	}
+	want := `{"items":["a"],"total":35,"page":2,"limit":10,"nextCursor":"/api/imap/messages?envelope=1\u0026limit=10\u0026page=3"}`
+	if got := page("/api/imap/messages?envelope=1&page=2&limit=10", []string{"a"}); got != want {
+		t.Fatalf("envelope = %s, want %s", got, want)
+	}
+	if got := page("/api/imap/messages?envelope=1", nil); got[:11] != `{"items":[]` {
+		t.Fatalf("nil items should encode as [], got %s", got)
+	}
+}
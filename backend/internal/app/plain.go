@@ -0,0 +1,93 @@
+package app
+
+import (
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// plainCSS is the whole stylesheet of the plain post view and of HTML
+// exports: readable on screen and on paper, with nothing fetched from the
+// site.
+const plainCSS = `body{margin:0;background:#fff;color:#3d3d3f;font:16px/1.8 -apple-system,BlinkMacSystemFont,"Segoe UI","PingFang SC","Hiragino Sans GB","Microsoft YaHei",sans-serif}
+main{max-width:46rem;margin:0 auto;padding:2.5rem 1.5rem}
+h1.title{font-size:2rem;line-height:1.3;margin:0 0 .5rem}
+.meta{color:#888;font-size:.85rem;margin:0 0 2rem}
+img{max-width:100%;height:auto}
+pre{background:#f6f8fa;padding:1em;overflow:auto;white-space:pre-wrap}
+code{font-family:SFMono-Regular,Consolas,Menlo,monospace;font-size:.9em}
+blockquote{margin:0;padding:0 1em;border-left:4px solid #ddd;color:#666}
+table{border-collapse:collapse}td,th{border:1px solid #ddd;padding:.3em .6em}
+a{color:#3273dc}
+footer{margin-top:3rem;color:#aaa;font-size:.8rem}
+@media print{main{max-width:none;padding:0}a{color:inherit}footer a{display:none}}`
+
+// plainPage wraps an article body in a minimal document without the app
+// shell: title, one meta line, the body and a link back to the post. base is
+// the site's absolute URL; headExtras goes at the end of <head>.
+func (s *server) plainPage(a article, base, body, headExtras string) string {
+	loc := s.siteLocation()
+	date := a.CreatedAt
+	if a.PublishedAt != nil {
+		date = *a.PublishedAt
+	}
+	meta := []string{date.In(loc).Format("2006-01-02 15:04")}
+	if len(a.Authors) > 0 {
+		names := make([]string, len(a.Authors))
+		for i, by := range a.Authors {
+			names[i] = by.name()
+		}
+		meta = append(meta, strings.Join(names, "、"))
+	}
+	if a.Archive != "" {
+		meta = append(meta, a.Archive)
+	}
+	canonical := base + "/post/" + urlPathEscape(a.Slug)
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html lang=\"" + html.EscapeString(s.contentLang(a.Lang)) + "\">\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString(`<meta name="viewport" content="width=device-width, initial-scale=1">` + "\n")
+	b.WriteString("<title>" + html.EscapeString(a.Title) + "</title>\n")
+	if a.Description != "" {
+		b.WriteString(`<meta name="description" content="` + html.EscapeString(a.Description) + `">` + "\n")
+	}
+	b.WriteString(`<link rel="canonical" href="` + html.EscapeString(canonical) + `">` + "\n")
+	b.WriteString("<style>\n" + plainCSS + "\n</style>\n" + headExtras + "</head>\n<body>\n<main>\n<article>\n")
+	b.WriteString(`<h1 class="title">` + html.EscapeString(a.Title) + "</h1>\n")
+	b.WriteString(`<p class="meta">` + html.EscapeString(strings.Join(meta, " · ")) + "</p>\n")
+	b.WriteString(body)
+	b.WriteString("\n</article>\n")
+	b.WriteString(`<footer>` + html.EscapeString(s.siteSettings().Title) + ` · <a href="` + html.EscapeString(canonical) + `">` + html.EscapeString(canonical) + "</a></footer>\n")
+	b.WriteString("</main>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// seoPlainPostHandler serves /post/:slug/plain, a style-light rendering of
+// the post for printing and text-mode browsers. It points search engines at
+// the full page through its canonical link.
+func (s *server) seoPlainPostHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a, ok := s.lookupPublishedPost(c, "/plain")
+		if !ok {
+			return
+		}
+		ctx := c.Request.Context()
+		var body, head string
+		if s.isUnlocked(c, a) {
+			body = strings.TrimSpace(a.BodyHTML)
+			if body == "" {
+				body = renderMarkdown(a.BodyMD)
+			}
+			body = s.proxyImages(s.expandFileShortcodes(ctx, s.expandGallery(ctx, a.ID, body)))
+		} else {
+			body = s.lockedPostBody(a, c.Query("unlock") == "failed")
+		}
+		if a.Visibility == visibilityPassword || a.Visibility == visibilityUnlisted {
+			head = `<meta name="robots" content="noindex">` + "\n"
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.String(http.StatusOK, s.plainPage(a, s.baseURL(c), body, head))
+	}
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPreviewTTL = 7 * 24 * time.Hour
+	maxPreviewTTL     = 90 * 24 * time.Hour
+	maxPreviewNote    = 200
+)
+
+// Preview tokens let reviewers read a draft without an account. Only the
+// SHA-256 of a token is stored, so a leaked database does not leak links.
+func (s *server) ensurePreviewSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS preview_tokens (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			token_hash TEXT NOT NULL UNIQUE,
+			article_id UUID NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+			note TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS idx_preview_tokens_article ON preview_tokens(article_id);
+	`)
+	return err
+}
+
+type previewToken struct {
+	ID        string     `json:"id"`
+	ArticleID string     `json:"articleId"`
+	Note      string     `json:"note,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	// Token and URL are only returned once, when the link is created.
+	Token string `json:"token,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+type previewPayload struct {
+	TTLHours int    `json:"ttlHours"`
+	Note     string `json:"note"`
+}
+
+func newPreviewToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashPreviewToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func previewTTL(hours int) (time.Duration, bool) {
+	if hours == 0 {
+		return defaultPreviewTTL, true
+	}
+	ttl := time.Duration(hours) * time.Hour
+	return ttl, hours > 0 && ttl <= maxPreviewTTL
+}
+
+func (s *server) createPreview(c *gin.Context) {
+	ctx := c.Request.Context()
+	articleID, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkArticleAuthor(c, articleID) {
+		return
+	}
+	var payload previewPayload
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&payload); err != nil {
+			respondError(c, http.StatusBadRequest, errInvalidBody)
+			return
+		}
+	}
+	ttl, ok := previewTTL(payload.TTLHours)
+	if !ok {
+		respondError(c, http.StatusBadRequest, errInvalidPreviewTTL)
+		return
+	}
+	note := truncateRunes(collapseWhitespace(payload.Note), maxPreviewNote)
+
+	token, err := newPreviewToken()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errCreatePreviewFailed)
+		return
+	}
+	p := previewToken{Note: note, Token: token}
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO preview_tokens (token_hash, article_id, note, expires_at)
+		SELECT $1, id, $3, $4 FROM articles WHERE id=$2
+		RETURNING id, article_id, created_at, expires_at`,
+		hashPreviewToken(token), articleID, note, time.Now().Add(ttl)).
+		Scan(&p.ID, &p.ArticleID, &p.CreatedAt, &p.ExpiresAt)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			respondError(c, http.StatusNotFound, errArticleNotFound)
+			return
+		}
+		respondErrorDetail(c, http.StatusInternalServerError, errCreatePreviewFailed, err)
+		return
+	}
+	p.inLocation(s.siteLocation())
+	p.URL = s.baseURL(c) + "/preview/" + token
+	c.JSON(http.StatusCreated, p)
+}
+
+func (p *previewToken) inLocation(loc *time.Location) {
+	p.CreatedAt = p.CreatedAt.In(loc)
+	p.ExpiresAt = p.ExpiresAt.In(loc)
+	if p.RevokedAt != nil {
+		t := p.RevokedAt.In(loc)
+		p.RevokedAt = &t
+	}
+}
+
+func (s *server) listPreviews(c *gin.Context) {
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	rows, err := s.readQuery(c.Request.Context(), `
+		SELECT id, article_id, note, created_at, expires_at, revoked_at
+		FROM preview_tokens
+		WHERE article_id=$1
+		ORDER BY created_at DESC`, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryPreviewsFailed)
+		return
+	}
+	defer rows.Close()
+	items := []previewToken{}
+	for rows.Next() {
+		var p previewToken
+		if err := rows.Scan(&p.ID, &p.ArticleID, &p.Note, &p.CreatedAt, &p.ExpiresAt, &p.RevokedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryPreviewsFailed)
+			return
+		}
+		p.inLocation(s.siteLocation())
+		items = append(items, p)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryPreviewsFailed)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (s *server) revokePreview(c *gin.Context) {
+	id, ok := idParam(c, "id", errArticleNotFound)
+	if !ok {
+		return
+	}
+	previewID, ok := idParam(c, "previewId", errPreviewNotFound)
+	if !ok {
+		return
+	}
+	if !s.checkArticleAuthor(c, id) {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE preview_tokens SET revoked_at = COALESCE(revoked_at, now())
+		WHERE id=$1 AND article_id=$2`, previewID, id)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryPreviewsFailed)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errPreviewNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// seoPreviewHandler renders the article behind a live preview token through
+// the normal post pipeline. Revoked, expired and unknown tokens all 404.
+func (s *server) seoPreviewHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimSpace(c.Param("token"))
+		if token == "" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		a, ok, err := s.queryPost(c.Request.Context(), `art.id = (
+			SELECT article_id FROM preview_tokens
+			WHERE token_hash=$1 AND revoked_at IS NULL AND expires_at > now())`, hashPreviewToken(token))
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+		c.Header("Referrer-Policy", "no-referrer")
+		s.renderPost(c, spa, a, true)
+	}
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreviewTTL(t *testing.T) {
+	if ttl, ok := previewTTL(0); !ok || ttl != defaultPreviewTTL {
+		t.Fatalf("default ttl = %v, %v", ttl, ok)
+	}
+	if ttl, ok := previewTTL(48); !ok || ttl != 48*time.Hour {
+		t.Fatalf("48h ttl = %v, %v", ttl, ok)
+	}
+	for _, bad := range []int{-1, 24*90 + 1} {
+		if _, ok := previewTTL(bad); ok {
+			t.Errorf("previewTTL(%d) accepted", bad)
+		}
+	}
+}
+
+func TestPreviewTokenHashing(t *testing.T) {
+	a, err := newPreviewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newPreviewToken()
+	if a == b || len(a) != 32 {
+		t.Fatalf("tokens should be random 32-char strings: %q %q", a, b)
+	}
+	if hashPreviewToken(a) == a || hashPreviewToken(a) != hashPreviewToken(a) {
+		t.Fatal("hash should be stable and differ from the token")
+	}
+}
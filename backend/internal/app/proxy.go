@@ -0,0 +1,105 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// proxyTrust decides whether a peer may speak for the client through
+// X-Forwarded-* headers. An empty list trusts nobody, which is the safe
+// default when selfecho is exposed directly.
+type proxyTrust struct {
+	prefixes []netip.Prefix
+}
+
+func parseTrustedProxies(list []string) (*proxyTrust, error) {
+	prefixes, err := parsePrefixes("trustedProxies", list)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyTrust{prefixes: prefixes}, nil
+}
+
+// parsePrefixes reads a list of CIDRs and bare addresses; field names the
+// config key in errors.
+func parsePrefixes(field string, list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, raw := range list {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		if strings.Contains(raw, "/") {
+			prefix, err := netip.ParsePrefix(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s 无效: %s", field, raw)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s 无效: %s", field, raw)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// strings renders the list in the form gin's SetTrustedProxies expects.
+func (pt *proxyTrust) strings() []string {
+	if pt == nil || len(pt.prefixes) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(pt.prefixes))
+	for _, p := range pt.prefixes {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+func (pt *proxyTrust) trusts(remoteAddr string) bool {
+	if pt == nil {
+		return false
+	}
+	return prefixesContain(pt.prefixes, remoteAddr)
+}
+
+// prefixesContain reports whether addr, with or without a port, falls in
+// any of prefixes.
+func prefixesContain(prefixes []netip.Prefix, addr string) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustForwarded reports whether r came through a configured trusted proxy.
+func (s *server) trustForwarded(r *http.Request) bool {
+	return s.proxies.trusts(r.RemoteAddr)
+}
+
+func (s *server) requestBaseURL(r *http.Request) string {
+	return requestBaseURL(r, s.trustForwarded(r))
+}
+
+func (s *server) isSecureRequest(r *http.Request) bool {
+	return strings.HasPrefix(s.requestBaseURL(r), "https://")
+}
@@ -0,0 +1,30 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestBaseURL_ForwardedHeadersNeedTrustedPeer(t *testing.T) {
+	pt, err := parseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{proxies: pt}
+
+	r := httptest.NewRequest("GET", "http://internal:8080/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "blog.example.com")
+
+	r.RemoteAddr = "203.0.113.9:4000"
+	if got := s.requestBaseURL(r); got != "http://internal:8080" {
+		t.Fatalf("untrusted peer: got %q", got)
+	}
+	r.RemoteAddr = "10.1.2.3:4000"
+	if got := s.requestBaseURL(r); got != "https://blog.example.com" {
+		t.Fatalf("trusted peer: got %q", got)
+	}
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Fatal("expected error for invalid proxy entry")
+	}
+}
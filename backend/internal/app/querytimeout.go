@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const defaultQueryTimeout = 10 * time.Second
+
+// queryTimeout bounds each read query issued for a request, including the
+// wait for a pooled connection, so a slow Postgres turns into fast errors
+// instead of a pile of stuck SSR and sitemap renders. "0" disables it.
+func (cfg dbConfig) queryTimeout() (time.Duration, error) {
+	if cfg.QueryTimeout == "" {
+		return defaultQueryTimeout, nil
+	}
+	d, err := time.ParseDuration(cfg.QueryTimeout)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("database.queryTimeout 无效: %s", cfg.QueryTimeout)
+	}
+	return d, nil
+}
+
+// withQueryTimeout derives the context for one query from ctx, which already
+// carries the client's cancellation. The deadline is only tightened, never
+// extended.
+func (s *server) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	if dl, ok := ctx.Deadline(); ok && time.Until(dl) <= s.queryTimeout {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// detachedQueryTimeout is withQueryTimeout for *sql.Rows, whose lifetime
+// outlives the call: the context is cancelled by a timer instead of by the
+// caller, so iterating a slow result set is bounded too.
+func (s *server) detachedQueryTimeout(ctx context.Context) context.Context {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	if s.queryTimeout > 0 {
+		time.AfterFunc(s.queryTimeout, cancel)
+	}
+	return ctx
+}
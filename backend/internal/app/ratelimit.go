@@ -0,0 +1,111 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitConfig caps /api requests per client IP with a token bucket.
+// RequestsPerMinute 0 turns the limit off; Burst defaults to
+// RequestsPerMinute.
+type rateLimitConfig struct {
+	RequestsPerMinute int `yaml:"requestsPerMinute"`
+	Burst             int `yaml:"burst"`
+}
+
+func (cfg rateLimitConfig) validate() error {
+	if cfg.RequestsPerMinute < 0 || cfg.Burst < 0 {
+		return fmt.Errorf("rateLimit 不能为负数: %d/%d", cfg.RequestsPerMinute, cfg.Burst)
+	}
+	return nil
+}
+
+// rateLimitSweepInterval is how often buckets that have refilled are dropped.
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one bucket per client IP. configure swaps the limits in
+// place so a reload takes effect without restarting.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg rateLimitConfig) *rateLimiter {
+	l := &rateLimiter{}
+	l.configure(cfg)
+	return l
+}
+
+func (l *rateLimiter) configure(cfg rateLimitConfig) {
+	burst := cfg.Burst
+	if burst == 0 {
+		burst = cfg.RequestsPerMinute
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perSecond = float64(cfg.RequestsPerMinute) / 60
+	l.burst = float64(burst)
+	l.buckets = map[string]*tokenBucket{}
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it reports
+// how long until the next token.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perSecond == 0 {
+		return true, 0
+	}
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that would be full by now; a fresh bucket is the same.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.perSecond >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (s *server) rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := s.limiter.allow(c.ClientIP(), time.Now())
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondError(c, http.StatusTooManyRequests, errRateLimited)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(rateLimitConfig{RequestsPerMinute: 60, Burst: 2})
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("request %d within burst refused", i)
+		}
+	}
+	ok, wait := l.allow("a", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("third request: ok=%v wait=%v", ok, wait)
+	}
+	if ok, _ := l.allow("b", now); !ok {
+		t.Fatal("other client refused")
+	}
+	if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+		t.Fatal("refilled token refused")
+	}
+
+	l.configure(rateLimitConfig{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatal("disabled limiter refused a request")
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := newRateLimiter(rateLimitConfig{RequestsPerMinute: 60, Burst: 5})
+	now := time.Now()
+	l.allow("a", now)
+	l.allow("b", now.Add(rateLimitSweepInterval))
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("refilled bucket survived the sweep")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Fatal("active bucket swept")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{limiter: newRateLimiter(rateLimitConfig{RequestsPerMinute: 1})}
+	r := gin.New()
+	r.GET("/", s.rateLimitMiddleware(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	run := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+	if w := run(); w.Code != http.StatusNoContent {
+		t.Fatalf("first request: %d", w.Code)
+	}
+	w := run()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("second request: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
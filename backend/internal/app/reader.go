@@ -0,0 +1,572 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/emersion/go-message/charset"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	readerPollInterval = 30 * time.Minute
+	// readerKeepItems is how many items a subscription keeps; older read
+	// ones are dropped after each poll.
+	readerKeepItems    = 200
+	maxReaderFeedBytes = 5 << 20
+	readerSummaryRunes = 500
+)
+
+// readerFeed is an external RSS or Atom feed the blog subscribes to. Its
+// items make up the admin reading list.
+type readerFeed struct {
+	ID            string     `json:"id"`
+	Title         string     `json:"title"`
+	FeedURL       string     `json:"feedUrl"`
+	SiteURL       string     `json:"siteUrl,omitempty"`
+	LastFetchedAt *time.Time `json:"lastFetchedAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	Unread        int        `json:"unread"`
+	CreatedAt     time.Time  `json:"createdAt"`
+
+	etag, lastModified string
+}
+
+type readerItem struct {
+	ID          string     `json:"id"`
+	FeedID      string     `json:"feedId"`
+	FeedTitle   string     `json:"feedTitle"`
+	Title       string     `json:"title"`
+	Link        string     `json:"link,omitempty"`
+	Summary     string     `json:"summary,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	Read        bool       `json:"read"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+func (s *server) ensureReaderSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS reader_feeds (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			title TEXT NOT NULL DEFAULT '',
+			feed_url TEXT NOT NULL UNIQUE,
+			site_url TEXT NOT NULL DEFAULT '',
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			last_fetched_at TIMESTAMPTZ,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+		CREATE TABLE IF NOT EXISTS reader_items (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			feed_id UUID NOT NULL REFERENCES reader_feeds(id) ON DELETE CASCADE,
+			guid TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			link TEXT NOT NULL DEFAULT '',
+			summary TEXT NOT NULL DEFAULT '',
+			author TEXT NOT NULL DEFAULT '',
+			published_at TIMESTAMPTZ,
+			read_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			UNIQUE(feed_id, guid)
+		);
+		CREATE INDEX IF NOT EXISTS idx_reader_items_date ON reader_items((COALESCE(published_at, created_at)) DESC);
+		CREATE INDEX IF NOT EXISTS idx_reader_items_unread ON reader_items(feed_id) WHERE read_at IS NULL;
+	`)
+	return err
+}
+
+// parsedFeed is a fetched feed reduced to what the reading list keeps.
+type parsedFeed struct {
+	Title   string
+	SiteURL string
+	Items   []parsedItem
+}
+
+type parsedItem struct {
+	GUID      string
+	Title     string
+	Link      string
+	Summary   string
+	Author    string
+	Published *time.Time
+}
+
+// rssFeedItem is an RSS item as read, with the fields rssItem does not
+// write.
+type rssFeedItem struct {
+	rssItem
+	Author  string `xml:"author"`
+	Creator string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Content string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+var rssDateLayouts = []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700", time.RFC822Z, time.RFC822, time.RFC3339}
+
+func parseFeedDate(s string) *time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range rssDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func feedSummary(s string) string {
+	return truncateRunes(collapseWhitespace(htmlToPlain(s)), readerSummaryRunes)
+}
+
+// parseFeed reads an RSS 2.0 or Atom document in any charset go-message
+// knows. Items without a GUID or ID are keyed by their link, then title.
+func parseFeed(data []byte) (parsedFeed, error) {
+	decode := func(v any) error {
+		dec := xml.NewDecoder(bytes.NewReader(data))
+		dec.CharsetReader = charset.Reader
+		dec.Strict = false
+		dec.Entity = xml.HTMLEntity
+		return dec.Decode(v)
+	}
+	var root struct{ XMLName xml.Name }
+	if err := decode(&root); err != nil {
+		return parsedFeed{}, err
+	}
+	var out parsedFeed
+	switch root.XMLName.Local {
+	case "rss":
+		var doc struct {
+			Channel struct {
+				Title string        `xml:"title"`
+				Link  string        `xml:"link"`
+				Items []rssFeedItem `xml:"item"`
+			} `xml:"channel"`
+		}
+		if err := decode(&doc); err != nil {
+			return out, err
+		}
+		out.Title, out.SiteURL = doc.Channel.Title, doc.Channel.Link
+		for _, it := range doc.Channel.Items {
+			summary := it.Description
+			if summary == "" {
+				summary = it.Content
+			}
+			author := it.Creator
+			if author == "" {
+				author = it.Author
+			}
+			out.Items = append(out.Items, parsedItem{GUID: it.GUID.Value, Title: it.Title, Link: it.Link,
+				Summary: summary, Author: author, Published: parseFeedDate(it.PubDate)})
+		}
+	case "feed":
+		var doc atomFeed
+		if err := decode(&doc); err != nil {
+			return out, err
+		}
+		out.Title, out.SiteURL = doc.Title, atomAlternate(doc.Links)
+		for _, e := range doc.Entries {
+			it := parsedItem{GUID: e.ID, Title: e.Title, Link: atomAlternate(e.Links), Published: parseFeedDate(e.Published)}
+			if it.Published == nil {
+				it.Published = parseFeedDate(e.Updated)
+			}
+			if e.Summary != nil {
+				it.Summary = e.Summary.Body
+			} else if e.Content != nil {
+				it.Summary = e.Content.Body
+			}
+			if e.Author != nil {
+				it.Author = e.Author.Name
+			}
+			out.Items = append(out.Items, it)
+		}
+	default:
+		return out, fmt.Errorf("不是 RSS 或 Atom: <%s>", root.XMLName.Local)
+	}
+	out.Title = collapseWhitespace(out.Title)
+	out.SiteURL = strings.TrimSpace(out.SiteURL)
+	items := out.Items[:0]
+	for _, it := range out.Items {
+		it.Title = collapseWhitespace(it.Title)
+		it.Link = strings.TrimSpace(it.Link)
+		it.Author = collapseWhitespace(it.Author)
+		it.Summary = feedSummary(it.Summary)
+		it.GUID = strings.TrimSpace(it.GUID)
+		if it.GUID == "" {
+			it.GUID = it.Link
+		}
+		if it.GUID == "" {
+			it.GUID = it.Title
+		}
+		if it.GUID != "" {
+			items = append(items, it)
+		}
+	}
+	out.Items = items
+	return out, nil
+}
+
+// fetchReaderFeed downloads f, returning nil without error when the server
+// answers 304 to the stored validators.
+func (s *server) fetchReaderFeed(ctx context.Context, f readerFeed) (*parsedFeed, string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.FeedURL, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.5")
+	req.Header.Set("User-Agent", "selfecho-reader")
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	resp, err := s.publicClient.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, f.etag, f.lastModified, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("上游返回 %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxReaderFeedBytes+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(data) > maxReaderFeedBytes {
+		return nil, "", "", errors.New("订阅源超过大小限制")
+	}
+	parsed, err := parseFeed(data)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return &parsed, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// pollReaderFeed fetches f and stores its new items, recording the outcome
+// on the subscription. It returns how many items were added.
+func (s *server) pollReaderFeed(ctx context.Context, f readerFeed) (int, error) {
+	parsed, etag, lastModified, err := s.fetchReaderFeed(ctx, f)
+	if err != nil {
+		_, _ = s.db.ExecContext(ctx, `UPDATE reader_feeds SET last_fetched_at=now(), last_error=$2 WHERE id=$1`,
+			f.ID, truncateRunes(err.Error(), 500))
+		return 0, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	added := 0
+	if parsed != nil {
+		for _, it := range parsed.Items {
+			res, err := tx.ExecContext(ctx, `
+				INSERT INTO reader_items (feed_id, guid, title, link, summary, author, published_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (feed_id, guid) DO NOTHING`, f.ID, it.GUID, it.Title, it.Link, it.Summary, it.Author, it.Published)
+			if err != nil {
+				return 0, err
+			}
+			n, _ := res.RowsAffected()
+			added += int(n)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE reader_feeds SET title=CASE WHEN title='' THEN $2 ELSE title END, site_url=$3
+			WHERE id=$1`, f.ID, parsed.Title, parsed.SiteURL); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM reader_items WHERE id IN (
+				SELECT id FROM reader_items WHERE feed_id=$1
+				ORDER BY COALESCE(published_at, created_at) DESC, created_at DESC
+				OFFSET $2)
+			AND read_at IS NOT NULL`, f.ID, readerKeepItems); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE reader_feeds SET etag=$2, last_modified=$3, last_fetched_at=now(), last_error='' WHERE id=$1`,
+		f.ID, etag, lastModified); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
+const readerFeedColumns = `f.id, f.title, f.feed_url, f.site_url, f.etag, f.last_modified, f.last_fetched_at, f.last_error, f.created_at,
+	(SELECT count(*) FROM reader_items i WHERE i.feed_id = f.id AND i.read_at IS NULL)`
+
+func (s *server) scanReaderFeed(row interface{ Scan(...any) error }) (readerFeed, error) {
+	var f readerFeed
+	var fetched sql.NullTime
+	err := row.Scan(&f.ID, &f.Title, &f.FeedURL, &f.SiteURL, &f.etag, &f.lastModified, &fetched, &f.LastError, &f.CreatedAt, &f.Unread)
+	if fetched.Valid {
+		t := fetched.Time.In(s.siteLocation())
+		f.LastFetchedAt = &t
+	}
+	f.CreatedAt = f.CreatedAt.In(s.siteLocation())
+	return f, err
+}
+
+func (s *server) readerFeeds(ctx context.Context) ([]readerFeed, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+readerFeedColumns+` FROM reader_feeds f ORDER BY f.created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []readerFeed{}
+	for rows.Next() {
+		f, err := s.scanReaderFeed(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, f)
+	}
+	return items, rows.Err()
+}
+
+func (s *server) readerFeed(ctx context.Context, id string) (readerFeed, error) {
+	if !store.ValidID(id) {
+		return readerFeed{}, sql.ErrNoRows
+	}
+	return s.scanReaderFeed(s.db.QueryRowContext(ctx, `SELECT `+readerFeedColumns+` FROM reader_feeds f WHERE f.id=$1`, id))
+}
+
+// pollReaderFeeds refreshes every subscription; one failing feed does not
+// stop the others.
+func (s *server) pollReaderFeeds(ctx context.Context) (int, error) {
+	feeds, err := s.readerFeeds(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, f := range feeds {
+		n, err := s.pollReaderFeed(ctx, f)
+		total += n
+		if err != nil {
+			warnf("抓取订阅 %s 失败: %v\n", f.FeedURL, err)
+		}
+	}
+	return total, nil
+}
+
+func (s *server) runReaderPolling() {
+	ticker := time.NewTicker(readerPollInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		if _, err := s.pollReaderFeeds(ctx); err != nil {
+			warnf("抓取订阅失败: %v\n", err)
+		}
+		cancel()
+		<-ticker.C
+	}
+}
+
+func (s *server) listReaderFeeds(c *gin.Context) {
+	feeds, err := s.readerFeeds(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryReaderFailed)
+		return
+	}
+	c.JSON(http.StatusOK, feeds)
+}
+
+// createReaderFeed subscribes to a feed and fetches it right away. A feed
+// that cannot be fetched yet is still kept, with the error on lastError.
+func (s *server) createReaderFeed(c *gin.Context) {
+	var payload struct {
+		FeedURL string `json:"feedUrl"`
+		Title   string `json:"title"`
+	}
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	raw := strings.TrimSpace(payload.FeedURL)
+	if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		respondError(c, http.StatusBadRequest, errInvalidFeedURL)
+		return
+	}
+	ctx := c.Request.Context()
+	var id string
+	err := s.db.QueryRowContext(ctx, `INSERT INTO reader_feeds (feed_url, title) VALUES ($1, $2) RETURNING id`,
+		raw, truncateRunes(collapseWhitespace(payload.Title), 200)).Scan(&id)
+	if isUniqueViolation(err) {
+		respondError(c, http.StatusConflict, errReaderFeedExists)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveReaderFeedFailed, err)
+		return
+	}
+	f, err := s.readerFeed(ctx, id)
+	if err == nil {
+		_, _ = s.pollReaderFeed(ctx, f)
+		f, err = s.readerFeed(ctx, id)
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+		return
+	}
+	c.JSON(http.StatusCreated, f)
+}
+
+// refreshReaderFeed polls one subscription now.
+func (s *server) refreshReaderFeed(c *gin.Context) {
+	ctx := c.Request.Context()
+	f, err := s.readerFeed(ctx, c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errReaderFeedNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryReaderFailed)
+		return
+	}
+	added, err := s.pollReaderFeed(ctx, f)
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadGateway, errFetchFeedFailed, err)
+		return
+	}
+	if f, err = s.readerFeed(ctx, f.ID); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryReaderFailed)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"feed": f, "added": added})
+}
+
+func (s *server) deleteReaderFeed(c *gin.Context) {
+	id, ok := idParam(c, "id", errReaderFeedNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM reader_feeds WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveReaderFeedFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errReaderFeedNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listReaderItems pages through the reading list, newest first, optionally
+// of one ?feedId= and only ?unread=1 items.
+func (s *server) listReaderItems(c *gin.Context) {
+	ctx := c.Request.Context()
+	limit := 30
+	if l, err := strconv.Atoi(strings.TrimSpace(c.Query("limit"))); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	page := 1
+	if p, err := strconv.Atoi(strings.TrimSpace(c.Query("page"))); err == nil && p > 0 {
+		page = p
+	}
+	where := `($1 = '' OR i.feed_id = NULLIF($1, '')::uuid) AND (NOT $2 OR i.read_at IS NULL)`
+	feedID, unread := strings.TrimSpace(c.Query("feedId")), c.Query("unread") == "1"
+	if feedID != "" && !store.ValidID(feedID) {
+		respondError(c, http.StatusNotFound, errReaderFeedNotFound)
+		return
+	}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM reader_items i WHERE `+where, feedID, unread).Scan(&total); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+		return
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT i.id, i.feed_id, COALESCE(NULLIF(f.title, ''), f.feed_url), i.title, i.link, i.summary, i.author,
+		       i.published_at, i.read_at IS NOT NULL, i.created_at
+		FROM reader_items i JOIN reader_feeds f ON f.id = i.feed_id
+		WHERE `+where+`
+		ORDER BY COALESCE(i.published_at, i.created_at) DESC, i.created_at DESC
+		LIMIT $3 OFFSET $4`, feedID, unread, limit, (page-1)*limit)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+		return
+	}
+	defer rows.Close()
+	items := []readerItem{}
+	for rows.Next() {
+		var it readerItem
+		var published sql.NullTime
+		if err := rows.Scan(&it.ID, &it.FeedID, &it.FeedTitle, &it.Title, &it.Link, &it.Summary, &it.Author,
+			&published, &it.Read, &it.CreatedAt); err != nil {
+			respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+			return
+		}
+		if published.Valid {
+			t := published.Time.In(s.siteLocation())
+			it.PublishedAt = &t
+		}
+		it.CreatedAt = it.CreatedAt.In(s.siteLocation())
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+		return
+	}
+	respondPage(c, items, page, limit, total)
+}
+
+// markReaderItem sets one item read or unread.
+func (s *server) markReaderItem(c *gin.Context) {
+	var payload struct {
+		Read bool `json:"read"`
+	}
+	id, ok := idParam(c, "id", errReaderItemNotFound)
+	if !ok {
+		return
+	}
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE reader_items SET read_at = CASE WHEN $2 THEN COALESCE(read_at, now()) END WHERE id=$1`,
+		id, payload.Read)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errReaderItemNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// markReaderRead marks every unread item, or those of ?feedId=, read.
+func (s *server) markReaderRead(c *gin.Context) {
+	feedID := strings.TrimSpace(c.Query("feedId"))
+	if feedID != "" && !store.ValidID(feedID) {
+		respondError(c, http.StatusNotFound, errReaderFeedNotFound)
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE reader_items SET read_at = now() WHERE read_at IS NULL AND ($1 = '' OR feed_id = NULLIF($1, '')::uuid)`,
+		feedID)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryReaderFailed, err)
+		return
+	}
+	n, _ := res.RowsAffected()
+	c.JSON(http.StatusOK, gin.H{"marked": n})
+}
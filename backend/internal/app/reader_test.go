@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestParseFeedRSS(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel><title> Example  Blog </title><link>https://example.org/</link>
+<item><title>First</title><link>https://example.org/1</link><guid isPermaLink="false">id-1</guid>
+<pubDate>Wed, 01 May 2024 12:00:00 +0000</pubDate><dc:creator>Ann</dc:creator>
+<description>&lt;p&gt;Hello&amp;nbsp;&lt;b&gt;world&lt;/b&gt;&lt;/p&gt;</description></item>
+<item><title>No guid</title><link>https://example.org/2</link></item>
+<item><description>nothing to key on</description></item>
+</channel></rss>`
+	f, err := parseFeed([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "Example Blog" || f.SiteURL != "https://example.org/" || len(f.Items) != 2 {
+		t.Fatalf("feed = %+v", f)
+	}
+	first := f.Items[0]
+	if first.GUID != "id-1" || first.Author != "Ann" || first.Summary != "Hello world" || first.Published == nil || first.Published.Day() != 1 {
+		t.Fatalf("first = %+v", first)
+	}
+	if f.Items[1].GUID != "https://example.org/2" || f.Items[1].Published != nil {
+		t.Fatalf("second = %+v", f.Items[1])
+	}
+}
+
+func TestParseFeedAtom(t *testing.T) {
+	doc := `<feed xmlns="http://www.w3.org/2005/Atom"><title>Notes</title>
+<link rel="self" href="https://example.org/atom.xml"/><link href="https://example.org/"/>
+<entry><id>urn:1</id><title>One</title><updated>2024-05-02T08:00:00Z</updated>
+<link rel="alternate" href="https://example.org/one"/><author><name>Bo</name></author>
+<content type="html">&lt;p&gt;Body&lt;/p&gt;</content></entry></feed>`
+	f, err := parseFeed([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "Notes" || f.SiteURL != "https://example.org/" || len(f.Items) != 1 {
+		t.Fatalf("feed = %+v", f)
+	}
+	if it := f.Items[0]; it.GUID != "urn:1" || it.Link != "https://example.org/one" || it.Author != "Bo" || it.Summary != "Body" || it.Published == nil {
+		t.Fatalf("entry = %+v", it)
+	}
+}
+
+func TestParseFeedCharset(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="gbk"?><rss version="2.0"><channel><title>`)
+	w := simplifiedchinese.GBK.NewEncoder().Writer(&buf)
+	w.Write([]byte("中文博客"))
+	buf.WriteString(`</title></channel></rss>`)
+	f, err := parseFeed(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Title != "中文博客" {
+		t.Fatalf("title = %q", f.Title)
+	}
+	if _, err := parseFeed([]byte(`<html><body>nope</body></html>`)); err == nil || !strings.Contains(err.Error(), "html") {
+		t.Fatalf("html page parsed: %v", err)
+	}
+}
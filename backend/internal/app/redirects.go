@@ -0,0 +1,30 @@
+package app
+
+import (
+	"context"
+
+	"selfecho/backend/internal/slugmigrate"
+)
+
+func (s *server) ensureRedirectSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, slugmigrate.RedirectsSchema)
+	return err
+}
+
+// resolveSlugRedirect maps a retired post slug to the post's current slug.
+// Live slugs always win, so callers only consult it after a miss.
+func (s *server) resolveSlugRedirect(ctx context.Context, oldSlug string) (string, bool, error) {
+	var current string
+	err := s.readQueryRow(ctx, `
+		SELECT art.slug
+		FROM slug_redirects r
+		JOIN articles art ON art.id = r.article_id
+		WHERE r.old_slug=$1 AND art.status='published' AND art.type='post'`, oldSlug).Scan(&current)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return current, current != oldSlug, nil
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCacheTTLSeconds = 30
+
+// runtimeConfig holds the config values that may change on reload.
+type runtimeConfig struct {
+	mu      sync.Mutex
+	path    string
+	current config
+	// startup is the config the process started with. Restart-only settings
+	// are diffed against it, as a reload never applies them.
+	startup config
+	origins []string
+}
+
+func (c *listCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+func cacheTTL(cfg config) time.Duration {
+	if cfg.CacheTTL <= 0 {
+		return defaultCacheTTLSeconds * time.Second
+	}
+	return time.Duration(cfg.CacheTTL) * time.Second
+}
+
+func normalizeOrigins(list []string) []string {
+	var out []string
+	for _, o := range list {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// validateReloadable checks the settings reloadConfig applies, so a bad
+// reload is refused before anything changes.
+func validateReloadable(cfg config) error {
+	if cfg.CacheTTL < 0 {
+		return fmt.Errorf("cacheTTLSeconds 不能为负数: %d", cfg.CacheTTL)
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	if err := cfg.RateLimit.validate(); err != nil {
+		return err
+	}
+	for _, o := range normalizeOrigins(cfg.CORSOrigins) {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("corsOrigins 无效: %s", o)
+		}
+	}
+	return nil
+}
+
+func (rc *runtimeConfig) allowedOrigin(origin string) (string, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.origins) == 0 {
+		return "*", true
+	}
+	for _, o := range rc.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+func (s *server) corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if allow, ok := s.runtime.allowedOrigin(c.GetHeader("Origin")); ok && allow != "" {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", allow)
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Page, X-Limit, X-Total-Pages, Link, Idempotent-Replayed")
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// restartOnlyChanges lists the settings that differ between old and next but
+// only take effect after a restart.
+func restartOnlyChanges(old, next config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("database", old.Database, next.Database)
+	check("port", old.Port, next.Port)
+	check("staticDir", old.StaticDir, next.StaticDir)
+	check("basePath", old.BasePath, next.BasePath)
+	check("trustedProxies", old.TrustedProxies, next.TrustedProxies)
+	check("site.canonicalHost", old.Site.CanonicalHost, next.Site.CanonicalHost)
+	check("static", old.Static, next.Static)
+	check("env", old.Env, next.Env)
+	check("imapSecret", old.ImapSecret, next.ImapSecret)
+	check("deepseek", old.Deepseek, next.Deepseek)
+	check("slug", old.Slug, next.Slug)
+	check("llm", old.LLM, next.LLM)
+	check("search", old.Search, next.Search)
+	check("export", old.Export, next.Export)
+	check("encryption", old.Encryption, next.Encryption)
+	check("session", old.Session, next.Session)
+	check("admin", old.Admin, next.Admin)
+	check("tls", old.TLS, next.TLS)
+	check("challenge", old.Challenge, next.Challenge)
+	check("metrics", old.Metrics, next.Metrics)
+	check("webhooks", old.Webhooks, next.Webhooks)
+	check("smtp", old.SMTP, next.SMTP)
+	check("gitSync", old.GitSync, next.GitSync)
+	check("backup", old.Backup, next.Backup)
+	return changed
+}
+
+type reloadResult struct {
+	Applied       []string `json:"applied"`
+	RestartNeeded []string `json:"restartNeeded"`
+}
+
+// reloadConfig re-reads config.yaml and the stored site settings, applying the
+// hot-reloadable parts (cache TTLs, CORS origins, log level, rate limit, site
+// settings). A config that fails the startup validation is refused as a whole.
+func (s *server) reloadConfig(ctx context.Context) (reloadResult, error) {
+	s.runtime.mu.Lock()
+	path, old, startup := s.runtime.path, s.runtime.current, s.runtime.startup
+	s.runtime.mu.Unlock()
+
+	next, err := loadConfig(path)
+	if err != nil {
+		return reloadResult{}, err
+	}
+	if err := validateServerConfig(next); err != nil {
+		return reloadResult{}, err
+	}
+	res := reloadResult{Applied: []string{}, RestartNeeded: restartOnlyChanges(startup, next)}
+
+	if ttl := cacheTTL(next); ttl != cacheTTL(old) {
+		s.cache.setTTL(ttl)
+		s.cache.invalidateAll()
+		res.Applied = append(res.Applied, "cacheTTLSeconds")
+	}
+	if ttl := ssrCacheTTL(next); ttl != ssrCacheTTL(old) {
+		s.pages.setTTL(ttl)
+		res.Applied = append(res.Applied, "ssrCacheTTLSeconds")
+	}
+	if next.LogLevel != old.LogLevel {
+		level, _ := parseLogLevel(next.LogLevel)
+		setLogLevel(level)
+		res.Applied = append(res.Applied, "logLevel")
+	}
+	if next.RateLimit != old.RateLimit {
+		s.limiter.configure(next.RateLimit)
+		res.Applied = append(res.Applied, "rateLimit")
+	}
+	s.runtime.mu.Lock()
+	if !reflect.DeepEqual(normalizeOrigins(old.CORSOrigins), normalizeOrigins(next.CORSOrigins)) {
+		res.Applied = append(res.Applied, "corsOrigins")
+	}
+	s.runtime.origins = normalizeOrigins(next.CORSOrigins)
+	s.runtime.current = next
+	s.runtime.mu.Unlock()
+
+	if err := s.loadSettings(ctx); err != nil {
+		return res, fmt.Errorf("重新加载站点设置失败: %w", err)
+	}
+	res.Applied = append(res.Applied, "siteSettings")
+	s.publish(eventSettingsChanged, actionUpdated, siteSettingsKey, "")
+
+	if len(res.RestartNeeded) > 0 {
+		warnf("以下配置修改需要重启才能生效: %s\n", strings.Join(res.RestartNeeded, ", "))
+	}
+	infof("配置已重新加载: %s\n", strings.Join(res.Applied, ", "))
+	return res, nil
+}
+
+func (s *server) reloadConfigHandler(c *gin.Context) {
+	res, err := s.reloadConfig(c.Request.Context())
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errReloadFailed, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchReloadSignal reloads the configuration whenever the process gets SIGHUP.
+func (s *server) watchReloadSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if _, err := s.reloadConfig(context.Background()); err != nil {
+				warnf("SIGHUP 重新加载配置失败: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package app
+
+// watchReloadSignal is a no-op on Windows; use POST /api/admin/reload instead.
+func (s *server) watchReloadSignal() {}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestartOnlyChanges(t *testing.T) {
+	old := defaultConfig()
+	next := defaultConfig()
+	next.CacheTTL = 60
+	next.CORSOrigins = []string{"https://admin.example.com"}
+	next.LogLevel = "warn"
+	next.RateLimit = rateLimitConfig{RequestsPerMinute: 120}
+	if got := restartOnlyChanges(old, next); len(got) != 0 {
+		t.Fatalf("expected hot-reloadable changes only, got %v", got)
+	}
+	next.Port = 9090
+	next.Database.Host = "db"
+	if got := restartOnlyChanges(old, next); !reflect.DeepEqual(got, []string{"database", "port"}) {
+		t.Fatalf("unexpected restart-only changes: %v", got)
+	}
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	rc := &runtimeConfig{}
+	if got, ok := rc.allowedOrigin("https://x.example"); !ok || got != "*" {
+		t.Fatalf("expected wildcard without config, got %q %v", got, ok)
+	}
+	rc.origins = normalizeOrigins([]string{"https://admin.example.com/"})
+	if got, ok := rc.allowedOrigin("https://admin.example.com"); !ok || got != "https://admin.example.com" {
+		t.Fatalf("expected echoed origin, got %q %v", got, ok)
+	}
+	if _, ok := rc.allowedOrigin("https://evil.example"); ok {
+		t.Fatal("expected foreign origin to be rejected")
+	}
+}
+
+func TestValidateReloadable(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.CORSOrigins = []string{"https://admin.example.com/", "*", "http://localhost:4200"}
+	if err := validateReloadable(cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, origins := range [][]string{{"admin.example.com"}, {"https://admin.example.com/app"}, {"ftp://example.com"}} {
+		cfg.CORSOrigins = origins
+		if err := validateReloadable(cfg); err == nil {
+			t.Errorf("origins %q accepted", origins)
+		}
+	}
+	cfg.CORSOrigins = nil
+	cfg.CacheTTL = -1
+	if err := validateReloadable(cfg); err == nil {
+		t.Error("negative cache TTL accepted")
+	}
+	cfg.CacheTTL = 0
+	cfg.LogLevel = "verbose"
+	if err := validateReloadable(cfg); err == nil {
+		t.Error("unknown log level accepted")
+	}
+	cfg.LogLevel = ""
+	cfg.RateLimit.Burst = -1
+	if err := validateReloadable(cfg); err == nil {
+		t.Error("negative burst accepted")
+	}
+}
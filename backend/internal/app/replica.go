@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"selfecho/backend/internal/store"
+)
+
+// replicaBackoff is how long reads stay on the primary after the replica failed.
+const replicaBackoff = 30 * time.Second
+
+// readReplica routes public read traffic (lists, SSR pages, feeds, sitemap) to
+// an optional read-only database so crawler load stays off the primary.
+type readReplica struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// replicaConfig derives the replica settings from the primary: same options
+// and pool sizing, different URL.
+func replicaConfig(cfg dbConfig) (dbConfig, bool) {
+	if cfg.ReadURL == "" {
+		return dbConfig{}, false
+	}
+	replica := cfg
+	replica.URL = cfg.ReadURL
+	replica.ReadURL = ""
+	return replica, true
+}
+
+func openReadReplica(ctx context.Context, cfg dbConfig) *readReplica {
+	replicaCfg, ok := replicaConfig(cfg)
+	if !ok {
+		return nil
+	}
+	db, err := ensureDB(ctx, replicaCfg)
+	if err != nil {
+		warnf("只读副本不可用，读请求使用主库: %v\n", err)
+		return nil
+	}
+	return &readReplica{db: db}
+}
+
+func (r *readReplica) available() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().After(r.downUntil)
+}
+
+func (r *readReplica) markDown(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Now().After(r.downUntil) {
+		warnf("只读副本查询失败，%s 内回退到主库: %v\n", replicaBackoff, err)
+	}
+	r.downUntil = time.Now().Add(replicaBackoff)
+}
+
+func (r *readReplica) close() {
+	if r != nil {
+		r.db.Close()
+	}
+}
+
+// shouldFallback reports whether a replica error warrants retrying on the
+// primary; "no rows" and caller cancellations are real answers.
+func shouldFallback(ctx context.Context, err error) bool {
+	return err != nil && !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil
+}
+
+// readQuery runs a read-only query on the replica when available, retrying on
+// the primary if the replica errors.
+func (s *server) readQuery(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx = s.detachedQueryTimeout(ctx)
+	if s.replica.available() {
+		rows, err := s.replica.db.QueryContext(ctx, query, args...)
+		if !shouldFallback(ctx, err) {
+			return rows, err
+		}
+		s.replica.markDown(err)
+	}
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+// replicaReader routes store reads through readQuery/readQueryRow, so they
+// get the replica fallback and the query timeout.
+type replicaReader struct {
+	s *server
+}
+
+func (r replicaReader) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.s.readQuery(ctx, query, args...)
+}
+
+func (r replicaReader) QueryRow(ctx context.Context, query string, args ...any) store.Row {
+	return r.s.readQueryRow(ctx, query, args...)
+}
+
+// fallbackRow mirrors *sql.Row for readQueryRow; the query runs on Scan.
+type fallbackRow struct {
+	s     *server
+	ctx   context.Context
+	query string
+	args  []any
+}
+
+func (s *server) readQueryRow(ctx context.Context, query string, args ...any) fallbackRow {
+	return fallbackRow{s: s, ctx: ctx, query: query, args: args}
+}
+
+func (r fallbackRow) Scan(dest ...any) error {
+	ctx, cancel := r.s.withQueryTimeout(r.ctx)
+	defer cancel()
+	if r.s.replica.available() {
+		err := r.s.replica.db.QueryRowContext(ctx, r.query, r.args...).Scan(dest...)
+		if !shouldFallback(ctx, err) {
+			return err
+		}
+		r.s.replica.markDown(err)
+	}
+	return r.s.db.QueryRowContext(ctx, r.query, r.args...).Scan(dest...)
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestReplicaConfigInheritsPoolSettings(t *testing.T) {
+	if _, ok := replicaConfig(dbConfig{URL: "postgres://db/blog"}); ok {
+		t.Fatal("expected no replica without readUrl")
+	}
+	got, ok := replicaConfig(dbConfig{Host: "db", ReadURL: "postgres://replica/blog", MaxOpenConns: 20})
+	if !ok || got.URL != "postgres://replica/blog" || got.ReadURL != "" || got.MaxOpenConns != 20 {
+		t.Fatalf("unexpected replica config: %+v", got)
+	}
+}
+
+func TestShouldFallback(t *testing.T) {
+	ctx := context.Background()
+	if shouldFallback(ctx, nil) || shouldFallback(ctx, sql.ErrNoRows) {
+		t.Fatal("nil and ErrNoRows must not fall back")
+	}
+	if !shouldFallback(ctx, errors.New("connection refused")) {
+		t.Fatal("connection errors should fall back to the primary")
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if shouldFallback(canceled, context.Canceled) {
+		t.Fatal("canceled requests must not be retried")
+	}
+	var r *readReplica
+	if r.available() {
+		t.Fatal("nil replica must not be available")
+	}
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"selfecho/backend/internal/store"
+)
+
+// The handlers reach the database through these narrow interfaces rather
+// than *store.Store directly, so tests can swap in fakes without a Postgres.
+// Run wires all of them to the same store.
+
+type articleRepo interface {
+	ListArticles(ctx context.Context, f store.ArticleFilter) ([]store.ArticleRow, int, error)
+}
+
+type archiveRepo interface {
+	ListArchives(ctx context.Context) ([]store.Archive, error)
+	ArchiveByName(ctx context.Context, name string) (store.Archive, error)
+	ListCategories(ctx context.Context) ([]store.Category, error)
+	CreateArchive(ctx context.Context, name, description string) (string, error)
+	UpdateArchive(ctx context.Context, id, name, description string) error
+	DeleteArchive(ctx context.Context, id, reassignTo string) (store.DeletedArchive, error)
+}
+
+type userRepo interface {
+	UserByUsername(ctx context.Context, username string) (store.User, error)
+	CreateUser(ctx context.Context, username, passwordHash, role string) error
+	SessionByToken(ctx context.Context, tokenHash string) (store.Session, error)
+	CreateSession(ctx context.Context, userID, tokenHash string, ttl, maxAge time.Duration, remember bool) (store.Session, error)
+	TouchSession(ctx context.Context, id string, ttl time.Duration) (time.Time, error)
+	DeleteSession(ctx context.Context, id string) error
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+}
+
+type authorRepo interface {
+	ListAuthors(ctx context.Context) ([]store.Author, error)
+	AuthorByUsername(ctx context.Context, username string) (store.Author, error)
+	UpdateProfile(ctx context.Context, userID string, p store.Profile) error
+}
+
+type imapRepo interface {
+	ListImapAccounts(ctx context.Context) ([]store.ImapAccount, error)
+	ImapAccount(ctx context.Context, id string) (store.ImapAccount, error)
+	CreateImapAccount(ctx context.Context, a store.ImapAccount) error
+	SetImapRetention(ctx context.Context, id string, messages, days int) error
+	ImapCacheStats(ctx context.Context) (map[string]store.ImapCacheStats, error)
+	ImapMessages(ctx context.Context, accountID string, limit, offset int) ([]store.ImapMessage, error)
+	CountImapMessages(ctx context.Context, accountID string) (int, error)
+	ImapMailboxCounts(ctx context.Context, accountID string) (total, unseen int, err error)
+	ImapMessage(ctx context.Context, accountID string, uid uint32) (store.ImapMessage, error)
+	ImapThreads(ctx context.Context, accountID string, limit, offset int) ([]store.ImapThread, error)
+	CountImapThreads(ctx context.Context, accountID string) (int, error)
+	ImapThread(ctx context.Context, accountID, threadID string) ([]store.ImapMessage, error)
+}
+
+var (
+	_ articleRepo = (*store.Store)(nil)
+	_ archiveRepo = (*store.Store)(nil)
+	_ userRepo    = (*store.Store)(nil)
+	_ authorRepo  = (*store.Store)(nil)
+	_ imapRepo    = (*store.Store)(nil)
+)
+
+// useStore points every repository at st.
+func (s *server) useStore(st *store.Store) {
+	s.articles = st
+	s.archives = st
+	s.users = st
+	s.authors = st
+	s.mail = st
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"selfecho/backend/internal/store"
+)
+
+type fakeUsers struct {
+	users    map[string]store.User
+	sessions map[string]store.Session
+}
+
+func (f *fakeUsers) UserByUsername(_ context.Context, username string) (store.User, error) {
+	if u, ok := f.users[username]; ok {
+		return u, nil
+	}
+	return store.User{}, store.ErrNotFound
+}
+
+func (f *fakeUsers) CreateUser(_ context.Context, username, passwordHash, role string) error {
+	if _, ok := f.users[username]; !ok {
+		f.users[username] = store.User{ID: "u-" + username, Username: username, PasswordHash: passwordHash, Role: role}
+	}
+	return nil
+}
+
+func (f *fakeUsers) SessionByToken(_ context.Context, tokenHash string) (store.Session, error) {
+	if ss, ok := f.sessions[tokenHash]; ok {
+		return ss, nil
+	}
+	return store.Session{}, store.ErrNotFound
+}
+
+func (f *fakeUsers) CreateSession(_ context.Context, userID, tokenHash string, ttl, maxAge time.Duration, remember bool) (store.Session, error) {
+	for _, u := range f.users {
+		if u.ID == userID {
+			now := time.Now()
+			ss := store.Session{ID: "s" + userID, User: u, Expires: now.Add(min(ttl, maxAge)), AbsoluteExpires: now.Add(maxAge), Remember: remember}
+			f.sessions[tokenHash] = ss
+			return ss, nil
+		}
+	}
+	return store.Session{}, store.ErrNotFound
+}
+
+func (f *fakeUsers) TouchSession(_ context.Context, id string, ttl time.Duration) (time.Time, error) {
+	for k, ss := range f.sessions {
+		if ss.ID == id {
+			ss.Expires = time.Now().Add(ttl)
+			if ss.Expires.After(ss.AbsoluteExpires) {
+				ss.Expires = ss.AbsoluteExpires
+			}
+			f.sessions[k] = ss
+			return ss.Expires, nil
+		}
+	}
+	return time.Time{}, store.ErrNotFound
+}
+
+func (f *fakeUsers) DeleteSession(_ context.Context, id string) error {
+	for k, ss := range f.sessions {
+		if ss.ID == id {
+			delete(f.sessions, k)
+		}
+	}
+	return nil
+}
+
+func (f *fakeUsers) DeleteExpiredSessions(context.Context) (int64, error) {
+	var n int64
+	for k, ss := range f.sessions {
+		if time.Now().After(ss.Expires) {
+			delete(f.sessions, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type fakeArchives struct {
+	archiveRepo
+	items []store.Archive
+	err   error
+}
+
+func (f *fakeArchives) ListArchives(context.Context) ([]store.Archive, error) {
+	return f.items, f.err
+}
+
+func TestLoginSessionWithFakeUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := &fakeUsers{users: map[string]store.User{}, sessions: map[string]store.Session{}}
+	s := &server{users: users, proxies: &proxyTrust{}}
+	if err := s.createUser(context.Background(), "admin", "pw", ""); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/login", s.login)
+	r.POST("/logout", s.logout)
+	r.GET("/me", s.me)
+
+	do := func(method, path, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPost, "/login", `{"username":"admin","password":"wrong"}`, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: got %d", w.Code)
+	}
+	w := do(http.MethodPost, "/login", `{"username":"admin","password":"pw"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: got %d %s", w.Code, w.Body)
+	}
+	var cookie *http.Cookie
+	for _, ck := range w.Result().Cookies() {
+		if ck.Name == sessionCookieName {
+			cookie = ck
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no session cookie")
+	}
+	if w := do(http.MethodGet, "/me", "", cookie); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"role":"admin"`) {
+		t.Fatalf("me: got %d %s", w.Code, w.Body)
+	}
+	do(http.MethodPost, "/logout", "", cookie)
+	if w := do(http.MethodGet, "/me", "", cookie); w.Code != http.StatusUnauthorized {
+		t.Fatalf("me after logout: got %d", w.Code)
+	}
+}
+
+func TestListArchivesWithFakeRepo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	latest := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	repo := &fakeArchives{items: []store.Archive{{ID: "1", Name: "Go", ItemCount: 2, LatestPostAt: &latest}}}
+	s := &server{archives: repo}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/archives", nil)
+	s.listArchives(c)
+	var got []archive
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Name != "Go" || got[0].ItemCount != 2 {
+		t.Fatalf("got %+v", got)
+	}
+
+	repo.err = errors.New("boom")
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/archives", nil)
+	s.listArchives(c)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("repo error: got %d", w.Code)
+	}
+}
+
+// brokenUsers fails every lookup the way an unreachable database would.
+type brokenUsers struct{ fakeUsers }
+
+func (brokenUsers) UserByUsername(context.Context, string) (store.User, error) {
+	return store.User{}, errors.New("connection refused")
+}
+
+func TestLoginLookupFailureIsNotAFailedLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{users: &brokenUsers{}, proxies: &proxyTrust{}, security: newSecurityStats()}
+	r := gin.New()
+	r.POST("/login", s.login)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"admin","password":"pw"}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want 500", w.Code)
+	}
+	if n := s.security.snapshot(time.Now()).LoginFailures; n != 0 {
+		t.Fatalf("lookup error counted as %d failed logins", n)
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+)
+
+// aiCrawlers are the user agents blocked by robots.blockAiBots: crawlers
+// that collect training data or answer-engine content rather than index
+// pages for search.
+var aiCrawlers = []string{
+	"GPTBot",
+	"ChatGPT-User",
+	"OAI-SearchBot",
+	"ClaudeBot",
+	"anthropic-ai",
+	"Google-Extended",
+	"Applebot-Extended",
+	"CCBot",
+	"PerplexityBot",
+	"Bytespider",
+	"meta-externalagent",
+}
+
+const (
+	maxRobotsRules = 100
+	maxCrawlDelay  = 60
+	maxRobotsExtra = 4 << 10
+)
+
+// robotsFieldChar are characters that would let a value start a new
+// robots.txt directive.
+const robotsFieldChar = "\r\n"
+
+// robotsRules are the admin-editable additions to robots.txt. Paths are
+// relative to the site root; basePath is prepended when rendering.
+type robotsRules struct {
+	Disallow    []string `json:"disallow,omitempty"`
+	CrawlDelay  int      `json:"crawlDelay,omitempty"`
+	BlockAIBots bool     `json:"blockAiBots,omitempty"`
+	BlockAgents []string `json:"blockAgents,omitempty"`
+	Extra       string   `json:"extra,omitempty"`
+}
+
+// sitemapRules hide posts (by slug) and categories from sitemap.xml; posts in
+// an excluded category are left out as well.
+type sitemapRules struct {
+	ExcludeSlugs      []string `json:"excludeSlugs,omitempty"`
+	ExcludeCategories []string `json:"excludeCategories,omitempty"`
+}
+
+func cleanRuleList(in []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, v := range in {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+func (r *robotsRules) normalize() error {
+	r.Disallow = cleanRuleList(r.Disallow)
+	r.BlockAgents = cleanRuleList(r.BlockAgents)
+	r.Extra = strings.TrimSpace(r.Extra)
+	if len(r.Disallow) > maxRobotsRules || len(r.BlockAgents) > maxRobotsRules || len(r.Extra) > maxRobotsExtra {
+		return newAPIError(errInvalidRobotsRule, "too many rules")
+	}
+	for _, p := range r.Disallow {
+		if !strings.HasPrefix(p, "/") || strings.ContainsAny(p, robotsFieldChar) {
+			return newAPIError(errInvalidRobotsRule, p)
+		}
+	}
+	for _, a := range r.BlockAgents {
+		if strings.ContainsAny(a, robotsFieldChar+":") {
+			return newAPIError(errInvalidRobotsRule, a)
+		}
+	}
+	if r.CrawlDelay < 0 || r.CrawlDelay > maxCrawlDelay {
+		return newAPIError(errInvalidRobotsRule, "crawlDelay")
+	}
+	return nil
+}
+
+func (r *sitemapRules) normalize() {
+	r.ExcludeSlugs = cleanRuleList(r.ExcludeSlugs)
+	r.ExcludeCategories = cleanRuleList(r.ExcludeCategories)
+}
+
+func (r sitemapRules) excludes(slug, category string) bool {
+	for _, s := range r.ExcludeSlugs {
+		if s == slug {
+			return true
+		}
+	}
+	return category != "" && r.excludesCategory(category)
+}
+
+func (r sitemapRules) excludesCategory(name string) bool {
+	for _, c := range r.ExcludeCategories {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildRobots renders robots.txt: the default group for every crawler, one
+// blocking group for AI and custom agents, then the admin's raw extra lines.
+func buildRobots(basePath, sitemapURL string, r robotsRules) string {
+	lines := []string{
+		"User-agent: *",
+		"Allow: " + basePath + "/",
+		"Disallow: " + basePath + "/admin",
+		"Disallow: " + basePath + "/api",
+	}
+	for _, p := range r.Disallow {
+		lines = append(lines, "Disallow: "+basePath+p)
+	}
+	if r.CrawlDelay > 0 {
+		lines = append(lines, "Crawl-delay: "+strconv.Itoa(r.CrawlDelay))
+	}
+
+	var blocked []string
+	if r.BlockAIBots {
+		blocked = append(blocked, aiCrawlers...)
+	}
+	blocked = append(blocked, r.BlockAgents...)
+	if blocked = cleanRuleList(blocked); len(blocked) > 0 {
+		lines = append(lines, "")
+		for _, agent := range blocked {
+			lines = append(lines, "User-agent: "+agent)
+		}
+		lines = append(lines, "Disallow: /")
+	}
+	if r.Extra != "" {
+		lines = append(lines, "", strings.ReplaceAll(r.Extra, "\r\n", "\n"))
+	}
+	lines = append(lines, "", "Sitemap: "+sitemapURL, "")
+	return strings.Join(lines, "\n")
+}
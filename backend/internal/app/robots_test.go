@@ -0,0 +1,61 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildRobots(t *testing.T) {
+	rules := robotsRules{
+		Disallow:    []string{"/drafts"},
+		CrawlDelay:  5,
+		BlockAIBots: true,
+		BlockAgents: []string{"BadBot", "GPTBot"},
+		Extra:       "User-agent: Bingbot\r\nAllow: /",
+	}
+	if err := rules.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	got := buildRobots("/blog", "https://x.test/blog/sitemap.xml", rules)
+	for _, want := range []string{
+		"Disallow: /blog/drafts\n",
+		"Crawl-delay: 5\n",
+		"User-agent: GPTBot\n",
+		"User-agent: BadBot\nDisallow: /\n",
+		"User-agent: Bingbot\nAllow: /\n",
+		"Sitemap: https://x.test/blog/sitemap.xml\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("robots.txt missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "User-agent: GPTBot") != 1 {
+		t.Fatalf("agents should be deduplicated:\n%s", got)
+	}
+
+	plain := buildRobots("", "https://x.test/sitemap.xml", robotsRules{})
+	if strings.Contains(plain, "Crawl-delay") || strings.Count(plain, "User-agent") != 1 {
+		t.Fatalf("default robots.txt changed:\n%s", plain)
+	}
+}
+
+func TestRobotsRulesRejectInjection(t *testing.T) {
+	for _, r := range []robotsRules{
+		{Disallow: []string{"drafts"}},
+		{Disallow: []string{"/a\nUser-agent: *"}},
+		{BlockAgents: []string{"Bot\nDisallow: /"}},
+		{CrawlDelay: 600},
+	} {
+		if err := r.normalize(); err == nil {
+			t.Fatalf("expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestSitemapRules(t *testing.T) {
+	r := sitemapRules{ExcludeSlugs: []string{" secret "}, ExcludeCategories: []string{"日记"}}
+	r.normalize()
+	if !r.excludes("secret", "") || !r.excludes("post", "日记") || r.excludes("post", "技术") || r.excludes("post", "") {
+		t.Fatalf("unexpected exclusion results for %+v", r)
+	}
+}
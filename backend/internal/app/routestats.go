@@ -0,0 +1,175 @@
+package app
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route stats cover the last routeStatsWindow in routeStatsSlots slots, so the
+// window slides in routeStatsWindow/routeStatsSlots steps.
+const (
+	routeStatsWindow = 5 * time.Minute
+	routeStatsSlots  = 30
+	routeStatsSlot   = routeStatsWindow / routeStatsSlots
+)
+
+// latencyBounds are the upper bounds of the latency histogram: 100µs growing
+// by a quarter per bucket up to about a minute. Percentiles read from it are
+// within 25% of the true value; slower requests land in one overflow bucket.
+var latencyBounds = func() []time.Duration {
+	var b []time.Duration
+	for d := 100 * time.Microsecond; d < time.Minute; d = d * 5 / 4 {
+		b = append(b, d)
+	}
+	return b
+}()
+
+type routeSlot struct {
+	// at is the slot number (unix time / routeStatsSlot) the counts are for.
+	at       int64
+	requests int64
+	errors   int64
+	hist     []uint32
+}
+
+// routeStats keeps per route group request latencies and 5xx counts over the
+// last five minutes, for the health page.
+type routeStats struct {
+	mu     sync.Mutex
+	groups map[string]*[routeStatsSlots]routeSlot
+}
+
+type routeGroupStats struct {
+	Group    string `json:"group"`
+	Requests int64  `json:"requests"`
+	// Errors counts 5xx responses.
+	Errors int64   `json:"errors"`
+	P50Ms  float64 `json:"p50Ms"`
+	P95Ms  float64 `json:"p95Ms"`
+}
+
+func newRouteStats() *routeStats {
+	return &routeStats{groups: map[string]*[routeStatsSlots]routeSlot{}}
+}
+
+func (rs *routeStats) observe(group string, status int, took time.Duration, now time.Time) {
+	if rs == nil {
+		return
+	}
+	at := now.UnixNano() / int64(routeStatsSlot)
+	bucket, _ := slices.BinarySearch(latencyBounds, took)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	slots := rs.groups[group]
+	if slots == nil {
+		slots = new([routeStatsSlots]routeSlot)
+		rs.groups[group] = slots
+	}
+	slot := &slots[at%routeStatsSlots]
+	if slot.hist != nil && slot.at > at {
+		// the slot has moved on since now was read
+		return
+	}
+	if slot.at != at || slot.hist == nil {
+		*slot = routeSlot{at: at, hist: make([]uint32, len(latencyBounds)+1)}
+	}
+	slot.requests++
+	if status >= 500 {
+		slot.errors++
+	}
+	slot.hist[bucket]++
+}
+
+// snapshot sums each group's slots inside the window; groups without
+// requests in it are left out.
+func (rs *routeStats) snapshot(now time.Time) []routeGroupStats {
+	out := []routeGroupStats{}
+	if rs == nil {
+		return out
+	}
+	at := now.UnixNano() / int64(routeStatsSlot)
+	hist := make([]uint32, len(latencyBounds)+1)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for group, slots := range rs.groups {
+		g := routeGroupStats{Group: group}
+		clear(hist)
+		for _, slot := range slots {
+			if slot.hist == nil || at-slot.at >= routeStatsSlots {
+				continue
+			}
+			g.Requests += slot.requests
+			g.Errors += slot.errors
+			for i, n := range slot.hist {
+				hist[i] += n
+			}
+		}
+		if g.Requests == 0 {
+			delete(rs.groups, group)
+			continue
+		}
+		g.P50Ms = histPercentileMs(hist, g.Requests, 0.5)
+		g.P95Ms = histPercentileMs(hist, g.Requests, 0.95)
+		out = append(out, g)
+	}
+	slices.SortFunc(out, func(a, b routeGroupStats) int { return strings.Compare(a.Group, b.Group) })
+	return out
+}
+
+// histPercentileMs is the upper bound of the bucket holding the q-th of n
+// requests. The overflow bucket reports the last bound.
+func histPercentileMs(hist []uint32, n int64, q float64) float64 {
+	rank := int64(math.Ceil(q * float64(n)))
+	var seen int64
+	for i, c := range hist {
+		seen += int64(c)
+		if seen >= rank {
+			bound := latencyBounds[min(i, len(latencyBounds)-1)]
+			return math.Round(float64(bound.Microseconds())/10) / 100
+		}
+	}
+	return 0
+}
+
+// routeGroup names the group a route template belongs to: API routes by
+// their first segment ("/api/posts"), admin APIs one level deeper
+// ("/api/admin/backups"), every other matched route "pages" and requests
+// no route matched (the SPA, media and static files) "static".
+func routeGroup(basePath, fullPath string) string {
+	if fullPath == "" {
+		return "static"
+	}
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(fullPath, basePath), "/api/")
+	if !ok {
+		return "pages"
+	}
+	depth := 1
+	if strings.HasPrefix(rest, "admin/") {
+		depth = 2
+	}
+	parts := strings.SplitN(rest, "/", depth+1)
+	parts = parts[:min(depth, len(parts))]
+	for i, p := range parts {
+		if strings.HasPrefix(p, ":") || strings.HasPrefix(p, "*") {
+			parts = parts[:i]
+			break
+		}
+	}
+	return "/api/" + strings.Join(parts, "/")
+}
+
+// routeStatsMiddleware times every request into s.routeStats.
+func (s *server) routeStatsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		s.routeStats.observe(routeGroup(s.basePath, c.FullPath()), c.Writer.Status(), time.Since(start), time.Now())
+	}
+}
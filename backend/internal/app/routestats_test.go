@@ -0,0 +1,77 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteStats(t *testing.T) {
+	rs := newRouteStats()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	// 100 requests of 1..100ms, two of them failing
+	for i := 1; i <= 100; i++ {
+		status := http.StatusOK
+		if i%50 == 0 {
+			status = http.StatusBadGateway
+		}
+		rs.observe("/api/posts", status, time.Duration(i)*time.Millisecond, now.Add(-time.Duration(i)*time.Second))
+	}
+	rs.observe("/api/posts", http.StatusNotFound, time.Hour, now.Add(-6*time.Minute))
+	rs.observe("pages", http.StatusOK, 3*time.Millisecond, now)
+
+	got := rs.snapshot(now)
+	if len(got) != 2 || got[0].Group != "/api/posts" || got[1].Group != "pages" {
+		t.Fatalf("groups = %+v", got)
+	}
+	posts := got[0]
+	if posts.Requests != 100 || posts.Errors != 2 {
+		t.Fatalf("posts = %+v (the six-minute-old request is outside the window)", posts)
+	}
+	if posts.P50Ms < 50 || posts.P50Ms > 50*1.25 || posts.P95Ms < 95 || posts.P95Ms > 95*1.25 {
+		t.Fatalf("percentiles = %v / %v", posts.P50Ms, posts.P95Ms)
+	}
+
+	if got := rs.snapshot(now.Add(10 * time.Minute)); len(got) != 0 {
+		t.Fatalf("stale groups = %+v", got)
+	}
+	var nilStats *routeStats
+	nilStats.observe("x", http.StatusOK, time.Millisecond, now)
+	if got := nilStats.snapshot(now); got == nil || len(got) != 0 {
+		t.Fatalf("nil stats snapshot = %#v", got)
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	for fullPath, want := range map[string]string{
+		"":                          "static",
+		"/blog/post/:slug":          "pages",
+		"/blog/api/posts/:id":       "/api/posts",
+		"/blog/api/admin/backups":   "/api/admin/backups",
+		"/blog/api/admin/posts/:id": "/api/admin/posts",
+		"/blog/api/admin":           "/api/admin",
+		"/blog/api/:kind/feed":      "/api/",
+	} {
+		if got := routeGroup("/blog", fullPath); got != want {
+			t.Errorf("routeGroup(%q) = %q, want %q", fullPath, got, want)
+		}
+	}
+}
+
+func TestRouteStatsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{routeStats: newRouteStats()}
+	r := gin.New()
+	r.Use(s.routeStatsMiddleware())
+	r.GET("/api/posts/:id", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/posts/7", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/media/a.png", nil))
+
+	got := s.routeStats.snapshot(time.Now())
+	if len(got) != 2 || got[0].Group != "/api/posts" || got[0].Errors != 1 || got[1].Group != "static" || got[1].Errors != 0 {
+		t.Fatalf("stats = %+v", got)
+	}
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchConfig picks the Postgres text search configuration used to build
+// articles.search_vector. "simple" works for mixed Chinese/English text;
+// changing it requires a reindex.
+type searchConfig struct {
+	Language string `yaml:"language"`
+}
+
+func (c searchConfig) language() string {
+	if lang := strings.TrimSpace(c.Language); lang != "" {
+		return lang
+	}
+	return "simple"
+}
+
+// reindexProgressEvery is how many articles pass between progress events.
+const reindexProgressEvery = 50
+
+// searchIndexer maintains the tsvector column. Only one full reindex runs at
+// a time; single-article updates from the write path may overlap with it.
+type searchIndexer struct {
+	lang    string
+	mu      sync.Mutex
+	running bool
+}
+
+func newSearchIndexer(cfg searchConfig) *searchIndexer {
+	return &searchIndexer{lang: cfg.language()}
+}
+
+// begin claims the full-reindex slot; callers must call end when it succeeds.
+func (ix *searchIndexer) begin() bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.running {
+		return false
+	}
+	ix.running = true
+	return true
+}
+
+func (ix *searchIndexer) end() {
+	ix.mu.Lock()
+	ix.running = false
+	ix.mu.Unlock()
+}
+
+func (s *server) ensureSearchSchema(ctx context.Context) error {
+	var lang string
+	if err := s.db.QueryRowContext(ctx, `SELECT $1::regconfig::text`, s.search.lang).Scan(&lang); err != nil {
+		warnf("全文检索配置 %q 无效，改用 simple: %v\n", s.search.lang, err)
+		s.search.lang = "simple"
+	}
+	_, err := s.db.ExecContext(ctx, `
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS search_vector tsvector;
+		CREATE INDEX IF NOT EXISTS idx_articles_search ON articles USING GIN (search_vector);
+	`)
+	return err
+}
+
+// indexArticle rebuilds one article's search_vector: the title weighs most,
+// then the meta description and tags, then the rendered body text.
+func (s *server) indexArticle(ctx context.Context, id string) error {
+	a, ok, err := s.loadArticleText(ctx, id)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE articles SET search_vector =
+			setweight(to_tsvector($2::regconfig, $3), 'A') ||
+			setweight(to_tsvector($2::regconfig, $4), 'B') ||
+			setweight(to_tsvector($2::regconfig, $5), 'C')
+		WHERE id=$1`,
+		a.ID, s.search.lang, a.Title, a.Description+" "+strings.Join(a.Tags, " "), searchableBody(a))
+	return err
+}
+
+// searchableBody is the body text indexed for a; encrypted bodies stay out
+// of the index, which would otherwise keep their words in plaintext.
+func searchableBody(a articleText) string {
+	if a.Sealed {
+		return ""
+	}
+	return markdownPlainText(a.BodyMD)
+}
+
+// refreshSearchIndex is the write-path hook; failures are logged and repaired
+// by the next reindex.
+func (s *server) refreshSearchIndex(id string) {
+	if err := s.indexArticle(context.Background(), id); err != nil {
+		warnf("更新文章 %s 的检索索引失败: %v\n", id, err)
+	}
+}
+
+// reindexSearch rebuilds search_vector for every article (or only the ones
+// that have none), calling progress after each batch and at the end. The
+// caller holds the indexer's begin slot.
+func (s *server) reindexSearch(ctx context.Context, onlyMissing bool, progress func(done, total int)) (int, error) {
+	query := `SELECT id FROM articles`
+	if onlyMissing {
+		query += ` WHERE search_vector IS NULL`
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY created_at ASC`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.indexArticle(ctx, id); err != nil {
+			return i, fmt.Errorf("article %s: %w", id, err)
+		}
+		if progress != nil && ((i+1)%reindexProgressEvery == 0 || i+1 == len(ids)) {
+			progress(i+1, len(ids))
+		}
+	}
+	return len(ids), nil
+}
+
+// startReindex runs reindexSearch in the background, reporting progress to
+// admin listeners as search.reindex events. It returns false when a reindex
+// is already running.
+func (s *server) startReindex(onlyMissing bool) bool {
+	if !s.search.begin() {
+		return false
+	}
+	go func() {
+		defer s.search.end()
+		s.events.publish(changeEvent{Kind: eventSearchReindex, Action: actionStarted, Message: s.search.lang})
+		n, err := s.reindexSearch(context.Background(), onlyMissing, func(done, total int) {
+			s.events.publish(changeEvent{Kind: eventSearchReindex, Action: actionProgress, Message: fmt.Sprintf("%d/%d", done, total)})
+		})
+		ev := changeEvent{Kind: eventSearchReindex, Action: actionFinished, Message: fmt.Sprintf("%d", n)}
+		if err != nil {
+			warnf("重建检索索引失败: %v\n", err)
+			ev.Action = actionFailed
+			ev.Message = err.Error()
+		}
+		s.events.publish(ev)
+	}()
+	return true
+}
+
+// reindexSearchHandler backs POST /api/admin/search/reindex; progress arrives
+// on /api/admin/events.
+func (s *server) reindexSearchHandler(c *gin.Context) {
+	if !s.startReindex(c.Query("missing") == "1") {
+		respondError(c, http.StatusConflict, errReindexInProgress)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "started", "language": s.search.lang})
+}
+
+// RunSearch implements `selfecho search reindex [--missing]`.
+func RunSearch(w io.Writer, args []string) error {
+	if len(args) == 0 || args[0] != "reindex" {
+		return errors.New("usage: selfecho search reindex [--missing]")
+	}
+	onlyMissing := false
+	for _, arg := range args[1:] {
+		switch arg {
+		case "--missing", "-missing":
+			onlyMissing = true
+		default:
+			return fmt.Errorf("未知参数: %s", arg)
+		}
+	}
+	ctx := context.Background()
+	s, cfg, err := openCLIServer(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.db.Close()
+	s.search = newSearchIndexer(cfg.Search)
+	if err := s.ensureSearchSchema(ctx); err != nil {
+		return err
+	}
+	s.search.begin()
+	defer s.search.end()
+	n, err := s.reindexSearch(ctx, onlyMissing, func(done, total int) {
+		fmt.Fprintf(w, "reindex: %d/%d\n", done, total)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "reindexed %d articles with %s\n", n, s.search.lang)
+	return nil
+}
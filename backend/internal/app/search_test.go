@@ -0,0 +1,20 @@
+package app
+
+import "testing"
+
+func TestSearchIndexerSingleReindex(t *testing.T) {
+	ix := newSearchIndexer(searchConfig{})
+	if ix.lang != "simple" {
+		t.Fatalf("default language = %q, want simple", ix.lang)
+	}
+	if !ix.begin() {
+		t.Fatal("first begin should succeed")
+	}
+	if ix.begin() {
+		t.Fatal("second begin should fail while running")
+	}
+	ix.end()
+	if !ix.begin() {
+		t.Fatal("begin should succeed after end")
+	}
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockedIPWindow is how long an address denied admin access counts as
+// blocked in the telemetry.
+const blockedIPWindow = time.Hour
+
+// maxTrackedBlockedIPs bounds the set during a spray from many addresses;
+// the counters keep counting past it.
+const maxTrackedBlockedIPs = 10000
+
+// securityStats counts signs of brute force and probing since start.
+type securityStats struct {
+	loginFailures     atomic.Int64
+	unlockFailures    atomic.Int64
+	challengeMissing  atomic.Int64
+	challengeFailures atomic.Int64
+	adminDenied       atomic.Int64
+
+	mu      sync.Mutex
+	blocked map[string]time.Time
+}
+
+type securitySnapshot struct {
+	LoginFailures     int64 `json:"loginFailures"`
+	UnlockFailures    int64 `json:"unlockFailures"`
+	ChallengeMissing  int64 `json:"challengeMissing"`
+	ChallengeFailures int64 `json:"challengeFailures"`
+	AdminDenied       int64 `json:"adminDenied"`
+	// BlockedIPs counts addresses denied admin access in the last hour.
+	BlockedIPs int `json:"blockedIps"`
+}
+
+func newSecurityStats() *securityStats {
+	return &securityStats{blocked: map[string]time.Time{}}
+}
+
+// The recorders below accept a nil receiver, as servers built in tests
+// have no stats.
+
+func (st *securityStats) loginFailed() {
+	if st != nil {
+		st.loginFailures.Add(1)
+	}
+}
+
+func (st *securityStats) unlockFailed() {
+	if st != nil {
+		st.unlockFailures.Add(1)
+	}
+}
+
+func (st *securityStats) challengeRejected(missing bool) {
+	switch {
+	case st == nil:
+	case missing:
+		st.challengeMissing.Add(1)
+	default:
+		st.challengeFailures.Add(1)
+	}
+}
+
+// blockIP records an admin request refused for ip.
+func (st *securityStats) blockIP(ip string, now time.Time) {
+	if st == nil {
+		return
+	}
+	st.adminDenied.Add(1)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.blocked[ip]; ok || len(st.blocked) < maxTrackedBlockedIPs {
+		st.blocked[ip] = now
+	}
+}
+
+func (st *securityStats) snapshot(now time.Time) securitySnapshot {
+	if st == nil {
+		return securitySnapshot{}
+	}
+	st.mu.Lock()
+	for ip, at := range st.blocked {
+		if now.Sub(at) > blockedIPWindow {
+			delete(st.blocked, ip)
+		}
+	}
+	blocked := len(st.blocked)
+	st.mu.Unlock()
+	return securitySnapshot{
+		LoginFailures:     st.loginFailures.Load(),
+		UnlockFailures:    st.unlockFailures.Load(),
+		ChallengeMissing:  st.challengeMissing.Load(),
+		ChallengeFailures: st.challengeFailures.Load(),
+		AdminDenied:       st.adminDenied.Load(),
+		BlockedIPs:        blocked,
+	}
+}
+
+// metricsConfig enables GET /metrics in the Prometheus text format. The
+// endpoint stays off (404) until Token (or METRICS_TOKEN) is set; scrapers
+// send it as a bearer token.
+type metricsConfig struct {
+	Token string `yaml:"token"`
+}
+
+// writeSecurityMetrics renders snap in the Prometheus text exposition format.
+func writeSecurityMetrics(b *strings.Builder, snap securitySnapshot) {
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("selfecho_login_failures_total", "counter", "Failed admin logins.", snap.LoginFailures)
+	metric("selfecho_unlock_failures_total", "counter", "Wrong passwords for protected posts.", snap.UnlockFailures)
+	fmt.Fprintf(b, "# HELP selfecho_challenge_rejections_total Requests to challenge-guarded endpoints that did not pass.\n"+
+		"# TYPE selfecho_challenge_rejections_total counter\n"+
+		"selfecho_challenge_rejections_total{reason=\"missing\"} %d\n"+
+		"selfecho_challenge_rejections_total{reason=\"failed\"} %d\n", snap.ChallengeMissing, snap.ChallengeFailures)
+	metric("selfecho_admin_denied_total", "counter", "Admin requests refused by the network or client certificate guard.", snap.AdminDenied)
+	metric("selfecho_blocked_ips", "gauge", "Addresses refused admin access in the last hour.", snap.BlockedIPs)
+}
+
+func (s *server) metricsHandler(c *gin.Context) {
+	if s.metricsToken == "" {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.metricsToken)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	var b strings.Builder
+	writeSecurityMetrics(&b, s.security.snapshot(time.Now()))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
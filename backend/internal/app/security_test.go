@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityStats(t *testing.T) {
+	st := newSecurityStats()
+	now := time.Now()
+	st.loginFailed()
+	st.loginFailed()
+	st.challengeRejected(true)
+	st.blockIP("203.0.113.5", now.Add(-2*time.Hour))
+	st.blockIP("203.0.113.6", now)
+	st.blockIP("203.0.113.6", now)
+	snap := st.snapshot(now)
+	if snap.LoginFailures != 2 || snap.ChallengeMissing != 1 || snap.AdminDenied != 3 {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+	if snap.BlockedIPs != 1 {
+		t.Fatalf("blocked IPs = %d, want 1 (the other aged out)", snap.BlockedIPs)
+	}
+
+	var nilStats *securityStats
+	nilStats.loginFailed()
+	if got := nilStats.snapshot(now); got != (securitySnapshot{}) {
+		t.Fatalf("nil stats snapshot = %+v", got)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{security: newSecurityStats()}
+	s.security.unlockFailed()
+	r := gin.New()
+	r.GET("/metrics", s.metricsHandler)
+	get := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	if w := get(""); w.Code != http.StatusNotFound {
+		t.Fatalf("without a token configured: %d", w.Code)
+	}
+	s.metricsToken = "scrape"
+	if w := get("Bearer nope"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %d", w.Code)
+	}
+	w := get("Bearer scrape")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "selfecho_unlock_failures_total 1\n") ||
+		!strings.Contains(w.Body.String(), `selfecho_challenge_rejections_total{reason="failed"} 0`) {
+		t.Fatalf("metrics: %d\n%s", w.Code, w.Body)
+	}
+}
@@ -7,10 +7,10 @@ import (
 	"encoding/xml"
 	"fmt"
 	"html"
+	"io/fs"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -26,15 +26,14 @@ type indexTemplateEntry struct {
 
 var indexTemplateCache sync.Map
 
-func getIndexTemplate(staticDir string) (string, error) {
-	staticDir = filepath.Clean(staticDir)
-	if staticDir == "" {
-		return "", fmt.Errorf("staticDir is empty")
+func getIndexTemplate(spa fs.FS) (string, error) {
+	if spa == nil {
+		return "", fmt.Errorf("frontend build not available")
 	}
-	val, _ := indexTemplateCache.LoadOrStore(staticDir, &indexTemplateEntry{})
+	val, _ := indexTemplateCache.LoadOrStore(spa, &indexTemplateEntry{})
 	entry := val.(*indexTemplateEntry)
 	entry.once.Do(func() {
-		bytes, err := os.ReadFile(filepath.Join(staticDir, "index.html"))
+		bytes, err := fs.ReadFile(spa, "index.html")
 		if err != nil {
 			entry.err = err
 			return
@@ -44,23 +43,44 @@ func getIndexTemplate(staticDir string) (string, error) {
 	return entry.html, entry.err
 }
 
-func requestBaseURL(r *http.Request) string {
+// requestBaseURL derives scheme://host for r. X-Forwarded-Proto/Host are only
+// honored when trustForwarded is set, i.e. the peer is a trusted proxy.
+func requestBaseURL(r *http.Request, trustForwarded bool) string {
 	scheme := "http"
 	if r.TLS != nil {
 		scheme = "https"
 	}
-	if proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]); proto != "" {
-		if sanitized := sanitizeScheme(proto); sanitized != "" {
-			scheme = sanitized
+	var host string
+	if trustForwarded {
+		if proto := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]); proto != "" {
+			if sanitized := sanitizeScheme(proto); sanitized != "" {
+				scheme = sanitized
+			}
 		}
+		host = sanitizeHost(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0]))
 	}
-	host := sanitizeHost(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0]))
 	if host == "" {
 		host = sanitizeHost(r.Host)
 	}
 	return scheme + "://" + host
 }
 
+// baseURL is the absolute site root including the configured basePath.
+func (s *server) baseURL(c *gin.Context) string {
+	return s.originURL(c.Request) + s.basePath
+}
+
+var baseHrefPattern = regexp.MustCompile(`(?i)<base\s+href="[^"]*"\s*/?>`)
+
+// rewriteBaseHref points the SPA's <base href> at basePath so Angular resolves
+// its bundles, routes and API calls under the sub-path.
+func rewriteBaseHref(doc, basePath string) string {
+	if basePath == "" {
+		return doc
+	}
+	return baseHrefPattern.ReplaceAllLiteralString(doc, `<base href="`+html.EscapeString(basePath)+`/">`)
+}
+
 func sanitizeScheme(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
 	if s == "http" || s == "https" {
@@ -162,7 +182,26 @@ func truncateRunes(s string, max int) string {
 	return string(r[:max]) + "…"
 }
 
+// excerptStoreRunes is how much plain text the excerpt column keeps; it must
+// exceed every length excerptFromArticle is asked for so truncation still
+// knows whether to add an ellipsis.
+const excerptStoreRunes = 320
+
+// plainExcerpt is the stored excerpt for rendered body HTML.
+func plainExcerpt(bodyHTML string) string {
+	text := collapseWhitespace(html.UnescapeString(stripHTMLTags(bodyHTML)))
+	if r := []rune(text); len(r) > excerptStoreRunes {
+		text = string(r[:excerptStoreRunes])
+	}
+	return text
+}
+
+// excerptFromArticle prefers the stored excerpt and only renders the body for
+// rows loaded without one.
 func excerptFromArticle(a article, maxRunes int) string {
+	if a.Excerpt != "" {
+		return truncateRunes(a.Excerpt, maxRunes)
+	}
 	content := strings.TrimSpace(a.BodyHTML)
 	if content == "" {
 		content = renderMarkdown(a.BodyMD)
@@ -185,6 +224,11 @@ func escapeJSONForHTMLScript(jsonLD string) string {
 }
 
 func seoHead(siteTitle, pageTitle, description, canonical, ogType, jsonLD string) string {
+	return seoHeadCard(siteTitle, pageTitle, description, canonical, ogType, jsonLD, socialCard{})
+}
+
+// seoHeadCard is seoHead with per-page social card overrides.
+func seoHeadCard(siteTitle, pageTitle, description, canonical, ogType, jsonLD string, card socialCard) string {
 	fullTitle := pageTitle
 	if siteTitle != "" && pageTitle != "" && siteTitle != pageTitle {
 		fullTitle = pageTitle + " - " + siteTitle
@@ -195,17 +239,7 @@ func seoHead(siteTitle, pageTitle, description, canonical, ogType, jsonLD string
 	var b strings.Builder
 	b.WriteString(`<meta name="description" content="` + html.EscapeString(description) + `">`)
 	b.WriteString(`<link rel="canonical" href="` + html.EscapeString(canonical) + `">`)
-	b.WriteString(`<meta property="og:title" content="` + html.EscapeString(fullTitle) + `">`)
-	b.WriteString(`<meta property="og:description" content="` + html.EscapeString(description) + `">`)
-	b.WriteString(`<meta property="og:url" content="` + html.EscapeString(canonical) + `">`)
-	if siteTitle != "" {
-		b.WriteString(`<meta property="og:site_name" content="` + html.EscapeString(siteTitle) + `">`)
-	}
-	if ogType == "" {
-		ogType = "website"
-	}
-	b.WriteString(`<meta property="og:type" content="` + html.EscapeString(ogType) + `">`)
-	b.WriteString(`<meta name="twitter:card" content="summary">`)
+	b.WriteString(socialHead(siteTitle, fullTitle, description, canonical, ogType, card))
 	if jsonLD != "" {
 		b.WriteString(`<script type="application/ld+json">` + escapeJSONForHTMLScript(jsonLD) + `</script>`)
 	}
@@ -213,17 +247,26 @@ func seoHead(siteTitle, pageTitle, description, canonical, ogType, jsonLD string
 }
 
 func (s *server) queryPublishedPostBySlug(ctx context.Context, slug string) (article, bool, error) {
+	return s.queryPost(ctx, `art.status='published' AND art.type='post' AND art.slug=$1`, slug)
+}
+
+// queryPost loads the first article matching cond (which references $1 as
+// arg) with everything the post page renders.
+func (s *server) queryPost(ctx context.Context, cond string, arg any) (article, bool, error) {
 	var a article
 	var archiveName sql.NullString
 	var publishedAt sql.NullTime
-	err := s.db.QueryRowContext(ctx, `
+	var social, meta []byte
+	err := s.readQueryRow(ctx, `
 		SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
-		       art.body_md, art.body_html, art.published_at, art.created_at, art.updated_at
+		       art.body_md, art.body_html, COALESCE(art.excerpt, ''), art.meta_description, to_json(art.tags)::text, art.social::text, art.lang,
+		       art.meta::text, art.visibility, art.password_hash, art.published_at, art.created_at, art.updated_at
 		FROM articles art
 		LEFT JOIN archives ar ON ar.id = art.archive_id
-		WHERE art.status='published' AND art.type='post' AND art.slug=$1
-		LIMIT 1`, slug).
-		Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &archiveName, &a.Status, &a.BodyMD, &a.BodyHTML, &publishedAt, &a.CreatedAt, &a.UpdatedAt)
+		WHERE `+cond+`
+		LIMIT 1`, arg).
+		Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &archiveName, &a.Status, &a.BodyMD, &a.BodyHTML, &a.Excerpt, &a.Description, &a.Tags, &social, &a.Lang,
+			&meta, &a.Visibility, &a.passHash, &publishedAt, &a.CreatedAt, &a.UpdatedAt)
 	if err != nil {
 		if errorsIsNotFound(err) {
 			return article{}, false, nil
@@ -236,6 +279,12 @@ func (s *server) queryPublishedPostBySlug(ctx context.Context, slug string) (art
 	if publishedAt.Valid {
 		a.PublishedAt = &publishedAt.Time
 	}
+	a.Social = parseSocialCard(social)
+	a.Meta = parseCustomFields(meta)
+	s.openArticle(&a)
+	if a.Authors, err = s.queryBylines(ctx, a.ID); err != nil {
+		return article{}, false, err
+	}
 	return a, true, nil
 }
 
@@ -247,12 +296,15 @@ func (s *server) queryLatestPosts(ctx context.Context, limit int) ([]article, er
 	if limit <= 0 || limit > 50 {
 		limit = 20
 	}
-	rows, err := s.db.QueryContext(ctx, `
+	rows, err := s.readQuery(ctx, `
 		SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
-		       art.body_md, art.body_html, art.published_at, art.created_at, art.updated_at
+		       '' AS body_md,
+		       CASE WHEN art.visibility <> 'password' AND art.excerpt IS NULL THEN art.body_html ELSE '' END,
+		       CASE WHEN art.visibility = 'password' THEN '' ELSE COALESCE(art.excerpt, '') END,
+		       art.published_at, art.created_at, art.updated_at
 		FROM articles art
 		LEFT JOIN archives ar ON ar.id = art.archive_id
-		WHERE art.status='published' AND art.type='post'
+		WHERE art.status='published' AND art.type='post' AND art.visibility <> 'unlisted'
 		ORDER BY COALESCE(art.published_at, art.created_at) DESC, art.created_at DESC
 		LIMIT $1`, limit)
 	if err != nil {
@@ -265,7 +317,7 @@ func (s *server) queryLatestPosts(ctx context.Context, limit int) ([]article, er
 		var a article
 		var archiveName sql.NullString
 		var publishedAt sql.NullTime
-		if err := rows.Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &archiveName, &a.Status, &a.BodyMD, &a.BodyHTML, &publishedAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		if err := rows.Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &archiveName, &a.Status, &a.BodyMD, &a.BodyHTML, &a.Excerpt, &publishedAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
 			return nil, err
 		}
 		if archiveName.Valid {
@@ -281,13 +333,15 @@ func (s *server) queryLatestPosts(ctx context.Context, limit int) ([]article, er
 
 func (s *server) queryAllPublishedPostSlugs(ctx context.Context) ([]struct {
 	Slug    string
+	Archive string
 	Updated time.Time
 }, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT slug, updated_at
-		FROM articles
-		WHERE status='published' AND type='post'
-		ORDER BY updated_at DESC`)
+	rows, err := s.readQuery(ctx, `
+		SELECT art.slug, COALESCE(ar.name, ''), art.updated_at
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		WHERE art.status='published' AND art.type='post' AND art.visibility = 'public'
+		ORDER BY art.updated_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -295,14 +349,16 @@ func (s *server) queryAllPublishedPostSlugs(ctx context.Context) ([]struct {
 
 	var items []struct {
 		Slug    string
+		Archive string
 		Updated time.Time
 	}
 	for rows.Next() {
 		var it struct {
 			Slug    string
+			Archive string
 			Updated time.Time
 		}
-		if err := rows.Scan(&it.Slug, &it.Updated); err != nil {
+		if err := rows.Scan(&it.Slug, &it.Archive, &it.Updated); err != nil {
 			return nil, err
 		}
 		items = append(items, it)
@@ -311,11 +367,11 @@ func (s *server) queryAllPublishedPostSlugs(ctx context.Context) ([]struct {
 }
 
 func (s *server) queryCategorySummaries(ctx context.Context) ([]categorySummary, error) {
-	rows, err := s.db.QueryContext(ctx, `
+	rows, err := s.readQuery(ctx, `
 		SELECT COALESCE(ar.name, '未分类') AS name, COUNT(*) AS count
 		FROM articles art
 		LEFT JOIN archives ar ON ar.id = art.archive_id
-		WHERE art.status = 'published' AND art.type = 'post'
+		WHERE art.status = 'published' AND art.type = 'post' AND art.visibility <> 'unlisted'
 		GROUP BY COALESCE(ar.name, '未分类')
 		ORDER BY count DESC, name ASC`)
 	if err != nil {
@@ -334,33 +390,36 @@ func (s *server) queryCategorySummaries(ctx context.Context) ([]categorySummary,
 	return items, nil
 }
 
-func (s *server) queryPostsByArchive(ctx context.Context, archive string, limit int) ([]article, error) {
-	if limit <= 0 || limit > 200 {
-		limit = 200
-	}
-	archive = strings.TrimSpace(archive)
+// countPostsByArchive counts published posts in archive ("" means
+// uncategorized when used by category pages, all posts on /archive).
+func (s *server) countPostsByArchive(ctx context.Context, archive string, all bool) (int, error) {
+	var total int
+	err := s.readQueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		WHERE art.status='published' AND art.type='post' AND art.visibility <> 'unlisted' AND ($2 OR COALESCE(ar.name, '') = $1)`,
+		strings.TrimSpace(archive), all).Scan(&total)
+	return total, err
+}
 
-	var rows *sql.Rows
-	var err error
-	if archive == "" {
-		rows, err = s.db.QueryContext(ctx, `
-			SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
-			       '' AS body_md, '' AS body_html, art.published_at, art.created_at, art.updated_at
-			FROM articles art
-			LEFT JOIN archives ar ON ar.id = art.archive_id
-			WHERE art.status='published' AND art.type='post'
-			ORDER BY COALESCE(art.published_at, art.created_at) DESC, art.created_at DESC
-			LIMIT $1`, limit)
-	} else {
-		rows, err = s.db.QueryContext(ctx, `
-			SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
-			       '' AS body_md, '' AS body_html, art.published_at, art.created_at, art.updated_at
-			FROM articles art
-			LEFT JOIN archives ar ON ar.id = art.archive_id
-			WHERE art.status='published' AND art.type='post' AND COALESCE(ar.name, '') = $1
-			ORDER BY COALESCE(art.published_at, art.created_at) DESC, art.created_at DESC
-			LIMIT $2`, archive, limit)
+// queryPostsByArchive lists one page of published posts in archive, or of
+// every post when all is set.
+func (s *server) queryPostsByArchive(ctx context.Context, archive string, all bool, page, size int) ([]article, error) {
+	if size <= 0 || size > 200 {
+		size = archivePageSize
 	}
+	if page < 1 {
+		page = 1
+	}
+	rows, err := s.readQuery(ctx, `
+		SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
+		       '' AS body_md, '' AS body_html, art.published_at, art.created_at, art.updated_at
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		WHERE art.status='published' AND art.type='post' AND art.visibility <> 'unlisted' AND ($2 OR COALESCE(ar.name, '') = $1)
+		ORDER BY COALESCE(art.published_at, art.created_at) DESC, art.created_at DESC
+		LIMIT $3 OFFSET $4`, strings.TrimSpace(archive), all, size, (page-1)*size)
 	if err != nil {
 		return nil, err
 	}
@@ -382,13 +441,15 @@ func (s *server) queryPostsByArchive(ctx context.Context, archive string, limit
 		}
 		items = append(items, a)
 	}
-	return items, nil
+	return items, rows.Err()
 }
 
-func (s *server) seoHomeHandler(staticDir, siteTitle string) gin.HandlerFunc {
+func (s *server) seoHomeHandler(spa fs.FS) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		site := s.siteSettings()
+		siteTitle := site.Title
 		ctx := c.Request.Context()
-		base := requestBaseURL(c.Request)
+		base := s.baseURL(c)
 		canonical := base + "/"
 
 		items, err := s.queryLatestPosts(ctx, 20)
@@ -404,9 +465,9 @@ func (s *server) seoHomeHandler(staticDir, siteTitle string) gin.HandlerFunc {
 			b.WriteString(`<article class="article-entry space-y-3">`)
 			b.WriteString(`<header class="space-y-1">`)
 			b.WriteString(`<h2 class="text-[1.6rem] font-semibold text-[#3d3d3f] py-2">`)
-			b.WriteString(`<a href="/post/` + urlPathEscape(it.Slug) + `" class="text-[#3c546c]">` + html.EscapeString(it.Title) + `</a>`)
+			b.WriteString(`<a href="` + s.basePath + `/post/` + urlPathEscape(it.Slug) + `" class="text-[#3c546c]">` + html.EscapeString(it.Title) + `</a>`)
 			b.WriteString(`</h2>`)
-			b.WriteString(`<p class="text-xs text-[#aaa] py-1">发布时间：` + html.EscapeString(it.CreatedAt.Format("2006-01-02 15:04")) + `</p>`)
+			b.WriteString(`<p class="text-xs text-[#aaa] py-1">发布时间：` + html.EscapeString(s.formatSiteTime(it.CreatedAt)) + `</p>`)
 			b.WriteString(`</header>`)
 			b.WriteString(`<p class="text-[16px] leading-8 text-[#3d3d3f] tracking-[0.0625em]">` + html.EscapeString(desc) + `</p>`)
 			b.WriteString(`</article>`)
@@ -417,108 +478,184 @@ func (s *server) seoHomeHandler(staticDir, siteTitle string) gin.HandlerFunc {
 		if siteTitle != "" {
 			description = siteTitle + " - " + description
 		}
-		headExtras := seoHead(siteTitle, siteTitle, description, canonical, "website", "")
-
-		doc, err := getIndexTemplate(staticDir)
-		if err != nil {
-			c.Header("Content-Type", "text/html; charset=utf-8")
-			c.String(http.StatusOK, minimalHTML(siteTitle, headExtras, b.String()))
-			return
+		if site.Description != "" {
+			description = site.Description
 		}
-		doc = setTitle(doc, siteTitle)
-		doc = injectBeforeEndTag(doc, "</head>", headExtras)
-		doc = injectIntoAppRoot(doc, b.String())
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(http.StatusOK, doc)
+		publisher := publisherEntity(site, canonical)
+		website := map[string]any{
+			"@type":     "WebSite",
+			"name":      siteTitle,
+			"url":       canonical,
+			"publisher": map[string]any{"@id": publisher["@id"]},
+		}
+		if site.Description != "" {
+			website["description"] = site.Description
+		}
+		headExtras := seoHead(siteTitle, siteTitle, description, canonical, "website", jsonLDGraph(website, publisher))
+
+		s.writeSSR(c, spa, siteTitle, headExtras, b.String())
 	}
 }
 
-func (s *server) seoPostHandler(staticDir, siteTitle string) gin.HandlerFunc {
+func (s *server) seoPostHandler(spa fs.FS) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx := c.Request.Context()
-		slug := strings.TrimSpace(c.Param("slug"))
-		if slug == "" {
-			c.Status(http.StatusNotFound)
-			return
+		if a, ok := s.lookupPublishedPost(c, ""); ok {
+			s.renderPost(c, spa, a, false)
 		}
+	}
+}
 
-		a, ok, err := s.queryPublishedPostBySlug(ctx, slug)
-		if err != nil {
-			c.Status(http.StatusInternalServerError)
-			return
-		}
-		if !ok {
-			c.Status(http.StatusNotFound)
-			return
-		}
-
-		base := requestBaseURL(c.Request)
-		canonical := base + "/post/" + urlPathEscape(slug)
-		desc := excerptFromArticle(a, 180)
-
-		var jsonLD string
-		jsonLD = buildJSONLD(map[string]any{
-			"@context": "https://schema.org",
-			"@type":    "BlogPosting",
-			"headline": a.Title,
-			"datePublished": func() string {
-				if a.PublishedAt != nil {
-					return a.PublishedAt.Format(time.RFC3339)
-				}
-				return a.CreatedAt.Format(time.RFC3339)
-			}(),
-			"dateModified":        a.UpdatedAt.Format(time.RFC3339),
-			"mainEntityOfPage":    canonical,
-			"url":                 canonical,
-			"isAccessibleForFree": true,
-		})
-
-		headExtras := seoHead(siteTitle, a.Title, desc, canonical, "article", jsonLD)
-
-		bodyHTML := strings.TrimSpace(a.BodyHTML)
-		if bodyHTML == "" {
-			bodyHTML = renderMarkdown(a.BodyMD)
-		}
-		archiveName := a.Archive
-		if strings.TrimSpace(archiveName) == "" {
-			archiveName = "未分类"
-		}
+// lookupPublishedPost loads the post named by the :slug parameter for one of
+// the /post/:slug pages. When it is not found it answers the request itself:
+// renamed slugs redirect to /post/<current><suffix>, anything else is 404.
+func (s *server) lookupPublishedPost(c *gin.Context, suffix string) (article, bool) {
+	ctx := c.Request.Context()
+	slug := strings.TrimSpace(c.Param("slug"))
+	if slug == "" {
+		c.Status(http.StatusNotFound)
+		return article{}, false
+	}
+	a, ok, err := s.queryPublishedPostBySlug(ctx, slug)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return article{}, false
+	}
+	if !ok {
+		if current, found, err := s.resolveSlugRedirect(ctx, slug); err == nil && found {
+			c.Redirect(http.StatusMovedPermanently, s.basePath+"/post/"+urlPathEscape(current)+suffix)
+			return article{}, false
+		}
+		c.Status(http.StatusNotFound)
+		return article{}, false
+	}
+	if a.Visibility == visibilityPassword {
+		// the page depends on the unlock cookie, so keep it out of shared caches
+		c.Header("Cache-Control", "private, no-store")
+	}
+	return a, true
+}
+
+// renderPost writes the SSR page for a. Previews render drafts the same way
+// but are marked noindex and carry a banner.
+func (s *server) renderPost(c *gin.Context, spa fs.FS, a article, preview bool) {
+	siteTitle := s.siteSettings().Title
+	ctx := c.Request.Context()
+	base := s.baseURL(c)
+	canonical := base + "/post/" + urlPathEscape(a.Slug)
+	unlocked := preview || s.isUnlocked(c, a)
+	desc := a.Description
+	if desc == "" && unlocked {
+		desc = excerptFromArticle(a, 180)
+	}
+
+	loc := s.siteLocation()
+	site := s.siteSettings()
+	publisher := publisherEntity(site, base+"/")
+	lang := s.contentLang(a.Lang)
+	posting := map[string]any{
+		"@type":      "BlogPosting",
+		"headline":   a.Title,
+		"inLanguage": lang,
+		"datePublished": func() string {
+			if a.PublishedAt != nil {
+				return a.PublishedAt.In(loc).Format(time.RFC3339)
+			}
+			return a.CreatedAt.In(loc).Format(time.RFC3339)
+		}(),
+		"dateModified":        a.UpdatedAt.In(loc).Format(time.RFC3339),
+		"mainEntityOfPage":    canonical,
+		"url":                 canonical,
+		"isAccessibleForFree": true,
+		"publisher":           map[string]any{"@id": publisher["@id"]},
+	}
+	if desc != "" {
+		posting["description"] = desc
+	}
+	if len(a.Authors) > 0 {
+		people := make([]map[string]any, len(a.Authors))
+		for i, by := range a.Authors {
+			people[i] = map[string]any{"@type": "Person", "name": by.name(), "url": base + "/author/" + urlPathEscape(by.Username)}
+		}
+		posting["author"] = people
+	} else if site.Author != "" {
+		posting["author"] = map[string]any{"@type": "Person", "name": site.Author}
+	} else if publisher["@type"] == "Person" {
+		posting["author"] = map[string]any{"@id": publisher["@id"]}
+	}
+	crumbs := []crumb{{Name: siteTitle, URL: base + "/"}}
+	if strings.TrimSpace(a.Archive) != "" {
+		crumbs = append(crumbs, crumb{Name: a.Archive, URL: base + "/category/" + urlPathEscape(a.Archive)})
+	}
+	crumbs = append(crumbs, crumb{Name: a.Title, URL: canonical})
+	jsonLD := jsonLDGraph(posting, breadcrumbList(crumbs), publisher)
+
+	var card socialCard
+	if a.Social != nil {
+		card = *a.Social
+	}
+	headExtras := seoHeadCard(siteTitle, a.Title, desc, canonical, "article", jsonLD, card)
+	if card.Type == "" || card.Type == "article" {
+		headExtras += articleMeta(a, loc)
+	}
+	if translations, err := s.queryTranslations(ctx, a.ID); err != nil {
+		warnf("查询文章译文失败: %v\n", err)
+	} else {
+		headExtras += hreflangLinks(base, s.contentLang(""), translations)
+	}
+	if !preview {
+		headExtras += `<link rel="alternate" media="print" href="` + html.EscapeString(canonical+"/plain") + `">`
+	}
+	if preview || a.Visibility == visibilityPassword {
+		headExtras += `<meta name="robots" content="noindex, nofollow">`
+	} else if a.Visibility == visibilityUnlisted {
+		headExtras += `<meta name="robots" content="noindex">`
+	}
 
-		var b strings.Builder
-		b.WriteString(`<section class="space-y-5 py-6">`)
-		b.WriteString(`<article class="space-y-3">`)
-		b.WriteString(`<header class="post-meta">`)
-		b.WriteString(`<h1 class="post-title text-[2rem] font-semibold text-[#3d3d3f] py-[4em]">` + html.EscapeString(a.Title) + `</h1>`)
-		publishedAt := a.CreatedAt
-		if a.PublishedAt != nil {
-			publishedAt = *a.PublishedAt
-		}
-		b.WriteString(`<p class="post-time text-xs text-[#aaa]">发布时间：` + html.EscapeString(publishedAt.Format("2006-01-02 15:04")) + `</p>`)
-		b.WriteString(`<p class="post-time text-xs text-[#aaa]">分类：<a href="/category/` + urlPathEscape(archiveName) + `" class="category-link">` + html.EscapeString(archiveName) + `</a></p>`)
-		b.WriteString(`</header>`)
-		b.WriteString(`<div class="article-body space-y-3 text-[16px] leading-8 text-[#3d3d3f] tracking-[0.0625em]">` + bodyHTML + `</div>`)
-		b.WriteString(`<div class="pt-2"><a href="/" class="text-sm text-[#3c546c] hover:underline">← 返回首页</a></div>`)
-		b.WriteString(`</article>`)
-		b.WriteString(`</section>`)
+	bodyHTML := strings.TrimSpace(a.BodyHTML)
+	if bodyHTML == "" {
+		bodyHTML = renderMarkdown(a.BodyMD)
+	}
+	if !unlocked {
+		bodyHTML = s.lockedPostBody(a, c.Query("unlock") == "failed")
+	} else {
+		bodyHTML = s.proxyImages(s.expandFileShortcodes(ctx, s.expandGallery(ctx, a.ID, bodyHTML)))
+	}
+	archiveName := a.Archive
+	if strings.TrimSpace(archiveName) == "" {
+		archiveName = "未分类"
+	}
 
-		doc, err := getIndexTemplate(staticDir)
-		if err != nil {
-			c.Header("Content-Type", "text/html; charset=utf-8")
-			c.String(http.StatusOK, minimalHTML(a.Title, headExtras, b.String()))
-			return
-		}
-		doc = setTitle(doc, a.Title)
-		doc = injectBeforeEndTag(doc, "</head>", headExtras)
-		doc = injectIntoAppRoot(doc, b.String())
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(http.StatusOK, doc)
+	var b strings.Builder
+	b.WriteString(`<section class="space-y-5 py-6">`)
+	if preview {
+		b.WriteString(`<p class="preview-banner rounded bg-[#fff8e1] px-4 py-2 text-sm text-[#8a6d3b]">草稿预览，内容尚未发布</p>`)
+	}
+	b.WriteString(`<article class="space-y-3">`)
+	b.WriteString(`<header class="post-meta">`)
+	b.WriteString(`<h1 class="post-title text-[2rem] font-semibold text-[#3d3d3f] py-[4em]">` + html.EscapeString(a.Title) + `</h1>`)
+	publishedAt := a.CreatedAt
+	if a.PublishedAt != nil {
+		publishedAt = *a.PublishedAt
 	}
+	b.WriteString(`<p class="post-time text-xs text-[#aaa]">发布时间：` + html.EscapeString(s.formatSiteTime(publishedAt)) + `</p>`)
+	if len(a.Authors) > 0 {
+		b.WriteString(`<p class="post-authors text-xs text-[#aaa]">作者：` + s.bylineHTML(a.Authors) + `</p>`)
+	}
+	b.WriteString(`<p class="post-time text-xs text-[#aaa]">分类：<a href="` + s.basePath + `/category/` + urlPathEscape(archiveName) + `" class="category-link">` + html.EscapeString(archiveName) + `</a></p>`)
+	b.WriteString(`</header>`)
+	b.WriteString(`<div class="article-body space-y-3 text-[16px] leading-8 text-[#3d3d3f] tracking-[0.0625em]">` + bodyHTML + `</div>`)
+	b.WriteString(`<div class="pt-2"><a href="` + s.basePath + `/" class="text-sm text-[#3c546c] hover:underline">← 返回首页</a></div>`)
+	b.WriteString(`</article>`)
+	b.WriteString(`</section>`)
+
+	s.writeSSRLang(c, spa, lang, a.Title, headExtras, b.String())
 }
 
-func (s *server) seoCategoriesHandler(staticDir, siteTitle string) gin.HandlerFunc {
+func (s *server) seoCategoriesHandler(spa fs.FS) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
 		ctx := c.Request.Context()
-		base := requestBaseURL(c.Request)
+		base := s.baseURL(c)
 		canonical := base + "/categories"
 
 		items, err := s.queryCategorySummaries(ctx)
@@ -531,7 +668,7 @@ func (s *server) seoCategoriesHandler(staticDir, siteTitle string) gin.HandlerFu
 		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 text-center sm:px-9 md:px-12 lg:px-[10rem]">`)
 		b.WriteString(`<div class="grid grid-cols-1 gap-4">`)
 		for _, it := range items {
-			b.WriteString(`<a class="rounded border border-slate-200 px-4 py-3 text-left transition hover:border-[#3273dc] hover:bg-[#f6f9ff]" href="/category/` + urlPathEscape(it.Name) + `">`)
+			b.WriteString(`<a class="rounded border border-slate-200 px-4 py-3 text-left transition hover:border-[#3273dc] hover:bg-[#f6f9ff]" href="` + s.basePath + `/category/` + urlPathEscape(it.Name) + `">`)
 			b.WriteString(`<div class="text-[1.2rem] font-bold text-[#3273dc] tracking-[0.09375em]">` + html.EscapeString(it.Name) + `</div>`)
 			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + fmt.Sprintf("%d", it.Count) + ` 篇</div>`)
 			b.WriteString(`</a>`)
@@ -539,35 +676,43 @@ func (s *server) seoCategoriesHandler(staticDir, siteTitle string) gin.HandlerFu
 		b.WriteString(`</div></section>`)
 
 		headExtras := seoHead(siteTitle, "分类", "分类列表", canonical, "website", "")
-		doc, err := getIndexTemplate(staticDir)
-		if err != nil {
-			c.Header("Content-Type", "text/html; charset=utf-8")
-			c.String(http.StatusOK, minimalHTML("分类", headExtras, b.String()))
-			return
-		}
-		doc = setTitle(doc, "分类")
-		doc = injectBeforeEndTag(doc, "</head>", headExtras)
-		doc = injectIntoAppRoot(doc, b.String())
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(http.StatusOK, doc)
+		s.writeSSR(c, spa, "分类", headExtras, b.String())
 	}
 }
 
-func (s *server) seoArchiveHandler(staticDir, siteTitle string) gin.HandlerFunc {
+func (s *server) seoArchiveHandler(spa fs.FS) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
 		ctx := c.Request.Context()
 		selected := strings.TrimSpace(c.Query("archive"))
-		base := requestBaseURL(c.Request)
-		canonical := base + "/archive"
+		page, ok := pageParam(c)
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		base := s.baseURL(c)
+		listURL := base + "/archive"
 		if selected != "" {
-			canonical += "?archive=" + urlQueryEscape(selected)
+			listURL += "?archive=" + urlQueryEscape(selected)
 		}
 
-		posts, err := s.queryPostsByArchive(ctx, selected, 200)
+		total, err := s.countPostsByArchive(ctx, selected, selected == "")
 		if err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
 		}
+		pages := totalPages(total, archivePageSize)
+		if page > pages {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		posts, err := s.queryPostsByArchive(ctx, selected, selected == "", page, archivePageSize)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		canonical := withPage(listURL, page)
+		prev, next := pageLinks(listURL, page, pages)
 
 		var b strings.Builder
 		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 text-center sm:px-9 md:px-12 lg:px-[10rem]">`)
@@ -577,35 +722,31 @@ func (s *server) seoArchiveHandler(staticDir, siteTitle string) gin.HandlerFunc
 		for _, it := range posts {
 			b.WriteString(`<div class="pb-6 space-y-1">`)
 			b.WriteString(`<div class="text-[1.4rem] font-bold tracking-[0.09375em]">`)
-			b.WriteString(`<a href="/post/` + urlPathEscape(it.Slug) + `" class="text-[#3273dc] no-underline">` + html.EscapeString(it.Title) + `</a>`)
+			b.WriteString(`<a href="` + s.basePath + `/post/` + urlPathEscape(it.Slug) + `" class="text-[#3273dc] no-underline">` + html.EscapeString(it.Title) + `</a>`)
 			b.WriteString(`</div>`)
-			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + html.EscapeString(it.CreatedAt.Format("2006-01-02 15:04")) + `</div>`)
+			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + html.EscapeString(s.formatSiteTime(it.CreatedAt)) + `</div>`)
 			b.WriteString(`</div>`)
 		}
+		b.WriteString(paginationNav(prev, next, page, pages))
 		b.WriteString(`</section>`)
 
 		title := "归档"
 		if selected != "" {
 			title = "归档 - " + selected
 		}
+		if page > 1 {
+			title += fmt.Sprintf(" (第 %d 页)", page)
+		}
 		headExtras := seoHead(siteTitle, title, "归档文章列表", canonical, "website", "")
+		headExtras += paginationHead(prev, next)
 
-		doc, err := getIndexTemplate(staticDir)
-		if err != nil {
-			c.Header("Content-Type", "text/html; charset=utf-8")
-			c.String(http.StatusOK, minimalHTML(title, headExtras, b.String()))
-			return
-		}
-		doc = setTitle(doc, title)
-		doc = injectBeforeEndTag(doc, "</head>", headExtras)
-		doc = injectIntoAppRoot(doc, b.String())
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(http.StatusOK, doc)
+		s.writeSSR(c, spa, title, headExtras, b.String())
 	}
 }
 
-func (s *server) seoCategoryHandler(staticDir, siteTitle string) gin.HandlerFunc {
+func (s *server) seoCategoryHandler(spa fs.FS) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
 		ctx := c.Request.Context()
 		name := strings.TrimSpace(c.Param("name"))
 		if name == "" {
@@ -614,46 +755,77 @@ func (s *server) seoCategoryHandler(staticDir, siteTitle string) gin.HandlerFunc
 		}
 
 		queryName := name
+		var descriptionMD string
 		if name == "未分类" {
 			queryName = ""
+		} else {
+			ar, ok, err := s.queryArchiveByName(ctx, name)
+			if err != nil {
+				c.Status(http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				descriptionMD = ar.Description
+			}
 		}
 
-		base := requestBaseURL(c.Request)
-		canonical := base + "/category/" + urlPathEscape(name)
+		page, ok := pageParam(c)
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		base := s.baseURL(c)
+		listURL := base + "/category/" + urlPathEscape(name)
 
-		posts, err := s.queryPostsByArchive(ctx, queryName, 200)
+		total, err := s.countPostsByArchive(ctx, queryName, false)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		pages := totalPages(total, archivePageSize)
+		if page > pages {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		posts, err := s.queryPostsByArchive(ctx, queryName, false, page, archivePageSize)
 		if err != nil {
 			c.Status(http.StatusInternalServerError)
 			return
 		}
+		canonical := withPage(listURL, page)
+		prev, next := pageLinks(listURL, page, pages)
 
 		var b strings.Builder
 		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 text-center sm:px-9 md:px-12 lg:px-[10rem]">`)
 		b.WriteString(`<div class="mb-4 inline-flex rounded-[3px] bg-[#3273dc] px-3 py-1 text-sm font-semibold text-white">` + html.EscapeString(name) + `</div>`)
+		if strings.TrimSpace(descriptionMD) != "" && page == 1 {
+			b.WriteString(`<div class="category-description mb-6 text-left text-[15px] leading-7 text-[#3d3d3f]">` + renderMarkdown(descriptionMD) + `</div>`)
+		}
 		for _, it := range posts {
 			b.WriteString(`<div class="pb-6 space-y-1">`)
 			b.WriteString(`<div class="text-[1.4rem] font-bold tracking-[0.09375em]">`)
-			b.WriteString(`<a href="/post/` + urlPathEscape(it.Slug) + `" class="text-[#3273dc] no-underline">` + html.EscapeString(it.Title) + `</a>`)
+			b.WriteString(`<a href="` + s.basePath + `/post/` + urlPathEscape(it.Slug) + `" class="text-[#3273dc] no-underline">` + html.EscapeString(it.Title) + `</a>`)
 			b.WriteString(`</div>`)
-			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + html.EscapeString(it.CreatedAt.Format("2006-01-02 15:04")) + `</div>`)
+			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + html.EscapeString(s.formatSiteTime(it.CreatedAt)) + `</div>`)
 			b.WriteString(`</div>`)
 		}
+		b.WriteString(paginationNav(prev, next, page, pages))
 		b.WriteString(`</section>`)
 
 		title := "分类 - " + name
-		headExtras := seoHead(siteTitle, title, "分类文章列表", canonical, "website", "")
-
-		doc, err := getIndexTemplate(staticDir)
-		if err != nil {
-			c.Header("Content-Type", "text/html; charset=utf-8")
-			c.String(http.StatusOK, minimalHTML(title, headExtras, b.String()))
-			return
+		description := "分类文章列表"
+		if plain := markdownPlainText(descriptionMD); plain != "" {
+			description = truncateRunes(plain, 180)
 		}
-		doc = setTitle(doc, title)
-		doc = injectBeforeEndTag(doc, "</head>", headExtras)
-		doc = injectIntoAppRoot(doc, b.String())
-		c.Header("Content-Type", "text/html; charset=utf-8")
-		c.String(http.StatusOK, doc)
+		if page > 1 {
+			title += fmt.Sprintf(" (第 %d 页)", page)
+		}
+		crumbs := breadcrumbList([]crumb{{Name: siteTitle, URL: base + "/"}, {Name: name, URL: listURL}})
+		headExtras := seoHead(siteTitle, title, description, canonical, "website", jsonLDGraph(crumbs))
+		headExtras += paginationHead(prev, next)
+		headExtras += rssAlternateLink(title, categoryFeedLink(base, name))
+
+		s.writeSSR(c, spa, title, headExtras, b.String())
 	}
 }
 
@@ -668,10 +840,10 @@ type sitemapURL struct {
 	LastMod string `xml:"lastmod,omitempty"`
 }
 
-func (s *server) seoSitemapHandler(siteTitle string) gin.HandlerFunc {
+func (s *server) seoSitemapHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
-		base := requestBaseURL(c.Request)
+		base := s.baseURL(c)
 
 		slugs, err := s.queryAllPublishedPostSlugs(ctx)
 		if err != nil {
@@ -684,14 +856,19 @@ func (s *server) seoSitemapHandler(siteTitle string) gin.HandlerFunc {
 			c.Status(http.StatusInternalServerError)
 			return
 		}
+		notes, err := s.queryPublishedNoteDates(ctx)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
 
+		rules := s.siteSettings().Sitemap
 		var urls []sitemapURL
 		urls = append(urls, sitemapURL{Loc: base + "/"})
 		urls = append(urls, sitemapURL{Loc: base + "/archive"})
 		urls = append(urls, sitemapURL{Loc: base + "/categories"})
-		_ = siteTitle
 		for _, it := range categories {
-			if strings.TrimSpace(it.Name) == "" {
+			if strings.TrimSpace(it.Name) == "" || rules.excludesCategory(it.Name) {
 				continue
 			}
 			urls = append(urls, sitemapURL{
@@ -699,12 +876,21 @@ func (s *server) seoSitemapHandler(siteTitle string) gin.HandlerFunc {
 			})
 		}
 		for _, it := range slugs {
-			if strings.TrimSpace(it.Slug) == "" {
+			if strings.TrimSpace(it.Slug) == "" || rules.excludes(it.Slug, it.Archive) {
 				continue
 			}
 			urls = append(urls, sitemapURL{
 				Loc:     base + "/post/" + url.PathEscape(it.Slug),
-				LastMod: it.Updated.Format(time.RFC3339),
+				LastMod: it.Updated.In(s.siteLocation()).Format(time.RFC3339),
+			})
+		}
+		if len(notes) > 0 {
+			urls = append(urls, sitemapURL{Loc: base + "/notes"})
+		}
+		for _, it := range notes {
+			urls = append(urls, sitemapURL{
+				Loc:     base + notePath(it.ID),
+				LastMod: it.Updated.In(s.siteLocation()).Format(time.RFC3339),
 			})
 		}
 
@@ -727,20 +913,52 @@ func (s *server) seoSitemapHandler(siteTitle string) gin.HandlerFunc {
 
 func (s *server) seoRobotsHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		base := requestBaseURL(c.Request)
-		lines := []string{
-			"User-agent: *",
-			"Allow: /",
-			"Disallow: /admin",
-			"Disallow: /api",
-			"Sitemap: " + base + "/sitemap.xml",
-			"",
-		}
+		base := s.baseURL(c)
+		body := buildRobots(s.basePath, base+"/sitemap.xml", s.siteSettings().Robots)
 		c.Header("Content-Type", "text/plain; charset=utf-8")
 		c.Header("Vary", "Host, X-Forwarded-Proto, X-Forwarded-Host")
 		c.Header("Cache-Control", "public, max-age=300")
-		c.String(http.StatusOK, strings.Join(lines, "\n"))
+		c.String(http.StatusOK, body)
+	}
+}
+
+// writeSSR renders body into the SPA shell (or a minimal document when the
+// frontend build is missing) and appends the site-wide custom snippets.
+func (s *server) writeSSR(c *gin.Context, spa fs.FS, title, headExtras, body string) {
+	s.writeSSRLang(c, spa, s.contentLang(""), title, headExtras, body)
+}
+
+// writeSSRLang is writeSSR for a page in a specific language; it sets
+// <html lang> and og:locale.
+func (s *server) writeSSRLang(c *gin.Context, spa fs.FS, lang, title, headExtras, body string) {
+	site := s.siteSettings()
+	headExtras += `<meta property="og:locale" content="` + html.EscapeString(ogLocale(lang)) + `">`
+	headExtras += s.themeHead(site.Theme, s.baseURL(c))
+	headExtras += site.CustomHead
+
+	doc, err := getIndexTemplate(spa)
+	if err != nil {
+		doc = minimalHTML(title, headExtras, body)
+	} else {
+		doc = rewriteBaseHref(doc, s.basePath)
+		doc = setTitle(doc, title)
+		doc = injectBeforeEndTag(doc, "</head>", headExtras)
+		doc = injectIntoAppRoot(doc, body)
 	}
+	doc = setHTMLLang(doc, lang)
+	if site.CustomFooter != "" {
+		doc = injectBeforeLastTag(doc, "</body>", site.CustomFooter)
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.String(http.StatusOK, doc)
+}
+
+func injectBeforeLastTag(doc, tag, injection string) string {
+	idx := strings.LastIndex(strings.ToLower(doc), tag)
+	if idx < 0 {
+		return doc + injection
+	}
+	return doc[:idx] + injection + doc[idx:]
 }
 
 func minimalHTML(title, headExtras, body string) string {
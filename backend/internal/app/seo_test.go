@@ -34,3 +34,30 @@ func TestSeoHead_JSONLDNotHTMLEscaped(t *testing.T) {
 		t.Fatalf("expected escaped closing tag sequence, got: %s", head)
 	}
 }
+
+func TestRewriteBaseHref(t *testing.T) {
+	doc := `<head><base href="/" /><title>x</title></head>`
+	if got := rewriteBaseHref(doc, ""); got != doc {
+		t.Fatalf("expected untouched doc without basePath, got: %s", got)
+	}
+	got := rewriteBaseHref(doc, "/blog")
+	if !strings.Contains(got, `<base href="/blog/">`) {
+		t.Fatalf("expected rewritten base href, got: %s", got)
+	}
+}
+
+func TestStoredExcerpt(t *testing.T) {
+	long := "<p>" + strings.Repeat("字", excerptStoreRunes+50) + "</p>"
+	stored := plainExcerpt(long)
+	if n := len([]rune(stored)); n != excerptStoreRunes {
+		t.Fatalf("stored excerpt has %d runes", n)
+	}
+	fromBody := excerptFromArticle(article{BodyHTML: long}, 180)
+	fromStored := excerptFromArticle(article{Excerpt: stored}, 180)
+	if fromBody != fromStored {
+		t.Fatalf("stored excerpt differs:\n%q\n%q", fromStored, fromBody)
+	}
+	if got := plainExcerpt("<p>a &amp;  <b>b</b></p>\n<p>c</p>"); got != "a & b c" {
+		t.Fatalf("plainExcerpt = %q", got)
+	}
+}
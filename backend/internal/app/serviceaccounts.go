@@ -0,0 +1,219 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roleService marks a non-interactive account, such as the one a CI job
+// uses to run the static export. It has no password, cannot sign in and
+// acts only through its API tokens; changes it makes are attributed to it
+// in the activity log. Service accounts are not listed as authors.
+const roleService = "service"
+
+// unusablePasswordHash is no bcrypt hash, so no password ever matches it.
+const unusablePasswordHash = "!"
+
+// account is a user as the admin users API shows it. Tokens is only filled
+// for service accounts.
+type account struct {
+	ID        string     `json:"id"`
+	Username  string     `json:"username"`
+	Role      string     `json:"role"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Tokens    []apiToken `json:"tokens,omitempty"`
+}
+
+// listUsers returns every account, service accounts with their tokens.
+func (s *server) listUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+	rows, err := s.db.QueryContext(ctx, `SELECT id, username, role, created_at FROM users ORDER BY username`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryUsersFailed)
+		return
+	}
+	defer rows.Close()
+	items := []account{}
+	byID := map[string]int{}
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.ID, &a.Username, &a.Role, &a.CreatedAt); err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryUsersFailed)
+			return
+		}
+		if a.Role == roleService {
+			a.Tokens = []apiToken{}
+		}
+		byID[a.ID] = len(items)
+		items = append(items, a)
+	}
+	if err := rows.Err(); err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryUsersFailed)
+		return
+	}
+
+	tokens, err := s.db.QueryContext(ctx, `
+		SELECT `+tokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE u.role = $1 ORDER BY t.created_at DESC`, roleService)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryUsersFailed)
+		return
+	}
+	defer tokens.Close()
+	for tokens.Next() {
+		t, err := scanAPIToken(tokens)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryUsersFailed)
+			return
+		}
+		if i, ok := byID[t.UserID]; ok {
+			items[i].Tokens = append(items[i].Tokens, t)
+		}
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// serviceAccountPayload names the account and its first token, whose name
+// defaults to the account's.
+type serviceAccountPayload struct {
+	Username string `json:"username"`
+	tokenPayload
+}
+
+func (p *serviceAccountPayload) normalize() error {
+	var v validator
+	p.Username = strings.TrimSpace(p.Username)
+	if v.check(p.Username != "", "username", errNameRequired) {
+		v.check(maxRunes(p.Username, maxUsernameRunes), "username", errUsernameTooLong)
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		p.Name = p.Username
+	}
+	if err := p.tokenPayload.normalize(); err != nil {
+		return errors.Join(v.err(), err)
+	}
+	return v.err()
+}
+
+// createServiceAccount adds a service account together with a token and
+// returns the token secret, which cannot be read back later.
+func (s *server) createServiceAccount(c *gin.Context) {
+	var payload serviceAccountPayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	ctx := c.Request.Context()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveUserFailed, err)
+		return
+	}
+	defer tx.Rollback()
+	var a account
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3)
+		RETURNING id, username, role, created_at`, payload.Username, unusablePasswordHash, roleService).
+		Scan(&a.ID, &a.Username, &a.Role, &a.CreatedAt)
+	if isUniqueViolation(err) {
+		respondError(c, http.StatusConflict, errUsernameTaken)
+		return
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveUserFailed, err)
+		return
+	}
+	t, secret, err := issueToken(ctx, tx, a.ID, payload.tokenPayload)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTokenFailed, err)
+		return
+	}
+	a.Tokens = []apiToken{t}
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "account": a})
+}
+
+// serviceAccountID resolves :id to a service account, writing the error
+// response otherwise.
+func (s *server) serviceAccountID(c *gin.Context) (string, bool) {
+	id, ok := idParam(c, "id", errUserNotFound)
+	if !ok {
+		return "", false
+	}
+	var role string
+	err := s.db.QueryRowContext(c.Request.Context(), `SELECT id, role FROM users WHERE id=$1`, id).Scan(&id, &role)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondError(c, http.StatusNotFound, errUserNotFound)
+		return "", false
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errQueryUsersFailed)
+		return "", false
+	case role != roleService:
+		respondError(c, http.StatusBadRequest, errNotServiceAccount)
+		return "", false
+	}
+	return id, true
+}
+
+// revokeServiceAccount drops every token of a service account; the account
+// stays so its history keeps a name and a new token can be issued.
+func (s *server) revokeServiceAccount(c *gin.Context) {
+	id, ok := s.serviceAccountID(c)
+	if !ok {
+		return
+	}
+	if _, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM api_tokens WHERE user_id=$1`, id); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTokenFailed, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// issueServiceToken adds another token to a service account, e.g. to rotate
+// the one a pipeline uses before revoking the old one.
+func (s *server) issueServiceToken(c *gin.Context) {
+	id, ok := s.serviceAccountID(c)
+	if !ok {
+		return
+	}
+	var payload tokenPayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	t, secret, err := issueToken(c.Request.Context(), s.db, id, payload)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTokenFailed, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "info": t})
+}
+
+// deleteServiceAccount removes a service account and its tokens.
+func (s *server) deleteServiceAccount(c *gin.Context) {
+	id, ok := s.serviceAccountID(c)
+	if !ok {
+		return
+	}
+	if _, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM users WHERE id=$1`, id); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveUserFailed, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServiceAccountPayloadNormalize(t *testing.T) {
+	p := serviceAccountPayload{Username: " ci-export ", tokenPayload: tokenPayload{Scopes: []string{scopeArticlesRead}}}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Username != "ci-export" || p.Name != "ci-export" {
+		t.Fatalf("normalized = %+v", p)
+	}
+	bad := serviceAccountPayload{tokenPayload: tokenPayload{Scopes: []string{scopeArticlesRead}}}
+	if err := bad.normalize(); err == nil {
+		t.Fatal("missing username accepted")
+	}
+}
+
+func TestPublishFromAttributesActor(t *testing.T) {
+	s := &server{events: newEventBus()}
+	var got changeEvent
+	s.events.subscribe("test", func(ev changeEvent) { got = ev })
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(string(userContextKey), user{Username: "ci-export", Role: roleService})
+	s.publishFrom(c, eventArticleChanged, actionUpdated, "1", "hello")
+	if got.Actor != "ci-export" || got.Slug != "hello" {
+		t.Fatalf("event = %+v", got)
+	}
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionTokenBytes = 32
+	// sessionTouchInterval keeps sliding expiry from writing on every
+	// request: a session is only extended once it is this much older than
+	// its last refresh.
+	sessionTouchInterval   = time.Hour
+	sessionCleanupInterval = time.Hour
+
+	defaultSessionTTL    = 12 * time.Hour
+	defaultSessionMaxAge = 7 * 24 * time.Hour
+	defaultRememberTTL   = 90 * 24 * time.Hour
+)
+
+// sessionConfig sets how long logins last, as Go durations. A normal login
+// expires after TTL without activity and MaxAge after login at the latest;
+// its cookie ends with the browser. A "remember me" login gets a persistent
+// cookie and lasts RememberTTL.
+type sessionConfig struct {
+	TTL         string `yaml:"ttl"`
+	MaxAge      string `yaml:"maxAge"`
+	RememberTTL string `yaml:"rememberTTL"`
+}
+
+type sessionPolicy struct {
+	ttl, maxAge, rememberTTL time.Duration
+}
+
+func (sc sessionConfig) policy() (sessionPolicy, error) {
+	p := sessionPolicy{defaultSessionTTL, defaultSessionMaxAge, defaultRememberTTL}
+	for _, f := range []struct {
+		name, raw string
+		into      *time.Duration
+	}{
+		{"session.ttl", sc.TTL, &p.ttl},
+		{"session.maxAge", sc.MaxAge, &p.maxAge},
+		{"session.rememberTTL", sc.RememberTTL, &p.rememberTTL},
+	} {
+		if f.raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.raw)
+		if err != nil || d < time.Minute {
+			return p, fmt.Errorf("%s 无效: %s", f.name, f.raw)
+		}
+		*f.into = d
+	}
+	if p.maxAge < p.ttl {
+		return p, fmt.Errorf("session.maxAge (%s) 不能短于 session.ttl (%s)", p.maxAge, p.ttl)
+	}
+	return p, nil
+}
+
+// lifetime returns the idle timeout and absolute cap for a new session. The
+// zero policy (servers built without a config) uses the defaults.
+func (p sessionPolicy) lifetime(remember bool) (ttl, maxAge time.Duration) {
+	if p == (sessionPolicy{}) {
+		p = sessionPolicy{defaultSessionTTL, defaultSessionMaxAge, defaultRememberTTL}
+	}
+	if remember {
+		return p.rememberTTL, p.rememberTTL
+	}
+	return p.ttl, p.maxAge
+}
+
+// newSessionToken returns a random cookie value. Only its hash is stored, so
+// a leaked sessions table cannot be replayed.
+func newSessionToken() (string, error) {
+	raw := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// refreshSession slides the expiry of an active session and re-issues the
+// cookie to match. Failures only mean the session ends a little sooner.
+func (s *server) refreshSession(c *gin.Context, token string, swu *sessionWithUser) {
+	ttl, _ := s.sessions.lifetime(swu.Remember)
+	if time.Until(swu.Expires) > ttl-min(sessionTouchInterval, ttl/10) {
+		return
+	}
+	expires, err := s.users.TouchSession(c.Request.Context(), swu.SessionID, ttl)
+	if err != nil {
+		warnf("续期会话失败: %v\n", err)
+		return
+	}
+	if expires.After(swu.Expires) {
+		swu.Expires = expires
+		if swu.Remember {
+			s.setSessionCookie(c, swu, token)
+		}
+	}
+}
+
+func (s *server) runSessionCleanup() {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		n, err := s.users.DeleteExpiredSessions(ctx)
+		cancel()
+		if err != nil {
+			warnf("清理过期会话失败: %v\n", err)
+		} else if n > 0 {
+			infof("已清理 %d 个过期会话\n", n)
+		}
+		<-ticker.C
+	}
+}
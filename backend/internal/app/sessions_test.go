@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"selfecho/backend/internal/store"
+)
+
+func TestSessionTokensAreHashedAndSlide(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := &fakeUsers{users: map[string]store.User{}, sessions: map[string]store.Session{}}
+	s := &server{users: users, proxies: &proxyTrust{}}
+	if err := s.createUser(context.Background(), "admin", "pw", ""); err != nil {
+		t.Fatal(err)
+	}
+	swu, err := s.createSession(context.Background(), "u-admin", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(swu.Token) < 40 || swu.Token == swu.SessionID {
+		t.Fatalf("token %q should be random and not the session id", swu.Token)
+	}
+	if _, ok := users.sessions[swu.Token]; ok {
+		t.Fatal("the raw token must not be stored")
+	}
+	if _, ok := users.sessions[hashSessionToken(swu.Token)]; !ok {
+		t.Fatal("session should be stored under the token hash")
+	}
+
+	// pretend the last refresh was two hours ago
+	key := hashSessionToken(swu.Token)
+	ss := users.sessions[key]
+	ss.Expires = time.Now().Add(defaultSessionTTL - 2*time.Hour)
+	users.sessions[key] = ss
+
+	r := gin.New()
+	r.GET("/me", s.me)
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: swu.Token})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("me: got %d", w.Code)
+	}
+	if got := users.sessions[key].Expires; time.Until(got) < defaultSessionTTL-time.Minute {
+		t.Fatalf("expiry not extended: %v", got)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("a browser-session cookie needs no re-issue")
+	}
+
+	// the absolute cap wins over activity
+	ss = users.sessions[key]
+	ss.AbsoluteExpires = time.Now().Add(time.Hour)
+	ss.Expires = ss.AbsoluteExpires
+	users.sessions[key] = ss
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := users.sessions[key].Expires; got.After(ss.AbsoluteExpires) {
+		t.Fatalf("expiry %v past the absolute cap %v", got, ss.AbsoluteExpires)
+	}
+}
+
+func TestRememberMeCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := &fakeUsers{users: map[string]store.User{}, sessions: map[string]store.Session{}}
+	s := &server{users: users, proxies: &proxyTrust{}}
+	if err := s.createUser(context.Background(), "admin", "pw", ""); err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/login", s.login)
+	login := func(body string) *http.Cookie {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+		for _, ck := range w.Result().Cookies() {
+			if ck.Name == sessionCookieName {
+				return ck
+			}
+		}
+		t.Fatalf("login %s: no cookie (%d)", body, w.Code)
+		return nil
+	}
+	if ck := login(`{"username":"admin","password":"pw"}`); ck.MaxAge != 0 || !ck.Expires.IsZero() {
+		t.Fatalf("normal login should set a browser-session cookie, got max-age %d", ck.MaxAge)
+	}
+	ck := login(`{"username":"admin","password":"pw","remember":true}`)
+	if want := int(defaultRememberTTL.Seconds()); ck.MaxAge < want-60 || ck.MaxAge > want {
+		t.Fatalf("remember me max-age = %d, want about %d", ck.MaxAge, want)
+	}
+}
+
+func TestSessionPolicy(t *testing.T) {
+	p, err := sessionConfig{TTL: "2h", RememberTTL: "720h"}.policy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl, maxAge := p.lifetime(false); ttl != 2*time.Hour || maxAge != defaultSessionMaxAge {
+		t.Fatalf("lifetime(false) = %v, %v", ttl, maxAge)
+	}
+	if ttl, maxAge := p.lifetime(true); ttl != 720*time.Hour || maxAge != 720*time.Hour {
+		t.Fatalf("lifetime(true) = %v, %v", ttl, maxAge)
+	}
+	for _, bad := range []sessionConfig{{TTL: "soon"}, {TTL: "10s"}, {TTL: "48h", MaxAge: "24h"}} {
+		if _, err := bad.policy(); err == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const siteSettingsKey = "site"
+
+type socialLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type siteSettings struct {
+	Title        string        `json:"title"`
+	Subtitle     string        `json:"subtitle"`
+	Description  string        `json:"description"`
+	Author       string        `json:"author"`
+	FooterText   string        `json:"footerText"`
+	SocialLinks  []socialLink  `json:"socialLinks"`
+	PostsPerPage int           `json:"postsPerPage"`
+	Timezone     string        `json:"timezone"`
+	Language     string        `json:"language"`
+	CustomHead   string        `json:"customHead,omitempty"`
+	CustomFooter string        `json:"customFooter,omitempty"`
+	Robots       robotsRules   `json:"robots"`
+	Sitemap      sitemapRules  `json:"sitemap"`
+	Publisher    publisherLD   `json:"publisher"`
+	Theme        themeSettings `json:"theme"`
+}
+
+const maxCustomSnippetBytes = 64 << 10
+
+// public strips admin-only fields before the settings are exposed via /api/site.
+func (st siteSettings) public() siteSettings {
+	st.CustomHead = ""
+	st.CustomFooter = ""
+	st.Robots = robotsRules{}
+	st.Sitemap = sitemapRules{}
+	return st
+}
+
+func defaultSiteSettings(site siteConfig) siteSettings {
+	title := strings.TrimSpace(site.Title)
+	if title == "" {
+		title = defaultConfig().Site.Title
+	}
+	return siteSettings{
+		Title:        title,
+		SocialLinks:  []socialLink{},
+		PostsPerPage: 6,
+		Timezone:     "Local",
+		Language:     defaultSiteLanguage,
+	}
+}
+
+func (st *siteSettings) normalize() error {
+	var v validator
+	st.Title = strings.TrimSpace(st.Title)
+	st.Subtitle = strings.TrimSpace(st.Subtitle)
+	st.Description = strings.TrimSpace(st.Description)
+	st.Author = strings.TrimSpace(st.Author)
+	st.Timezone = strings.TrimSpace(st.Timezone)
+	if v.check(st.Title != "", "title", errSiteTitleRequired) {
+		v.check(maxRunes(st.Title, maxTitleRunes), "title", errTitleTooLong)
+	}
+	v.check(st.PostsPerPage > 0 && st.PostsPerPage <= 100, "postsPerPage", errInvalidPostsPerPage)
+	if st.Timezone == "" {
+		st.Timezone = "Local"
+	}
+	if _, err := time.LoadLocation(st.Timezone); err != nil {
+		v.add("timezone", errInvalidTimezone, st.Timezone)
+	}
+	if lang, ok := normalizeLang(st.Language); v.check(ok, "language", errInvalidLanguage, st.Language) {
+		if lang == "" {
+			lang = defaultSiteLanguage
+		}
+		st.Language = lang
+	}
+	v.check(len(st.CustomHead) <= maxCustomSnippetBytes, "customHead", errSnippetTooLong)
+	v.check(len(st.CustomFooter) <= maxCustomSnippetBytes, "customFooter", errSnippetTooLong)
+	v.wrap("robots", st.Robots.normalize())
+	st.Sitemap.normalize()
+	v.wrap("publisher", st.Publisher.normalize())
+	v.wrap("theme", st.Theme.normalize())
+	if st.SocialLinks == nil {
+		st.SocialLinks = []socialLink{}
+	}
+	for i := range st.SocialLinks {
+		link := &st.SocialLinks[i]
+		field := fmt.Sprintf("socialLinks[%d]", i)
+		link.Name = strings.TrimSpace(link.Name)
+		link.URL = strings.TrimSpace(link.URL)
+		if link.Name == "" || link.URL == "" {
+			v.add(field, errSocialLinkRequired)
+			continue
+		}
+		u, err := url.Parse(link.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto") {
+			v.add(field+".url", errInvalidSocialLink, link.URL)
+		}
+	}
+	return v.err()
+}
+
+type settingsCache struct {
+	mu   sync.RWMutex
+	site siteSettings
+	loc  *time.Location
+}
+
+func newSettingsCache(defaults siteSettings) *settingsCache {
+	return &settingsCache{site: defaults, loc: loadSiteLocation(defaults.Timezone)}
+}
+
+func (c *settingsCache) get() siteSettings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st := c.site
+	st.SocialLinks = append([]socialLink{}, c.site.SocialLinks...)
+	st.Robots.Disallow = append([]string(nil), c.site.Robots.Disallow...)
+	st.Robots.BlockAgents = append([]string(nil), c.site.Robots.BlockAgents...)
+	st.Sitemap.ExcludeSlugs = append([]string(nil), c.site.Sitemap.ExcludeSlugs...)
+	st.Sitemap.ExcludeCategories = append([]string(nil), c.site.Sitemap.ExcludeCategories...)
+	st.Publisher.SameAs = append([]string(nil), c.site.Publisher.SameAs...)
+	return st
+}
+
+func (c *settingsCache) set(st siteSettings) {
+	loc := loadSiteLocation(st.Timezone)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.site = st
+	c.loc = loc
+}
+
+func (c *settingsCache) location() *time.Location {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loc
+}
+
+func (s *server) siteSettings() siteSettings {
+	return s.settings.get()
+}
+
+func loadSiteLocation(name string) *time.Location {
+	if name == "" || name == "Local" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// siteLocation is the configured site timezone used for every rendered date.
+func (s *server) siteLocation() *time.Location {
+	return s.settings.location()
+}
+
+// siteTimezoneSQL returns the IANA zone name for AT TIME ZONE clauses, or ""
+// when the site follows the server's local zone.
+func (s *server) siteTimezoneSQL() string {
+	tz := s.siteSettings().Timezone
+	if tz == "Local" {
+		return ""
+	}
+	return tz
+}
+
+func (s *server) formatSiteTime(t time.Time) string {
+	return t.In(s.siteLocation()).Format("2006-01-02 15:04")
+}
+
+func (s *server) ensureSettingsSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value JSONB NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
+
+// loadSettings reads the stored site settings on top of the config defaults and
+// seeds the row on first start so later edits always go through the table.
+func (s *server) loadSettings(ctx context.Context) error {
+	st := s.settings.get()
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key=$1`, siteSettingsKey).Scan(&raw)
+	if err != nil {
+		if !errorsIsNotFound(err) {
+			return err
+		}
+		return s.saveSettings(ctx, st)
+	}
+	if err := json.Unmarshal(raw, &st); err != nil {
+		return fmt.Errorf("解析站点设置失败: %w", err)
+	}
+	if err := st.normalize(); err != nil {
+		warnf("站点设置无效，使用默认值: %v\n", err)
+		return nil
+	}
+	s.settings.set(st)
+	return nil
+}
+
+func (s *server) saveSettings(ctx context.Context, st siteSettings) error {
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO settings (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value=EXCLUDED.value, updated_at=now()`, siteSettingsKey, raw)
+	if err != nil {
+		return err
+	}
+	s.settings.set(st)
+	return nil
+}
+
+func (s *server) getSite(c *gin.Context) {
+	c.JSON(http.StatusOK, s.siteSettings().public())
+}
+
+func (s *server) getSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, s.siteSettings())
+}
+
+func (s *server) updateSettings(c *gin.Context) {
+	st := s.siteSettings()
+	if err := c.BindJSON(&st); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := st.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	if err := s.saveSettings(c.Request.Context(), st); err != nil {
+		respondError(c, http.StatusInternalServerError, errSaveSettingsFailed)
+		return
+	}
+	s.publishFrom(c, eventSettingsChanged, actionUpdated, siteSettingsKey, "")
+	c.JSON(http.StatusOK, st)
+}
@@ -0,0 +1,25 @@
+package app
+
+import "testing"
+
+func TestSiteSettingsNormalize(t *testing.T) {
+	st := defaultSiteSettings(siteConfig{Title: "  Demo  "})
+	if err := st.normalize(); err != nil {
+		t.Fatalf("defaults should be valid: %v", err)
+	}
+	if st.Title != "Demo" {
+		t.Fatalf("expected trimmed title, got %q", st.Title)
+	}
+
+	bad := st
+	bad.Timezone = "Mars/Olympus"
+	if err := bad.normalize(); err == nil {
+		t.Fatalf("expected invalid timezone to be rejected")
+	}
+
+	bad = st
+	bad.SocialLinks = []socialLink{{Name: "x", URL: "javascript:alert(1)"}}
+	if err := bad.normalize(); err == nil {
+		t.Fatalf("expected non-http social link to be rejected")
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+
+	"selfecho/backend/internal/slugmigrate"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slugConfig picks who proposes slugs in the editor: one of the llm providers
+// ("deepseek" by default, "openai", "ollama"), falling back to
+// transliteration on failure, or "none" for transliteration only.
+type slugConfig struct {
+	Provider string `yaml:"provider"`
+}
+
+// llmConfig holds per-provider settings (llm.openai, llm.ollama, ...); the
+// top-level deepseek block still configures DeepSeek.
+type llmConfig map[string]slugmigrate.ProviderConfig
+
+func (cfg slugConfig) provider() string {
+	p := strings.ToLower(strings.TrimSpace(cfg.Provider))
+	if p == "" {
+		return "deepseek"
+	}
+	return p
+}
+
+// newSlugProvider builds the editor's slug provider, or nil when disabled or
+// misconfigured (suggestions then always transliterate).
+func newSlugProvider(cfg config, httpClient *http.Client) slugmigrate.Provider {
+	name := cfg.Slug.provider()
+	if name == "none" {
+		return nil
+	}
+	providerCfg := slugmigrate.ResolveProviderConfig(name, cfg.LLM, slugmigrate.ProviderConfig{
+		BaseURL: cfg.Deepseek.BaseURL,
+		Model:   cfg.Deepseek.Model,
+		APIKey:  cfg.Deepseek.APIKey,
+	})
+	p, err := slugmigrate.NewProvider(name, providerCfg, httpClient)
+	if err != nil {
+		warnf("slug 建议将只使用音译: %v\n", err)
+		return nil
+	}
+	return p
+}
+
+type slugSuggestion struct {
+	Slug           string `json:"slug"`
+	Source         string `json:"source"`
+	Provider       string `json:"provider,omitempty"`
+	Deduped        bool   `json:"deduped"`
+	FallbackReason string `json:"fallbackReason,omitempty"`
+}
+
+// suggestSlug backs POST /api/slug/suggest: one-click English slugs at write
+// time. id is the article being edited so its own slug doesn't count as taken.
+func (s *server) suggestSlug(c *gin.Context) {
+	var payload struct {
+		Title string `json:"title"`
+		ID    string `json:"id"`
+	}
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	title := strings.TrimSpace(payload.Title)
+	if title == "" {
+		respondError(c, http.StatusBadRequest, errTitleRequired)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var out slugSuggestion
+	var candidate string
+	if s.slugLLM != nil {
+		var err error
+		candidate, err = s.slugLLM.GenerateSlug(ctx, title)
+		if err == nil {
+			out.Source = "llm"
+			out.Provider = s.slugLLM.Name()
+		} else {
+			out.FallbackReason = err.Error()
+		}
+	} else {
+		out.FallbackReason = localizeError(requestLanguage(c), errLLMNotConfigured, "")
+	}
+	if candidate == "" {
+		candidate = slugmigrate.Transliterate(title)
+		out.Source = "transliteration"
+	}
+	if candidate == "" {
+		respondError(c, http.StatusBadRequest, errSlugGenerationFailed)
+		return
+	}
+
+	unique, err := s.ensureUniqueSlug(ctx, candidate, strings.TrimSpace(payload.ID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
+		return
+	}
+	out.Slug = unique
+	out.Deduped = unique != candidate
+	c.JSON(http.StatusOK, out)
+}
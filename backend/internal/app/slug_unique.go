@@ -18,12 +18,17 @@ func isUniqueViolation(err error) bool {
 // ensureUniqueSlug returns baseSlug if it's free; otherwise returns baseSlug-<n>.
 // It ignores the row with ignoreID (used for updates).
 func (s *server) ensureUniqueSlug(ctx context.Context, baseSlug string, ignoreID string) (string, error) {
+	return uniqueSlug(ctx, s.db, baseSlug, ignoreID)
+}
+
+// uniqueSlug is ensureUniqueSlug on q, so a transaction sees its own inserts.
+func uniqueSlug(ctx context.Context, q sqlQuerier, baseSlug string, ignoreID string) (string, error) {
 	baseSlug = strings.TrimSpace(baseSlug)
 	if baseSlug == "" {
 		return "", errors.New("slug 为空")
 	}
 
-	rows, err := s.db.QueryContext(ctx, `
+	rows, err := q.QueryContext(ctx, `
 		SELECT id, slug
 		FROM articles
 		WHERE slug = $1 OR slug LIKE $2`, baseSlug, baseSlug+"-%")
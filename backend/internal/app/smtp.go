@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpConfig is the outgoing mail server, used to forward mail matched by
+// IMAP rules. Security is "starttls" (the default), "tls" for implicit TLS,
+// or "none".
+type smtpConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	Security string `yaml:"security"`
+}
+
+func (c smtpConfig) enabled() bool {
+	return c.Host != ""
+}
+
+var errSMTPDisabled = errors.New("未配置 smtp")
+
+func validateSMTP(c smtpConfig) error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("smtp.port 无效: %d", c.Port)
+	}
+	switch c.Security {
+	case "", "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("smtp.security 需为 starttls、tls 或 none: %q", c.Security)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("smtp.from 无效: %v", err)
+	}
+	return nil
+}
+
+func (c smtpConfig) port() int {
+	switch {
+	case c.Port != 0:
+		return c.Port
+	case c.Security == "tls":
+		return 465
+	}
+	return 587
+}
+
+// outgoingMail is a plain text message with an optional HTML alternative.
+type outgoingMail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// render builds the RFC 5322 message for m.
+func (c smtpConfig) render(m outgoingMail, now time.Time) []byte {
+	var b bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+	header("From", c.From)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	text := strings.ReplaceAll(m.Text, "\n", "\r\n")
+	if m.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		b.WriteString("\r\n" + text + "\r\n")
+		return b.Bytes()
+	}
+	boundary := "selfecho-" + strconv.FormatInt(now.UnixNano(), 36)
+	header("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	fmt.Fprintf(&b, "\r\n--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", boundary, text)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", boundary, m.HTML)
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+// sendMail delivers m through the configured server.
+func (c smtpConfig) sendMail(ctx context.Context, m outgoingMail) error {
+	if !c.enabled() {
+		return errSMTPDisabled
+	}
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.port()))
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	if c.Security == "tls" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: c.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if c.Security == "" || c.Security == "starttls" {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(c.render(m, time.Now())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
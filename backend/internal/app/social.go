@@ -0,0 +1,112 @@
+package app
+
+import (
+	"encoding/json"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxSocialTitleRunes = 200
+	maxSocialDescRunes  = 300
+)
+
+// socialCard overrides what link previews show for a post. Empty fields fall
+// back to the article title, meta description and site defaults.
+type socialCard struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type is the og:type; "article" when empty.
+	Type string `json:"type,omitempty"`
+	// Card is the twitter:card style, "summary" or "summary_large_image".
+	Card string `json:"card,omitempty"`
+}
+
+var socialOGTypes = map[string]bool{"article": true, "website": true, "profile": true, "book": true}
+
+var twitterCards = map[string]bool{"summary": true, "summary_large_image": true}
+
+func (sc *socialCard) normalize() error {
+	sc.Title = collapseWhitespace(sc.Title)
+	sc.Description = collapseWhitespace(sc.Description)
+	sc.Type = strings.ToLower(strings.TrimSpace(sc.Type))
+	sc.Card = strings.ToLower(strings.TrimSpace(sc.Card))
+	if utf8.RuneCountInString(sc.Title) > maxSocialTitleRunes || utf8.RuneCountInString(sc.Description) > maxSocialDescRunes {
+		return newAPIError(errInvalidSocialCard, "too long")
+	}
+	if sc.Type != "" && !socialOGTypes[sc.Type] {
+		return newAPIError(errInvalidSocialCard, sc.Type)
+	}
+	if sc.Card != "" && !twitterCards[sc.Card] {
+		return newAPIError(errInvalidSocialCard, sc.Card)
+	}
+	return nil
+}
+
+func (sc socialCard) empty() bool {
+	return sc == socialCard{}
+}
+
+// parseSocialCard decodes the stored JSONB column; an empty object yields nil
+// so the API omits the field.
+func parseSocialCard(raw []byte) *socialCard {
+	var sc socialCard
+	if len(raw) == 0 || json.Unmarshal(raw, &sc) != nil || sc.empty() {
+		return nil
+	}
+	return &sc
+}
+
+// socialHead renders the Open Graph / Twitter tags for a page, applying card
+// overrides on top of the page defaults.
+func socialHead(siteTitle, fullTitle, description, canonical, ogType string, card socialCard) string {
+	if card.Title != "" {
+		fullTitle = card.Title
+	}
+	if card.Description != "" {
+		description = card.Description
+	}
+	if card.Type != "" {
+		ogType = card.Type
+	}
+	if ogType == "" {
+		ogType = "website"
+	}
+	twitter := card.Card
+	if twitter == "" {
+		twitter = "summary"
+	}
+	var b strings.Builder
+	b.WriteString(`<meta property="og:title" content="` + html.EscapeString(fullTitle) + `">`)
+	b.WriteString(`<meta property="og:description" content="` + html.EscapeString(description) + `">`)
+	b.WriteString(`<meta property="og:url" content="` + html.EscapeString(canonical) + `">`)
+	if siteTitle != "" {
+		b.WriteString(`<meta property="og:site_name" content="` + html.EscapeString(siteTitle) + `">`)
+	}
+	b.WriteString(`<meta property="og:type" content="` + html.EscapeString(ogType) + `">`)
+	b.WriteString(`<meta name="twitter:card" content="` + html.EscapeString(twitter) + `">`)
+	if card.Title != "" {
+		b.WriteString(`<meta name="twitter:title" content="` + html.EscapeString(card.Title) + `">`)
+	}
+	if card.Description != "" {
+		b.WriteString(`<meta name="twitter:description" content="` + html.EscapeString(card.Description) + `">`)
+	}
+	return b.String()
+}
+
+// articleMeta renders the article:* Open Graph properties for a post.
+func articleMeta(a article, loc *time.Location) string {
+	published := articleFeedDate(a)
+	var b strings.Builder
+	b.WriteString(`<meta property="article:published_time" content="` + published.In(loc).Format(time.RFC3339) + `">`)
+	b.WriteString(`<meta property="article:modified_time" content="` + a.UpdatedAt.In(loc).Format(time.RFC3339) + `">`)
+	if section := strings.TrimSpace(a.Archive); section != "" {
+		b.WriteString(`<meta property="article:section" content="` + html.EscapeString(section) + `">`)
+	}
+	for _, tag := range a.Tags {
+		b.WriteString(`<meta property="article:tag" content="` + html.EscapeString(tag) + `">`)
+	}
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocialCardNormalize(t *testing.T) {
+	sc := socialCard{Title: "  Hi  there ", Type: "Article", Card: "SUMMARY_LARGE_IMAGE"}
+	if err := sc.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Title != "Hi there" || sc.Type != "article" || sc.Card != "summary_large_image" {
+		t.Fatalf("unexpected card %+v", sc)
+	}
+	for _, bad := range []socialCard{{Type: "music.song"}, {Card: "player"}, {Title: strings.Repeat("长", maxSocialTitleRunes+1)}} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+	if parseSocialCard([]byte(`{}`)) != nil {
+		t.Error("empty card should parse to nil")
+	}
+}
+
+func TestSeoHeadCardOverrides(t *testing.T) {
+	head := seoHeadCard("Site", "Post", "desc", "https://x/post/p", "article", "", socialCard{Title: "Share me", Card: "summary_large_image"})
+	for _, want := range []string{
+		`<meta property="og:title" content="Share me">`,
+		`<meta property="og:description" content="desc">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+		`<meta name="twitter:title" content="Share me">`,
+	} {
+		if !strings.Contains(head, want) {
+			t.Errorf("missing %s in %s", want, head)
+		}
+	}
+}
+
+func TestArticleMeta(t *testing.T) {
+	pub := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	a := article{Archive: "Go", Tags: tagList{"web"}, PublishedAt: &pub, UpdatedAt: pub.Add(time.Hour)}
+	got := articleMeta(a, time.UTC)
+	for _, want := range []string{
+		`article:published_time" content="2024-05-01T08:00:00Z"`,
+		`article:modified_time" content="2024-05-01T09:00:00Z"`,
+		`article:section" content="Go"`,
+		`article:tag" content="web"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+}
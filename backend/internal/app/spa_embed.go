@@ -0,0 +1,25 @@
+//go:build embedspa
+
+package app
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The release build copies frontend/dist/selfecho-frontend into
+// internal/app/spa before running `go build -tags embedspa`.
+//
+//go:embed all:spa
+var embeddedSPAFiles embed.FS
+
+func embeddedSPA() fs.FS {
+	sub, err := fs.Sub(embeddedSPAFiles, "spa")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(sub, "index.html"); err != nil {
+		return nil
+	}
+	return sub
+}
@@ -0,0 +1,10 @@
+//go:build !embedspa
+
+package app
+
+import "io/fs"
+
+// embeddedSPA is nil unless the binary is built with -tags embedspa.
+func embeddedSPA() fs.FS {
+	return nil
+}
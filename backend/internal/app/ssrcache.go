@@ -0,0 +1,213 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSSRCacheTTLSeconds = 60
+	maxSSRCacheEntries        = 2000
+)
+
+// ssrCacheTTL reads ssrCacheTTLSeconds: unset uses the default and a negative
+// value turns the cache off.
+func ssrCacheTTL(cfg config) time.Duration {
+	switch {
+	case cfg.SSRCacheTTL < 0:
+		return 0
+	case cfg.SSRCacheTTL == 0:
+		return defaultSSRCacheTTLSeconds * time.Second
+	}
+	return time.Duration(cfg.SSRCacheTTL) * time.Second
+}
+
+type ssrEntry struct {
+	body     []byte
+	header   http.Header
+	cachedAt time.Time
+}
+
+// ssrCache keeps rendered SEO pages (home, posts, archives, feeds, sitemap)
+// so crawler bursts don't re-run their queries. Entries are keyed by origin
+// and request URI and dropped wholesale on any content change.
+type ssrCache struct {
+	mu       sync.RWMutex
+	data     map[string]ssrEntry
+	inflight map[string]chan struct{}
+	ttl      time.Duration
+	hits     int64
+	misses   int64
+}
+
+func newSSRCache(ttl time.Duration) *ssrCache {
+	return &ssrCache{data: make(map[string]ssrEntry), inflight: make(map[string]chan struct{}), ttl: ttl}
+}
+
+// claim registers the caller as the renderer for key. When another request
+// is already rendering it, claim returns that render's done channel instead,
+// so a cold cache under a crawler burst runs each page's queries once.
+func (c *ssrCache) claim(key string) (wait <-chan struct{}, release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.inflight[key]; ok {
+		return ch, nil
+	}
+	ch := make(chan struct{})
+	c.inflight[key] = ch
+	return nil, func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		close(ch)
+	}
+}
+
+func (c *ssrCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	c.data = make(map[string]ssrEntry)
+}
+
+func (c *ssrCache) enabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ttl > 0
+}
+
+func (c *ssrCache) get(key string) (ssrEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return ssrEntry{}, false
+	}
+	e, ok := c.data[key]
+	if !ok || time.Since(e.cachedAt) > c.ttl {
+		c.misses++
+		return ssrEntry{}, false
+	}
+	c.hits++
+	return e, true
+}
+
+func (c *ssrCache) set(key string, e ssrEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	if len(c.data) >= maxSSRCacheEntries {
+		c.evictLocked()
+	}
+	c.data[key] = e
+}
+
+// evictLocked drops expired entries, or everything if none had expired; a
+// blog rarely has enough distinct pages for this to matter.
+func (c *ssrCache) evictLocked() {
+	for k, e := range c.data {
+		if time.Since(e.cachedAt) > c.ttl {
+			delete(c.data, k)
+		}
+	}
+	if len(c.data) >= maxSSRCacheEntries {
+		c.data = make(map[string]ssrEntry)
+	}
+}
+
+func (c *ssrCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = make(map[string]ssrEntry)
+}
+
+func (c *ssrCache) stats() (entries int, hits, misses int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.data), c.hits, c.misses
+}
+
+// captureWriter tees the response body so a successful render can be stored.
+type captureWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func serveSSREntry(c *gin.Context, e ssrEntry) {
+	for k, v := range e.header {
+		c.Writer.Header()[k] = v
+	}
+	c.Header("X-SSR-Cache", "hit")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodGet {
+		c.Writer.Write(e.body)
+	}
+}
+
+// privateResponse reports whether the handler marked its response as
+// per-reader (password posts), which must never be shared.
+func privateResponse(h http.Header) bool {
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	return strings.Contains(cc, "private") || strings.Contains(cc, "no-store")
+}
+
+// cachedSSR wraps a public SSR handler with the page cache. Only 200
+// responses to GET are stored, and only when not marked private; HEAD is
+// served from the cache but never fills it.
+func (s *server) cachedSSR(h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			h(c)
+			return
+		}
+		key := s.originURL(c.Request) + c.Request.URL.RequestURI()
+		if e, ok := s.pages.get(key); ok {
+			serveSSREntry(c, e)
+			return
+		}
+		if s.pages.enabled() {
+			wait, release := s.pages.claim(key)
+			if wait != nil {
+				select {
+				case <-wait:
+				case <-c.Request.Context().Done():
+					return
+				}
+				if e, ok := s.pages.get(key); ok {
+					serveSSREntry(c, e)
+					return
+				}
+				// the first render wasn't cacheable; render our own copy
+			} else {
+				defer release()
+			}
+		}
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Header("X-SSR-Cache", "miss")
+		h(c)
+		c.Writer = w.ResponseWriter
+		if c.Request.Method == http.MethodGet && w.Status() == http.StatusOK && !privateResponse(w.Header()) {
+			header := w.Header().Clone()
+			header.Del("X-SSR-Cache")
+			s.pages.set(key, ssrEntry{body: w.buf.Bytes(), header: header, cachedAt: time.Now()})
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCachedSSR(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{pages: newSSRCache(time.Minute), proxies: &proxyTrust{}}
+	calls := 0
+	router := gin.New()
+	router.GET("/post/:slug", s.cachedSSR(func(c *gin.Context) {
+		calls++
+		if c.Param("slug") == "missing" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.String(http.StatusOK, "page "+c.Param("slug"))
+	}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/post/a"); w.Header().Get("X-SSR-Cache") != "miss" || w.Body.String() != "page a" {
+		t.Fatalf("first request: %q %q", w.Header().Get("X-SSR-Cache"), w.Body.String())
+	}
+	w := get("/post/a")
+	if w.Header().Get("X-SSR-Cache") != "hit" || w.Body.String() != "page a" || w.Header().Get("Cache-Control") == "" || calls != 1 {
+		t.Fatalf("second request should be served from cache: %q %q calls=%d", w.Header().Get("X-SSR-Cache"), w.Body.String(), calls)
+	}
+	get("/post/missing")
+	get("/post/missing")
+	if calls != 3 {
+		t.Fatalf("non-200 responses must not be cached, calls=%d", calls)
+	}
+
+	s.pages.invalidateAll()
+	get("/post/a")
+	if calls != 4 {
+		t.Fatalf("invalidation should force a re-render, calls=%d", calls)
+	}
+	if _, hits, misses := s.pages.stats(); hits != 1 || misses != 4 {
+		t.Fatalf("stats hits=%d misses=%d", hits, misses)
+	}
+
+	s.pages.setTTL(0)
+	get("/post/a")
+	get("/post/a")
+	if calls != 6 {
+		t.Fatalf("ttl 0 should disable the cache, calls=%d", calls)
+	}
+}
+
+func TestCachedSSRCoalescesMisses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{pages: newSSRCache(time.Minute), proxies: &proxyTrust{}}
+	var calls atomic.Int32
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	router := gin.New()
+	router.GET("/sitemap.xml", s.cachedSSR(func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-unblock
+		c.String(http.StatusOK, "sitemap")
+	}))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i > 0 {
+				<-started
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil))
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("concurrent misses rendered %d times", n)
+	}
+	for i, b := range bodies {
+		if b != "sitemap" {
+			t.Fatalf("request %d got %q", i, b)
+		}
+	}
+}
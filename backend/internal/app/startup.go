@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStartupTimeout = 2 * time.Minute
+	maxStartupBackoff     = 10 * time.Second
+)
+
+// startupTimeout is how long Run keeps retrying the initial DB connection;
+// "0" restores the old fail-fast behaviour.
+func (cfg dbConfig) startupTimeout() (time.Duration, error) {
+	if cfg.StartupTimeout == "" {
+		return defaultStartupTimeout, nil
+	}
+	d, err := time.ParseDuration(cfg.StartupTimeout)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("database.startupTimeout 无效: %s", cfg.StartupTimeout)
+	}
+	return d, nil
+}
+
+// swapHandler lets the listener come up before the full router exists.
+type swapHandler struct {
+	h atomic.Value
+}
+
+func (sh *swapHandler) set(h http.Handler) {
+	sh.h.Store(&h)
+}
+
+func (sh *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*sh.h.Load().(*http.Handler)).ServeHTTP(w, r)
+}
+
+// startingHandler answers every request with 503 while the database is still
+// unreachable. Why it is unreachable only goes to the log, which
+// connectWithRetry writes on every attempt.
+func startingHandler() http.Handler {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.NoRoute(func(c *gin.Context) {
+		c.Header("Retry-After", "5")
+		respondError(c, http.StatusServiceUnavailable, errServerStarting)
+	})
+	return router
+}
+
+// connectWithRetry keeps calling ensureDB with exponential backoff until it
+// succeeds, the timeout elapses or ctx is canceled.
+func connectWithRetry(ctx context.Context, cfg dbConfig) (*sql.DB, error) {
+	timeout, err := cfg.startupTimeout()
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		db, err := ensureDB(ctx, cfg)
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		warnf("数据库未就绪 (第 %d 次尝试)，%s 后重试: %v\n", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxStartupBackoff {
+			backoff = maxStartupBackoff
+		}
+	}
+}
+
+// healthz is the readiness probe: 200 only when the primary answers a ping.
+func (s *server) healthz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		warnf("健康检查无法连接数据库: %v\n", err)
+		respondError(c, http.StatusServiceUnavailable, errDatabaseUnavailable)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
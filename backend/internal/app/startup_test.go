@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupTimeout(t *testing.T) {
+	if d, err := (dbConfig{}).startupTimeout(); err != nil || d != defaultStartupTimeout {
+		t.Fatalf("default: got %v, %v", d, err)
+	}
+	if d, err := (dbConfig{StartupTimeout: "0"}).startupTimeout(); err != nil || d != 0 {
+		t.Fatalf("fail-fast: got %v, %v", d, err)
+	}
+	if _, err := (dbConfig{StartupTimeout: "soon"}).startupTimeout(); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+}
+
+func TestStartingHandlerReportsNotReady(t *testing.T) {
+	rec := httptest.NewRecorder()
+	startingHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"`+string(errServerStarting)+`"`) {
+		t.Fatalf("body = %s", rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	if d, err := (dbConfig{}).queryTimeout(); err != nil || d != defaultQueryTimeout {
+		t.Fatalf("default = %v, %v", d, err)
+	}
+	if d, err := (dbConfig{QueryTimeout: "0"}).queryTimeout(); err != nil || d != 0 {
+		t.Fatalf("disabled = %v, %v", d, err)
+	}
+	if _, err := (dbConfig{QueryTimeout: "soon"}).queryTimeout(); err == nil {
+		t.Fatal("invalid duration accepted")
+	}
+
+	s := &server{queryTimeout: time.Second}
+	ctx, cancel := s.withQueryTimeout(context.Background())
+	defer cancel()
+	if dl, ok := ctx.Deadline(); !ok || time.Until(dl) > time.Second {
+		t.Fatalf("deadline = %v, %v", dl, ok)
+	}
+	tight, cancelTight := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTight()
+	if got, _ := s.withQueryTimeout(tight); got != tight {
+		t.Fatal("a tighter caller deadline should be kept as is")
+	}
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hashedBundle matches Angular's content-hashed build output (main-ABCD1234.js).
+var hashedBundle = regexp.MustCompile(`-[A-Za-z0-9]{8,}\.(js|css|woff2?|ttf|svg|png|jpe?g|webp)$`)
+
+// serviceWorkers are the root-level worker scripts a build may ship (Angular's
+// ngsw-worker.js and its safety/kill switch, or a hand-written sw.js).
+var serviceWorkers = map[string]bool{"ngsw-worker.js": true, "safety-worker.js": true, "worker-basic.min.js": true, "sw.js": true, "service-worker.js": true}
+
+const (
+	cacheImmutable = "public, max-age=31536000, immutable"
+	cacheAssets    = "public, max-age=86400"
+	cacheMedia     = "public, max-age=604800"
+	cacheIndex     = "no-cache"
+)
+
+// staticSite serves everything that is not API or SSR: the SPA build (root
+// bundles plus /assets), uploaded media under /media, and the index.html
+// fallback for client-side routes. prefix is the sub-path a reverse proxy
+// forwards us under, e.g. "/blog". The SPA comes either from disk or from the
+// build embedded into the binary; media always lives on disk.
+type staticSite struct {
+	prefix   string
+	files    fs.FS
+	mediaDir string
+	// images, when set, answers /media/cache/ from the external image cache.
+	images *imageCache
+}
+
+func newStaticSite(files fs.FS, mediaDir, prefix string) *staticSite {
+	st := &staticSite{prefix: normalizeURLPrefix(prefix), files: files}
+	if dir := filepath.Clean(mediaDir); mediaDir != "" {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			st.mediaDir = dir
+		}
+	}
+	return st
+}
+
+// diskSPA returns the on-disk build at staticDir, or nil when it is missing.
+func diskSPA(staticDir string) fs.FS {
+	if staticDir == "" {
+		return nil
+	}
+	dir := filepath.Clean(staticDir)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		warnf("静态目录不存在: %s\n", dir)
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		warnf("index.html 不存在于静态目录 %s\n", dir)
+		return nil
+	}
+	return os.DirFS(dir)
+}
+
+// normalizeURLPrefix turns "blog/", "/blog" or "" into "/blog" or "".
+func normalizeURLPrefix(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// safeJoin resolves rel inside root and reports false when the result would
+// escape root (../ segments, absolute paths, symlink-free traversal tricks).
+func safeJoin(root, rel string) (string, bool) {
+	full := filepath.Join(root, filepath.FromSlash(filepath.Clean("/"+rel)))
+	r, err := filepath.Rel(root, full)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) || filepath.IsAbs(r) {
+		return "", false
+	}
+	return full, true
+}
+
+// fsName maps a URL path onto an fs.FS name, rejecting anything fs.ValidPath
+// would refuse.
+func fsName(rel string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+rel), "/")
+	if name == "" {
+		return ".", true
+	}
+	return name, fs.ValidPath(name)
+}
+
+func (st *staticSite) mount(router *gin.Engine) {
+	if st.mediaDir != "" {
+		h := st.serveDir(st.mediaDir, cacheMedia)
+		if st.images != nil {
+			h = st.withImageCache(h)
+		}
+		router.GET(st.prefix+"/media/*filepath", h)
+		router.HEAD(st.prefix+"/media/*filepath", h)
+	}
+	if st.files == nil {
+		warnf("未找到前端构建，跳过静态文件服务\n")
+		router.NoRoute(st.notFound)
+		return
+	}
+	assets := st.serveFS("assets", cacheAssets)
+	router.GET(st.prefix+"/assets/*filepath", assets)
+	router.HEAD(st.prefix+"/assets/*filepath", assets)
+	router.NoRoute(st.fallback)
+}
+
+// serveDir serves single files below root with http.ServeFile semantics
+// (ranges, conditional requests) but never lists directories.
+func (st *staticSite) serveDir(root, cacheControl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		full, ok := safeJoin(root, c.Param("filepath"))
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		info, err := os.Stat(full)
+		if err != nil || info.IsDir() {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Cache-Control", cacheControl)
+		http.ServeFile(c.Writer, c.Request, full)
+	}
+}
+
+// withImageCache routes /media/cache/<hash> to the external image cache and
+// everything else under /media to next.
+func (st *staticSite) withImageCache(next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if hash, ok := strings.CutPrefix(c.Param("filepath"), "/"+imageCacheSubdir+"/"); ok {
+			st.images.serve(c, hash)
+			return
+		}
+		next(c)
+	}
+}
+
+// serveFS is serveDir for a sub-directory of the SPA build.
+func (st *staticSite) serveFS(dir, cacheControl string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, ok := fsName(dir + "/" + c.Param("filepath"))
+		if !ok || !st.isFile(name) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Cache-Control", cacheControl)
+		http.ServeFileFS(c.Writer, c.Request, st.files, name)
+	}
+}
+
+func (st *staticSite) isFile(name string) bool {
+	info, err := fs.Stat(st.files, name)
+	return err == nil && !info.IsDir()
+}
+
+func (st *staticSite) notFound(c *gin.Context) {
+	respondError(c, http.StatusNotFound, errNotFound)
+}
+
+// buildFile is fallback for routes the server also answers itself; without a
+// frontend build it is a plain 404.
+func (st *staticSite) buildFile(c *gin.Context) {
+	if st.files == nil {
+		st.notFound(c)
+		return
+	}
+	st.fallback(c)
+}
+
+// serviceWorkerHeaders lets a root-level worker control the whole site under
+// the prefix and keeps browsers from caching the script itself, so updates
+// are picked up on the next navigation.
+func (st *staticSite) serviceWorkerHeaders(c *gin.Context) {
+	c.Header("Service-Worker-Allowed", st.prefix+"/")
+	c.Header("Cache-Control", cacheIndex)
+}
+
+// fallback serves root-level build files and hands every other GET to the SPA.
+func (st *staticSite) fallback(c *gin.Context) {
+	reqPath := c.Request.URL.Path
+	if st.prefix != "" {
+		if reqPath != st.prefix && !strings.HasPrefix(reqPath, st.prefix+"/") {
+			st.notFound(c)
+			return
+		}
+		reqPath = strings.TrimPrefix(reqPath, st.prefix)
+	}
+	if strings.HasPrefix(reqPath, "/api/") || reqPath == "/api" || reqPath == "/health" {
+		st.notFound(c)
+		return
+	}
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		st.notFound(c)
+		return
+	}
+
+	if name, ok := fsName(reqPath); ok && name != "." && name != "index.html" && st.isFile(name) {
+		switch {
+		case serviceWorkers[name]:
+			st.serviceWorkerHeaders(c)
+		case name == "ngsw.json":
+			// the worker's manifest of the current build; a stale copy pins old bundles
+			c.Header("Cache-Control", cacheIndex)
+		case hashedBundle.MatchString(path.Base(name)):
+			c.Header("Cache-Control", cacheImmutable)
+		}
+		http.ServeFileFS(c.Writer, c.Request, st.files, name)
+		return
+	}
+
+	doc, err := fs.ReadFile(st.files, "index.html")
+	if err != nil {
+		st.notFound(c)
+		return
+	}
+	c.Header("Cache-Control", cacheIndex)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(rewriteBaseHref(string(doc), st.prefix)))
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := filepath.FromSlash("/srv/static")
+	cases := []struct {
+		rel  string
+		want string
+		ok   bool
+	}{
+		{"main-ABCDEFGH.js", "/srv/static/main-ABCDEFGH.js", true},
+		{"/assets/logo.svg", "/srv/static/assets/logo.svg", true},
+		{"../config.yaml", "/srv/static/config.yaml", true},
+		{"assets/../../etc/passwd", "/srv/static/etc/passwd", true},
+		{"", "/srv/static", true},
+	}
+	for _, tc := range cases {
+		got, ok := safeJoin(root, tc.rel)
+		if ok != tc.ok || got != filepath.FromSlash(tc.want) {
+			t.Fatalf("safeJoin(%q) = %q, %v; want %q, %v", tc.rel, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestNormalizeURLPrefix(t *testing.T) {
+	for in, want := range map[string]string{"": "", "/": "", "blog": "/blog", "/blog/": "/blog", " /a/b ": "/a/b"} {
+		if got := normalizeURLPrefix(in); got != want {
+			t.Fatalf("normalizeURLPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFSName(t *testing.T) {
+	for in, want := range map[string]string{"": ".", "/": ".", "/main-ABCDEFGH.js": "main-ABCDEFGH.js", "/assets/../../x": "x", "assets//a.png": "assets/a.png"} {
+		got, ok := fsName(in)
+		if !ok || got != want {
+			t.Fatalf("fsName(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+}
+
+func TestFallbackServiceWorker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	files := fstest.MapFS{
+		"index.html":       {Data: []byte("<base href=\"/\">")},
+		"ngsw-worker.js":   {Data: []byte("self")},
+		"ngsw.json":        {Data: []byte("{}")},
+		"main-ABCDEFGH.js": {Data: []byte("main")},
+	}
+	router := gin.New()
+	newStaticSite(files, "", "/blog").mount(router)
+	for path, want := range map[string][2]string{
+		"/blog/ngsw-worker.js":   {"/blog/", cacheIndex},
+		"/blog/ngsw.json":        {"", cacheIndex},
+		"/blog/main-ABCDEFGH.js": {"", cacheImmutable},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Service-Worker-Allowed") != want[0] || w.Header().Get("Cache-Control") != want[1] {
+			t.Errorf("%s: %d %v", path, w.Code, w.Header())
+		}
+	}
+}
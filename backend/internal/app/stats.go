@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	activityDays     = 365
+	activityMonths   = 12
+	activityCacheTTL = 10 * time.Minute
+	dateLayout       = "2006-01-02"
+)
+
+// activityDay is one day with published posts, in the site's timezone.
+type activityDay struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+	Words int    `json:"words"`
+}
+
+type activityMonth struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+	Words int    `json:"words"`
+}
+
+type activityStreak struct {
+	Days  int    `json:"days"`
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// activityStats is the heatmap payload: Days lists the days in [From, To]
+// that have posts (the rest are zero), Months the last twelve months oldest
+// first. Streaks count consecutive posting days over the whole history; the
+// current one is still alive if the last post was today or yesterday.
+type activityStats struct {
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	Total         int             `json:"total"`
+	Days          []activityDay   `json:"days"`
+	Months        []activityMonth `json:"months"`
+	LongestStreak activityStreak  `json:"longestStreak"`
+	CurrentStreak int             `json:"currentStreak"`
+}
+
+// activityCache keeps the last result for the day it was computed on. It is
+// dropped on every content change, like the list cache.
+type activityCache struct {
+	mu    sync.Mutex
+	day   string
+	at    time.Time
+	stats *activityStats
+}
+
+func (c *activityCache) get(day string) (*activityStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil || c.day != day || time.Since(c.at) > activityCacheTTL {
+		return nil, false
+	}
+	return c.stats, true
+}
+
+func (c *activityCache) set(day string, stats *activityStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.day, c.at, c.stats = day, time.Now(), stats
+}
+
+func (c *activityCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = nil
+}
+
+// queryActivityDays groups every public post and note by local publishing
+// day. Words are approximate: each CJK character counts as one, plus runs of
+// ASCII letters and digits; encrypted bodies count none.
+func (s *server) queryActivityDays(ctx context.Context) ([]activityDay, error) {
+	rows, err := s.readQuery(ctx, `
+		SELECT (COALESCE(published_at, created_at) AT TIME ZONE COALESCE(NULLIF($1, ''), current_setting('TimeZone')))::date AS day,
+		       COUNT(*),
+		       COALESCE(SUM(CASE WHEN body_md LIKE 'enc1:%' THEN 0 ELSE
+		           length(regexp_replace(body_md, '[^㐀-䶿一-鿿]', '', 'g'))
+		           + (SELECT COUNT(*) FROM regexp_matches(body_md, '[A-Za-z0-9]+', 'g'))
+		       END), 0)
+		FROM articles
+		WHERE status='published' AND type IN ('post', 'note') AND visibility = 'public'
+		GROUP BY day
+		ORDER BY day`, s.siteTimezoneSQL())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var days []activityDay
+	for rows.Next() {
+		var d activityDay
+		var day time.Time
+		if err := rows.Scan(&day, &d.Count, &d.Words); err != nil {
+			return nil, err
+		}
+		d.Date = day.Format(dateLayout)
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// buildActivityStats summarizes days (oldest first, as queried) relative to
+// today's date.
+func buildActivityStats(days []activityDay, today time.Time) activityStats {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	from := today.AddDate(0, 0, -(activityDays - 1))
+	st := activityStats{
+		From:   from.Format(dateLayout),
+		To:     today.Format(dateLayout),
+		Days:   []activityDay{},
+		Months: make([]activityMonth, activityMonths),
+	}
+	firstMonth := time.Date(today.Year(), today.Month()-activityMonths+1, 1, 0, 0, 0, 0, time.UTC)
+	monthIndex := map[string]int{}
+	for i := range st.Months {
+		key := firstMonth.AddDate(0, i, 0).Format("2006-01")
+		st.Months[i].Month = key
+		monthIndex[key] = i
+	}
+
+	var run activityStreak
+	var prev time.Time
+	for _, d := range days {
+		day, err := time.Parse(dateLayout, d.Date)
+		if err != nil || day.After(today) {
+			continue
+		}
+		if !day.Before(from) {
+			st.Days = append(st.Days, d)
+			st.Total += d.Count
+		}
+		if i, ok := monthIndex[d.Date[:7]]; ok {
+			st.Months[i].Count += d.Count
+			st.Months[i].Words += d.Words
+		}
+		if run.Days > 0 && day.Equal(prev.AddDate(0, 0, 1)) {
+			run.Days++
+			run.End = d.Date
+		} else {
+			run = activityStreak{Days: 1, Start: d.Date, End: d.Date}
+		}
+		if run.Days > st.LongestStreak.Days {
+			st.LongestStreak = run
+		}
+		prev = day
+	}
+	if run.Days > 0 && !prev.Before(today.AddDate(0, 0, -1)) {
+		st.CurrentStreak = run.Days
+	}
+	return st
+}
+
+func (s *server) activityStatsHandler(c *gin.Context) {
+	now := time.Now().In(s.siteLocation())
+	today := now.Format(dateLayout)
+	st, ok := s.activity.get(today)
+	if !ok {
+		days, err := s.queryActivityDays(c.Request.Context())
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryStatsFailed)
+			return
+		}
+		built := buildActivityStats(days, now)
+		st = &built
+		s.activity.set(today, st)
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, st)
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildActivityStats(t *testing.T) {
+	today := time.Date(2026, 3, 10, 23, 30, 0, 0, time.FixedZone("CST", 8*3600))
+	days := []activityDay{
+		{Date: "2024-01-01", Count: 9, Words: 900}, // outside the year
+		{Date: "2025-03-11", Count: 1, Words: 10},  // first day in range
+		{Date: "2025-06-01", Count: 1, Words: 5},
+		{Date: "2025-06-02", Count: 2, Words: 7},
+		{Date: "2025-06-03", Count: 1, Words: 1},
+		{Date: "2026-03-09", Count: 1, Words: 20},
+		{Date: "2026-03-10", Count: 3, Words: 30},
+		{Date: "2026-03-11", Count: 1, Words: 1}, // tomorrow, ignored
+	}
+	st := buildActivityStats(days, today)
+	if st.From != "2025-03-11" || st.To != "2026-03-10" {
+		t.Fatalf("range = %s..%s", st.From, st.To)
+	}
+	if len(st.Days) != 6 || st.Total != 9 {
+		t.Fatalf("days = %+v, total %d", st.Days, st.Total)
+	}
+	if len(st.Months) != 12 || st.Months[0].Month != "2025-04" || st.Months[11].Month != "2026-03" {
+		t.Fatalf("months = %+v", st.Months)
+	}
+	if m := st.Months[11]; m.Count != 4 || m.Words != 50 {
+		t.Fatalf("this month = %+v", m)
+	}
+	if m := st.Months[2]; m.Month != "2025-06" || m.Count != 4 || m.Words != 13 {
+		t.Fatalf("june = %+v", m)
+	}
+	if st.LongestStreak != (activityStreak{Days: 3, Start: "2025-06-01", End: "2025-06-03"}) {
+		t.Fatalf("longest = %+v", st.LongestStreak)
+	}
+	if st.CurrentStreak != 2 {
+		t.Fatalf("current = %d", st.CurrentStreak)
+	}
+
+	if st := buildActivityStats(days[:4], today); st.CurrentStreak != 0 {
+		t.Fatalf("lapsed streak = %d", st.CurrentStreak)
+	}
+	if st := buildActivityStats(nil, today); st.Days == nil || st.LongestStreak.Days != 0 {
+		t.Fatalf("empty = %+v", st)
+	}
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// storageWalkTimeout bounds the directory walks of one storage report.
+const storageWalkTimeout = 20 * time.Second
+
+type tableUsage struct {
+	Name       string `json:"name"`
+	TotalBytes int64  `json:"totalBytes"`
+	TableBytes int64  `json:"tableBytes"`
+	IndexBytes int64  `json:"indexBytes"`
+	// Rows is the planner's estimate, -1 for tables never analyzed.
+	Rows int64 `json:"rows"`
+}
+
+// dirUsage is what a directory holds on disk. Directories may nest (the
+// image cache lives in mediaDir), so their sizes don't add up.
+type dirUsage struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+	Files int    `json:"files"`
+	// Partial is set when the walk timed out or hit unreadable entries.
+	Partial bool `json:"partial,omitempty"`
+}
+
+type storageReport struct {
+	Database struct {
+		Name  string `json:"name"`
+		Bytes int64  `json:"bytes"`
+		// WALBytes needs superuser or pg_monitor; nil when not allowed.
+		WALBytes *int64 `json:"walBytes"`
+	} `json:"database"`
+	Tables      []tableUsage `json:"tables"`
+	Directories []dirUsage   `json:"directories"`
+	Disk        struct {
+		TotalBytes uint64 `json:"totalBytes"`
+		UsedBytes  uint64 `json:"usedBytes"`
+		FreeBytes  uint64 `json:"freeBytes"`
+	} `json:"disk"`
+}
+
+// databaseUsage fills the database part of r from the pg catalog.
+func (s *server) databaseUsage(ctx context.Context, r *storageReport) error {
+	if err := s.db.QueryRowContext(ctx, `SELECT current_database(), pg_database_size(current_database())`).
+		Scan(&r.Database.Name, &r.Database.Bytes); err != nil {
+		return err
+	}
+	var wal int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(sum(size), 0)::bigint FROM pg_ls_waldir()`).Scan(&wal); err == nil {
+		r.Database.WALBytes = &wal
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.relname, pg_total_relation_size(c.oid), pg_relation_size(c.oid), pg_indexes_size(c.oid), c.reltuples::bigint
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p', 'm') AND n.nspname = current_schema()
+		ORDER BY 2 DESC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	r.Tables = []tableUsage{}
+	for rows.Next() {
+		var t tableUsage
+		if err := rows.Scan(&t.Name, &t.TotalBytes, &t.TableBytes, &t.IndexBytes, &t.Rows); err != nil {
+			return err
+		}
+		r.Tables = append(r.Tables, t)
+	}
+	return rows.Err()
+}
+
+// storageDirs lists the directories the server writes to, by subsystem.
+func (s *server) storageDirs() []dirUsage {
+	dirs := []dirUsage{}
+	add := func(name, path string) {
+		if path != "" {
+			dirs = append(dirs, dirUsage{Name: name, Path: path})
+		}
+	}
+	add("media", s.mediaDir)
+	if s.mediaDir != "" {
+		add("media.imageCache", filepath.Join(s.mediaDir, imageCacheSubdir))
+		add("media.imported", filepath.Join(s.mediaDir, importedSubdir))
+	}
+	if s.files != nil {
+		add("files", s.files.dir)
+	}
+	if s.git != nil {
+		add("gitSync", s.git.dir)
+	}
+	if s.backups != nil {
+		for i, t := range s.backups.targets {
+			if local, ok := t.(*localTarget); ok {
+				add("backup."+s.backups.cfg.Targets[i].label(i), local.dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// walkUsage adds up the regular files below d.Path. A missing directory is
+// empty.
+func walkUsage(ctx context.Context, d *dirUsage) {
+	err := filepath.WalkDir(d.Path, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				d.Partial = true
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !e.Type().IsRegular() {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			d.Partial = true
+			return nil
+		}
+		d.Bytes += info.Size()
+		d.Files++
+		return nil
+	})
+	if err != nil {
+		d.Partial = true
+	}
+}
+
+// adminStorage backs GET /api/admin/storage: what the database, its biggest
+// tables and the server's directories take up, next to the disk the server
+// runs on.
+func (s *server) adminStorage(c *gin.Context) {
+	var r storageReport
+	ctx := c.Request.Context()
+	if err := s.databaseUsage(ctx, &r); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errQueryStatsFailed, err)
+		return
+	}
+
+	walkCtx, cancel := context.WithTimeout(ctx, storageWalkTimeout)
+	defer cancel()
+	r.Directories = s.storageDirs()
+	for i := range r.Directories {
+		walkUsage(walkCtx, &r.Directories[i])
+	}
+
+	root := "/"
+	if s.mediaDir != "" {
+		if _, err := os.Stat(s.mediaDir); err == nil {
+			root = s.mediaDir
+		}
+	}
+	if u, err := disk.Usage(root); err == nil {
+		r.Disk.TotalBytes, r.Disk.UsedBytes, r.Disk.FreeBytes = u.Total, u.Used, u.Free
+	}
+	c.JSON(http.StatusOK, r)
+}
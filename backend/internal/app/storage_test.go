@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkUsage(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "a", "b"), 0o755)
+	os.WriteFile(filepath.Join(dir, "one"), make([]byte, 10), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "b", "two"), make([]byte, 5), 0o644)
+	os.Symlink(filepath.Join(dir, "one"), filepath.Join(dir, "link"))
+
+	d := dirUsage{Path: dir}
+	walkUsage(context.Background(), &d)
+	if d.Bytes != 15 || d.Files != 2 || d.Partial {
+		t.Fatalf("usage = %+v", d)
+	}
+
+	missing := dirUsage{Path: filepath.Join(dir, "nope")}
+	walkUsage(context.Background(), &missing)
+	if missing.Bytes != 0 || missing.Partial {
+		t.Fatalf("missing dir = %+v", missing)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d = dirUsage{Path: dir}
+	walkUsage(ctx, &d)
+	if !d.Partial {
+		t.Fatal("a cancelled walk should be partial")
+	}
+}
+
+func TestStorageDirs(t *testing.T) {
+	s := &server{
+		mediaDir: "/srv/media",
+		files:    &attachmentStore{dir: "/srv/files"},
+		backups: newBackupRunner("/etc/selfecho/config.yaml", backupConfig{Enabled: true, Targets: []backupTargetConfig{
+			{Name: "nas", Type: backupLocal, Dir: "backups"},
+			{Type: backupWebDAV, URL: "https://dav.example/b"},
+		}}, dbConfig{}),
+	}
+	got := map[string]string{}
+	for _, d := range s.storageDirs() {
+		got[d.Name] = d.Path
+	}
+	want := map[string]string{
+		"media":            "/srv/media",
+		"media.imageCache": "/srv/media/cache",
+		"media.imported":   "/srv/media/imported",
+		"files":            "/srv/files",
+		"backup.nas":       "/etc/selfecho/backups",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("dirs = %v", got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
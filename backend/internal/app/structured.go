@@ -0,0 +1,111 @@
+package app
+
+import (
+	"net/url"
+	"strings"
+)
+
+// publisherLD describes who runs the site for schema.org: a Person (a
+// personal blog, the default) or an Organization. Empty fields fall back to
+// the site author, title and social links.
+type publisherLD struct {
+	Type   string   `json:"type,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	URL    string   `json:"url,omitempty"`
+	Logo   string   `json:"logo,omitempty"`
+	SameAs []string `json:"sameAs,omitempty"`
+}
+
+func (p *publisherLD) normalize() error {
+	p.Type = strings.TrimSpace(p.Type)
+	p.Name = strings.TrimSpace(p.Name)
+	p.URL = strings.TrimSpace(p.URL)
+	p.Logo = strings.TrimSpace(p.Logo)
+	p.SameAs = cleanRuleList(p.SameAs)
+	if p.Type != "" && p.Type != "Person" && p.Type != "Organization" {
+		return newAPIError(errInvalidPublisher, p.Type)
+	}
+	for _, raw := range append([]string{p.URL, p.Logo}, p.SameAs...) {
+		if raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return newAPIError(errInvalidPublisher, raw)
+		}
+	}
+	return nil
+}
+
+// publisherEntity builds the Person/Organization node shared by every page;
+// siteURL is the absolute home page URL.
+func publisherEntity(st siteSettings, siteURL string) map[string]any {
+	p := st.Publisher
+	typ := p.Type
+	name := p.Name
+	if name == "" && typ != "Organization" && st.Author != "" {
+		name = st.Author
+	}
+	if name == "" {
+		name = st.Title
+		if typ == "" {
+			typ = "Organization"
+		}
+	}
+	if typ == "" {
+		typ = "Person"
+	}
+	entity := map[string]any{
+		"@type": typ,
+		"@id":   siteURL + "#publisher",
+		"name":  name,
+		"url":   siteURL,
+	}
+	if p.URL != "" {
+		entity["url"] = p.URL
+	}
+	if p.Logo != "" {
+		key := "image"
+		if typ == "Organization" {
+			key = "logo"
+		}
+		entity[key] = map[string]any{"@type": "ImageObject", "url": p.Logo}
+	}
+	sameAs := p.SameAs
+	if len(sameAs) == 0 {
+		for _, link := range st.SocialLinks {
+			if strings.HasPrefix(link.URL, "http://") || strings.HasPrefix(link.URL, "https://") {
+				sameAs = append(sameAs, link.URL)
+			}
+		}
+	}
+	if len(sameAs) > 0 {
+		entity["sameAs"] = sameAs
+	}
+	return entity
+}
+
+type crumb struct {
+	Name string
+	URL  string
+}
+
+func breadcrumbList(crumbs []crumb) map[string]any {
+	items := make([]map[string]any, 0, len(crumbs))
+	for i, c := range crumbs {
+		items = append(items, map[string]any{
+			"@type":    "ListItem",
+			"position": i + 1,
+			"name":     c.Name,
+			"item":     c.URL,
+		})
+	}
+	return map[string]any{"@type": "BreadcrumbList", "itemListElement": items}
+}
+
+// jsonLDGraph wraps nodes in a single schema.org @graph document.
+func jsonLDGraph(nodes ...map[string]any) string {
+	return buildJSONLD(map[string]any{
+		"@context": "https://schema.org",
+		"@graph":   nodes,
+	})
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPublisherEntityFallbacks(t *testing.T) {
+	st := defaultSiteSettings(siteConfig{Title: "Echo"})
+	st.SocialLinks = []socialLink{{Name: "GitHub", URL: "https://github.com/x"}, {Name: "Mail", URL: "mailto:a@b.c"}}
+
+	got := publisherEntity(st, "https://x.test/")
+	if got["@type"] != "Organization" || got["name"] != "Echo" {
+		t.Fatalf("site without author should publish as the site: %v", got)
+	}
+	if sameAs, _ := got["sameAs"].([]string); len(sameAs) != 1 || sameAs[0] != "https://github.com/x" {
+		t.Fatalf("sameAs should come from http social links: %v", got["sameAs"])
+	}
+
+	st.Author = "Feng"
+	if got := publisherEntity(st, "https://x.test/"); got["@type"] != "Person" || got["name"] != "Feng" {
+		t.Fatalf("author should make a Person publisher: %v", got)
+	}
+
+	st.Publisher = publisherLD{Type: "Organization", Name: "Echo Inc", Logo: "https://x.test/logo.png"}
+	got = publisherEntity(st, "https://x.test/")
+	if got["name"] != "Echo Inc" || got["logo"] == nil {
+		t.Fatalf("explicit publisher should win: %v", got)
+	}
+
+	bad := publisherLD{Type: "Robot"}
+	if err := bad.normalize(); err == nil {
+		t.Fatal("expected unknown publisher type to be rejected")
+	}
+}
+
+func TestJSONLDGraphBreadcrumbs(t *testing.T) {
+	doc := jsonLDGraph(breadcrumbList([]crumb{{"Home", "https://x.test/"}, {"Go", "https://x.test/category/Go"}}))
+	var parsed struct {
+		Context string `json:"@context"`
+		Graph   []struct {
+			Type  string `json:"@type"`
+			Items []struct {
+				Position int    `json:"position"`
+				Item     string `json:"item"`
+			} `json:"itemListElement"`
+		} `json:"@graph"`
+	}
+	if err := json.Unmarshal([]byte(doc), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Context != "https://schema.org" || len(parsed.Graph) != 1 || parsed.Graph[0].Type != "BreadcrumbList" {
+		t.Fatalf("unexpected graph: %s", doc)
+	}
+	if items := parsed.Graph[0].Items; len(items) != 2 || items[1].Position != 2 || items[1].Item != "https://x.test/category/Go" {
+		t.Fatalf("unexpected breadcrumb items: %s", doc)
+	}
+}
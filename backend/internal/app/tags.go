@@ -0,0 +1,249 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTagRunes           = 32
+	maxArticleTags        = 20
+	defaultTagSuggestions = 8
+)
+
+// tagList scans the `to_json(tags)::text` form used by article queries.
+type tagList []string
+
+func (t *tagList) Scan(src any) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("tagList: unsupported type %T", src)
+	}
+	var out []string
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return err
+	}
+	*t = out
+	return nil
+}
+
+// normalizeTag trims decoration and lowercases ASCII so "#Go " and "go" are
+// the same tag; CJK tags are kept as written.
+func normalizeTag(tag string) string {
+	tag = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(tag), "#"))
+	tag = strings.Map(func(r rune) rune {
+		if r == ',' || r == '，' || unicode.IsControl(r) {
+			return -1
+		}
+		if r < utf8.RuneSelf {
+			return unicode.ToLower(r)
+		}
+		return r
+	}, tag)
+	tag = collapseWhitespace(tag)
+	if utf8.RuneCountInString(tag) > maxTagRunes {
+		tag = strings.TrimSpace(string([]rune(tag)[:maxTagRunes]))
+	}
+	return tag
+}
+
+// countTags is how many distinct tags in holds once normalized, before the
+// cap normalizeTags applies.
+func countTags(in []string) int {
+	seen := make(map[string]bool, len(in))
+	for _, t := range in {
+		if t = normalizeTag(t); t != "" {
+			seen[t] = true
+		}
+	}
+	return len(seen)
+}
+
+// normalizeTags normalizes, drops empties and duplicates and caps the count.
+func normalizeTags(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := []string{}
+	for _, t := range in {
+		t = normalizeTag(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+		if len(out) == maxArticleTags {
+			break
+		}
+	}
+	return out
+}
+
+type tagCandidate struct {
+	Tag      string  `json:"tag"`
+	Score    float64 `json:"score"`
+	Existing bool    `json:"existing"`
+}
+
+var fencedCode = regexp.MustCompile("(?s)```.*?```")
+
+// keywordText is the plain text keyword extraction works on: the title counts
+// three times and code blocks are left out.
+func keywordText(title, bodyMD string) string {
+	body := markdownPlainText(fencedCode.ReplaceAllString(bodyMD, " "))
+	return strings.Repeat(title+"\n", 3) + body
+}
+
+var englishStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "that": true, "this": true,
+	"from": true, "are": true, "was": true, "were": true, "you": true, "your": true,
+	"not": true, "but": true, "can": true, "will": true, "have": true, "has": true,
+	"its": true, "into": true, "when": true, "what": true, "how": true, "why": true,
+	"use": true, "using": true, "also": true, "there": true, "then": true, "than": true,
+	"about": true, "more": true, "some": true, "just": true, "like": true, "one": true,
+	"all": true, "any": true, "out": true, "our": true, "their": true, "they": true,
+	"http": true, "https": true, "www": true, "com": true,
+}
+
+// extractTerms counts candidate terms: latin words of three or more letters
+// (keeping things like c++ and node.js together) and 2–4 character CJK
+// n-grams that repeat, reduced to the longest form with the same count.
+func extractTerms(text string) map[string]int {
+	terms := make(map[string]int)
+	grams := make(map[string]int)
+
+	var word []rune
+	flushWord := func() {
+		w := strings.Trim(string(word), ".-")
+		word = word[:0]
+		if utf8.RuneCountInString(w) < 3 || englishStopwords[w] {
+			return
+		}
+		if strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			return
+		}
+		terms[w]++
+	}
+	var han []rune
+	flushHan := func() {
+		for n := 2; n <= 4; n++ {
+			for i := 0; i+n <= len(han); i++ {
+				grams[string(han[i:i+n])]++
+			}
+		}
+		han = han[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || (len(word) > 0 && strings.ContainsRune("+#.-", r))):
+			flushHan()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+
+	for g, n := range grams {
+		if n < 2 {
+			continue
+		}
+		covered := false
+		for longer, m := range grams {
+			if m == n && len(longer) > len(g) && strings.Contains(longer, g) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			terms[g] += n
+		}
+	}
+	return terms
+}
+
+// keywordIndex holds site-wide statistics for TF-IDF scoring: how many
+// articles contain each term, and which tags are already in use.
+type keywordIndex struct {
+	docs int
+	df   map[string]int
+	tags map[string]int
+}
+
+func newKeywordIndex() *keywordIndex {
+	return &keywordIndex{df: make(map[string]int), tags: make(map[string]int)}
+}
+
+func (ix *keywordIndex) add(title, bodyMD string, tags []string) {
+	ix.docs++
+	for term := range extractTerms(keywordText(title, bodyMD)) {
+		ix.df[term]++
+	}
+	for _, t := range tags {
+		ix.tags[normalizeTag(t)]++
+	}
+}
+
+// suggest ranks keyword candidates for one article. Tags already used on the
+// site that appear in the text are preferred so the vocabulary stays small.
+func (ix *keywordIndex) suggest(title, bodyMD string, current []string, limit int) []tagCandidate {
+	text := keywordText(title, bodyMD)
+	lower := strings.ToLower(text)
+	have := make(map[string]bool, len(current))
+	for _, t := range current {
+		have[normalizeTag(t)] = true
+	}
+
+	scores := make(map[string]float64)
+	existing := make(map[string]bool)
+	for term, tf := range extractTerms(text) {
+		idf := math.Log(1 + float64(ix.docs+1)/float64(ix.df[term]+1))
+		scores[term] = float64(tf) * idf
+	}
+	for tag := range ix.tags {
+		if tag == "" {
+			continue
+		}
+		if n := strings.Count(lower, tag); n > 0 {
+			scores[tag] = math.Max(scores[tag], float64(n)) * 2
+			existing[tag] = true
+		}
+	}
+
+	out := make([]tagCandidate, 0, len(scores))
+	for term, score := range scores {
+		tag := normalizeTag(term)
+		if tag == "" || have[tag] {
+			continue
+		}
+		out = append(out, tagCandidate{Tag: tag, Score: math.Round(score*100) / 100, Existing: existing[term]})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Tag < out[j].Tag
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
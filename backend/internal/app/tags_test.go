@@ -0,0 +1,48 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" #Go ", "go", "Postgre SQL", "", "性能，优化"})
+	want := []string{"go", "postgre sql", "性能优化"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("normalizeTags = %q, want %q", got, want)
+	}
+}
+
+func TestExtractTerms(t *testing.T) {
+	terms := extractTerms("Node.js 和 C++ 的性能优化。性能优化很重要，the node.js way")
+	if terms["node.js"] != 2 || terms["c++"] != 1 {
+		t.Fatalf("unexpected latin terms: %v", terms)
+	}
+	if terms["性能优化"] != 2 {
+		t.Fatalf("expected repeated CJK phrase, got %v", terms)
+	}
+	if _, ok := terms["性能"]; ok {
+		t.Fatalf("shorter gram with same count should be folded: %v", terms)
+	}
+	if _, ok := terms["the"]; ok {
+		t.Fatal("stopwords should be skipped")
+	}
+}
+
+func TestKeywordIndexSuggest(t *testing.T) {
+	ix := newKeywordIndex()
+	ix.add("Go 并发", "goroutine goroutine channel", []string{"golang"})
+	ix.add("PostgreSQL 索引", "postgresql index index btree", []string{"postgresql"})
+	ix.add("随笔", "today was fine", nil)
+
+	got := ix.suggest("PostgreSQL 调优", "postgresql vacuum vacuum vacuum", nil, 3)
+	if len(got) == 0 || got[0].Tag != "postgresql" || !got[0].Existing {
+		t.Fatalf("expected existing tag first, got %+v", got)
+	}
+	got = ix.suggest("PostgreSQL 调优", "postgresql vacuum vacuum vacuum", []string{"PostgreSQL"}, 3)
+	for _, c := range got {
+		if c.Tag == "postgresql" {
+			t.Fatalf("current tags should not be suggested: %+v", got)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxTemplateNameRunes = 100
+
+// articleTemplate is a reusable skeleton for recurring posts (weekly notes,
+// changelogs). Title and BodyMD may contain {{placeholders}}, filled in when
+// a draft is created from it; see templateVars.
+type articleTemplate struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Title     string    `json:"title"`
+	BodyMD    string    `json:"bodyMd"`
+	Type      string    `json:"type"`
+	Archive   string    `json:"archive,omitempty"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type templatePayload struct {
+	Name    string   `json:"name"`
+	Title   string   `json:"title"`
+	BodyMD  string   `json:"bodyMd"`
+	Type    string   `json:"type"`
+	Archive string   `json:"archive"`
+	Tags    []string `json:"tags"`
+}
+
+func (p *templatePayload) normalize() error {
+	var v validator
+	p.Name = strings.TrimSpace(p.Name)
+	p.Archive = strings.TrimSpace(p.Archive)
+	if p.Type == "" {
+		p.Type = "post"
+	}
+	if v.check(p.Name != "", "name", errNameRequired) {
+		v.check(maxRunes(p.Name, maxTemplateNameRunes), "name", errNameTooLong)
+	}
+	v.check(maxRunes(p.Title, maxTitleRunes), "title", errTitleTooLong)
+	v.check(validArticleType(p.Type), "type", errInvalidType)
+	v.check(maxRunes(p.Archive, maxArchiveNameRunes), "archive", errNameTooLong)
+	v.check(countTags(p.Tags) <= maxArticleTags, "tags", errTooManyTags)
+	p.Tags = normalizeTags(p.Tags)
+	return v.err()
+}
+
+func (s *server) ensureTemplateSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS templates (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			body_md TEXT NOT NULL DEFAULT '',
+			type TEXT NOT NULL DEFAULT 'post',
+			archive TEXT NOT NULL DEFAULT '',
+			tags TEXT[] NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+	`)
+	return err
+}
+
+const templateColumns = `id, name, title, body_md, type, archive, to_json(tags)::text, created_at, updated_at`
+
+func (s *server) scanTemplate(row interface{ Scan(...any) error }) (articleTemplate, error) {
+	var t articleTemplate
+	var tags tagList
+	if err := row.Scan(&t.ID, &t.Name, &t.Title, &t.BodyMD, &t.Type, &t.Archive, &tags, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return t, err
+	}
+	t.Tags = []string(tags)
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	loc := s.siteLocation()
+	t.CreatedAt = t.CreatedAt.In(loc)
+	t.UpdatedAt = t.UpdatedAt.In(loc)
+	return t, nil
+}
+
+func (s *server) queryTemplate(ctx context.Context, id string) (articleTemplate, error) {
+	if !store.ValidID(id) {
+		return articleTemplate{}, sql.ErrNoRows
+	}
+	return s.scanTemplate(s.db.QueryRowContext(ctx, `SELECT `+templateColumns+` FROM templates WHERE id=$1`, id))
+}
+
+func (s *server) listTemplates(c *gin.Context) {
+	rows, err := s.db.QueryContext(c.Request.Context(), `SELECT `+templateColumns+` FROM templates ORDER BY name, created_at`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryTemplatesFailed)
+		return
+	}
+	defer rows.Close()
+	items := []articleTemplate{}
+	for rows.Next() {
+		t, err := s.scanTemplate(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryTemplatesFailed)
+			return
+		}
+		items = append(items, t)
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+func (s *server) getTemplate(c *gin.Context) {
+	t, err := s.queryTemplate(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errTemplateNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryTemplatesFailed)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+func (s *server) createTemplate(c *gin.Context) {
+	var payload templatePayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	var id string
+	err := s.db.QueryRowContext(c.Request.Context(), `
+		INSERT INTO templates (name, title, body_md, type, archive, tags) VALUES ($1, $2, $3, $4, $5, $6::text[]) RETURNING id`,
+		payload.Name, payload.Title, payload.BodyMD, payload.Type, payload.Archive, payload.Tags).Scan(&id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTemplateFailed, err)
+		return
+	}
+	s.publishFrom(c, eventTemplateChanged, actionCreated, id, "")
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+func (s *server) updateTemplate(c *gin.Context) {
+	id, ok := idParam(c, "id", errTemplateNotFound)
+	if !ok {
+		return
+	}
+	var payload templatePayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `
+		UPDATE templates SET name=$1, title=$2, body_md=$3, type=$4, archive=$5, tags=$6::text[], updated_at=now() WHERE id::text=$7`,
+		payload.Name, payload.Title, payload.BodyMD, payload.Type, payload.Archive, payload.Tags, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTemplateFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errTemplateNotFound)
+		return
+	}
+	s.publishFrom(c, eventTemplateChanged, actionUpdated, id, "")
+	c.Status(http.StatusNoContent)
+}
+
+func (s *server) deleteTemplate(c *gin.Context) {
+	id, ok := idParam(c, "id", errTemplateNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM templates WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTemplateFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errTemplateNotFound)
+		return
+	}
+	s.publishFrom(c, eventTemplateChanged, actionDeleted, id, "")
+	c.Status(http.StatusNoContent)
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z][A-Za-z0-9_]*)\s*\}\}`)
+
+// templateVars are the built-in placeholders, evaluated at now in the site
+// timezone: {{date}} (2006-01-02), {{time}} (15:04), {{year}}, {{month}},
+// {{day}}, {{week}} (ISO week number) and {{weekday}}.
+func templateVars(now time.Time) map[string]string {
+	_, week := now.ISOWeek()
+	return map[string]string{
+		"date":    now.Format("2006-01-02"),
+		"time":    now.Format("15:04"),
+		"year":    now.Format("2006"),
+		"month":   now.Format("01"),
+		"day":     now.Format("02"),
+		"week":    fmt.Sprintf("%02d", week),
+		"weekday": now.Weekday().String(),
+	}
+}
+
+// expandTemplate replaces known {{placeholders}}; unknown ones are left in
+// place so a typo stays visible in the draft.
+func expandTemplate(text string, vars map[string]string) string {
+	return templatePlaceholder.ReplaceAllStringFunc(text, func(m string) string {
+		name := templatePlaceholder.FindStringSubmatch(m)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// createFromTemplate backs POST /api/articles/from-template/:id. The body is
+// optional: {"title": "...", "vars": {"name": "value"}} overrides the
+// template's title and adds placeholders; {{title}} in the body refers to
+// the final title.
+func (s *server) createFromTemplate(c *gin.Context) {
+	ctx := c.Request.Context()
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Title string            `json:"title"`
+		Vars  map[string]string `json:"vars"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			respondError(c, http.StatusBadRequest, errInvalidBody)
+			return
+		}
+	}
+	t, err := s.queryTemplate(ctx, c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, errTemplateNotFound)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryTemplatesFailed)
+		return
+	}
+
+	vars := templateVars(time.Now().In(s.siteLocation()))
+	for k, v := range payload.Vars {
+		vars[k] = v
+	}
+	title := strings.TrimSpace(payload.Title)
+	if title == "" {
+		title = strings.TrimSpace(expandTemplate(t.Title, vars))
+	}
+	if title == "" {
+		title = t.Name
+	}
+	vars["title"] = title
+	bodyMD := expandTemplate(t.BodyMD, vars)
+
+	slug, err := makeSlug(title, "")
+	if err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidSlug, err)
+		return
+	}
+	var archiveID *string
+	if t.Archive != "" {
+		id, err := s.ensureArchive(ctx, t.Archive)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errCreateArchiveFailed)
+			return
+		}
+		archiveID = &id
+	}
+	p := articlePayload{Title: title, BodyMD: bodyMD, Status: "draft", Type: t.Type, Tags: &t.Tags}
+	body, err := s.storeBody(p.Status, p.Visibility, bodyMD, renderMarkdown(bodyMD))
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errCreateArticleFailed, err)
+		return
+	}
+
+	slugBase := slug
+	var id string
+	for attempt := 0; attempt < 3; attempt++ {
+		slug, err = s.ensureUniqueSlug(ctx, slugBase, "")
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errSlugDedupeFailed)
+			return
+		}
+		err = s.db.QueryRowContext(ctx, articleInsertSQL,
+			slug, title, body.md, body.html, p.Status, archiveID, nil, p.Type, p.description(), p.tags(),
+			p.lang(), nil, p.social(), p.Visibility, nil, body.excerpt, p.meta(), u.ID,
+		).Scan(&id)
+		if err == nil || !isUniqueViolation(err) {
+			break
+		}
+	}
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errCreateArticleFailed, err)
+		return
+	}
+	if err := setArticleAuthors(ctx, s.db, id, []string{u.ID}); err != nil {
+		warnf("设置文章作者失败: %v\n", err)
+	}
+	s.refreshSearchIndex(id)
+	s.refreshLinkGraph(id)
+	s.publishFrom(c, eventArticleChanged, actionCreated, id, slug)
+	c.JSON(http.StatusCreated, gin.H{"id": id, "slug": slug, "title": title})
+}
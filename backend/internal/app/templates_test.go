@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	now := time.Date(2026, 1, 5, 9, 30, 0, 0, time.UTC)
+	vars := templateVars(now)
+	vars["title"] = "Week {{week}}"
+	got := expandTemplate("# {{ title }}\n{{date}} {{time}} W{{week}} {{weekday}} {{unknown}} {{ }}", vars)
+	want := "# Week {{week}}\n2026-01-05 09:30 W02 Monday {{unknown}} {{ }}"
+	if got != want {
+		t.Fatalf("expand:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestTemplatePayloadNormalize(t *testing.T) {
+	p := templatePayload{Name: " Weekly ", Tags: []string{"Go", "go"}}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Weekly" || p.Type != "post" {
+		t.Fatalf("normalize: %+v", p)
+	}
+	for _, bad := range []templatePayload{{}, {Name: "x", Type: "page"}} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("accepted %+v", bad)
+		}
+	}
+}
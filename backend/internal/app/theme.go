@@ -0,0 +1,405 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	themeSubdir        = "theme"
+	maxThemeAssetBytes = 2 << 20
+	ogImageWidth       = 1200
+	ogImageHeight      = 630
+	defaultAccentColor = "#3273dc"
+	darkBackground     = "#16161a"
+)
+
+// themeSettings are the site's brand assets. Logo, LogoDark and Favicon are
+// URLs below /media/theme set by the upload endpoints; the accent colors
+// feed theme-color and the generated OG images.
+type themeSettings struct {
+	AccentColor     string `json:"accentColor,omitempty"`
+	AccentColorDark string `json:"accentColorDark,omitempty"`
+	Logo            string `json:"logo,omitempty"`
+	LogoDark        string `json:"logoDark,omitempty"`
+	Favicon         string `json:"favicon,omitempty"`
+	// OGScheme picks the OG image variant link previews get, "light" or
+	// "dark"; previews can't follow the reader's color scheme.
+	OGScheme string `json:"ogScheme,omitempty"`
+	// Icons is the version of the icon set generated from the logo; empty
+	// while there is none.
+	Icons string `json:"icons,omitempty"`
+}
+
+var hexColorRe = regexp.MustCompile(`^#([0-9a-f]{3}|[0-9a-f]{6})$`)
+
+// themeAssets maps the upload names to their field.
+var themeAssets = map[string]func(*themeSettings) *string{
+	"logo":     func(t *themeSettings) *string { return &t.Logo },
+	"logoDark": func(t *themeSettings) *string { return &t.LogoDark },
+	"favicon":  func(t *themeSettings) *string { return &t.Favicon },
+}
+
+// themeImageTypes are the accepted uploads by sniffed content type. SVG is
+// left out on purpose: /media serves it inline, scripts included.
+var themeImageTypes = map[string]string{
+	"image/png":                ".png",
+	"image/jpeg":               ".jpg",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+}
+
+func normalizeColor(c string) (string, bool) {
+	c = strings.ToLower(strings.TrimSpace(c))
+	if c == "" {
+		return "", true
+	}
+	if !hexColorRe.MatchString(c) {
+		return c, false
+	}
+	if len(c) == 4 {
+		c = "#" + strings.Repeat(c[1:2], 2) + strings.Repeat(c[2:3], 2) + strings.Repeat(c[3:4], 2)
+	}
+	return c, true
+}
+
+func (t *themeSettings) normalize() error {
+	var v validator
+	var ok bool
+	t.AccentColor, ok = normalizeColor(t.AccentColor)
+	v.check(ok, "accentColor", errInvalidColor, t.AccentColor)
+	t.AccentColorDark, ok = normalizeColor(t.AccentColorDark)
+	v.check(ok, "accentColorDark", errInvalidColor, t.AccentColorDark)
+	t.OGScheme = strings.ToLower(strings.TrimSpace(t.OGScheme))
+	v.check(t.OGScheme == "" || t.OGScheme == "light" || t.OGScheme == "dark", "ogScheme", errInvalidBody, t.OGScheme)
+	for _, name := range []string{"logo", "logoDark", "favicon"} {
+		p := themeAssets[name](t)
+		*p = strings.TrimSpace(*p)
+		v.check(*p == "" || strings.Contains(*p, "/media/"+themeSubdir+"/"), name, errInvalidThemeAsset, *p)
+	}
+	return v.err()
+}
+
+// accent returns the accent color for scheme, falling back to the light one.
+func (t themeSettings) accent(scheme string) string {
+	if scheme == "dark" && t.AccentColorDark != "" {
+		return t.AccentColorDark
+	}
+	if t.AccentColor != "" {
+		return t.AccentColor
+	}
+	return defaultAccentColor
+}
+
+// version changes whenever anything the OG image is drawn from does, so
+// crawlers refetch it.
+func (t themeSettings) version() string {
+	raw, _ := json.Marshal(t)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:4])
+}
+
+// themeHead renders the favicon, theme-color and og:image tags every SSR page
+// carries. base is the site's absolute URL.
+func (s *server) themeHead(t themeSettings, base string) string {
+	var b strings.Builder
+	b.WriteString(s.iconsHead(t))
+	b.WriteString(`<link rel="manifest" href="` + s.basePath + `/manifest.webmanifest">`)
+	if t.AccentColorDark != "" {
+		b.WriteString(`<meta name="theme-color" media="(prefers-color-scheme: light)" content="` + t.accent("light") + `">`)
+		b.WriteString(`<meta name="theme-color" media="(prefers-color-scheme: dark)" content="` + t.accent("dark") + `">`)
+	} else if t.AccentColor != "" {
+		b.WriteString(`<meta name="theme-color" content="` + t.AccentColor + `">`)
+	}
+	scheme := t.OGScheme
+	if scheme == "" {
+		scheme = "light"
+	}
+	image := html.EscapeString(base + "/og-image.png?scheme=" + scheme + "&v=" + t.version())
+	b.WriteString(`<meta property="og:image" content="` + image + `">`)
+	b.WriteString(fmt.Sprintf(`<meta property="og:image:width" content="%d"><meta property="og:image:height" content="%d">`, ogImageWidth, ogImageHeight))
+	b.WriteString(`<meta name="twitter:image" content="` + image + `">`)
+	return b.String()
+}
+
+func (s *server) getTheme(c *gin.Context) {
+	c.JSON(http.StatusOK, s.siteSettings().Theme)
+}
+
+func (s *server) updateTheme(c *gin.Context) {
+	st := s.siteSettings()
+	t := st.Theme
+	if err := c.BindJSON(&t); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	// assets only change through the upload endpoints
+	t.Logo, t.LogoDark, t.Favicon, t.Icons = st.Theme.Logo, st.Theme.LogoDark, st.Theme.Favicon, st.Theme.Icons
+	st.Theme = t
+	s.saveTheme(c, st)
+}
+
+// uploadThemeAsset stores the multipart "file" as the logo, dark logo or
+// favicon. Files are named by content hash so every upload gets a fresh URL
+// and /media can keep caching them for long. A new logo or favicon also
+// regenerates the site icons.
+func (s *server) uploadThemeAsset(c *gin.Context) {
+	field, ok := themeAssets[c.Param("asset")]
+	if !ok {
+		respondError(c, http.StatusNotFound, errInvalidThemeAsset)
+		return
+	}
+	if s.mediaDir == "" {
+		respondError(c, http.StatusServiceUnavailable, errThemeAssetsDisabled)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxThemeAssetBytes+1<<20)
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, http.StatusRequestEntityTooLarge, errFileTooLarge)
+			return
+		}
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxThemeAssetBytes+1))
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	if len(data) > maxThemeAssetBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+	ext, ok := themeImageTypes[http.DetectContentType(data)]
+	if !ok {
+		respondError(c, http.StatusUnsupportedMediaType, errUnsupportedImage)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	name := c.Param("asset") + "-" + hex.EncodeToString(sum[:6]) + ext
+	dir := filepath.Join(s.mediaDir, themeSubdir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(dir, name), data); err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errUploadFailed, err)
+		return
+	}
+	st := s.siteSettings()
+	*field(&st.Theme) = s.basePath + "/media/" + themeSubdir + "/" + name
+	if c.Param("asset") != "logoDark" {
+		s.refreshIcons(&st.Theme)
+	}
+	s.saveTheme(c, st)
+}
+
+// deleteThemeAsset unsets an asset. The file stays on disk: cached pages
+// may still point at it.
+func (s *server) deleteThemeAsset(c *gin.Context) {
+	field, ok := themeAssets[c.Param("asset")]
+	if !ok {
+		respondError(c, http.StatusNotFound, errInvalidThemeAsset)
+		return
+	}
+	st := s.siteSettings()
+	*field(&st.Theme) = ""
+	if c.Param("asset") != "logoDark" {
+		s.refreshIcons(&st.Theme)
+	}
+	s.saveTheme(c, st)
+}
+
+func (s *server) saveTheme(c *gin.Context, st siteSettings) {
+	if err := st.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	if err := s.saveSettings(c.Request.Context(), st); err != nil {
+		respondError(c, http.StatusInternalServerError, errSaveSettingsFailed)
+		return
+	}
+	s.publishFrom(c, eventSettingsChanged, actionUpdated, siteSettingsKey, "")
+	c.JSON(http.StatusOK, st.Theme)
+}
+
+// ogImageCache keeps the rendered OG images of the current theme.
+type ogImageCache struct {
+	mu      sync.Mutex
+	version string
+	images  map[string][]byte
+}
+
+func (oc *ogImageCache) get(version, scheme string, render func() ([]byte, error)) ([]byte, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if oc.version != version {
+		oc.version, oc.images = version, map[string][]byte{}
+	}
+	if img, ok := oc.images[scheme]; ok {
+		return img, nil
+	}
+	img, err := render()
+	if err != nil {
+		return nil, err
+	}
+	oc.images[scheme] = img
+	return img, nil
+}
+
+// ogImageHandler serves /og-image.png, the site's link preview image in the
+// light or dark variant (?scheme=).
+func (s *server) ogImageHandler(c *gin.Context) {
+	scheme := "light"
+	if c.Query("scheme") == "dark" {
+		scheme = "dark"
+	}
+	t := s.siteSettings().Theme
+	img, err := s.ogImages.get(t.version(), scheme, func() ([]byte, error) {
+		return renderOGImage(t, scheme, s.themeLogo(t, scheme))
+	})
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "image/png", img)
+}
+
+// themeLogo decodes the logo for scheme from the media directory.
+func (s *server) themeLogo(t themeSettings, scheme string) image.Image {
+	if scheme == "dark" && t.LogoDark != "" {
+		return s.decodeThemeAsset(t.LogoDark)
+	}
+	return s.decodeThemeAsset(t.Logo)
+}
+
+// decodeThemeAsset reads an uploaded asset back from the media directory;
+// nil when there is none or it isn't a PNG, JPEG or GIF.
+func (s *server) decodeThemeAsset(src string) image.Image {
+	_, rel, ok := strings.Cut(src, "/media/")
+	if !ok || s.mediaDir == "" {
+		return nil
+	}
+	full, ok := safeJoin(s.mediaDir, rel)
+	if !ok {
+		return nil
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	logo, _, err := image.Decode(f)
+	if err != nil {
+		return nil
+	}
+	return logo
+}
+
+// renderOGImage draws the 1200×630 card: a light or dark canvas, the logo
+// centred and an accent bar along the bottom.
+func renderOGImage(t themeSettings, scheme string, logo image.Image) ([]byte, error) {
+	bg := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	if scheme == "dark" {
+		bg = parseHexColor(darkBackground)
+	}
+	accent := parseHexColor(t.accent(scheme))
+	canvas := image.NewRGBA(image.Rect(0, 0, ogImageWidth, ogImageHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	bar := image.Rect(0, ogImageHeight-24, ogImageWidth, ogImageHeight)
+	draw.Draw(canvas, bar, image.NewUniform(accent), image.Point{}, draw.Src)
+	if logo != nil {
+		scaled := scaleToFit(logo, 420, 360)
+		size := scaled.Bounds().Size()
+		at := image.Pt((ogImageWidth-size.X)/2, (ogImageHeight-24-size.Y)/2)
+		draw.Draw(canvas, image.Rectangle{Min: at, Max: at.Add(size)}, scaled, image.Point{}, draw.Over)
+	} else {
+		dot := image.Rect(ogImageWidth/2-60, (ogImageHeight-24)/2-60, ogImageWidth/2+60, (ogImageHeight-24)/2+60)
+		draw.Draw(canvas, dot, image.NewUniform(accent), image.Point{}, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, canvas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleToFit shrinks src to fit within w×h, keeping its aspect ratio.
+// Images that already fit are only copied.
+func scaleToFit(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > w || sh > h {
+		if sw*h > sh*w {
+			dw, dh = w, max(1, sh*w/sw)
+		} else {
+			dw, dh = max(1, sw*h/sh), h
+		}
+	}
+	return resize(src, dw, dh)
+}
+
+// resize scales src to dw×dh by averaging the source pixels under each
+// target pixel, which keeps small icons legible; enlarging degrades to
+// nearest neighbour.
+func resize(src image.Image, dw, dh int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		y1 = max(y1, y0+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			x1 = max(x1, x0+1)
+			var r, g, bl, a uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// parseHexColor reads a normalized #rrggbb color.
+func parseHexColor(c string) color.RGBA {
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(c, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{0, 0, 0, 0xff}
+	}
+	return color.RGBA{r, g, b, 0xff}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestThemeSettingsNormalize(t *testing.T) {
+	th := themeSettings{AccentColor: " #ABC ", AccentColorDark: "#112233", OGScheme: "Dark"}
+	if err := th.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if th.AccentColor != "#aabbcc" || th.OGScheme != "dark" {
+		t.Fatalf("normalized = %+v", th)
+	}
+	for _, bad := range []themeSettings{
+		{AccentColor: "red"},
+		{AccentColorDark: "#12345"},
+		{OGScheme: "sepia"},
+		{Logo: "https://evil.example/logo.png"},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("%+v should be rejected", bad)
+		}
+	}
+}
+
+func TestThemeHead(t *testing.T) {
+	s := &server{}
+	head := s.themeHead(themeSettings{AccentColor: "#aabbcc", AccentColorDark: "#112233", Favicon: "/media/theme/favicon-1.png"}, "https://b.example")
+	for _, want := range []string{
+		`<link rel="icon" href="/media/theme/favicon-1.png">`,
+		`media="(prefers-color-scheme: dark)" content="#112233"`,
+		`<meta property="og:image" content="https://b.example/og-image.png?scheme=light&amp;v=`,
+	} {
+		if !strings.Contains(head, want) {
+			t.Errorf("missing %q in %s", want, head)
+		}
+	}
+	if a, b := (themeSettings{}).version(), (themeSettings{AccentColor: "#000000"}).version(); a == b {
+		t.Error("version should change with the theme")
+	}
+}
+
+func TestRenderOGImage(t *testing.T) {
+	logo := image.NewRGBA(image.Rect(0, 0, 800, 200))
+	for i := range logo.Pix {
+		logo.Pix[i] = 0xff
+	}
+	raw, err := renderOGImage(themeSettings{AccentColorDark: "#ff0000"}, "dark", logo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != ogImageWidth || img.Bounds().Dy() != ogImageHeight {
+		t.Fatalf("size = %v", img.Bounds())
+	}
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != parseHexColor(darkBackground) {
+		t.Errorf("background = %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(0, ogImageHeight-1)); got != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("accent bar = %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(ogImageWidth/2, (ogImageHeight-24)/2)); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("logo centre = %v", got)
+	}
+	if b := scaleToFit(logo, 420, 360).Bounds(); b.Dx() != 420 || b.Dy() != 105 {
+		t.Errorf("scaled logo = %v", b)
+	}
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type timelineMonth struct {
+	Month int `json:"month"`
+	Count int `json:"count"`
+}
+
+type timelineYear struct {
+	Year   int             `json:"year"`
+	Count  int             `json:"count"`
+	Months []timelineMonth `json:"months"`
+}
+
+func (s *server) queryTimeline(ctx context.Context) ([]timelineYear, error) {
+	rows, err := s.readQuery(ctx, `
+		SELECT EXTRACT(YEAR FROM local_ts)::int AS y, EXTRACT(MONTH FROM local_ts)::int AS m, COUNT(*) AS count
+		FROM (
+			SELECT COALESCE(published_at, created_at) AT TIME ZONE COALESCE(NULLIF($1, ''), current_setting('TimeZone')) AS local_ts
+			FROM articles
+			WHERE status='published' AND type='post' AND visibility <> 'unlisted'
+		) t
+		GROUP BY y, m
+		ORDER BY y DESC, m DESC`, s.siteTimezoneSQL())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var years []timelineYear
+	for rows.Next() {
+		var y, m, count int
+		if err := rows.Scan(&y, &m, &count); err != nil {
+			return nil, err
+		}
+		if len(years) == 0 || years[len(years)-1].Year != y {
+			years = append(years, timelineYear{Year: y})
+		}
+		cur := &years[len(years)-1]
+		cur.Count += count
+		cur.Months = append(cur.Months, timelineMonth{Month: m, Count: count})
+	}
+	return years, rows.Err()
+}
+
+func (s *server) queryPostsByMonth(ctx context.Context, year, month int) ([]article, error) {
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, s.siteLocation())
+	end := start.AddDate(0, 1, 0)
+	rows, err := s.readQuery(ctx, `
+		SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status,
+		       '' AS body_md, '' AS body_html, art.published_at, art.created_at, art.updated_at
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		WHERE art.status='published' AND art.type='post' AND art.visibility <> 'unlisted'
+		  AND COALESCE(art.published_at, art.created_at) >= $1
+		  AND COALESCE(art.published_at, art.created_at) < $2
+		ORDER BY COALESCE(art.published_at, art.created_at) DESC, art.created_at DESC`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []article
+	for rows.Next() {
+		var a article
+		var publishedAt sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &a.Archive, &a.Status, &a.BodyMD, &a.BodyHTML, &publishedAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if publishedAt.Valid {
+			a.PublishedAt = &publishedAt.Time
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+func parseYearMonth(yearStr, monthStr string) (int, int, bool) {
+	year, err := strconv.Atoi(strings.TrimSpace(yearStr))
+	if err != nil || year < 1970 || year > 9999 {
+		return 0, 0, false
+	}
+	month, err := strconv.Atoi(strings.TrimSpace(monthStr))
+	if err != nil || month < 1 || month > 12 {
+		return 0, 0, false
+	}
+	return year, month, true
+}
+
+func (s *server) archiveTimeline(c *gin.Context) {
+	years, err := s.queryTimeline(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryTimelineFailed)
+		return
+	}
+	if years == nil {
+		years = []timelineYear{}
+	}
+	c.JSON(http.StatusOK, years)
+}
+
+func (s *server) seoArchiveMonthHandler(spa fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		siteTitle := s.siteSettings().Title
+		ctx := c.Request.Context()
+		year, month, ok := parseYearMonth(c.Param("year"), c.Param("month"))
+		if !ok {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		posts, err := s.queryPostsByMonth(ctx, year, month)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		base := s.baseURL(c)
+		canonical := fmt.Sprintf("%s/archive/%04d/%02d", base, year, month)
+		label := fmt.Sprintf("%d 年 %d 月", year, month)
+
+		var b strings.Builder
+		b.WriteString(`<section class="mx-auto max-w-3xl px-6 py-8 text-center sm:px-9 md:px-12 lg:px-[10rem]">`)
+		b.WriteString(`<div class="mb-4 inline-flex rounded-[3px] bg-[#3273dc] px-3 py-1 text-sm font-semibold text-white">` + html.EscapeString(label) + `</div>`)
+		for _, it := range posts {
+			b.WriteString(`<div class="pb-6 space-y-1">`)
+			b.WriteString(`<div class="text-[1.4rem] font-bold tracking-[0.09375em]">`)
+			b.WriteString(`<a href="` + s.basePath + `/post/` + urlPathEscape(it.Slug) + `" class="text-[#3273dc] no-underline">` + html.EscapeString(it.Title) + `</a>`)
+			b.WriteString(`</div>`)
+			b.WriteString(`<div class="mt-1 text-xs text-[#aaa]">` + html.EscapeString(s.formatSiteTime(articleFeedDate(it))) + `</div>`)
+			b.WriteString(`</div>`)
+		}
+		b.WriteString(`</section>`)
+
+		title := "归档 - " + label
+		headExtras := seoHead(siteTitle, title, label+"发布的文章", canonical, "website", "")
+
+		s.writeSSR(c, spa, title, headExtras, b.String())
+	}
+}
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API token scopes. A token reaches only the route groups its scopes name;
+// articles:write implies articles:read and admin implies everything.
+// Browser sessions are not scoped.
+const (
+	scopeArticlesRead  = "articles:read"
+	scopeArticlesWrite = "articles:write"
+	scopeMediaWrite    = "media:write"
+	scopeImapRead      = "imap:read"
+	scopeBookmarks     = "bookmarks"
+	scopeAdmin         = "admin"
+
+	// apiTokenPrefix makes tokens recognisable to secret scanners.
+	apiTokenPrefix        = "se_"
+	maxTokenNameRunes     = 100
+	scopesContextKey      = ctxKey("scopes")
+	tokenLastUsedInterval = time.Minute
+)
+
+var errTokenExpired = errors.New("api token expired")
+
+var apiScopes = []string{scopeArticlesRead, scopeArticlesWrite, scopeMediaWrite, scopeImapRead, scopeBookmarks, scopeAdmin}
+
+type scopeSet []string
+
+func (ss scopeSet) allows(scope string) bool {
+	return slices.Contains(ss, scopeAdmin) || slices.Contains(ss, scope) ||
+		(scope == scopeArticlesRead && slices.Contains(ss, scopeArticlesWrite))
+}
+
+// apiToken is a bearer credential acting as its user within Scopes. Only a
+// hash of the secret is stored; it is shown once, on creation.
+type apiToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Username   string     `json:"username"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (s *server) ensureTokenSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT[] NOT NULL DEFAULT '{}',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_used_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
+	`)
+	return err
+}
+
+func newAPIToken() (string, error) {
+	raw := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok && strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// authenticateToken resolves a bearer token to its user and scopes.
+func (s *server) authenticateToken(ctx context.Context, token string) (*user, scopeSet, error) {
+	var (
+		u         user
+		id        string
+		scopes    tagList
+		expiresAt sql.NullTime
+		lastUsed  sql.NullTime
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT t.id, to_json(t.scopes)::text, t.expires_at, t.last_used_at, u.id, u.username, u.password_hash, u.role, u.created_at
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1`, hashSessionToken(token)).
+		Scan(&id, &scopes, &expiresAt, &lastUsed, &u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return nil, nil, errTokenExpired
+	}
+	if !lastUsed.Valid || time.Since(lastUsed.Time) > tokenLastUsedInterval {
+		if _, err := s.db.ExecContext(ctx, `UPDATE api_tokens SET last_used_at=now() WHERE id=$1`, id); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &u, scopeSet(scopes), nil
+}
+
+// ensureTokenUser is ensureUser for requests carrying a bearer token.
+func (s *server) ensureTokenUser(c *gin.Context, token string) (*user, bool) {
+	u, scopes, err := s.authenticateToken(c.Request.Context(), token)
+	switch {
+	case errors.Is(err, errTokenExpired):
+		respondError(c, http.StatusUnauthorized, errSessionExpired)
+		return nil, false
+	case errors.Is(err, sql.ErrNoRows):
+		// unknown or revoked token
+		s.security.loginFailed()
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return nil, false
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, errQueryTokensFailed)
+		return nil, false
+	}
+	c.Set(string(userContextKey), *u)
+	c.Set(string(scopesContextKey), scopes)
+	return u, true
+}
+
+// callerAllows reports whether the request is signed in with a session or
+// with a token holding scope.
+func callerAllows(c *gin.Context, scope string) bool {
+	if _, ok := c.Get(string(userContextKey)); !ok {
+		return false
+	}
+	v, scoped := c.Get(string(scopesContextKey))
+	return !scoped || v.(scopeSet).allows(scope)
+}
+
+// requireScope limits a route group to tokens holding scope.
+func (s *server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if v, ok := c.Get(string(scopesContextKey)); ok && !v.(scopeSet).allows(scope) {
+			respondError(c, http.StatusForbidden, errInsufficientScope)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+const tokenColumns = `t.id, t.user_id, u.username, t.name, to_json(t.scopes)::text, t.created_at, t.last_used_at, t.expires_at`
+
+func scanAPIToken(row interface{ Scan(...any) error }) (apiToken, error) {
+	var t apiToken
+	var scopes tagList
+	if err := row.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+		return t, err
+	}
+	t.Scopes = []string(scopes)
+	if t.Scopes == nil {
+		t.Scopes = []string{}
+	}
+	return t, nil
+}
+
+func (s *server) listTokens(c *gin.Context) {
+	rows, err := s.db.QueryContext(c.Request.Context(), `
+		SELECT `+tokenColumns+` FROM api_tokens t JOIN users u ON u.id = t.user_id ORDER BY t.created_at DESC`)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errQueryTokensFailed)
+		return
+	}
+	defer rows.Close()
+	items := []apiToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryTokensFailed)
+			return
+		}
+		items = append(items, t)
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+type tokenPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays of 0 means the token does not expire.
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+func (p *tokenPayload) normalize() error {
+	var v validator
+	p.Name = strings.TrimSpace(p.Name)
+	if v.check(p.Name != "", "name", errNameRequired) {
+		v.check(maxRunes(p.Name, maxTokenNameRunes), "name", errNameTooLong)
+	}
+	v.check(len(p.Scopes) > 0, "scopes", errInvalidScope)
+	for _, scope := range p.Scopes {
+		v.check(slices.Contains(apiScopes, scope), "scopes", errInvalidScope)
+	}
+	v.check(p.ExpiresInDays >= 0, "expiresInDays", errInvalidBody)
+	slices.Sort(p.Scopes)
+	p.Scopes = slices.Compact(p.Scopes)
+	return v.err()
+}
+
+// createToken issues a token for the calling user and returns the secret,
+// which cannot be read back later.
+func (s *server) createToken(c *gin.Context) {
+	u, ok := s.ensureUser(c)
+	if !ok {
+		return
+	}
+	var payload tokenPayload
+	if err := c.BindJSON(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	if err := payload.normalize(); err != nil {
+		respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, err)
+		return
+	}
+	t, secret, err := issueToken(c.Request.Context(), s.db, u.ID, payload)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTokenFailed, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "info": t})
+}
+
+func issueToken(ctx context.Context, q sqlQuerier, userID string, p tokenPayload) (apiToken, string, error) {
+	secret, err := newAPIToken()
+	if err != nil {
+		return apiToken{}, "", err
+	}
+	var expires *time.Time
+	if p.ExpiresInDays > 0 {
+		at := time.Now().AddDate(0, 0, p.ExpiresInDays)
+		expires = &at
+	}
+	t, err := scanAPIToken(q.QueryRowContext(ctx, `
+		WITH t AS (
+			INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at) VALUES ($1, $2, $3, $4::text[], $5)
+			RETURNING *
+		)
+		SELECT `+tokenColumns+` FROM t JOIN users u ON u.id = t.user_id`,
+		userID, p.Name, hashSessionToken(secret), p.Scopes, expires))
+	return t, secret, err
+}
+
+func (s *server) revokeToken(c *gin.Context) {
+	id, ok := idParam(c, "id", errTokenNotFound)
+	if !ok {
+		return
+	}
+	res, err := s.db.ExecContext(c.Request.Context(), `DELETE FROM api_tokens WHERE id=$1`, id)
+	if err != nil {
+		respondErrorDetail(c, http.StatusInternalServerError, errSaveTokenFailed, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(c, http.StatusNotFound, errTokenNotFound)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
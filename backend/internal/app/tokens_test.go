@@ -0,0 +1,101 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScopeSetAllows(t *testing.T) {
+	cases := []struct {
+		scopes scopeSet
+		scope  string
+		want   bool
+	}{
+		{scopeSet{scopeArticlesRead}, scopeArticlesRead, true},
+		{scopeSet{scopeArticlesRead}, scopeArticlesWrite, false},
+		{scopeSet{scopeArticlesWrite}, scopeArticlesRead, true},
+		{scopeSet{scopeMediaWrite}, scopeArticlesRead, false},
+		{scopeSet{scopeAdmin}, scopeImapRead, true},
+		{scopeSet{}, scopeArticlesRead, false},
+	}
+	for _, tc := range cases {
+		if got := tc.scopes.allows(tc.scope); got != tc.want {
+			t.Errorf("%v.allows(%s) = %v, want %v", tc.scopes, tc.scope, got, tc.want)
+		}
+	}
+}
+
+func TestTokenPayloadNormalize(t *testing.T) {
+	p := tokenPayload{Name: "  ci  ", Scopes: []string{scopeMediaWrite, scopeArticlesRead, scopeMediaWrite}}
+	if err := p.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "ci" || len(p.Scopes) != 2 || p.Scopes[0] != scopeArticlesRead {
+		t.Fatalf("normalized = %+v", p)
+	}
+	for _, bad := range []tokenPayload{
+		{Name: "", Scopes: []string{scopeAdmin}},
+		{Name: "x", Scopes: nil},
+		{Name: "x", Scopes: []string{"everything"}},
+		{Name: "x", Scopes: []string{scopeAdmin}, ExpiresInDays: -1},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("normalize(%+v) accepted", bad)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{}
+	run := func(scopes scopeSet) int {
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			if scopes != nil {
+				c.Set(string(scopesContextKey), scopes)
+			}
+		})
+		r.GET("/", s.requireScope(scopeArticlesWrite), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+	if code := run(nil); code != http.StatusNoContent {
+		t.Fatalf("session request: %d", code)
+	}
+	if code := run(scopeSet{scopeArticlesWrite}); code != http.StatusNoContent {
+		t.Fatalf("scoped token: %d", code)
+	}
+	if code := run(scopeSet{scopeArticlesRead}); code != http.StatusForbidden {
+		t.Fatalf("read-only token: %d", code)
+	}
+}
+
+func TestCallerAllows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := func(signedIn bool, scopes scopeSet) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if signedIn {
+			c.Set(string(userContextKey), user{Username: "admin"})
+		}
+		if scopes != nil {
+			c.Set(string(scopesContextKey), scopes)
+		}
+		return c
+	}
+	if callerAllows(ctx(false, nil), scopeImapRead) {
+		t.Fatal("anonymous caller allowed")
+	}
+	if !callerAllows(ctx(true, nil), scopeImapRead) {
+		t.Fatal("session caller denied")
+	}
+	if callerAllows(ctx(true, scopeSet{scopeArticlesRead}), scopeImapRead) {
+		t.Fatal("token without imap:read allowed")
+	}
+	if !callerAllows(ctx(true, scopeSet{scopeImapRead}), scopeImapRead) {
+		t.Fatal("imap:read token denied")
+	}
+}
@@ -0,0 +1,275 @@
+package app
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// analyticsConfig turns on the built-in page view rollups. SampleRate (0, 1]
+// records only that share of views and scales the counts back up; GeoIPDB
+// points at an optional IP-to-country CSV (see geoIPDB).
+type analyticsConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	SampleRate float64 `yaml:"sampleRate"`
+	GeoIPDB    string  `yaml:"geoipDb"`
+}
+
+// trafficFlushInterval is how often buffered counts are written out.
+const trafficFlushInterval = 30 * time.Second
+
+// trafficKey is one rollup row: views are summed per day and dimension.
+type trafficKey struct {
+	day      string
+	path     string
+	slug     string
+	referrer string
+	uaClass  string
+	country  string
+}
+
+// trafficRecorder buffers sampled page views in memory and flushes them into
+// traffic_daily, so a page view costs a map update rather than a query.
+type trafficRecorder struct {
+	rate float64
+	geo  *geoIPDB
+
+	mu      sync.Mutex
+	pending map[trafficKey]float64
+}
+
+// newTrafficRecorder returns nil when analytics are off. A GeoIP file that
+// fails to load only disables the country column.
+func newTrafficRecorder(cfg analyticsConfig, cfgPath string) *trafficRecorder {
+	if !cfg.Enabled {
+		return nil
+	}
+	rate := cfg.SampleRate
+	if rate <= 0 || rate > 1 {
+		rate = 1
+	}
+	t := &trafficRecorder{rate: rate, pending: make(map[trafficKey]float64)}
+	if path := resolveMediaDir(cfgPath, cfg.GeoIPDB); path != "" {
+		db, err := loadGeoIPDB(path)
+		if err != nil {
+			warnf("加载 GeoIP 数据库 %s 失败，不记录国家: %v\n", path, err)
+		} else {
+			t.geo = db
+		}
+	}
+	return t
+}
+
+func (t *trafficRecorder) add(k trafficKey) {
+	t.mu.Lock()
+	t.pending[k] += 1 / t.rate
+	t.mu.Unlock()
+}
+
+func (t *trafficRecorder) drain() map[trafficKey]float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := t.pending
+	t.pending = make(map[trafficKey]float64)
+	return out
+}
+
+func (s *server) ensureTrafficSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS traffic_daily (
+			day DATE NOT NULL,
+			path TEXT NOT NULL,
+			slug TEXT NOT NULL DEFAULT '',
+			referrer TEXT NOT NULL DEFAULT '',
+			ua_class TEXT NOT NULL DEFAULT '',
+			country TEXT NOT NULL DEFAULT '',
+			views DOUBLE PRECISION NOT NULL DEFAULT 0,
+			PRIMARY KEY (day, path, referrer, ua_class, country)
+		);
+		CREATE INDEX IF NOT EXISTS idx_traffic_daily_slug ON traffic_daily(slug, day) WHERE slug <> '';
+	`)
+	return err
+}
+
+// accessLogMiddleware samples successful page and feed GETs into the traffic
+// rollup. Checking the response content type after the handler keeps API
+// calls, assets and media out without listing every route; feeds are kept so
+// feed readers show up in the crawler breakdown.
+func (s *server) accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		t := s.traffic
+		if t == nil || c.Request.Method != http.MethodGet || c.Writer.Status() != http.StatusOK {
+			return
+		}
+		if !countedContentType(c.Writer.Header().Get("Content-Type")) {
+			return
+		}
+		if t.rate < 1 && rand.Float64() >= t.rate {
+			return
+		}
+		path := c.Request.URL.Path
+		t.add(trafficKey{
+			day:      time.Now().In(s.siteLocation()).Format("2006-01-02"),
+			path:     path,
+			slug:     s.postSlugFromPath(path),
+			referrer: s.referrerHost(c.Request.Referer(), c.Request.Host),
+			uaClass:  uaClass(c.Request.UserAgent()),
+			country:  t.geo.country(c.ClientIP()),
+		})
+	}
+}
+
+func countedContentType(ct string) bool {
+	mt, _, _ := strings.Cut(ct, ";")
+	switch strings.TrimSpace(mt) {
+	case "text/html", "application/rss+xml", "application/atom+xml":
+		return true
+	}
+	return false
+}
+
+// postSlugFromPath returns the slug for /post/<slug> paths under basePath.
+func (s *server) postSlugFromPath(p string) string {
+	rest, ok := strings.CutPrefix(p, s.basePath+"/post/")
+	if !ok || rest == "" || strings.Contains(rest, "/") {
+		return ""
+	}
+	if slug, err := url.PathUnescape(rest); err == nil {
+		return slug
+	}
+	return rest
+}
+
+// referrerHost reduces a Referer to its host, dropping our own host so
+// internal navigation doesn't show up as a referrer.
+func (s *server) referrerHost(ref, host string) string {
+	u, err := url.Parse(ref)
+	if ref == "" || err != nil || u.Hostname() == "" {
+		return ""
+	}
+	h := strings.ToLower(u.Hostname())
+	if strings.EqualFold(h, hostOnly(host)) || strings.EqualFold(h, s.canonicalHostname()) {
+		return ""
+	}
+	return strings.TrimPrefix(h, "www.")
+}
+
+func hostOnly(hostport string) string {
+	if h, _, ok := strings.Cut(hostport, ":"); ok && !strings.HasPrefix(hostport, "[") {
+		return h
+	}
+	return strings.Trim(hostport, "[]")
+}
+
+// runTrafficFlusher writes buffered counts every trafficFlushInterval.
+func (s *server) runTrafficFlusher() {
+	if s.traffic == nil {
+		return
+	}
+	ticker := time.NewTicker(trafficFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.flushTraffic(context.Background()); err != nil {
+			warnf("写入访问统计失败: %v\n", err)
+		}
+	}
+}
+
+func (s *server) flushTraffic(ctx context.Context) error {
+	batch := s.traffic.drain()
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for k, views := range batch {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO traffic_daily (day, path, slug, referrer, ua_class, country, views)
+			VALUES ($1::date, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (day, path, referrer, ua_class, country) DO UPDATE
+			SET views = traffic_daily.views + EXCLUDED.views`,
+			k.day, k.path, k.slug, k.referrer, k.uaClass, k.country, views)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// trafficCount is one report row; Human excludes crawlers, feed readers and
+// other automated clients (see classifyUA).
+type trafficCount struct {
+	Key   string  `json:"key"`
+	Title string  `json:"title,omitempty"`
+	Views float64 `json:"views"`
+	Human float64 `json:"human"`
+}
+
+// adminTraffic summarises the last ?days= (default 30, max 365) of page
+// views per day, per post, and by referrer, UA class, automated agent and
+// country, each with the human share split out.
+func (s *server) adminTraffic(c *gin.Context) {
+	days := 30
+	if d, err := strconv.Atoi(strings.TrimSpace(c.Query("days"))); err == nil && d > 0 && d <= 365 {
+		days = d
+	}
+	ctx := c.Request.Context()
+	since := time.Now().In(s.siteLocation()).AddDate(0, 0, 1-days).Format("2006-01-02")
+
+	const sums = `SUM(views), COALESCE(SUM(views) FILTER (WHERE ` + humanTrafficCond + `), 0)`
+	queries := []struct {
+		name  string
+		query string
+	}{
+		{"days", `SELECT day::text, '', ` + sums + ` FROM traffic_daily WHERE day >= $1::date GROUP BY day ORDER BY day`},
+		{"posts", `
+			SELECT t.slug, COALESCE(MAX(a.title), ''), ` + sums + `
+			FROM traffic_daily t LEFT JOIN articles a ON a.slug = t.slug
+			WHERE t.day >= $1::date AND t.slug <> ''
+			GROUP BY t.slug ORDER BY 4 DESC, 3 DESC LIMIT 50`},
+		{"referrers", `SELECT referrer, '', ` + sums + ` FROM traffic_daily WHERE day >= $1::date AND referrer <> '' GROUP BY referrer ORDER BY 4 DESC, 3 DESC LIMIT 50`},
+		{"uaClasses", `SELECT split_part(ua_class, ':', 1), '', ` + sums + ` FROM traffic_daily WHERE day >= $1::date GROUP BY 1 ORDER BY 3 DESC`},
+		{"agents", `SELECT ua_class, '', ` + sums + ` FROM traffic_daily WHERE day >= $1::date AND ua_class LIKE '%:%' GROUP BY ua_class ORDER BY 3 DESC LIMIT 50`},
+		{"countries", `SELECT country, '', ` + sums + ` FROM traffic_daily WHERE day >= $1::date AND country <> '' GROUP BY country ORDER BY 4 DESC, 3 DESC LIMIT 50`},
+	}
+	out := gin.H{"enabled": s.traffic != nil, "since": since}
+	for _, q := range queries {
+		items, err := s.trafficCounts(ctx, q.query, since)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errQueryTrafficFailed)
+			return
+		}
+		out[q.name] = items
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (s *server) trafficCounts(ctx context.Context, query, since string) ([]trafficCount, error) {
+	rows, err := s.readQuery(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []trafficCount{}
+	for rows.Next() {
+		var tc trafficCount
+		if err := rows.Scan(&tc.Key, &tc.Title, &tc.Views, &tc.Human); err != nil {
+			return nil, err
+		}
+		items = append(items, tc)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeoIPLookup(t *testing.T) {
+	db, err := parseGeoIPDB(strings.NewReader(
+		"1.0.0.0,1.0.0.255,AU\n" +
+			"\"8.8.8.0\",\"8.8.8.255\",\"us\"\n" +
+			"2001:db8::,2001:db8::ffff,DE\n" +
+			"9.0.0.0,9.0.0.255,ZZ\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"1.0.0.7":        "AU",
+		"8.8.8.8":        "US",
+		"::ffff:8.8.8.8": "US",
+		"8.8.9.1":        "",
+		"9.0.0.1":        "",
+		"2001:db8::1":    "DE",
+		"2001:db8::1:0":  "",
+		"not-an-ip":      "",
+		"0.0.0.1":        "",
+	}
+	for ip, want := range cases {
+		if got := db.country(ip); got != want {
+			t.Errorf("country(%q) = %q, want %q", ip, got, want)
+		}
+	}
+	if _, err := parseGeoIPDB(strings.NewReader("1.0.0.9,1.0.0.1,AU\n")); err == nil {
+		t.Error("inverted range accepted")
+	}
+}
+
+func TestTrafficDimensions(t *testing.T) {
+	s := &server{basePath: "/blog"}
+	if got := s.postSlugFromPath("/blog/post/hello%20world"); got != "hello world" {
+		t.Errorf("slug = %q", got)
+	}
+	for _, p := range []string{"/blog/post/", "/blog/post/a/b", "/post/x", "/blog/archive"} {
+		if got := s.postSlugFromPath(p); got != "" {
+			t.Errorf("postSlugFromPath(%q) = %q", p, got)
+		}
+	}
+	if got := s.referrerHost("https://www.example.org/x?y", "blog.test:8080"); got != "example.org" {
+		t.Errorf("referrer = %q", got)
+	}
+	if got := s.referrerHost("https://blog.test/post/a", "blog.test:8080"); got != "" {
+		t.Errorf("internal referrer = %q", got)
+	}
+	if !countedContentType("text/html; charset=utf-8") || !countedContentType("application/rss+xml") ||
+		countedContentType("application/json; charset=utf-8") {
+		t.Error("countedContentType filter wrong")
+	}
+}
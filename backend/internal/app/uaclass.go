@@ -0,0 +1,108 @@
+package app
+
+import "strings"
+
+// UA classes stored in traffic_daily.ua_class. Automated traffic is stored as
+// "<class>:<agent>" (e.g. "ai:gptbot") so reports can group either way.
+const (
+	uaDesktop = "desktop"
+	uaMobile  = "mobile"
+	uaUnknown = "unknown"
+	uaSearch  = "search"
+	uaAI      = "ai"
+	uaFeed    = "feed"
+	uaBot     = "bot"
+)
+
+// humanTrafficCond selects the rows counted as human page views.
+const humanTrafficCond = `split_part(ua_class, ':', 1) IN ('desktop', 'mobile')`
+
+type knownAgent struct {
+	token, class, name string
+}
+
+// knownAgents is checked in order against the lowercased UA, so more specific
+// tokens go first (e.g. "googlebot-image" style variants share "googlebot").
+var knownAgents = []knownAgent{
+	// AI training and answer-engine crawlers
+	{"gptbot", uaAI, "gptbot"},
+	{"oai-searchbot", uaAI, "oai-searchbot"},
+	{"chatgpt-user", uaAI, "chatgpt-user"},
+	{"claudebot", uaAI, "claudebot"},
+	{"claude-web", uaAI, "claude-web"},
+	{"anthropic-ai", uaAI, "anthropic-ai"},
+	{"perplexitybot", uaAI, "perplexitybot"},
+	{"google-extended", uaAI, "google-extended"},
+	{"ccbot", uaAI, "ccbot"},
+	{"bytespider", uaAI, "bytespider"},
+	{"amazonbot", uaAI, "amazonbot"},
+	{"applebot-extended", uaAI, "applebot-extended"},
+	{"meta-externalagent", uaAI, "meta-externalagent"},
+	{"cohere-ai", uaAI, "cohere-ai"},
+	// search engines
+	{"googlebot", uaSearch, "googlebot"},
+	{"bingbot", uaSearch, "bingbot"},
+	{"duckduckbot", uaSearch, "duckduckbot"},
+	{"baiduspider", uaSearch, "baiduspider"},
+	{"yandexbot", uaSearch, "yandexbot"},
+	{"sogou", uaSearch, "sogou"},
+	{"360spider", uaSearch, "360spider"},
+	{"applebot", uaSearch, "applebot"},
+	{"petalbot", uaSearch, "petalbot"},
+	// feed readers
+	{"feedly", uaFeed, "feedly"},
+	{"inoreader", uaFeed, "inoreader"},
+	{"newsblur", uaFeed, "newsblur"},
+	{"feedbin", uaFeed, "feedbin"},
+	{"theoldreader", uaFeed, "theoldreader"},
+	{"miniflux", uaFeed, "miniflux"},
+	{"freshrss", uaFeed, "freshrss"},
+	{"tiny tiny rss", uaFeed, "tt-rss"},
+	{"netnewswire", uaFeed, "netnewswire"},
+	{"reeder", uaFeed, "reeder"},
+	{"feedburner", uaFeed, "feedburner"},
+	{"feedfetcher", uaFeed, "feedfetcher"},
+}
+
+// genericBotTokens mark automated clients we don't name individually.
+var genericBotTokens = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "fetch", "scan",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client",
+	"okhttp", "java/", "libwww", "httpclient", "headless",
+}
+
+// classifyUA buckets a user agent into a class and, for automated clients we
+// recognise, the agent name.
+func classifyUA(ua string) (class, agent string) {
+	l := strings.ToLower(strings.TrimSpace(ua))
+	if l == "" {
+		return uaUnknown, ""
+	}
+	for _, a := range knownAgents {
+		if strings.Contains(l, a.token) {
+			return a.class, a.name
+		}
+	}
+	// feed readers that only announce themselves by subscriber count
+	if strings.Contains(l, "subscriber") && (strings.Contains(l, "feed") || strings.Contains(l, "rss")) {
+		return uaFeed, ""
+	}
+	for _, tok := range genericBotTokens {
+		if strings.Contains(l, tok) {
+			return uaBot, ""
+		}
+	}
+	if strings.Contains(l, "mobi") || strings.Contains(l, "android") {
+		return uaMobile, ""
+	}
+	return uaDesktop, ""
+}
+
+// uaClass is the traffic_daily.ua_class value for ua.
+func uaClass(ua string) string {
+	class, agent := classifyUA(ua)
+	if agent != "" {
+		return class + ":" + agent
+	}
+	return class
+}
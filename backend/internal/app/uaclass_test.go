@@ -0,0 +1,23 @@
+package app
+
+import "testing"
+
+func TestUAClass(t *testing.T) {
+	cases := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/124.0 Safari/537.36": "desktop",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148 Safari/604.1":       "mobile",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                "search:googlebot",
+		"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; GPTBot/1.2)":              "ai:gptbot",
+		"Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; ClaudeBot/1.0)":           "ai:claudebot",
+		"Feedly/1.0 (+http://www.feedly.com/fetcher.html; 42 subscribers)":                        "feed:feedly",
+		"SomeReader/2.0 (+https://reader.example; 3 subscribers; feed-id=1)":                      "feed",
+		"curl/8.4.0":         "bot",
+		"Go-http-client/1.1": "bot",
+		"":                   "unknown",
+	}
+	for ua, want := range cases {
+		if got := uaClass(ua); got != want {
+			t.Errorf("uaClass(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
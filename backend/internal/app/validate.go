@@ -0,0 +1,119 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxTitleRunes       = 200
+	maxSlugRunes        = 96
+	maxArchiveNameRunes = 64
+	maxArchiveDescRunes = 500
+	maxUsernameRunes    = 64
+	maxLoginPassword    = 72 // bcrypt ignores anything longer
+)
+
+// fieldError is one rejected field. Field is the JSON path of the input
+// ("title", "socialLinks[2].url"); the message is localized when written.
+type fieldError struct {
+	Field  string
+	Code   errCode
+	Detail string
+}
+
+// validationErrors collects every problem in a payload so an editor can mark
+// all offending fields after one round trip. It is only returned non-empty.
+type validationErrors []fieldError
+
+// validator accumulates field errors; err returns them, or nil.
+type validator struct {
+	errs validationErrors
+}
+
+func (v *validator) add(field string, code errCode, detail ...string) {
+	v.errs = append(v.errs, fieldError{Field: field, Code: code, Detail: strings.Join(detail, " ")})
+}
+
+// check adds the error when ok is false and reports ok.
+func (v *validator) check(ok bool, field string, code errCode, detail ...string) bool {
+	if !ok {
+		v.add(field, code, detail...)
+	}
+	return ok
+}
+
+// wrap records err from a nested normalizer under field, keeping its code
+// when it has one.
+func (v *validator) wrap(field string, err error) {
+	if err == nil {
+		return
+	}
+	var ae *apiError
+	if errors.As(err, &ae) {
+		v.add(field, ae.code, ae.detail)
+		return
+	}
+	v.add(field, errInvalidBody, err.Error())
+}
+
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func (e validationErrors) Error() string {
+	_, msg := describeError(defaultLanguage, errInvalidBody, e)
+	return msg
+}
+
+// respondValidation writes the first problem as error and code, like any
+// other API error, plus a fields list with all of them.
+func respondValidation(c *gin.Context, status int, errs validationErrors) {
+	lang := requestLanguage(c)
+	fields := make([]gin.H, len(errs))
+	for i, fe := range errs {
+		fields[i] = gin.H{"field": fe.Field, "code": fe.Code, "error": localizeError(lang, fe.Code, fe.Detail)}
+	}
+	first := errs[0]
+	c.JSON(status, gin.H{"error": localizeError(lang, first.Code, first.Detail), "code": first.Code, "fields": fields})
+}
+
+func maxRunes(s string, n int) bool {
+	return utf8.RuneCountInString(s) <= n
+}
+
+// validSlugInput accepts what makeSlug can turn into a URL segment: it is
+// transliterated and lowercased later, but characters that carry URL meaning
+// are refused rather than silently dropped.
+func validSlugInput(s string) bool {
+	if !maxRunes(s, maxSlugRunes) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\?#%&=+"'<>`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// idParam reads the UUID path parameter name. A malformed id names no row, so
+// it is answered 404 with code and ok is false.
+func idParam(c *gin.Context, name string, code errCode) (string, bool) {
+	id := c.Param(name)
+	if !store.ValidID(id) {
+		respondError(c, http.StatusNotFound, code)
+		return "", false
+	}
+	return id, true
+}
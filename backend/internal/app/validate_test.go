@@ -0,0 +1,79 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidatePayloadCollectsAllFields(t *testing.T) {
+	tags := make([]string, maxArticleTags+1)
+	for i := range tags {
+		tags[i] = strings.Repeat("t", i+1)
+	}
+	bad := "sideways"
+	err := validatePayload(articlePayload{
+		Title:      strings.Repeat("长", maxTitleRunes+1),
+		Slug:       "a/b",
+		Status:     "live",
+		Tags:       &tags,
+		Visibility: &bad,
+	})
+	var ve validationErrors
+	if !errors.As(err, &ve) {
+		t.Fatalf("want validationErrors, got %v", err)
+	}
+	got := map[string]errCode{}
+	for _, fe := range ve {
+		got[fe.Field] = fe.Code
+	}
+	want := map[string]errCode{
+		"title": errTitleTooLong, "slug": errInvalidSlug, "status": errInvalidStatus,
+		"tags": errTooManyTags, "visibility": errInvalidVisibility,
+	}
+	for field, code := range want {
+		if got[field] != code {
+			t.Errorf("%s: got %q, want %q", field, got[field], code)
+		}
+	}
+	if len(ve) != len(want) {
+		t.Errorf("unexpected extra errors: %v", ve)
+	}
+
+	dup := []string{"Go", "go", "#go"}
+	if err := validatePayload(articlePayload{Title: "ok", Slug: "Hello World", Status: "draft", Tags: &dup}); err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+}
+
+func TestRespondValidationKeepsFirstError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	c.Request.Header.Set("Accept-Language", "en")
+	respondErrorDetail(c, http.StatusBadRequest, errInvalidBody, archivePayload{Description: strings.Repeat("x", maxArchiveDescRunes+1)}.validate())
+
+	var body struct {
+		Error  string
+		Code   errCode
+		Fields []struct {
+			Field string
+			Code  errCode
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest || body.Code != errNameRequired || body.Error != "name is required" {
+		t.Fatalf("top-level error = %d %+v", w.Code, body)
+	}
+	if len(body.Fields) != 2 || body.Fields[1].Field != "description" || body.Fields[1].Code != errDescriptionTooLong {
+		t.Fatalf("fields = %+v", body.Fields)
+	}
+}
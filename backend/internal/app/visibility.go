@@ -0,0 +1,207 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"selfecho/backend/internal/store"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	visibilityPublic   = "public"
+	visibilityUnlisted = "unlisted"
+	visibilityPassword = "password"
+
+	unlockCookiePrefix = "selfecho_unlock_"
+	unlockCookieTTL    = 30 * 24 * time.Hour
+	maxPostPassword    = 72 // bcrypt ignores anything longer
+)
+
+func validVisibility(v string) bool {
+	return v == visibilityPublic || v == visibilityUnlisted || v == visibilityPassword
+}
+
+// unlockToken is the cookie value proving the reader knew the post password.
+// Keying it on the stored hash means changing the password locks everyone
+// out again.
+func unlockToken(passHash, articleID string) string {
+	mac := hmac.New(sha256.New, []byte(passHash))
+	mac.Write([]byte(articleID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isUnlocked reports whether the request may read a's body.
+func (s *server) isUnlocked(c *gin.Context, a article) bool {
+	if a.Visibility != visibilityPassword || a.passHash == "" {
+		return true
+	}
+	cookie, err := c.Cookie(unlockCookiePrefix + a.ID)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(cookie), []byte(unlockToken(a.passHash, a.ID)))
+}
+
+// protectBodies returns items with the bodies of password posts the reader
+// has not unlocked removed. Cached slices are never modified.
+func (s *server) protectBodies(c *gin.Context, items []article, authed bool) []article {
+	if authed {
+		return items
+	}
+	var out []article
+	for i, a := range items {
+		if s.isUnlocked(c, a) {
+			continue
+		}
+		if out == nil {
+			out = append([]article(nil), items...)
+		}
+		out[i].lockBody()
+	}
+	if out == nil {
+		return items
+	}
+	return out
+}
+
+// lockBody hides the content of a password post the reader has not unlocked.
+func (a *article) lockBody() {
+	a.BodyMD = ""
+	a.BodyHTML = ""
+	a.Excerpt = ""
+	a.Gallery = nil
+	a.Footnotes = 0
+	a.Locked = true
+}
+
+// hasSession is ensureUser without the 401: public endpoints use it to show
+// editors what anonymous readers cannot see.
+func (s *server) hasSession(c *gin.Context) bool {
+	if _, ok := c.Get(string(userContextKey)); ok {
+		v, scoped := c.Get(string(scopesContextKey))
+		return !scoped || v.(scopeSet).allows(scopeArticlesRead)
+	}
+	if token, ok := bearerToken(c); ok {
+		u, scopes, err := s.authenticateToken(c.Request.Context(), token)
+		if err != nil || !scopes.allows(scopeArticlesRead) {
+			return false
+		}
+		c.Set(string(userContextKey), *u)
+		c.Set(string(scopesContextKey), scopes)
+		return true
+	}
+	cookie, err := c.Cookie(sessionCookieName)
+	if err != nil || cookie == "" {
+		return false
+	}
+	swu, err := s.loadSession(c.Request.Context(), cookie)
+	if err != nil || time.Now().After(swu.Expires) {
+		return false
+	}
+	c.Set(string(userContextKey), swu.User)
+	return true
+}
+
+// checkVisibility rejects switching an article to password visibility
+// without a password, counting one already stored for id.
+func (s *server) checkVisibility(ctx context.Context, p articlePayload, id string) error {
+	if p.Visibility == nil || *p.Visibility != visibilityPassword {
+		return nil
+	}
+	if p.Password != nil && *p.Password != "" {
+		return nil
+	}
+	if id == "" {
+		return newAPIError(errPasswordRequired)
+	}
+	var stored string
+	err := s.db.QueryRowContext(ctx, `SELECT password_hash FROM articles WHERE id=$1`, id).Scan(&stored)
+	if err != nil && !errorsIsNotFound(err) {
+		return err
+	}
+	if stored == "" {
+		return newAPIError(errPasswordRequired)
+	}
+	return nil
+}
+
+type unlockPayload struct {
+	Password string `json:"password" form:"password"`
+}
+
+// unlockArticle checks a post password and sets the unlock cookie. It is
+// mounted on /articles/:id/unlock but accepts a slug as well, and answers
+// HTML form posts from the SSR lock page with a redirect back to the post.
+func (s *server) unlockArticle(c *gin.Context) {
+	ref := c.Param("id")
+	var payload unlockPayload
+	if err := c.ShouldBind(&payload); err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidBody)
+		return
+	}
+	match := `slug=$1`
+	if store.ValidID(ref) {
+		match = `(slug=$1 OR id=$1::uuid)`
+	}
+	var id, slug, passHash string
+	err := s.readQueryRow(c.Request.Context(), `
+		SELECT id, slug, password_hash FROM articles
+		WHERE `+match+` AND status='published' AND visibility='password'
+		LIMIT 1`, ref).Scan(&id, &slug, &passHash)
+	if err != nil {
+		if errorsIsNotFound(err) {
+			respondError(c, http.StatusNotFound, errArticleNotFound)
+			return
+		}
+		respondError(c, http.StatusInternalServerError, errQueryArticlesFailed)
+		return
+	}
+	fromForm := strings.HasPrefix(c.ContentType(), "application/x-www-form-urlencoded")
+	postURL := s.basePath + "/post/" + urlPathEscape(slug)
+	if passHash == "" || bcrypt.CompareHashAndPassword([]byte(passHash), []byte(payload.Password)) != nil {
+		s.security.unlockFailed()
+		if fromForm {
+			c.Redirect(http.StatusSeeOther, postURL+"?unlock=failed")
+			return
+		}
+		respondError(c, http.StatusForbidden, errWrongPassword)
+		return
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     unlockCookiePrefix + id,
+		Value:    unlockToken(passHash, id),
+		Path:     s.cookiePath(),
+		MaxAge:   int(unlockCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.isSecureRequest(c.Request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	if fromForm {
+		c.Redirect(http.StatusSeeOther, postURL)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// lockedPostBody is the SSR form shown instead of a password post's content.
+func (s *server) lockedPostBody(a article, failed bool) string {
+	var b strings.Builder
+	b.WriteString(`<form class="post-unlock space-y-3" method="post" action="` + s.basePath + `/api/articles/` + urlPathEscape(a.Slug) + `/unlock">`)
+	b.WriteString(`<p class="text-sm text-[#666]">这篇文章受密码保护，请输入密码查看。</p>`)
+	if failed {
+		b.WriteString(`<p class="text-sm text-[#c0392b]">密码错误</p>`)
+	}
+	b.WriteString(`<input type="password" name="password" required autocomplete="off" class="rounded border border-slate-300 px-3 py-1">`)
+	b.WriteString(s.challengeWidget("unlock"))
+	b.WriteString(` <button type="submit" class="rounded bg-[#3c546c] px-3 py-1 text-white">查看</button>`)
+	b.WriteString(`</form>`)
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnlockCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{}
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := article{ID: "a1", Visibility: visibilityPassword, passHash: hash, BodyMD: "body"}
+
+	ctx := func(cookie string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != "" {
+			c.Request.AddCookie(&http.Cookie{Name: unlockCookiePrefix + a.ID, Value: cookie})
+		}
+		return c
+	}
+	if s.isUnlocked(ctx(""), a) || s.isUnlocked(ctx("forged"), a) {
+		t.Fatal("locked post readable without a valid cookie")
+	}
+	if !s.isUnlocked(ctx(unlockToken(hash, a.ID)), a) {
+		t.Fatal("valid cookie rejected")
+	}
+	other, _ := hashPassword("changed")
+	if unlockToken(other, a.ID) == unlockToken(hash, a.ID) {
+		t.Fatal("changing the password should invalidate unlock cookies")
+	}
+
+	items := []article{a, {ID: "b", Visibility: visibilityPublic, BodyMD: "open"}}
+	out := s.protectBodies(ctx(""), items, false)
+	if !out[0].Locked || out[0].BodyMD != "" || out[1].BodyMD != "open" {
+		t.Fatalf("unexpected protected list: %+v", out)
+	}
+	if items[0].BodyMD != "body" {
+		t.Fatal("protectBodies modified the cached slice")
+	}
+}
+
+func TestPrivateResponse(t *testing.T) {
+	h := http.Header{}
+	if privateResponse(h) {
+		t.Fatal("empty header is not private")
+	}
+	h.Set("Cache-Control", "private, no-store")
+	if !privateResponse(h) {
+		t.Fatal("private response should bypass the page cache")
+	}
+}
+
+func TestCompactArticlesCopies(t *testing.T) {
+	items := []article{{ID: "1", BodyMD: "# hi", BodyHTML: "<h1>hi</h1>"}}
+	out := compactArticles(items)
+	if out[0].BodyMD != "" || out[0].BodyHTML != "" || out[0].ID != "1" {
+		t.Fatalf("compact = %+v", out[0])
+	}
+	if items[0].BodyMD == "" {
+		t.Fatal("compactArticles modified the cached slice")
+	}
+}
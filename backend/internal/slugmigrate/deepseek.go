@@ -0,0 +1,139 @@
+package slugmigrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultDeepSeekBaseURL = "https://api.deepseek.com"
+	DefaultDeepSeekModel   = "deepseek-chat"
+)
+
+// SlugPrompt is the system prompt used for every slug request.
+const SlugPrompt = "将我下面给你的中文标题转换为SEO友好的英文slug格式。输出要求：全小写、用连字符连接、简洁明了。仅输出slug本身。"
+
+// ErrEmptySlug is returned when the model answered but nothing slug-like was left.
+var ErrEmptySlug = errors.New("empty slug after normalization")
+
+// HTTPError is a non-2xx answer from the LLM API.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// DeepSeekClient asks a DeepSeek chat-completions endpoint for slugs.
+type DeepSeekClient struct {
+	BaseURL    string
+	Model      string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func (c *DeepSeekClient) Name() string { return "deepseek" }
+
+func (c *DeepSeekClient) GenerateSlug(ctx context.Context, title string) (string, error) {
+	return slugFromCompletion(ctx, c, title)
+}
+
+func (c *DeepSeekClient) Complete(ctx context.Context, system, prompt string) (string, error) {
+	return chatCompletion(ctx, c.HTTPClient, orDefault(c.BaseURL, DefaultDeepSeekBaseURL), orDefault(c.Model, DefaultDeepSeekModel), c.APIKey, system, prompt)
+}
+
+// slugFromCompletion runs SlugPrompt through p and normalizes the answer.
+func slugFromCompletion(ctx context.Context, p Provider, title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", errors.New("empty title")
+	}
+	content, err := p.Complete(ctx, SlugPrompt, title)
+	if err != nil {
+		return "", err
+	}
+	return normalizedOrErr(content)
+}
+
+// chatCompletion calls an OpenAI-style /chat/completions endpoint and returns
+// the raw answer.
+func chatCompletion(ctx context.Context, httpClient *http.Client, baseURL, model, apiKey, system, prompt string) (string, error) {
+	payload := map[string]any{
+		"model": model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+		"stream": false,
+	}
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postJSON(ctx, httpClient, strings.TrimSuffix(baseURL, "/")+"/chat/completions", apiKey, payload, &result); err != nil {
+		return "", err
+	}
+	if len(result.Choices) == 0 {
+		return "", errors.New("empty choices")
+	}
+	return result.Choices[0].Message.Content, nil
+}
+
+func postJSON(ctx context.Context, httpClient *http.Client, url, apiKey string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &HTTPError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(snippet))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func normalizedOrErr(content string) (string, error) {
+	out := NormalizeLLMOutputToSlug(content)
+	if out == "" {
+		return "", ErrEmptySlug
+	}
+	return out, nil
+}
+
+func orDefault(v, def string) string {
+	if v = strings.TrimSpace(v); v != "" {
+		return v
+	}
+	return def
+}
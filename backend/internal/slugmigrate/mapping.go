@@ -0,0 +1,86 @@
+package slugmigrate
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Mapping records one slug change made (or proposed) by the migration.
+type Mapping struct {
+	ID      string
+	Title   string
+	OldSlug string
+	NewSlug string
+	Method  string
+}
+
+var mappingHeader = []string{"id", "title", "old_slug", "new_slug", "method"}
+
+// WriteMappingCSV writes items with a header row.
+func WriteMappingCSV(w io.Writer, items []Mapping) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(mappingHeader); err != nil {
+		return err
+	}
+	for _, it := range items {
+		if err := cw.Write([]string{it.ID, it.Title, it.OldSlug, it.NewSlug, it.Method}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadMappingCSV parses a mapping CSV written by WriteMappingCSV. Columns are
+// matched by header name, so files from before the method column still load.
+func ReadMappingCSV(r io.Reader) ([]Mapping, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"id", "new_slug"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("mapping csv: missing %q column", required)
+		}
+	}
+	field := func(rec []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+
+	var items []Mapping
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := Mapping{
+			ID:      field(rec, "id"),
+			Title:   field(rec, "title"),
+			OldSlug: field(rec, "old_slug"),
+			NewSlug: field(rec, "new_slug"),
+			Method:  field(rec, "method"),
+		}
+		if m.ID == "" || m.NewSlug == "" {
+			continue
+		}
+		items = append(items, m)
+	}
+}
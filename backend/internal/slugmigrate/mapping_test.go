@@ -0,0 +1,39 @@
+package slugmigrate
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMappingCSVRoundTrip(t *testing.T) {
+	items := []Mapping{
+		{ID: "1", Title: "你好, 世界", OldSlug: "old", NewSlug: "hello-world", Method: "deepseek"},
+		{ID: "2", Title: "Go \"泛型\"", OldSlug: "x", NewSlug: "go-fan-xing", Method: MethodTransliteration},
+	}
+	var buf bytes.Buffer
+	if err := WriteMappingCSV(&buf, items); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadMappingCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, items) {
+		t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", got, items)
+	}
+}
+
+func TestReadMappingCSVLegacyHeader(t *testing.T) {
+	got, err := ReadMappingCSV(strings.NewReader("id,title,old_slug,new_slug\n1,T,a,b\n2,U,c,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].NewSlug != "b" || got[0].Method != "" {
+		t.Fatalf("unexpected rows: %+v", got)
+	}
+	if _, err := ReadMappingCSV(strings.NewReader("title,slug\nT,a\n")); err == nil {
+		t.Fatal("expected error for missing columns")
+	}
+}
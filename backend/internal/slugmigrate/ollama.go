@@ -0,0 +1,46 @@
+package slugmigrate
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultOllamaBaseURL = "http://127.0.0.1:11434"
+	DefaultOllamaModel   = "qwen2.5"
+)
+
+// OllamaClient uses a local Ollama server's /api/chat endpoint; no API key.
+type OllamaClient struct {
+	BaseURL    string
+	Model      string
+	HTTPClient *http.Client
+}
+
+func (c *OllamaClient) Name() string { return "ollama" }
+
+func (c *OllamaClient) GenerateSlug(ctx context.Context, title string) (string, error) {
+	return slugFromCompletion(ctx, c, title)
+}
+
+func (c *OllamaClient) Complete(ctx context.Context, system, prompt string) (string, error) {
+	payload := map[string]any{
+		"model": orDefault(c.Model, DefaultOllamaModel),
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": prompt},
+		},
+		"stream": false,
+	}
+	var result struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	url := strings.TrimSuffix(orDefault(c.BaseURL, DefaultOllamaBaseURL), "/") + "/api/chat"
+	if err := postJSON(ctx, c.HTTPClient, url, "", payload, &result); err != nil {
+		return "", err
+	}
+	return result.Message.Content, nil
+}
@@ -0,0 +1,31 @@
+package slugmigrate
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+	DefaultOpenAIModel   = "gpt-4o-mini"
+)
+
+// OpenAIClient talks to any OpenAI-compatible chat-completions API (OpenAI,
+// Azure-style gateways, vLLM, LM Studio, ...). BaseURL includes the version
+// prefix, e.g. https://api.openai.com/v1.
+type OpenAIClient struct {
+	BaseURL    string
+	Model      string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func (c *OpenAIClient) Name() string { return "openai" }
+
+func (c *OpenAIClient) GenerateSlug(ctx context.Context, title string) (string, error) {
+	return slugFromCompletion(ctx, c, title)
+}
+
+func (c *OpenAIClient) Complete(ctx context.Context, system, prompt string) (string, error) {
+	return chatCompletion(ctx, c.HTTPClient, orDefault(c.BaseURL, DefaultOpenAIBaseURL), orDefault(c.Model, DefaultOpenAIModel), c.APIKey, system, prompt)
+}
@@ -0,0 +1,139 @@
+package slugmigrate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider turns a post title into a slug candidate. Complete exposes the
+// underlying chat call for other editor helpers (summaries, tags).
+type Provider interface {
+	Name() string
+	GenerateSlug(ctx context.Context, title string) (string, error)
+	Complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+// ProviderConfig is the per-provider block in config.yaml. RPS caps requests
+// per second; 0 uses the provider default and a negative value disables
+// limiting.
+type ProviderConfig struct {
+	BaseURL string  `yaml:"baseUrl"`
+	Model   string  `yaml:"model"`
+	APIKey  string  `yaml:"apiKey"`
+	RPS     float64 `yaml:"rps"`
+}
+
+// defaultRPS keeps hosted APIs under typical free-tier limits; local Ollama
+// is only bounded by the machine.
+var defaultRPS = map[string]float64{
+	"deepseek": 5,
+	"openai":   3,
+	"ollama":   0,
+}
+
+// NewProvider builds the named provider ("deepseek", "openai" or "ollama")
+// wrapped in its rate limiter.
+func NewProvider(name string, cfg ProviderConfig, httpClient *http.Client) (Provider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	var p Provider
+	switch name {
+	case "deepseek":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("deepseek: missing API key")
+		}
+		p = &DeepSeekClient{BaseURL: cfg.BaseURL, Model: cfg.Model, APIKey: cfg.APIKey, HTTPClient: httpClient}
+	case "openai":
+		if cfg.APIKey == "" && cfg.BaseURL == "" {
+			return nil, fmt.Errorf("openai: missing API key")
+		}
+		p = &OpenAIClient{BaseURL: cfg.BaseURL, Model: cfg.Model, APIKey: cfg.APIKey, HTTPClient: httpClient}
+	case "ollama":
+		p = &OllamaClient{BaseURL: cfg.BaseURL, Model: cfg.Model, HTTPClient: httpClient}
+	default:
+		return nil, fmt.Errorf("unknown provider %q (want deepseek, openai or ollama)", name)
+	}
+
+	rps := cfg.RPS
+	if rps == 0 {
+		rps = defaultRPS[name]
+	}
+	if rps <= 0 {
+		return p, nil
+	}
+	return RateLimit(p, rps), nil
+}
+
+type rateLimited struct {
+	Provider
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// RateLimit spaces calls to p so at most rps start per second, even when
+// called from several goroutines.
+func RateLimit(p Provider, rps float64) Provider {
+	return &rateLimited{Provider: p, interval: time.Duration(float64(time.Second) / rps)}
+}
+
+func (r *rateLimited) GenerateSlug(ctx context.Context, title string) (string, error) {
+	if err := r.wait(ctx); err != nil {
+		return "", err
+	}
+	return r.Provider.GenerateSlug(ctx, title)
+}
+
+func (r *rateLimited) Complete(ctx context.Context, system, prompt string) (string, error) {
+	if err := r.wait(ctx); err != nil {
+		return "", err
+	}
+	return r.Provider.Complete(ctx, system, prompt)
+}
+
+func (r *rateLimited) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	wait := r.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+		r.next = now
+	}
+	r.next = r.next.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil
+}
+
+// ResolveProviderConfig merges the llm.<name> block with the legacy top-level
+// deepseek block and the <NAME>_API_KEY environment variable (which wins).
+func ResolveProviderConfig(name string, providers map[string]ProviderConfig, legacyDeepSeek ProviderConfig) ProviderConfig {
+	name = strings.ToLower(strings.TrimSpace(name))
+	cfg := providers[name]
+	if name == "deepseek" {
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = legacyDeepSeek.BaseURL
+		}
+		if cfg.Model == "" {
+			cfg.Model = legacyDeepSeek.Model
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = legacyDeepSeek.APIKey
+		}
+	}
+	if env := strings.TrimSpace(os.Getenv(strings.ToUpper(name) + "_API_KEY")); env != "" {
+		cfg.APIKey = env
+	}
+	return cfg
+}
@@ -0,0 +1,77 @@
+package slugmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProvidersSpeakTheirAPIs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			if r.Header.Get("Authorization") != "Bearer sk-test" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"choices": []any{map[string]any{"message": map[string]string{"content": "Hello World"}}}})
+		case "/api/chat":
+			json.NewEncoder(w).Encode(map[string]any{"message": map[string]string{"content": "`local-slug`"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	openai, err := NewProvider("openai", ProviderConfig{BaseURL: srv.URL + "/v1", APIKey: "sk-test", RPS: -1}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := openai.GenerateSlug(ctx, "你好世界"); err != nil || got != "hello-world" {
+		t.Fatalf("openai: got %q, %v", got, err)
+	}
+	ollama, err := NewProvider("ollama", ProviderConfig{BaseURL: srv.URL}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ollama.GenerateSlug(ctx, "本地"); err != nil || got != "local-slug" {
+		t.Fatalf("ollama: got %q, %v", got, err)
+	}
+	if _, err := NewProvider("deepseek", ProviderConfig{}, nil); err == nil {
+		t.Fatal("expected deepseek without key to fail")
+	}
+	if _, err := NewProvider("gemini", ProviderConfig{}, nil); err == nil {
+		t.Fatal("expected unknown provider to fail")
+	}
+}
+
+type countingProvider struct{ calls int }
+
+func (p *countingProvider) Name() string { return "count" }
+
+func (p *countingProvider) GenerateSlug(context.Context, string) (string, error) {
+	p.calls++
+	return "x", nil
+}
+
+func (p *countingProvider) Complete(context.Context, string, string) (string, error) {
+	p.calls++
+	return "x", nil
+}
+
+func TestRateLimitSpacesCalls(t *testing.T) {
+	p := RateLimit(&countingProvider{}, 50)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := p.GenerateSlug(context.Background(), "t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Fatalf("expected calls to be spaced ~20ms apart, took %v", elapsed)
+	}
+}
@@ -0,0 +1,65 @@
+package slugmigrate
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// RedirectsSchema creates the slug_redirects table. The server serves 301s
+// from it and slug-migrate fills it, so either side may be the first to run.
+const RedirectsSchema = `
+	CREATE TABLE IF NOT EXISTS slug_redirects (
+		old_slug TEXT PRIMARY KEY,
+		article_id UUID NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_slug_redirects_article ON slug_redirects(article_id);
+`
+
+// Redirect file formats accepted by WriteRedirects.
+const (
+	RedirectsNginx   = "nginx"     // a map block for `map $uri $slug_redirect`
+	RedirectsNetlify = "redirects" // a Netlify / Cloudflare Pages _redirects file
+)
+
+// DefaultPostPrefix is the public path posts are served under.
+const DefaultPostPrefix = "/post/"
+
+// WriteRedirects renders items as static redirect rules from prefix+old to
+// prefix+new, skipping rows whose slug did not change.
+func WriteRedirects(w io.Writer, format, prefix string, items []Mapping) error {
+	if prefix == "" {
+		prefix = DefaultPostPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var b strings.Builder
+	switch format {
+	case RedirectsNginx:
+		b.WriteString("# include inside http {}; then in the server block:\n")
+		b.WriteString("#   if ($slug_redirect) { return 301 $slug_redirect; }\n")
+		b.WriteString("map $uri $slug_redirect {\n")
+		b.WriteString("\tdefault \"\";\n")
+		for _, it := range items {
+			if it.OldSlug == "" || it.OldSlug == it.NewSlug {
+				continue
+			}
+			fmt.Fprintf(&b, "\t%q %q;\n", prefix+it.OldSlug, prefix+url.PathEscape(it.NewSlug))
+		}
+		b.WriteString("}\n")
+	case RedirectsNetlify:
+		for _, it := range items {
+			if it.OldSlug == "" || it.OldSlug == it.NewSlug {
+				continue
+			}
+			fmt.Fprintf(&b, "%s%s %s%s 301\n", prefix, url.PathEscape(it.OldSlug), prefix, url.PathEscape(it.NewSlug))
+		}
+	default:
+		return fmt.Errorf("unknown redirects format %q (want %s or %s)", format, RedirectsNginx, RedirectsNetlify)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
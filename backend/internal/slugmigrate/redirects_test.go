@@ -0,0 +1,34 @@
+package slugmigrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteRedirects(t *testing.T) {
+	items := []Mapping{
+		{ID: "1", OldSlug: "old-post", NewSlug: "new-post"},
+		{ID: "2", OldSlug: "same", NewSlug: "same"},
+		{ID: "3", OldSlug: "", NewSlug: "fresh"},
+	}
+
+	var netlify strings.Builder
+	if err := WriteRedirects(&netlify, RedirectsNetlify, "/blog/post", items); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := netlify.String(), "/blog/post/old-post /blog/post/new-post 301\n"; got != want {
+		t.Fatalf("redirects = %q, want %q", got, want)
+	}
+
+	var nginx strings.Builder
+	if err := WriteRedirects(&nginx, RedirectsNginx, "", items); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(nginx.String(), "\t\"/post/old-post\" \"/post/new-post\";\n") || strings.Contains(nginx.String(), "same") {
+		t.Fatalf("unexpected nginx map:\n%s", nginx.String())
+	}
+
+	if err := WriteRedirects(&nginx, "apache", "", items); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+}
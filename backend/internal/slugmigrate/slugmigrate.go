@@ -0,0 +1,65 @@
+// Package slugmigrate holds the slug helpers shared by the slug-migrate tool
+// and the server's slug suggestion endpoint.
+package slugmigrate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gosimple/slug"
+)
+
+// NormalizeLLMOutputToSlug turns a chat completion into a slug. Models tend to
+// wrap the answer in quotes or code fences or prefix it with "slug:", so only
+// the first meaningful line is kept.
+func NormalizeLLMOutputToSlug(content string) string {
+	var line string
+	for _, l := range strings.Split(content, "\n") {
+		l = strings.Trim(strings.TrimSpace(l), "\"`'* ")
+		if l == "" || strings.HasPrefix(l, "```") {
+			continue
+		}
+		line = l
+		break
+	}
+	if i := strings.Index(line, ":"); i >= 0 && strings.EqualFold(strings.TrimSpace(line[:i]), "slug") {
+		line = strings.Trim(strings.TrimSpace(line[i+1:]), "\"`' ")
+	}
+	out := slug.Make(line)
+	if out == "" {
+		out = slug.MakeLang(line, "zh")
+	}
+	return out
+}
+
+// Transliterate is the offline fallback: pinyin-style transliteration of the
+// title, the same conversion the editor's "pinyin" mode uses.
+func Transliterate(title string) string {
+	return slug.MakeLang(strings.TrimSpace(title), "zh")
+}
+
+// EnsureUniqueSlug returns newSlug, or newSlug-<n> when another article
+// already owns it. used maps slug -> article id.
+func EnsureUniqueSlug(newSlug, id string, used map[string]string) string {
+	newSlug = strings.TrimSpace(newSlug)
+	if newSlug == "" {
+		return ""
+	}
+	if owner, ok := used[newSlug]; !ok || owner == id {
+		return newSlug
+	}
+	for n := 2; ; n++ {
+		candidate := newSlug + "-" + strconv.Itoa(n)
+		if owner, ok := used[candidate]; !ok || owner == id {
+			return candidate
+		}
+	}
+}
+
+// ApplySlugChange records that id moved from oldSlug to newSlug.
+func ApplySlugChange(id, oldSlug, newSlug string, used map[string]string) {
+	if used[oldSlug] == id {
+		delete(used, oldSlug)
+	}
+	used[newSlug] = id
+}
@@ -0,0 +1,36 @@
+package slugmigrate
+
+import "testing"
+
+func TestNormalizeLLMOutputToSlug(t *testing.T) {
+	cases := map[string]string{
+		"hello-world":                    "hello-world",
+		"\"Hello World\"":                "hello-world",
+		"```\nmy-first-post\n```":        "my-first-post",
+		"Slug: `go-generics-notes`\n解释…": "go-generics-notes",
+		"   ":                            "",
+	}
+	for in, want := range cases {
+		if got := NormalizeLLMOutputToSlug(in); got != want {
+			t.Fatalf("NormalizeLLMOutputToSlug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEnsureUniqueSlugAndApply(t *testing.T) {
+	used := map[string]string{"hello": "1", "hello-2": "2", "other": "3"}
+	if got := EnsureUniqueSlug("hello", "1", used); got != "hello" {
+		t.Fatalf("own slug should be kept, got %q", got)
+	}
+	if got := EnsureUniqueSlug("hello", "3", used); got != "hello-3" {
+		t.Fatalf("expected next free suffix, got %q", got)
+	}
+	if got := EnsureUniqueSlug(" ", "3", used); got != "" {
+		t.Fatalf("expected empty slug, got %q", got)
+	}
+
+	ApplySlugChange("3", "other", "hello-3", used)
+	if _, ok := used["other"]; ok || used["hello-3"] != "3" {
+		t.Fatalf("unexpected used map: %v", used)
+	}
+}
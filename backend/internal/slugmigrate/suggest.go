@@ -0,0 +1,44 @@
+package slugmigrate
+
+import (
+	"context"
+	"errors"
+)
+
+// MethodTransliteration marks slugs produced offline from the title; LLM
+// slugs are marked with the provider name.
+const MethodTransliteration = "transliteration"
+
+// Suggestion is one slug candidate and how it was produced. LLMErr keeps the
+// provider error when the transliteration fallback was used.
+type Suggestion struct {
+	Slug   string
+	Method string
+	LLMErr error
+}
+
+// Suggest asks p (which may be nil for offline mode) for a slug and, when
+// fallback is set, transliterates the title if that fails.
+func Suggest(ctx context.Context, p Provider, title string, fallback bool) (Suggestion, error) {
+	var llmErr error
+	if p != nil {
+		out, err := p.GenerateSlug(ctx, title)
+		if err == nil {
+			return Suggestion{Slug: out, Method: p.Name()}, nil
+		}
+		if !fallback || ctx.Err() != nil {
+			return Suggestion{}, err
+		}
+		llmErr = err
+	} else if !fallback {
+		return Suggestion{}, errors.New("no LLM provider configured")
+	}
+	out := Transliterate(title)
+	if out == "" {
+		if llmErr != nil {
+			return Suggestion{}, llmErr
+		}
+		return Suggestion{}, ErrEmptySlug
+	}
+	return Suggestion{Slug: out, Method: MethodTransliteration, LLMErr: llmErr}, nil
+}
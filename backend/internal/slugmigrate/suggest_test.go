@@ -0,0 +1,38 @@
+package slugmigrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type failingProvider struct{}
+
+func (failingProvider) Name() string { return "broken" }
+
+func (failingProvider) GenerateSlug(context.Context, string) (string, error) {
+	return "", errors.New("upstream down")
+}
+
+func (failingProvider) Complete(context.Context, string, string) (string, error) {
+	return "", errors.New("upstream down")
+}
+
+func TestSuggestFallsBackToTransliteration(t *testing.T) {
+	ctx := context.Background()
+
+	got, err := Suggest(ctx, failingProvider{}, "你好世界", true)
+	if err != nil || got.Method != MethodTransliteration || got.Slug != "ni-hao-shi-jie" || got.LLMErr == nil {
+		t.Fatalf("unexpected fallback result: %+v, %v", got, err)
+	}
+	if got, err := Suggest(ctx, nil, "你好世界", true); err != nil || got.Method != MethodTransliteration || got.LLMErr != nil {
+		t.Fatalf("offline mode should transliterate: %+v, %v", got, err)
+	}
+	if _, err := Suggest(ctx, failingProvider{}, "你好世界", false); err == nil {
+		t.Fatal("expected provider error without fallback")
+	}
+	p := &countingProvider{}
+	if got, err := Suggest(ctx, p, "x", true); err != nil || got.Method != "count" {
+		t.Fatalf("expected provider slug: %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,52 @@
+package slugmigrate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// SummaryPrompt asks for a meta description in the article's own language.
+const SummaryPrompt = "为下面的博客文章写一段用于 SEO meta description 的摘要。要求：使用文章本身的语言，一到两句话，不超过120个字，不要使用 Markdown、引号或“本文”之类的开头。仅输出摘要本身。"
+
+// MaxSummaryRunes caps stored descriptions; search engines cut around here.
+const MaxSummaryRunes = 160
+
+// maxPromptRunes keeps long posts within small context windows.
+const maxPromptRunes = 6000
+
+// ErrEmptySummary is returned when the model produced nothing usable.
+var ErrEmptySummary = errors.New("empty summary")
+
+// Summarize asks p for a meta description of the article.
+func Summarize(ctx context.Context, p Provider, title, body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if utf8.RuneCountInString(body) > maxPromptRunes {
+		body = string([]rune(body)[:maxPromptRunes])
+	}
+	content, err := p.Complete(ctx, SummaryPrompt, "标题："+strings.TrimSpace(title)+"\n\n"+body)
+	if err != nil {
+		return "", err
+	}
+	out := CleanSummary(content)
+	if out == "" {
+		return "", ErrEmptySummary
+	}
+	return out, nil
+}
+
+// CleanSummary flattens model output (or an editor's approved text) into a
+// single line of at most MaxSummaryRunes runes.
+func CleanSummary(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "摘要：")
+	s = strings.TrimPrefix(s, "摘要:")
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.Trim(s, "\"'“”「」`")
+	if utf8.RuneCountInString(s) > MaxSummaryRunes {
+		r := []rune(s)[:MaxSummaryRunes-1]
+		s = strings.TrimSpace(string(r)) + "…"
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package slugmigrate
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCleanSummary(t *testing.T) {
+	cases := map[string]string{
+		"  “一篇关于 Go 泛型的笔记。”\n": "一篇关于 Go 泛型的笔记。",
+		"摘要：介绍\n  pgx  的用法":    "介绍 pgx 的用法",
+		"   ":                  "",
+	}
+	for in, want := range cases {
+		if got := CleanSummary(in); got != want {
+			t.Fatalf("CleanSummary(%q) = %q, want %q", in, got, want)
+		}
+	}
+	long := CleanSummary(strings.Repeat("长", 500))
+	if utf8.RuneCountInString(long) != MaxSummaryRunes || !strings.HasSuffix(long, "…") {
+		t.Fatalf("expected truncated summary, got %d runes", utf8.RuneCountInString(long))
+	}
+}
+
+func TestParseTagList(t *testing.T) {
+	got := ParseTagList("1. Go，#PostgreSQL、 \"性能优化\"\n- go\n3D打印\n")
+	want := []string{"Go", "PostgreSQL", "性能优化", "3D打印"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("ParseTagList = %q, want %q", got, want)
+	}
+}
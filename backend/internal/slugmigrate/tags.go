@@ -0,0 +1,59 @@
+package slugmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// TagPrompt asks for a comma-separated tag list; %d is the maximum count.
+const TagPrompt = "为下面的博客文章推荐最多%d个标签。要求：每个标签是简短的名词或术语，使用文章本身的语言，技术名词保留英文原文。只输出用英文逗号分隔的标签列表。"
+
+// ErrNoTags is returned when the model answered without any usable tag.
+var ErrNoTags = errors.New("no tags in response")
+
+// SuggestTags asks p for up to n tags for the article.
+func SuggestTags(ctx context.Context, p Provider, title, body string, n int) ([]string, error) {
+	body = strings.TrimSpace(body)
+	if utf8.RuneCountInString(body) > maxPromptRunes {
+		body = string([]rune(body)[:maxPromptRunes])
+	}
+	content, err := p.Complete(ctx, fmt.Sprintf(TagPrompt, n), "标题："+strings.TrimSpace(title)+"\n\n"+body)
+	if err != nil {
+		return nil, err
+	}
+	tags := ParseTagList(content)
+	if len(tags) == 0 {
+		return nil, ErrNoTags
+	}
+	if len(tags) > n {
+		tags = tags[:n]
+	}
+	return tags, nil
+}
+
+var listMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])?\s*`)
+
+// ParseTagList splits model output on commas, ideographic commas and line
+// breaks, dropping list markers, '#' prefixes and duplicates.
+func ParseTagList(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '，' || r == '、' || r == ';' || r == '；' || r == '\n'
+	})
+	seen := make(map[string]bool)
+	var out []string
+	for _, f := range fields {
+		f = listMarker.ReplaceAllString(f, "")
+		f = strings.Trim(f, "#\"'“”「」` ")
+		key := strings.ToLower(f)
+		if f == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, f)
+	}
+	return out
+}
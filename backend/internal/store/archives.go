@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors returned by DeleteArchive.
+var (
+	ErrReassignToSelf         = errors.New("store: cannot reassign an archive's articles to itself")
+	ErrReassignTargetNotFound = errors.New("store: reassignment target not found")
+)
+
+// Archive is one archive (category) with the public post statistics used by
+// the archive list.
+type Archive struct {
+	ID           string
+	Name         string
+	Description  string
+	CreatedAt    time.Time
+	ItemCount    int
+	LatestPostAt *time.Time
+}
+
+// Category is a published-post count per archive name; posts without an
+// archive are reported as "未分类".
+type Category struct {
+	Name  string
+	Count int
+}
+
+// ListArchives returns every archive by name, with counts of its published,
+// listed posts.
+func (s *Store) ListArchives(ctx context.Context) ([]Archive, error) {
+	rows, err := s.read.Query(ctx, `
+		SELECT ar.id, ar.name, COALESCE(ar.description, ''), ar.created_at,
+		       COUNT(art.id) AS item_count, MAX(COALESCE(art.published_at, art.created_at)) AS latest_post_at
+		FROM archives ar
+		LEFT JOIN articles art ON art.archive_id = ar.id AND art.status = 'published' AND art.type = 'post' AND art.visibility <> 'unlisted'
+		GROUP BY ar.id, ar.name, ar.description, ar.created_at
+		ORDER BY ar.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Archive
+	for rows.Next() {
+		var a Archive
+		var latest sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Name, &a.Description, &a.CreatedAt, &a.ItemCount, &latest); err != nil {
+			return nil, err
+		}
+		if latest.Valid {
+			a.LatestPostAt = &latest.Time
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+// ArchiveByName looks an archive up by its exact name.
+func (s *Store) ArchiveByName(ctx context.Context, name string) (Archive, error) {
+	var a Archive
+	err := s.read.QueryRow(ctx, `SELECT id, name, COALESCE(description, ''), created_at FROM archives WHERE name=$1`, name).
+		Scan(&a.ID, &a.Name, &a.Description, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Archive{}, ErrNotFound
+	}
+	return a, err
+}
+
+// ListCategories counts published, listed posts per archive name, largest
+// first.
+func (s *Store) ListCategories(ctx context.Context) ([]Category, error) {
+	rows, err := s.read.Query(ctx, `
+		SELECT COALESCE(ar.name, '未分类') AS name, COUNT(*) AS count
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		WHERE art.status = 'published' AND art.type = 'post' AND art.visibility <> 'unlisted'
+		GROUP BY COALESCE(ar.name, '未分类')
+		ORDER BY count DESC, name ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Category
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.Name, &c.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// CreateArchive inserts an archive and returns its id.
+func (s *Store) CreateArchive(ctx context.Context, name, description string) (string, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `INSERT INTO archives (name, description) VALUES ($1, $2) RETURNING id`, name, description).Scan(&id)
+	return id, err
+}
+
+// UpdateArchive renames and re-describes archive id.
+func (s *Store) UpdateArchive(ctx context.Context, id, name, description string) error {
+	if !ValidID(id) {
+		return ErrNotFound
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE archives SET name=$1, description=$2 WHERE id=$3`, name, description, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeletedArchive reports what DeleteArchive did with the archive's articles.
+type DeletedArchive struct {
+	// Moved is how many articles were reassigned or left uncategorized.
+	Moved int64
+	// Target is the archive they moved to, nil when they were uncategorized.
+	Target *Archive
+}
+
+// DeleteArchive removes archive id in one transaction, first moving its
+// articles to reassignTo, or leaving them uncategorized when it is empty.
+func (s *Store) DeleteArchive(ctx context.Context, id, reassignTo string) (DeletedArchive, error) {
+	var out DeletedArchive
+	if !ValidID(id) {
+		return out, ErrNotFound
+	}
+	if reassignTo != "" {
+		// UUIDs compare case-insensitively, like Postgres does
+		if strings.EqualFold(reassignTo, id) {
+			return out, ErrReassignToSelf
+		}
+		if !ValidID(reassignTo) {
+			return out, ErrReassignTargetNotFound
+		}
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return out, err
+	}
+	defer tx.Rollback()
+
+	var target *string
+	if reassignTo != "" {
+		var t Archive
+		err := tx.QueryRowContext(ctx, `SELECT id, name FROM archives WHERE id=$1`, reassignTo).Scan(&t.ID, &t.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return out, ErrReassignTargetNotFound
+		}
+		if err != nil {
+			return out, err
+		}
+		out.Target = &t
+		target = &t.ID
+	}
+
+	moved, err := tx.ExecContext(ctx, `UPDATE articles SET archive_id=$1, updated_at=now() WHERE archive_id=$2`, target, id)
+	if err != nil {
+		return out, err
+	}
+	out.Moved, _ = moved.RowsAffected()
+	res, err := tx.ExecContext(ctx, `DELETE FROM archives WHERE id=$1`, id)
+	if err != nil {
+		return out, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return out, ErrNotFound
+	}
+	return out, tx.Commit()
+}
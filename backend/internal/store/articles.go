@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArticleRow is one article as stored, with the archive name joined in.
+type ArticleRow struct {
+	ID            string
+	Type          string
+	Title         string
+	Slug          string
+	Archive       string
+	Status        string
+	BodyMD        string
+	BodyHTML      string
+	Excerpt       string
+	Description   string
+	Tags          []string
+	Lang          string
+	TranslationOf *string
+	Social        []byte
+	Meta          []byte
+	// Author is the primary author's username and AuthorName their display
+	// name; both are empty for articles without an author. Authors lists
+	// every author, co-authors included, in byline order.
+	Author       string
+	AuthorName   string
+	Authors      []Byline
+	Visibility   string
+	PasswordHash string
+	PublishedAt  *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Byline is one of an article's authors.
+type Byline struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+}
+
+// bylinesColumn aggregates an article's authors for the list queries.
+const bylinesColumn = `COALESCE((
+		SELECT json_agg(json_build_object('username', bu.username, 'displayName', bu.display_name) ORDER BY aa.position, bu.username)
+		FROM article_authors aa JOIN users bu ON bu.id = aa.user_id
+		WHERE aa.article_id = art.id), '[]')::text`
+
+// ArticleFilter selects the rows for ListArticles. Empty fields don't filter.
+type ArticleFilter struct {
+	Status  string
+	Slug    string
+	Archive string
+	// Author is a username; co-authored articles match too.
+	Author string
+	// Type is "post", "memo", "note", or "" / "all" for every type.
+	Type string
+	Lang string
+	// LangIncludesUnset also matches articles without a language, which are
+	// in the site default.
+	LangIncludesUnset bool
+	// ListedOnly hides unlisted articles.
+	ListedOnly bool
+	// Meta matches custom fields by their text value (meta->>key).
+	Meta map[string]string
+	// Page and Limit page the result; Limit 0 returns every row.
+	Page, Limit int
+}
+
+// where builds the WHERE clause for f with $1-based placeholders.
+func (f ArticleFilter) where() (string, []any) {
+	var parts []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Status != "" {
+		parts = append(parts, "art.status = "+arg(f.Status))
+	}
+	if f.Slug != "" {
+		parts = append(parts, "art.slug = "+arg(f.Slug))
+	}
+	if f.Archive != "" {
+		parts = append(parts, "COALESCE(ar.name, '') = "+arg(f.Archive))
+	}
+	if f.Author != "" {
+		parts = append(parts, "EXISTS (SELECT 1 FROM article_authors aa JOIN users au ON au.id = aa.user_id WHERE aa.article_id = art.id AND au.username = "+arg(f.Author)+")")
+	}
+	if f.ListedOnly {
+		parts = append(parts, "art.visibility <> 'unlisted'")
+	}
+	if f.Type != "" && f.Type != "all" {
+		parts = append(parts, "art.type = "+arg(f.Type))
+	}
+	if f.Lang != "" {
+		p := arg(f.Lang)
+		if f.LangIncludesUnset {
+			parts = append(parts, "(lower(art.lang) = lower("+p+") OR art.lang = '')")
+		} else {
+			parts = append(parts, "lower(art.lang) = lower("+p+")")
+		}
+	}
+	keys := make([]string, 0, len(f.Meta))
+	for k := range f.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, "art.meta->>"+arg(k)+" = "+arg(f.Meta[k]))
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(parts, " AND "), args
+}
+
+// listQuery returns the list statement for f. The window count rides along
+// with each row, so paged requests get their total without a second round
+// trip; it is computed before LIMIT applies.
+func (f ArticleFilter) listQuery() (string, []any) {
+	where, args := f.where()
+	query := `
+		SELECT art.id, art.type, art.title, art.slug, COALESCE(ar.name, '') AS archive, art.status, art.body_md, COALESCE(art.body_html, ''),
+		       COALESCE(art.excerpt, ''), art.meta_description, to_json(art.tags)::text, art.lang, art.translation_of::text,
+		       art.social::text, art.meta::text,
+		       COALESCE(u.username, ''), COALESCE(u.display_name, ''), ` + bylinesColumn + `, art.visibility, art.password_hash, art.published_at, art.created_at, art.updated_at,
+		       COUNT(*) OVER() AS total
+		FROM articles art
+		LEFT JOIN archives ar ON ar.id = art.archive_id
+		LEFT JOIN users u ON u.id = art.author_id
+		` + where + `
+		ORDER BY art.created_at DESC`
+	if f.Limit > 0 {
+		page := max(f.Page, 1)
+		query += fmt.Sprintf(` LIMIT $%d OFFSET $%d`, len(args)+1, len(args)+2)
+		args = append(args, f.Limit, (page-1)*f.Limit)
+	}
+	return query, args
+}
+
+// ListArticles returns the rows matching f, newest first, and the total
+// number of matches ignoring paging.
+func (s *Store) ListArticles(ctx context.Context, f ArticleFilter) ([]ArticleRow, int, error) {
+	query, args := f.listQuery()
+	rows, err := s.read.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var (
+		items []ArticleRow
+		total int
+	)
+	for rows.Next() {
+		var (
+			a             ArticleRow
+			tags          []byte
+			bylines       []byte
+			translationOf sql.NullString
+			publishedAt   sql.NullTime
+		)
+		if err := rows.Scan(&a.ID, &a.Type, &a.Title, &a.Slug, &a.Archive, &a.Status, &a.BodyMD, &a.BodyHTML, &a.Excerpt, &a.Description, &tags,
+			&a.Lang, &translationOf, &a.Social, &a.Meta, &a.Author, &a.AuthorName, &bylines, &a.Visibility, &a.PasswordHash, &publishedAt, &a.CreatedAt, &a.UpdatedAt, &total); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal(tags, &a.Tags); err != nil {
+			return nil, 0, fmt.Errorf("store: tags of %s: %w", a.ID, err)
+		}
+		if err := json.Unmarshal(bylines, &a.Authors); err != nil {
+			return nil, 0, fmt.Errorf("store: authors of %s: %w", a.ID, err)
+		}
+		if translationOf.Valid {
+			a.TranslationOf = &translationOf.String
+		}
+		if publishedAt.Valid {
+			a.PublishedAt = &publishedAt.Time
+		}
+		items = append(items, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if f.Limit > 0 && len(items) == 0 && f.Page > 1 {
+		// past the last page there is no row to carry the window count
+		if total, err = s.CountArticles(ctx, f); err != nil {
+			return nil, 0, err
+		}
+	}
+	if f.Limit == 0 {
+		total = len(items)
+	}
+	return items, total, nil
+}
+
+// CountArticles counts the rows matching f, ignoring paging.
+func (s *Store) CountArticles(ctx context.Context, f ArticleFilter) (int, error) {
+	where, args := f.where()
+	var n int
+	err := s.read.QueryRow(ctx, `SELECT COUNT(*) FROM articles art LEFT JOIN archives ar ON ar.id = art.archive_id LEFT JOIN users u ON u.id = art.author_id `+where, args...).Scan(&n)
+	return n, err
+}
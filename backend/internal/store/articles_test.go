@@ -0,0 +1,49 @@
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArticleFilterWhere(t *testing.T) {
+	where, args := ArticleFilter{}.where()
+	if where != "" || args != nil {
+		t.Fatalf("empty filter = %q %v", where, args)
+	}
+
+	f := ArticleFilter{Status: "published", Archive: "go", Type: "post", Lang: "en", ListedOnly: true}
+	where, args = f.where()
+	want := "WHERE art.status = $1 AND COALESCE(ar.name, '') = $2 AND art.visibility <> 'unlisted' AND art.type = $3 AND lower(art.lang) = lower($4)"
+	if where != want {
+		t.Fatalf("where =\n%s\nwant\n%s", where, want)
+	}
+	if !reflect.DeepEqual(args, []any{"published", "go", "post", "en"}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	where, _ = ArticleFilter{Type: "all", Lang: "zh-CN", LangIncludesUnset: true}.where()
+	if where != "WHERE (lower(art.lang) = lower($1) OR art.lang = '')" {
+		t.Fatalf("all types / default language: %q", where)
+	}
+
+	where, args = ArticleFilter{Status: "published", Meta: map[string]string{"mood": "calm", "city": "Oslo"}}.where()
+	if where != "WHERE art.status = $1 AND art.meta->>$2 = $3 AND art.meta->>$4 = $5" ||
+		!reflect.DeepEqual(args, []any{"published", "city", "Oslo", "mood", "calm"}) {
+		t.Fatalf("meta filter: %q %v", where, args)
+	}
+}
+
+func TestArticleFilterPaging(t *testing.T) {
+	query, args := ArticleFilter{Status: "draft"}.listQuery()
+	if strings.Contains(query, "LIMIT") || len(args) != 1 {
+		t.Fatalf("unpaged query has LIMIT or extra args: %v", args)
+	}
+	query, args = ArticleFilter{Status: "draft", Page: 3, Limit: 10}.listQuery()
+	if !strings.HasSuffix(query, "LIMIT $2 OFFSET $3") || !reflect.DeepEqual(args, []any{"draft", 10, 20}) {
+		t.Fatalf("paged query: %q %v", query[len(query)-30:], args)
+	}
+	if !strings.Contains(query, "COUNT(*) OVER()") {
+		t.Fatal("list query should carry the window count")
+	}
+}
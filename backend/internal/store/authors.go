@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Profile is what readers see about a user: the byline name, a short bio
+// and an avatar image URL. Empty fields are unset.
+type Profile struct {
+	DisplayName string
+	Bio         string
+	AvatarURL   string
+}
+
+// Author is a user as shown on author pages, with the number of published,
+// listed posts they wrote or co-wrote.
+type Author struct {
+	ID       string
+	Username string
+	Profile
+	PostCount    int
+	LatestPostAt *time.Time
+}
+
+const authorSelect = `
+	SELECT u.id, u.username, u.display_name, u.bio, u.avatar_url,
+	       COUNT(art.id), MAX(COALESCE(art.published_at, art.created_at))
+	FROM users u
+	LEFT JOIN article_authors aa ON aa.user_id = u.id
+	LEFT JOIN articles art ON art.id = aa.article_id AND art.status = 'published' AND art.type = 'post' AND art.visibility <> 'unlisted'
+	WHERE u.role <> 'service'
+`
+
+func scanAuthor(row interface{ Scan(...any) error }) (Author, error) {
+	var a Author
+	var latest sql.NullTime
+	if err := row.Scan(&a.ID, &a.Username, &a.DisplayName, &a.Bio, &a.AvatarURL, &a.PostCount, &latest); err != nil {
+		return Author{}, err
+	}
+	if latest.Valid {
+		a.LatestPostAt = &latest.Time
+	}
+	return a, nil
+}
+
+// ListAuthors returns every user by username with their post counts.
+// Service accounts are not authors and are left out.
+func (s *Store) ListAuthors(ctx context.Context) ([]Author, error) {
+	rows, err := s.read.Query(ctx, authorSelect+`GROUP BY u.id ORDER BY u.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Author
+	for rows.Next() {
+		a, err := scanAuthor(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+// AuthorByUsername looks an author up by exact username.
+func (s *Store) AuthorByUsername(ctx context.Context, username string) (Author, error) {
+	a, err := scanAuthor(s.read.QueryRow(ctx, authorSelect+`AND u.username = $1 GROUP BY u.id`, username))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Author{}, ErrNotFound
+	}
+	return a, err
+}
+
+// UpdateProfile replaces the profile of userID.
+func (s *Store) UpdateProfile(ctx context.Context, userID string, p Profile) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET display_name=$2, bio=$3, avatar_url=$4 WHERE id=$1`,
+		userID, p.DisplayName, p.Bio, p.AvatarURL)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
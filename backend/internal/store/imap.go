@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ImapAccount is a configured mailbox. Password is stored as given; the app
+// encrypts it before CreateImapAccount and decrypts after reading.
+type ImapAccount struct {
+	ID              string
+	Host            string
+	Port            int
+	Username        string
+	Password        string
+	UseSSL          bool
+	UseStartTLS     bool
+	LastUID         uint32
+	LastUIDValidity uint32
+	CreatedAt       time.Time
+
+	CACert                string
+	InsecureSkipVerify    bool
+	ConnectTimeoutSeconds int
+	ReadTimeoutSeconds    int
+
+	// RetainMessages and RetainDays bound the message cache; zero means no
+	// limit.
+	RetainMessages int
+	RetainDays     int
+}
+
+// ImapCacheStats is the size of an account's message cache.
+type ImapCacheStats struct {
+	Messages int
+	Bytes    int64
+}
+
+// ImapMessage is one cached message. Flags are space separated. Snippet is
+// a short plain text preview of the body. ThreadID groups a conversation;
+// messages cached before threading each form their own.
+type ImapMessage struct {
+	UID       uint32
+	Subject   string
+	From      string
+	Date      *time.Time
+	Flags     string
+	Snippet   string
+	ThreadID  string
+	BodyHTML  string
+	BodyPlain string
+}
+
+// ImapThread summarizes a conversation: Subject is its first message's,
+// From, Snippet and LatestUID its newest one's.
+type ImapThread struct {
+	ID         string
+	Subject    string
+	From       string
+	Snippet    string
+	LatestUID  uint32
+	LatestDate *time.Time
+	Count      int
+	Unseen     int
+}
+
+// imapLatestCopies selects the newest cached copy of every UID of account
+// $1, with the thread each belongs to.
+const imapLatestCopies = `
+	SELECT DISTINCT ON (uid) uid, subject, from_addr, msg_date, flags, snippet,
+	       COALESCE(thread_id, 'u' || uid) AS thread, body_html, body_plain, created_at
+	FROM imap_messages
+	WHERE account_id=$1
+	ORDER BY uid, uidvalidity DESC, created_at DESC`
+
+const imapAccountColumns = `id, host, port, username, password, use_ssl, use_starttls, ca_cert, insecure_skip_verify, connect_timeout_seconds, read_timeout_seconds, retain_messages, retain_days, last_uid, last_uidvalidity, created_at`
+
+func scanImapAccount(row Row) (ImapAccount, error) {
+	var a ImapAccount
+	err := row.Scan(&a.ID, &a.Host, &a.Port, &a.Username, &a.Password, &a.UseSSL, &a.UseStartTLS, &a.CACert, &a.InsecureSkipVerify, &a.ConnectTimeoutSeconds, &a.ReadTimeoutSeconds, &a.RetainMessages, &a.RetainDays, &a.LastUID, &a.LastUIDValidity, &a.CreatedAt)
+	return a, err
+}
+
+// ListImapAccounts returns every account, newest first.
+func (s *Store) ListImapAccounts(ctx context.Context) ([]ImapAccount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+imapAccountColumns+` FROM imap_accounts ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ImapAccount
+	for rows.Next() {
+		a, err := scanImapAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, a)
+	}
+	return items, rows.Err()
+}
+
+// ImapAccount returns the account with id, or the newest one when id is
+// empty.
+func (s *Store) ImapAccount(ctx context.Context, id string) (ImapAccount, error) {
+	var row *sql.Row
+	if id != "" {
+		if !ValidID(id) {
+			return ImapAccount{}, ErrNotFound
+		}
+		row = s.db.QueryRowContext(ctx, `SELECT `+imapAccountColumns+` FROM imap_accounts WHERE id=$1`, id)
+	} else {
+		row = s.db.QueryRowContext(ctx, `SELECT `+imapAccountColumns+` FROM imap_accounts ORDER BY created_at DESC LIMIT 1`)
+	}
+	a, err := scanImapAccount(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImapAccount{}, ErrNotFound
+	}
+	return a, err
+}
+
+// CreateImapAccount inserts a; ID, sync state and CreatedAt are ignored.
+func (s *Store) CreateImapAccount(ctx context.Context, a ImapAccount) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO imap_accounts (host, port, username, password, use_ssl, use_starttls, ca_cert, insecure_skip_verify, connect_timeout_seconds, read_timeout_seconds,
+		                           retain_messages, retain_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		a.Host, a.Port, a.Username, a.Password, a.UseSSL, a.UseStartTLS, a.CACert, a.InsecureSkipVerify, a.ConnectTimeoutSeconds, a.ReadTimeoutSeconds,
+		a.RetainMessages, a.RetainDays,
+	)
+	return err
+}
+
+// SetImapRetention updates the cache limits of account id.
+func (s *Store) SetImapRetention(ctx context.Context, id string, messages, days int) error {
+	if !ValidID(id) {
+		return ErrNotFound
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE imap_accounts SET retain_messages=$2, retain_days=$3 WHERE id=$1`, id, messages, days)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ImapCacheStats returns the cached message count and their on-disk size
+// per account ID; accounts without messages are missing.
+func (s *Store) ImapCacheStats(ctx context.Context) (map[string]ImapCacheStats, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT account_id::text, COUNT(*), COALESCE(SUM(pg_column_size(m.*)), 0)
+		FROM imap_messages m
+		GROUP BY account_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]ImapCacheStats{}
+	for rows.Next() {
+		var id string
+		var st ImapCacheStats
+		if err := rows.Scan(&id, &st.Messages, &st.Bytes); err != nil {
+			return nil, err
+		}
+		out[id] = st
+	}
+	return out, rows.Err()
+}
+
+func scanImapMessage(row Row) (ImapMessage, error) {
+	var m ImapMessage
+	var msgDate sql.NullTime
+	var snippet, bodyHTML, bodyPlain sql.NullString
+	if err := row.Scan(&m.UID, &m.Subject, &m.From, &msgDate, &m.Flags, &snippet, &m.ThreadID, &bodyHTML, &bodyPlain); err != nil {
+		return m, err
+	}
+	if msgDate.Valid {
+		m.Date = &msgDate.Time
+	}
+	m.Snippet = snippet.String
+	m.BodyHTML = bodyHTML.String
+	m.BodyPlain = bodyPlain.String
+	return m, nil
+}
+
+// ImapMessages pages through an account's cached messages, newest first.
+// A UID cached under several UIDVALIDITY values is returned once, from the
+// latest. Bodies are left empty; the list only needs the snippet.
+func (s *Store) ImapMessages(ctx context.Context, accountID string, limit, offset int) ([]ImapMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uid, subject, from_addr, msg_date, flags, snippet, thread, NULL, NULL
+		FROM (`+imapLatestCopies+`) t
+		ORDER BY msg_date DESC NULLS LAST, uid DESC
+		LIMIT $2 OFFSET $3`, accountID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ImapMessage
+	for rows.Next() {
+		m, err := scanImapMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m)
+	}
+	return items, rows.Err()
+}
+
+// CountImapMessages counts an account's distinct cached UIDs.
+func (s *Store) CountImapMessages(ctx context.Context, accountID string) (int, error) {
+	var total int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT uid) FROM imap_messages WHERE account_id=$1`, accountID).Scan(&total)
+	return total, err
+}
+
+// ImapMessage returns the latest cached copy of uid.
+func (s *Store) ImapMessage(ctx context.Context, accountID string, uid uint32) (ImapMessage, error) {
+	m, err := scanImapMessage(s.db.QueryRowContext(ctx, `
+		SELECT uid, subject, from_addr, msg_date, flags, snippet, COALESCE(thread_id, 'u' || uid), body_html, body_plain
+		FROM imap_messages
+		WHERE account_id=$1 AND uid=$2
+		ORDER BY uidvalidity DESC, created_at DESC
+		LIMIT 1`, accountID, uid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ImapMessage{}, ErrNotFound
+	}
+	return m, err
+}
+
+// ImapMailboxCounts counts an account's distinct cached UIDs and those of
+// them, in their latest copy, without the \Seen flag.
+func (s *Store) ImapMailboxCounts(ctx context.Context, accountID string) (total, unseen int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE position('\Seen' IN COALESCE(flags, '')) = 0)
+		FROM (`+imapLatestCopies+`) t`, accountID).Scan(&total, &unseen)
+	return total, unseen, err
+}
+
+// ImapThreads pages through an account's conversations, the one with the
+// newest message first.
+func (s *Store) ImapThreads(ctx context.Context, accountID string, limit, offset int) ([]ImapThread, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT thread,
+		       (array_agg(subject ORDER BY msg_date ASC NULLS LAST, uid ASC))[1],
+		       (array_agg(from_addr ORDER BY msg_date DESC NULLS LAST, uid DESC))[1],
+		       (array_agg(snippet ORDER BY msg_date DESC NULLS LAST, uid DESC))[1],
+		       (array_agg(uid ORDER BY msg_date DESC NULLS LAST, uid DESC))[1],
+		       max(msg_date),
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE position('\Seen' IN COALESCE(flags, '')) = 0)
+		FROM (`+imapLatestCopies+`) t
+		GROUP BY thread
+		ORDER BY max(msg_date) DESC NULLS LAST, max(uid) DESC
+		LIMIT $2 OFFSET $3`, accountID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ImapThread
+	for rows.Next() {
+		var t ImapThread
+		var subject, from, snippet sql.NullString
+		var latest sql.NullTime
+		if err := rows.Scan(&t.ID, &subject, &from, &snippet, &t.LatestUID, &latest, &t.Count, &t.Unseen); err != nil {
+			return nil, err
+		}
+		t.Subject, t.From, t.Snippet = subject.String, from.String, snippet.String
+		if latest.Valid {
+			t.LatestDate = &latest.Time
+		}
+		items = append(items, t)
+	}
+	return items, rows.Err()
+}
+
+// CountImapThreads counts an account's conversations.
+func (s *Store) CountImapThreads(ctx context.Context, accountID string) (int, error) {
+	var total int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT thread) FROM (`+imapLatestCopies+`) t`, accountID).Scan(&total)
+	return total, err
+}
+
+// ImapThread returns the messages of a conversation, oldest first, or
+// ErrNotFound when it has none.
+func (s *Store) ImapThread(ctx context.Context, accountID, threadID string) ([]ImapMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT uid, subject, from_addr, msg_date, flags, snippet, thread, body_html, body_plain
+		FROM (`+imapLatestCopies+`) t
+		WHERE thread=$2
+		ORDER BY msg_date ASC NULLS LAST, uid ASC`, accountID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ImapMessage
+	for rows.Next() {
+		m, err := scanImapMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+	return items, nil
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// testSchema is the slice of the real schema (sql/01_init.sql plus the
+// columns the server adds at startup) that these queries touch.
+const testSchema = `
+	CREATE TABLE users (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		username TEXT UNIQUE NOT NULL,
+		password_hash TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL DEFAULT 'admin',
+		display_name TEXT NOT NULL DEFAULT '',
+		bio TEXT NOT NULL DEFAULT '',
+		avatar_url TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE archives (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		name TEXT UNIQUE NOT NULL,
+		description TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE articles (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		slug TEXT UNIQUE NOT NULL,
+		title TEXT NOT NULL,
+		body_md TEXT NOT NULL DEFAULT '',
+		body_html TEXT,
+		excerpt TEXT,
+		status TEXT NOT NULL,
+		type TEXT NOT NULL DEFAULT 'post',
+		archive_id UUID REFERENCES archives(id) ON DELETE SET NULL,
+		meta_description TEXT NOT NULL DEFAULT '',
+		tags TEXT[] NOT NULL DEFAULT '{}',
+		lang TEXT NOT NULL DEFAULT '',
+		translation_of UUID,
+		social JSONB NOT NULL DEFAULT '{}',
+		meta JSONB NOT NULL DEFAULT '{}',
+		author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+		visibility TEXT NOT NULL DEFAULT 'public',
+		password_hash TEXT NOT NULL DEFAULT '',
+		published_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE article_authors (
+		article_id UUID NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		position INT NOT NULL DEFAULT 0,
+		PRIMARY KEY (article_id, user_id)
+	);
+`
+
+// openTestStore connects to SELFECHO_TEST_DATABASE_URL inside a throwaway
+// schema. The tests are skipped when it is unset.
+func openTestStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+	raw := os.Getenv("SELFECHO_TEST_DATABASE_URL")
+	if raw == "" {
+		t.Skip("SELFECHO_TEST_DATABASE_URL not set")
+	}
+	admin, err := sql.Open("pgx", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("store_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE EXTENSION IF NOT EXISTS pgcrypto; CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema+",public")
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("pgx", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if cleanup, err := sql.Open("pgx", raw); err == nil {
+			cleanup.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			cleanup.Close()
+		}
+	})
+	if _, err := db.Exec(testSchema); err != nil {
+		t.Fatal(err)
+	}
+	return New(db, nil), db
+}
+
+func TestListArticlesDB(t *testing.T) {
+	st, db := openTestStore(t)
+	ctx := context.Background()
+	archiveID, err := st.CreateArchive(ctx, "go", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct{ slug, status, visibility, lang string }{
+		{"a", "published", "public", ""},
+		{"b", "published", "unlisted", "en"},
+		{"c", "draft", "public", ""},
+		{"d", "published", "public", "en"},
+	} {
+		_, err := db.Exec(`INSERT INTO articles (slug, title, status, visibility, lang, archive_id, tags, created_at)
+			VALUES ($1, $1, $2, $3, $4, $5, '{x}', now() - make_interval(mins => $6))`,
+			v.slug, v.status, v.visibility, v.lang, archiveID, 10-i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, total, err := st.ListArticles(ctx, ArticleFilter{Status: "published", ListedOnly: true, Page: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(items) != 1 || items[0].Slug != "d" || items[0].Archive != "go" || len(items[0].Tags) != 1 {
+		t.Fatalf("page 1: total=%d items=%+v", total, items)
+	}
+	if _, total, _ := st.ListArticles(ctx, ArticleFilter{Status: "published", ListedOnly: true, Page: 9, Limit: 1}); total != 2 {
+		t.Fatalf("past the last page total = %d", total)
+	}
+	items, _, _ = st.ListArticles(ctx, ArticleFilter{Lang: "EN"})
+	if len(items) != 2 {
+		t.Fatalf("lang filter matched %d", len(items))
+	}
+	items, _, _ = st.ListArticles(ctx, ArticleFilter{Lang: "zh-CN", LangIncludesUnset: true})
+	if len(items) != 2 {
+		t.Fatalf("default-language filter matched %d", len(items))
+	}
+	if _, err := db.Exec(`UPDATE articles SET meta = '{"mood": "calm", "stars": 4}' WHERE slug = 'a'`); err != nil {
+		t.Fatal(err)
+	}
+	items, _, _ = st.ListArticles(ctx, ArticleFilter{Meta: map[string]string{"mood": "calm", "stars": "4"}})
+	if len(items) != 1 || items[0].Slug != "a" || !strings.Contains(string(items[0].Meta), `"mood"`) {
+		t.Fatalf("meta filter: %+v", items)
+	}
+}
+
+func TestArchivesDB(t *testing.T) {
+	st, db := openTestStore(t)
+	ctx := context.Background()
+	from, _ := st.CreateArchive(ctx, "old", "")
+	to, _ := st.CreateArchive(ctx, "new", "")
+	if _, err := db.Exec(`INSERT INTO articles (slug, title, status, archive_id) VALUES ('p', 'p', 'published', $1)`, from); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.UpdateArchive(ctx, "00000000-0000-0000-0000-000000000000", "x", ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update missing = %v", err)
+	}
+	if _, err := st.DeleteArchive(ctx, from, strings.ToUpper(from)); !errors.Is(err, ErrReassignToSelf) {
+		t.Fatalf("mixed-case self reassign = %v", err)
+	}
+	if _, err := st.DeleteArchive(ctx, from, from); !errors.Is(err, ErrReassignToSelf) {
+		t.Fatalf("reassign to self = %v", err)
+	}
+	res, err := st.DeleteArchive(ctx, from, to)
+	if err != nil || res.Moved != 1 || res.Target == nil || res.Target.Name != "new" {
+		t.Fatalf("delete = %+v, %v", res, err)
+	}
+	archives, err := st.ListArchives(ctx)
+	if err != nil || len(archives) != 1 || archives[0].ItemCount != 1 {
+		t.Fatalf("archives = %+v, %v", archives, err)
+	}
+	if _, err := st.ArchiveByName(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted archive lookup = %v", err)
+	}
+}
+
+func TestAuthorsDB(t *testing.T) {
+	st, db := openTestStore(t)
+	ctx := context.Background()
+	var ann, bob string
+	if err := db.QueryRow(`INSERT INTO users (username) VALUES ('ann') RETURNING id`).Scan(&ann); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`INSERT INTO users (username) VALUES ('bob') RETURNING id`).Scan(&bob); err != nil {
+		t.Fatal(err)
+	}
+	for _, slug := range []string{"one", "two"} {
+		if _, err := db.Exec(`
+			WITH art AS (INSERT INTO articles (slug, title, status, author_id) VALUES ($1, $1, 'published', $2) RETURNING id)
+			INSERT INTO article_authors (article_id, user_id) SELECT id, $2 FROM art`, slug, ann); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO article_authors (article_id, user_id, position) SELECT id, $1, 1 FROM articles WHERE slug = 'two'`, bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.UpdateProfile(ctx, ann, Profile{DisplayName: "Ann A.", Bio: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	authors, err := st.ListAuthors(ctx)
+	if err != nil || len(authors) != 2 || authors[0].PostCount != 2 || authors[0].DisplayName != "Ann A." || authors[1].PostCount != 1 {
+		t.Fatalf("authors = %+v %v", authors, err)
+	}
+	if _, err := st.AuthorByUsername(ctx, "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing author err = %v", err)
+	}
+	items, total, _ := st.ListArticles(ctx, ArticleFilter{Author: "ann", Page: 1, Limit: 1})
+	if total != 2 || len(items) != 1 || items[0].Author != "ann" || items[0].AuthorName != "Ann A." {
+		t.Fatalf("author filter: total=%d %+v", total, items)
+	}
+	items, _, _ = st.ListArticles(ctx, ArticleFilter{Author: "bob"})
+	if len(items) != 1 || items[0].Slug != "two" || len(items[0].Authors) != 2 || items[0].Authors[1].Username != "bob" {
+		t.Fatalf("co-author filter: %+v", items)
+	}
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"0b8f3c4e-1d2a-4b5c-9d8e-7f6a5b4c3d2e": true,
+		"0B8F3C4E-1D2A-4B5C-9D8E-7F6A5B4C3D2E": true,
+		"":                                     false,
+		"42":                                   false,
+		"hello-world":                          false,
+		"0b8f3c4e-1d2a-4b5c-9d8e-7f6a5b4c3d2g": false,
+		"0b8f3c4e11d2a-4b5c-9d8e-7f6a5b4c3d2e": false,
+		"{0b8f3c4e-1d2a-4b5c-9d8e-7f6a5b4c3d2": false,
+	} {
+		if got := ValidID(id); got != want {
+			t.Errorf("ValidID(%q) = %v", id, got)
+		}
+	}
+}
+
+func TestDeleteArchiveRejectsSelf(t *testing.T) {
+	const id = "0b8f3c4e-1d2a-4b5c-9d8e-7f6a5b4c3d2e"
+	// both checks run before the store touches the database
+	st := &Store{}
+	if _, err := st.DeleteArchive(context.Background(), id, strings.ToUpper(id)); !errors.Is(err, ErrReassignToSelf) {
+		t.Fatalf("mixed-case self reassign = %v", err)
+	}
+	if _, err := st.DeleteArchive(context.Background(), id, "nope"); !errors.Is(err, ErrReassignTargetNotFound) {
+		t.Fatalf("malformed target = %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// User is an admin account. PasswordHash is the bcrypt hash.
+type User struct {
+	ID           string
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+}
+
+// Session is a login session together with its user.
+type Session struct {
+	ID      string
+	User    User
+	Expires time.Time
+	// AbsoluteExpires caps how far activity can push Expires.
+	AbsoluteExpires time.Time
+	// Remember marks a "remember me" login with a persistent cookie.
+	Remember bool
+}
+
+// UserByUsername looks a user up by exact username.
+func (s *Store) UserByUsername(ctx context.Context, username string) (User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx, `SELECT id, username, password_hash, role, created_at FROM users WHERE username=$1`, username).
+		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotFound
+	}
+	return u, err
+}
+
+// CreateUser inserts a user unless the username is taken, in which case it
+// does nothing.
+func (s *Store) CreateUser(ctx context.Context, username, passwordHash, role string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3) ON CONFLICT (username) DO NOTHING`, username, passwordHash, role)
+	return err
+}
+
+// SessionByToken loads a session and its user by the hash of its token.
+// Expired sessions are returned as well; the caller decides what to do with
+// them.
+func (s *Store) SessionByToken(ctx context.Context, tokenHash string) (Session, error) {
+	var ss Session
+	err := s.db.QueryRowContext(ctx, `
+		SELECT s.id, s.expires_at, s.absolute_expires_at, s.remember, u.id, u.username, u.password_hash, u.role, u.created_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = $1`, tokenHash).
+		Scan(&ss.ID, &ss.Expires, &ss.AbsoluteExpires, &ss.Remember, &ss.User.ID, &ss.User.Username, &ss.User.PasswordHash, &ss.User.Role, &ss.User.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrNotFound
+	}
+	return ss, err
+}
+
+// CreateSession starts a session for userID identified by tokenHash. It
+// expires after ttl without activity and after maxAge at the latest.
+func (s *Store) CreateSession(ctx context.Context, userID, tokenHash string, ttl, maxAge time.Duration, remember bool) (Session, error) {
+	var ss Session
+	err := s.db.QueryRowContext(ctx, `
+		WITH ins AS (
+			INSERT INTO sessions (user_id, token_hash, expires_at, absolute_expires_at, remember)
+			VALUES ($1, $2, now() + LEAST($3::int, $4::int) * interval '1 second', now() + $4::int * interval '1 second', $5)
+			RETURNING id, user_id, expires_at, absolute_expires_at, remember
+		)
+		SELECT ins.id, ins.expires_at, ins.absolute_expires_at, ins.remember, u.id, u.username, u.password_hash, u.role, u.created_at
+		FROM ins JOIN users u ON u.id = ins.user_id`, userID, tokenHash, int(ttl.Seconds()), int(maxAge.Seconds()), remember).
+		Scan(&ss.ID, &ss.Expires, &ss.AbsoluteExpires, &ss.Remember, &ss.User.ID, &ss.User.Username, &ss.User.PasswordHash, &ss.User.Role, &ss.User.CreatedAt)
+	return ss, err
+}
+
+// TouchSession pushes a session's expiry to ttl from now, capped at its
+// absolute expiry, and returns the new expiry.
+func (s *Store) TouchSession(ctx context.Context, id string, ttl time.Duration) (time.Time, error) {
+	var expires time.Time
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE sessions SET expires_at = LEAST(now() + $2::int * interval '1 second', absolute_expires_at), last_seen_at = now()
+		WHERE id = $1 RETURNING expires_at`, id, int(ttl.Seconds())).Scan(&expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	return expires, err
+}
+
+// DeleteSession removes a session; deleting an unknown one is not an error.
+func (s *Store) DeleteSession(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id=$1`, id)
+	return err
+}
+
+// DeleteExpiredSessions removes every session past its expiry and returns
+// how many there were.
+func (s *Store) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
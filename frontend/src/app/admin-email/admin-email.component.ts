@@ -12,9 +12,36 @@ interface ImapAccount {
   username: string;
   useSsl: boolean;
   useStartTls: boolean;
+  caCert?: string;
+  insecureSkipVerify: boolean;
+  connectTimeoutSeconds: number;
+  readTimeoutSeconds: number;
+  retainMessages: number;
+  retainDays: number;
+  cachedMessages?: number;
+  cacheBytes?: number;
   createdAt: string;
 }
 
+interface ImapServerSettings {
+  host: string;
+  port: number;
+  useSsl: boolean;
+  useStartTls: boolean;
+  note?: string;
+}
+
+interface ImapProvider extends ImapServerSettings {
+  id: string;
+  name: string;
+  domains: string[];
+}
+
+interface ImapProvidersResponse {
+  providers: ImapProvider[];
+  suggestion: (ImapServerSettings & { source: string }) | null;
+}
+
 interface ImapMessage {
   uid: number;
   subject: string;
@@ -56,6 +83,8 @@ export class AdminEmailComponent implements OnInit {
   diagnoseHost = '';
   rebuildLoading = false;
   rebuildError = '';
+  providers: ImapProvider[] = [];
+  providerNote = '';
 
   form = {
     host: '',
@@ -63,7 +92,11 @@ export class AdminEmailComponent implements OnInit {
     username: '',
     password: '',
     useSsl: true,
-    useStartTls: false
+    useStartTls: false,
+    caCert: '',
+    insecureSkipVerify: false,
+    connectTimeoutSeconds: 0,
+    readTimeoutSeconds: 0
   };
 
   constructor(private http: HttpClient) {}
@@ -87,6 +120,44 @@ export class AdminEmailComponent implements OnInit {
     });
   }
 
+  toggleForm(): void {
+    this.showForm = !this.showForm;
+    if (this.showForm && !this.providers.length) {
+      this.http.get<ImapProvidersResponse>(`${API_BASE}/imap/providers`).subscribe({
+        next: (res) => (this.providers = res?.providers || []),
+        error: () => (this.providers = [])
+      });
+    }
+  }
+
+  applyProvider(id: string): void {
+    const p = this.providers.find((x) => x.id === id);
+    if (p) this.applyServerSettings(p);
+  }
+
+  // Prefills the server from the address typed as username, unless the host
+  // was already filled in.
+  autodiscover(): void {
+    const username = this.form.username.trim();
+    if (!username.includes('@') || this.form.host) return;
+    this.http
+      .get<ImapProvidersResponse>(`${API_BASE}/imap/providers`, { params: { email: username } })
+      .subscribe({
+        next: (res) => {
+          if (res?.suggestion && !this.form.host) this.applyServerSettings(res.suggestion);
+        },
+        error: () => {}
+      });
+  }
+
+  private applyServerSettings(p: ImapServerSettings): void {
+    this.form.host = p.host;
+    this.form.port = p.port;
+    this.form.useSsl = p.useSsl;
+    this.form.useStartTls = p.useStartTls;
+    this.providerNote = p.note || '';
+  }
+
   saveAccount(): void {
     if (!this.form.host || !this.form.username || !this.form.password || this.saving) return;
     this.saving = true;
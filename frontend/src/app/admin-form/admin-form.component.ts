@@ -12,7 +12,7 @@ import { TiptapImage } from '../tiptap-image';
 import { TiptapLink } from '../tiptap-link';
 
 type Status = 'draft' | 'published';
-type ArticleType = 'post' | 'memo';
+type ArticleType = 'post' | 'memo' | 'note';
 
 interface ArticlePayload {
   title: string;
@@ -71,7 +71,7 @@ export class AdminFormComponent implements OnInit, AfterViewInit, OnDestroy {
       this.loadArticle(id);
     } else {
       const t = this.route.snapshot.queryParamMap.get('type');
-      if (t === 'post' || t === 'memo') {
+      if (t === 'post' || t === 'memo' || t === 'note') {
         this.form.type = t;
       }
     }
@@ -127,7 +127,7 @@ export class AdminFormComponent implements OnInit, AfterViewInit, OnDestroy {
 
   save() {
     this.error = '';
-    if (!this.form.title.trim()) {
+    if (!this.form.title.trim() && this.form.type !== 'note') {
       this.error = '标题必填';
       return;
     }
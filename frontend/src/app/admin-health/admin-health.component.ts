@@ -26,6 +26,15 @@ interface HealthPayload {
   cacheMisses?: number;
   cacheHitRate?: number;
   cacheTtlSeconds?: number;
+  routes?: RouteGroupStats[];
+}
+
+interface RouteGroupStats {
+  group: string;
+  requests: number;
+  errors: number;
+  p50Ms: number;
+  p95Ms: number;
 }
 
 @Component({
@@ -50,7 +59,7 @@ export class AdminHealthComponent implements OnInit {
   fetch(): void {
     this.loading = true;
     this.error = '';
-    this.http.get<HealthPayload>(`${API_BASE}/health`).subscribe({
+    this.http.get<HealthPayload>(`${API_BASE}/admin/health`).subscribe({
       next: (d) => {
         this.data = d;
         this.loading = false;
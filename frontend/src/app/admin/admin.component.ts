@@ -6,7 +6,7 @@ import { HttpClient, HttpClientModule } from '@angular/common/http';
 import { API_BASE } from '../api.config';
 
 type Status = 'draft' | 'published';
-type ArticleType = 'post' | 'memo';
+type ArticleType = 'post' | 'memo' | 'note';
 
 interface Article {
   id: string;
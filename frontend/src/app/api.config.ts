@@ -1 +1,3 @@
-export const API_BASE = '/api';
+// Resolved against <base href> so the app keeps working when the backend
+// serves it under a sub-path (basePath in config.yaml).
+export const API_BASE = new URL('api', document.baseURI).pathname;
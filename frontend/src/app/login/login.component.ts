@@ -1,10 +1,33 @@
 import { CommonModule } from '@angular/common';
-import { Component } from '@angular/core';
+import { AfterViewInit, Component, ElementRef, ViewChild } from '@angular/core';
 import { FormsModule } from '@angular/forms';
 import { Router, RouterLink } from '@angular/router';
-import { HttpClient, HttpClientModule } from '@angular/common/http';
+import { HttpClient, HttpClientModule, HttpHeaders } from '@angular/common/http';
+import { firstValueFrom } from 'rxjs';
 import { API_BASE } from '../api.config';
 
+interface ChallengeInfo {
+  provider: 'none' | 'pow' | 'turnstile' | 'hcaptcha';
+  header?: string;
+  siteKey?: string;
+  challenge?: string;
+  bits?: number;
+}
+
+const widgetScripts: Record<string, { src: string; global: string }> = {
+  turnstile: { src: 'https://challenges.cloudflare.com/turnstile/v0/api.js?render=explicit', global: 'turnstile' },
+  hcaptcha: { src: 'https://js.hcaptcha.com/1/api.js?render=explicit', global: 'hcaptcha' }
+};
+
+function leadingZeroBits(d: Uint8Array): number {
+  let n = 0;
+  for (const b of d) {
+    if (b) return n + Math.clz32(b) - 24;
+    n += 8;
+  }
+  return n;
+}
+
 @Component({
   selector: 'app-login',
   standalone: true,
@@ -12,23 +35,84 @@ import { API_BASE } from '../api.config';
   templateUrl: './login.component.html',
   styleUrls: ['./login.component.css']
 })
-export class LoginComponent {
+export class LoginComponent implements AfterViewInit {
+  @ViewChild('widget') widget?: ElementRef<HTMLDivElement>;
+
   username = '';
   password = '';
+  remember = false;
   loading = false;
   error = '';
+  challenge: ChallengeInfo = { provider: 'none' };
+  private captchaToken = '';
 
   constructor(private http: HttpClient, private router: Router) {}
 
-  submit(): void {
+  ngAfterViewInit(): void {
+    this.http.get<ChallengeInfo>(`${API_BASE}/challenge/login`).subscribe({
+      next: (info) => {
+        this.challenge = info;
+        const script = widgetScripts[info.provider];
+        if (script) this.renderWidget(script.src, script.global, info.siteKey || '');
+      },
+      error: () => {}
+    });
+  }
+
+  private renderWidget(src: string, global: string, siteKey: string): void {
+    const el = document.createElement('script');
+    el.src = src;
+    el.async = true;
+    el.onload = () => {
+      const api = (window as any)[global];
+      api?.render(this.widget?.nativeElement, {
+        sitekey: siteKey,
+        callback: (token: string) => (this.captchaToken = token)
+      });
+    };
+    document.head.appendChild(el);
+  }
+
+  // solvePow fetches a fresh puzzle; each solution is only good once
+  private async solvePow(): Promise<string> {
+    const info = await firstValueFrom(this.http.get<ChallengeInfo>(`${API_BASE}/challenge/login`));
+    const enc = new TextEncoder();
+    for (let n = 0; ; n++) {
+      const candidate = `${info.challenge}.${n}`;
+      const digest = new Uint8Array(await crypto.subtle.digest('SHA-256', enc.encode(candidate)));
+      if (leadingZeroBits(digest) >= (info.bits || 0)) return candidate;
+    }
+  }
+
+  private async challengeHeaders(): Promise<HttpHeaders> {
+    let headers = new HttpHeaders();
+    const name = this.challenge.header;
+    if (!name) return headers;
+    if (this.challenge.provider === 'pow') {
+      headers = headers.set(name, await this.solvePow());
+    } else if (this.captchaToken) {
+      headers = headers.set(name, this.captchaToken);
+    }
+    return headers;
+  }
+
+  async submit(): Promise<void> {
     if (!this.username || !this.password || this.loading) return;
     this.loading = true;
     this.error = '';
+    let headers: HttpHeaders;
+    try {
+      headers = await this.challengeHeaders();
+    } catch {
+      this.loading = false;
+      this.error = '人机验证失败，请重试';
+      return;
+    }
     this.http
       .post(
         `${API_BASE}/auth/login`,
-        { username: this.username, password: this.password },
-        { withCredentials: true }
+        { username: this.username, password: this.password, remember: this.remember },
+        { withCredentials: true, headers }
       )
       .subscribe({
         next: () => {
@@ -38,6 +122,10 @@ export class LoginComponent {
         error: (err) => {
           this.loading = false;
           this.error = err?.error?.error || '登录失败，请重试';
+          // captcha tokens are single use
+          this.captchaToken = '';
+          const api = (window as any)[widgetScripts[this.challenge.provider]?.global || ''];
+          api?.reset?.();
         }
       });
   }